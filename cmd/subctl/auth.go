@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newLoginCommand(g *globals) *cobra.Command {
+	var email, password, code string
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in and save the tokens to the config file",
+		Long: "Log in and save the tokens, and the server when --server is given, to the\n" +
+			"config file. The password is read from standard input unless --password\n" +
+			"is given. Accounts with two-factor authentication also need --code.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			if password == "" {
+				fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("reading password: %w", err)
+				}
+				password = strings.TrimRight(line, "\r\n")
+			}
+			creds := map[string]string{"email": email, "password": password}
+			if code != "" {
+				creds["code"] = code
+			}
+			resp, err := c.send("POST", "/api/auth/login", creds, "")
+			if err != nil {
+				return err
+			}
+			var t tokens
+			if err := decode(resp, &t); err != nil {
+				var e *apiError
+				if errors.As(err, &e) && e.Code == "two_factor_required" {
+					return fmt.Errorf("%w; pass --code", err)
+				}
+				return err
+			}
+			if g.server != "" {
+				c.config.Server = c.server
+			}
+			if err := c.saveTokens(t); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Logged in to", c.server, "as", email)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&email, "email", "", "account email")
+	cmd.Flags().StringVar(&password, "password", "", "account password")
+	cmd.Flags().StringVar(&code, "code", "", "two-factor or recovery code")
+	cmd.MarkFlagRequired("email")
+	return cmd
+}
+
+func newLogoutCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "End the saved session and remove its tokens from the config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			if c.config.RefreshToken != "" {
+				resp, err := c.send("POST", "/api/auth/logout", map[string]string{"refreshToken": c.config.RefreshToken}, "")
+				if err != nil {
+					return err
+				}
+				if err := decode(resp, nil); err != nil {
+					return err
+				}
+			}
+			return c.saveTokens(tokens{})
+		},
+	}
+}
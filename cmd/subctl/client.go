@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// client calls the API as the configured user.
+type client struct {
+	g      *globals
+	config config
+	server string
+	token  string
+	// saved is set when the token came from the config file, so a
+	// refreshed one is saved back to it. Tokens from --token or
+	// SUBCTL_TOKEN can't be refreshed.
+	saved bool
+	http  *http.Client
+}
+
+func (g *globals) client() (*client, error) {
+	c, err := g.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	cl := &client{g: g, config: c, server: g.serverURL(c), http: &http.Client{Timeout: 30 * time.Second}}
+	switch {
+	case g.token != "":
+		cl.token = g.token
+	case os.Getenv("SUBCTL_TOKEN") != "":
+		cl.token = os.Getenv("SUBCTL_TOKEN")
+	default:
+		cl.token, cl.saved = c.Token, true
+	}
+	return cl, nil
+}
+
+// apiError is a problem response (RFC 9457) from the API. A validation
+// error's detail already lists every invalid field.
+type apiError struct {
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (e *apiError) Error() string {
+	if e.Detail == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Detail
+}
+
+// errLoggedOut is returned when there's no usable token.
+var errLoggedOut = errors.New("not logged in; run subctl login, or pass --token")
+
+// do sends a request with body as JSON, if it isn't nil, and decodes the
+// response into out, if that isn't nil. An expired access token is
+// refreshed once with the saved refresh token.
+func (c *client) do(method, path string, body, out any) error {
+	if c.token == "" {
+		return errLoggedOut
+	}
+	resp, err := c.send(method, path, body, c.token)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.saved && c.config.RefreshToken != "" {
+		resp.Body.Close()
+		if err := c.refresh(); err != nil {
+			return err
+		}
+		if resp, err = c.send(method, path, body, c.token); err != nil {
+			return err
+		}
+	}
+	return decode(resp, out)
+}
+
+func (c *client) send(method, path string, body any, token string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.http.Do(req)
+}
+
+// decode reads a response, returning the API's error for anything but a
+// 2xx.
+func decode(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e := &apiError{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+			return fmt.Errorf("server answered %s", resp.Status)
+		}
+		return e
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// tokens is the part of the auth responses subctl keeps.
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refresh exchanges the refresh token for new tokens and saves them. Each
+// refresh token works once, so they have to be saved straight away.
+func (c *client) refresh() error {
+	resp, err := c.send("POST", "/api/auth/refresh", map[string]string{"refreshToken": c.config.RefreshToken}, "")
+	if err != nil {
+		return err
+	}
+	var t tokens
+	if err := decode(resp, &t); err != nil {
+		var e *apiError
+		if errors.As(err, &e) && e.Status == http.StatusUnauthorized {
+			return fmt.Errorf("session expired; run subctl login")
+		}
+		return err
+	}
+	return c.saveTokens(t)
+}
+
+func (c *client) saveTokens(t tokens) error {
+	c.token = t.Token
+	c.config.Token, c.config.RefreshToken = t.Token, t.RefreshToken
+	return c.g.saveConfig(c.config)
+}
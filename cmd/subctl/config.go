@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultServer is the server a fresh config talks to, the one the server
+// listens on by default.
+const defaultServer = "http://localhost:8080"
+
+// config is the config file. login saves the tokens, and the client saves
+// new ones there when it refreshes an expired access token.
+type config struct {
+	Server       string `json:"server,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".subctl.json"
+	}
+	return filepath.Join(dir, "subctl", "config.json")
+}
+
+func (g *globals) path() string {
+	if g.configPath != "" {
+		return g.configPath
+	}
+	return defaultConfigPath()
+}
+
+// loadConfig reads the config file. A missing one is an empty config.
+func (g *globals) loadConfig() (config, error) {
+	var c config
+	data, err := os.ReadFile(g.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("reading %s: %w", g.path(), err)
+	}
+	return c, nil
+}
+
+// saveConfig writes the config file, readable only by its owner since it
+// holds tokens.
+func (g *globals) saveConfig(c config) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path()), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(g.path(), append(data, '\n'), 0o600)
+}
+
+// serverURL is the server to talk to: --server, then SUBCTL_SERVER, then
+// the config file, then the default.
+func (g *globals) serverURL(c config) string {
+	for _, s := range []string{g.server, os.Getenv("SUBCTL_SERVER"), c.Server} {
+		if s != "" {
+			return strings.TrimRight(s, "/")
+		}
+	}
+	return defaultServer
+}
+
+func newConfigCommand(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or change the config file",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "view",
+		Short: "Print the config file's path and settings, with tokens hidden",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.loadConfig()
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			fmt.Fprintln(w, "config:", g.path())
+			fmt.Fprintln(w, "server:", g.serverURL(c))
+			fmt.Fprintln(w, "token:", mask(c.Token))
+			fmt.Fprintln(w, "refresh token:", mask(c.RefreshToken))
+			return nil
+		},
+	}, &cobra.Command{
+		Use:   "set server|token VALUE",
+		Short: "Set the server URL or the access token",
+		Long: "Set the server URL or the access token. Setting a token by hand, such as\n" +
+			"one from the admin API, clears the refresh token saved by login.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.loadConfig()
+			if err != nil {
+				return err
+			}
+			switch args[0] {
+			case "server":
+				c.Server = strings.TrimRight(args[1], "/")
+			case "token":
+				c.Token, c.RefreshToken = args[1], ""
+			default:
+				return fmt.Errorf("unknown setting %q (want server or token)", args[0])
+			}
+			return g.saveConfig(c)
+		},
+	})
+	return cmd
+}
+
+// mask hides all but the end of a token.
+func mask(token string) string {
+	switch {
+	case token == "":
+		return "(none)"
+	case len(token) <= 8:
+		return "********"
+	}
+	return "…" + token[len(token)-6:]
+}
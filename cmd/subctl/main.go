@@ -0,0 +1,72 @@
+// Command subctl is a command-line client for the subscription tracker's
+// HTTP API:
+//
+//	subctl login --email me@example.com
+//	subctl add --name Netflix --category Video --cost 15.99 --cycle monthly
+//	subctl list
+//	subctl stats -o json
+//
+// The server URL and tokens are kept in a config file (see subctl config),
+// and can be overridden with --server and --token or SUBCTL_SERVER and
+// SUBCTL_TOKEN.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats for --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// globals are the flags every command takes.
+type globals struct {
+	configPath string
+	server     string
+	token      string
+	output     string
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	g := &globals{}
+	root := &cobra.Command{
+		Use:           "subctl",
+		Short:         "Manage subscriptions from the command line",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if g.output != outputTable && g.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputTable, outputJSON)
+			}
+			return nil
+		},
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&g.configPath, "config", "", "config file (default "+defaultConfigPath()+")")
+	flags.StringVar(&g.server, "server", "", "server URL, overriding the config file and SUBCTL_SERVER")
+	flags.StringVar(&g.token, "token", "", "access token, overriding the config file and SUBCTL_TOKEN")
+	flags.StringVarP(&g.output, "output", "o", outputTable, "output format: table or json")
+
+	root.AddCommand(
+		newLoginCommand(g),
+		newLogoutCommand(g),
+		newListCommand(g),
+		newAddCommand(g),
+		newDeleteCommand(g),
+		newStatsCommand(g),
+		newConfigCommand(g),
+	)
+	return root
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// These tests run subctl against a fake API that answers as the server
+// does, checking the requests it gets.
+
+// fakeAPI is a server that accepts the token "valid", and exchanges the
+// refresh token "refresh" for a valid one.
+func fakeAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	authed := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer valid" {
+				problem(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("POST /api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var creds map[string]string
+		json.NewDecoder(r.Body).Decode(&creds)
+		if creds["password"] != "correct horse" {
+			problem(w, http.StatusUnauthorized, "Invalid email or password")
+			return
+		}
+		json.NewEncoder(w).Encode(tokens{Token: "valid", RefreshToken: "refresh"})
+	})
+	mux.HandleFunc("POST /api/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["refreshToken"] != "refresh" {
+			problem(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		json.NewEncoder(w).Encode(tokens{Token: "valid", RefreshToken: "refresh2"})
+	})
+	mux.HandleFunc("GET /api/subscriptions", authed(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "active" {
+			t.Errorf("list query = %s, want status=active", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"items": [{"id": 1, "name": "Netflix", "category": "Video", "cost": 15.99, "currency": "USD", "billingCycle": "monthly", "nextBilling": "2025-05-12", "status": "active"}], "total": 1, "limit": 500, "offset": 0}`))
+	}))
+	mux.HandleFunc("POST /api/subscriptions", authed(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["name"] == "" {
+			problem(w, http.StatusUnprocessableEntity, "name: is required")
+			return
+		}
+		if body["cost"] != 15.99 || body["billingCycle"] != "monthly" || body["currency"] != "EUR" {
+			t.Errorf("add sent %v", body)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 2, "name": "Netflix", "cost": 15.99, "currency": "EUR", "billingCycle": "monthly", "nextBilling": "2025-05-12"}`))
+	}))
+	mux.HandleFunc("DELETE /api/subscriptions/{id}", authed(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "2" {
+			problem(w, http.StatusNotFound, "Subscription not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("GET /api/stats", authed(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"currency": "USD", "totalMonthly": 15.99, "totalYearly": 191.88, "byCategory": [{"category": "Video", "count": 1, "monthly": 15.99, "yearly": 191.88}]}`))
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func problem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Status: status, Detail: detail})
+}
+
+// run runs subctl with args against srv, using the config file at path,
+// and returns what it printed.
+func run(t *testing.T, srv *httptest.Server, path string, args ...string) (string, error) {
+	t.Helper()
+	t.Setenv("SUBCTL_SERVER", "")
+	t.Setenv("SUBCTL_TOKEN", "")
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(append([]string{"--config", path, "--server", srv.URL}, args...))
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSubctl(t *testing.T) {
+	srv := fakeAPI(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if _, err := run(t, srv, path, "list"); !errors.Is(err, errLoggedOut) {
+		t.Fatalf("list before login: err = %v, want errLoggedOut", err)
+	}
+	if _, err := run(t, srv, path, "login", "--email", "me@example.com", "--password", "wrong"); err == nil || err.Error() != "Invalid email or password" {
+		t.Fatalf("login with a wrong password: err = %v", err)
+	}
+	if _, err := run(t, srv, path, "login", "--email", "me@example.com", "--password", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	g := &globals{configPath: path}
+	if c, err := g.loadConfig(); err != nil || c.Token != "valid" || c.RefreshToken != "refresh" || c.Server != srv.URL {
+		t.Fatalf("config after login = %+v, %v", c, err)
+	}
+
+	out, err := run(t, srv, path, "list", "--status", "active")
+	if err != nil || !strings.Contains(out, "NEXT BILLING") || !strings.Contains(out, "Netflix") || !strings.Contains(out, "15.99 USD") {
+		t.Errorf("list = %q, %v", out, err)
+	}
+	out, err = run(t, srv, path, "list", "--status", "active", "-o", "json")
+	var list []map[string]any
+	if err != nil || json.Unmarshal([]byte(out), &list) != nil || len(list) != 1 || list[0]["name"] != "Netflix" {
+		t.Errorf("list -o json = %q, %v", out, err)
+	}
+
+	out, err = run(t, srv, path, "add", "--name", "Netflix", "--cost", "15.99", "--currency", "eur", "--next", "2025-05-12")
+	if err != nil || !strings.Contains(out, "15.99 EUR") {
+		t.Errorf("add = %q, %v", out, err)
+	}
+	if _, err := run(t, srv, path, "add", "--name", "", "--cost", "15.99", "--currency", "eur"); err == nil || err.Error() != "name: is required" {
+		t.Errorf("add without a name: err = %v, want the server's detail", err)
+	}
+	if _, err := run(t, srv, path, "add", "--name", "Netflix", "--cost", "lots"); err == nil || !strings.HasPrefix(err.Error(), "--cost") {
+		t.Errorf("add with a bad cost: err = %v", err)
+	}
+
+	if out, err := run(t, srv, path, "delete", "2"); err != nil || out != "Deleted subscription 2\n" {
+		t.Errorf("delete = %q, %v", out, err)
+	}
+	var apiErr *apiError
+	if _, err := run(t, srv, path, "delete", "3"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("deleting a missing subscription: err = %v, want a 404", err)
+	}
+
+	out, err = run(t, srv, path, "stats")
+	if err != nil || !strings.Contains(out, "Monthly: 15.99 USD") || !strings.Contains(out, "Video") {
+		t.Errorf("stats = %q, %v", out, err)
+	}
+	if _, err := run(t, srv, path, "stats", "-o", "yaml"); err == nil || !strings.Contains(err.Error(), "--output") {
+		t.Errorf("stats -o yaml: err = %v", err)
+	}
+}
+
+func TestSubctlRefresh(t *testing.T) {
+	srv := fakeAPI(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	g := &globals{configPath: path}
+
+	// An expired token is refreshed, and the new tokens saved.
+	if err := g.saveConfig(config{Token: "expired", RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, srv, path, "stats"); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := g.loadConfig(); c.Token != "valid" || c.RefreshToken != "refresh2" {
+		t.Errorf("config after refresh = %+v", c)
+	}
+
+	// A refresh token that no longer works means logging in again.
+	if err := g.saveConfig(config{Token: "expired", RefreshToken: "revoked"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, srv, path, "stats"); err == nil || !strings.Contains(err.Error(), "subctl login") {
+		t.Errorf("stats with a revoked session: err = %v", err)
+	}
+
+	// Tokens given on the command line aren't refreshed.
+	if err := g.saveConfig(config{RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *apiError
+	if _, err := run(t, srv, path, "--token", "expired", "stats"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("stats with --token expired: err = %v, want a 401", err)
+	}
+}
+
+func TestSubctlConfig(t *testing.T) {
+	srv := fakeAPI(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if _, err := run(t, srv, path, "config", "set", "token", "abcdefghijklmnop"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run(t, srv, path, "config", "view")
+	if err != nil || !strings.Contains(out, "token: …klmnop") || strings.Contains(out, "abcdefghij") || !strings.Contains(out, "refresh token: (none)") {
+		t.Errorf("config view = %q, %v", out, err)
+	}
+	if _, err := run(t, srv, path, "config", "set", "colour", "blue"); err == nil || !strings.Contains(err.Error(), "unknown setting") {
+		t.Errorf("setting an unknown setting: err = %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+
+	"subscription-tracker/pkg/models"
+)
+
+// stats is the part of GET /api/stats the table shows.
+type stats struct {
+	Currency     string       `json:"currency"`
+	TotalMonthly models.Money `json:"totalMonthly"`
+	TotalYearly  models.Money `json:"totalYearly"`
+	ByCategory   []struct {
+		Category string       `json:"category"`
+		Count    int          `json:"count"`
+		Monthly  models.Money `json:"monthly"`
+		Yearly   models.Money `json:"yearly"`
+	} `json:"byCategory"`
+	Upcoming []models.Subscription `json:"upcoming"`
+}
+
+func newStatsCommand(g *globals) *cobra.Command {
+	var currency string
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show spending totals, by category, and the week's renewals",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			path := "/api/stats"
+			if currency != "" {
+				path += "?" + url.Values{"currency": {currency}}.Encode()
+			}
+			var body json.RawMessage
+			if err := c.do("GET", path, nil, &body); err != nil {
+				return err
+			}
+			if g.output == outputJSON {
+				return printJSON(cmd.OutOrStdout(), body)
+			}
+			var s stats
+			if err := json.Unmarshal(body, &s); err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			fmt.Fprintf(w, "Monthly: %s %s\nYearly:  %s %s\n\n", s.TotalMonthly, s.Currency, s.TotalYearly, s.Currency)
+			t := newTable(w)
+			fmt.Fprintln(t, "CATEGORY\tSUBSCRIPTIONS\tMONTHLY\tYEARLY")
+			for _, cat := range s.ByCategory {
+				fmt.Fprintf(t, "%s\t%d\t%s\t%s\n", cat.Category, cat.Count, cat.Monthly, cat.Yearly)
+			}
+			if err := t.Flush(); err != nil {
+				return err
+			}
+			if len(s.Upcoming) > 0 {
+				fmt.Fprintln(w, "\nRenewing in the next 7 days:")
+				t := newTable(w)
+				for _, sub := range s.Upcoming {
+					fmt.Fprintf(t, "  %s\t%s\t%s %s\n", sub.NextBilling, sub.Name, sub.Cost, sub.Currency)
+				}
+				return t.Flush()
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&currency, "currency", "", "currency to show amounts in (default your display currency)")
+	return cmd
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"subscription-tracker/pkg/models"
+)
+
+// pageSize is how many subscriptions list fetches per request, the most
+// the API returns at once.
+const pageSize = 500
+
+func newListCommand(g *globals) *cobra.Command {
+	var status, category, tag string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List subscriptions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			q := url.Values{"sort": {"name"}, "limit": {strconv.Itoa(pageSize)}}
+			for key, v := range map[string]string{"status": status, "category": category, "tag": tag} {
+				if v != "" {
+					q.Set(key, v)
+				}
+			}
+
+			var subs []json.RawMessage
+			for {
+				q.Set("offset", strconv.Itoa(len(subs)))
+				var page models.Page[json.RawMessage]
+				if err := c.do("GET", "/api/subscriptions?"+q.Encode(), nil, &page); err != nil {
+					return err
+				}
+				subs = append(subs, page.Items...)
+				if len(page.Items) == 0 || len(subs) >= page.Total {
+					break
+				}
+			}
+
+			if g.output == outputJSON {
+				data, err := json.Marshal(subs)
+				if err != nil {
+					return err
+				}
+				return printJSON(cmd.OutOrStdout(), data)
+			}
+			list := make([]models.Subscription, len(subs))
+			for i, raw := range subs {
+				if err := json.Unmarshal(raw, &list[i]); err != nil {
+					return err
+				}
+			}
+			return printSubscriptions(cmd.OutOrStdout(), list)
+		},
+	}
+	cmd.Flags().StringVar(&status, "status", "", "only subscriptions with this status: active, paused or cancelled")
+	cmd.Flags().StringVar(&category, "category", "", "only subscriptions in this category")
+	cmd.Flags().StringVar(&tag, "tag", "", "only subscriptions with this tag")
+	return cmd
+}
+
+func printSubscriptions(w io.Writer, subs []models.Subscription) error {
+	t := newTable(w)
+	fmt.Fprintln(t, "ID\tNAME\tCATEGORY\tCOST\tCYCLE\tNEXT BILLING\tSTATUS")
+	for _, s := range subs {
+		fmt.Fprintf(t, "%d\t%s\t%s\t%s %s\t%s\t%s\t%s\n", s.ID, s.Name, s.Category, s.Cost, s.Currency, s.BillingCycle, s.NextBilling, s.Status)
+	}
+	return t.Flush()
+}
+
+func newAddCommand(g *globals) *cobra.Command {
+	var name, category, cost, cycle, next, currency, description string
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a subscription",
+		Example: "  subctl add --name Netflix --cost 15.99 --cycle monthly\n" +
+			"  subctl add --name AWS --category Cloud --cost 120 --cycle yearly --next 2025-11-01",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			amount, err := models.ParseMoney(cost)
+			if err != nil {
+				return fmt.Errorf("--cost: %w", err)
+			}
+			if next == "" {
+				next = time.Now().Format(time.DateOnly)
+			}
+			body := map[string]any{
+				"name":         name,
+				"category":     category,
+				"cost":         amount,
+				"billingCycle": cycle,
+				"nextBilling":  next,
+				"description":  description,
+				"tags":         tags,
+			}
+			if currency != "" {
+				body["currency"] = strings.ToUpper(currency)
+			}
+
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			var created json.RawMessage
+			if err := c.do("POST", "/api/subscriptions", body, &created); err != nil {
+				return err
+			}
+			if g.output == outputJSON {
+				return printJSON(cmd.OutOrStdout(), created)
+			}
+			var s models.Subscription
+			if err := json.Unmarshal(created, &s); err != nil {
+				return err
+			}
+			return printSubscriptions(cmd.OutOrStdout(), []models.Subscription{s})
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&name, "name", "", "name, such as Netflix")
+	flags.StringVar(&category, "category", "Other", "category")
+	flags.StringVar(&cost, "cost", "", "cost per billing cycle, such as 15.99")
+	flags.StringVar(&cycle, "cycle", "monthly", `billing cycle: weekly, monthly, quarterly, yearly, or custom such as "every 2 weeks"`)
+	flags.StringVar(&next, "next", "", "next billing date, YYYY-MM-DD (default today)")
+	flags.StringVar(&currency, "currency", "", "ISO 4217 currency code (default the server's)")
+	flags.StringVar(&description, "description", "", "description")
+	flags.StringSliceVar(&tags, "tag", nil, "tag, repeatable")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("cost")
+	return cmd
+}
+
+func newDeleteCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete ID",
+		Short: "Delete a subscription",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid ID %q", args[0])
+			}
+			c, err := g.client()
+			if err != nil {
+				return err
+			}
+			if err := c.do("DELETE", fmt.Sprintf("/api/subscriptions/%d", id), nil, nil); err != nil {
+				return err
+			}
+			if g.output == outputTable {
+				fmt.Fprintln(cmd.OutOrStdout(), "Deleted subscription", id)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/api"
+	"subscription-tracker/pkg/clock"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+)
+
+// devServices swaps every external integration for a local fake that logs
+// what it would have done, so the full feature set runs without
+// credentials. The clock can be moved with /api/admin/clock.
+func devServices() api.Services {
+	travel := &clock.Travel{}
+	slog.Info("dev mode: using fake mail, exchange rate, bank sync and blob storage services; time travel at /api/admin/clock")
+	return api.Services{
+		Clock:    travel,
+		Mailer:   notify.LogMailer{},
+		Rates:    devRates{},
+		BankSync: devBankSync{clock: travel},
+		Blobs:    &devBlobStore{objects: map[string][]byte{}},
+	}
+}
+
+// devRates serves a fixed table of approximate rates against USD.
+type devRates struct{}
+
+var devUSDRates = map[string]float64{"USD": 1, "EUR": 0.92, "GBP": 0.79, "CAD": 1.36, "AUD": 1.52, "JPY": 155}
+
+func (devRates) Rate(_ context.Context, from, to string) (float64, error) {
+	f, ok := devUSDRates[strings.ToUpper(from)]
+	t, ok2 := devUSDRates[strings.ToUpper(to)]
+	if !ok || !ok2 {
+		return 0, fmt.Errorf("no dev rate for %s/%s", from, to)
+	}
+	return t / f, nil
+}
+
+// devBankSync returns a canned month of charges for common services, dated
+// relative to today so they line up with freshly created subscriptions.
+type devBankSync struct {
+	clock clock.Clock
+}
+
+func (b devBankSync) FetchTransactions(ctx context.Context, since time.Time) ([]models.Transaction, error) {
+	today := b.clock.Now()
+	canned := []struct {
+		description string
+		amount      models.Money
+		daysAgo     int
+	}{
+		{"NETFLIX.COM 866-579-7172 CA", -1549, 3},
+		{"SPOTIFY USA", -1099, 9},
+		{"GITHUB, INC.", -400, 14},
+		{"ACME CLOUD BACKUP", -499, 20},
+		{"ACME CLOUD BACKUP", -499, 50},
+	}
+
+	var txs []models.Transaction
+	for _, c := range canned {
+		date := today.AddDate(0, 0, -c.daysAgo)
+		if date.Before(since) {
+			continue
+		}
+		txs = append(txs, models.Transaction{
+			Source:      "bank",
+			ExternalID:  fmt.Sprintf("dev-%s-%s", strings.ToLower(strings.Fields(c.description)[0]), date.Format(time.DateOnly)),
+			Description: c.description,
+			Amount:      c.amount,
+			Date:        date.Format(time.DateOnly),
+		})
+	}
+	slog.InfoContext(ctx, "dev bank sync", "transactions", len(txs), "since", since.Format(time.DateOnly))
+	return txs, nil
+}
+
+// devBlobStore keeps objects in memory.
+type devBlobStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *devBlobStore) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.objects[key] = data
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "dev blob put", "key", key, "bytes", len(data))
+	return nil
+}
+
+func (s *devBlobStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	data, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *devBlobStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "dev blob delete", "key", key)
+	return nil
+}
+
+func (s *devBlobStore) List(_ context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+const migrateUsage = "usage: migrate up|down|status"
+
+// runMigrate implements the migrate command. The server doesn't migrate on
+// its own, so run migrate up before starting a new version; down rolls one
+// migration back, and status shows where a database stands.
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := store.MigrateUp(db)
+		for _, m := range ran {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		m, err := store.MigrateDown(db)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("no migrations to revert")
+			return nil
+		}
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		return nil
+	case "status":
+		statuses, err := store.Status(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+	return errors.New(migrateUsage)
+}
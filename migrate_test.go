@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"subscription-tracker/pkg/store"
+)
+
+// openTestDB opens an empty SQLite database that goes away with the test.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := store.Open(store.SQLite, filepath.Join(t.TempDir(), "subs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := store.CheckSchema(db); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d migrations are pending", store.SchemaVersion)) {
+		t.Errorf("CheckSchema on an empty database = %v", err)
+	}
+	if err := runMigrate(db, []string{"status"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigrate(db, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckSchema(db); err != nil {
+		t.Errorf("CheckSchema after migrate up = %v", err)
+	}
+	// Running it again has nothing left to do.
+	if err := runMigrate(db, []string{"up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runMigrate(db, []string{"down"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckSchema(db); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("migration %04d_", store.SchemaVersion)) {
+		t.Errorf("CheckSchema after migrate down = %v", err)
+	}
+
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "down"}} {
+		if err := runMigrate(db, args); err == nil || err.Error() != migrateUsage {
+			t.Errorf("migrate %q: err = %v, want the usage", args, err)
+		}
+	}
+}
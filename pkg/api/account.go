@@ -0,0 +1,436 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// exportNotification is an email the server sent the user, such as a
+// renewal reminder.
+type exportNotification struct {
+	SubscriptionID int         `json:"subscriptionId"`
+	Kind           string      `json:"kind"`
+	BillingDate    models.Date `json:"billingDate"`
+	SentAt         string      `json:"sentAt"`
+}
+
+// exportUsage is the user's requests to one endpoint in one hour.
+type exportUsage struct {
+	Hour       string `json:"hour"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	Requests   int64  `json:"requests"`
+	Errors     int64  `json:"errors"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// exportDelivery is a webhook delivery with the payload that was sent.
+type exportDelivery struct {
+	WebhookID int `json:"webhookId"`
+	models.WebhookDelivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+// accountExport is everything stored about a user, one file of the export
+// archive per field. Secrets that only prove who the user is, the password
+// and two-factor hashes, are left out.
+type accountExport struct {
+	Account       models.User
+	Data          store.Backup
+	Audit         []models.AuditEntry
+	Notifications []exportNotification
+	Sessions      []models.Session
+	Usage         []exportUsage
+	Household     *models.Household
+	Invites       []models.HouseholdInvite
+	Deliveries    []exportDelivery
+}
+
+// files names the archive's files, in the order they're written.
+func (e accountExport) files() []struct {
+	name string
+	data any
+} {
+	return []struct {
+		name string
+		data any
+	}{
+		{"account.json", e.Account},
+		{"data.json", e.Data},
+		{"audit.json", e.Audit},
+		{"notifications.json", e.Notifications},
+		{"sessions.json", e.Sessions},
+		{"usage.json", e.Usage},
+		{"household.json", e.Household},
+		{"invites.json", e.Invites},
+		{"webhook-deliveries.json", e.Deliveries},
+	}
+}
+
+// readAccountExport collects everything stored about the user.
+func (a *App) readAccountExport(ctx context.Context, userID int) (accountExport, error) {
+	var e accountExport
+	var err error
+	if e.Account, err = a.account(ctx, userID); err != nil {
+		return e, err
+	}
+	if e.Data, err = store.ReadBackup(ctx, a.db, userID, a.clock.Now()); err != nil {
+		return e, err
+	}
+	if e.Sessions, err = a.userSessions(ctx, userID, 0); err != nil {
+		return e, err
+	}
+	if e.Invites, err = a.householdInvites(ctx, inviteForUser, userID); err != nil {
+		return e, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT l.id, l.subscription_id, l.action, l.actor_id, u.email, l.changes, l.created_at
+		FROM audit_log l LEFT JOIN users u ON u.id = l.actor_id
+		WHERE l.user_id = $1 ORDER BY l.id
+	`, userID)
+	if err != nil {
+		return e, err
+	}
+	if e.Audit, err = scanAuditEntries(rows); err != nil {
+		return e, err
+	}
+
+	e.Notifications = []exportNotification{}
+	err = eachRow(ctx, a.db, "SELECT subscription_id, kind, billing_date, sent_at FROM notifications WHERE user_id = $1 ORDER BY id", userID, func(rows *sql.Rows) error {
+		var n exportNotification
+		var sentAt time.Time
+		if err := rows.Scan(&n.SubscriptionID, &n.Kind, &n.BillingDate, &sentAt); err != nil {
+			return err
+		}
+		n.SentAt = sentAt.UTC().Format(time.RFC3339)
+		e.Notifications = append(e.Notifications, n)
+		return nil
+	})
+	if err != nil {
+		return e, err
+	}
+
+	e.Usage = []exportUsage{}
+	err = eachRow(ctx, a.db, "SELECT hour, method, route, requests, errors, duration_ms FROM api_usage WHERE user_id = $1 ORDER BY hour, route, method", userID, func(rows *sql.Rows) error {
+		var u exportUsage
+		var hour time.Time
+		if err := rows.Scan(&hour, &u.Method, &u.Route, &u.Requests, &u.Errors, &u.DurationMs); err != nil {
+			return err
+		}
+		u.Hour = hour.UTC().Format(time.RFC3339)
+		e.Usage = append(e.Usage, u)
+		return nil
+	})
+	if err != nil {
+		return e, err
+	}
+
+	e.Deliveries = []exportDelivery{}
+	err = eachRow(ctx, a.db, `
+		SELECT d.webhook_id, d.id, d.event, d.status, d.attempts, d.response_status, d.last_error, d.created_at, d.delivered_at, d.payload
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE w.user_id = $1 ORDER BY d.id
+	`, userID, func(rows *sql.Rows) error {
+		var d exportDelivery
+		var responseStatus sql.NullInt64
+		var lastError sql.NullString
+		var createdAt time.Time
+		var deliveredAt sql.NullTime
+		var payload string
+		if err := rows.Scan(&d.WebhookID, &d.ID, &d.Event, &d.Status, &d.Attempts, &responseStatus, &lastError, &createdAt, &deliveredAt, &payload); err != nil {
+			return err
+		}
+		if responseStatus.Valid {
+			status := int(responseStatus.Int64)
+			d.ResponseStatus = &status
+		}
+		if lastError.Valid {
+			d.Error = &lastError.String
+		}
+		d.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		if deliveredAt.Valid {
+			at := deliveredAt.Time.UTC().Format(time.RFC3339)
+			d.DeliveredAt = &at
+		}
+		d.Payload = json.RawMessage(payload)
+		e.Deliveries = append(e.Deliveries, d)
+		return nil
+	})
+	if err != nil {
+		return e, err
+	}
+
+	var household int
+	err = a.db.QueryRowContext(ctx, "SELECT household_id FROM household_members WHERE user_id = $1", userID).Scan(&household)
+	if err == nil {
+		var h models.Household
+		if h, err = a.household(ctx, household); err == nil {
+			e.Household = &h
+		}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return e, err
+	}
+	return e, nil
+}
+
+// eachRow runs query with the single argument arg and calls fn for each
+// row.
+func eachRow(ctx context.Context, db *sql.DB, query string, arg any, fn func(*sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportAccount downloads everything stored about the user as a zip of
+// JSON files, for the right of access and data portability under the
+// GDPR. data.json is the same document as GET /api/backup, so it can be
+// restored here or on another instance.
+func (a *App) exportAccount(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Exports need a database")
+		return
+	}
+	e, err := a.readAccountExport(r.Context(), userID(r))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	startDownload(w, "application/zip", fmt.Sprintf("account-export-%s.zip", a.clock.Now().Format(dateLayout)))
+	w.Header().Set("Cache-Control", "no-store")
+	archive := zip.NewWriter(w)
+	modified := a.clock.Now()
+	for _, f := range e.files() {
+		fw, err := archive.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			enc := json.NewEncoder(fw)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(f.data)
+		}
+		if err != nil {
+			a.logger.ErrorContext(r.Context(), "account export cut short", "err", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		a.logger.ErrorContext(r.Context(), "account export cut short", "err", err)
+	}
+}
+
+// deleteAccountRequest confirms a deletion with the account's password
+// and, if two-factor authentication is on, a code.
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// deleteAccount schedules the user's account to be erased once
+// AccountDeletionGrace has passed, or erases it at once without a grace
+// period. Every session is revoked; logging in again before the deadline
+// cancels the deletion. Asking again keeps the first deadline.
+func (a *App) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	ctx := r.Context()
+	uid := userID(r)
+	var hash string
+	if err := a.db.QueryRowContext(ctx, "SELECT password_hash FROM users WHERE id = $1", uid).Scan(&hash); err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		writeValidationErrors(w, fieldErrors{{"password", "is incorrect"}})
+		return
+	}
+	state, err := a.twoFactorState(ctx, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if state.enabled {
+		if strings.TrimSpace(req.Code) == "" {
+			writeValidationErrors(w, fieldErrors{{"code", "is required with two-factor authentication on"}})
+			return
+		}
+		ok, err := a.checkSecondFactor(ctx, uid, state, req.Code)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if !ok {
+			writeValidationErrors(w, fieldErrors{{"code", "is incorrect or has expired"}})
+			return
+		}
+	}
+
+	if a.config.AccountDeletionGrace <= 0 {
+		if _, err := a.eraseAccount(ctx, uid, nil); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		a.logger.InfoContext(ctx, "account erased", "user", uid)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err = store.InTx(ctx, a.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET delete_after = COALESCE(delete_after, $1) WHERE id = $2", a.dbNow().Add(a.config.AccountDeletionGrace), uid); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", uid)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.logger.InfoContext(ctx, "account deletion scheduled", "user", uid)
+	a.writeMe(w, r, http.StatusAccepted)
+}
+
+// cancelAccountDeletion clears a pending deletion when the user logs in.
+func (a *App) cancelAccountDeletion(ctx context.Context, userID int) error {
+	res, err := a.db.ExecContext(ctx, "UPDATE users SET delete_after = NULL WHERE id = $1 AND delete_after IS NOT NULL", userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		a.logger.InfoContext(ctx, "account deletion cancelled", "user", userID)
+	}
+	return nil
+}
+
+// eraseAccount deletes the user and everything stored about them,
+// attached files included. The households they own are disbanded, invites
+// to their email are withdrawn, and cost splits naming them go back to
+// equal splits. Audit entries they made in others' logs are kept without
+// them as the actor.
+//
+// With dueBy set, the account is only erased if its deletion is due by
+// then, so one whose user has logged in since is kept; it reports whether
+// the account was erased.
+func (a *App) eraseAccount(ctx context.Context, userID int, dueBy *time.Time) (bool, error) {
+	erased := false
+	err := store.InTx(ctx, a.db, func(tx *sql.Tx) error {
+		if dueBy != nil {
+			res, err := tx.ExecContext(ctx, "UPDATE users SET delete_after = delete_after WHERE id = $1 AND delete_after <= $2", userID, *dueBy)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return nil
+			}
+		}
+		for _, q := range []struct {
+			query string
+			args  []any
+		}{
+			{"DELETE FROM household_invites WHERE id IN (SELECT i.id FROM household_invites i JOIN households h ON h.id = i.household_id WHERE " + inviteForUser + ")", []any{userID}},
+			{"DELETE FROM households WHERE id IN (SELECT household_id FROM household_members WHERE user_id = $1 AND role = $2)", []any{userID, models.RoleOwner}},
+			{"DELETE FROM household_splits WHERE subscription_id IN (SELECT subscription_id FROM household_splits WHERE user_id = $1)", []any{userID}},
+			{"DELETE FROM users WHERE id = $1", []any{userID}},
+		} {
+			if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+				return err
+			}
+		}
+		erased = true
+		return nil
+	})
+	if err != nil || !erased {
+		return false, err
+	}
+	a.invalidateStats(ctx, userID)
+	a.purgeAttachments(ctx, userID, 0)
+	return true, nil
+}
+
+// StartAccountDeletion erases the accounts whose deletion grace period is
+// over, now and then every AccountDeletionInterval, until ctx is
+// cancelled.
+func (a *App) StartAccountDeletion(ctx context.Context) {
+	if a.config.AccountDeletionInterval <= 0 || a.db == nil {
+		return
+	}
+
+	a.jobs.started("account_deletion", a.config.AccountDeletionInterval)
+	go func() {
+		defer a.jobs.stopped("account_deletion")
+		ticker := time.NewTicker(a.config.AccountDeletionInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.eraseDueAccounts(ctx); err != nil {
+				a.logger.Warn("erasing deleted accounts", "err", err)
+			} else if n > 0 {
+				a.logger.Info("erased deleted accounts", "count", n)
+			}
+			a.jobs.ran("account_deletion")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// eraseDueAccounts erases every account whose deletion deadline has
+// passed and returns how many it erased.
+func (a *App) eraseDueAccounts(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	now := a.dbNow()
+	var due []int
+	err := eachRow(ctx, a.db, "SELECT id FROM users WHERE delete_after <= $1 ORDER BY id", now, func(rows *sql.Rows) error {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		due = append(due, id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	erased := 0
+	for _, id := range due {
+		ok, err := a.eraseAccount(ctx, id, &now)
+		if err != nil {
+			return erased, fmt.Errorf("user %d: %w", id, err)
+		}
+		if ok {
+			erased++
+		}
+	}
+	return erased, nil
+}
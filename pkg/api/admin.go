@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+func (a *App) isAdminRequest(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && a.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.config.AdminToken)) == 1
+}
+
+// isAdminUser reports whether an account with the given grant is an
+// admin. The role is only granted explicitly, through PUT
+// /api/admin/users/{id}/admin, since sign-up doesn't verify emails. The
+// admin API covers the whole instance, so only the default tenant's
+// accounts can hold it.
+func isAdminUser(granted bool, tenant int) bool {
+	return granted && tenant == defaultTenant
+}
+
+// adminMiddleware rejects requests without the admin bearer token or an
+// access token for an admin user. An admin user's ID goes in the request
+// context, so handlers can tell who acted; with the admin token it's 0.
+// The admin API covers every account, so its queries run as the system
+// role.
+func (a *App) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(store.AsSystem(r.Context()))
+		if a.isAdminRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin access required")
+			return
+		}
+		id, _, _, err := a.parseToken(token)
+		if err != nil {
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin access required")
+			return
+		}
+		var granted bool
+		var tenant int
+		err = a.db.QueryRowContext(r.Context(), "SELECT is_admin, tenant_id FROM users WHERE id = $1", id).Scan(&granted, &tenant)
+		if err != nil && err != sql.ErrNoRows {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if !isAdminUser(granted, tenant) {
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin access required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey, id)))
+	})
+}
+
+// MaintenanceState is the current maintenance mode setting.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	Since   string `json:"since,omitempty"`
+}
+
+const defaultMaintenanceMessage = "The service is undergoing maintenance. Please try again shortly."
+
+type maintenanceState struct {
+	sync.RWMutex
+	state MaintenanceState
+}
+
+// maintenanceExempt lists paths that keep working during maintenance so
+// orchestrators and operators can still see and fix the service.
+var maintenanceExempt = []string{"/livez", "/readyz", "/api/health", "/api/dbcheck", "/api/status", "/api/version", "/api/admin/"}
+
+// maintenanceMiddleware answers 503 to everything except exempt paths and
+// admin-authenticated requests while maintenance mode is on.
+func (a *App) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.maintenance.RLock()
+		state := a.maintenance.state
+		a.maintenance.RUnlock()
+
+		if !state.Enabled || a.isAdminRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range maintenanceExempt {
+			if r.URL.Path == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Retry-After", "300")
+		writeProblem(w, problem{
+			Status: http.StatusServiceUnavailable,
+			Code:   codeMaintenance,
+			Detail: state.Message,
+			Extra:  map[string]any{"since": state.Since},
+		})
+	})
+}
+
+func (a *App) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	a.maintenance.RLock()
+	state := a.maintenance.state
+	a.maintenance.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// setMaintenance turns maintenance mode on or off. An empty message falls
+// back to the default.
+func (a *App) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceState
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.Message == "" {
+		req.Message = defaultMaintenanceMessage
+	}
+
+	a.maintenance.Lock()
+	if req.Enabled && !a.maintenance.state.Enabled {
+		req.Since = time.Now().Format(time.RFC3339)
+	} else if req.Enabled {
+		req.Since = a.maintenance.state.Since
+	} else {
+		req.Since = ""
+	}
+	a.maintenance.state = req
+	a.maintenance.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// ReadOnlyState is the current read-only mode setting.
+type ReadOnlyState struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+	Since   string `json:"since,omitempty"`
+}
+
+type readOnlyState struct {
+	sync.RWMutex
+	state ReadOnlyState
+}
+
+// readOnlyMiddleware rejects mutations with 503 while read-only mode is on.
+// Reads keep working, and so does the admin API so the mode can be lifted.
+// GraphQL reads are POSTs, so graphQLReadOnly checks its operations instead.
+func (a *App) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.readOnly.RLock()
+		state := a.readOnly.state
+		a.readOnly.RUnlock()
+
+		switch {
+		case !state.Enabled,
+			r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions,
+			strings.HasPrefix(r.URL.Path, "/api/admin/"), r.URL.Path == "/api/graphql":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", "300")
+		writeProblem(w, problem{
+			Status: http.StatusServiceUnavailable,
+			Code:   codeReadOnly,
+			Detail: "The service is temporarily read-only; changes are not being accepted.",
+			Extra:  map[string]any{"reason": state.Reason, "since": state.Since},
+		})
+	})
+}
+
+func (a *App) getReadOnly(w http.ResponseWriter, r *http.Request) {
+	a.readOnly.RLock()
+	state := a.readOnly.state
+	a.readOnly.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// setReadOnly turns read-only mode on or off.
+func (a *App) setReadOnly(w http.ResponseWriter, r *http.Request) {
+	var req ReadOnlyState
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	a.readOnly.Lock()
+	if req.Enabled && !a.readOnly.state.Enabled {
+		req.Since = time.Now().Format(time.RFC3339)
+	} else if req.Enabled {
+		req.Since = a.readOnly.state.Since
+	} else {
+		req.Since = ""
+	}
+	a.readOnly.state = req
+	a.readOnly.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+)
+
+// raiseAlert records an alert for the user and notifies them. dedupeKey
+// identifies the underlying problem so that re-imports don't alert twice.
+// An App without a database keeps no alerts.
+func (a *App) raiseAlert(ctx context.Context, userID int, alert models.Alert, dedupeKey string) error {
+	if a.db == nil {
+		return nil
+	}
+	err := a.db.QueryRowContext(ctx, `
+		INSERT INTO alerts (user_id, kind, message, subscription_id, transaction_id, dedupe_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, dedupe_key) DO NOTHING
+		RETURNING id
+	`, userID, alert.Kind, alert.Message, alert.SubscriptionID, alert.TransactionID, dedupeKey).Scan(&alert.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := a.notifier.Notify(alert); err != nil {
+		a.logger.ErrorContext(ctx, "sending alert", "alert", alert.ID, "err", err)
+	}
+	a.pushAlert(ctx, userID, alert)
+	return nil
+}
+
+// checkUnexpectedCharge raises an alert when a matched charge doesn't agree
+// with the recorded cost, or when an unmatched charge looks recurring.
+func (a *App) checkUnexpectedCharge(ctx context.Context, userID int, t models.Transaction, subs []matchableSubscription) error {
+	if t.SubscriptionID != nil {
+		for _, s := range subs {
+			if s.ID == *t.SubscriptionID {
+				return a.checkChargeAmount(ctx, userID, t, s)
+			}
+		}
+		return nil
+	}
+	if t.MatchStatus == models.MatchStatusUnmatched {
+		return a.checkRecurringCharge(ctx, userID, t)
+	}
+	return nil
+}
+
+func (a *App) checkChargeAmount(ctx context.Context, userID int, t models.Transaction, s matchableSubscription) error {
+	amount := t.Amount.Abs()
+	if (amount - s.Cost).Abs() <= amountTolerance {
+		return nil
+	}
+	return a.raiseAlert(ctx, userID, models.Alert{
+		Kind:           models.AlertChargeAmountMismatch,
+		Message:        fmt.Sprintf("%s charged %s on %s, but the recorded cost is %s", s.Name, amount, t.Date, s.Cost),
+		SubscriptionID: &s.ID,
+		TransactionID:  &t.ID,
+	}, fmt.Sprintf("%s:%d", models.AlertChargeAmountMismatch, t.ID))
+}
+
+// checkRecurringCharge looks for earlier unmatched charges from the same
+// merchant for a similar amount at a regular interval.
+func (a *App) checkRecurringCharge(ctx context.Context, userID int, t models.Transaction) error {
+	merchant := normalizeMerchant(t.Description)
+	if merchant == "" {
+		return nil
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT description, amount_cents, posted_on
+		FROM transactions
+		WHERE user_id = $1 AND match_status = $2 AND id <> $3
+	`, userID, models.MatchStatusUnmatched, t.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	posted, err := time.Parse(dateLayout, t.Date)
+	if err != nil {
+		return err
+	}
+	dates := []time.Time{posted}
+	for rows.Next() {
+		var description string
+		var amount models.Money
+		var d time.Time
+		if err := rows.Scan(&description, &amount, &d); err != nil {
+			return err
+		}
+		if normalizeMerchant(description) == merchant && amountSimilarity(amount.Abs(), t.Amount.Abs()) >= 0.75 {
+			dates = append(dates, d)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !looksRecurring(dates) {
+		return nil
+	}
+
+	return a.raiseAlert(ctx, userID, models.Alert{
+		Kind:          models.AlertUnknownRecurringCharge,
+		Message:       fmt.Sprintf("Recurring charge %q of %s doesn't match any tracked subscription", t.Description, t.Amount.Abs()),
+		TransactionID: &t.ID,
+	}, fmt.Sprintf("%s:%s", models.AlertUnknownRecurringCharge, merchant))
+}
+
+// looksRecurring reports whether the dates contain at least two charges
+// spaced at a common billing interval.
+func looksRecurring(dates []time.Time) bool {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	for i := 1; i < len(dates); i++ {
+		gap := absDays(dates[i].Sub(dates[i-1]))
+		for _, cycle := range []string{"weekly", "monthly", "quarterly", "yearly"} {
+			if math.Abs(float64(gap-cycleDays(cycle))) <= 4 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getAlerts returns the alerts feed, newest first. Dismissed alerts are
+// hidden unless ?all=true.
+func (a *App) getAlerts(w http.ResponseWriter, r *http.Request) {
+	uid := userID(r)
+	if err := a.raiseStaleAlerts(r.Context(), uid); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	query := `
+		SELECT id, kind, message, subscription_id, transaction_id, created_at, dismissed
+		FROM alerts
+		WHERE user_id = $1
+	`
+	if r.URL.Query().Get("all") != "true" {
+		query += " AND NOT dismissed"
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	rows, err := a.db.QueryContext(r.Context(), query, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.Alert{}
+	for rows.Next() {
+		var alert models.Alert
+		var subscriptionID, transactionID sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&alert.ID, &alert.Kind, &alert.Message, &subscriptionID, &transactionID, &createdAt, &alert.Dismissed); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Row scan error: %v", err))
+			return
+		}
+		alert.SubscriptionID = nullableInt(subscriptionID)
+		alert.TransactionID = nullableInt(transactionID)
+		alert.CreatedAt = createdAt.Format(time.RFC3339)
+		alerts = append(alerts, alert)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(alerts); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// dismissAlert hides an alert from the default feed.
+func (a *App) dismissAlert(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	result, err := a.db.ExecContext(r.Context(), "UPDATE alerts SET dismissed = TRUE WHERE id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Alert not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	id := int(n.Int64)
+	return &id
+}
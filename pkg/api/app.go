@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/blobs"
+	"subscription-tracker/pkg/cache"
+	"subscription-tracker/pkg/catalog"
+	"subscription-tracker/pkg/clock"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/store"
+)
+
+// Services are the external dependencies an App talks to. Any left nil fall
+// back to the default: SQL storage on db, the real clock, log notifications,
+// stats cached in memory (or in Redis when Config.RedisURL is set), blobs
+// in S3 when Config.S3Bucket is set or in Config.BlobDir, and the
+// unconfigured stub for everything else.
+type Services struct {
+	Subscriptions store.SubscriptionRepository
+
+	Clock    clock.Clock
+	Notifier notify.Notifier
+	Mailer   notify.Mailer
+	Rates    RateProvider
+	BankSync BankSync
+	Blobs    BlobStore
+	Stats    StatsCache
+	// Logger gets everything the App logs; it defaults to slog.Default()
+	// as it is when New is called.
+	Logger *slog.Logger
+}
+
+// App is the subscription-tracking engine: its settings, storage, external
+// services and runtime state. Handlers are methods on it, so nothing
+// depends on package-level variables and several Apps can run side by side.
+type App struct {
+	config Config
+	db     *sql.DB
+	// stmts runs the queries every stats request makes as prepared
+	// statements.
+	stmts *store.Statements
+
+	subscriptions store.SubscriptionRepository
+
+	clock    clock.Clock
+	logger   *slog.Logger
+	notifier notify.Notifier
+	// channels push alerts to the users who turned them on, by channel
+	// name. webpush is only there when the VAPID keys are set.
+	channels map[string]notify.Channel
+	mailer   notify.Mailer
+	rates    RateProvider
+	bankSync BankSync
+	blobs    BlobStore
+	stats    StatsCache
+	// catalog is the service catalog, swapped whole when it's reloaded.
+	catalog atomic.Pointer[catalog.Catalog]
+
+	// backupRunning is held while a backup runs, so the scheduled and
+	// on-demand ones don't overlap.
+	backupRunning sync.Mutex
+
+	// webhookClient sends webhook deliveries; webhookWake tells the
+	// delivery job there's something new to send.
+	webhookClient *http.Client
+	webhookWake   chan struct{}
+
+	// quotaUsage reports current usage per quota. Features register a
+	// counter here when they start storing the resource; until then usage
+	// is zero.
+	quotaUsage map[string]func(ctx context.Context, userID int) (int64, error)
+
+	maintenance  *maintenanceState
+	readOnly     *readOnlyState
+	integrations *integrationRegistry
+	features     *featureCounters
+	events       *eventBus
+	jobs         *jobMonitor
+	usage        usageBuffer
+}
+
+// New builds an App on an initialized database (see store.Init). It fails
+// only if cfg has no JWTSecret and a random one can't be generated.
+func New(cfg Config, db *sql.DB, svc Services) (*App, error) {
+	a := &App{
+		config:        cfg,
+		db:            db,
+		subscriptions: svc.Subscriptions,
+		clock:         svc.Clock,
+		logger:        svc.Logger,
+		notifier:      svc.Notifier,
+		mailer:        svc.Mailer,
+		rates:         svc.Rates,
+		bankSync:      svc.BankSync,
+		blobs:         svc.Blobs,
+		stats:         svc.Stats,
+		webhookClient: &http.Client{Timeout: webhookTimeout},
+		webhookWake:   make(chan struct{}, 1),
+		maintenance:   &maintenanceState{state: MaintenanceState{Enabled: cfg.Maintenance, Message: defaultMaintenanceMessage}},
+		readOnly:      &readOnlyState{state: ReadOnlyState{Enabled: cfg.ReadOnly}},
+		integrations:  newIntegrationRegistry(),
+		features:      &featureCounters{counts: map[string]int{}},
+		events:        newEventBus(),
+		jobs:          newJobMonitor(),
+	}
+	if a.logger == nil {
+		a.logger = slog.Default()
+	}
+	if a.config.JWTSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generating a JWT secret: %w", err)
+		}
+		a.config.JWTSecret = hex.EncodeToString(secret)
+		a.logger.Warn("JWT_SECRET is not set; using a random secret, so sessions end when the server restarts")
+	}
+	if db != nil {
+		a.stmts = store.NewStatements(db)
+	}
+	if a.subscriptions == nil {
+		a.subscriptions = store.NewSQLSubscriptions(db)
+	}
+	if a.clock == nil {
+		a.clock = clock.Real{}
+	}
+	if a.notifier == nil {
+		a.notifier = notify.LogNotifier{}
+	}
+	pushClient := &http.Client{Timeout: pushTimeout}
+	a.channels = map[string]notify.Channel{
+		notify.ChannelNtfy:    notify.Ntfy{Client: pushClient},
+		notify.ChannelGotify:  notify.Gotify{Client: pushClient},
+		notify.ChannelSlack:   notify.Slack{Client: pushClient},
+		notify.ChannelDiscord: notify.Discord{Client: pushClient},
+	}
+	if cfg.VAPIDPublicKey != "" {
+		a.channels[notify.ChannelWebPush] = notify.WebPush{PublicKey: cfg.VAPIDPublicKey, PrivateKey: cfg.VAPIDPrivateKey, Subject: cfg.VAPIDSubject, Client: pushClient}
+	}
+	if a.mailer == nil && cfg.SMTPHost != "" {
+		a.mailer = notify.SMTPMailer{Host: cfg.SMTPHost, Port: cfg.SMTPPort, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.SMTPFrom}
+	}
+	if a.mailer == nil {
+		a.mailer = unconfigured{}
+	}
+	if a.rates == nil && cfg.ExchangeRatesProvider != "" {
+		a.rates = a.rateProvider()
+	}
+	if a.rates == nil {
+		a.rates = unconfigured{}
+	}
+	if a.bankSync == nil {
+		a.bankSync = unconfigured{}
+	}
+	if a.blobs == nil && cfg.S3Bucket != "" {
+		if s3, err := blobs.NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKeyID, cfg.S3SecretAccessKey); err != nil {
+			a.logger.Error("blob storage is off", "err", err)
+		} else {
+			a.blobs = s3
+		}
+	}
+	if a.blobs == nil && cfg.BlobDir != "" {
+		if dir, err := blobs.NewDir(cfg.BlobDir); err != nil {
+			a.logger.Error("blob storage is off", "err", err)
+		} else {
+			a.blobs = dir
+		}
+	}
+	if a.blobs == nil {
+		a.blobs = unconfigured{}
+	}
+	if a.stats == nil && cfg.RedisURL != "" && cfg.StatsCacheTTL > 0 {
+		if c, err := cache.NewRedis(cfg.RedisURL, "stats", cfg.StatsCacheTTL); err != nil {
+			a.logger.Error("caching stats in memory instead of Redis", "err", err)
+		} else {
+			a.stats = c
+		}
+	}
+	if a.stats == nil {
+		a.stats = cache.NewMemory()
+	}
+	if c, err := a.loadCatalog(); err != nil {
+		a.logger.Error("using the built-in catalog only", "err", err)
+		a.catalog.Store(catalog.Embedded())
+	} else {
+		a.catalog.Store(c)
+	}
+
+	a.quotaUsage = map[string]func(ctx context.Context, userID int) (int64, error){
+		QuotaSubscriptions:    a.countSubscriptions,
+		QuotaAttachmentBytes:  a.countAttachmentBytes,
+		QuotaWebhookEndpoints: a.countWebhooks,
+	}
+
+	a.integrations.register("smtp", cfg.SMTPHost != "" || svc.Mailer != nil)
+	a.integrations.register("web_push", cfg.VAPIDPublicKey != "")
+	a.integrations.register("exchange_rates", cfg.ExchangeRatesProvider != "" || svc.Rates != nil)
+	a.integrations.register("stripe", true)
+	a.integrations.register("plaid", cfg.PlaidClientID != "" || svc.BankSync != nil)
+	a.integrations.register("s3", cfg.S3Bucket != "" || svc.Blobs != nil)
+	a.integrations.register("redis", cfg.RedisURL != "")
+	a.integrations.register("telemetry", cfg.telemetryActive())
+	return a, nil
+}
+
+// Router returns a new router serving every API route under /api, the
+// /livez and /readyz probes and the /share pages. Callers can mount it
+// inside a larger server or add their own routes to it.
+func (a *App) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = problemHandler(http.StatusNotFound)
+	r.MethodNotAllowedHandler = problemHandler(http.StatusMethodNotAllowed)
+	r.Use(a.traceMiddleware)
+	r.Use(a.requestLogMiddleware)
+	r.Use(problemMiddleware)
+	r.Use(a.telemetryMiddleware)
+	r.Use(a.maintenanceMiddleware)
+	r.Use(a.readOnlyMiddleware)
+
+	r.HandleFunc("/livez", getLive).Methods("GET")
+	r.HandleFunc("/readyz", a.getReady).Methods("GET")
+	r.HandleFunc("/api/health", healthCheck).Methods("GET")
+	r.HandleFunc("/api/dbcheck", a.dbCheck).Methods("GET")
+	r.HandleFunc("/api/status", a.getStatus).Methods("GET")
+	r.HandleFunc("/api/version", a.getVersion).Methods("GET")
+	r.HandleFunc("/api/openapi.json", a.getOpenAPISpec).Methods("GET")
+	r.HandleFunc("/api/docs", getDocs).Methods("GET")
+
+	r.HandleFunc("/api/auth/signup", a.signup).Methods("POST")
+	r.HandleFunc("/api/auth/login", a.login).Methods("POST")
+	r.HandleFunc("/api/auth/refresh", a.refreshSession).Methods("POST")
+	r.HandleFunc("/api/auth/logout", a.logout).Methods("POST")
+
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(a.adminMiddleware)
+	admin.HandleFunc("/maintenance", a.getMaintenance).Methods("GET")
+	admin.HandleFunc("/maintenance", a.setMaintenance).Methods("PUT")
+	admin.HandleFunc("/read-only", a.getReadOnly).Methods("GET")
+	admin.HandleFunc("/read-only", a.setReadOnly).Methods("PUT")
+	admin.HandleFunc("/db-pool", a.getPool).Methods("GET")
+	admin.HandleFunc("/usage", a.getAdminUsage).Methods("GET")
+	admin.HandleFunc("/dashboard", a.getDashboard).Methods("GET")
+	admin.HandleFunc("/users", a.getAdminUsers).Methods("GET")
+	admin.HandleFunc("/users/{id}", a.getAdminUser).Methods("GET")
+	admin.HandleFunc("/users/{id}/impersonate", a.impersonateUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/admin", a.setAdminRole).Methods("PUT")
+	admin.HandleFunc("/audit", a.getAdminAudit).Methods("GET")
+	admin.HandleFunc("/backups", a.getAdminBackups).Methods("GET")
+	admin.HandleFunc("/backups", a.createAdminBackup).Methods("POST")
+	admin.HandleFunc("/catalog/reload", a.reloadCatalog).Methods("POST")
+	admin.HandleFunc("/tenants", a.getTenants).Methods("GET")
+	admin.HandleFunc("/tenants", a.createTenant).Methods("POST")
+	admin.HandleFunc("/tenants/{id}", a.deleteTenant).Methods("DELETE")
+	if travel, ok := a.clock.(*clock.Travel); ok {
+		tt := timeTravel{travel}
+		admin.HandleFunc("/clock", tt.get).Methods("GET")
+		admin.HandleFunc("/clock", tt.set).Methods("PUT")
+		admin.HandleFunc("/clock", tt.reset).Methods("DELETE")
+	}
+
+	// The calendar feed also takes a token in the URL, for calendar apps.
+	r.Handle("/api/subscriptions/calendar.ics", a.calendarAuth(http.HandlerFunc(a.getCalendar))).Methods("GET")
+	// So does the event stream, for EventSource.
+	r.Handle("/api/events", a.eventsAuth(http.HandlerFunc(a.getEvents))).Methods("GET")
+	// Share links are public; the token is all they need.
+	r.HandleFunc("/share/{token}", a.getSharedView).Methods("GET")
+
+	// Everything else under /api belongs to the signed-in user.
+	user := r.PathPrefix("/api").Subrouter()
+	user.Use(a.authMiddleware)
+	user.Use(a.usageMiddleware)
+	user.HandleFunc("/me", a.getMe).Methods("GET")
+	user.HandleFunc("/me", a.patchMe).Methods("PATCH")
+	user.HandleFunc("/me", a.deleteAccount).Methods("DELETE")
+	user.HandleFunc("/me/export", a.exportAccount).Methods("GET")
+	user.HandleFunc("/sessions", a.getSessions).Methods("GET")
+	user.HandleFunc("/sessions/{id}", a.deleteSession).Methods("DELETE")
+	user.HandleFunc("/auth/2fa/setup", a.setupTwoFactor).Methods("POST")
+	user.HandleFunc("/auth/2fa/verify", a.verifyTwoFactor).Methods("POST")
+	user.HandleFunc("/auth/2fa/disable", a.disableTwoFactor).Methods("POST")
+	user.HandleFunc("/me/limits", a.getLimits).Methods("GET")
+	user.HandleFunc("/usage", a.getUsage).Methods("GET")
+	user.HandleFunc("/me/calendar", a.getCalendarLink).Methods("GET")
+	user.HandleFunc("/me/invites", a.getMyInvites).Methods("GET")
+	user.HandleFunc("/me/invites/{id}/accept", a.acceptInvite).Methods("POST")
+	user.HandleFunc("/me/invites/{id}/decline", a.declineInvite).Methods("POST")
+	user.HandleFunc("/me/notifications", a.getNotificationSettings).Methods("GET")
+	user.HandleFunc("/me/notifications/{channel}", a.putNotificationChannel).Methods("PUT")
+	user.HandleFunc("/me/push-subscriptions", a.getPushSubscriptions).Methods("GET")
+	user.HandleFunc("/me/push-subscriptions", a.createPushSubscription).Methods("POST")
+	user.HandleFunc("/me/push-subscriptions/{id}", a.deletePushSubscription).Methods("DELETE")
+	user.HandleFunc("/notifications/test", a.testNotification).Methods("POST")
+	user.HandleFunc("/me/stripe", a.getStripeAccount).Methods("GET")
+	user.HandleFunc("/me/stripe", a.connectStripe).Methods("PUT")
+	user.HandleFunc("/me/stripe", a.disconnectStripe).Methods("DELETE")
+	user.HandleFunc("/stripe/sync", a.syncStripeNow).Methods("POST")
+
+	user.HandleFunc("/subscriptions", a.getSubscriptions).Methods("GET")
+	user.HandleFunc("/subscriptions", a.idempotent(a.createSubscription)).Methods("POST")
+	// Registered before /subscriptions/{id} so "bulk", "import",
+	// "export", "search", "duplicates", "archived" and "share" aren't taken
+	// as IDs.
+	user.HandleFunc("/subscriptions/bulk", a.bulkCreateSubscriptions).Methods("POST")
+	user.HandleFunc("/subscriptions/bulk", a.bulkDeleteSubscriptions).Methods("DELETE")
+	user.HandleFunc("/subscriptions/bulk/archive", a.bulkArchiveSubscriptions).Methods("POST")
+	user.HandleFunc("/subscriptions/import", a.importSubscriptionsCSV).Methods("POST")
+	user.HandleFunc("/subscriptions/export", a.exportSubscriptions).Methods("GET")
+	user.HandleFunc("/subscriptions/search", a.searchSubscriptions).Methods("GET")
+	user.HandleFunc("/subscriptions/duplicates", a.getDuplicates).Methods("GET")
+	user.HandleFunc("/subscriptions/archived", a.getArchivedSubscriptions).Methods("GET")
+	user.HandleFunc("/subscriptions/share", a.getShareLinks).Methods("GET")
+	user.HandleFunc("/subscriptions/share", a.createShareLink).Methods("POST")
+	user.HandleFunc("/subscriptions/share/{id}", a.deleteShareLink).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}", a.getSubscription).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}", a.updateSubscription).Methods("PUT")
+	user.HandleFunc("/subscriptions/{id}", a.patchSubscription).Methods("PATCH")
+	user.HandleFunc("/subscriptions/{id}", a.deleteSubscription).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}/verify", a.verifySubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/pause", a.pauseSubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/resume", a.resumeSubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/cancel", a.cancelSubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/archive", a.archiveSubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/unarchive", a.unarchiveSubscription).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/history", a.getSubscriptionHistory).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/audit", a.getSubscriptionAudit).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/prices", a.getSubscriptionPrices).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/reminder", a.getReminder).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/reminder", a.setReminder).Methods("PUT")
+	user.HandleFunc("/subscriptions/{id}/reminder", a.deleteReminder).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}/cancellation-info", a.getCancellationInfo).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/cancellation-info", a.setCancellationInfo).Methods("PUT")
+	user.HandleFunc("/subscriptions/{id}/cancellation-info", a.deleteCancellationInfo).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}/usage", a.getUses).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/usage", a.logUse).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/usage/{usageId}", a.deleteUse).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}/stripe", a.getStripeLink).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/stripe", a.linkStripe).Methods("PUT")
+	user.HandleFunc("/subscriptions/{id}/stripe", a.unlinkStripe).Methods("DELETE")
+	user.HandleFunc("/subscriptions/{id}/attachments", a.getAttachments).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/attachments", a.uploadAttachment).Methods("POST")
+	user.HandleFunc("/subscriptions/{id}/attachments/{attachmentId}", a.downloadAttachment).Methods("GET")
+	user.HandleFunc("/subscriptions/{id}/attachments/{attachmentId}", a.deleteAttachment).Methods("DELETE")
+
+	user.HandleFunc("/trials", a.getTrials).Methods("GET")
+
+	user.HandleFunc("/audit", a.getAudit).Methods("GET")
+
+	user.HandleFunc("/backup", a.getBackup).Methods("GET")
+	user.HandleFunc("/restore", a.restoreBackup).Methods("POST")
+
+	user.HandleFunc("/tags", a.getTags).Methods("GET")
+	user.HandleFunc("/tags", a.createTag).Methods("POST")
+	user.HandleFunc("/tags/{id}", a.renameTag).Methods("PUT")
+	user.HandleFunc("/tags/{id}", a.deleteTag).Methods("DELETE")
+
+	user.HandleFunc("/catalog", a.getCatalog).Methods("GET")
+	user.HandleFunc("/catalog/{id}", a.getCatalogService).Methods("GET")
+
+	user.HandleFunc("/budgets", a.getBudgets).Methods("GET")
+	user.HandleFunc("/budgets", a.createBudget).Methods("POST")
+	user.HandleFunc("/budgets/{id}", a.updateBudget).Methods("PUT")
+	user.HandleFunc("/budgets/{id}", a.deleteBudget).Methods("DELETE")
+
+	user.HandleFunc("/households", a.createHousehold).Methods("POST")
+	user.HandleFunc("/households/{id}", a.getHousehold).Methods("GET")
+	user.HandleFunc("/households/{id}", a.deleteHousehold).Methods("DELETE")
+	user.HandleFunc("/households/{id}/members/{userId}", a.removeHouseholdMember).Methods("DELETE")
+	user.HandleFunc("/households/{id}/invites", a.getHouseholdInvites).Methods("GET")
+	user.HandleFunc("/households/{id}/invites", a.inviteHouseholdMember).Methods("POST")
+	user.HandleFunc("/households/{id}/invites/{inviteId}", a.deleteHouseholdInvite).Methods("DELETE")
+	user.HandleFunc("/households/{id}/subscriptions", a.getSharedSubscriptions).Methods("GET")
+	user.HandleFunc("/households/{id}/subscriptions/{subscriptionId}", a.shareSubscription).Methods("PUT")
+	user.HandleFunc("/households/{id}/subscriptions/{subscriptionId}", a.unshareSubscription).Methods("DELETE")
+	user.HandleFunc("/households/{id}/stats", a.getHouseholdStats).Methods("GET")
+
+	user.HandleFunc("/stats", a.getStats).Methods("GET")
+	user.HandleFunc("/stats/timeseries", a.getSpendingTimeseries).Methods("GET")
+	user.HandleFunc("/forecast", a.getForecast).Methods("GET")
+	user.HandleFunc("/spending", a.getSpending).Methods("GET")
+	user.HandleFunc("/reports/monthly", a.getMonthlyReport).Methods("GET")
+	user.HandleFunc("/reports/monthly.pdf", a.getMonthlyReportPDF).Methods("GET")
+	user.HandleFunc("/reports/annual/{year}", a.getAnnualReport).Methods("GET")
+	user.HandleFunc("/insights", a.getInsights).Methods("GET")
+	user.Handle("/graphql", a.graphQLHandler()).Methods("POST")
+	user.HandleFunc("/telemetry/preview", a.getTelemetryPreview).Methods("GET")
+
+	user.HandleFunc("/transactions", a.getTransactions).Methods("GET")
+	user.HandleFunc("/transactions", a.importTransactions).Methods("POST")
+	user.HandleFunc("/transactions/match", a.rematchTransactions).Methods("POST")
+	user.HandleFunc("/transactions/statement", a.importStatement).Methods("POST")
+	user.HandleFunc("/transactions/recurring", a.getRecurringCharges).Methods("GET")
+	user.HandleFunc("/transactions/link", a.linkTransactions).Methods("POST")
+	user.HandleFunc("/transactions/sync", a.syncTransactions).Methods("POST")
+	user.HandleFunc("/matches/review", a.getMatchReviewQueue).Methods("GET")
+	user.HandleFunc("/matches/{id}/accept", a.acceptMatch).Methods("POST")
+	user.HandleFunc("/matches/{id}/reject", a.rejectMatch).Methods("POST")
+	user.HandleFunc("/reconciliation", a.getReconciliation).Methods("GET")
+	user.HandleFunc("/alerts", a.getAlerts).Methods("GET")
+	user.HandleFunc("/alerts/{id}/dismiss", a.dismissAlert).Methods("POST")
+
+	user.HandleFunc("/webhooks", a.getWebhooks).Methods("GET")
+	user.HandleFunc("/webhooks", a.createWebhook).Methods("POST")
+	user.HandleFunc("/webhooks/{id}", a.deleteWebhook).Methods("DELETE")
+	user.HandleFunc("/webhooks/{id}/deliveries", a.getWebhookDeliveries).Methods("GET")
+
+	return r
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// bulkArchived is the bulk item status of an archived subscription.
+const bulkArchived = "archived"
+
+// getArchivedSubscriptions lists archived subscriptions, which GET
+// /api/subscriptions leaves out, with the same filters, sorting and paging.
+func (a *App) getArchivedSubscriptions(w http.ResponseWriter, r *http.Request) {
+	a.listSubscriptions(w, r, true)
+}
+
+// archiveSubscription hides a subscription of any status from the
+// subscription list and stats. It still bills if it's active, and its
+// billing history stays in reports.
+func (a *App) archiveSubscription(w http.ResponseWriter, r *http.Request) {
+	a.setArchived(w, r, true)
+}
+
+// unarchiveSubscription puts an archived subscription back in the list.
+func (a *App) unarchiveSubscription(w http.ResponseWriter, r *http.Request) {
+	a.setArchived(w, r, false)
+}
+
+// setArchived archives or unarchives the subscription in the path,
+// answering 409 if it already is or isn't archived.
+func (a *App) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	before, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if (before.ArchivedAt != nil) == archived {
+		if archived {
+			writeError(w, http.StatusConflict, codeConflict, "Subscription is already archived")
+		} else {
+			writeError(w, http.StatusConflict, codeConflict, "Subscription isn't archived")
+		}
+		return
+	}
+
+	s, err := a.archive(r, before, archived)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.setSubscriptionETag(w, r, uid, id)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// archive stores before as archived or not and returns it as now stored,
+// recording the change in the audit log and as an update event.
+func (a *App) archive(r *http.Request, before models.Subscription, archived bool) (models.Subscription, error) {
+	uid := userID(r)
+	now := a.clock.Now()
+	if err := a.subscriptions.SetArchived(r.Context(), uid, before.ID, archived, now); err != nil {
+		return before, err
+	}
+	// Read back rather than patched, so the version is the bumped one.
+	s, err := a.subscriptions.Get(r.Context(), uid, before.ID)
+	if err != nil {
+		return before, err
+	}
+	a.setStale(&s)
+	a.recordAudit(r.Context(), uid, s.ID, &before, &s)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionUpdated, s)
+	return s, nil
+}
+
+// bulkArchiveSubscriptions archives each ID in {"ids": [...]} and reports
+// per ID whether it's now archived or not found. One already archived is
+// left as it was.
+func (a *App) bulkArchiveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBulkItems {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Send between 1 and %d ids", maxBulkItems))
+		return
+	}
+
+	uid := userID(r)
+	results := make([]bulkResult, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i] = bulkResult{Index: i, ID: id, Status: bulkArchived}
+		s, err := a.subscriptions.Get(r.Context(), uid, id)
+		if err == nil && s.ArchivedAt == nil {
+			s, err = a.archive(r, s, true)
+		}
+		if err == store.ErrNotFound {
+			results[i].Status = bulkNotFound
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		} else {
+			a.setStale(&s)
+			results[i].Subscription = &s
+		}
+	}
+	writeBulkResponse(w, http.StatusOK, results)
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+)
+
+// defaultAttachmentBytes caps each attached file when
+// Config.MaxAttachmentBytes is zero, and attachmentOverhead is how much
+// of an upload may be multipart framing around the file.
+const (
+	defaultAttachmentBytes = 10 << 20
+	attachmentOverhead     = 64 << 10
+)
+
+// attachmentTypes are the file types a subscription may have attached,
+// as http.DetectContentType sniffs them: receipts and invoices as PDFs or
+// images. The type a client claims is ignored.
+var attachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+var errAttachmentTooLarge = errors.New("attachment is too large")
+
+// attachmentPrefix is where the user's attachments are kept in the blob
+// store, or those of one subscription if subscriptionID isn't zero.
+func attachmentPrefix(userID, subscriptionID int) string {
+	if subscriptionID == 0 {
+		return fmt.Sprintf("attachments/%d/", userID)
+	}
+	return fmt.Sprintf("attachments/%d/%d/", userID, subscriptionID)
+}
+
+func (a *App) countAttachmentBytes(ctx context.Context, userID int) (int64, error) {
+	var n int64
+	err := a.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size_bytes), 0) FROM attachments WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+// uploadLimiter fails reads once more than max bytes have come through,
+// and keeps the error the upload itself failed with, so it can be told
+// apart from the blob store's.
+type uploadLimiter struct {
+	r       io.Reader
+	n, max  int64
+	readErr error
+}
+
+func (l *uploadLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		l.readErr = errAttachmentTooLarge
+		return n, l.readErr
+	}
+	if err != nil && err != io.EOF {
+		l.readErr = err
+	}
+	return n, err
+}
+
+// uploadAttachment stores the "file" part of a multipart upload as an
+// attachment of the subscription. The file is streamed to the blob store
+// as it arrives; it must be a PDF or image of at most MaxAttachmentBytes
+// and fit in the user's attachment quota.
+func (a *App) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if !a.blobsConfigured() {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Attachment storage is not configured; set S3_BUCKET or BLOB_DIR")
+		return
+	}
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	ctx, uid := r.Context(), userID(r)
+	maxBytes := cmp.Or(a.config.MaxAttachmentBytes, defaultAttachmentBytes)
+	quota, err := a.quotaStatus(ctx, uid, QuotaAttachmentBytes)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	room := maxBytes
+	if quota.Limit != nil {
+		if quota.Used >= *quota.Limit {
+			writeQuotaExceeded(w, QuotaAttachmentBytes, quota)
+			return
+		}
+		room = min(room, *quota.Limit-quota.Used)
+	}
+
+	part, ok := uploadedFile(w, r, room+attachmentOverhead)
+	if !ok {
+		return
+	}
+	upload := &uploadLimiter{r: part, max: room}
+	file := bufio.NewReaderSize(upload, 512)
+	head, _ := file.Peek(512)
+	if upload.readErr == nil && len(head) == 0 {
+		writeValidationErrors(w, fieldErrors{{"file", "is empty"}})
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if upload.readErr == nil && !attachmentTypes[contentType] {
+		writeValidationErrors(w, fieldErrors{{"file", "must be a PDF, PNG, JPEG, GIF or WebP file"}})
+		return
+	}
+
+	var suffix [12]byte
+	rand.Read(suffix[:])
+	key := attachmentPrefix(uid, id) + hex.EncodeToString(suffix[:])
+	err = a.blobs.Put(ctx, key, file)
+	if err != nil || upload.readErr != nil {
+		a.blobs.Delete(ctx, key)
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(upload.readErr, errAttachmentTooLarge) && room < maxBytes:
+		writeQuotaExceeded(w, QuotaAttachmentBytes, quota)
+		return
+	case errors.Is(upload.readErr, errAttachmentTooLarge), errors.As(upload.readErr, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("File is larger than %d bytes", maxBytes))
+		return
+	case upload.readErr != nil:
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading upload: %v", upload.readErr))
+		return
+	case err != nil:
+		a.reportBlobs(err)
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Attachment storage error: %v", err))
+		return
+	}
+	a.reportBlobs(nil)
+
+	att := models.Attachment{SubscriptionID: id, Filename: attachmentFilename(part.FileName()), ContentType: contentType, Size: upload.n}
+	var createdAt time.Time
+	err = a.db.QueryRowContext(ctx, `
+		INSERT INTO attachments (user_id, subscription_id, blob_key, filename, content_type, size_bytes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, uid, id, key, att.Filename, att.ContentType, att.Size, a.dbNow()).Scan(&att.ID, &createdAt)
+	if err != nil {
+		a.blobs.Delete(ctx, key)
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	att.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(att); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// attachmentFilename is the base name of an uploaded file, without
+// control characters and at most 255 bytes long.
+func attachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+func (a *App) getAttachments(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, filename, content_type, size_bytes, created_at FROM attachments
+		WHERE subscription_id = $1 AND user_id = $2 ORDER BY id
+	`, id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	atts := []models.Attachment{}
+	for rows.Next() {
+		att := models.Attachment{SubscriptionID: id}
+		var createdAt time.Time
+		if err := rows.Scan(&att.ID, &att.Filename, &att.ContentType, &att.Size, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		att.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		atts = append(atts, att)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(atts); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// attachment looks up the attachment the request names, writing the error
+// if it isn't one of the subscription's.
+func (a *App) attachment(w http.ResponseWriter, r *http.Request) (models.Attachment, string, bool) {
+	var att models.Attachment
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return att, "", false
+	}
+	attachmentID, err := strconv.Atoi(mux.Vars(r)["attachmentId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid attachment ID")
+		return att, "", false
+	}
+	var key string
+	var createdAt time.Time
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT id, subscription_id, blob_key, filename, content_type, size_bytes, created_at FROM attachments
+		WHERE id = $1 AND subscription_id = $2 AND user_id = $3
+	`, attachmentID, id, userID(r)).Scan(&att.ID, &att.SubscriptionID, &key, &att.Filename, &att.ContentType, &att.Size, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Attachment not found")
+		return att, "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return att, "", false
+	}
+	att.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return att, key, true
+}
+
+// downloadAttachment sends the file as it was uploaded.
+func (a *App) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	att, key, ok := a.attachment(w, r)
+	if !ok {
+		return
+	}
+	file, err := a.blobs.Get(r.Context(), key)
+	a.reportBlobs(err)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Attachment storage error: %v", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, file); err != nil {
+		a.logger.WarnContext(r.Context(), "sending attachment", "key", key, "err", err)
+	}
+}
+
+func (a *App) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	att, key, ok := a.attachment(w, r)
+	if !ok {
+		return
+	}
+	err := a.blobs.Delete(r.Context(), key)
+	a.reportBlobs(err)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Attachment storage error: %v", err))
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM attachments WHERE id = $1", att.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeAttachments deletes the files attached to a subscription that was
+// deleted, or with subscriptionID zero to any of the user's, once their
+// rows are gone. Files that can't be deleted are logged and left behind.
+func (a *App) purgeAttachments(ctx context.Context, userID, subscriptionID int) {
+	if !a.blobsConfigured() {
+		return
+	}
+	prefix := attachmentPrefix(userID, subscriptionID)
+	keys, err := a.blobs.List(ctx, prefix)
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, a.blobs.Delete(ctx, key))
+	}
+	err = errors.Join(append(errs, err)...)
+	a.reportBlobs(err)
+	if err != nil {
+		a.logger.WarnContext(ctx, "purging attachments", "prefix", prefix, "err", err)
+	}
+}
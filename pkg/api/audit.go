@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// auditIgnored are subscription fields that aren't edits: the ID never
+// changes, and verification, staleness, timestamps and the version are
+// bookkeeping.
+var auditIgnored = map[string]bool{"id": true, "lastVerifiedAt": true, "stale": true, "createdAt": true, "updatedAt": true, "version": true}
+
+// auditActions are the values ?action= accepts.
+var auditActions = []string{models.AuditCreate, models.AuditUpdate, models.AuditDelete}
+
+// auditDiff lists the fields that differ between before and after, either
+// of which may be nil.
+func auditDiff(before, after *models.Subscription) (map[string]models.AuditChange, error) {
+	fields := func(s *models.Subscription) (map[string]any, error) {
+		m := map[string]any{}
+		if s == nil {
+			return m, nil
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		return m, json.Unmarshal(data, &m)
+	}
+	from, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]models.AuditChange{}
+	for _, m := range []map[string]any{from, to} {
+		for field := range m {
+			if auditIgnored[field] || reflect.DeepEqual(from[field], to[field]) {
+				continue
+			}
+			changes[field] = models.AuditChange{From: from[field], To: to[field]}
+		}
+	}
+	return changes, nil
+}
+
+// recordAudit logs a change to one of the user's subscriptions: a create
+// when before is nil, a delete when after is nil and otherwise an update,
+// which is skipped if nothing changed. The actor is the signed-in user in
+// ctx, or nobody for background jobs. Like emitEvent it runs after the
+// change is made, so failures are logged rather than returned, and an App
+// without a database keeps no log. Either way the user's cached stats are
+// dropped.
+func (a *App) recordAudit(ctx context.Context, userID, subscriptionID int, before, after *models.Subscription) {
+	a.invalidateStats(ctx, userID)
+	if a.db == nil {
+		return
+	}
+	action := models.AuditUpdate
+	switch {
+	case before == nil:
+		action = models.AuditCreate
+	case after == nil:
+		action = models.AuditDelete
+	}
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		a.logger.WarnContext(ctx, "recording audit entry", "subscription", subscriptionID, "err", err)
+		return
+	}
+	if action == models.AuditUpdate && len(changes) == 0 {
+		return
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		a.logger.WarnContext(ctx, "recording audit entry", "subscription", subscriptionID, "err", err)
+		return
+	}
+
+	var actor *int
+	if id, ok := ctx.Value(userIDKey).(int); ok {
+		actor = &id
+	}
+	_, err = a.db.ExecContext(ctx, `
+		INSERT INTO audit_log (user_id, actor_id, subscription_id, action, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, actor, subscriptionID, action, string(data), a.dbNow())
+	if err != nil {
+		a.logger.WarnContext(ctx, "recording audit entry", "subscription", subscriptionID, "err", err)
+	}
+}
+
+// getAudit returns one page of the user's audit log, newest first. It can
+// be narrowed with ?subscriptionId, ?action (create, update or delete) and
+// ?from and ?to, inclusive YYYY-MM-DD dates in UTC, and is paged like the
+// subscription list.
+func (a *App) getAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var subscription *int
+	if v := q.Get("subscriptionId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "subscriptionId must be a number")
+			return
+		}
+		subscription = &id
+	}
+	a.writeAudit(w, r, subscription)
+}
+
+// getSubscriptionAudit returns one page of a subscription's audit log,
+// newest first, with the same filters as getAudit. It still works once
+// the subscription is deleted.
+func (a *App) getSubscriptionAudit(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	// Subscriptions from before the log have no entries but still exist.
+	var n int
+	err := a.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM audit_log WHERE user_id = $1 AND subscription_id = $2", userID(r), id).Scan(&n)
+	if err == nil && n == 0 {
+		_, err = a.subscriptions.Get(r.Context(), userID(r), id)
+	}
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.writeAudit(w, r, &id)
+}
+
+// auditDecodeError is an audit entry whose changes aren't valid JSON.
+type auditDecodeError struct {
+	id  int
+	err error
+}
+
+func (e auditDecodeError) Error() string { return fmt.Sprintf("Audit entry %d: %v", e.id, e.err) }
+
+// scanAuditEntries reads audit entries from rows of id, subscription_id,
+// action, actor_id, the actor's email, changes and created_at, and closes
+// them.
+func scanAuditEntries(rows *sql.Rows) ([]models.AuditEntry, error) {
+	defer rows.Close()
+	items := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var actorID sql.NullInt64
+		var actor sql.NullString
+		var changes string
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.SubscriptionID, &e.Action, &actorID, &actor, &changes, &createdAt); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			e.ActorID = &id
+		}
+		if actor.Valid {
+			e.Actor = &actor.String
+		}
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, auditDecodeError{e.ID, err}
+		}
+		e.CreatedAt = createdAt.Format(time.RFC3339)
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+func (a *App) writeAudit(w http.ResponseWriter, r *http.Request, subscription *int) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	// conds are written with %d for their argument's placeholder number.
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	add("l.user_id = $%d", userID(r))
+	if subscription != nil {
+		add("l.subscription_id = $%d", *subscription)
+	}
+	q := r.URL.Query()
+	if v := q.Get("action"); v != "" {
+		if !slices.Contains(auditActions, v) {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "action must be one of "+strings.Join(auditActions, ", "))
+			return
+		}
+		add("l.action = $%d", v)
+	}
+	for _, f := range []struct {
+		param, cond string
+		days        int
+	}{{"from", "l.created_at >= $%d", 0}, {"to", "l.created_at < $%d", 1}} {
+		if v := q.Get(f.param); v != "" {
+			day, err := time.Parse(dateLayout, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, f.param+" must be YYYY-MM-DD")
+				return
+			}
+			add(f.cond, day.AddDate(0, 0, f.days))
+		}
+	}
+	where := " WHERE " + strings.Join(conds, " AND ")
+
+	var total int
+	if err := a.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM audit_log l"+where, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT l.id, l.subscription_id, l.action, l.actor_id, u.email, l.changes, l.created_at
+		FROM audit_log l LEFT JOIN users u ON u.id = l.actor_id%s
+		ORDER BY l.id DESC LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2), append(args, limit, offset)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	items, err := scanAuditEntries(rows)
+	var decodeErr auditDecodeError
+	if errors.As(err, &decodeErr) {
+		writeError(w, http.StatusInternalServerError, codeInternal, decodeErr.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	page := models.Page[models.AuditEntry]{Items: items, Total: total, Limit: limit, Offset: offset}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+const minPasswordLength = 8
+
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	requestIDKey
+	sessionIDKey
+)
+
+// userID returns the authenticated user for a request that went through
+// authMiddleware.
+func userID(r *http.Request) int {
+	id, _ := r.Context().Value(userIDKey).(int)
+	return id
+}
+
+// sessionID returns the session the request's access token was issued
+// for, or 0 if it wasn't issued for one.
+func sessionID(r *http.Request) int {
+	id, _ := r.Context().Value(sessionIDKey).(int)
+	return id
+}
+
+// accessClaims are the claims of an access token: the registered ones,
+// with the user as the subject and the session as the ID, and the user's
+// tenant.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Tenant int `json:"tid,omitempty"`
+}
+
+// issueToken signs an access token for the user in the tenant, for the
+// session if it's not 0. Tokens use wall-clock time, not the App's clock,
+// so dev-mode time travel can't expire them.
+func (a *App) issueToken(id, tenant, session int) (string, error) {
+	now := time.Now()
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.config.TokenTTL)),
+		},
+		Tenant: tenant,
+	}
+	if session != 0 {
+		claims.ID = strconv.Itoa(session)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.config.JWTSecret))
+}
+
+// parseToken validates an access token and returns its user ID, its
+// user's tenant and its session, 0 if it has none. Tokens issued before
+// tenants existed are the default tenant's.
+func (a *App) parseToken(token string) (id, tenant, session int, err error) {
+	var claims accessClaims
+	_, err = jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return []byte(a.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if id, err = strconv.Atoi(claims.Subject); err != nil {
+		return 0, 0, 0, err
+	}
+	if claims.ID != "" {
+		if session, err = strconv.Atoi(claims.ID); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return id, cmp.Or(claims.Tenant, defaultTenant), session, nil
+}
+
+// withUser records the authenticated user in ctx and scopes the queries
+// made with it to the user's rows in its tenant.
+func (a *App) withUser(ctx context.Context, tenant, id int) context.Context {
+	return context.WithValue(store.WithUser(ctx, tenant, id), userIDKey, id)
+}
+
+// authMiddleware requires a valid bearer token, issued in the tenant the
+// request is for and for a session that hasn't been revoked, and records
+// the user in the request context.
+func (a *App) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Authentication required")
+			return
+		}
+		id, tenant, session, err := a.parseToken(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired token")
+			return
+		}
+		current, ok := a.resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		if tenant != current {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Token is for another tenant")
+			return
+		}
+		ctx := a.withUser(r.Context(), tenant, id)
+		if session != 0 {
+			// Logging out and revoking a session delete it, which ends
+			// the access tokens issued for it too.
+			var live bool
+			if err := a.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2)", session, id).Scan(&live); err != nil {
+				writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+				return
+			}
+			if !live {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "Session has been revoked")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, sessionIDKey, session)))
+	})
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Code is the TOTP or recovery code for logging in to an account with
+	// two-factor authentication on.
+	Code string `json:"code,omitempty"`
+}
+
+// authResponse is what signing up, logging in and refreshing return: a
+// short-lived access token, which expires in ExpiresIn seconds, and the
+// refresh token to get the next one with.
+type authResponse struct {
+	Token        string      `json:"token"`
+	ExpiresIn    int         `json:"expiresIn"`
+	RefreshToken string      `json:"refreshToken"`
+	User         models.User `json:"user"`
+}
+
+// writeAuthResponse starts a session for the user and sends its tokens.
+func (a *App) writeAuthResponse(w http.ResponseWriter, r *http.Request, status int, u models.User) {
+	session, refresh, err := a.createSession(r, u.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.writeTokens(w, status, u, session, refresh)
+}
+
+// writeTokens sends a new access token for the session with its refresh
+// token.
+func (a *App) writeTokens(w http.ResponseWriter, status int, u models.User, session int, refresh string) {
+	token, err := a.issueToken(u.ID, u.TenantID, session)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Token error: %v", err))
+		return
+	}
+
+	resp := authResponse{Token: token, ExpiresIn: int(a.config.TokenTTL / time.Second), RefreshToken: refresh, User: u}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// signup creates an account in the request's tenant and logs it in. The
+// first account to sign up to the default tenant takes ownership of any
+// data created before accounts existed.
+func (a *App) signup(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	c.Email = strings.ToLower(strings.TrimSpace(c.Email))
+	if _, err := mail.ParseAddress(c.Email); err != nil {
+		writeError(w, http.StatusBadRequest, codeValidation, "A valid email is required")
+		return
+	}
+	if len(c.Password) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, codeValidation, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Password hashing error: %v", err))
+		return
+	}
+
+	tenant, ok := a.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	u := models.User{Email: c.Email, TenantID: tenant}
+	var createdAt time.Time
+	// Claiming unowned data runs as the system role, so a signup to the
+	// default tenant does; others only need their tenant's scope.
+	ctx := store.WithTenant(r.Context(), tenant)
+	if tenant == defaultTenant {
+		ctx = store.AsSystem(ctx)
+	}
+	var claimed int64
+	err = store.InTx(ctx, a.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO users (email, password_hash, tenant_id) VALUES ($1, $2, $3)
+			ON CONFLICT (tenant_id, email) DO NOTHING
+			RETURNING id, created_at, currency
+		`, c.Email, string(hash), tenant).Scan(&u.ID, &createdAt, &u.Currency)
+		if err != nil || tenant != defaultTenant {
+			return err
+		}
+		claimed, err = claimUnownedData(ctx, tx, u.ID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusConflict, codeConflict, "An account with this email already exists in this tenant")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+	if claimed > 0 {
+		a.logger.InfoContext(r.Context(), "unowned data claimed", "user", u.ID, "rows", claimed)
+	}
+
+	a.writeAuthResponse(w, r.WithContext(a.withUser(r.Context(), tenant, u.ID)), http.StatusCreated, u)
+}
+
+// claimUnownedData assigns rows without an owner to the user if it's the
+// first account in the default tenant, and reports how many it claimed.
+// It runs in the signup's transaction, as the system role since rows
+// without an owner are no user's to see. Each UPDATE only takes rows that
+// are still unowned once it holds their locks, so two signups racing can
+// never both claim the same row.
+func claimUnownedData(ctx context.Context, tx *sql.Tx, id int) (int64, error) {
+	var first int
+	if err := tx.QueryRowContext(ctx, "SELECT MIN(id) FROM users WHERE tenant_id = $1", defaultTenant).Scan(&first); err != nil {
+		return 0, err
+	}
+	if first != id {
+		return 0, nil
+	}
+	var claimed int64
+	for _, table := range []string{"subscriptions", "transactions", "alerts"} {
+		res, err := tx.ExecContext(ctx, "UPDATE "+table+" SET user_id = $1 WHERE user_id IS NULL", id)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		claimed += n
+	}
+	return claimed, nil
+}
+
+// login exchanges an email and password, and a second factor if the
+// account has two-factor authentication on, for an access token and a new
+// session's refresh token. Only accounts in the request's tenant can log
+// in. Logging in cancels a pending account deletion.
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	tenant, ok := a.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	u := models.User{TenantID: tenant}
+	var hash string
+	var createdAt time.Time
+	var state twoFactorState
+	err := a.db.QueryRowContext(store.WithTenant(r.Context(), tenant), `
+		SELECT id, email, password_hash, created_at, currency, monthly_digest, totp_secret, totp_enabled, totp_last_step
+		FROM users WHERE email = $1 AND tenant_id = $2
+	`, strings.ToLower(strings.TrimSpace(c.Email)), tenant).Scan(&u.ID, &u.Email, &hash, &createdAt, &u.Currency, &u.MonthlyDigest, &state.secret, &state.enabled, &state.lastStep)
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err == sql.ErrNoRows || bcrypt.CompareHashAndPassword([]byte(hash), []byte(c.Password)) != nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid email or password")
+		return
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+	u.TwoFactorEnabled = state.enabled
+	r = r.WithContext(a.withUser(r.Context(), tenant, u.ID))
+
+	if state.enabled {
+		if strings.TrimSpace(c.Code) == "" {
+			writeError(w, http.StatusUnauthorized, codeTwoFactorRequired, "Enter the code from your authenticator app or a recovery code")
+			return
+		}
+		ok, err := a.checkSecondFactor(r.Context(), u.ID, state, c.Code)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid or already used two-factor code")
+			return
+		}
+	}
+
+	if err := a.cancelAccountDeletion(r.Context(), u.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	a.writeAuthResponse(w, r, http.StatusOK, u)
+}
+
+// account loads a user, returning sql.ErrNoRows if there's none.
+func (a *App) account(ctx context.Context, id int) (models.User, error) {
+	u := models.User{ID: id}
+	var createdAt time.Time
+	var deleteAfter sql.NullTime
+	err := a.db.QueryRowContext(ctx, "SELECT email, created_at, currency, totp_enabled, monthly_digest, delete_after FROM users WHERE id = $1", id).
+		Scan(&u.Email, &createdAt, &u.Currency, &u.TwoFactorEnabled, &u.MonthlyDigest, &deleteAfter)
+	if err != nil {
+		return u, err
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+	if deleteAfter.Valid {
+		at := deleteAfter.Time.UTC().Format(time.RFC3339)
+		u.DeleteAfter = &at
+	}
+	return u, nil
+}
+
+// getMe returns the authenticated user.
+func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
+	a.writeMe(w, r, http.StatusOK)
+}
+
+// writeMe sends the authenticated user with the given status.
+func (a *App) writeMe(w http.ResponseWriter, r *http.Request, status int) {
+	u, err := a.account(r.Context(), userID(r))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// patchMe changes the authenticated user's settings: the display
+// currency, {"currency": "EUR"}, and whether they get the monthly digest,
+// {"monthlyDigest": true}.
+func (a *App) patchMe(w http.ResponseWriter, r *http.Request) {
+	var patch struct {
+		Currency      *string `json:"currency"`
+		MonthlyDigest *bool   `json:"monthlyDigest"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if patch.Currency != nil {
+		if !isCurrencyCode(*patch.Currency) {
+			writeValidationErrors(w, fieldErrors{{"currency", "must be a three-letter ISO 4217 code such as USD"}})
+			return
+		}
+		if _, err := a.db.ExecContext(r.Context(), "UPDATE users SET currency = $1 WHERE id = $2", *patch.Currency, userID(r)); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	if patch.MonthlyDigest != nil {
+		if _, err := a.db.ExecContext(r.Context(), "UPDATE users SET monthly_digest = $1 WHERE id = $2", *patch.MonthlyDigest, userID(r)); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	a.getMe(w, r)
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/blobs"
+	"subscription-tracker/pkg/store"
+)
+
+// backupKeyLayout is the time in a scheduled backup's key, which makes
+// keys sort oldest first.
+const backupKeyLayout = "20060102T150405Z"
+
+var errBackupRunning = errors.New("a backup is already running")
+
+// backupStorageError is a failure of the blob store, as opposed to the
+// database, while listing or making backups.
+type backupStorageError struct{ err error }
+
+func (e backupStorageError) Error() string { return e.err.Error() }
+func (e backupStorageError) Unwrap() error { return e.err }
+
+// ScheduledBackup is one backup of every account in the blob store: a
+// gzipped tar file with one NDJSON backup per account, named by its ID and
+// email, that POST /api/restore accepts as it is.
+type ScheduledBackup struct {
+	Key       string `json:"key"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// BackupRun is a backup just made: how many accounts it holds and which
+// old backups were deleted to make room.
+type BackupRun struct {
+	ScheduledBackup
+	Accounts int      `json:"accounts"`
+	Pruned   []string `json:"pruned"`
+}
+
+// BackupList is the blob store's backups, newest first, and the schedule
+// that makes them.
+type BackupList struct {
+	Backups   []ScheduledBackup `json:"backups"`
+	Prefix    string            `json:"prefix"`
+	Interval  string            `json:"interval"`
+	Retention int               `json:"retention"`
+}
+
+func (a *App) blobsConfigured() bool {
+	_, off := a.blobs.(unconfigured)
+	return !off
+}
+
+// reportBlobs records how a call to the blob store went. A local
+// directory isn't an external system, so it isn't reported.
+func (a *App) reportBlobs(err error) {
+	if _, local := a.blobs.(*blobs.Dir); !local {
+		a.integrations.report("s3", err)
+	}
+}
+
+// StartBackups backs up every account to the blob store every
+// BackupInterval, keeping the newest BackupRetention backups. A backup is
+// only made once the newest one is an interval old, so restarting the
+// server doesn't add one each time. It does nothing without a blob store
+// and a database.
+func (a *App) StartBackups(ctx context.Context) {
+	if a.config.BackupInterval <= 0 || !a.blobsConfigured() || a.db == nil {
+		return
+	}
+
+	a.jobs.started("backups", a.config.BackupInterval)
+	go func() {
+		defer a.jobs.stopped("backups")
+		ticker := time.NewTicker(a.config.BackupInterval)
+		defer ticker.Stop()
+		for {
+			if run, err := a.backupIfDue(ctx); err != nil {
+				a.logger.Warn("backing up accounts", "err", err)
+			} else if run != nil {
+				a.logger.Info("backed up accounts", "key", run.Key, "accounts", run.Accounts, "pruned", len(run.Pruned))
+			}
+			a.jobs.ran("backups")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// backupIfDue makes a backup unless the newest one is less than an
+// interval old, returning nil then.
+func (a *App) backupIfDue(ctx context.Context) (*BackupRun, error) {
+	ctx = store.AsSystem(ctx)
+	backups, err := a.listBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) > 0 {
+		newest, _ := time.Parse(time.RFC3339, backups[0].CreatedAt)
+		if a.clock.Now().Sub(newest) < a.config.BackupInterval {
+			return nil, nil
+		}
+	}
+	run, err := a.runBackup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (a *App) backupKey(at time.Time) string {
+	return a.config.BackupPrefix + "backup-" + at.UTC().Format(backupKeyLayout) + ".tar.gz"
+}
+
+// listBackups returns the scheduled backups under BackupPrefix, newest
+// first. Other objects under the prefix are left out.
+func (a *App) listBackups(ctx context.Context) ([]ScheduledBackup, error) {
+	keys, err := a.blobs.List(ctx, a.config.BackupPrefix)
+	a.reportBlobs(err)
+	if err != nil {
+		return nil, backupStorageError{err}
+	}
+	backups := []ScheduledBackup{}
+	for _, key := range keys {
+		stamp, ok := strings.CutPrefix(key, a.config.BackupPrefix+"backup-")
+		if !ok {
+			continue
+		}
+		at, err := time.Parse(backupKeyLayout, strings.TrimSuffix(stamp, ".tar.gz"))
+		if err != nil || !strings.HasSuffix(stamp, ".tar.gz") {
+			continue
+		}
+		backups = append(backups, ScheduledBackup{Key: key, CreatedAt: at.Format(time.RFC3339)})
+	}
+	slices.SortFunc(backups, func(x, y ScheduledBackup) int { return strings.Compare(y.Key, x.Key) })
+	return backups, nil
+}
+
+// runBackup backs up every account, streaming the archive to the blob
+// store, then deletes the oldest backups beyond BackupRetention.
+func (a *App) runBackup(ctx context.Context) (BackupRun, error) {
+	ctx = store.AsSystem(ctx)
+	var run BackupRun
+	if !a.backupRunning.TryLock() {
+		return run, errBackupRunning
+	}
+	defer a.backupRunning.Unlock()
+	if a.db == nil {
+		return run, errors.New("backups need a database")
+	}
+
+	type account struct {
+		id    int
+		email string
+	}
+	var accounts []account
+	rows, err := a.db.QueryContext(ctx, "SELECT id, email FROM users ORDER BY id")
+	if err != nil {
+		return run, err
+	}
+	for rows.Next() {
+		var acc account
+		if err := rows.Scan(&acc.id, &acc.email); err != nil {
+			rows.Close()
+			return run, err
+		}
+		accounts = append(accounts, acc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return run, err
+	}
+
+	at := a.clock.Now()
+	run.Key, run.CreatedAt, run.Accounts, run.Pruned = a.backupKey(at), at.UTC().Format(time.RFC3339), len(accounts), []string{}
+
+	// The archive is written into a pipe as the upload reads it, so only
+	// one account's backup is in memory at a time.
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := func() error {
+			gz := gzip.NewWriter(pw)
+			archive := tar.NewWriter(gz)
+			for _, acc := range accounts {
+				b, err := store.ReadBackup(ctx, a.db, acc.id, at)
+				if err != nil {
+					return fmt.Errorf("account %d: %w", acc.id, err)
+				}
+				var buf bytes.Buffer
+				if err := writeBackupLines(&buf, b); err != nil {
+					return err
+				}
+				header := &tar.Header{Name: fmt.Sprintf("%d-%s.ndjson", acc.id, acc.email), Mode: 0o600, Size: int64(buf.Len()), ModTime: at}
+				if err := archive.WriteHeader(header); err != nil {
+					return err
+				}
+				if _, err := archive.Write(buf.Bytes()); err != nil {
+					return err
+				}
+			}
+			if err := archive.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}()
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err = a.blobs.Put(ctx, run.Key, pr)
+	// Stop the writer if the upload gave up before reading everything; its
+	// writes then fail with the upload's error. Any other error is the
+	// writer's own, which the upload failed with in turn.
+	pr.CloseWithError(err)
+	if werr := <-written; werr != nil && (err == nil || !errors.Is(werr, err)) {
+		return run, werr
+	}
+	a.reportBlobs(err)
+	if err != nil {
+		return run, backupStorageError{err}
+	}
+
+	backups, err := a.listBackups(ctx)
+	if err != nil {
+		return run, err
+	}
+	var errs []error
+	for _, old := range backups[min(len(backups), max(a.config.BackupRetention, 1)):] {
+		if err := a.blobs.Delete(ctx, old.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		run.Pruned = append(run.Pruned, old.Key)
+	}
+	if err := errors.Join(errs...); err != nil {
+		a.reportBlobs(err)
+		return run, backupStorageError{fmt.Errorf("deleting old backups: %w", err)}
+	}
+	return run, nil
+}
+
+// writeBackupError sends the response for an error from listing or making
+// backups.
+func writeBackupError(w http.ResponseWriter, err error) {
+	var storageErr backupStorageError
+	switch {
+	case errors.Is(err, errBackupRunning):
+		writeError(w, http.StatusConflict, codeConflict, "A backup is already running")
+	case errors.As(err, &storageErr):
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Backup storage error: %v", err))
+	default:
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+	}
+}
+
+// getAdminBackups lists the scheduled backups in the blob store.
+func (a *App) getAdminBackups(w http.ResponseWriter, r *http.Request) {
+	if !a.blobsConfigured() {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Backup storage is not configured; set S3_BUCKET or BLOB_DIR")
+		return
+	}
+	backups, err := a.listBackups(r.Context())
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	list := BackupList{Backups: backups, Prefix: a.config.BackupPrefix, Interval: a.config.BackupInterval.String(), Retention: a.config.BackupRetention}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// createAdminBackup makes a backup of every account now, whatever the
+// schedule, and answers once it's stored.
+func (a *App) createAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if !a.blobsConfigured() {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Backup storage is not configured; set S3_BUCKET or BLOB_DIR")
+		return
+	}
+	run, err := a.runBackup(r.Context())
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	a.logger.InfoContext(r.Context(), "backed up accounts", "key", run.Key, "accounts", run.Accounts, "pruned", len(run.Pruned))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
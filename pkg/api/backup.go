@@ -0,0 +1,382 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// maxBackupBytes caps the size of a backup sent to POST /api/restore, both
+// as uploaded and once decompressed.
+const maxBackupBytes = 50 << 20
+
+var errBackupTooLarge = fmt.Errorf("backup is larger than %d bytes", maxBackupBytes)
+
+// backupLine is one line of an NDJSON backup: a header line with the
+// backup's version, export time and currency, then one line per tag,
+// subscription, budget, transaction, alert and webhook.
+type backupLine struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type backupHeader struct {
+	Version    int    `json:"version"`
+	ExportedAt string `json:"exportedAt"`
+	Currency   string `json:"currency"`
+}
+
+// getBackup sends everything the caller's account holds as one JSON
+// document, or with ?format=ndjson as gzipped NDJSON, one item a line, for
+// accounts too big to comfortably parse whole. Either restores with POST
+// /api/restore, here or on another instance.
+func (a *App) getBackup(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "ndjson" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "format must be json or ndjson")
+		return
+	}
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Backups need a database")
+		return
+	}
+	b, err := store.ReadBackup(r.Context(), a.db, userID(r), a.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("subscriptions-backup-%s", a.clock.Now().Format(dateLayout))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		if err := json.NewEncoder(w).Encode(b); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		}
+		return
+	}
+	startDownload(w, "application/gzip", filename+".ndjson.gz")
+	gz := gzip.NewWriter(w)
+	if err := writeBackupLines(gz, b); err != nil {
+		a.logger.ErrorContext(r.Context(), "backup cut short", "err", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		a.logger.ErrorContext(r.Context(), "backup cut short", "err", err)
+	}
+}
+
+// writeBackupLines writes b as NDJSON.
+func writeBackupLines(w io.Writer, b store.Backup) error {
+	enc := json.NewEncoder(w)
+	write := func(kind string, data any) error {
+		return enc.Encode(struct {
+			Type string `json:"type"`
+			Data any    `json:"data"`
+		}{kind, data})
+	}
+	if err := write("header", backupHeader{b.Version, b.ExportedAt, b.Currency}); err != nil {
+		return err
+	}
+	for _, t := range b.Tags {
+		if err := write("tag", t); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.Subscriptions {
+		if err := write("subscription", s); err != nil {
+			return err
+		}
+	}
+	for _, budget := range b.Budgets {
+		if err := write("budget", budget); err != nil {
+			return err
+		}
+	}
+	for _, t := range b.Transactions {
+		if err := write("transaction", t); err != nil {
+			return err
+		}
+	}
+	for _, al := range b.Alerts {
+		if err := write("alert", al); err != nil {
+			return err
+		}
+	}
+	for _, hook := range b.Webhooks {
+		if err := write("webhook", hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBackup reads a backup in either format GET /api/backup writes,
+// gzipped or not.
+func readBackup(body io.Reader) (store.Backup, error) {
+	var b store.Backup
+	br := bufio.NewReader(body)
+	var in io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return b, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	limited := &io.LimitedReader{R: in, N: maxBackupBytes + 1}
+	dec := json.NewDecoder(limited)
+
+	var first json.RawMessage
+	err := dec.Decode(&first)
+	if err == nil {
+		var line backupLine
+		if json.Unmarshal(first, &line) == nil && line.Type == "header" {
+			b, err = readBackupLines(dec, line)
+		} else if err = json.Unmarshal(first, &b); err == nil && dec.More() {
+			err = errors.New("unexpected data after the backup")
+		}
+	}
+	if limited.N == 0 {
+		return b, errBackupTooLarge
+	}
+	return b, err
+}
+
+// readBackupLines reads the lines of an NDJSON backup after its header.
+func readBackupLines(dec *json.Decoder, header backupLine) (store.Backup, error) {
+	var h backupHeader
+	if err := json.Unmarshal(header.Data, &h); err != nil {
+		return store.Backup{}, fmt.Errorf("line 1: %w", err)
+	}
+	b := store.Backup{Version: h.Version, ExportedAt: h.ExportedAt, Currency: h.Currency}
+	for n := 2; dec.More(); n++ {
+		var line backupLine
+		if err := dec.Decode(&line); err != nil {
+			return b, fmt.Errorf("line %d: %w", n, err)
+		}
+		var err error
+		switch line.Type {
+		case "tag":
+			err = appendDecoded(line.Data, &b.Tags)
+		case "subscription":
+			err = appendDecoded(line.Data, &b.Subscriptions)
+		case "budget":
+			err = appendDecoded(line.Data, &b.Budgets)
+		case "transaction":
+			err = appendDecoded(line.Data, &b.Transactions)
+		case "alert":
+			err = appendDecoded(line.Data, &b.Alerts)
+		case "webhook":
+			err = appendDecoded(line.Data, &b.Webhooks)
+		default:
+			err = fmt.Errorf("unknown type %q", line.Type)
+		}
+		if err != nil {
+			return b, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return b, nil
+}
+
+func appendDecoded[T any](data json.RawMessage, list *[]T) error {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*list = append(*list, v)
+	return nil
+}
+
+// validateBackup checks everything in b as the endpoints that create each
+// kind of item would, naming fields by their path in the backup, such as
+// subscriptions[2].cost. Subscriptions are stored as validated, with their
+// billing cycle in canonical form.
+func validateBackup(b *store.Backup) fieldErrors {
+	var errs fieldErrors
+	if b.Version != store.BackupVersion {
+		errs.add("version", fmt.Sprintf("must be %d", store.BackupVersion))
+	}
+	if b.Currency != "" && !isCurrencyCode(b.Currency) {
+		errs.add("currency", "must be a three-letter ISO 4217 code such as USD")
+	}
+	for i, tag := range b.Tags {
+		if msg := tagNameError(tag); msg != "" {
+			errs.add(fmt.Sprintf("tags[%d]", i), msg)
+		}
+	}
+	for i := range b.Subscriptions {
+		s := &b.Subscriptions[i]
+		field := func(name string) string { return fmt.Sprintf("subscriptions[%d].%s", i, name) }
+		valid, subErrs := validateSubscription(subscriptionInput{Subscription: s.Subscription, NextBilling: s.NextBilling.String()})
+		for _, e := range subErrs {
+			errs.add(field(e.Field), e.Message)
+		}
+		if s.Status != "" && !slices.Contains(subscriptionStatuses, s.Status) {
+			errs.add(field("status"), "must be one of "+strings.Join(subscriptionStatuses, ", "))
+		}
+		if d := s.ReminderDaysBefore; d != nil && (*d < 0 || *d > maxReminderDays) {
+			errs.add(field("reminderDaysBefore"), fmt.Sprintf("must be between 0 and %d", maxReminderDays))
+		}
+		if c := s.Cancellation; c != nil {
+			for _, e := range validateCancellationInfo(*c) {
+				errs.add(field("cancellation."+e.Field), e.Message)
+			}
+		}
+		for j, e := range s.History {
+			if _, err := models.ParseDate(e.Date); err != nil {
+				errs.add(field(fmt.Sprintf("history[%d].date", j)), "must be a valid date in YYYY-MM-DD format")
+			}
+		}
+		s.Subscription = valid
+	}
+	for i, budget := range b.Budgets {
+		if budget.Category != nil && strings.TrimSpace(*budget.Category) == "" {
+			errs.add(fmt.Sprintf("budgets[%d].category", i), "must not be empty; leave it out for an overall budget")
+		}
+		if budget.Amount <= 0 {
+			errs.add(fmt.Sprintf("budgets[%d].amount", i), "must be greater than 0")
+		}
+		if !isCurrencyCode(budget.Currency) {
+			errs.add(fmt.Sprintf("budgets[%d].currency", i), "must be a three-letter ISO 4217 code such as USD")
+		}
+	}
+	for i, t := range b.Transactions {
+		if t.Source == "" || t.ExternalID == "" {
+			errs.add(fmt.Sprintf("transactions[%d]", i), "needs a source and externalId")
+		}
+		if _, err := models.ParseDate(t.Date); err != nil {
+			errs.add(fmt.Sprintf("transactions[%d].date", i), "must be a valid date in YYYY-MM-DD format")
+		}
+	}
+	for i, al := range b.Alerts {
+		if al.Kind == "" || al.Message == "" || al.DedupeKey == "" {
+			errs.add(fmt.Sprintf("alerts[%d]", i), "needs a kind, message and dedupeKey")
+		}
+	}
+	for i, hook := range b.Webhooks {
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add(fmt.Sprintf("webhooks[%d].url", i), "must be an absolute http or https URL")
+		}
+		if hook.Secret == "" {
+			errs.add(fmt.Sprintf("webhooks[%d].secret", i), "is required")
+		}
+		if len(hook.Events) == 0 {
+			errs.add(fmt.Sprintf("webhooks[%d].events", i), "is required")
+		}
+		for _, e := range hook.Events {
+			if !slices.Contains(webhookEvents, e) {
+				errs.add(fmt.Sprintf("webhooks[%d].events", i), fmt.Sprintf("%q is not one of %s", e, strings.Join(webhookEvents, ", ")))
+			}
+		}
+	}
+	return errs
+}
+
+// quotaError vetoes a restore that would take the user over a quota.
+type quotaError struct {
+	resource string
+	status   QuotaStatus
+}
+
+func (e quotaError) Error() string {
+	return fmt.Sprintf("Quota exceeded for %s: limit is %d", e.resource, *e.status.Limit)
+}
+
+// restoreResponse is what POST /api/restore did with each kind of item.
+type restoreResponse struct {
+	Strategy string `json:"strategy"`
+	store.RestoreResult
+}
+
+// restoreBackup replays a backup from GET /api/backup into the caller's
+// account in one transaction: it all goes in or, on any error, none of it
+// does. ?strategy says what to do with items the account already has:
+// skip them (the default), overwrite them or merge the two; see the store
+// package for what each does to each kind. It's checked against the
+// quotas as a whole, counting only what it adds. Restoring doesn't send
+// webhook events or write the audit log.
+func (a *App) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = store.RestoreSkip
+	}
+	if !slices.Contains(store.RestoreStrategies, strategy) {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "strategy must be one of "+strings.Join(store.RestoreStrategies, ", "))
+		return
+	}
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Backups need a database")
+		return
+	}
+
+	b, err := readBackup(http.MaxBytesReader(w, r.Body, maxBackupBytes))
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) || errors.Is(err, errBackupTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, errBackupTooLarge.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid backup: %v", err))
+		return
+	}
+	if errs := validateBackup(&b); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	// Usage is read before the restore's transaction starts, since SQLite
+	// has the one connection; the transaction then vetoes itself if what
+	// it adds goes over.
+	uid := userID(r)
+	quotas := map[string]QuotaStatus{}
+	for _, resource := range []string{QuotaSubscriptions, QuotaWebhookEndpoints} {
+		if quotas[resource], err = a.quotaStatus(r.Context(), uid, resource); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	check := func(res store.RestoreResult) error {
+		added := map[string]int{QuotaSubscriptions: res.Subscriptions.Created, QuotaWebhookEndpoints: res.Webhooks.Created}
+		for resource, status := range quotas {
+			if status.Limit != nil && status.Used+int64(added[resource]) > *status.Limit {
+				return quotaError{resource, status}
+			}
+		}
+		return nil
+	}
+
+	res, err := store.Restore(r.Context(), a.db, uid, b, strategy, a.clock.Now(), check)
+	var overQuota quotaError
+	if errors.As(err, &overQuota) {
+		writeQuotaExceeded(w, overQuota.resource, overQuota.status)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.invalidateStats(r.Context(), uid)
+	if res.Subscriptions.Created+res.Subscriptions.Updated+res.Budgets.Created+res.Budgets.Updated > 0 {
+		a.checkBudgets(r.Context(), uid)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(restoreResponse{strategy, res}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
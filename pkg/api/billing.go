@@ -0,0 +1,214 @@
+package api
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+const dateLayout = "2006-01-02"
+
+// addCycle moves t forward (or backward for negative n) by n billing cycles.
+// The second return value is false when the cycle is not one we understand.
+func addCycle(t time.Time, cycle string, n int) (time.Time, bool) {
+	r, err := models.ParseRecurrence(cycle)
+	if err != nil {
+		return t, false
+	}
+	return addRecurrence(t, r, n), true
+}
+
+// addRecurrence moves t by n times r. An anchored recurrence lands on its
+// day of the month whatever day t is on, so a subscription charged on the
+// last day goes from February 28 back to March 31.
+func addRecurrence(t time.Time, r models.Recurrence, n int) time.Time {
+	months := r.Interval * n
+	switch r.Unit {
+	case models.UnitWeek:
+		return t.AddDate(0, 0, 7*r.Interval*n)
+	case models.UnitYear:
+		months *= 12
+	}
+	day := t.Day()
+	switch {
+	case r.LastDayOfMonth:
+		day = 31
+	case r.DayOfMonth > 0:
+		day = r.DayOfMonth
+	}
+	return addMonths(t, months, day)
+}
+
+// addMonths moves t forward n months onto the given day, except that a day
+// past the end of the target month becomes its last day: a charge on
+// January 31 is due on February 28, not March 3.
+func addMonths(t time.Time, n, day int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(day, last)-1)
+}
+
+// cycleRRule is the iCalendar recurrence rule for a billing cycle. An
+// anchored day past the 28th is given as the last of the days up to it
+// each month has, since a plain BYMONTHDAY skips the months without it.
+func cycleRRule(cycle string) (string, bool) {
+	r, err := models.ParseRecurrence(cycle)
+	if err != nil {
+		return "", false
+	}
+	freq, interval := "MONTHLY", r.Interval
+	switch {
+	case r.Unit == models.UnitWeek:
+		freq = "WEEKLY"
+	case r.Unit == models.UnitYear && r.Anchored():
+		interval *= 12
+	case r.Unit == models.UnitYear:
+		freq = "YEARLY"
+	}
+	rule := "FREQ=" + freq
+	if interval > 1 {
+		rule += ";INTERVAL=" + strconv.Itoa(interval)
+	}
+	switch day := r.DayOfMonth; {
+	case r.LastDayOfMonth || day == 31:
+		rule += ";BYMONTHDAY=-1"
+	case day > 28:
+		days := []string{}
+		for d := 28; d <= day; d++ {
+			days = append(days, strconv.Itoa(d))
+		}
+		rule += ";BYMONTHDAY=" + strings.Join(days, ",") + ";BYSETPOS=-1"
+	case day > 0:
+		rule += ";BYMONTHDAY=" + strconv.Itoa(day)
+	}
+	return rule, true
+}
+
+// cyclesPerYear is how often a billing cycle charges: charges times every
+// years years, such as 26 times a year or 12 times every 5 years.
+func cyclesPerYear(cycle string) (charges, years int, ok bool) {
+	r, err := models.ParseRecurrence(cycle)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch r.Unit {
+	case models.UnitWeek:
+		charges = 52
+	case models.UnitMonth:
+		charges = 12
+	default:
+		charges = 1
+	}
+	// Reduce 52/2 to 26/1, so the common cycles stay whole numbers.
+	g := gcd(charges, r.Interval)
+	return charges / g, r.Interval / g, true
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// yearlyCost is what a subscription costs over a year. A cycle we don't
+// understand, which only older records can have, counts as monthly.
+func yearlyCost(s models.Subscription) models.Money {
+	charges, years, ok := cyclesPerYear(s.BillingCycle)
+	if !ok {
+		charges, years = 12, 1
+	}
+	total := s.Cost * models.Money(charges)
+	if years == 1 {
+		return total
+	}
+	return models.MoneyFromFloat(total.Float() / float64(years))
+}
+
+// perMonth is a yearly amount spread over twelve months, to the nearest
+// cent.
+func perMonth(yearly models.Money) models.Money {
+	return models.MoneyFromFloat(yearly.Float() / 12)
+}
+
+// roundCents rounds an amount to two decimal places.
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// cycleDays is the approximate length of a billing cycle in days.
+func cycleDays(cycle string) int {
+	r, err := models.ParseRecurrence(cycle)
+	if err != nil {
+		return 0
+	}
+	switch r.Unit {
+	case models.UnitWeek:
+		return 7 * r.Interval
+	case models.UnitMonth:
+		return int(math.Round(float64(r.Interval) * 365 / 12))
+	}
+	return 365 * r.Interval
+}
+
+// nearestBillingDate returns the billing date closest to t, projecting from
+// the known next billing date in either direction.
+func nearestBillingDate(next time.Time, cycle string, t time.Time) (time.Time, bool) {
+	if _, ok := addCycle(next, cycle, 1); !ok {
+		return next, false
+	}
+
+	best := next
+	for i := 1; i < 1000; i++ {
+		var candidate time.Time
+		if t.After(next) {
+			candidate, _ = addCycle(next, cycle, i)
+		} else {
+			candidate, _ = addCycle(next, cycle, -i)
+		}
+		if absDays(candidate.Sub(t)) > absDays(best.Sub(t)) {
+			break
+		}
+		best = candidate
+	}
+	return best, true
+}
+
+func absDays(d time.Duration) int {
+	days := int(d.Hours() / 24)
+	if days < 0 {
+		return -days
+	}
+	return days
+}
+
+// billingDatesBetween lists every billing date in [from, to] for a
+// subscription whose next charge is on next. Unknown cycles only yield next
+// itself, if it falls in range.
+func billingDatesBetween(next time.Time, cycle string, from, to time.Time) []time.Time {
+	if _, ok := addCycle(next, cycle, 1); !ok {
+		if !next.Before(from) && !next.After(to) {
+			return []time.Time{next}
+		}
+		return nil
+	}
+
+	// Walk back to the first date on or after from, then forward to to.
+	start := next
+	n := 0
+	for start.After(from) {
+		n--
+		start, _ = addCycle(next, cycle, n)
+	}
+	var dates []time.Time
+	for d := start; !d.After(to); d, _ = addCycle(next, cycle, n) {
+		if !d.Before(from) {
+			dates = append(dates, d)
+		}
+		n++
+	}
+	return dates
+}
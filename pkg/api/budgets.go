@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// budgetRequest is the body of POST /api/budgets and PUT
+// /api/budgets/{id}. Category is ignored on PUT: a budget stays on the
+// category it was made for.
+type budgetRequest struct {
+	Category *string      `json:"category"`
+	Amount   models.Money `json:"amount"`
+	Currency string       `json:"currency"`
+}
+
+// budgetStatus is a stats entry comparing a budget with normalized monthly
+// spending, both in the stats currency.
+type budgetStatus struct {
+	ID        int          `json:"id"`
+	Category  *string      `json:"category"`
+	Amount    models.Money `json:"amount"`
+	Spent     models.Money `json:"spent"`
+	Remaining models.Money `json:"remaining"`
+	Over      bool         `json:"over"`
+}
+
+// readBudgetRequest decodes and validates a budget body, writing the error
+// and returning false if it's no good. A category, when given, is trimmed
+// and the currency upper-cased.
+func readBudgetRequest(w http.ResponseWriter, r *http.Request) (budgetRequest, bool) {
+	var req budgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return req, false
+	}
+	var errs fieldErrors
+	if req.Category != nil {
+		c := strings.TrimSpace(*req.Category)
+		req.Category = &c
+		if c == "" {
+			errs.add("category", "must not be empty; leave it out for an overall budget")
+		}
+	}
+	if req.Amount <= 0 {
+		errs.add("amount", "must be greater than 0")
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.Currency != "" && !isCurrencyCode(req.Currency) {
+		errs.add("currency", "must be a three-letter ISO 4217 code such as USD")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return req, false
+	}
+	return req, true
+}
+
+// budgets lists the user's budgets, the overall one first and then by
+// category. An App without a database has none.
+func (a *App) budgets(ctx context.Context, userID int) ([]models.Budget, error) {
+	if a.db == nil {
+		return []models.Budget{}, nil
+	}
+	rows, err := a.stmts.QueryContext(ctx, `
+		SELECT id, category, amount_cents, currency FROM budgets
+		WHERE user_id = $1
+		ORDER BY category, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := []models.Budget{}
+	for rows.Next() {
+		var b models.Budget
+		var category string
+		if err := rows.Scan(&b.ID, &category, &b.Amount, &b.Currency); err != nil {
+			return nil, err
+		}
+		if category != "" {
+			b.Category = &category
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+// budget loads one of the user's budgets.
+func (a *App) budget(ctx context.Context, userID, id int) (models.Budget, error) {
+	var b models.Budget
+	var category string
+	err := a.db.QueryRowContext(ctx, "SELECT id, category, amount_cents, currency FROM budgets WHERE id = $1 AND user_id = $2", id, userID).
+		Scan(&b.ID, &category, &b.Amount, &b.Currency)
+	if err == sql.ErrNoRows {
+		return b, store.ErrNotFound
+	}
+	if category != "" {
+		b.Category = &category
+	}
+	return b, err
+}
+
+// budgetSpend is normalized monthly spending, converted by convert:
+// overall, and by category. On error it also returns the currency that
+// couldn't be converted.
+func budgetSpend(subs []models.Subscription, convert func(from string, amount models.Money) (models.Money, error)) (models.Money, map[string]models.Money, string, error) {
+	var total models.Money
+	byCategory := map[string]models.Money{}
+	for _, s := range subs {
+		yearly, err := convert(s.Currency, yearlyCost(s))
+		if err != nil {
+			return 0, nil, s.Currency, err
+		}
+		total += yearly
+		byCategory[s.Category] += yearly
+	}
+	for c, yearly := range byCategory {
+		byCategory[c] = perMonth(yearly)
+	}
+	return perMonth(total), byCategory, "", nil
+}
+
+// budgetStatuses compares each budget with spending, converting both to
+// currency. It returns the currency that couldn't be converted along with
+// the error.
+func (a *App) budgetStatuses(ctx context.Context, budgets []models.Budget, subs []models.Subscription, currency string) ([]budgetStatus, string, error) {
+	statuses := []budgetStatus{}
+	if len(budgets) == 0 {
+		return statuses, "", nil
+	}
+	convert := a.converter(ctx, currency)
+	total, byCategory, from, err := budgetSpend(subs, convert)
+	if err != nil {
+		return nil, from, err
+	}
+	for _, b := range budgets {
+		amount, err := convert(b.Currency, b.Amount)
+		if err != nil {
+			return nil, b.Currency, err
+		}
+		spent := total
+		if b.Category != nil {
+			spent = byCategory[*b.Category]
+		}
+		statuses = append(statuses, budgetStatus{
+			ID:        b.ID,
+			Category:  b.Category,
+			Amount:    amount,
+			Spent:     spent,
+			Remaining: amount - spent,
+			Over:      spent > amount,
+		})
+	}
+	return statuses, "", nil
+}
+
+// checkBudgets raises an alert, and sends budget.exceeded to webhooks, for
+// each budget that normalized monthly spending is now over. Each budget
+// alerts at most once a calendar month, however often it's checked. Like
+// emitEvent it runs after the change that prompted it, so failures are
+// logged rather than returned.
+func (a *App) checkBudgets(ctx context.Context, userID int) {
+	budgets, err := a.budgets(ctx, userID)
+	if err != nil || len(budgets) == 0 {
+		if err != nil {
+			a.logger.WarnContext(ctx, "checking budgets", "err", err)
+		}
+		return
+	}
+	subs, _, err := a.subscriptions.List(ctx, userID, store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		a.logger.WarnContext(ctx, "checking budgets", "err", err)
+		return
+	}
+
+	month := a.clock.Now().Format("2006-01")
+	for _, b := range budgets {
+		total, byCategory, _, err := budgetSpend(subs, a.converter(ctx, b.Currency))
+		if err != nil {
+			a.logger.WarnContext(ctx, "checking budget", "budget", b.ID, "err", err)
+			continue
+		}
+		spent, what := total, "overall"
+		if b.Category != nil {
+			spent, what = byCategory[*b.Category], *b.Category
+		}
+		if spent <= b.Amount {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d:%s", models.AlertBudgetExceeded, b.ID, month)
+		alerted, err := a.alerted(ctx, userID, key)
+		if err != nil || alerted {
+			if err != nil {
+				a.logger.WarnContext(ctx, "checking budget", "budget", b.ID, "err", err)
+			}
+			continue
+		}
+		err = a.raiseAlert(ctx, userID, models.Alert{
+			Kind:    models.AlertBudgetExceeded,
+			Message: fmt.Sprintf("Spending (%s) is %s %s a month, over the budget of %s", what, spent, b.Currency, b.Amount),
+		}, key)
+		if err != nil {
+			a.logger.WarnContext(ctx, "raising budget alert", "budget", b.ID, "err", err)
+			continue
+		}
+		a.emitEvent(ctx, userID, models.EventBudgetExceeded, struct {
+			models.Budget
+			Spent models.Money `json:"spent"`
+		}{b, spent})
+	}
+}
+
+// alerted reports whether the user already has an alert for dedupeKey.
+func (a *App) alerted(ctx context.Context, userID int, dedupeKey string) (bool, error) {
+	var n int
+	err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM alerts WHERE user_id = $1 AND dedupe_key = $2", userID, dedupeKey).Scan(&n)
+	return n > 0, err
+}
+
+// getBudgets lists the user's budgets, the overall one first. How they
+// compare with spending is in the stats.
+func (a *App) getBudgets(w http.ResponseWriter, r *http.Request) {
+	budgets, err := a.budgets(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(budgets); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// createBudget sets a monthly budget for a category, or overall when the
+// category is left out. Each can have one budget; the currency defaults to
+// the user's display currency.
+func (a *App) createBudget(w http.ResponseWriter, r *http.Request) {
+	req, ok := readBudgetRequest(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	b := models.Budget{Category: req.Category, Amount: req.Amount, Currency: req.Currency}
+	if b.Currency == "" {
+		var err error
+		if b.Currency, err = a.userCurrency(r.Context(), uid); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	category := ""
+	if b.Category != nil {
+		category = *b.Category
+	}
+
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO budgets (user_id, category, amount_cents, currency, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, category) DO NOTHING
+		RETURNING id
+	`, uid, category, b.Amount, b.Currency, a.dbNow()).Scan(&b.ID)
+	if err == sql.ErrNoRows {
+		if b.Category == nil {
+			writeError(w, http.StatusConflict, codeConflict, "You already have an overall budget")
+		} else {
+			writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("You already have a budget for %q", category))
+		}
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	a.invalidateStats(r.Context(), uid)
+	a.checkBudgets(r.Context(), uid)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// updateBudget changes a budget's amount and currency, which defaults to
+// the one it had.
+func (a *App) updateBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	req, ok := readBudgetRequest(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	b, err := a.budget(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Budget not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	b.Amount = req.Amount
+	if req.Currency != "" {
+		b.Currency = req.Currency
+	}
+	_, err = a.db.ExecContext(r.Context(), "UPDATE budgets SET amount_cents = $1, currency = $2 WHERE id = $3 AND user_id = $4", b.Amount, b.Currency, id, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	a.invalidateStats(r.Context(), uid)
+	a.checkBudgets(r.Context(), uid)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteBudget removes a budget.
+func (a *App) deleteBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	uid := userID(r)
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM budgets WHERE id = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Budget not found")
+		return
+	}
+	a.invalidateStats(r.Context(), uid)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// maxBulkItems caps how many subscriptions one bulk request may touch.
+const maxBulkItems = 500
+
+// Bulk item statuses.
+const (
+	bulkCreated  = "created"
+	bulkDeleted  = "deleted"
+	bulkInvalid  = "invalid"
+	bulkNotFound = "not_found"
+	// bulkSkipped marks a valid item that wasn't stored because another
+	// item in the same all-or-nothing batch was invalid.
+	bulkSkipped = "skipped"
+)
+
+// bulkResult reports what happened to one item of a bulk request. Index is
+// its position in the request.
+type bulkResult struct {
+	Index        int                  `json:"index"`
+	ID           int                  `json:"id,omitempty"`
+	Status       string               `json:"status"`
+	Error        string               `json:"error,omitempty"`
+	Errors       fieldErrors          `json:"errors,omitempty"`
+	Subscription *models.Subscription `json:"subscription,omitempty"`
+}
+
+type bulkResponse struct {
+	Results []bulkResult `json:"results"`
+}
+
+func writeBulkResponse(w http.ResponseWriter, status int, results []bulkResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(bulkResponse{Results: results}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// bulkCreateSubscriptions inserts an array of subscriptions in one
+// transaction. If any item is invalid nothing is stored, and the 400
+// response says which items need fixing.
+func (a *App) bulkCreateSubscriptions(w http.ResponseWriter, r *http.Request) {
+	var inputs []subscriptionInput
+	if err := json.NewDecoder(r.Body).Decode(&inputs); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if len(inputs) == 0 || len(inputs) > maxBulkItems {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Send between 1 and %d subscriptions", maxBulkItems))
+		return
+	}
+
+	subs := make([]models.Subscription, len(inputs))
+	results := make([]bulkResult, len(inputs))
+	valid := true
+	for i, in := range inputs {
+		in.Subscription = asNew(in.Subscription)
+		results[i] = bulkResult{Index: i, Status: bulkSkipped}
+		var errs fieldErrors
+		if subs[i], errs = validateSubscription(in); len(errs) > 0 {
+			results[i].Status, results[i].Error, results[i].Errors = bulkInvalid, errs.Error(), errs
+			valid = false
+		}
+	}
+	if !valid {
+		writeBulkResponse(w, http.StatusBadRequest, results)
+		return
+	}
+
+	uid := userID(r)
+	if !a.checkQuota(r.Context(), w, uid, QuotaSubscriptions, int64(len(subs))) {
+		return
+	}
+
+	created, err := a.subscriptions.CreateMany(r.Context(), uid, subs, a.clock.Now(), a.subscriptionLimit())
+	if errors.Is(err, store.ErrLimitReached) {
+		a.writeLimitReached(r.Context(), w, uid, QuotaSubscriptions)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for i := range created {
+		results[i] = bulkResult{Index: i, ID: created[i].ID, Status: bulkCreated, Subscription: &created[i]}
+		a.recordAudit(r.Context(), uid, created[i].ID, nil, &created[i])
+		a.emitEvent(r.Context(), uid, models.EventSubscriptionCreated, created[i])
+	}
+	a.checkBudgets(r.Context(), uid)
+	writeBulkResponse(w, http.StatusCreated, results)
+}
+
+// bulkDeleteSubscriptions deletes each ID in {"ids": [...]} and reports
+// per ID whether it was deleted or not found. IDs are independent: a
+// missing one doesn't stop the others.
+func (a *App) bulkDeleteSubscriptions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBulkItems {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Send between 1 and %d ids", maxBulkItems))
+		return
+	}
+
+	uid := userID(r)
+	results := make([]bulkResult, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i] = bulkResult{Index: i, ID: id, Status: bulkDeleted}
+		before, err := a.subscriptions.Get(r.Context(), uid, id)
+		if err == nil {
+			err = a.subscriptions.Delete(r.Context(), uid, id)
+		}
+		if err == store.ErrNotFound {
+			results[i].Status = bulkNotFound
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		} else {
+			a.recordAudit(r.Context(), uid, id, &before, nil)
+			a.emitEvent(r.Context(), uid, models.EventSubscriptionDeleted, deletedSubscription{id})
+			a.purgeAttachments(r.Context(), uid, id)
+		}
+	}
+	writeBulkResponse(w, http.StatusOK, results)
+}
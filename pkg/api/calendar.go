@@ -0,0 +1,186 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// calendarAudience marks feed tokens. Calendar apps poll a URL and can't
+// send an Authorization header, so the feed takes a token in the query
+// string instead. Feed tokens don't expire and only open the feed: they
+// have no expiry, which parseToken requires, and parseCalendarToken
+// requires this audience, which access tokens lack.
+const calendarAudience = "calendar"
+
+func (a *App) issueCalendarToken(id int) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:  strconv.Itoa(id),
+		Audience: jwt.ClaimStrings{calendarAudience},
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.config.JWTSecret))
+}
+
+func (a *App) parseCalendarToken(token string) (int, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return []byte(a.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithAudience(calendarAudience))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(claims.Subject)
+}
+
+// calendarAuth accepts a feed token in ?token= and otherwise falls back to
+// the usual bearer token.
+func (a *App) calendarAuth(next http.Handler) http.Handler {
+	bearer := a.authMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			bearer.ServeHTTP(w, r)
+			return
+		}
+		id, err := a.parseCalendarToken(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid calendar token")
+			return
+		}
+		// Feed tokens don't name a tenant, so the feed is the request
+		// tenant's, if the user is in it.
+		tenant, ok := a.resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		ctx := a.withUser(r.Context(), tenant, id)
+		if a.db != nil {
+			err := a.db.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1", id).Scan(&id)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid calendar token")
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getCalendarLink returns the feed path with a token, for pasting into a
+// calendar app's "subscribe by URL". Anyone with the link can read the
+// feed until JWT_SECRET changes.
+func (a *App) getCalendarLink(w http.ResponseWriter, r *http.Request) {
+	token, err := a.issueCalendarToken(userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Token error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+		"path":  "/api/subscriptions/calendar.ics?token=" + url.QueryEscape(token),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getCalendar serves an iCalendar feed with one all-day event per active
+// subscription, starting on its next billing date and repeating every
+// billing cycle.
+func (a *App) getCalendar(w http.ResponseWriter, r *http.Request) {
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	var ics icsWriter
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("VERSION:2.0")
+	ics.line("PRODID:-//subscription-tracker//EN")
+	ics.line("CALSCALE:GREGORIAN")
+	ics.line("METHOD:PUBLISH")
+	ics.line("X-WR-CALNAME:Subscription renewals")
+	stamp := a.clock.Now().UTC().Format("20060102T150405Z")
+	for _, s := range subs {
+		writeBillingEvent(&ics, s, stamp)
+	}
+	ics.line("END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="subscriptions.ics"`)
+	w.Write([]byte(ics.String()))
+}
+
+func writeBillingEvent(ics *icsWriter, s models.Subscription, stamp string) {
+	if s.NextBilling.IsZero() {
+		return
+	}
+	next := s.NextBilling.Time()
+
+	ics.line("BEGIN:VEVENT")
+	ics.line(fmt.Sprintf("UID:subscription-%d@subscription-tracker", s.ID))
+	ics.line("DTSTAMP:" + stamp)
+	ics.line("DTSTART;VALUE=DATE:" + next.Format("20060102"))
+	ics.line("DTEND;VALUE=DATE:" + next.AddDate(0, 0, 1).Format("20060102"))
+	if rule, ok := cycleRRule(s.BillingCycle); ok {
+		ics.line("RRULE:" + rule)
+	}
+	ics.line("SUMMARY:" + icsEscape(fmt.Sprintf("%s renews (%s %s)", s.Name, s.Cost.String(), s.Currency)))
+	description := s.Category
+	if s.Description != "" {
+		description += "\n" + s.Description
+	}
+	ics.line("DESCRIPTION:" + icsEscape(description))
+	ics.line("CATEGORIES:" + icsEscape(s.Category))
+	ics.line("TRANSP:TRANSPARENT")
+	ics.line("END:VEVENT")
+}
+
+// icsWriter builds iCalendar content with CRLF line endings, folding lines
+// longer than 75 octets as RFC 5545 requires.
+type icsWriter struct {
+	strings.Builder
+}
+
+func (w *icsWriter) line(s string) {
+	// Continuation lines start with a space, which counts toward the limit.
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8Start(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	w.WriteString(s + "\r\n")
+}
+
+// utf8Start reports whether b begins a UTF-8 sequence, so folding never
+// splits a character.
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
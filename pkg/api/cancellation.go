@@ -0,0 +1,310 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	texttemplate "text/template"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/store"
+)
+
+// cancelReminderDays is how many days before a renewal the reminder to
+// cancel is sent.
+const cancelReminderDays = 3
+
+// maxCancellationInstructions caps cancellation instructions, in
+// characters.
+const maxCancellationInstructions = 2000
+
+// notificationCancelReminder is the notifications kind for reminders to
+// cancel, recorded per channel as renewal reminders are.
+const notificationCancelReminder = "cancel_reminder"
+
+var (
+	cancelReminderHTML = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/cancel_reminder.html"))
+	cancelReminderText = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/cancel_reminder.txt"))
+)
+
+// cancellationInfo is the response of GET
+// /api/subscriptions/{id}/cancellation-info. FromCatalog marks a URL
+// taken from the service catalog because none was set. RenewsOn is the
+// next billing date of an active subscription, the date to cancel by.
+type cancellationInfo struct {
+	models.CancellationInfo
+	FromCatalog bool    `json:"fromCatalog"`
+	RenewsOn    *string `json:"renewsOn"`
+}
+
+// cancellation reads what's stored on how to cancel the subscription,
+// which is nothing set if there's no row for it.
+func (a *App) cancellation(ctx context.Context, userID, subscriptionID int) (models.CancellationInfo, error) {
+	var info models.CancellationInfo
+	err := a.db.QueryRowContext(ctx, `
+		SELECT url, instructions, remind_before_renewal FROM cancellation_info WHERE subscription_id = $1 AND user_id = $2
+	`, subscriptionID, userID).Scan(&info.URL, &info.Instructions, &info.RemindBeforeRenewal)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return info, err
+}
+
+// cancelURL is where to cancel s: the URL set for it, or else the
+// catalog's for the service it's named after. It reports whether the URL
+// came from the catalog.
+func (a *App) cancelURL(info models.CancellationInfo, s models.Subscription) (string, bool) {
+	if info.URL != "" {
+		return info.URL, false
+	}
+	if service, ok := a.catalog.Load().Find(s.Name); ok && service.CancelURL != "" {
+		return service.CancelURL, true
+	}
+	return "", false
+}
+
+func (a *App) cancellationInfo(ctx context.Context, s models.Subscription, userID int) (cancellationInfo, error) {
+	stored, err := a.cancellation(ctx, userID, s.ID)
+	if err != nil {
+		return cancellationInfo{}, err
+	}
+	info := cancellationInfo{CancellationInfo: stored}
+	info.URL, info.FromCatalog = a.cancelURL(stored, s)
+	if s.Status == models.StatusActive && !s.NextBilling.IsZero() {
+		date := s.NextBilling.String()
+		info.RenewsOn = &date
+	}
+	return info, nil
+}
+
+// cancellationSubscription loads the subscription in the path if it's the
+// caller's.
+func (a *App) cancellationSubscription(w http.ResponseWriter, r *http.Request) (models.Subscription, bool) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return models.Subscription{}, false
+	}
+	s, err := a.subscriptions.Get(r.Context(), userID(r), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return s, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return s, false
+	}
+	return s, true
+}
+
+func (a *App) writeCancellationInfo(w http.ResponseWriter, r *http.Request, s models.Subscription) {
+	info, err := a.cancellationInfo(r.Context(), s, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getCancellationInfo says how to cancel the subscription, filling in the
+// catalog's cancellation page when none has been set.
+func (a *App) getCancellationInfo(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.cancellationSubscription(w, r)
+	if !ok {
+		return
+	}
+	a.writeCancellationInfo(w, r, s)
+}
+
+// setCancellationInfo replaces the subscription's cancellation URL,
+// instructions and reminder flag. An empty URL goes back to the catalog's.
+func (a *App) setCancellationInfo(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.cancellationSubscription(w, r)
+	if !ok {
+		return
+	}
+	var req models.CancellationInfo
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if errs := validateCancellationInfo(req); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	_, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO cancellation_info (subscription_id, user_id, url, instructions, remind_before_renewal) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subscription_id) DO UPDATE
+		SET url = excluded.url, instructions = excluded.instructions, remind_before_renewal = excluded.remind_before_renewal
+	`, s.ID, userID(r), req.URL, req.Instructions, req.RemindBeforeRenewal)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.writeCancellationInfo(w, r, s)
+}
+
+func validateCancellationInfo(info models.CancellationInfo) fieldErrors {
+	var errs fieldErrors
+	if u, err := url.Parse(info.URL); info.URL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		errs.add("url", "must be an absolute http or https URL")
+	}
+	if len([]rune(info.Instructions)) > maxCancellationInstructions {
+		errs.add("instructions", fmt.Sprintf("must be at most %d characters", maxCancellationInstructions))
+	}
+	return errs
+}
+
+func (a *App) deleteCancellationInfo(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.cancellationSubscription(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM cancellation_info WHERE subscription_id = $1 AND user_id = $2", s.ID, userID(r)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveCatalogCancelURL records the cancellation page of the catalog
+// service a subscription was created from, so it stays with the
+// subscription if it's renamed. A failure is only logged; the catalog is
+// still consulted by name.
+func (a *App) saveCatalogCancelURL(ctx context.Context, userID, subscriptionID int, catalogID string) {
+	service, ok := a.catalog.Load().Get(catalogID)
+	if !ok || service.CancelURL == "" || a.db == nil {
+		return
+	}
+	if _, err := a.db.ExecContext(ctx, `
+		INSERT INTO cancellation_info (subscription_id, user_id, url) VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id) DO NOTHING
+	`, subscriptionID, userID, service.CancelURL); err != nil {
+		a.logger.WarnContext(ctx, "saving the catalog's cancellation page", "subscription", subscriptionID, "err", err)
+	}
+}
+
+// sendCancelReminders reminds users who asked to cancel a subscription
+// before it renews, cancelReminderDays ahead of each renewal, the same
+// way as renewal reminders. It returns how many were sent.
+func (a *App) sendCancelReminders(ctx context.Context, chats map[int][]pushTarget) (int, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT c.subscription_id, c.user_id, c.url, c.instructions, u.email
+		FROM cancellation_info c JOIN users u ON u.id = c.user_id
+		WHERE c.remind_before_renewal AND u.delete_after IS NULL
+		ORDER BY c.subscription_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	type reminder struct {
+		subscriptionID, userID int
+		info                   models.CancellationInfo
+		email                  string
+	}
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.subscriptionID, &r.userID, &r.info.URL, &r.info.Instructions, &r.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := a.clock.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	sent := 0
+	for _, r := range reminders {
+		s, err := a.subscriptions.Get(ctx, r.userID, r.subscriptionID)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		if s.Status != models.StatusActive || s.NextBilling.IsZero() {
+			continue // not renewing
+		}
+		date := s.NextBilling.Time()
+		daysLeft := int(date.Sub(today).Hours() / 24)
+		if daysLeft < 0 || daysLeft > cancelReminderDays {
+			continue
+		}
+
+		data := cancelReminderData{
+			reminderData: reminderData{
+				Name:         s.Name,
+				Category:     s.Category,
+				Description:  s.Description,
+				Cost:         s.Cost.String(),
+				Currency:     s.Currency,
+				BillingCycle: s.BillingCycle,
+				Date:         date.Format("Monday, January 2"),
+				When:         whenText(daysLeft),
+				DaysBefore:   cancelReminderDays,
+			},
+			Instructions: r.info.Instructions,
+		}
+		data.CancelURL, _ = a.cancelURL(r.info, s)
+		n, err := a.deliverReminder(ctx, r.userID, s.ID, r.email, notificationCancelReminder, date, func() (notify.Email, error) {
+			return renderCancelReminder(data)
+		}, cancelReminderPush(data), chats)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// cancelReminderData fills the cancel reminder templates.
+type cancelReminderData struct {
+	reminderData
+	CancelURL, Instructions string
+}
+
+// cancelReminderPush is a reminder to cancel as a chat message.
+func cancelReminderPush(data cancelReminderData) notify.Push {
+	msg := notify.Push{
+		Title: fmt.Sprintf("Cancel %s before it renews %s", data.Name, data.When),
+		Body:  fmt.Sprintf("%s %s will be billed on %s unless it's cancelled.", data.Cost, data.Currency, data.Date),
+		Tag:   "reminder",
+	}
+	if data.CancelURL != "" {
+		msg.Fields = append(msg.Fields, notify.PushField{Name: "Cancel at", Value: data.CancelURL})
+	}
+	if data.Instructions != "" {
+		msg.Fields = append(msg.Fields, notify.PushField{Name: "How", Value: data.Instructions})
+	}
+	return msg
+}
+
+func renderCancelReminder(data cancelReminderData) (notify.Email, error) {
+	var text, html bytes.Buffer
+	if err := cancelReminderText.Execute(&text, data); err != nil {
+		return notify.Email{}, err
+	}
+	if err := cancelReminderHTML.Execute(&html, data); err != nil {
+		return notify.Email{}, err
+	}
+	return notify.Email{
+		Subject:  fmt.Sprintf("Cancel %s before it renews %s", data.Name, data.When),
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/catalog"
+)
+
+// maxCatalogResults caps GET /api/catalog; defaultCatalogResults is what
+// it returns without ?limit.
+const (
+	defaultCatalogResults = 10
+	maxCatalogResults     = 50
+)
+
+// loadCatalog reads the service catalog: the embedded one, with
+// Config.CatalogFile added if it's set.
+func (a *App) loadCatalog() (*catalog.Catalog, error) {
+	return catalog.Load(a.config.CatalogFile)
+}
+
+// getCatalog suggests services from the catalog for a name being typed,
+// best match first: GET /api/catalog?q=net finds Netflix.
+func (a *App) getCatalog(w http.ResponseWriter, r *http.Request) {
+	limit := defaultCatalogResults
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCatalogResults {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCatalogResults))
+			return
+		}
+		limit = n
+	}
+	services := a.catalog.Load().Search(r.URL.Query().Get("q"), limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(services); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) getCatalogService(w http.ResponseWriter, r *http.Request) {
+	service, ok := a.catalog.Load().Get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Service not found in the catalog")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(service); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// reloadCatalog reads the catalog again, so edits to CATALOG_FILE apply
+// without a restart. A file that doesn't load leaves the catalog as it
+// was.
+func (a *App) reloadCatalog(w http.ResponseWriter, r *http.Request) {
+	c, err := a.loadCatalog()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Catalog error: %v", err))
+		return
+	}
+	a.catalog.Store(c)
+	a.logger.InfoContext(r.Context(), "reloaded catalog", "services", c.Len(), "file", a.config.CatalogFile)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"services": c.Len()}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// fillFromCatalog pre-fills a new subscription from the catalog service
+// in.CatalogID and its plan in.Plan, or its first plan: the name and
+// category, and the cost, currency and billing cycle. Only what in leaves
+// out is filled, and the currency only along with the cost.
+func (a *App) fillFromCatalog(in *subscriptionInput) fieldErrors {
+	service, ok := a.catalog.Load().Get(in.CatalogID)
+	if !ok {
+		return fieldErrors{{"catalogId", "isn't a service in the catalog"}}
+	}
+	var plan catalog.Plan
+	switch {
+	case in.Plan != "":
+		if plan, ok = service.Plan(in.Plan); !ok {
+			return fieldErrors{{"plan", fmt.Sprintf("isn't one of the %s plans in the catalog", service.Name)}}
+		}
+	case len(service.Plans) > 0:
+		plan = service.Plans[0]
+	}
+
+	s := &in.Subscription
+	if s.Name == "" {
+		s.Name = service.Name
+	}
+	if s.Category == "" {
+		s.Category = service.Category
+	}
+	if s.Cost == 0 && plan.Cost > 0 {
+		s.Cost = plan.Cost
+		if s.Currency == "" {
+			s.Currency = plan.Currency
+		}
+	}
+	if s.BillingCycle == "" && in.Recurrence == nil && plan.BillingCycle != "" {
+		s.BillingCycle = plan.BillingCycle
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+)
+
+// Config holds every setting the engine reads. Programs embedding the API
+// can fill it in directly; the server binary uses ConfigFromEnv.
+type Config struct {
+	Build BuildInfo
+
+	// JWTSecret signs user access tokens. When it's empty New generates a
+	// random one, so tokens stop working when the process restarts; New
+	// fails rather than sign with a secret it couldn't generate.
+	JWTSecret string
+	// TokenTTL is how long an access token lasts, and RefreshTokenTTL how
+	// long a session can go unused before its refresh token expires.
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+
+	// AdminToken guards /api/admin. Accounts granted the admin role, with
+	// this token at first, can use it with their own access token.
+	AdminToken  string
+	Maintenance bool
+	ReadOnly    bool
+
+	// StaleAfterMonths is how long a subscription can go without being
+	// confirmed by the user or a matched charge before it's reported as stale.
+	StaleAfterMonths int
+
+	// RollForwardInterval is how often passed billing dates are rolled
+	// forward by StartRollForward. Zero turns the job off.
+	RollForwardInterval time.Duration
+
+	// ReminderInterval is how often StartReminders looks for renewals to
+	// email about. Zero turns reminders off.
+	ReminderInterval time.Duration
+
+	// DigestInterval is how often StartDigests looks for users still owed
+	// last month's digest. Zero turns digests off.
+	DigestInterval time.Duration
+
+	// TrialInterval is how often StartTrials looks for trials that have
+	// ended. Zero turns the job off.
+	TrialInterval time.Duration
+
+	// WebhookInterval is how often StartWebhooks retries failed deliveries
+	// and looks for upcoming renewals. New events are sent straight away.
+	// Zero turns webhook delivery off.
+	WebhookInterval time.Duration
+
+	// PoolMonitorInterval is how often StartPoolMonitor checks the
+	// database connection pool for saturation. Zero turns the check off.
+	PoolMonitorInterval time.Duration
+
+	// UsageFlushInterval is how often StartUsage writes the API usage
+	// counted in memory to the database. Zero turns usage tracking off.
+	UsageFlushInterval time.Duration
+
+	// BackupInterval is how often StartBackups backs up every account to
+	// the blob store, under BackupPrefix, keeping the newest
+	// BackupRetention backups. Zero turns the job off, as does having no
+	// blob store.
+	BackupInterval  time.Duration
+	BackupPrefix    string
+	BackupRetention int
+
+	// AccountDeletionGrace is how long an account is kept after its user
+	// asks for it to be deleted, during which logging in cancels the
+	// deletion; zero erases it at once. AccountDeletionInterval is how often
+	// StartAccountDeletion erases the accounts whose grace period is over.
+	// Zero turns the job off.
+	AccountDeletionGrace    time.Duration
+	AccountDeletionInterval time.Duration
+
+	// DuplicateCheck makes creating a subscription that looks like one the
+	// user already has fail with a 409, unless the request is forced.
+	DuplicateCheck bool
+
+	// QuotaLimits caps what a single account may store. Zero means
+	// unlimited, which is the default for self-hosted, single-user installs.
+	QuotaLimits map[string]int64
+
+	// Telemetry is off unless TelemetryEnabled is set and an endpoint is
+	// configured.
+	TelemetryEnabled  bool
+	TelemetryEndpoint string
+	TelemetryInterval time.Duration
+
+	// External integrations; an empty value means not configured.
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+	ExchangeRatesURL string
+	// ExchangeRatesProvider picks where exchange rates come from: "static"
+	// for the ExchangeRatesStatic table, "ecb" or "openexchangerates".
+	// ExchangeRatesURL overrides the provider's endpoint, and fetched
+	// rates are kept for ExchangeRatesTTL.
+	ExchangeRatesProvider string
+	ExchangeRatesAppID    string
+	ExchangeRatesStatic   string
+	ExchangeRatesTTL      time.Duration
+	// RateSnapshotInterval is how often StartRateSnapshots records the
+	// day's rate for every currency in use, so that billing events can be
+	// converted at the rate of their own day.
+	RateSnapshotInterval time.Duration
+	PlaidClientID        string
+	// VAPIDPublicKey and VAPIDPrivateKey, a P-256 key pair in unpadded
+	// URL-safe base64, sign Web Push messages; browsers subscribe with the
+	// public key. VAPIDSubject is the mailto: or https: contact push
+	// services are given.
+	VAPIDPublicKey  string
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+	// Users connect their own Stripe accounts. StripeSyncInterval is how
+	// often StartStripeSync syncs the subscriptions they linked; zero turns
+	// the job off. StripeURL overrides the Stripe API endpoint.
+	StripeSyncInterval time.Duration
+	StripeURL          string
+	// S3Bucket is where uploads and backups are kept. S3Endpoint, empty
+	// for Amazon S3, points at another S3-compatible service such as
+	// MinIO; without an access key, the usual AWS credential sources are
+	// tried.
+	S3Bucket          string
+	S3Endpoint        string
+	S3Region          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// BlobDir keeps uploads and backups in a local directory instead of
+	// S3. MaxAttachmentBytes caps each file attached to a subscription;
+	// zero means 10 MB.
+	BlobDir            string
+	MaxAttachmentBytes int64
+	// CatalogFile is a JSON file of services to add to the built-in
+	// catalog, or to replace ones in it.
+	CatalogFile string
+	// RedisURL, when set, moves the stats cache into Redis so replicas
+	// share it.
+	RedisURL string
+	// StatsCacheTTL is how long a computed /api/stats response is served
+	// again; zero turns the cache off.
+	StatsCacheTTL time.Duration
+	// TenantDomain is the domain tenants are served on subdomains of:
+	// with example.com, acme.example.com is the tenant acme. Requests can
+	// also name their tenant in an X-Tenant header.
+	TenantDomain string
+}
+
+// BuildInfo identifies the running binary in /api/version and telemetry.
+type BuildInfo struct {
+	Version   string
+	GitSHA    string
+	BuildTime string
+}
+
+// ConfigFromEnv reads the configuration from environment variables and
+// validates it. Malformed numbers are reported rather than replaced by the
+// default.
+func ConfigFromEnv() (Config, error) {
+	env := &envReader{}
+	cfg := Config{
+		Build:            BuildInfo{Version: "dev", GitSHA: "unknown", BuildTime: "unknown"},
+		JWTSecret:        os.Getenv("JWT_SECRET"),
+		TokenTTL:         time.Duration(env.int("JWT_TTL_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL:  time.Duration(env.int("REFRESH_TOKEN_TTL_DAYS", 30)) * 24 * time.Hour,
+		AdminToken:       os.Getenv("ADMIN_TOKEN"),
+		Maintenance:      os.Getenv("MAINTENANCE_MODE") == "true",
+		ReadOnly:         os.Getenv("READ_ONLY") == "true",
+		StaleAfterMonths: env.int("STALE_AFTER_MONTHS", 6),
+		DuplicateCheck:   os.Getenv("DUPLICATE_CHECK") == "true",
+		QuotaLimits: map[string]int64{
+			QuotaSubscriptions:    int64(env.int("QUOTA_MAX_SUBSCRIPTIONS", 0)),
+			QuotaAttachmentBytes:  int64(env.int("QUOTA_MAX_ATTACHMENT_BYTES", 0)),
+			QuotaWebhookEndpoints: int64(env.int("QUOTA_MAX_WEBHOOKS", 0)),
+		},
+		TelemetryEnabled:        os.Getenv("TELEMETRY_ENABLED") == "true",
+		TelemetryEndpoint:       os.Getenv("TELEMETRY_ENDPOINT"),
+		TelemetryInterval:       time.Duration(env.int("TELEMETRY_INTERVAL_HOURS", 24)) * time.Hour,
+		RollForwardInterval:     time.Duration(env.int("ROLL_FORWARD_INTERVAL_MINUTES", 60)) * time.Minute,
+		ReminderInterval:        time.Duration(env.int("REMINDER_INTERVAL_MINUTES", 60)) * time.Minute,
+		DigestInterval:          time.Duration(env.int("DIGEST_INTERVAL_MINUTES", 60)) * time.Minute,
+		TrialInterval:           time.Duration(env.int("TRIAL_INTERVAL_MINUTES", 60)) * time.Minute,
+		WebhookInterval:         time.Duration(env.int("WEBHOOK_INTERVAL_SECONDS", 30)) * time.Second,
+		PoolMonitorInterval:     time.Duration(env.int("POOL_MONITOR_INTERVAL_SECONDS", 60)) * time.Second,
+		UsageFlushInterval:      time.Duration(env.int("USAGE_FLUSH_SECONDS", 60)) * time.Second,
+		BackupInterval:          time.Duration(env.int("BACKUP_INTERVAL_HOURS", 24)) * time.Hour,
+		BackupPrefix:            envString("BACKUP_PREFIX", "backups/"),
+		BackupRetention:         env.int("BACKUP_RETENTION", 7),
+		AccountDeletionGrace:    time.Duration(env.int("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour,
+		AccountDeletionInterval: time.Duration(env.int("ACCOUNT_DELETION_INTERVAL_MINUTES", 60)) * time.Minute,
+		SMTPHost:                os.Getenv("SMTP_HOST"),
+		SMTPPort:                env.int("SMTP_PORT", 587),
+		SMTPUsername:            os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:            os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:                os.Getenv("SMTP_FROM"),
+		ExchangeRatesURL:        os.Getenv("EXCHANGE_RATES_URL"),
+		ExchangeRatesProvider:   os.Getenv("EXCHANGE_RATES_PROVIDER"),
+		ExchangeRatesAppID:      os.Getenv("EXCHANGE_RATES_APP_ID"),
+		ExchangeRatesStatic:     os.Getenv("EXCHANGE_RATES_STATIC"),
+		ExchangeRatesTTL:        time.Duration(env.int("EXCHANGE_RATES_CACHE_HOURS", 12)) * time.Hour,
+		RateSnapshotInterval:    time.Duration(env.int("EXCHANGE_RATES_SNAPSHOT_HOURS", 24)) * time.Hour,
+		PlaidClientID:           os.Getenv("PLAID_CLIENT_ID"),
+		VAPIDPublicKey:          os.Getenv("VAPID_PUBLIC_KEY"),
+		VAPIDPrivateKey:         os.Getenv("VAPID_PRIVATE_KEY"),
+		VAPIDSubject:            os.Getenv("VAPID_SUBJECT"),
+		StripeSyncInterval:      time.Duration(env.int("STRIPE_SYNC_INTERVAL_HOURS", 6)) * time.Hour,
+		StripeURL:               os.Getenv("STRIPE_API_URL"),
+		S3Bucket:                os.Getenv("S3_BUCKET"),
+		S3Endpoint:              os.Getenv("S3_ENDPOINT"),
+		S3Region:                os.Getenv("S3_REGION"),
+		S3AccessKeyID:           os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:       os.Getenv("S3_SECRET_ACCESS_KEY"),
+		BlobDir:                 os.Getenv("BLOB_DIR"),
+		MaxAttachmentBytes:      int64(env.int("ATTACHMENT_MAX_MB", 10)) << 20,
+		CatalogFile:             os.Getenv("CATALOG_FILE"),
+		RedisURL:                os.Getenv("REDIS_URL"),
+		StatsCacheTTL:           time.Duration(env.int("STATS_CACHE_SECONDS", 60)) * time.Second,
+		TenantDomain:            strings.TrimPrefix(os.Getenv("TENANT_DOMAIN"), "."),
+	}
+	// ADMIN_EMAILS used to grant the admin role to whoever signed up with
+	// those emails first. Failing is safer than ignoring it unnoticed.
+	if os.Getenv("ADMIN_EMAILS") != "" {
+		env.errs = append(env.errs, errors.New("ADMIN_EMAILS is no longer supported; grant the admin role with PUT /api/admin/users/{id}/admin"))
+	}
+	if len(env.errs) > 0 {
+		return cfg, errors.Join(env.errs...)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports settings the engine can't run with.
+func (c Config) Validate() error {
+	var errs []error
+	if c.TokenTTL <= 0 {
+		errs = append(errs, errors.New("token TTL must be positive"))
+	}
+	if c.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("refresh token TTL must be positive"))
+	}
+	if c.StaleAfterMonths < 1 {
+		errs = append(errs, errors.New("stale threshold must be at least one month"))
+	}
+	for resource, limit := range c.QuotaLimits {
+		if limit < 0 {
+			errs = append(errs, fmt.Errorf("quota for %s must not be negative", resource))
+		}
+	}
+	if c.RollForwardInterval < 0 {
+		errs = append(errs, errors.New("roll-forward interval must not be negative"))
+	}
+	if c.ReminderInterval < 0 {
+		errs = append(errs, errors.New("reminder interval must not be negative"))
+	}
+	if c.DigestInterval < 0 {
+		errs = append(errs, errors.New("digest interval must not be negative"))
+	}
+	if c.TrialInterval < 0 {
+		errs = append(errs, errors.New("trial interval must not be negative"))
+	}
+	if c.WebhookInterval < 0 {
+		errs = append(errs, errors.New("webhook interval must not be negative"))
+	}
+	if c.PoolMonitorInterval < 0 {
+		errs = append(errs, errors.New("pool monitor interval must not be negative"))
+	}
+	if c.UsageFlushInterval < 0 {
+		errs = append(errs, errors.New("usage flush interval must not be negative"))
+	}
+	if c.BackupInterval < 0 {
+		errs = append(errs, errors.New("backup interval must not be negative"))
+	}
+	if c.BackupRetention < 1 {
+		errs = append(errs, errors.New("backup retention must be at least 1"))
+	}
+	if c.AccountDeletionGrace < 0 {
+		errs = append(errs, errors.New("account deletion grace period must not be negative"))
+	}
+	if c.AccountDeletionInterval < 0 {
+		errs = append(errs, errors.New("account deletion interval must not be negative"))
+	}
+	if c.S3Endpoint != "" {
+		if u, err := url.Parse(c.S3Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("S3_ENDPOINT %q must be an http or https URL", c.S3Endpoint))
+		}
+	}
+	if (c.S3AccessKeyID == "") != (c.S3SecretAccessKey == "") {
+		errs = append(errs, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together"))
+	}
+	if c.S3Bucket != "" && c.BlobDir != "" {
+		errs = append(errs, errors.New("S3_BUCKET and BLOB_DIR can't both be set"))
+	}
+	if c.MaxAttachmentBytes < 0 {
+		errs = append(errs, errors.New("attachment size limit must not be negative"))
+	}
+	if c.SMTPHost != "" && c.SMTPFrom == "" {
+		errs = append(errs, errors.New("SMTP_FROM is required when SMTP_HOST is set"))
+	}
+	if c.SMTPHost != "" && (c.SMTPPort < 1 || c.SMTPPort > 65535) {
+		errs = append(errs, fmt.Errorf("SMTP port %d is out of range", c.SMTPPort))
+	}
+	if (c.VAPIDPublicKey == "") != (c.VAPIDPrivateKey == "") {
+		errs = append(errs, errors.New("VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY must be set together"))
+	}
+	if c.VAPIDPublicKey != "" && !strings.HasPrefix(c.VAPIDSubject, "mailto:") && !strings.HasPrefix(c.VAPIDSubject, "https://") {
+		errs = append(errs, errors.New("VAPID_SUBJECT must be a mailto: or https: URL when the VAPID keys are set"))
+	}
+	if c.StripeSyncInterval < 0 {
+		errs = append(errs, errors.New("Stripe sync interval must not be negative"))
+	}
+	switch c.ExchangeRatesProvider {
+	case "":
+		if c.ExchangeRatesURL != "" {
+			errs = append(errs, errors.New("EXCHANGE_RATES_URL is set but EXCHANGE_RATES_PROVIDER isn't"))
+		}
+	case "static":
+		if _, err := rates.ParseTable(models.DefaultCurrency, c.ExchangeRatesStatic); err != nil {
+			errs = append(errs, fmt.Errorf("EXCHANGE_RATES_STATIC: %w", err))
+		}
+	case "ecb":
+	case "openexchangerates":
+		if c.ExchangeRatesAppID == "" {
+			errs = append(errs, errors.New("EXCHANGE_RATES_APP_ID is required for openexchangerates"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown exchange rate provider %q; use static, ecb or openexchangerates", c.ExchangeRatesProvider))
+	}
+	if c.ExchangeRatesProvider != "" && c.ExchangeRatesProvider != "static" && c.ExchangeRatesTTL <= 0 {
+		errs = append(errs, errors.New("exchange rate cache time must be positive"))
+	}
+	if c.RateSnapshotInterval < 0 {
+		errs = append(errs, errors.New("exchange rate snapshot interval must not be negative"))
+	}
+	if c.RedisURL != "" {
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_URL: %w", err))
+		}
+	}
+	if c.StatsCacheTTL < 0 {
+		errs = append(errs, errors.New("stats cache time must not be negative"))
+	}
+	if c.TelemetryEnabled && c.TelemetryInterval <= 0 {
+		errs = append(errs, errors.New("telemetry interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// envReader parses settings from the environment, collecting an error for
+// each malformed value.
+type envReader struct {
+	errs []error
+}
+
+// int reads an integer setting, falling back to def when it's unset.
+func (e *envReader) int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a number", key, v))
+		return def
+	}
+	return n
+}
+
+// envString reads a setting, falling back to def when it's unset.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (c Config) telemetryActive() bool {
+	return c.TelemetryEnabled && c.TelemetryEndpoint != ""
+}
@@ -0,0 +1,458 @@
+//go:build integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// Contract tests pin the JSON shape of every endpoint. Each case records
+// the status code and a type skeleton of the body (keys and value types,
+// never values) in testdata/golden/<name>.json, and checks the response
+// against its operation in openapi.json: the status has to be documented
+// and the body has to match its schema. To accept an intentional change,
+// rerun with:
+//
+//	go test -tags integration -run TestContracts -update ./pkg/api
+
+var updateGolden = flag.Bool("update", false, "rewrite golden contract files")
+
+type contractCase struct {
+	name   string
+	method string
+	path   string
+	body   any
+	admin  bool
+	// anonymous sends the request without a token.
+	anonymous bool
+	// setup prepares fixtures and may return a path to use instead of path,
+	// e.g. one containing a freshly created ID.
+	setup func(h *harness) string
+	// loose lists dotted paths whose contents vary between runs; they're
+	// recorded only as "object".
+	loose []string
+}
+
+// withWebhook registers a webhook for every event and returns its ID.
+func withWebhook(h *harness) int {
+	var hook models.Webhook
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": "https://hooks.example.com/subscriptions"}, http.StatusCreated, &hook)
+	return hook.ID
+}
+
+func withTaggedNetflix(h *harness) string {
+	s := netflixFixture()
+	s.Tags = []string{"shared", "trial"}
+	h.createSubscription(s)
+	return ""
+}
+
+// withBudget sets an overall budget of 30 a month.
+func withBudget(h *harness) string {
+	h.doJSON("POST", "/api/budgets", map[string]any{"amount": 30}, http.StatusCreated, nil)
+	return ""
+}
+
+func withNetflix(h *harness) string {
+	h.createSubscription(netflixFixture())
+	return ""
+}
+
+// firstVersion is s as an update of a subscription just created sends it.
+func firstVersion(s models.Subscription) models.Subscription {
+	s.Version = 1
+	return s
+}
+
+// withUsage makes a request and flushes the usage it counted.
+func withUsage(h *harness) string {
+	withNetflix(h)
+	if err := h.app.FlushUsage(context.Background()); err != nil {
+		h.t.Fatal(err)
+	}
+	return ""
+}
+
+func withMatchedCharge(h *harness) string {
+	h.createSubscription(netflixFixture())
+	h.importTransactions(bankTransaction("t1", "NETFLIX.COM", -1549, "2025-05-12"))
+	return ""
+}
+
+func withReviewCandidate(h *harness) []models.MatchCandidate {
+	h.createSubscription(models.Subscription{Name: "Disney Plus", Category: "Entertainment", Cost: 799, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20")})
+	h.createSubscription(models.Subscription{Name: "Disney Bundle", Category: "Entertainment", Cost: 1399, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20")})
+	h.importTransactions(bankTransaction("t1", "DISNEY", -1099, "2025-04-28"))
+
+	var queue []models.MatchCandidate
+	h.doJSON("GET", "/api/matches/review", nil, http.StatusOK, &queue)
+	return queue
+}
+
+var contractCases = []contractCase{
+	{name: "health", method: "GET", path: "/api/health"},
+	{name: "dbcheck", method: "GET", path: "/api/dbcheck"},
+	{name: "status", method: "GET", path: "/api/status", loose: []string{"integrations"}},
+	{name: "version", method: "GET", path: "/api/version"},
+
+	{name: "auth_signup", method: "POST", path: "/api/auth/signup", anonymous: true,
+		body: credentials{Email: "new@example.com", Password: testPassword}},
+	{name: "auth_login", method: "POST", path: "/api/auth/login", anonymous: true,
+		body: credentials{Email: testEmail, Password: testPassword}},
+	{name: "auth_login_invalid", method: "POST", path: "/api/auth/login", anonymous: true,
+		body: credentials{Email: testEmail, Password: "wrong-password"}},
+	{name: "auth_refresh_invalid", method: "POST", path: "/api/auth/refresh", anonymous: true,
+		body: refreshRequest{RefreshToken: "rt_unknown"}},
+	{name: "sessions_list", method: "GET", path: "/api/sessions"},
+	{name: "usage", method: "GET", path: "/api/usage", setup: withUsage},
+	{name: "me", method: "GET", path: "/api/me"},
+	{name: "me_patch", method: "PATCH", path: "/api/me", body: map[string]any{"currency": "EUR"}},
+	{name: "subscriptions_unauthorized", method: "GET", path: "/api/subscriptions", anonymous: true},
+
+	{name: "subscriptions_list", method: "GET", path: "/api/subscriptions", setup: withNetflix},
+	{name: "subscriptions_create", method: "POST", path: "/api/subscriptions", body: netflixFixture()},
+	{name: "subscriptions_create_invalid", method: "POST", path: "/api/subscriptions", body: map[string]any{}},
+	{name: "subscriptions_bulk_create", method: "POST", path: "/api/subscriptions/bulk",
+		body: []models.Subscription{netflixFixture(), spotifyFixture()}},
+	{name: "subscriptions_bulk_create_invalid", method: "POST", path: "/api/subscriptions/bulk",
+		body: []models.Subscription{netflixFixture(), {Name: "Broken"}}},
+	{name: "subscriptions_bulk_delete", method: "DELETE", path: "/api/subscriptions/bulk", setup: withNetflix,
+		body: map[string]any{"ids": []int{1, 999}}},
+	{name: "subscriptions_get", method: "GET", setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "")
+	}},
+	{name: "subscriptions_get_missing", method: "GET", path: "/api/subscriptions/999"},
+	{name: "subscriptions_update", method: "PUT", body: firstVersion(netflixFixture()), setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "")
+	}},
+	{name: "subscriptions_patch", method: "PATCH", body: map[string]any{"cost": 12.99, "version": 1}, setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "")
+	}},
+	{name: "subscriptions_patch_invalid", method: "PATCH", body: map[string]any{"cost": -1, "version": 1}, setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "")
+	}},
+	{name: "subscriptions_delete", method: "DELETE", setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "")
+	}},
+	{name: "subscriptions_verify", method: "POST", setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "/verify")
+	}},
+	{name: "subscriptions_history", method: "GET", setup: func(h *harness) string {
+		id := h.createSubscription(netflixFixture()).ID
+		h.clock.Set(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+		if _, err := h.app.rollForward(context.Background()); err != nil {
+			h.t.Fatal(err)
+		}
+		return subscriptionPath(id, "/history")
+	}},
+	{name: "subscriptions_reminder_put", method: "PUT", body: map[string]any{"daysBefore": 3}, setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "/reminder")
+	}},
+	{name: "subscriptions_reminder_invalid", method: "PUT", body: map[string]any{"daysBefore": 45}, setup: func(h *harness) string {
+		return subscriptionPath(h.createSubscription(netflixFixture()).ID, "/reminder")
+	}},
+
+	{name: "stats", method: "GET", path: "/api/stats", setup: withNetflix},
+	{name: "forecast", method: "GET", path: "/api/forecast?months=2", setup: withNetflix},
+	{name: "subscriptions_pause", method: "POST", path: "/api/subscriptions/1/pause", setup: withNetflix},
+	{name: "subscriptions_cancel", method: "POST", path: "/api/subscriptions/1/cancel", setup: withNetflix,
+		body: map[string]any{"date": "2025-05-31", "reason": "Too expensive"}},
+	{name: "subscriptions_resume_active", method: "POST", path: "/api/subscriptions/1/resume", setup: withNetflix},
+	{name: "subscriptions_search", method: "GET", path: "/api/subscriptions/search?q=standard", setup: withNetflix},
+	{name: "subscriptions_search_empty", method: "GET", path: "/api/subscriptions/search?q="},
+	{name: "subscriptions_create_duplicate", method: "POST", path: "/api/subscriptions", body: netflixFixture(), setup: func(h *harness) string {
+		h.app.config.DuplicateCheck = true
+		return withNetflix(h)
+	}},
+	{name: "subscriptions_duplicates", method: "GET", path: "/api/subscriptions/duplicates", setup: func(h *harness) string {
+		h.createSubscription(netflixFixture())
+		return withNetflix(h)
+	}},
+	{name: "trials", method: "GET", path: "/api/trials", setup: func(h *harness) string {
+		s, ends := netflixFixture(), "2025-05-05"
+		s.IsTrial, s.TrialEndsAt = true, &ends
+		h.createSubscription(s)
+		return ""
+	}},
+
+	{name: "budgets_list", method: "GET", path: "/api/budgets", setup: withBudget},
+	{name: "budgets_create", method: "POST", path: "/api/budgets", body: map[string]any{"category": "Entertainment", "amount": 20}},
+	{name: "budgets_create_invalid", method: "POST", path: "/api/budgets", body: map[string]any{"amount": -5, "currency": "dollars"}},
+	{name: "budgets_create_duplicate", method: "POST", path: "/api/budgets", setup: withBudget, body: map[string]any{"amount": 40}},
+	{name: "budgets_update", method: "PUT", path: "/api/budgets/1", setup: withBudget, body: map[string]any{"amount": 40}},
+	{name: "budgets_delete", method: "DELETE", path: "/api/budgets/1", setup: withBudget},
+	{name: "stats_budgets", method: "GET", path: "/api/stats", setup: func(h *harness) string {
+		withBudget(h)
+		return withNetflix(h)
+	}},
+	{name: "limits", method: "GET", path: "/api/me/limits"},
+	{name: "calendar_link", method: "GET", path: "/api/me/calendar"},
+	{name: "calendar_feed", method: "GET", path: "/api/subscriptions/calendar.ics", setup: withNetflix},
+	{name: "telemetry_preview", method: "GET", path: "/api/telemetry/preview", loose: []string{"payload.features"}},
+
+	{name: "transactions_import", method: "POST", path: "/api/transactions", setup: withNetflix,
+		body: []models.Transaction{bankTransaction("t1", "NETFLIX.COM", -1549, "2025-05-12")}},
+	{name: "transactions_list", method: "GET", path: "/api/transactions", setup: withMatchedCharge},
+	{name: "transactions_rematch", method: "POST", path: "/api/transactions/match"},
+	{name: "transactions_sync_unconfigured", method: "POST", path: "/api/transactions/sync"},
+	{name: "matches_review", method: "GET", path: "/api/matches/review", setup: func(h *harness) string {
+		withReviewCandidate(h)
+		return ""
+	}},
+	{name: "matches_accept", method: "POST", setup: func(h *harness) string {
+		return "/api/matches/" + strconv.Itoa(withReviewCandidate(h)[0].ID) + "/accept"
+	}},
+	{name: "matches_reject", method: "POST", setup: func(h *harness) string {
+		return "/api/matches/" + strconv.Itoa(withReviewCandidate(h)[0].ID) + "/reject"
+	}},
+	{name: "reconciliation", method: "GET", path: "/api/reconciliation?month=2025-05", setup: withMatchedCharge},
+
+	{name: "alerts", method: "GET", path: "/api/alerts", setup: func(h *harness) string {
+		h.importTransactions(
+			bankTransaction("t1", "ACME CLOUD BACKUP", -499, "2025-03-05"),
+			bankTransaction("t2", "ACME CLOUD BACKUP", -499, "2025-04-05"),
+		)
+		return ""
+	}},
+	{name: "alerts_dismiss_missing", method: "POST", path: "/api/alerts/999/dismiss"},
+
+	{name: "webhooks_create", method: "POST", path: "/api/webhooks",
+		body: map[string]any{"url": "https://hooks.example.com/subscriptions", "events": []string{"subscription.created"}}},
+	{name: "webhooks_create_invalid", method: "POST", path: "/api/webhooks", body: map[string]any{"url": "hooks.example.com"}},
+	{name: "webhooks_list", method: "GET", path: "/api/webhooks", setup: func(h *harness) string {
+		withWebhook(h)
+		return ""
+	}},
+	{name: "webhooks_deliveries", method: "GET", setup: func(h *harness) string {
+		id := withWebhook(h)
+		h.createSubscription(netflixFixture())
+		return "/api/webhooks/" + strconv.Itoa(id) + "/deliveries"
+	}},
+	{name: "webhooks_delete", method: "DELETE", setup: func(h *harness) string {
+		return "/api/webhooks/" + strconv.Itoa(withWebhook(h))
+	}},
+
+	{name: "audit_list", method: "GET", path: "/api/audit", setup: withNetflix},
+	{name: "audit_invalid", method: "GET", path: "/api/audit?action=rename"},
+	{name: "backup", method: "GET", path: "/api/backup", setup: func(h *harness) string {
+		withTaggedNetflix(h)
+		withBudget(h)
+		withWebhook(h)
+		return ""
+	}},
+	{name: "restore", method: "POST", path: "/api/restore?strategy=merge", setup: withNetflix,
+		body: store.Backup{Version: store.BackupVersion, Subscriptions: []store.BackupSubscription{{Subscription: netflixFixture()}, {Subscription: spotifyFixture()}}}},
+	{name: "restore_invalid", method: "POST", path: "/api/restore", body: store.Backup{Version: store.BackupVersion, Subscriptions: []store.BackupSubscription{{}}}},
+	{name: "subscriptions_prices", method: "GET", setup: func(h *harness) string {
+		s := h.createSubscription(netflixFixture())
+		h.patchSubscription(s.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+		return subscriptionPath(s.ID, "/prices")
+	}},
+	{name: "subscriptions_audit", method: "GET", path: "/api/subscriptions/1/audit", setup: withNetflix},
+
+	{name: "tags_list", method: "GET", path: "/api/tags", setup: withTaggedNetflix},
+	{name: "tags_create", method: "POST", path: "/api/tags", body: map[string]any{"name": "Work"}},
+	{name: "tags_create_duplicate", method: "POST", path: "/api/tags", setup: withTaggedNetflix,
+		body: map[string]any{"name": "shared"}},
+	{name: "tags_create_invalid", method: "POST", path: "/api/tags", body: map[string]any{"name": "a,b"}},
+	{name: "tags_rename", method: "PUT", path: "/api/tags/1", setup: withTaggedNetflix,
+		body: map[string]any{"name": "family"}},
+	{name: "tags_delete", method: "DELETE", path: "/api/tags/1", setup: withTaggedNetflix},
+
+	{name: "admin_maintenance_get", method: "GET", path: "/api/admin/maintenance", admin: true},
+	{name: "admin_maintenance_set", method: "PUT", path: "/api/admin/maintenance", admin: true,
+		body: MaintenanceState{Enabled: true, Message: "Upgrading"}},
+	{name: "admin_maintenance_forbidden", method: "GET", path: "/api/admin/maintenance"},
+	{name: "admin_read_only_get", method: "GET", path: "/api/admin/read-only", admin: true},
+	{name: "admin_read_only_set", method: "PUT", path: "/api/admin/read-only", admin: true,
+		body: ReadOnlyState{Enabled: true, Reason: "failover"}},
+	{name: "admin_usage", method: "GET", path: "/api/admin/usage", admin: true, setup: withUsage},
+	{name: "admin_dashboard", method: "GET", path: "/api/admin/dashboard", admin: true},
+	{name: "admin_users", method: "GET", path: "/api/admin/users", admin: true, setup: withNetflix},
+	{name: "admin_user_impersonate", method: "POST", path: "/api/admin/users/1/impersonate", admin: true},
+}
+
+// golden is what's stored per case.
+type golden struct {
+	Status int `json:"status"`
+	Body   any `json:"body"`
+}
+
+func TestContracts(t *testing.T) {
+	for _, c := range contractCases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newHarness(t)
+			path := c.path
+			if c.setup != nil {
+				if p := c.setup(h); p != "" {
+					path = p
+				}
+			}
+			if c.admin {
+				h = h.asAdmin()
+			}
+			if c.anonymous {
+				h = h.anonymous()
+			}
+
+			resp, data := h.do(c.method, path, c.body)
+			got := golden{Status: resp.StatusCode, Body: bodyShape(t, resp, data, c.loose)}
+			assertGolden(t, c.name, got)
+			if err := validateResponse(resp, data); err != nil {
+				t.Errorf("response doesn't match openapi.json: %v", err)
+			}
+		})
+	}
+}
+
+// specRouter finds the operations of openapi.json, loaded once.
+var specRouter = sync.OnceValues(func() (routers.Router, error) {
+	for _, ct := range []string{"text/calendar", "text/csv", "text/html", "application/pdf"} {
+		openapi3filter.RegisterBodyDecoder(ct, openapi3filter.FileBodyDecoder)
+	}
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+	return gorillamux.NewRouter(doc)
+})
+
+// validateResponse checks a response against the operation in openapi.json
+// its request was for: its status must be documented and its headers and
+// body must match. Bodies that aren't JSON are only checked for their
+// content type.
+func validateResponse(resp *http.Response, body []byte) error {
+	router, err := specRouter()
+	if err != nil {
+		return err
+	}
+	route, params, err := router.FindRoute(resp.Request)
+	if err != nil {
+		return err
+	}
+	in := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: resp.Request, PathParams: params, Route: route},
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true, MultiError: true},
+	}
+	in.SetBodyBytes(body)
+	return openapi3filter.ValidateResponse(resp.Request.Context(), in)
+}
+
+// bodyShape reduces a response body to its type skeleton. Non-JSON bodies
+// are recorded by content type only.
+func bodyShape(t *testing.T, resp *http.Response, data []byte, loose []string) any {
+	if len(data) == 0 {
+		return nil
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/json") && !strings.HasPrefix(ct, problemContentType) {
+		return "text"
+	}
+
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("response is not valid JSON: %v\n%s", err, data)
+	}
+	return shapeOf(v, "", loose)
+}
+
+// shapeOf replaces every value with its JSON type name. Arrays are
+// represented by the shape of their first element.
+func shapeOf(v any, path string, loose []string) any {
+	for _, l := range loose {
+		if path == l {
+			return "object"
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		shape := map[string]any{}
+		for k, x := range v {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			shape[k] = shapeOf(x, child, loose)
+		}
+		return shape
+	case []any:
+		if len(v) == 0 {
+			return []any{}
+		}
+		return []any{shapeOf(v[0], path, loose)}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+func assertGolden(t *testing.T, name string, got golden) {
+	t.Helper()
+
+	file := filepath.Join("testdata", "golden", name+".json")
+	gotJSON, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateGolden {
+		// Round-trip through a map so keys are written sorted, the same as
+		// the comparison below and hand-edited files.
+		var norm any
+		json.Unmarshal(gotJSON, &norm)
+		gotJSON, _ = json.MarshalIndent(norm, "", "  ")
+		gotJSON = append(gotJSON, '\n')
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, gotJSON, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	wantJSON, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("missing golden file %s (run with -update to create it): %v", file, err)
+	}
+	// Compare semantically so formatting of hand-edited files doesn't matter.
+	var want, gotNorm any
+	if err := json.Unmarshal(wantJSON, &want); err != nil {
+		t.Fatalf("invalid golden file %s: %v", file, err)
+	}
+	json.Unmarshal(gotJSON, &gotNorm)
+	wantNorm, _ := json.MarshalIndent(want, "", "  ")
+	gotOut, _ := json.MarshalIndent(gotNorm, "", "  ")
+	if string(wantNorm) != string(gotOut) {
+		t.Errorf("response contract changed for %s\n--- want\n%s\n--- got\n%s", name, wantNorm, gotOut)
+	}
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// maxImportBytes caps the size of an uploaded CSV file.
+const maxImportBytes = 10 << 20
+
+// csvColumn is a field a CSV import reads, and the header names that map
+// to it by default. Headers are compared after normalizeHeader.
+type csvColumn struct {
+	field    string
+	required bool
+	aliases  []string
+}
+
+// csvColumns are the subscription fields.
+var csvColumns = []csvColumn{
+	{"name", true, []string{"name", "service", "subscription"}},
+	{"category", true, []string{"category", "type"}},
+	{"cost", true, []string{"cost", "price", "amount"}},
+	{"currency", false, []string{"currency"}},
+	{"billingCycle", true, []string{"billingcycle", "cycle", "billing", "frequency"}},
+	{"nextBilling", true, []string{"nextbilling", "nextbillingdate", "nextpayment", "renewal", "renews"}},
+	{"description", false, []string{"description", "notes"}},
+	{"tags", false, []string{"tags", "labels"}},
+}
+
+// normalizeHeader folds case and drops spaces, underscores and dashes, so
+// "Next Billing", "next_billing" and "nextBilling" are the same column.
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(h)))
+}
+
+// csvMapping resolves each of columns to a column index in header. A query
+// parameter column.<field>=<header> picks a column explicitly; otherwise
+// the column's aliases are tried.
+func csvMapping(header []string, r *http.Request, columns []csvColumn) (map[string]int, error) {
+	index := map[string]int{}
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff") // byte order mark from Excel
+		}
+		if _, dup := index[normalizeHeader(h)]; !dup {
+			index[normalizeHeader(h)] = i
+		}
+	}
+
+	mapping := map[string]int{}
+	for _, c := range columns {
+		if want := r.URL.Query().Get("column." + c.field); want != "" {
+			i, ok := index[normalizeHeader(want)]
+			if !ok {
+				return nil, fmt.Errorf("column %q for %s is not in the header", want, c.field)
+			}
+			mapping[c.field] = i
+			continue
+		}
+		for _, alias := range c.aliases {
+			if i, ok := index[alias]; ok {
+				mapping[c.field] = i
+				break
+			}
+		}
+		if _, ok := mapping[c.field]; !ok && c.required {
+			return nil, fmt.Errorf("no column for %s; name one with ?column.%s=<header>", c.field, c.field)
+		}
+	}
+	return mapping, nil
+}
+
+// parseCSVSubscription builds a subscription from one record and
+// validates it like any other.
+func parseCSVSubscription(record []string, mapping map[string]int) (models.Subscription, error) {
+	get := func(field string) string {
+		if i, ok := mapping[field]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	s := models.Subscription{
+		Name:         get("name"),
+		Category:     get("category"),
+		Currency:     strings.ToUpper(get("currency")),
+		BillingCycle: get("billingCycle"),
+		Description:  get("description"),
+	}
+	// Tags are comma-separated within their cell.
+	for _, tag := range strings.Split(get("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	if raw := get("cost"); raw != "" {
+		cost, err := models.ParseMoney(strings.ReplaceAll(strings.TrimLeft(raw, "$€£"), ",", ""))
+		if err != nil {
+			return s, fmt.Errorf("cost %q is not a number", raw)
+		}
+		s.Cost = cost
+	}
+	s, errs := validateSubscription(subscriptionInput{Subscription: s, NextBilling: get("nextBilling")})
+	if len(errs) > 0 {
+		return s, errs
+	}
+	return s, nil
+}
+
+type csvImportRow struct {
+	Line  int    `json:"line"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type csvImportReport struct {
+	Inserted []csvImportRow `json:"inserted"`
+	Errors   []csvImportRow `json:"errors"`
+}
+
+// uploadedFile finds the "file" part of a multipart upload of at most
+// maxBytes, writing the error if there's none.
+func uploadedFile(w http.ResponseWriter, r *http.Request, maxBytes int64) (*multipart.Part, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Expected a multipart/form-data upload")
+		return nil, false
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading upload: %v", err))
+			return nil, false
+		}
+		if part.FormName() == "file" {
+			return part, true
+		}
+	}
+	writeError(w, http.StatusBadRequest, codeBadRequest, `Missing "file" field`)
+	return nil, false
+}
+
+// importSubscriptionsCSV reads the "file" part of a multipart upload as
+// CSV, one subscription per row after the header. The file is read row by
+// row as it arrives, never held in memory as a whole. Valid rows are
+// stored even if others fail; the report lists both by line number.
+func (a *App) importSubscriptionsCSV(w http.ResponseWriter, r *http.Request) {
+	file, ok := uploadedFile(w, r, maxImportBytes)
+	if !ok {
+		return
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading CSV header: %v", err))
+		return
+	}
+	mapping, err := csvMapping(header, r, csvColumns)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	uid := userID(r)
+	quota, err := a.quotaStatus(r.Context(), uid, QuotaSubscriptions)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	report := csvImportReport{Inserted: []csvImportRow{}, Errors: []csvImportRow{}}
+	now := a.clock.Now()
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Errors = append(report.Errors, csvImportRow{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("File is larger than %d bytes", maxImportBytes))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading upload: %v", err))
+			return
+		}
+		line, _ := reader.FieldPos(0)
+
+		s, err := parseCSVSubscription(record, mapping)
+		if err != nil {
+			report.Errors = append(report.Errors, csvImportRow{Line: line, Error: err.Error()})
+			continue
+		}
+		if quota.Limit != nil && quota.Used >= *quota.Limit {
+			report.Errors = append(report.Errors, csvImportRow{Line: line, Error: "subscription quota exceeded"})
+			continue
+		}
+		s, err = a.subscriptions.Create(r.Context(), uid, s, now, a.subscriptionLimit())
+		if errors.Is(err, store.ErrLimitReached) {
+			report.Errors = append(report.Errors, csvImportRow{Line: line, Error: "subscription quota exceeded"})
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		quota.Used++
+		a.recordAudit(r.Context(), uid, s.ID, nil, &s)
+		a.emitEvent(r.Context(), uid, models.EventSubscriptionCreated, s)
+		report.Inserted = append(report.Inserted, csvImportRow{Line: line, ID: s.ID})
+	}
+	a.checkBudgets(r.Context(), uid)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
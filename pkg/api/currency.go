@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+)
+
+// userCurrency is the currency the user has chosen to see totals in. An
+// App without a database has no user records, so it's always the default.
+func (a *App) userCurrency(ctx context.Context, userID int) (string, error) {
+	if a.db == nil {
+		return models.DefaultCurrency, nil
+	}
+	var currency string
+	err := a.stmts.QueryRowContext(ctx, "SELECT currency FROM users WHERE id = $1", userID).Scan(&currency)
+	return currency, err
+}
+
+// statsCurrency reads the currency to report in: ?currency if given, else
+// the user's display currency.
+func (a *App) statsCurrency(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if v := r.URL.Query().Get("currency"); v != "" {
+		v = strings.ToUpper(v)
+		if !isCurrencyCode(v) {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "currency must be a three-letter ISO 4217 code such as USD")
+			return "", false
+		}
+		return v, true
+	}
+	currency, err := a.userCurrency(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return "", false
+	}
+	return currency, true
+}
+
+// converter returns a function converting amounts to currency, to the
+// nearest cent. Each rate is looked up once, however many amounts use it.
+func (a *App) converter(ctx context.Context, currency string) func(from string, amount models.Money) (models.Money, error) {
+	cache := map[string]float64{}
+	return func(from string, amount models.Money) (models.Money, error) {
+		if from == currency {
+			return amount, nil
+		}
+		rate, ok := cache[from]
+		if !ok {
+			var err error
+			rate, err = a.rates.Rate(ctx, from, currency)
+			if !errors.Is(err, ErrNotConfigured) && !errors.Is(err, rates.ErrUnknownCurrency) {
+				a.integrations.report("exchange_rates", err)
+			}
+			if err != nil {
+				return 0, err
+			}
+			cache[from] = rate
+		}
+		return models.MoneyFromFloat(amount.Float() * rate), nil
+	}
+}
+
+// writeConversionError reports why an amount in from couldn't be shown in
+// to.
+func (a *App) writeConversionError(w http.ResponseWriter, from, to string, err error) {
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured,
+			fmt.Sprintf("Exchange rates are not configured, so amounts in %s can't be shown in %s", from, to))
+	case errors.Is(err, rates.ErrUnknownCurrency):
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("No exchange rate from %s to %s", from, to))
+	default:
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Exchange rate error: %v", err))
+	}
+}
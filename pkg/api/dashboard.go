@@ -0,0 +1,377 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// Dashboard is the instance overview GET /api/admin/dashboard returns.
+type Dashboard struct {
+	Users               int           `json:"users"`
+	Subscriptions       int           `json:"subscriptions"`
+	ActiveSubscriptions int           `json:"activeSubscriptions"`
+	Database            DatabaseStats `json:"database"`
+	Jobs                []JobStatus   `json:"jobs"`
+}
+
+// DatabaseStats describes the database: its engine, applied schema version
+// and size on disk.
+type DatabaseStats struct {
+	Driver        string `json:"driver"`
+	SchemaVersion int    `json:"schemaVersion"`
+	SizeBytes     int64  `json:"sizeBytes"`
+}
+
+// AdminUser is an account as the admin API lists it, with its tenant's
+// slug, how much it stores and how many devices are signed in to it.
+type AdminUser struct {
+	models.User
+	Tenant              string `json:"tenant"`
+	Admin               bool   `json:"admin"`
+	Subscriptions       int    `json:"subscriptions"`
+	ActiveSubscriptions int    `json:"activeSubscriptions"`
+	Sessions            int    `json:"sessions"`
+}
+
+// getDashboard reports how many accounts and subscriptions there are, the
+// database's size and the background jobs' status.
+func (a *App) getDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d := Dashboard{Jobs: a.jobs.statuses()}
+	err := a.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM subscriptions),
+			(SELECT COUNT(*) FROM subscriptions WHERE status = $1)
+	`, models.StatusActive).Scan(&d.Users, &d.Subscriptions, &d.ActiveSubscriptions)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	d.Database.Driver = string(store.DriverOf(a.db))
+	if d.Database.SchemaVersion, err = store.AppliedVersion(ctx, a.db); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if d.Database.SizeBytes, err = store.Size(ctx, a.db); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// adminUserColumns selects an AdminUser, in scanAdminUser's order, from
+// users aliased u.
+const adminUserColumns = `
+	u.id, u.email, u.created_at, u.currency, u.totp_enabled, u.monthly_digest,
+	u.tenant_id, (SELECT t.slug FROM tenants t WHERE t.id = u.tenant_id), u.is_admin,
+	(SELECT COUNT(*) FROM subscriptions s WHERE s.user_id = u.id),
+	(SELECT COUNT(*) FROM subscriptions s WHERE s.user_id = u.id AND s.status = 'active'),
+	(SELECT COUNT(*) FROM sessions s WHERE s.user_id = u.id AND s.expires_at > $1)`
+
+func (a *App) scanAdminUser(row interface{ Scan(...any) error }) (AdminUser, error) {
+	var u AdminUser
+	var createdAt time.Time
+	var tenant int
+	var granted bool
+	if err := row.Scan(&u.ID, &u.Email, &createdAt, &u.Currency, &u.TwoFactorEnabled, &u.MonthlyDigest, &tenant, &u.Tenant, &granted, &u.Subscriptions, &u.ActiveSubscriptions, &u.Sessions); err != nil {
+		return u, err
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+	u.Admin = isAdminUser(granted, tenant)
+	return u, nil
+}
+
+// getAdminUsers lists the accounts, oldest first, paginated. ?email=
+// narrows them to emails containing it.
+func (a *App) getAdminUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+	email := "%" + strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email"))) + "%"
+
+	var total int
+	if err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE email LIKE $1`, email).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	rows, err := a.db.QueryContext(ctx, `SELECT `+adminUserColumns+`
+		FROM users u WHERE u.email LIKE $2
+		ORDER BY u.id LIMIT $3 OFFSET $4
+	`, sessionNow(), email, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		u, err := a.scanAdminUser(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	page := models.Page[AdminUser]{Items: users, Total: total, Limit: limit, Offset: offset}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getAdminUser returns one account.
+func (a *App) getAdminUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	a.writeAdminUser(w, r, id)
+}
+
+func (a *App) writeAdminUser(w http.ResponseWriter, r *http.Request, id int) {
+	u, err := a.scanAdminUser(a.db.QueryRowContext(r.Context(), "SELECT "+adminUserColumns+" FROM users u WHERE u.id = $2", sessionNow(), id))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// impersonation is the response of POST
+// /api/admin/users/{id}/impersonate.
+type impersonation struct {
+	Token     string      `json:"token"`
+	ExpiresIn int         `json:"expiresIn"`
+	User      models.User `json:"user"`
+}
+
+// impersonateUser issues an access token for the user, so support can see
+// the app as they do. It belongs to no session: it can't be refreshed,
+// doesn't show in the user's sessions and stops working when it expires.
+// Every impersonation is recorded in the admin audit log with who asked
+// for it, and no token is issued if it can't be.
+func (a *App) impersonateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	u := models.User{ID: id}
+	var createdAt time.Time
+	err = a.db.QueryRowContext(r.Context(), "SELECT email, created_at, currency, totp_enabled, monthly_digest, tenant_id FROM users WHERE id = $1", id).
+		Scan(&u.Email, &createdAt, &u.Currency, &u.TwoFactorEnabled, &u.MonthlyDigest, &u.TenantID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+
+	if err := a.recordAdminAction(r, adminImpersonate, id, u.Email); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	token, err := a.issueToken(id, u.TenantID, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Token error: %v", err))
+		return
+	}
+	a.logger.WarnContext(r.Context(), "admin impersonating user", "user_id", id, "admin_user_id", userID(r))
+
+	resp := impersonation{Token: token, ExpiresIn: int(a.config.TokenTTL / time.Second), User: u}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// setAdminRole grants the admin role to the account, or takes it away,
+// with {"admin": true} or {"admin": false}, and returns the account. Only
+// accounts in the default tenant can be given it. The change is recorded
+// in the admin audit log.
+func (a *App) setAdminRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	var req struct {
+		Admin *bool `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.Admin == nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "admin is required")
+		return
+	}
+
+	var email string
+	var tenant int
+	err = a.db.QueryRowContext(r.Context(), "SELECT email, tenant_id FROM users WHERE id = $1", id).Scan(&email, &tenant)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if *req.Admin && tenant != defaultTenant {
+		writeError(w, http.StatusConflict, codeConflict, "Only accounts in the default tenant can be admins")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "UPDATE users SET is_admin = $1 WHERE id = $2", *req.Admin, id); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	action := adminRevoke
+	if *req.Admin {
+		action = adminGrant
+	}
+	if err := a.recordAdminAction(r, action, id, email); err != nil {
+		a.logger.WarnContext(r.Context(), "recording admin action", "action", action, "user_id", id, "err", err)
+	}
+	a.writeAdminUser(w, r, id)
+}
+
+// Admin audit actions.
+const (
+	adminImpersonate = "impersonate"
+	adminGrant       = "grant_admin"
+	adminRevoke      = "revoke_admin"
+)
+
+// AdminAuditEntry records one thing an admin did to an account. ActorID
+// and Actor, the admin's email, are null when it was done with the admin
+// token.
+type AdminAuditEntry struct {
+	ID        int     `json:"id"`
+	Action    string  `json:"action"`
+	ActorID   *int    `json:"actorId"`
+	Actor     *string `json:"actor"`
+	UserID    int     `json:"userId"`
+	Email     string  `json:"email"`
+	CreatedAt string  `json:"createdAt"`
+}
+
+// recordAdminAction adds action on the account to the admin audit log,
+// with the admin user behind r as its actor.
+func (a *App) recordAdminAction(r *http.Request, action string, targetID int, targetEmail string) error {
+	var actor *int
+	if id := userID(r); id != 0 {
+		actor = &id
+	}
+	_, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO admin_audit_log (actor_id, action, target_user_id, target_email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, actor, action, targetID, targetEmail, a.dbNow())
+	return err
+}
+
+// getAdminAudit returns one page of the admin audit log, newest first,
+// narrowed to one account with ?userId.
+func (a *App) getAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	// -1 matches every account; IDs start at 1.
+	target := -1
+	if v := r.URL.Query().Get("userId"); v != "" {
+		if target, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "userId must be a number")
+			return
+		}
+	}
+	ctx := r.Context()
+
+	var total int
+	err = a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM admin_audit_log WHERE $1 = -1 OR target_user_id = $1", target).Scan(&total)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT l.id, l.action, l.actor_id, u.email, l.target_user_id, l.target_email, l.created_at
+		FROM admin_audit_log l LEFT JOIN users u ON u.id = l.actor_id
+		WHERE $1 = -1 OR l.target_user_id = $1
+		ORDER BY l.id DESC LIMIT $2 OFFSET $3
+	`, target, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	entries := []AdminAuditEntry{}
+	for rows.Next() {
+		var e AdminAuditEntry
+		var actorID sql.NullInt64
+		var actor sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.Action, &actorID, &actor, &e.UserID, &e.Email, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			e.ActorID = &id
+		}
+		if actor.Valid {
+			e.Actor = &actor.String
+		}
+		e.CreatedAt = createdAt.Format(time.RFC3339)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	page := models.Page[AdminAuditEntry]{Items: entries, Total: total, Limit: limit, Offset: offset}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
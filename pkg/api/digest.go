@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"slices"
+	texttemplate "text/template"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/store"
+)
+
+// digestUpcomingDays is how far ahead a digest lists renewals.
+const digestUpcomingDays = 30
+
+var (
+	digestHTML = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/digest.html"))
+	digestText = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/digest.txt"))
+)
+
+// digestLine is one subscription in a digest.
+type digestLine struct {
+	Name, Cost, Currency, BillingCycle, Date string
+}
+
+// digestData fills the digest templates. Change compares Total with the
+// month before, and is empty when that had no charges.
+type digestData struct {
+	Month, Currency, Total, Change string
+	Charges                        int
+	Upcoming, Added, Cancelled     []digestLine
+}
+
+// StartDigests emails last month's digest to every user who asked for
+// one, now and then every DigestInterval, until ctx is cancelled. It does
+// nothing unless mail is configured.
+func (a *App) StartDigests(ctx context.Context) {
+	if _, off := a.mailer.(unconfigured); off || a.config.DigestInterval <= 0 || a.db == nil {
+		return
+	}
+
+	a.jobs.started("digests", a.config.DigestInterval)
+	go func() {
+		defer a.jobs.stopped("digests")
+		ticker := time.NewTicker(a.config.DigestInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.sendDigests(ctx); err != nil {
+				a.logger.Warn("sending monthly digests", "err", err)
+			} else if n > 0 {
+				a.logger.Info("sent monthly digests", "count", n)
+			}
+			a.jobs.ran("digests")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sendDigests mails last month's digest to each user who opted in and
+// hasn't been sent it yet, unless nothing was billed, added or cancelled
+// that month. As with reminders, the month is claimed in digests before
+// sending and given back if the send fails, so each user gets it once. It
+// returns how many were sent.
+func (a *App) sendDigests(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	now := a.clock.Now()
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, -1, 0)
+	month := start.Format("2006-01")
+
+	type recipient struct {
+		id              int
+		email, currency string
+	}
+	var recipients []recipient
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, email, currency FROM users
+		WHERE monthly_digest AND delete_after IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var r recipient
+		if err := rows.Scan(&r.id, &r.email, &r.currency); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		recipients = append(recipients, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range recipients {
+		var claimed int
+		err := a.db.QueryRowContext(ctx, `
+			INSERT INTO digests (user_id, month, sent_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, month) DO NOTHING
+			RETURNING user_id
+		`, r.id, month, a.dbNow()).Scan(&claimed)
+		if err == sql.ErrNoRows {
+			continue // already sent
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		data, err := a.digest(ctx, r.id, r.currency, start)
+		if err == nil && data.Charges == 0 && len(data.Added) == 0 && len(data.Cancelled) == 0 {
+			continue // nothing to tell; the claim stays so it isn't checked again
+		}
+		var msg notify.Email
+		if err == nil {
+			if msg, err = renderDigest(data); err == nil {
+				msg.To = []string{r.email}
+				err = a.mailer.Send(ctx, msg)
+				a.integrations.report("smtp", err)
+			}
+		}
+		if err != nil {
+			a.logger.WarnContext(ctx, "sending monthly digest", "user", r.id, "month", month, "err", err)
+			if _, err := a.db.ExecContext(ctx, "DELETE FROM digests WHERE user_id = $1 AND month = $2", r.id, month); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// digest summarizes the calendar month from start for userID: what was
+// billed, in currency, against the month before, the subscriptions added
+// and cancelled in it, and what renews in the coming days.
+func (a *App) digest(ctx context.Context, userID int, currency string, start time.Time) (digestData, error) {
+	end := start.AddDate(0, 1, 0)
+	data := digestData{Month: start.Format("January 2006"), Currency: currency}
+
+	spent, unconverted, err := a.billedSpending(ctx, userID, currency, start.AddDate(0, -1, 0), 2)
+	if unconverted != "" {
+		return data, fmt.Errorf("converting %s to %s: %w", unconverted, currency, err)
+	}
+	if err != nil {
+		return data, err
+	}
+	previous, current := spent.Months[0], spent.Months[1]
+	data.Total, data.Charges = current.Total.String(), current.Charges
+	before := start.AddDate(0, -1, 0).Format("January")
+	switch diff := current.Total - previous.Total; {
+	case previous.Charges == 0:
+	case diff > 0:
+		data.Change = fmt.Sprintf("%s %s more than in %s", diff, currency, before)
+	case diff < 0:
+		data.Change = fmt.Sprintf("%s %s less than in %s", diff.Abs(), currency, before)
+	default:
+		data.Change = "the same as in " + before
+	}
+
+	subs, _, err := a.subscriptions.List(ctx, userID, store.SubscriptionQuery{})
+	if err != nil {
+		return data, err
+	}
+	today := models.DateOf(a.clock.Now())
+	until := today.AddDate(0, 0, digestUpcomingDays)
+	var upcoming []models.Subscription
+	for _, s := range subs {
+		line := digestLine{Name: s.Name, Cost: s.Cost.String(), Currency: s.Currency, BillingCycle: s.BillingCycle}
+		if created, err := time.Parse(time.RFC3339, s.CreatedAt); err == nil && !created.Before(start) && created.Before(end) {
+			data.Added = append(data.Added, line)
+		}
+		if s.Status == models.StatusCancelled && s.CancelledAt != nil {
+			if cancelled, err := models.ParseDate(*s.CancelledAt); err == nil && !cancelled.Time().Before(start) && cancelled.Time().Before(end) {
+				line.Date = cancelled.Time().Format("January 2")
+				data.Cancelled = append(data.Cancelled, line)
+			}
+		}
+		if s.Status == models.StatusActive && !s.NextBilling.IsZero() && !s.NextBilling.Before(today) && !s.NextBilling.After(until) {
+			upcoming = append(upcoming, s)
+		}
+	}
+	slices.SortStableFunc(upcoming, func(x, y models.Subscription) int { return cmp.Compare(x.NextBilling.String(), y.NextBilling.String()) })
+	for _, s := range upcoming {
+		data.Upcoming = append(data.Upcoming, digestLine{Name: s.Name, Cost: s.Cost.String(), Currency: s.Currency, BillingCycle: s.BillingCycle, Date: s.NextBilling.Time().Format("Monday, January 2")})
+	}
+	return data, nil
+}
+
+func renderDigest(data digestData) (notify.Email, error) {
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, data); err != nil {
+		return notify.Email{}, err
+	}
+	if err := digestHTML.Execute(&html, data); err != nil {
+		return notify.Email{}, err
+	}
+	return notify.Email{
+		Subject:  "Your subscriptions in " + data.Month,
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
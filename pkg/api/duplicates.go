@@ -0,0 +1,184 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// Two subscriptions look like duplicates when their normalized names are
+// at least duplicateSimilarity alike and their costs, in the same
+// currency, are within duplicateCostTolerance of each other.
+const (
+	duplicateSimilarity    = 0.5
+	duplicateCostTolerance = 0.1
+)
+
+// duplicateCandidate is an existing subscription that a new one may
+// duplicate.
+type duplicateCandidate struct {
+	models.Subscription
+	Similarity float64 `json:"similarity"`
+}
+
+// duplicatePair is two existing subscriptions that look like the same one.
+type duplicatePair struct {
+	Subscriptions [2]models.Subscription `json:"subscriptions"`
+	Similarity    float64                `json:"similarity"`
+}
+
+// normalizeName lower-cases a subscription name and keeps only its words,
+// so "Netflix (Premium)" and "netflix premium" compare equal.
+func normalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// trigrams is the set of three-letter runs in a normalized name, counting
+// each word padded with two spaces in front and one behind, as pg_trgm
+// does, so short words and word starts still count.
+func trigrams(name string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(name) {
+		r := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// trigramSimilarity is the share of trigrams two names have in common, from
+// 0 for nothing shared to 1 for the same words.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(normalizeName(a)), trigrams(normalizeName(b))
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// duplicateSimilarityOf reports how alike the names of a and b are, and
+// whether they're alike enough, at a close enough cost, to be duplicates.
+func duplicateSimilarityOf(a, b models.Subscription) (float64, bool) {
+	if a.Currency != b.Currency {
+		return 0, false
+	}
+	if (a.Cost - b.Cost).Abs().Float() > duplicateCostTolerance*max(a.Cost, b.Cost).Float() {
+		return 0, false
+	}
+	sim := trigramSimilarity(a.Name, b.Name)
+	return roundSimilarity(sim), sim >= duplicateSimilarity
+}
+
+// roundSimilarity keeps two decimal places, which is all the precision a
+// trigram ratio is worth.
+func roundSimilarity(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// duplicateCandidates lists the subscriptions, other than cancelled ones,
+// the user has that s may duplicate, most alike first.
+func (a *App) duplicateCandidates(ctx context.Context, uid int, s models.Subscription) ([]duplicateCandidate, error) {
+	subs, _, err := a.subscriptions.List(ctx, uid, store.SubscriptionQuery{})
+	if err != nil {
+		return nil, err
+	}
+	if s.Currency == "" {
+		s.Currency = models.DefaultCurrency // as the store will save it
+	}
+	candidates := []duplicateCandidate{}
+	for _, existing := range subs {
+		if existing.Status == models.StatusCancelled {
+			continue
+		}
+		if sim, ok := duplicateSimilarityOf(s, existing); ok {
+			a.setStale(&existing)
+			candidates = append(candidates, duplicateCandidate{Subscription: existing, Similarity: sim})
+		}
+	}
+	slices.SortStableFunc(candidates, func(x, y duplicateCandidate) int { return cmp.Compare(y.Similarity, x.Similarity) })
+	return candidates, nil
+}
+
+// checkDuplicates writes a 409 listing the candidates and returns false
+// when duplicate checking is on and s looks like a subscription the user
+// already has, unless the request says ?force=true.
+func (a *App) checkDuplicates(w http.ResponseWriter, r *http.Request, uid int, s models.Subscription) bool {
+	if !a.config.DuplicateCheck {
+		return true
+	}
+	if v := r.URL.Query().Get("force"); v != "" {
+		force, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "force must be true or false")
+			return false
+		}
+		if force {
+			return true
+		}
+	}
+
+	candidates, err := a.duplicateCandidates(r.Context(), uid, s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return false
+	}
+	if len(candidates) == 0 {
+		return true
+	}
+	writeProblem(w, problem{
+		Status: http.StatusConflict,
+		Code:   codeDuplicate,
+		Detail: fmt.Sprintf("%s looks like a subscription you already have; send it again with ?force=true to add it anyway", s.Name),
+		Extra:  map[string]any{"duplicates": candidates},
+	})
+	return false
+}
+
+// getDuplicates lists pairs of the user's subscriptions, other than
+// cancelled ones, that look like the same subscription, most alike first,
+// whether or not duplicate checking is on.
+func (a *App) getDuplicates(w http.ResponseWriter, r *http.Request) {
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), store.SubscriptionQuery{})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	subs = slices.DeleteFunc(subs, func(s models.Subscription) bool { return s.Status == models.StatusCancelled })
+	for i := range subs {
+		a.setStale(&subs[i])
+	}
+
+	pairs := []duplicatePair{}
+	for i := range subs {
+		for j := i + 1; j < len(subs); j++ {
+			if sim, ok := duplicateSimilarityOf(subs[i], subs[j]); ok {
+				pairs = append(pairs, duplicatePair{Subscriptions: [2]models.Subscription{subs[i], subs[j]}, Similarity: sim})
+			}
+		}
+	}
+	slices.SortStableFunc(pairs, func(x, y duplicatePair) int { return cmp.Compare(y.Similarity, x.Similarity) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pairs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
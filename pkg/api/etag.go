@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// etagOf is the strong entity tag of a response body.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// etag. With weak set, W/ tags compare equal to their strong form, as
+// If-None-Match requires; If-Match never matches a weak tag.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTagged writes v as JSON with its ETag, or just a 304 when the
+// request's If-None-Match already names it.
+func writeTagged(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		return
+	}
+	body = append(body, '\n')
+	writeTaggedBody(w, r, body, etagOf(body))
+}
+
+// writeTaggedBody writes a JSON body with etag, or just a 304 when the
+// request's If-None-Match already names it.
+func writeTaggedBody(w http.ResponseWriter, r *http.Request, body []byte, etag string) {
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// subscriptionETag is the ETag of s as stored. It leaves out Stale, which
+// changes as time passes without s changing.
+func subscriptionETag(s models.Subscription) (string, error) {
+	s.Stale = false
+	body, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return etagOf(body), nil
+}
+
+// writeSubscription writes s, marked stale or not, with its ETag.
+func (a *App) writeSubscription(w http.ResponseWriter, r *http.Request, s models.Subscription) {
+	etag, err := subscriptionETag(s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		return
+	}
+	a.setStale(&s)
+	body, err := json.Marshal(s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		return
+	}
+	writeTaggedBody(w, r, append(body, '\n'), etag)
+}
+
+// setSubscriptionETag sends the ETag of the stored subscription after an
+// update. It's read back because the store may hand dates back in another
+// form than they were written, and the tag must be the one a GET returns.
+func (a *App) setSubscriptionETag(w http.ResponseWriter, r *http.Request, uid, id int) {
+	s, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err != nil {
+		return
+	}
+	if etag, err := subscriptionETag(s); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// checkIfMatch guards an update of current against a lost update: when
+// the request has an If-Match header that doesn't name current's ETag it
+// writes a 412 and returns false, and a missing header is a 428 unless the
+// body is versioned, sending the version it expects instead. conditional
+// reports whether the request named a tag, in which case the caller should
+// only write if current is still the stored version.
+func (a *App) checkIfMatch(w http.ResponseWriter, r *http.Request, current models.Subscription, versioned bool) (conditional, ok bool) {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		if !versioned {
+			writeError(w, http.StatusPreconditionRequired, codePreconditionRequired, "Updates must send the subscription's version, or its ETag in If-Match")
+			return false, false
+		}
+		return false, true
+	}
+	etag, err := subscriptionETag(current)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		return false, false
+	}
+	if !etagMatches(im, etag, false) {
+		writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, "The subscription has changed since it was fetched")
+		return false, false
+	}
+	return true, true
+}
+
+// writeVersionConflict answers an update that expected another version of
+// the subscription than current with a 409 carrying current, so the client
+// can show what changed and reapply its edit on top.
+func writeVersionConflict(w http.ResponseWriter, current models.Subscription) {
+	writeProblem(w, problem{
+		Status: http.StatusConflict,
+		Code:   codeVersionConflict,
+		Detail: fmt.Sprintf("The subscription is at version %d now", current.Version),
+		Extra:  map[string]any{"current": current},
+	})
+}
+
+// writeUpdateConflict answers an update whose expected version was taken
+// by another write between reading the subscription and storing it: a 404
+// if that write deleted it and otherwise a 409 with what it's now.
+func (a *App) writeUpdateConflict(w http.ResponseWriter, r *http.Request, uid, id int) {
+	current, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.setStale(&current)
+	writeVersionConflict(w, current)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventStreamBuffer is how many events a stream may fall behind by
+	// before it's closed; its client reconnects and refetches.
+	eventStreamBuffer = 32
+	// eventKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't time the connection out.
+	eventKeepAlive = 30 * time.Second
+)
+
+// streamEvent is one event on the bus: its sequence number, its name and
+// the webhook payload describing it.
+type streamEvent struct {
+	ID      int64
+	Event   string
+	Payload []byte
+}
+
+// eventStream is one open GET /api/events connection.
+type eventStream struct {
+	events chan streamEvent
+	// filter holds the events the client asked for; empty means all.
+	filter []string
+}
+
+// eventBus hands what emitEvent reports to the user's open event streams.
+// It lives in the process, so with several replicas a client only hears
+// about changes made through the one it's connected to.
+type eventBus struct {
+	sync.Mutex
+	lastID  int64
+	streams map[int]map[*eventStream]bool
+	closed  bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{streams: map[int]map[*eventStream]bool{}}
+}
+
+// subscribe opens a stream of the user's events. Its channel is closed if
+// the stream falls too far behind or the bus shuts down.
+func (b *eventBus) subscribe(userID int, filter []string) *eventStream {
+	s := &eventStream{events: make(chan streamEvent, eventStreamBuffer), filter: filter}
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	if b.streams[userID] == nil {
+		b.streams[userID] = map[*eventStream]bool{}
+	}
+	b.streams[userID][s] = true
+	return s
+}
+
+func (b *eventBus) unsubscribe(userID int, s *eventStream) {
+	b.Lock()
+	defer b.Unlock()
+	if b.streams[userID][s] {
+		delete(b.streams[userID], s)
+		close(s.events)
+	}
+	if len(b.streams[userID]) == 0 {
+		delete(b.streams, userID)
+	}
+}
+
+// publish sends an event to every one of the user's streams that wants
+// it, dropping streams that can't keep up rather than waiting for them.
+func (b *eventBus) publish(userID int, event string, payload []byte) {
+	b.Lock()
+	defer b.Unlock()
+	b.lastID++
+	e := streamEvent{ID: b.lastID, Event: event, Payload: payload}
+	for s := range b.streams[userID] {
+		if len(s.filter) > 0 && !slices.Contains(s.filter, event) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			delete(b.streams[userID], s)
+			close(s.events)
+		}
+	}
+}
+
+// CloseEventStreams ends every open event stream and refuses new ones.
+// http.Server.Shutdown doesn't interrupt running handlers, so register
+// it with RegisterOnShutdown to let a graceful shutdown finish.
+func (a *App) CloseEventStreams() {
+	b := a.events
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	for userID, streams := range b.streams {
+		for s := range streams {
+			close(s.events)
+		}
+		delete(b.streams, userID)
+	}
+}
+
+// publishEvent puts an event on the bus in the same shape as a webhook
+// delivery.
+func (a *App) publishEvent(ctx context.Context, userID int, event string, data any) {
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: a.dbNow().Format(time.RFC3339), Data: data})
+	if err != nil {
+		a.logger.WarnContext(ctx, "publishing event", "event", event, "err", err)
+		return
+	}
+	a.events.publish(userID, event, payload)
+}
+
+// eventsAuth accepts the bearer token in ?token= as well as the
+// Authorization header, since browsers' EventSource can't set headers.
+func (a *App) eventsAuth(next http.Handler) http.Handler {
+	bearer := a.authMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			bearer.ServeHTTP(w, r)
+			return
+		}
+		id, tenant, _, err := a.parseToken(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(a.withUser(r.Context(), tenant, id)))
+	})
+}
+
+// getEvents streams the user's events as Server-Sent Events, as they
+// happen, until the client goes away. ?events= narrows them to a
+// comma-separated list of the webhook events. Each is sent with its
+// webhook payload as data; events from before the stream opened aren't
+// replayed, so a client that reconnects should refetch what it shows.
+func (a *App) getEvents(w http.ResponseWriter, r *http.Request) {
+	var filter []string
+	if v := r.URL.Query().Get("events"); v != "" {
+		for _, e := range strings.Split(v, ",") {
+			if !slices.Contains(webhookEvents, e) {
+				writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("events: %q is not one of %s", e, strings.Join(webhookEvents, ", ")))
+				return
+			}
+			filter = append(filter, e)
+		}
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	uid := userID(r)
+	stream := a.events.subscribe(uid, filter)
+	defer a.events.unsubscribe(uid, stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		a.logger.WarnContext(r.Context(), "streaming events", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-stream.events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Event, e.Payload)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
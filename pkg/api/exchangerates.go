@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+	"subscription-tracker/pkg/store"
+)
+
+// StartRateSnapshots records the day's exchange rate for every currency a
+// subscription or user has, now and then every RateSnapshotInterval, so
+// that amounts billed on a past day can be converted at that day's rate.
+// It does nothing without a database or an exchange rate provider.
+func (a *App) StartRateSnapshots(ctx context.Context) {
+	if _, off := a.rates.(unconfigured); a.config.RateSnapshotInterval <= 0 || a.db == nil || off {
+		return
+	}
+
+	a.jobs.started("rate_snapshots", a.config.RateSnapshotInterval)
+	go func() {
+		defer a.jobs.stopped("rate_snapshots")
+		ticker := time.NewTicker(a.config.RateSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.snapshotRates(ctx); err != nil {
+				a.logger.Warn("recording exchange rates", "err", err)
+			} else {
+				a.logger.Info("recorded exchange rates", "currencies", n)
+			}
+			a.jobs.ran("rate_snapshots")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// snapshotRates stores today's rate, per US dollar, for every currency in
+// use, replacing any stored earlier today. Currencies the provider has no
+// rate for are skipped. It returns how many rates it stored.
+func (a *App) snapshotRates(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT currency FROM subscriptions
+		UNION SELECT currency FROM users
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var currencies []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if c != models.DefaultCurrency {
+			currencies = append(currencies, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	today := models.DateOf(a.clock.Now())
+	stored := 0
+	for _, c := range currencies {
+		rate, err := a.rates.Rate(ctx, models.DefaultCurrency, c)
+		if errors.Is(err, rates.ErrUnknownCurrency) {
+			continue
+		}
+		a.integrations.report("exchange_rates", err)
+		if err != nil {
+			return stored, err
+		}
+		if _, err := a.db.ExecContext(ctx, `
+			INSERT INTO exchange_rates (currency, rated_on, per_usd) VALUES ($1, $2, $3)
+			ON CONFLICT (currency, rated_on) DO UPDATE SET per_usd = excluded.per_usd
+		`, c, today, rate); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
+
+// rateOn is how many units of to one unit of from bought on day: from the
+// newest snapshots on or before it, or the provider's current rate if
+// either currency has none.
+func (a *App) rateOn(ctx context.Context, from, to string, day models.Date) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if a.db != nil {
+		f, ferr := a.snapshotOn(ctx, from, day)
+		t, terr := a.snapshotOn(ctx, to, day)
+		if ferr == nil && terr == nil {
+			return t / f, nil
+		}
+		for _, err := range []error{ferr, terr} {
+			if err != nil && err != sql.ErrNoRows {
+				return 0, fmt.Errorf("reading exchange rates: %w", err)
+			}
+		}
+	}
+	rate, err := a.rates.Rate(ctx, from, to)
+	if !errors.Is(err, ErrNotConfigured) && !errors.Is(err, rates.ErrUnknownCurrency) {
+		a.integrations.report("exchange_rates", err)
+	}
+	return rate, err
+}
+
+// snapshotOn is currency's newest stored rate per US dollar on or before
+// day, or sql.ErrNoRows.
+func (a *App) snapshotOn(ctx context.Context, currency string, day models.Date) (float64, error) {
+	if currency == models.DefaultCurrency {
+		return 1, nil
+	}
+	var rate float64
+	err := a.stmts.QueryRowContext(ctx, `
+		SELECT per_usd FROM exchange_rates
+		WHERE currency = $1 AND rated_on <= $2
+		ORDER BY rated_on DESC LIMIT 1
+	`, currency, day).Scan(&rate)
+	return rate, err
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+)
+
+// exportColumns is the header row of an export. The names are ones the CSV
+// import recognizes, so an export can be imported again.
+var exportColumns = []string{"id", "name", "category", "cost", "currency", "billing_cycle", "next_billing", "description", "tags", "last_verified_at"}
+
+func exportRow(s models.Subscription) []any {
+	verified := ""
+	if s.LastVerifiedAt != nil {
+		verified = *s.LastVerifiedAt
+	}
+	return []any{s.ID, s.Name, s.Category, s.Cost.Float(), s.Currency, s.BillingCycle, s.NextBilling.String(), s.Description, strings.Join(s.Tags, ","), verified}
+}
+
+// startDownload sets the headers of a file download that is streamed as
+// it's written. The status goes out with the first write, after which a
+// failure can only cut the file short, so callers log it instead of
+// answering with an error.
+func startDownload(w http.ResponseWriter, contentType, filename string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+}
+
+// rowWriter writes a table one row at a time. Cells are strings, ints or
+// float64s.
+type rowWriter interface {
+	WriteRow(cells []any) error
+	Close() error
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	open        func(io.Writer) rowWriter
+}{
+	"csv":  {"text/csv; charset=utf-8", newCSVRowWriter},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXRowWriter},
+}
+
+// exportSubscriptions streams the caller's subscriptions as ?format=csv
+// (the default) or xlsx, using the same filters and sort as the list
+// endpoint. Rows are fetched a page at a time, so large accounts never sit
+// in memory whole.
+func (a *App) exportSubscriptions(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "format must be csv or xlsx")
+		return
+	}
+	query, err := subscriptionFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	query.Limit = maxPageLimit
+
+	uid := userID(r)
+	page, _, err := a.subscriptions.List(r.Context(), uid, query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	startDownload(w, format.contentType, fmt.Sprintf("subscriptions-%s.%s", a.clock.Now().Format(dateLayout), name))
+	out := format.open(w)
+	header := make([]any, len(exportColumns))
+	for i, c := range exportColumns {
+		header[i] = c
+	}
+	if err := out.WriteRow(header); err != nil {
+		a.logger.ErrorContext(r.Context(), "export cut short", "err", err)
+		return
+	}
+	for {
+		for _, s := range page {
+			if err := out.WriteRow(exportRow(s)); err != nil {
+				a.logger.ErrorContext(r.Context(), "export cut short", "err", err)
+				return
+			}
+		}
+		if len(page) < query.Limit {
+			break
+		}
+		query.Offset += len(page)
+		if page, _, err = a.subscriptions.List(r.Context(), uid, query); err != nil {
+			a.logger.ErrorContext(r.Context(), "export cut short", "err", err)
+			return
+		}
+	}
+	if err := out.Close(); err != nil {
+		a.logger.ErrorContext(r.Context(), "export cut short", "err", err)
+	}
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer) rowWriter {
+	return csvRowWriter{csv.NewWriter(w)}
+}
+
+func (c csvRowWriter) WriteRow(cells []any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case string:
+			record[i] = v
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// xlsxRowWriter writes a single-sheet workbook with just the parts Excel,
+// LibreOffice and Google Sheets require. Strings are stored inline rather
+// than in a shared string table so rows can be written as they come.
+type xlsxRowWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	err   error
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Subscriptions" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+func newXLSXRowWriter(w io.Writer) rowWriter {
+	x := &xlsxRowWriter{zip: zip.NewWriter(w)}
+	for _, p := range xlsxParts {
+		f, err := x.zip.Create(p.name)
+		if err == nil {
+			_, err = io.WriteString(f, p.body)
+		}
+		if err != nil {
+			x.err = err
+			return x
+		}
+	}
+	// The sheet is the last entry, so it can stay open while rows stream.
+	f, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		x.err = err
+		return x
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return x
+}
+
+func (x *xlsxRowWriter) WriteRow(cells []any) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.sheet.WriteString("<row>")
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case string:
+			x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(x.sheet, []byte(v))
+			x.sheet.WriteString("</t></is></c>")
+		case int:
+			fmt.Fprintf(x.sheet, "<c><v>%d</v></c>", v)
+		case float64:
+			fmt.Fprintf(x.sheet, "<c><v>%s</v></c>", strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	_, x.err = x.sheet.WriteString("</row>")
+	return x.err
+}
+
+func (x *xlsxRowWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	x.sheet.WriteString("</sheetData></worksheet>")
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
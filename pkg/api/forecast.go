@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// Forecast lengths, in months, for ?months=.
+const (
+	defaultForecastMonths = 12
+	maxForecastMonths     = 60
+)
+
+type forecastCategory struct {
+	Category string       `json:"category"`
+	Charges  int          `json:"charges"`
+	Amount   models.Money `json:"amount"`
+}
+
+// forecastMonth is what's due in one calendar month, as YYYY-MM.
+type forecastMonth struct {
+	Month      string             `json:"month"`
+	Total      models.Money       `json:"total"`
+	ByCategory []forecastCategory `json:"byCategory"`
+}
+
+type forecast struct {
+	Currency string          `json:"currency"`
+	Total    models.Money    `json:"total"`
+	Months   []forecastMonth `json:"months"`
+}
+
+// getForecast projects spending month by month, from the current month
+// through ?months (default 12, at most 60), by stepping each subscription
+// through its billing cycle from its next billing date. Unlike the stats,
+// which spread a yearly plan evenly, each charge lands in the month it's
+// due. Amounts are converted like the stats, and the list endpoint's
+// filters narrow which subscriptions count. Paused subscriptions are left
+// out, and cancelled ones count only until their cancellation date.
+func (a *App) getForecast(w http.ResponseWriter, r *http.Request) {
+	months := defaultForecastMonths
+	if v := r.URL.Query().Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastMonths {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("months must be between 1 and %d", maxForecastMonths))
+			return
+		}
+		months = n
+	}
+	query, err := subscriptionFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	f, unconverted, err := a.forecastSpending(subs, months, a.converter(r.Context(), currency))
+	if err != nil {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+	f.Currency = currency
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// forecastSpending projects what subs cost over the coming months, in the
+// currency convert converts to, leaving the caller to fill in which one
+// that is. If a conversion fails it returns the currency it couldn't
+// convert.
+func (a *App) forecastSpending(subs []models.Subscription, months int, convert func(from string, amount models.Money) (models.Money, error)) (forecast, string, error) {
+	now := a.clock.Now()
+	today := now.Format(dateLayout)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, months, 0)
+
+	byCategory := make([]map[string]*forecastCategory, months)
+	for i := range byCategory {
+		byCategory[i] = map[string]*forecastCategory{}
+	}
+	for _, s := range subs {
+		if s.NextBilling.IsZero() {
+			continue
+		}
+		next := s.NextBilling.Time()
+		// Paused subscriptions don't bill, and cancelled ones stop on the
+		// day they were cancelled.
+		stop := end
+		switch s.Status {
+		case models.StatusPaused:
+			continue
+		case models.StatusCancelled:
+			if s.CancelledAt == nil {
+				continue
+			}
+			cancelled, err := time.Parse(dateLayout, *s.CancelledAt)
+			if err == nil && !cancelled.After(end) {
+				stop = cancelled
+			}
+		}
+		amount, err := convert(s.Currency, s.Cost)
+		if err != nil {
+			return forecast{}, s.Currency, err
+		}
+		// As in yearlyCost, a cycle we don't understand counts as monthly.
+		cycle := s.BillingCycle
+		if _, ok := addCycle(next, cycle, 1); !ok {
+			cycle = "monthly"
+		}
+		// Dates count from the stored one, as in rollForward.
+		for n := 0; ; n++ {
+			date, _ := addCycle(next, cycle, n)
+			if !date.Before(stop) {
+				break
+			}
+			if date.Format(dateLayout) < today {
+				continue // already charged, or about to be rolled forward
+			}
+			i := (date.Year()-start.Year())*12 + int(date.Month()-start.Month())
+			c := byCategory[i][s.Category]
+			if c == nil {
+				c = &forecastCategory{Category: s.Category}
+				byCategory[i][s.Category] = c
+			}
+			c.Charges++
+			c.Amount += amount
+		}
+	}
+
+	f := forecast{Months: make([]forecastMonth, months)}
+	for i := range f.Months {
+		m := forecastMonth{Month: start.AddDate(0, i, 0).Format("2006-01"), ByCategory: []forecastCategory{}}
+		for _, c := range byCategory[i] {
+			m.Total += c.Amount
+			m.ByCategory = append(m.ByCategory, *c)
+		}
+		sort.Slice(m.ByCategory, func(x, y int) bool {
+			if m.ByCategory[x].Amount != m.ByCategory[y].Amount {
+				return m.ByCategory[x].Amount > m.ByCategory[y].Amount
+			}
+			return m.ByCategory[x].Category < m.ByCategory[y].Category
+		})
+		f.Total += m.Total
+		f.Months[i] = m
+	}
+	return f, "", nil
+}
@@ -0,0 +1,561 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"subscription-tracker/pkg/graph"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+	"subscription-tracker/pkg/store"
+)
+
+// maxGraphQLComplexity bounds how many fields one GraphQL operation may
+// resolve, so nesting categories and tags can't fan out without limit.
+const maxGraphQLComplexity = 500
+
+// graphQLHandler serves POST /api/graphql. Resolvers follow the REST
+// handlers step for step, and errors carry the problem code the REST route
+// would have returned in their "code" extension.
+func (a *App) graphQLHandler() http.Handler {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: graphResolver{a}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.FixedComplexityLimit(maxGraphQLComplexity))
+	srv.AroundOperations(a.graphQLReadOnly)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), graphLoaderKey, &graphLoader{app: a, uid: userID(r)})
+		srv.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// graphQLReadOnly applies read-only mode to mutations. Queries are POSTs
+// too, so readOnlyMiddleware lets GraphQL through and leaves this to it.
+func (a *App) graphQLReadOnly(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	a.readOnly.RLock()
+	state := a.readOnly.state
+	a.readOnly.RUnlock()
+	if state.Enabled && graphql.GetOperationContext(ctx).Operation.Operation == ast.Mutation {
+		return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{
+			graphError(codeReadOnly, "The service is temporarily read-only; changes are not being accepted."),
+		}})
+	}
+	return next(ctx)
+}
+
+func graphError(code, message string) *gqlerror.Error {
+	return &gqlerror.Error{Message: message, Extensions: map[string]any{"code": code}}
+}
+
+func graphDatabaseError(err error) error {
+	return graphError(codeDatabase, fmt.Sprintf("Database error: %v", err))
+}
+
+// graphConversionError is the GraphQL counterpart of writeConversionError.
+func graphConversionError(from, to string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		return graphError(codeNotConfigured, fmt.Sprintf("Exchange rates are not configured, so amounts in %s can't be shown in %s", from, to))
+	case errors.Is(err, rates.ErrUnknownCurrency):
+		return graphError(codeBadRequest, fmt.Sprintf("No exchange rate from %s to %s", from, to))
+	default:
+		return graphError(codeUpstream, fmt.Sprintf("Exchange rate error: %v", err))
+	}
+}
+
+// graphVersionConflict is the GraphQL counterpart of writeVersionConflict.
+func graphVersionConflict(current models.Subscription) error {
+	e := graphError(codeVersionConflict, fmt.Sprintf("The subscription is at version %d now", current.Version))
+	e.Extensions["current"] = current
+	return e
+}
+
+type graphLoaderKeyType struct{}
+
+var graphLoaderKey graphLoaderKeyType
+
+// graphLoader loads the user's subscriptions and tags at most once per
+// request, however many nested fields ask for them, so a page of
+// subscriptions with their tags costs two queries rather than one per row.
+// Mutations call forget so fields resolved after them see the change.
+type graphLoader struct {
+	app *App
+	uid int
+
+	mu   sync.Mutex
+	subs []models.Subscription
+	tags map[string]*graph.Tag
+}
+
+func loaderFor(ctx context.Context) *graphLoader {
+	return ctx.Value(graphLoaderKey).(*graphLoader)
+}
+
+// subscriptions returns all the user's subscriptions, soonest billing
+// first.
+func (l *graphLoader) subscriptions(ctx context.Context) ([]models.Subscription, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subs == nil {
+		subs, _, err := l.app.subscriptions.List(ctx, l.uid, store.SubscriptionQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range subs {
+			l.app.setStale(&subs[i])
+		}
+		l.subs = subs
+	}
+	return l.subs, nil
+}
+
+// tag returns the user's tag called name.
+func (l *graphLoader) tag(ctx context.Context, name string) (*graph.Tag, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tags == nil {
+		tags, err := l.app.subscriptions.Tags(ctx, l.uid)
+		if err != nil {
+			return nil, err
+		}
+		l.tags = map[string]*graph.Tag{}
+		for _, t := range tags {
+			l.tags[t.Name] = &graph.Tag{ID: t.ID, Name: t.Name, SubscriptionCount: t.Subscriptions}
+		}
+	}
+	if t, ok := l.tags[name]; ok {
+		return t, nil
+	}
+	return &graph.Tag{Name: name}, nil
+}
+
+func (l *graphLoader) forget() {
+	l.mu.Lock()
+	l.subs, l.tags = nil, nil
+	l.mu.Unlock()
+}
+
+// subscriptionsWhere returns the user's subscriptions keep accepts, with
+// the given status if it isn't nil.
+func (l *graphLoader) subscriptionsWhere(ctx context.Context, status *string, keep func(models.Subscription) bool) ([]*models.Subscription, error) {
+	if status != nil && !slices.Contains(subscriptionStatuses, *status) {
+		return nil, graphError(codeBadRequest, "status must be one of "+strings.Join(subscriptionStatuses, ", "))
+	}
+	subs, err := l.subscriptions(ctx)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	result := []*models.Subscription{}
+	for _, s := range subs {
+		if keep(s) && (status == nil || s.Status == *status) {
+			result = append(result, &s)
+		}
+	}
+	return result, nil
+}
+
+// graphResolver implements graph.ResolverRoot.
+type graphResolver struct{ app *App }
+
+func (r graphResolver) Query() graph.QueryResolver               { return graphQuery(r) }
+func (r graphResolver) Mutation() graph.MutationResolver         { return graphMutation(r) }
+func (r graphResolver) Subscription() graph.SubscriptionResolver { return graphSubscription(r) }
+func (r graphResolver) Category() graph.CategoryResolver         { return graphCategory(r) }
+func (r graphResolver) Tag() graph.TagResolver                   { return graphTag(r) }
+
+type (
+	graphQuery        graphResolver
+	graphMutation     graphResolver
+	graphSubscription graphResolver
+	graphCategory     graphResolver
+	graphTag          graphResolver
+)
+
+// graphFilter is the GraphQL counterpart of subscriptionFilter.
+func graphFilter(f *graph.SubscriptionFilter, sort *string) (store.SubscriptionQuery, error) {
+	var query store.SubscriptionQuery
+	if f != nil {
+		query = store.SubscriptionQuery{
+			Category:     deref(f.Category),
+			BillingCycle: deref(f.BillingCycle),
+			Tag:          deref(f.Tag),
+			Status:       deref(f.Status),
+			Trial:        f.Trial,
+			MinCost:      optionalMoney(f.MinCost),
+			MaxCost:      optionalMoney(f.MaxCost),
+		}
+		if query.Status != "" && !slices.Contains(subscriptionStatuses, query.Status) {
+			return query, graphError(codeBadRequest, "status must be one of "+strings.Join(subscriptionStatuses, ", "))
+		}
+		for _, d := range []struct {
+			name  string
+			value *string
+			dest  *models.Date
+		}{{"nextBillingAfter", f.NextBillingAfter, &query.NextBillingAfter}, {"nextBillingBefore", f.NextBillingBefore, &query.NextBillingBefore}} {
+			if d.value == nil {
+				continue
+			}
+			date, err := models.ParseDate(*d.value)
+			if err != nil {
+				return query, graphError(codeBadRequest, d.name+" must be YYYY-MM-DD")
+			}
+			*d.dest = date
+		}
+	}
+	var err error
+	if query.Sort, err = parseSort(deref(sort), subscriptionSortFields); err != nil {
+		return query, graphError(codeBadRequest, err.Error())
+	}
+	return query, nil
+}
+
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// optionalMoney is an optional amount given as a float, as GraphQL and
+// protobuf give them.
+func optionalMoney(f *float64) *models.Money {
+	if f == nil {
+		return nil
+	}
+	m := models.MoneyFromFloat(*f)
+	return &m
+}
+
+// graphCurrency is the GraphQL counterpart of statsCurrency.
+func (a *App) graphCurrency(ctx context.Context, currency *string) (string, error) {
+	if currency != nil {
+		c := strings.ToUpper(*currency)
+		if !isCurrencyCode(c) {
+			return "", graphError(codeBadRequest, "currency must be a three-letter ISO 4217 code such as USD")
+		}
+		return c, nil
+	}
+	c, err := a.userCurrency(ctx, loaderFor(ctx).uid)
+	if err != nil {
+		return "", graphDatabaseError(err)
+	}
+	return c, nil
+}
+
+func (q graphQuery) Subscriptions(ctx context.Context, filter *graph.SubscriptionFilter, sort *string, limit, offset *int) (*graph.SubscriptionPage, error) {
+	l, o := deref(limit), deref(offset)
+	if l < 1 || l > maxPageLimit {
+		return nil, graphError(codeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit))
+	}
+	if o < 0 {
+		return nil, graphError(codeBadRequest, "offset must be a non-negative integer")
+	}
+	query, err := graphFilter(filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit, query.Offset = l, o
+
+	items, total, err := q.app.subscriptions.List(ctx, loaderFor(ctx).uid, query)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	page := &graph.SubscriptionPage{Items: make([]*models.Subscription, len(items)), Total: total, Limit: l, Offset: o}
+	for i := range items {
+		q.app.setStale(&items[i])
+		page.Items[i] = &items[i]
+	}
+	return page, nil
+}
+
+func (q graphQuery) Subscription(ctx context.Context, id int) (*models.Subscription, error) {
+	s, err := q.app.subscriptions.Get(ctx, loaderFor(ctx).uid, id)
+	if err == store.ErrNotFound {
+		return nil, graphError(codeNotFound, "Subscription not found")
+	}
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	q.app.setStale(&s)
+	return &s, nil
+}
+
+func (q graphQuery) Categories(ctx context.Context) ([]*graph.Category, error) {
+	subs, err := loaderFor(ctx).subscriptions(ctx)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	var names []string
+	for _, s := range subs {
+		names = append(names, s.Category)
+	}
+	slices.Sort(names)
+	categories := []*graph.Category{}
+	for _, name := range slices.Compact(names) {
+		categories = append(categories, &graph.Category{Name: name})
+	}
+	return categories, nil
+}
+
+func (q graphQuery) Tags(ctx context.Context) ([]*graph.Tag, error) {
+	tags, err := q.app.subscriptions.Tags(ctx, loaderFor(ctx).uid)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	result := make([]*graph.Tag, len(tags))
+	for i, t := range tags {
+		result[i] = &graph.Tag{ID: t.ID, Name: t.Name, SubscriptionCount: t.Subscriptions}
+	}
+	return result, nil
+}
+
+func (q graphQuery) Stats(ctx context.Context, currency *string) (*graph.Stats, error) {
+	c, err := q.app.graphCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	subs, _, err := q.app.subscriptions.List(ctx, loaderFor(ctx).uid, store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	spent, unconverted, err := summarizeSpending(subs, q.app.converter(ctx, c))
+	if err != nil {
+		return nil, graphConversionError(unconverted, c, err)
+	}
+
+	stats := &graph.Stats{Currency: c, TotalMonthly: spent.TotalMonthly.Float(), TotalYearly: spent.TotalYearly.Float(), ByCategory: []*graph.CategoryStat{}, ByTag: []*graph.TagStat{}}
+	for _, s := range spent.ByCategory {
+		stats.ByCategory = append(stats.ByCategory, &graph.CategoryStat{Category: &graph.Category{Name: s.Category}, Count: s.Count, Monthly: s.Monthly.Float(), Yearly: s.Yearly.Float()})
+	}
+	for _, s := range spent.ByTag {
+		stats.ByTag = append(stats.ByTag, &graph.TagStat{Tag: s.Tag, Count: s.Count, Monthly: s.Monthly.Float(), Yearly: s.Yearly.Float()})
+	}
+	return stats, nil
+}
+
+func (q graphQuery) Forecast(ctx context.Context, months *int, currency *string, filter *graph.SubscriptionFilter) (*graph.Forecast, error) {
+	n := deref(months)
+	if n < 1 || n > maxForecastMonths {
+		return nil, graphError(codeBadRequest, fmt.Sprintf("months must be between 1 and %d", maxForecastMonths))
+	}
+	query, err := graphFilter(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := q.app.graphCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	subs, _, err := q.app.subscriptions.List(ctx, loaderFor(ctx).uid, query)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	f, unconverted, err := q.app.forecastSpending(subs, n, q.app.converter(ctx, c))
+	if err != nil {
+		return nil, graphConversionError(unconverted, c, err)
+	}
+
+	result := &graph.Forecast{Currency: c, Total: f.Total.Float(), Months: make([]*graph.ForecastMonth, len(f.Months))}
+	for i, m := range f.Months {
+		month := &graph.ForecastMonth{Month: m.Month, Total: m.Total.Float(), ByCategory: make([]*graph.ForecastCategory, len(m.ByCategory))}
+		for j, fc := range m.ByCategory {
+			month.ByCategory[j] = &graph.ForecastCategory{Category: &graph.Category{Name: fc.Category}, Charges: fc.Charges, Amount: fc.Amount.Float()}
+		}
+		result.Months[i] = month
+	}
+	return result, nil
+}
+
+// subscriptionFromInput takes the fields a client may set, as
+// subscriptionFromProto does for gRPC.
+func subscriptionFromInput(in graph.SubscriptionInput) subscriptionInput {
+	return subscriptionInput{
+		Subscription: models.Subscription{
+			Name:         in.Name,
+			Category:     in.Category,
+			Cost:         models.MoneyFromFloat(in.Cost),
+			Currency:     deref(in.Currency),
+			BillingCycle: in.BillingCycle,
+			Description:  deref(in.Description),
+			Tags:         in.Tags,
+			IsTrial:      deref(in.IsTrial),
+			TrialEndsAt:  in.TrialEndsAt,
+		},
+		NextBilling: in.NextBilling,
+	}
+}
+
+// graphValidationError is the GraphQL counterpart of a validation_failed
+// problem, with the same list of field errors.
+func graphValidationError(errs fieldErrors) error {
+	e := graphError(codeValidation, errs.Error())
+	e.Extensions["errors"] = errs
+	return e
+}
+
+// graphQuotaError is the error for going over the subscription quota.
+func graphQuotaError(quota QuotaStatus) error {
+	e := graphError(codeQuotaExceeded, fmt.Sprintf("Quota exceeded for %s: limit is %d", QuotaSubscriptions, *quota.Limit))
+	e.Extensions["resource"], e.Extensions["limit"], e.Extensions["used"] = QuotaSubscriptions, *quota.Limit, quota.Used
+	return e
+}
+
+func (m graphMutation) CreateSubscription(ctx context.Context, input graph.SubscriptionInput, force *bool) (*models.Subscription, error) {
+	a, l := m.app, loaderFor(ctx)
+	in := subscriptionFromInput(input)
+	in.Subscription = asNew(in.Subscription)
+	s, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		return nil, graphValidationError(errs)
+	}
+
+	quota, err := a.quotaStatus(ctx, l.uid, QuotaSubscriptions)
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	if quota.Limit != nil && quota.Used+1 > *quota.Limit {
+		return nil, graphQuotaError(quota)
+	}
+	if a.config.DuplicateCheck && !deref(force) {
+		candidates, err := a.duplicateCandidates(ctx, l.uid, s)
+		if err != nil {
+			return nil, graphDatabaseError(err)
+		}
+		if len(candidates) > 0 {
+			e := graphError(codeDuplicate, fmt.Sprintf("%s looks like a subscription you already have; send it again with force: true to add it anyway", s.Name))
+			e.Extensions["duplicates"] = candidates
+			return nil, e
+		}
+	}
+
+	s, err = a.subscriptions.Create(ctx, l.uid, s, a.clock.Now(), a.subscriptionLimit())
+	if errors.Is(err, store.ErrLimitReached) {
+		if quota, err = a.quotaStatus(ctx, l.uid, QuotaSubscriptions); err != nil {
+			return nil, graphDatabaseError(err)
+		}
+		return nil, graphQuotaError(quota)
+	}
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	l.forget()
+	s.Stale = false
+	a.recordAudit(ctx, l.uid, s.ID, nil, &s)
+	a.emitEvent(ctx, l.uid, models.EventSubscriptionCreated, s)
+	a.checkBudgets(ctx, l.uid)
+	return &s, nil
+}
+
+func (m graphMutation) UpdateSubscription(ctx context.Context, id, version int, input graph.SubscriptionInput) (*models.Subscription, error) {
+	a, l := m.app, loaderFor(ctx)
+	in := subscriptionFromInput(input)
+	in.ID = id
+	s, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		return nil, graphValidationError(errs)
+	}
+
+	before, err := a.subscriptions.Get(ctx, l.uid, id)
+	if err == store.ErrNotFound {
+		return nil, graphError(codeNotFound, "Subscription not found")
+	}
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+	if version != before.Version {
+		a.setStale(&before)
+		return nil, graphVersionConflict(before)
+	}
+	// The status only changes through pause, resume and cancel.
+	s.Status, s.CancelledAt, s.CancellationReason = before.Status, before.CancelledAt, before.CancellationReason
+	s.ArchivedAt = before.ArchivedAt
+	s.Version = version
+	s, err = a.subscriptions.Update(ctx, l.uid, s, a.clock.Now())
+	if err == store.ErrNotFound {
+		// Another write took the version since it was read.
+		current, err := a.subscriptions.Get(ctx, l.uid, id)
+		if err == store.ErrNotFound {
+			return nil, graphError(codeNotFound, "Subscription not found")
+		}
+		if err != nil {
+			return nil, graphDatabaseError(err)
+		}
+		a.setStale(&current)
+		return nil, graphVersionConflict(current)
+	}
+	if err != nil {
+		return nil, graphDatabaseError(err)
+	}
+
+	l.forget()
+	s.Stale = false
+	a.recordAudit(ctx, l.uid, s.ID, &before, &s)
+	a.recordPriceChange(ctx, l.uid, before, s)
+	a.emitEvent(ctx, l.uid, models.EventSubscriptionUpdated, s)
+	a.checkBudgets(ctx, l.uid)
+	return &s, nil
+}
+
+func (m graphMutation) DeleteSubscription(ctx context.Context, id int) (int, error) {
+	a, l := m.app, loaderFor(ctx)
+	before, err := a.subscriptions.Get(ctx, l.uid, id)
+	if err == nil {
+		err = a.subscriptions.Delete(ctx, l.uid, id)
+	}
+	if err == store.ErrNotFound {
+		return 0, graphError(codeNotFound, "Subscription not found")
+	}
+	if err != nil {
+		return 0, graphDatabaseError(err)
+	}
+	l.forget()
+	a.recordAudit(ctx, l.uid, id, &before, nil)
+	a.emitEvent(ctx, l.uid, models.EventSubscriptionDeleted, deletedSubscription{id})
+	a.purgeAttachments(ctx, l.uid, id)
+	return id, nil
+}
+
+func (graphSubscription) Category(_ context.Context, s *models.Subscription) (*graph.Category, error) {
+	return &graph.Category{Name: s.Category}, nil
+}
+
+func (graphSubscription) Cost(_ context.Context, s *models.Subscription) (float64, error) {
+	return s.Cost.Float(), nil
+}
+
+func (graphSubscription) NextBilling(_ context.Context, s *models.Subscription) (string, error) {
+	return s.NextBilling.String(), nil
+}
+
+func (graphSubscription) Tags(ctx context.Context, s *models.Subscription) ([]*graph.Tag, error) {
+	tags := make([]*graph.Tag, len(s.Tags))
+	for i, name := range s.Tags {
+		t, err := loaderFor(ctx).tag(ctx, name)
+		if err != nil {
+			return nil, graphDatabaseError(err)
+		}
+		tags[i] = t
+	}
+	return tags, nil
+}
+
+func (graphCategory) Subscriptions(ctx context.Context, c *graph.Category, status *string) ([]*models.Subscription, error) {
+	return loaderFor(ctx).subscriptionsWhere(ctx, status, func(s models.Subscription) bool { return s.Category == c.Name })
+}
+
+func (graphTag) Subscriptions(ctx context.Context, t *graph.Tag, status *string) ([]*models.Subscription, error) {
+	return loaderFor(ctx).subscriptionsWhere(ctx, status, func(s models.Subscription) bool { return slices.Contains(s.Tags, t.Name) })
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+	"subscription-tracker/pkg/store"
+	pb "subscription-tracker/pkg/subscriptionsv1"
+)
+
+// grpcWrites are the methods read-only mode turns away.
+var grpcWrites = []string{
+	pb.SubscriptionService_CreateSubscription_FullMethodName,
+	pb.SubscriptionService_UpdateSubscription_FullMethodName,
+	pb.SubscriptionService_DeleteSubscription_FullMethodName,
+}
+
+// GRPCServer returns a gRPC server for SubscriptionService, backed by the
+// same repository and side effects (audit log, webhooks, budget alerts)
+// as the REST routes. Callers serve it on a listener of their own.
+func (a *App) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(a.grpcModes, a.grpcAuth))
+	srv := grpc.NewServer(opts...)
+	pb.RegisterSubscriptionServiceServer(srv, subscriptionService{app: a})
+	return srv
+}
+
+// grpcModes applies maintenance and read-only mode, as their middleware
+// does for REST.
+func (a *App) grpcModes(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	a.maintenance.RLock()
+	maintenance := a.maintenance.state
+	a.maintenance.RUnlock()
+	if maintenance.Enabled {
+		return nil, status.Error(codes.Unavailable, maintenance.Message)
+	}
+
+	a.readOnly.RLock()
+	readOnly := a.readOnly.state
+	a.readOnly.RUnlock()
+	if readOnly.Enabled && slices.Contains(grpcWrites, info.FullMethod) {
+		return nil, status.Error(codes.Unavailable, "The service is temporarily read-only; changes are not being accepted.")
+	}
+	return next(ctx, req)
+}
+
+// grpcAuth requires the REST bearer token in the authorization metadata
+// and records the user in the context. Calls count toward the user's API
+// usage under the full method name.
+func (a *App) grpcAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	var ok bool
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, ok = strings.CutPrefix(values[0], "Bearer ")
+	}
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	id, tenant, _, err := a.parseToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or expired token")
+	}
+	start := time.Now()
+	resp, err := next(a.withUser(ctx, tenant, id), req)
+	a.recordUsage(id, "GRPC", info.FullMethod, err != nil, time.Since(start))
+	return resp, err
+}
+
+// subscriptionService implements pb.SubscriptionServiceServer. Each method
+// follows its REST handler step for step, so the two stay in agreement.
+type subscriptionService struct {
+	pb.UnimplementedSubscriptionServiceServer
+	app *App
+}
+
+// contextUserID returns the user grpcAuth authenticated.
+func contextUserID(ctx context.Context) int {
+	id, _ := ctx.Value(userIDKey).(int)
+	return id
+}
+
+func databaseError(err error) error {
+	return status.Errorf(codes.Internal, "Database error: %v", err)
+}
+
+func subscriptionNotFound() error {
+	return status.Error(codes.NotFound, "Subscription not found")
+}
+
+// versionConflict is the gRPC counterpart of writeVersionConflict.
+func versionConflict(current models.Subscription) error {
+	return status.Errorf(codes.Aborted, "The subscription is at version %d now", current.Version)
+}
+
+// invalidArgument reports validation errors as field violations, the gRPC
+// counterpart of a validation_failed problem.
+func invalidArgument(errs fieldErrors) error {
+	br := &errdetails.BadRequest{}
+	for _, e := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: e.Field, Description: e.Message})
+	}
+	st, err := status.New(codes.InvalidArgument, errs.Error()).WithDetails(br)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, errs.Error())
+	}
+	return st.Err()
+}
+
+// conversionError is the gRPC counterpart of writeConversionError.
+func conversionError(from, to string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		return status.Errorf(codes.FailedPrecondition, "Exchange rates are not configured, so amounts in %s can't be shown in %s", from, to)
+	case errors.Is(err, rates.ErrUnknownCurrency):
+		return status.Errorf(codes.InvalidArgument, "No exchange rate from %s to %s", from, to)
+	default:
+		return status.Errorf(codes.Unavailable, "Exchange rate error: %v", err)
+	}
+}
+
+func (s subscriptionService) ListSubscriptions(ctx context.Context, req *pb.ListSubscriptionsRequest) (*pb.ListSubscriptionsResponse, error) {
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPageLimit)
+	}
+	if offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must be a non-negative integer")
+	}
+	if v := req.GetStatus(); v != "" && !slices.Contains(subscriptionStatuses, v) {
+		return nil, status.Error(codes.InvalidArgument, "status must be one of "+strings.Join(subscriptionStatuses, ", "))
+	}
+
+	items, total, err := s.app.subscriptions.List(ctx, contextUserID(ctx), store.SubscriptionQuery{
+		Category:     req.GetCategory(),
+		BillingCycle: req.GetBillingCycle(),
+		Tag:          req.GetTag(),
+		Status:       req.GetStatus(),
+		Limit:        limit,
+		Offset:       offset,
+	})
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	resp := &pb.ListSubscriptionsResponse{Total: int32(total)}
+	for _, item := range items {
+		s.app.setStale(&item)
+		resp.Subscriptions = append(resp.Subscriptions, subscriptionToProto(item))
+	}
+	return resp, nil
+}
+
+func (s subscriptionService) GetSubscription(ctx context.Context, req *pb.GetSubscriptionRequest) (*pb.Subscription, error) {
+	sub, err := s.app.subscriptions.Get(ctx, contextUserID(ctx), int(req.GetId()))
+	if err == store.ErrNotFound {
+		return nil, subscriptionNotFound()
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	s.app.setStale(&sub)
+	return subscriptionToProto(sub), nil
+}
+
+func (s subscriptionService) CreateSubscription(ctx context.Context, req *pb.CreateSubscriptionRequest) (*pb.Subscription, error) {
+	a := s.app
+	in := subscriptionFromProto(req.GetSubscription())
+	in.Subscription = asNew(in.Subscription)
+	sub, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		return nil, invalidArgument(errs)
+	}
+
+	uid := contextUserID(ctx)
+	quota, err := a.quotaStatus(ctx, uid, QuotaSubscriptions)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	if quota.Limit != nil && quota.Used+1 > *quota.Limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "Quota exceeded for %s: limit is %d", QuotaSubscriptions, *quota.Limit)
+	}
+	if a.config.DuplicateCheck && !req.GetForce() {
+		candidates, err := a.duplicateCandidates(ctx, uid, sub)
+		if err != nil {
+			return nil, databaseError(err)
+		}
+		if len(candidates) > 0 {
+			names := make([]string, len(candidates))
+			for i, c := range candidates {
+				names[i] = fmt.Sprintf("%s (%d)", c.Name, c.ID)
+			}
+			return nil, status.Errorf(codes.AlreadyExists, "%s looks like a subscription you already have: %s; set force to add it anyway", sub.Name, strings.Join(names, ", "))
+		}
+	}
+
+	sub, err = a.subscriptions.Create(ctx, uid, sub, a.clock.Now(), a.subscriptionLimit())
+	if errors.Is(err, store.ErrLimitReached) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Quota exceeded for %s: limit is %d", QuotaSubscriptions, *quota.Limit)
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	sub.Stale = false
+	a.recordAudit(ctx, uid, sub.ID, nil, &sub)
+	a.emitEvent(ctx, uid, models.EventSubscriptionCreated, sub)
+	a.checkBudgets(ctx, uid)
+	return subscriptionToProto(sub), nil
+}
+
+func (s subscriptionService) UpdateSubscription(ctx context.Context, req *pb.UpdateSubscriptionRequest) (*pb.Subscription, error) {
+	a := s.app
+	sub, errs := validateSubscription(subscriptionFromProto(req.GetSubscription()))
+	if len(errs) > 0 {
+		return nil, invalidArgument(errs)
+	}
+
+	uid := contextUserID(ctx)
+	before, err := a.subscriptions.Get(ctx, uid, sub.ID)
+	if err == store.ErrNotFound {
+		return nil, subscriptionNotFound()
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	if sub.Version == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "Updates must send the subscription's version")
+	}
+	if sub.Version != before.Version {
+		return nil, versionConflict(before)
+	}
+	// The status only changes through pause, resume and cancel.
+	sub.Status, sub.CancelledAt, sub.CancellationReason = before.Status, before.CancelledAt, before.CancellationReason
+	sub.ArchivedAt = before.ArchivedAt
+	sub, err = a.subscriptions.Update(ctx, uid, sub, a.clock.Now())
+	if err == store.ErrNotFound {
+		// Another write took the version since it was read.
+		current, err := a.subscriptions.Get(ctx, uid, before.ID)
+		if err == store.ErrNotFound {
+			return nil, subscriptionNotFound()
+		}
+		if err != nil {
+			return nil, databaseError(err)
+		}
+		return nil, versionConflict(current)
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+
+	sub.Stale = false
+	a.recordAudit(ctx, uid, sub.ID, &before, &sub)
+	a.recordPriceChange(ctx, uid, before, sub)
+	a.emitEvent(ctx, uid, models.EventSubscriptionUpdated, sub)
+	a.checkBudgets(ctx, uid)
+	return subscriptionToProto(sub), nil
+}
+
+func (s subscriptionService) DeleteSubscription(ctx context.Context, req *pb.DeleteSubscriptionRequest) (*emptypb.Empty, error) {
+	a := s.app
+	uid, id := contextUserID(ctx), int(req.GetId())
+	before, err := a.subscriptions.Get(ctx, uid, id)
+	if err == nil {
+		err = a.subscriptions.Delete(ctx, uid, id)
+	}
+	if err == store.ErrNotFound {
+		return nil, subscriptionNotFound()
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	a.recordAudit(ctx, uid, id, &before, nil)
+	a.emitEvent(ctx, uid, models.EventSubscriptionDeleted, deletedSubscription{id})
+	a.purgeAttachments(ctx, uid, id)
+	return &emptypb.Empty{}, nil
+}
+
+func (s subscriptionService) GetStats(ctx context.Context, req *pb.GetStatsRequest) (*pb.Stats, error) {
+	a := s.app
+	uid := contextUserID(ctx)
+	currency := strings.ToUpper(req.GetCurrency())
+	if currency == "" {
+		var err error
+		if currency, err = a.userCurrency(ctx, uid); err != nil {
+			return nil, databaseError(err)
+		}
+	} else if !isCurrencyCode(currency) {
+		return nil, status.Error(codes.InvalidArgument, "currency must be a three-letter ISO 4217 code such as USD")
+	}
+
+	subs, _, err := a.subscriptions.List(ctx, uid, store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	spent, unconverted, err := summarizeSpending(subs, a.converter(ctx, currency))
+	if err != nil {
+		return nil, conversionError(unconverted, currency, err)
+	}
+
+	stats := &pb.Stats{Currency: currency, TotalMonthly: spent.TotalMonthly.Float(), TotalYearly: spent.TotalYearly.Float()}
+	for _, c := range spent.ByCategory {
+		stats.ByCategory = append(stats.ByCategory, &pb.CategoryStat{Category: c.Category, Count: int32(c.Count), Monthly: c.Monthly.Float(), Yearly: c.Yearly.Float()})
+	}
+	for _, t := range spent.ByTag {
+		stats.ByTag = append(stats.ByTag, &pb.TagStat{Tag: t.Tag, Count: int32(t.Count), Monthly: t.Monthly.Float(), Yearly: t.Yearly.Float()})
+	}
+	return stats, nil
+}
+
+func subscriptionToProto(s models.Subscription) *pb.Subscription {
+	return &pb.Subscription{
+		Id:                 int64(s.ID),
+		Name:               s.Name,
+		Category:           s.Category,
+		Cost:               s.Cost.Float(),
+		Currency:           s.Currency,
+		BillingCycle:       s.BillingCycle,
+		NextBilling:        s.NextBilling.String(),
+		Description:        s.Description,
+		Tags:               s.Tags,
+		IsTrial:            s.IsTrial,
+		TrialEndsAt:        s.TrialEndsAt,
+		Status:             s.Status,
+		CancelledAt:        s.CancelledAt,
+		CancellationReason: s.CancellationReason,
+		LastVerifiedAt:     s.LastVerifiedAt,
+		Stale:              s.Stale,
+		CreatedAt:          s.CreatedAt,
+		UpdatedAt:          s.UpdatedAt,
+		Version:            int64(s.Version),
+	}
+}
+
+// subscriptionFromProto takes the fields a client may set; the rest are
+// the server's to fill in.
+func subscriptionFromProto(p *pb.Subscription) subscriptionInput {
+	return subscriptionInput{
+		Subscription: models.Subscription{
+			ID:           int(p.GetId()),
+			Name:         p.GetName(),
+			Category:     p.GetCategory(),
+			Cost:         models.MoneyFromFloat(p.GetCost()),
+			Currency:     p.GetCurrency(),
+			BillingCycle: p.GetBillingCycle(),
+			Description:  p.GetDescription(),
+			Tags:         p.GetTags(),
+			IsTrial:      p.GetIsTrial(),
+			TrialEndsAt:  p.TrialEndsAt,
+			Version:      int(p.GetVersion()),
+		},
+		NextBilling: p.GetNextBilling(),
+	}
+}
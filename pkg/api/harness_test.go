@@ -0,0 +1,385 @@
+//go:build integration
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"subscription-tracker/pkg/clock"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// The integration suite needs Docker. Run it with:
+//
+//	go test -tags integration ./...
+//
+// TestMain starts one throwaway Postgres for the whole run; every test gets
+// an empty schema via newHarness. With TEST_DB_DRIVER=sqlite it uses a
+// temporary SQLite file instead and needs no Docker.
+
+const testAdminToken = "test-admin-token"
+
+// Every harness starts signed in as this account.
+const (
+	testEmail    = "test@example.com"
+	testPassword = "password123"
+)
+
+// testDB is the shared test database, and testDriver its engine. On
+// Postgres, testDSN is its connection string.
+var (
+	testDB     *sql.DB
+	testDriver = store.Postgres
+	testDSN    string
+)
+
+// testConfig is the configuration every harness starts from: admin API on,
+// no quotas, telemetry off, stats cached.
+func testConfig() Config {
+	return Config{
+		JWTSecret:        "test-jwt-secret",
+		TokenTTL:         time.Hour,
+		RefreshTokenTTL:  24 * time.Hour,
+		AdminToken:       testAdminToken,
+		StaleAfterMonths: 6,
+		StatsCacheTTL:    time.Minute,
+		// Usage is counted but only flushed when a test calls FlushUsage.
+		UsageFlushInterval: time.Minute,
+		QuotaLimits: map[string]int64{
+			QuotaSubscriptions:    0,
+			QuotaAttachmentBytes:  0,
+			QuotaWebhookEndpoints: 0,
+		},
+	}
+}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv("TEST_DB_DRIVER"); name != "" {
+		var err error
+		if testDriver, err = store.ParseDriver(name); err != nil {
+			log.Fatalf("TEST_DB_DRIVER: %v", err)
+		}
+	}
+	if testDriver == store.SQLite {
+		os.Exit(runSQLite(m))
+	}
+	os.Exit(runPostgres(m))
+}
+
+func runPostgres(m *testing.M) int {
+	ctx := context.Background()
+
+	ctr, err := postgres.Run(ctx, "postgres:16-alpine",
+		postgres.WithDatabase("subscriptions"),
+		postgres.WithUsername("postgres"),
+		postgres.WithPassword("postgres"),
+		postgres.BasicWaitStrategies(),
+	)
+	if err != nil {
+		log.Fatalf("Error starting postgres container: %v", err)
+	}
+	defer testcontainers.TerminateContainer(ctr)
+
+	connStr, err := ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		log.Printf("Error getting connection string: %v", err)
+		return 1
+	}
+	testDSN = connStr
+	testDB, err = store.Open(store.Postgres, connStr)
+	if err != nil {
+		log.Printf("Error connecting to database: %v", err)
+		return 1
+	}
+	return runSuite(m)
+}
+
+func runSQLite(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "subscriptions-test")
+	if err != nil {
+		log.Printf("Error creating temp dir: %v", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	testDB, err = store.Open(store.SQLite, filepath.Join(dir, "test.db"))
+	if err != nil {
+		log.Printf("Error opening database: %v", err)
+		return 1
+	}
+	return runSuite(m)
+}
+
+func runSuite(m *testing.M) int {
+	defer testDB.Close()
+	if err := store.Init(testDB); err != nil {
+		log.Printf("Error initializing database: %v", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// truncate empties tables and resets their ID sequences. Rows in other
+// tables that reference them are deleted too.
+func truncate(t *testing.T, tables ...string) {
+	t.Helper()
+	list := strings.Join(tables, ", ")
+	if testDriver == store.Postgres {
+		if _, err := testDB.Exec("TRUNCATE " + list + " RESTART IDENTITY CASCADE"); err != nil {
+			t.Fatalf("Error truncating %s: %v", list, err)
+		}
+		return
+	}
+	for _, table := range tables {
+		if _, err := testDB.Exec("DELETE FROM " + table); err != nil {
+			t.Fatalf("Error truncating %s: %v", table, err)
+		}
+		if _, err := testDB.Exec("DELETE FROM sqlite_sequence WHERE name = $1", table); err != nil {
+			t.Fatalf("Error resetting %s IDs: %v", table, err)
+		}
+	}
+}
+
+// harness is an in-process App backed by the shared test database.
+type harness struct {
+	t      *testing.T
+	app    *App
+	server *httptest.Server
+	// token is sent as the bearer token; newHarness sets it to the test
+	// user's.
+	token string
+	// tenant, if set, is sent as the X-Tenant header.
+	tenant string
+	// clock starts at 2025-05-01 so date logic is deterministic; tests move
+	// it with Set or Advance.
+	clock *clock.Fake
+}
+
+// newHarness empties every table and starts a fresh App, with its own
+// settings, runtime toggles and clock, on an httptest server. The harness
+// is signed in as testEmail.
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	truncate(t, "users", "subscriptions", "billing_history", "reminders", "notifications", "webhooks", "webhook_deliveries", "tags", "subscription_tags", "audit_log", "price_history", "transactions", "match_candidates", "alerts", "budgets", "idempotency_keys", "sessions", "households", "household_members", "household_invites", "household_subscriptions", "household_splits", "api_usage", "admin_audit_log")
+	if _, err := testDB.Exec("DELETE FROM tenants WHERE id <> $1", defaultTenant); err != nil {
+		t.Fatalf("Error deleting tenants: %v", err)
+	}
+
+	fake := clock.NewFake(time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC))
+	app, err := New(testConfig(), testDB, Services{Clock: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, app: app, server: httptest.NewServer(app.Router()), clock: fake}
+	t.Cleanup(h.server.Close)
+	return h.signup(testEmail)
+}
+
+// signup creates an account and returns a copy of the harness signed in as
+// it.
+func (h *harness) signup(email string) *harness {
+	h.t.Helper()
+	var resp struct {
+		Token string `json:"token"`
+	}
+	h.anonymous().doJSON("POST", "/api/auth/signup", credentials{Email: email, Password: testPassword}, http.StatusCreated, &resp)
+	user := *h
+	user.token = resp.Token
+	return &user
+}
+
+// asAdmin returns a copy of the harness that sends the admin token.
+func (h *harness) asAdmin() *harness {
+	admin := *h
+	admin.token = testAdminToken
+	return &admin
+}
+
+// anonymous returns a copy of the harness that sends no token.
+func (h *harness) anonymous() *harness {
+	anon := *h
+	anon.token = ""
+	return &anon
+}
+
+// do sends a request with body JSON-encoded (nil for none) and returns the
+// response with its body already read.
+func (h *harness) do(method, path string, body any) (*http.Response, []byte) {
+	h.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatalf("Error encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		h.t.Fatalf("Error building request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return h.send(req)
+}
+
+// upload posts content as the file field of a multipart form.
+func (h *harness) upload(path, filename, content string) (*http.Response, []byte) {
+	h.t.Helper()
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	io.WriteString(part, content)
+	form.Close()
+
+	req, err := http.NewRequest("POST", h.server.URL+path, &buf)
+	if err != nil {
+		h.t.Fatalf("Error building request: %v", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return h.send(req)
+}
+
+// send adds the harness's token to req and returns the response and its
+// body, after checking the response against openapi.json so that every
+// test also catches the spec drifting from the handlers.
+func (h *harness) send(req *http.Request) (*http.Response, []byte) {
+	h.t.Helper()
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	if h.tenant != "" {
+		req.Header.Set(tenantHeader, h.tenant)
+	}
+
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatalf("Error reading response body: %v", err)
+	}
+	if err := validateResponse(resp, data); err != nil && !unspecified(err, data) {
+		h.t.Errorf("%s %s: response doesn't match openapi.json: %v", req.Method, req.URL.Path, err)
+	}
+	return resp, data
+}
+
+// unspecified reports whether a response that failed validateResponse is
+// one openapi.json doesn't try to describe: one for a route that doesn't
+// exist, or the maintenance and read-only problems any route can answer
+// with.
+func unspecified(err error, body []byte) bool {
+	if errors.Is(err, routers.ErrPathNotFound) || errors.Is(err, routers.ErrMethodNotAllowed) {
+		return true
+	}
+	var p struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(body, &p)
+	return p.Code == codeMaintenance || p.Code == codeReadOnly
+}
+
+// doJSON sends a request, checks the status code and decodes the response
+// into out (if non-nil).
+func (h *harness) doJSON(method, path string, body any, wantStatus int, out any) {
+	h.t.Helper()
+
+	resp, data := h.do(method, path, body)
+	if resp.StatusCode != wantStatus {
+		h.t.Fatalf("%s %s: got status %d, want %d; body: %s", method, path, resp.StatusCode, wantStatus, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			h.t.Fatalf("%s %s: decoding %s: %v", method, path, data, err)
+		}
+	}
+}
+
+// Fixtures.
+
+func netflixFixture() models.Subscription {
+	return models.Subscription{Name: "Netflix", Category: "Entertainment", Cost: 1549, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-12"), Description: "Standard plan"}
+}
+
+func spotifyFixture() models.Subscription {
+	return models.Subscription{Name: "Spotify", Category: "Music", Cost: 1099, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-03")}
+}
+
+func awsFixture() models.Subscription {
+	return models.Subscription{Name: "AWS", Category: "Cloud", Cost: 12000, BillingCycle: "yearly", NextBilling: models.MustParseDate("2025-11-01")}
+}
+
+// createSubscription stores a fixture through the API and returns it with
+// its assigned ID.
+func (h *harness) createSubscription(s models.Subscription) models.Subscription {
+	h.t.Helper()
+	var created models.Subscription
+	h.doJSON("POST", "/api/subscriptions", s, http.StatusCreated, &created)
+	return created
+}
+
+// version fetches the version the subscription with id is at now, which
+// an update has to send.
+func (h *harness) version(id int) int {
+	h.t.Helper()
+	var current models.Subscription
+	h.doJSON("GET", subscriptionPath(id, ""), nil, http.StatusOK, &current)
+	return current.Version
+}
+
+// patchSubscription PATCHes fields onto the subscription with id at the
+// version it's at now, as a client that just fetched it would.
+func (h *harness) patchSubscription(id int, fields map[string]any, wantStatus int, out any) {
+	h.t.Helper()
+	patch := map[string]any{"version": h.version(id)}
+	for k, v := range fields {
+		patch[k] = v
+	}
+	h.doJSON("PATCH", subscriptionPath(id, ""), patch, wantStatus, out)
+}
+
+func (h *harness) importTransactions(txs ...models.Transaction) map[string]int {
+	h.t.Helper()
+	var summary map[string]int
+	h.doJSON("POST", "/api/transactions", txs, http.StatusOK, &summary)
+	return summary
+}
+
+func bankTransaction(id, description string, amount models.Money, date string) models.Transaction {
+	return models.Transaction{Source: "bank", ExternalID: id, Description: description, Amount: amount, Date: date}
+}
+
+func subscriptionPath(id int, suffix string) string {
+	return fmt.Sprintf("/api/subscriptions/%d%s", id, suffix)
+}
@@ -0,0 +1,843 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/store"
+)
+
+// householdAccess checks the caller is a member of the household in the
+// path, and its owner if ownerOnly, and returns the household's ID and the
+// caller's role. Households the caller isn't in are reported as not found,
+// as other users' subscriptions are.
+func (a *App) householdAccess(w http.ResponseWriter, r *http.Request, ownerOnly bool) (int, string, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return 0, "", false
+	}
+	var role string
+	err = a.db.QueryRowContext(r.Context(), "SELECT role FROM household_members WHERE household_id = $1 AND user_id = $2", id, userID(r)).Scan(&role)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Household not found")
+		return 0, "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return 0, "", false
+	}
+	if ownerOnly && role != models.RoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the household's owner can do that")
+		return 0, "", false
+	}
+	return id, role, true
+}
+
+// household loads a household with its members.
+func (a *App) household(ctx context.Context, id int) (models.Household, error) {
+	h := models.Household{ID: id, Members: []models.HouseholdMember{}}
+	var createdAt time.Time
+	err := a.db.QueryRowContext(ctx, "SELECT name, created_at FROM households WHERE id = $1", id).Scan(&h.Name, &createdAt)
+	if err == sql.ErrNoRows {
+		return h, store.ErrNotFound
+	}
+	if err != nil {
+		return h, err
+	}
+	h.CreatedAt = createdAt.Format(time.RFC3339)
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT m.user_id, u.email, m.role, m.joined_at
+		FROM household_members m JOIN users u ON u.id = m.user_id
+		WHERE m.household_id = $1
+		ORDER BY m.role = 'owner' DESC, m.joined_at, m.user_id
+	`, id)
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.HouseholdMember
+		var joinedAt time.Time
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role, &joinedAt); err != nil {
+			return h, err
+		}
+		m.JoinedAt = joinedAt.Format(time.RFC3339)
+		h.Members = append(h.Members, m)
+	}
+	return h, rows.Err()
+}
+
+// writeHousehold sends the household with the given status.
+func (a *App) writeHousehold(w http.ResponseWriter, r *http.Request, status, id int) {
+	h, err := a.household(r.Context(), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Household not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// inHousehold reports whether the user is already a member of a household.
+func (a *App) inHousehold(ctx context.Context, userID int) (bool, error) {
+	var n int
+	err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM household_members WHERE user_id = $1", userID).Scan(&n)
+	return n > 0, err
+}
+
+// createHousehold starts a household with the caller as its owner. A user
+// already in a household has to leave it first.
+func (a *App) createHousehold(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeValidationErrors(w, fieldErrors{{"name", "is required"}})
+		return
+	}
+	uid := userID(r)
+	if in, err := a.inHousehold(r.Context(), uid); err != nil || in {
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		} else {
+			writeError(w, http.StatusConflict, codeConflict, "You're already in a household; leave it first")
+		}
+		return
+	}
+
+	var id int
+	now := a.dbNow()
+	err := store.InTx(r.Context(), a.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(r.Context(), `
+			INSERT INTO households (name, created_at, tenant_id) SELECT $1, $2, tenant_id FROM users WHERE id = $3 RETURNING id
+		`, req.Name, now, uid).Scan(&id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(r.Context(), "INSERT INTO household_members (household_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4)",
+			id, uid, models.RoleOwner, now)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.writeHousehold(w, r, http.StatusCreated, id)
+}
+
+// getHousehold returns a household the caller is in, with its members.
+func (a *App) getHousehold(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	a.writeHousehold(w, r, http.StatusOK, id)
+}
+
+// deleteHousehold disbands a household. Shared subscriptions go back to
+// being their owners' own.
+func (a *App) deleteHousehold(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, true)
+	if !ok {
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM households WHERE id = $1", id); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeHouseholdMember takes a member out of the household: the owner can
+// remove anyone else, and any other member can leave. The member's shared
+// subscriptions stop being shared, and subscriptions with splits naming
+// them go back to an equal split between those left.
+func (a *App) removeHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	member, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid user ID")
+		return
+	}
+	uid := userID(r)
+	switch {
+	case member == uid && role == models.RoleOwner:
+		writeError(w, http.StatusConflict, codeConflict, "The owner can't leave the household; delete it instead")
+		return
+	case member != uid && role != models.RoleOwner:
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the household's owner can remove other members")
+		return
+	}
+
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	// The member's subscriptions are unshared while they're still a member,
+	// since once they've left the row-level security policies no longer
+	// show them the household when they're the one leaving.
+	for _, query := range []string{
+		`DELETE FROM household_subscriptions WHERE household_id = $1
+			AND subscription_id IN (SELECT id FROM subscriptions WHERE user_id = $2)`,
+		`DELETE FROM household_splits WHERE subscription_id IN (
+			SELECT subscription_id FROM household_splits WHERE user_id = $2
+			AND subscription_id IN (SELECT subscription_id FROM household_subscriptions WHERE household_id = $1))`,
+	} {
+		if _, err := tx.ExecContext(r.Context(), query, id, member); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+	}
+	res, err := tx.ExecContext(r.Context(), "DELETE FROM household_members WHERE household_id = $1 AND user_id = $2", id, member)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Member not found")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// householdInvites lists the pending invites matching where, a condition
+// on the invite i taking arg as $1.
+func (a *App) householdInvites(ctx context.Context, where string, arg any) ([]models.HouseholdInvite, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT i.id, i.household_id, h.name, i.email, u.email, i.created_at
+		FROM household_invites i
+		JOIN households h ON h.id = i.household_id
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE `+where+`
+		ORDER BY i.id
+	`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.HouseholdInvite{}
+	for rows.Next() {
+		var inv models.HouseholdInvite
+		var createdAt time.Time
+		if err := rows.Scan(&inv.ID, &inv.HouseholdID, &inv.Household, &inv.Email, &inv.InvitedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		inv.CreatedAt = createdAt.Format(time.RFC3339)
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// writeInvites sends a list of invites, or the error loading them.
+func writeInvites(w http.ResponseWriter, invites []models.HouseholdInvite, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invites); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getHouseholdInvites lists a household's pending invites.
+func (a *App) getHouseholdInvites(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	invites, err := a.householdInvites(r.Context(), "i.household_id = $1", id)
+	writeInvites(w, invites, err)
+}
+
+// inviteHouseholdMember invites an email address to the household. The
+// invite is emailed if mail is set up; either way the account with that
+// email sees it in GET /api/me/invites, and one signed up later will too.
+func (a *App) inviteHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		writeValidationErrors(w, fieldErrors{{"email", "must be a valid email address"}})
+		return
+	}
+	h, err := a.household(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	var inviter string
+	for _, m := range h.Members {
+		if m.Email == req.Email {
+			writeError(w, http.StatusConflict, codeConflict, "That account is already a member")
+			return
+		}
+		if m.UserID == userID(r) {
+			inviter = m.Email
+		}
+	}
+
+	inv := models.HouseholdInvite{HouseholdID: id, Household: h.Name, Email: req.Email, InvitedBy: &inviter}
+	now := a.dbNow()
+	err = a.db.QueryRowContext(r.Context(), `
+		INSERT INTO household_invites (household_id, email, invited_by, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (household_id, email) DO NOTHING
+		RETURNING id
+	`, id, req.Email, userID(r), now).Scan(&inv.ID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusConflict, codeConflict, "That email has already been invited")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	inv.CreatedAt = now.Format(time.RFC3339)
+	a.sendInvite(r.Context(), inv)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(inv); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// sendInvite emails an invite if mail is configured. The invite stands
+// whether or not it's sent, so failures are only logged.
+func (a *App) sendInvite(ctx context.Context, inv models.HouseholdInvite) {
+	if _, off := a.mailer.(unconfigured); off {
+		return
+	}
+	err := a.mailer.Send(ctx, notify.Email{
+		To:      []string{inv.Email},
+		Subject: fmt.Sprintf("You're invited to join %s", inv.Household),
+		TextBody: fmt.Sprintf("%s invited you to share subscriptions in the household %q on Subscription Tracker.\n\n"+
+			"Sign in, or sign up with this email address, and accept the invite to join.\n", *inv.InvitedBy, inv.Household),
+	})
+	a.integrations.report("smtp", err)
+	if err != nil {
+		a.logger.WarnContext(ctx, "sending household invite", "invite", inv.ID, "err", err)
+	}
+}
+
+// deleteHouseholdInvite withdraws a pending invite.
+func (a *App) deleteHouseholdInvite(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, true)
+	if !ok {
+		return
+	}
+	invite, err := strconv.Atoi(mux.Vars(r)["inviteId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid invite ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM household_invites WHERE id = $1 AND household_id = $2", invite, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Invite not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteForUser is the householdInvites condition for the invites that
+// reach the user $1: those for its email to households in its tenant. An
+// email can have an account in each tenant, and each only gets its own
+// tenant's invites.
+const inviteForUser = "EXISTS (SELECT 1 FROM users me WHERE me.id = $1 AND me.email = i.email AND me.tenant_id = h.tenant_id)"
+
+// getMyInvites lists the invites for the caller's email address to
+// households in the caller's tenant.
+func (a *App) getMyInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := a.householdInvites(r.Context(), inviteForUser, userID(r))
+	writeInvites(w, invites, err)
+}
+
+// myInvite checks the invite in the path is for the caller's email, to a
+// household in the caller's tenant, and returns the household it's to.
+func (a *App) myInvite(w http.ResponseWriter, r *http.Request) (inviteID, householdID int, ok bool) {
+	inviteID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return 0, 0, false
+	}
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT i.household_id FROM household_invites i
+		JOIN users u ON u.email = i.email
+		JOIN households h ON h.id = i.household_id AND h.tenant_id = u.tenant_id
+		WHERE i.id = $1 AND u.id = $2
+	`, inviteID, userID(r)).Scan(&householdID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Invite not found")
+		return 0, 0, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return 0, 0, false
+	}
+	return inviteID, householdID, true
+}
+
+// acceptInvite joins the household the invite is to and returns it.
+func (a *App) acceptInvite(w http.ResponseWriter, r *http.Request) {
+	invite, id, ok := a.myInvite(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	if in, err := a.inHousehold(r.Context(), uid); err != nil || in {
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		} else {
+			writeError(w, http.StatusConflict, codeConflict, "You're already in a household; leave it first")
+		}
+		return
+	}
+
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(r.Context(), `
+		INSERT INTO household_members (household_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO NOTHING
+	`, id, uid, models.RoleMember, a.dbNow())
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			// Joined another household since the check above.
+			writeError(w, http.StatusConflict, codeConflict, "You're already in a household; leave it first")
+			return
+		}
+		_, err = tx.ExecContext(r.Context(), "DELETE FROM household_invites WHERE id = $1", invite)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.writeHousehold(w, r, http.StatusOK, id)
+}
+
+// declineInvite turns an invite down.
+func (a *App) declineInvite(w http.ResponseWriter, r *http.Request) {
+	invite, _, ok := a.myInvite(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM household_invites WHERE id = $1", invite); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// householdSplits returns the cost splits set for the household's shared
+// subscriptions, by subscription.
+func (a *App) householdSplits(ctx context.Context, householdID int) (map[int][]models.CostSplit, error) {
+	splits := map[int][]models.CostSplit{}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT sp.subscription_id, sp.user_id, sp.percent
+		FROM household_splits sp JOIN household_subscriptions hs ON hs.subscription_id = sp.subscription_id
+		WHERE hs.household_id = $1
+		ORDER BY sp.subscription_id, sp.user_id
+	`, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var split models.CostSplit
+		if err := rows.Scan(&id, &split.UserID, &split.Percent); err != nil {
+			return nil, err
+		}
+		splits[id] = append(splits[id], split)
+	}
+	return splits, rows.Err()
+}
+
+// sharedSubscriptions lists the subscriptions shared with a household, by
+// name, each with what its members pay.
+func (a *App) sharedSubscriptions(ctx context.Context, h models.Household) ([]models.SharedSubscription, error) {
+	splits, err := a.householdSplits(ctx, h.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Collect the IDs first: loading each subscription takes a connection
+	// of its own, which SQLite's single one can't spare while rows is open.
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT s.id, s.user_id FROM household_subscriptions hs JOIN subscriptions s ON s.id = hs.subscription_id
+		WHERE hs.household_id = $1
+		ORDER BY s.name, s.id
+	`, h.ID)
+	if err != nil {
+		return nil, err
+	}
+	type shared struct{ id, owner int }
+	var ids []shared
+	for rows.Next() {
+		var s shared
+		if err := rows.Scan(&s.id, &s.owner); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	subs := []models.SharedSubscription{}
+	for _, id := range ids {
+		s, err := a.subscriptions.Get(ctx, id.owner, id.id)
+		if err == store.ErrNotFound {
+			continue // deleted since
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.setStale(&s)
+		shared := models.SharedSubscription{Subscription: s, OwnerID: id.owner, Splits: splits[id.id]}
+		if len(shared.Splits) == 0 {
+			shared.Splits, shared.EqualSplit = equalSplits(h.Members), true
+		}
+		subs = append(subs, shared)
+	}
+	return subs, nil
+}
+
+// equalSplits divides a cost equally between the members.
+func equalSplits(members []models.HouseholdMember) []models.CostSplit {
+	splits := make([]models.CostSplit, len(members))
+	for i, m := range members {
+		splits[i] = models.CostSplit{UserID: m.UserID, Percent: 100 / float64(len(members))}
+	}
+	return splits
+}
+
+// loadHousehold loads the household in the path, writing the error if it
+// can't.
+func (a *App) loadHousehold(w http.ResponseWriter, r *http.Request, id int) (models.Household, bool) {
+	h, err := a.household(r.Context(), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Household not found")
+		return h, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return h, false
+	}
+	return h, true
+}
+
+// getSharedSubscriptions lists the subscriptions shared with a household.
+func (a *App) getSharedSubscriptions(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	h, ok := a.loadHousehold(w, r, id)
+	if !ok {
+		return
+	}
+	subs, err := a.sharedSubscriptions(r.Context(), h)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// validateSplits checks cost splits against the household: each for a
+// different member, each more than 0 percent with at most two decimals,
+// and adding up to 100. No splits at all means an equal split.
+func validateSplits(splits []models.CostSplit, h models.Household) fieldErrors {
+	var errs fieldErrors
+	if len(splits) == 0 {
+		return nil
+	}
+	members := map[int]bool{}
+	for _, m := range h.Members {
+		members[m.UserID] = true
+	}
+	seen := map[int]bool{}
+	var total float64
+	for _, s := range splits {
+		switch {
+		case !members[s.UserID]:
+			errs.add("splits", fmt.Sprintf("user %d isn't a member of the household", s.UserID))
+		case seen[s.UserID]:
+			errs.add("splits", fmt.Sprintf("user %d appears more than once", s.UserID))
+		case s.Percent <= 0 || s.Percent > 100 || roundCents(s.Percent) != s.Percent:
+			errs.add("splits", "each percent must be more than 0 and at most 100, with up to two decimals")
+		}
+		seen[s.UserID] = true
+		total += s.Percent
+	}
+	if len(errs) == 0 && math.Round(total*100) != 10000 {
+		errs.add("splits", fmt.Sprintf("percentages must add up to 100, not %g", roundCents(total)))
+	}
+	return errs
+}
+
+// shareSubscription shares one of the caller's subscriptions with their
+// household, or changes how it's split if it's already shared. The body's
+// splits give each member's percentage; leave them out to split equally.
+func (a *App) shareSubscription(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	subID, err := strconv.Atoi(mux.Vars(r)["subscriptionId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid subscription ID")
+		return
+	}
+	var req struct {
+		Splits []models.CostSplit `json:"splits"`
+	}
+	// An empty body shares with an equal split.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	uid := userID(r)
+	_, err = a.subscriptions.Get(r.Context(), uid, subID)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	h, ok := a.loadHousehold(w, r, id)
+	if !ok {
+		return
+	}
+	if errs := validateSplits(req.Splits, h); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	err = store.InTx(r.Context(), a.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), `
+			INSERT INTO household_subscriptions (subscription_id, household_id, shared_at) VALUES ($1, $2, $3)
+			ON CONFLICT (subscription_id) DO NOTHING
+		`, subID, id, a.dbNow()); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM household_splits WHERE subscription_id = $1", subID); err != nil {
+			return err
+		}
+		for _, s := range req.Splits {
+			if _, err := tx.ExecContext(r.Context(), "INSERT INTO household_splits (subscription_id, user_id, percent) VALUES ($1, $2, $3)", subID, s.UserID, s.Percent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	subs, err := a.sharedSubscriptions(r.Context(), h)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for _, s := range subs {
+		if s.ID == subID {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(s); err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+			}
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+}
+
+// unshareSubscription stops sharing a subscription with the household. Its
+// owner or the household's owner can do that.
+func (a *App) unshareSubscription(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	subID, err := strconv.Atoi(mux.Vars(r)["subscriptionId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid subscription ID")
+		return
+	}
+	var owner int
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT s.user_id FROM household_subscriptions hs JOIN subscriptions s ON s.id = hs.subscription_id
+		WHERE hs.subscription_id = $1 AND hs.household_id = $2
+	`, subID, id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not shared with this household")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if owner != userID(r) && role != models.RoleOwner {
+		writeError(w, http.StatusForbidden, codeForbidden, "Only the subscription's or the household's owner can stop sharing it")
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM household_subscriptions WHERE subscription_id = $1", subID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberShare is one member's part of a household's shared spending.
+type memberShare struct {
+	UserID  int          `json:"userId"`
+	Email   string       `json:"email"`
+	Monthly models.Money `json:"monthly"`
+	Yearly  models.Money `json:"yearly"`
+}
+
+// householdStats is the response of GET /api/households/{id}/stats.
+type householdStats struct {
+	Currency       string        `json:"currency"`
+	TotalMonthly   models.Money  `json:"totalMonthly"`
+	TotalYearly    models.Money  `json:"totalYearly"`
+	MyShareMonthly models.Money  `json:"myShareMonthly"`
+	MyShareYearly  models.Money  `json:"myShareYearly"`
+	ByMember       []memberShare `json:"byMember"`
+}
+
+// splitSpending totals the yearly cost of the active shared subscriptions,
+// converted by convert, and each member's share of it. Shares are rounded
+// to the cent per subscription, so they can add up to a cent or two off
+// the total. On error it also returns the currency that couldn't be
+// converted.
+func splitSpending(subs []models.SharedSubscription, convert func(from string, amount models.Money) (models.Money, error)) (models.Money, map[int]models.Money, string, error) {
+	var total models.Money
+	byMember := map[int]models.Money{}
+	for _, s := range subs {
+		if s.Status != models.StatusActive {
+			continue
+		}
+		yearly, err := convert(s.Currency, yearlyCost(s.Subscription))
+		if err != nil {
+			return 0, nil, s.Currency, err
+		}
+		total += yearly
+		for _, split := range s.Splits {
+			byMember[split.UserID] += models.MoneyFromFloat(yearly.Float() * split.Percent / 100)
+		}
+	}
+	return total, byMember, "", nil
+}
+
+// getHouseholdStats compares what the caller pays for the household's
+// shared subscriptions with their total, normalized as in the stats and in
+// the caller's display currency or ?currency. Paused and cancelled
+// subscriptions cost nothing.
+func (a *App) getHouseholdStats(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := a.householdAccess(w, r, false)
+	if !ok {
+		return
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+	h, ok := a.loadHousehold(w, r, id)
+	if !ok {
+		return
+	}
+	subs, err := a.sharedSubscriptions(r.Context(), h)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	total, byMember, unconverted, err := splitSpending(subs, a.converter(r.Context(), currency))
+	if err != nil {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+
+	stats := householdStats{
+		Currency:     currency,
+		TotalMonthly: perMonth(total),
+		TotalYearly:  total,
+		ByMember:     []memberShare{},
+	}
+	for _, m := range h.Members {
+		share := memberShare{UserID: m.UserID, Email: m.Email, Monthly: perMonth(byMember[m.UserID]), Yearly: byMember[m.UserID]}
+		if m.UserID == userID(r) {
+			stats.MyShareMonthly, stats.MyShareYearly = share.Monthly, share.Yearly
+		}
+		stats.ByMember = append(stats.ByMember, share)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
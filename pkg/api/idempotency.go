@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a response is kept for replay.
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength caps the Idempotency-Key header.
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers kept with an idempotency key
+// and sent again with the replay, besides Content-Type. Headers about the
+// request rather than the response, such as X-Request-ID, aren't.
+var replayedHeaders = []string{"Location", "ETag"}
+
+// idempotent lets clients retry next safely. A request carrying an
+// Idempotency-Key header the user has sent in the last day gets the first
+// response again, with its replayedHeaders and Idempotent-Replayed: true,
+// instead of being run
+// twice. Reusing a key for a different request is a 422, and retrying while
+// the first is still running a 409. Server errors aren't kept, so a retry
+// after one runs again. An App without a database can't keep responses,
+// so it ignores the header.
+func (a *App) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || a.db == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+		body, ok := readJSONBody(w, r)
+		if !ok {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		hash := hex.EncodeToString(sum[:])
+
+		uid, now := userID(r), a.dbNow()
+		if _, err := a.db.ExecContext(r.Context(), "DELETE FROM idempotency_keys WHERE user_id = $1 AND created_at <= $2", uid, now.Add(-idempotencyTTL)); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		res, err := a.db.ExecContext(r.Context(), `
+			INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, idempotency_key) DO NOTHING
+		`, uid, key, hash, now)
+		var claimed int64
+		if err == nil {
+			claimed, err = res.RowsAffected()
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if claimed == 0 {
+			a.replayIdempotent(w, r, uid, key, hash)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// Store the outcome even if the client has gone, so its retry
+		// doesn't find the key stuck in progress.
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 500 {
+			_, err = a.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2", uid, key)
+		} else {
+			headers := map[string]string{}
+			for _, name := range replayedHeaders {
+				if v := rec.Header().Get(name); v != "" {
+					headers[name] = v
+				}
+			}
+			stored, _ := json.Marshal(headers)
+			_, err = a.db.ExecContext(ctx, `
+				UPDATE idempotency_keys SET status = $1, content_type = $2, headers = $3, body = $4
+				WHERE user_id = $5 AND idempotency_key = $6
+			`, rec.status, rec.Header().Get("Content-Type"), string(stored), rec.body.String(), uid, key)
+		}
+		if err != nil {
+			a.logger.WarnContext(ctx, "storing idempotent response", "key", key, "err", err)
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key is already taken.
+func (a *App) replayIdempotent(w http.ResponseWriter, r *http.Request, uid int, key, hash string) {
+	var stored string
+	var status sql.NullInt64
+	var contentType, headers, body string
+	err := a.db.QueryRowContext(r.Context(), `
+		SELECT request_hash, status, content_type, headers, body FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2
+	`, uid, key).Scan(&stored, &status, &contentType, &headers, &body)
+	switch {
+	case err == sql.ErrNoRows:
+		// The first request failed and let the key go in the meantime.
+		writeError(w, http.StatusConflict, codeConflict, "The first request with this Idempotency-Key has just failed; retry it")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	case stored != hash:
+		writeError(w, http.StatusUnprocessableEntity, codeIdempotencyReuse, "This Idempotency-Key was already used for a different request")
+		return
+	case !status.Valid:
+		writeError(w, http.StatusConflict, codeConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	var replayed map[string]string
+	if err := json.Unmarshal([]byte(headers), &replayed); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Stored response headers: %v", err))
+		return
+	}
+	for name, v := range replayed {
+		w.Header().Set(name, v)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(status.Int64))
+	io.WriteString(w, body)
+}
+
+// idempotencyRecorder passes a response through, keeping a copy of its
+// status and body.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// unusedAfterMonths is how long a subscription can go without being
+// edited or confirmed before the insights suggest it's unused.
+const unusedAfterMonths = 6
+
+// defaultCostPerUseDays is how far back the insights look at logged uses
+// without ?usageDays; maxCostPerUseDays is the furthest they can.
+const (
+	defaultCostPerUseDays = 30
+	maxCostPerUseDays     = 365
+)
+
+// yearlyPlanMonths is what a yearly plan is assumed to cost, in months of
+// the shorter cycle: most services charge ten months' worth, or two months
+// free.
+const yearlyPlanMonths = 10
+
+// unusedInsight is an active subscription nobody has edited or confirmed
+// in unusedAfterMonths. Cancelling it saves YearlyCost.
+type unusedInsight struct {
+	models.Subscription
+	LastActivity string       `json:"lastActivity"`
+	YearlyCost   models.Money `json:"yearlyCost"`
+}
+
+// overlapInsight is two or more active subscriptions in the same
+// category, such as several video streaming services.
+type overlapInsight struct {
+	Category      string                `json:"category"`
+	Subscriptions []models.Subscription `json:"subscriptions"`
+	YearlyCost    models.Money          `json:"yearlyCost"`
+}
+
+// cycleInsight is a subscription billed more than once a year whose
+// yearly plan, priced at yearlyPlanMonths, would cost YearlySavings less.
+type cycleInsight struct {
+	SubscriptionID int          `json:"subscriptionId"`
+	Name           string       `json:"name"`
+	BillingCycle   string       `json:"billingCycle"`
+	YearlyCost     models.Money `json:"yearlyCost"`
+	YearlyPlanCost models.Money `json:"yearlyPlanCost"`
+	YearlySavings  models.Money `json:"yearlySavings"`
+}
+
+// costPerUseInsight is what each logged use of a subscription cost over
+// the last usageDays days: its cost for that stretch, spread over its
+// uses.
+type costPerUseInsight struct {
+	SubscriptionID int          `json:"subscriptionId"`
+	Name           string       `json:"name"`
+	Uses           int          `json:"uses"`
+	Cost           models.Money `json:"cost"`
+	CostPerUse     models.Money `json:"costPerUse"`
+}
+
+// idleInsight is an active subscription whose uses are logged but that
+// hasn't been used in usageDays days, a candidate for cancelling.
+// Cancelling it saves YearlyCost.
+type idleInsight struct {
+	models.Subscription
+	LastUsed   models.Date  `json:"lastUsed"`
+	YearlyCost models.Money `json:"yearlyCost"`
+}
+
+type insights struct {
+	Currency string `json:"currency"`
+	// PotentialSavings is what cancelling the unused and idle
+	// subscriptions and switching the rest to yearly plans would save in
+	// a year. Overlaps aren't counted, since which one to keep is the
+	// user's call.
+	PotentialSavings models.Money     `json:"potentialSavings"`
+	Unused           []unusedInsight  `json:"unused"`
+	Overlapping      []overlapInsight `json:"overlapping"`
+	SwitchToYearly   []cycleInsight   `json:"switchToYearly"`
+	PriceIncreases   []priceIncrease  `json:"priceIncreases"`
+	// UsageDays is the window CostPerUse and CancellationCandidates are
+	// worked out over.
+	UsageDays              int                 `json:"usageDays"`
+	CostPerUse             []costPerUseInsight `json:"costPerUse"`
+	CancellationCandidates []idleInsight       `json:"cancellationCandidates"`
+}
+
+// lastActivity is when each of the user's subscriptions was last edited
+// by someone, as opposed to rolled forward by the server. An App without
+// a database keeps no audit log, so it has none.
+func (a *App) lastActivity(ctx context.Context, userID int) (map[int]time.Time, error) {
+	last := map[int]time.Time{}
+	if a.db == nil {
+		return last, nil
+	}
+	rows, err := a.stmts.QueryContext(ctx, `
+		SELECT subscription_id, created_at FROM audit_log
+		WHERE user_id = $1 AND actor_id IS NOT NULL
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		if at.After(last[id]) {
+			last[id] = at
+		}
+	}
+	return last, rows.Err()
+}
+
+// getInsights suggests where the user could save: active subscriptions
+// that look unused, categories with more than one subscription, plans
+// that would be cheaper billed yearly, and prices that went up in the
+// last year. For subscriptions whose uses are logged, it works out the
+// cost per use over the last ?usageDays days and flags those not used in
+// that time. Amounts are converted like the stats, except the price
+// increases, which stay in each subscription's currency.
+func (a *App) getInsights(w http.ResponseWriter, r *http.Request) {
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+	usageDays := defaultCostPerUseDays
+	if v := r.URL.Query().Get("usageDays"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCostPerUseDays {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("usageDays must be between 1 and %d", maxCostPerUseDays))
+			return
+		}
+		usageDays = n
+	}
+	uid := userID(r)
+	subs, _, err := a.subscriptions.List(r.Context(), uid, store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	edited, err := a.lastActivity(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	now := a.clock.Now()
+	usage, err := a.usesSince(r.Context(), uid, models.NewDate(now.Date()).AddDate(0, 0, -usageDays))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	result := insights{
+		Currency:               currency,
+		Unused:                 []unusedInsight{},
+		Overlapping:            []overlapInsight{},
+		SwitchToYearly:         []cycleInsight{},
+		UsageDays:              usageDays,
+		CostPerUse:             []costPerUseInsight{},
+		CancellationCandidates: []idleInsight{},
+	}
+	convert := a.converter(r.Context(), currency)
+	cutoff := now.AddDate(0, -unusedAfterMonths, 0)
+	byCategory := map[string]*overlapInsight{}
+	var categories []string
+	for _, s := range subs {
+		a.setStale(&s)
+		yearly, err := convert(s.Currency, yearlyCost(s))
+		if err != nil {
+			a.writeConversionError(w, s.Currency, currency, err)
+			return
+		}
+
+		key := strings.ToLower(strings.TrimSpace(s.Category))
+		o := byCategory[key]
+		if o == nil {
+			o = &overlapInsight{Category: s.Category}
+			byCategory[key] = o
+			categories = append(categories, key)
+		}
+		o.Subscriptions = append(o.Subscriptions, s)
+		o.YearlyCost += yearly
+
+		// Activity is the latest of adding, confirming, editing and using
+		// it.
+		active, _ := time.Parse(time.RFC3339, s.CreatedAt)
+		if s.LastVerifiedAt != nil {
+			if verified, err := time.Parse(time.RFC3339, *s.LastVerifiedAt); err == nil && verified.After(active) {
+				active = verified
+			}
+		}
+		if at := edited[s.ID]; at.After(active) {
+			active = at
+		}
+		u, tracked := usage[s.ID]
+		if at := u.LastUsed.Time(); tracked && at.After(active) {
+			active = at
+		}
+		if active.Before(cutoff) {
+			result.Unused = append(result.Unused, unusedInsight{Subscription: s, LastActivity: active.UTC().Format(time.RFC3339), YearlyCost: yearly})
+			result.PotentialSavings += yearly
+			continue
+		}
+		switch {
+		case tracked && u.Uses == 0:
+			result.CancellationCandidates = append(result.CancellationCandidates, idleInsight{Subscription: s, LastUsed: u.LastUsed, YearlyCost: yearly})
+			result.PotentialSavings += yearly
+			continue
+		case tracked:
+			cost := models.MoneyFromFloat(yearly.Float() * float64(usageDays) / 365)
+			result.CostPerUse = append(result.CostPerUse, costPerUseInsight{
+				SubscriptionID: s.ID,
+				Name:           s.Name,
+				Uses:           u.Uses,
+				Cost:           cost,
+				CostPerUse:     models.MoneyFromFloat(cost.Float() / float64(u.Uses)),
+			})
+		}
+
+		charges, years, ok := cyclesPerYear(s.BillingCycle)
+		if !ok || s.IsTrial || charges <= years {
+			continue
+		}
+		plan := models.MoneyFromFloat(yearly.Float() * yearlyPlanMonths / 12)
+		result.SwitchToYearly = append(result.SwitchToYearly, cycleInsight{
+			SubscriptionID: s.ID,
+			Name:           s.Name,
+			BillingCycle:   s.BillingCycle,
+			YearlyCost:     yearly,
+			YearlyPlanCost: plan,
+			YearlySavings:  yearly - plan,
+		})
+		result.PotentialSavings += yearly - plan
+	}
+	for _, key := range categories {
+		if o := byCategory[key]; len(o.Subscriptions) > 1 {
+			result.Overlapping = append(result.Overlapping, *o)
+		}
+	}
+
+	result.PriceIncreases, err = a.priceIncreases(r.Context(), uid, subs, a.dbNow())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	// Biggest first; ties keep the list's order.
+	slices.SortStableFunc(result.Unused, func(x, y unusedInsight) int { return cmp.Compare(y.YearlyCost, x.YearlyCost) })
+	slices.SortStableFunc(result.Overlapping, func(x, y overlapInsight) int { return cmp.Compare(y.YearlyCost, x.YearlyCost) })
+	slices.SortStableFunc(result.SwitchToYearly, func(x, y cycleInsight) int { return cmp.Compare(y.YearlySavings, x.YearlySavings) })
+	slices.SortStableFunc(result.CostPerUse, func(x, y costPerUseInsight) int { return cmp.Compare(y.CostPerUse, x.CostPerUse) })
+	slices.SortStableFunc(result.CancellationCandidates, func(x, y idleInsight) int { return cmp.Compare(y.YearlyCost, x.YearlyCost) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,5423 @@
+//go:build integration
+
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"subscription-tracker/pkg/blobs"
+	"subscription-tracker/pkg/catalog"
+	"subscription-tracker/pkg/clock"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/rates"
+	"subscription-tracker/pkg/store"
+	"subscription-tracker/pkg/subscriptionsv1"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	h := newHarness(t)
+
+	for _, path := range []string{"/api/health", "/api/dbcheck", "/api/status", "/api/version"} {
+		var body map[string]any
+		h.doJSON("GET", path, nil, http.StatusOK, &body)
+		if len(body) == 0 {
+			t.Errorf("GET %s: empty body", path)
+		}
+	}
+
+	// The version reports the build the server was given.
+	h.app.config.Build = BuildInfo{Version: "v1.2.0", GitSHA: "abc123", BuildTime: "2025-05-01T12:00:00Z"}
+	var version map[string]any
+	h.anonymous().doJSON("GET", "/api/version", nil, http.StatusOK, &version)
+	if version["version"] != "v1.2.0" || version["gitSha"] != "abc123" || version["buildTime"] != "2025-05-01T12:00:00Z" ||
+		version["schemaVersion"] != float64(store.SchemaVersion) || version["schemaUpToDate"] != true {
+		t.Errorf("version = %v", version)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	h := newHarness(t)
+
+	var logs bytes.Buffer
+	h.app.logger = slog.New(NewLogHandler(slog.NewJSONHandler(&logs, nil)))
+
+	resp, _ := h.do("GET", "/api/subscriptions", nil)
+	generated := resp.Header.Get(RequestIDHeader)
+	if len(generated) != 16 {
+		t.Errorf("generated request ID = %q", generated)
+	}
+
+	for _, c := range []struct{ sent, want string }{
+		{"edge-1234.abc", "edge-1234.abc"},
+		{"has spaces\tand tabs", ""},
+		{strings.Repeat("x", 200), ""},
+	} {
+		req, _ := http.NewRequest("GET", h.server.URL+"/api/health", nil)
+		req.Header.Set(RequestIDHeader, c.sent)
+		resp, _ := h.send(req)
+		got := resp.Header.Get(RequestIDHeader)
+		if c.want != "" && got != c.want || c.want == "" && (got == c.sent || got == "") {
+			t.Errorf("sent %q, got back %q", c.sent, got)
+		}
+	}
+
+	var line struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		Duration  int64  `json:"duration"`
+	}
+	first, _, _ := bytes.Cut(logs.Bytes(), []byte("\n"))
+	if err := json.Unmarshal(first, &line); err != nil {
+		t.Fatalf("log line %q: %v", first, err)
+	}
+	if line.Msg != "request" || line.RequestID != generated || line.Method != "GET" || line.Path != "/api/subscriptions" || line.Status != http.StatusOK {
+		t.Errorf("unexpected log line: %s", first)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	h := newHarness(t)
+	sub := h.createSubscription(netflixFixture())
+
+	var logs bytes.Buffer
+	h.app.logger = slog.New(NewLogHandler(slog.NewJSONHandler(&logs, nil)))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest("GET", h.server.URL+subscriptionPath(sub.ID, ""), nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if resp, body := h.send(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+
+	var server sdktrace.ReadOnlySpan
+	queries := 0
+	for _, s := range recorder.Ended() {
+		if s.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		switch {
+		case s.SpanKind() == trace.SpanKindServer:
+			server = s
+		case s.SpanKind() == trace.SpanKindClient && strings.HasPrefix(s.Name(), "sql."):
+			queries++
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span continues the propagated trace")
+	}
+	if server.Name() != "GET /api/subscriptions/{id}" {
+		t.Errorf("server span name = %q", server.Name())
+	}
+	if queries == 0 {
+		t.Error("no database spans in the request's trace")
+	}
+
+	if !strings.Contains(logs.String(), `"trace_id":"`+traceID+`"`) {
+		t.Errorf("request log lacks the trace ID: %s", logs.String())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous()
+
+	anon.doJSON("GET", "/api/subscriptions", nil, http.StatusUnauthorized, nil)
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, nil)
+	h.asAdmin().doJSON("GET", "/api/subscriptions", nil, http.StatusUnauthorized, nil)
+
+	var me models.User
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	if me.ID == 0 || me.Email != testEmail {
+		t.Errorf("unexpected user: %+v", me)
+	}
+
+	anon.doJSON("POST", "/api/auth/signup", credentials{Email: "TEST@example.com", Password: testPassword}, http.StatusConflict, nil)
+	anon.doJSON("POST", "/api/auth/signup", credentials{Email: "not-an-email", Password: testPassword}, http.StatusBadRequest, nil)
+	anon.doJSON("POST", "/api/auth/signup", credentials{Email: "short@example.com", Password: "short"}, http.StatusBadRequest, nil)
+
+	var login authResponse
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusOK, &login)
+	if login.Token == "" || login.User.ID != me.ID {
+		t.Errorf("unexpected login response: %+v", login)
+	}
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: "wrong-password"}, http.StatusUnauthorized, nil)
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: "nobody@example.com", Password: testPassword}, http.StatusUnauthorized, nil)
+
+	h.app.config.TokenTTL = -time.Minute
+	expired, err := h.app.issueToken(me.ID, defaultTenant, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *h
+	stale.token = expired
+	stale.doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+}
+
+func TestSessions(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous()
+	as := func(token string) *harness {
+		user := *h
+		user.token = token
+		return &user
+	}
+	login := func() authResponse {
+		var resp authResponse
+		anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusOK, &resp)
+		return resp
+	}
+	sessionOf := func(resp authResponse) int {
+		_, _, id, err := h.app.parseToken(resp.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	phone := login()
+	if phone.RefreshToken == "" || phone.ExpiresIn != 3600 {
+		t.Fatalf("login response = %+v", phone)
+	}
+	var sessions []models.Session
+	h.doJSON("GET", "/api/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 2 || !sessions[1].Current || sessions[0].Current || sessions[0].UserAgent == "" || sessions[0].IP == "" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+
+	// Each refresh token works once and is replaced by the next.
+	var refreshed authResponse
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: phone.RefreshToken}, http.StatusOK, &refreshed)
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == phone.RefreshToken || refreshed.User.Email != testEmail {
+		t.Fatalf("refresh response = %+v", refreshed)
+	}
+	as(refreshed.Token).doJSON("GET", "/api/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 2 || !sessions[0].Current || sessions[0].ID != sessionOf(phone) {
+		t.Errorf("sessions after refreshing = %+v", sessions)
+	}
+
+	// Replaying the old token means it leaked: the session is revoked, so
+	// the new one stops working too.
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: phone.RefreshToken}, http.StatusUnauthorized, nil)
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: refreshed.RefreshToken}, http.StatusUnauthorized, nil)
+	h.doJSON("GET", "/api/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("sessions after reuse = %+v", sessions)
+	}
+
+	// The access tokens of a revoked session stop working with it.
+	as(refreshed.Token).doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+
+	laptop := login()
+	as(laptop.Token).doJSON("GET", "/api/me", nil, http.StatusOK, nil)
+	h.signup("other@example.com").doJSON("DELETE", fmt.Sprintf("/api/sessions/%d", sessionOf(laptop)), nil, http.StatusNotFound, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/sessions/%d", sessionOf(laptop)), nil, http.StatusNoContent, nil)
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: laptop.RefreshToken}, http.StatusUnauthorized, nil)
+	as(laptop.Token).doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/sessions/%d", sessionOf(laptop)), nil, http.StatusNotFound, nil)
+
+	tablet := login()
+	anon.doJSON("POST", "/api/auth/logout", map[string]any{}, http.StatusBadRequest, nil)
+	anon.doJSON("POST", "/api/auth/logout", refreshRequest{RefreshToken: tablet.RefreshToken}, http.StatusNoContent, nil)
+	anon.doJSON("POST", "/api/auth/logout", refreshRequest{RefreshToken: tablet.RefreshToken}, http.StatusNoContent, nil)
+	as(tablet.Token).doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: tablet.RefreshToken}, http.StatusUnauthorized, nil)
+
+	h.app.config.RefreshTokenTTL = -time.Minute
+	expired := login()
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: expired.RefreshToken}, http.StatusUnauthorized, nil)
+	h.doJSON("GET", "/api/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 1 {
+		t.Errorf("sessions at the end = %+v", sessions)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous()
+	login := func(code string, wantStatus int) {
+		t.Helper()
+		anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword, Code: code}, wantStatus, nil)
+	}
+	codeAt := func(secret string, step int64) string {
+		t.Helper()
+		code, err := totpCode(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	var setup struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	h.doJSON("POST", "/api/auth/2fa/verify", twoFactorCode{Code: "123456"}, http.StatusConflict, nil)
+	h.doJSON("POST", "/api/auth/2fa/setup", nil, http.StatusOK, &setup)
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/Subscription%20Tracker:test@example.com?") || !strings.Contains(setup.URI, "secret="+setup.Secret) {
+		t.Errorf("setup = %+v", setup)
+	}
+	// Not on until a code is verified.
+	login("", http.StatusOK)
+
+	step := totpStep(time.Now())
+	h.doJSON("POST", "/api/auth/2fa/verify", twoFactorCode{}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/auth/2fa/verify", twoFactorCode{Code: codeAt(setup.Secret, step-5)}, http.StatusBadRequest, nil)
+	var verified struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	h.doJSON("POST", "/api/auth/2fa/verify", twoFactorCode{Code: codeAt(setup.Secret, step)}, http.StatusOK, &verified)
+	if len(verified.RecoveryCodes) != 10 || len(verified.RecoveryCodes[0]) != 11 {
+		t.Fatalf("recovery codes = %v", verified.RecoveryCodes)
+	}
+	h.doJSON("POST", "/api/auth/2fa/setup", nil, http.StatusConflict, nil)
+	var me models.User
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	if !me.TwoFactorEnabled {
+		t.Error("two-factor authentication is off after verifying")
+	}
+
+	var required struct {
+		Code string `json:"code"`
+	}
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusUnauthorized, &required)
+	if required.Code != codeTwoFactorRequired {
+		t.Errorf("login without a code: code %q", required.Code)
+	}
+	// The code used to verify is spent; the next one works once.
+	login(codeAt(setup.Secret, step), http.StatusUnauthorized)
+	login(codeAt(setup.Secret, step+1), http.StatusOK)
+	login(codeAt(setup.Secret, step+1), http.StatusUnauthorized)
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: "wrong-password", Code: codeAt(setup.Secret, step)}, http.StatusUnauthorized, nil)
+
+	recovery := verified.RecoveryCodes[0]
+	login(strings.ToUpper(strings.ReplaceAll(recovery, "-", "")), http.StatusOK)
+	login(recovery, http.StatusUnauthorized)
+
+	h.doJSON("POST", "/api/auth/2fa/disable", twoFactorCode{Code: recovery}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/auth/2fa/disable", twoFactorCode{Code: verified.RecoveryCodes[1]}, http.StatusNoContent, nil)
+	h.doJSON("POST", "/api/auth/2fa/disable", twoFactorCode{Code: verified.RecoveryCodes[2]}, http.StatusConflict, nil)
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	if me.TwoFactorEnabled {
+		t.Error("two-factor authentication is still on after disabling")
+	}
+	login("", http.StatusOK)
+}
+
+func TestAccountExport(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{"daysBefore": 3}, http.StatusOK, nil)
+	var home models.Household
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Home"}, http.StatusCreated, &home)
+	h.signup("other@example.com").createSubscription(spotifyFixture())
+
+	resp, body := h.do("GET", "/api/me/export", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename="account-export-2025-05-01.zip"` {
+		t.Fatalf("export: %d %v", resp.StatusCode, resp.Header)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+	}
+	want := []string{"account.json", "data.json", "audit.json", "notifications.json", "sessions.json", "usage.json", "household.json", "invites.json", "webhook-deliveries.json"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+	for name, data := range files {
+		if !json.Valid(data) {
+			t.Errorf("%s isn't JSON: %s", name, data)
+		}
+	}
+
+	var account models.User
+	var data store.Backup
+	var audit []models.AuditEntry
+	var sessions []models.Session
+	var household models.Household
+	for name, out := range map[string]any{"account.json": &account, "data.json": &data, "audit.json": &audit, "sessions.json": &sessions, "household.json": &household} {
+		if err := json.Unmarshal(files[name], out); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if account.Email != testEmail || account.DeleteAfter != nil {
+		t.Errorf("account = %+v", account)
+	}
+	if len(data.Subscriptions) != 1 || data.Subscriptions[0].Name != "Netflix" || *data.Subscriptions[0].ReminderDaysBefore != 3 {
+		t.Errorf("data = %+v", data)
+	}
+	if len(audit) != 1 || audit[0].SubscriptionID != netflix.ID || audit[0].Action != "create" {
+		t.Errorf("audit = %+v", audit)
+	}
+	if len(sessions) != 1 || household.Name != "Home" || len(household.Members) != 1 {
+		t.Errorf("sessions = %+v, household = %+v", sessions, household)
+	}
+
+	// The data file restores into another account as it is.
+	fresh := h.signup("fresh@example.com")
+	var restored restoreResponse
+	fresh.doJSON("POST", "/api/restore", json.RawMessage(files["data.json"]), http.StatusOK, &restored)
+	if restored.Subscriptions.Created != 1 {
+		t.Errorf("restored %+v", restored)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	h := newHarness(t)
+	h.app.config.AccountDeletionGrace = 30 * 24 * time.Hour
+	anon := h.anonymous()
+	h.createSubscription(netflixFixture())
+	var home models.Household
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Home"}, http.StatusCreated, &home)
+	var invite models.HouseholdInvite
+	h.doJSON("POST", fmt.Sprintf("/api/households/%d/invites", home.ID), map[string]any{"email": "partner@example.com"}, http.StatusCreated, &invite)
+	partner := h.signup("partner@example.com")
+	partner.doJSON("POST", fmt.Sprintf("/api/me/invites/%d/accept", invite.ID), nil, http.StatusOK, nil)
+	partner.createSubscription(spotifyFixture())
+
+	var login authResponse
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusOK, &login)
+	h.doJSON("DELETE", "/api/me", deleteAccountRequest{Password: "wrong password"}, http.StatusBadRequest, nil)
+	var me models.User
+	h.doJSON("DELETE", "/api/me", deleteAccountRequest{Password: testPassword}, http.StatusAccepted, &me)
+	if me.DeleteAfter == nil || *me.DeleteAfter != "2025-05-31T12:00:00Z" {
+		t.Fatalf("after asking for deletion: %+v", me)
+	}
+	anon.doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+	// Every session ends, the one that asked included.
+	h.doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+	token, err := h.app.issueToken(me.ID, defaultTenant, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.token = token
+
+	// Asking again keeps the first deadline, and logging in cancels it.
+	h.clock.Advance(24 * time.Hour)
+	h.doJSON("DELETE", "/api/me", deleteAccountRequest{Password: testPassword}, http.StatusAccepted, &me)
+	if *me.DeleteAfter != "2025-05-31T12:00:00Z" {
+		t.Errorf("deadline after asking again = %s", *me.DeleteAfter)
+	}
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusOK, &login)
+	me = models.User{}
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	if me.DeleteAfter != nil {
+		t.Errorf("logging in didn't cancel the deletion: %+v", me)
+	}
+
+	h.doJSON("DELETE", "/api/me", deleteAccountRequest{Password: testPassword}, http.StatusAccepted, &me)
+	h.clock.Advance(29 * 24 * time.Hour)
+	if n, err := h.app.eraseDueAccounts(context.Background()); err != nil || n != 0 {
+		t.Fatalf("erasing before the deadline = %d, %v", n, err)
+	}
+	h.clock.Advance(24 * time.Hour)
+	if n, err := h.app.eraseDueAccounts(context.Background()); err != nil || n != 1 {
+		t.Fatalf("erasing after the deadline = %d, %v", n, err)
+	}
+	anon.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusUnauthorized, nil)
+	h.doJSON("GET", "/api/me", nil, http.StatusNotFound, nil)
+	var n int
+	if err := testDB.QueryRow("SELECT COUNT(*) FROM subscriptions").Scan(&n); err != nil || n != 1 {
+		t.Errorf("subscriptions left = %d, %v", n, err)
+	}
+	// The household they owned is disbanded; its members keep their own data.
+	partner.doJSON("GET", fmt.Sprintf("/api/households/%d", home.ID), nil, http.StatusNotFound, nil)
+	partner.doJSON("POST", "/api/households", map[string]any{"name": "Flat"}, http.StatusCreated, nil)
+
+	// Without a grace period the account goes at once.
+	h.app.config.AccountDeletionGrace = 0
+	partner.doJSON("DELETE", "/api/me", deleteAccountRequest{Password: testPassword}, http.StatusNoContent, nil)
+	partner.doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+}
+
+func TestUserIsolation(t *testing.T) {
+	h := newHarness(t)
+	other := h.signup("other@example.com")
+
+	netflix := h.createSubscription(netflixFixture())
+	h.importTransactions(
+		bankTransaction("t1", "ACME CLOUD BACKUP", -499, "2025-03-05"),
+		bankTransaction("t2", "ACME CLOUD BACKUP", -499, "2025-04-05"),
+	)
+
+	var list models.Page[models.Subscription]
+	other.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 0 {
+		t.Errorf("other user sees %d subscriptions, want 0", list.Total)
+	}
+	other.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusNotFound, nil)
+	other.doJSON("PUT", subscriptionPath(netflix.ID, ""), spotifyFixture(), http.StatusNotFound, nil)
+	other.doJSON("DELETE", subscriptionPath(netflix.ID, ""), nil, http.StatusNotFound, nil)
+
+	var txs []models.Transaction
+	other.doJSON("GET", "/api/transactions", nil, http.StatusOK, &txs)
+	if len(txs) != 0 {
+		t.Errorf("other user sees %d transactions, want 0", len(txs))
+	}
+	var alerts []models.Alert
+	other.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 0 {
+		t.Errorf("other user sees alerts: %+v", alerts)
+	}
+
+	// The same external ID is a different transaction for another user.
+	summary := other.importTransactions(bankTransaction("t1", "ACME CLOUD BACKUP", -499, "2025-03-05"))
+	if summary["imported"] != 1 {
+		t.Errorf("import summary = %v, want 1 imported", summary)
+	}
+}
+
+func TestFirstUserClaimsExistingData(t *testing.T) {
+	h := newHarness(t)
+
+	truncate(t, "users")
+	if _, err := testDB.Exec(`
+		INSERT INTO subscriptions (name, category, cost_cents, billing_cycle, next_billing)
+		VALUES ('Netflix', 'Entertainment', 1549, 'monthly', '2025-05-12')
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	first := h.signup("first@example.com")
+	second := h.signup("second@example.com")
+
+	var list models.Page[models.Subscription]
+	first.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 1 {
+		t.Errorf("first user sees %d subscriptions, want 1", list.Total)
+	}
+	second.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 0 {
+		t.Errorf("second user sees %d subscriptions, want 0", list.Total)
+	}
+
+	// Signups racing to be first claim each row once, all to one of them.
+	truncate(t, "users", "subscriptions")
+	if _, err := testDB.Exec(`
+		INSERT INTO subscriptions (name, category, cost_cents, billing_cycle, next_billing)
+		VALUES ('Netflix', 'Entertainment', 1549, 'monthly', '2025-05-12'),
+			('Spotify', 'Music', 1099, 'monthly', '2025-05-03')
+	`); err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Go(func() {
+			resp, _ := h.anonymous().do("POST", "/api/auth/signup", credentials{Email: fmt.Sprintf("racer%d@example.com", i), Password: testPassword})
+			statuses[i] = resp.StatusCode
+		})
+	}
+	wg.Wait()
+	for _, status := range statuses {
+		if status != http.StatusCreated {
+			t.Fatalf("racing signups = %v, want all 201", statuses)
+		}
+	}
+	var owners, owned int
+	if err := testDB.QueryRow("SELECT COUNT(DISTINCT user_id), COUNT(user_id) FROM subscriptions").Scan(&owners, &owned); err != nil {
+		t.Fatal(err)
+	}
+	if owners != 1 || owned != 2 {
+		t.Errorf("racing signups left %d owned rows across %d users, want 2 rows owned by 1", owned, owners)
+	}
+}
+
+func TestSubscriptionCRUD(t *testing.T) {
+	h := newHarness(t)
+
+	created := h.createSubscription(netflixFixture())
+	if created.ID == 0 || created.Name != "Netflix" || created.Stale {
+		t.Fatalf("unexpected created subscription: %+v", created)
+	}
+
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(created.ID, ""), nil, http.StatusOK, &got)
+	if got.Name != "Netflix" || got.Cost != 1549 || got.LastVerifiedAt == nil {
+		t.Errorf("unexpected subscription: %+v", got)
+	}
+
+	update := netflixFixture()
+	update.Cost, update.Version = 1799, got.Version
+	h.doJSON("PUT", subscriptionPath(created.ID, ""), update, http.StatusOK, nil)
+	h.doJSON("GET", subscriptionPath(created.ID, ""), nil, http.StatusOK, &got)
+	if got.Cost != 1799 {
+		t.Errorf("cost after update = %v, want 17.99", got.Cost)
+	}
+
+	var list models.Page[models.Subscription]
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if len(list.Items) != 1 || list.Total != 1 {
+		t.Errorf("got %d subscriptions (total %d), want 1", len(list.Items), list.Total)
+	}
+
+	h.doJSON("POST", subscriptionPath(created.ID, "/verify"), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", subscriptionPath(created.ID, ""), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(created.ID, ""), nil, http.StatusNotFound, nil)
+}
+
+func TestSubscriptionPatch(t *testing.T) {
+	h := newHarness(t)
+	created := h.createSubscription(netflixFixture())
+	path := subscriptionPath(created.ID, "")
+
+	var got models.Subscription
+	h.patchSubscription(created.ID, map[string]any{"cost": 12.99}, http.StatusOK, &got)
+	if got.Cost != 1299 || got.Name != "Netflix" || got.Category != "Entertainment" || got.NextBilling.String() != "2025-05-12" {
+		t.Errorf("after cost patch: %+v", got)
+	}
+	h.patchSubscription(created.ID, map[string]any{"nextBilling": "2025-06-12", "description": "4K plan"}, http.StatusOK, nil)
+	h.doJSON("GET", path, nil, http.StatusOK, &got)
+	if got.Cost != 1299 || got.Description != "4K plan" || got.NextBilling.String() != "2025-06-12" {
+		t.Errorf("after second patch: %+v", got)
+	}
+
+	for _, bad := range []map[string]any{
+		{"cost": 0},
+		{"name": ""},
+		{"nextBilling": "next week"},
+		{"price": 9.99},
+	} {
+		h.patchSubscription(created.ID, bad, http.StatusBadRequest, nil)
+	}
+	h.doJSON("GET", path, nil, http.StatusOK, &got)
+	if got.Cost != 1299 || got.Name != "Netflix" {
+		t.Errorf("rejected patch changed the subscription: %+v", got)
+	}
+
+	h.doJSON("PATCH", "/api/subscriptions/999", map[string]any{"cost": 1}, http.StatusNotFound, nil)
+	h.signup("other@example.com").doJSON("PATCH", path, map[string]any{"cost": 1}, http.StatusNotFound, nil)
+}
+
+func TestSubscriptionBulk(t *testing.T) {
+	h := newHarness(t)
+
+	var resp bulkResponse
+	h.doJSON("POST", "/api/subscriptions/bulk", []models.Subscription{netflixFixture(), {Name: "Broken"}, spotifyFixture()}, http.StatusBadRequest, &resp)
+	if len(resp.Results) != 3 || resp.Results[0].Status != bulkSkipped || resp.Results[1].Status != bulkInvalid || resp.Results[2].Status != bulkSkipped {
+		t.Errorf("invalid batch results: %+v", resp.Results)
+	}
+	var list models.Page[models.Subscription]
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 0 {
+		t.Fatalf("invalid batch stored %d subscriptions, want 0", list.Total)
+	}
+
+	h.doJSON("POST", "/api/subscriptions/bulk", []models.Subscription{netflixFixture(), spotifyFixture(), awsFixture()}, http.StatusCreated, &resp)
+	var ids []int
+	for i, r := range resp.Results {
+		if r.Status != bulkCreated || r.Index != i || r.ID == 0 || r.Subscription == nil || r.Subscription.ID != r.ID {
+			t.Errorf("result %d: %+v", i, r)
+		}
+		ids = append(ids, r.ID)
+	}
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 3 {
+		t.Errorf("got %d subscriptions after bulk create, want 3", list.Total)
+	}
+
+	// A batch that would go over quota is rejected as a whole.
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 4
+	h.doJSON("POST", "/api/subscriptions/bulk", []models.Subscription{netflixFixture(), spotifyFixture()}, http.StatusForbidden, nil)
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 0
+
+	other := h.signup("other@example.com")
+	other.doJSON("DELETE", "/api/subscriptions/bulk", map[string]any{"ids": ids[:1]}, http.StatusOK, &resp)
+	if resp.Results[0].Status != bulkNotFound {
+		t.Errorf("other user's bulk delete: %+v", resp.Results)
+	}
+
+	h.doJSON("DELETE", "/api/subscriptions/bulk", map[string]any{"ids": []int{ids[0], 999, ids[2]}}, http.StatusOK, &resp)
+	want := []string{bulkDeleted, bulkNotFound, bulkDeleted}
+	for i, r := range resp.Results {
+		if r.Status != want[i] {
+			t.Errorf("delete result %d = %s, want %s", i, r.Status, want[i])
+		}
+	}
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 1 || list.Items[0].ID != ids[1] {
+		t.Errorf("after bulk delete: %+v", list.Items)
+	}
+
+	h.doJSON("POST", "/api/subscriptions/bulk", []models.Subscription{}, http.StatusBadRequest, nil)
+	h.doJSON("DELETE", "/api/subscriptions/bulk", map[string]any{"ids": []int{}}, http.StatusBadRequest, nil)
+}
+
+func TestSubscriptionCSVImport(t *testing.T) {
+	h := newHarness(t)
+
+	file := "\ufeffService,Category,Monthly Price,Cycle,Next Billing,Notes\n" +
+		"Netflix,Entertainment,$15.49,monthly,2025-05-12,4K plan\n" +
+		"Spotify,Music,free,monthly,2025-05-03,\n" +
+		"\n" +
+		"AWS,Cloud,\"1,200.00\",yearly,2025-11-01,\n" +
+		"Gym,Health,30,monthly,next week\n"
+	resp, data := h.upload("/api/subscriptions/import?column.cost=monthly_price", "subs.csv", file)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import: got %d: %s", resp.StatusCode, data)
+	}
+	var report csvImportReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Inserted) != 2 || report.Inserted[0].Line != 2 || report.Inserted[1].Line != 5 {
+		t.Errorf("inserted = %+v, want lines 2 and 5", report.Inserted)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Line != 3 || report.Errors[1].Line != 6 {
+		t.Errorf("errors = %+v, want lines 3 and 6", report.Errors)
+	}
+
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(report.Inserted[1].ID, ""), nil, http.StatusOK, &got)
+	if got.Name != "AWS" || got.Cost != 120000 || got.BillingCycle != "yearly" {
+		t.Errorf("imported AWS row: %+v", got)
+	}
+
+	// Quota and missing columns.
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 3
+	resp, data = h.upload("/api/subscriptions/import", "subs.csv", "name,category,cost,billing_cycle,next_billing\nA,X,1,monthly,2025-06-01\nB,X,1,monthly,2025-06-01\n")
+	json.Unmarshal(data, &report)
+	if resp.StatusCode != http.StatusOK || len(report.Inserted) != 1 || len(report.Errors) != 1 {
+		t.Errorf("over-quota import: %d %+v", resp.StatusCode, report)
+	}
+	if resp, _ := h.upload("/api/subscriptions/import", "subs.csv", "name,cost\nA,1\n"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing columns: got %d, want 400", resp.StatusCode)
+	}
+	h.doJSON("POST", "/api/subscriptions/import", netflixFixture(), http.StatusBadRequest, nil)
+}
+
+func TestSubscriptionExport(t *testing.T) {
+	h := newHarness(t)
+	netflix := netflixFixture()
+	netflix.Description = `4K, "family" plan`
+	netflix.Tags = []string{"shared", "work"}
+	h.createSubscription(netflix)
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+
+	resp, data := h.do("GET", "/api/subscriptions/export?billingCycle=monthly&sort=name", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv export: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="subscriptions-2025-05-01.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "id,name,category,cost,currency,billing_cycle,next_billing,description,tags,last_verified_at" ||
+		!strings.HasPrefix(lines[1], `1,Netflix,Entertainment,15.49,USD,monthly,2025-05-12,"4K, ""family"" plan","shared,work",`) ||
+		!strings.Contains(lines[2], ",Spotify,") {
+		t.Errorf("unexpected csv:\n%s", data)
+	}
+
+	// An export imports back unchanged.
+	other := h.signup("other@example.com")
+	resp, body := other.upload("/api/subscriptions/import", "export.csv", string(data))
+	var report csvImportReport
+	json.Unmarshal(body, &report)
+	if resp.StatusCode != http.StatusOK || len(report.Inserted) != 2 || len(report.Errors) != 0 {
+		t.Errorf("reimport: %d %s", resp.StatusCode, body)
+	}
+	var tagged models.Page[models.Subscription]
+	other.doJSON("GET", "/api/subscriptions?tag=work", nil, http.StatusOK, &tagged)
+	if tagged.Total != 1 || strings.Join(tagged.Items[0].Tags, ",") != "shared,work" {
+		t.Errorf("reimported tags: %+v", tagged.Items)
+	}
+
+	resp, data = h.do("GET", "/api/subscriptions/export?format=xlsx&category=Cloud", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasSuffix(resp.Header.Get("Content-Disposition"), `.xlsx"`) {
+		t.Fatalf("xlsx export: %d %s", resp.StatusCode, resp.Header.Get("Content-Disposition"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("xlsx is not a zip: %v", err)
+	}
+	sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xml, _ := io.ReadAll(sheet)
+	if !strings.Contains(string(xml), ">AWS<") || strings.Contains(string(xml), ">Netflix<") || !strings.Contains(string(xml), "<v>120</v>") {
+		t.Errorf("unexpected sheet: %s", xml)
+	}
+
+	h.doJSON("GET", "/api/subscriptions/export?format=pdf", nil, http.StatusBadRequest, nil)
+}
+
+func TestBackup(t *testing.T) {
+	h := newHarness(t)
+	netflix := netflixFixture()
+	netflix.Tags = []string{"work"}
+	netflix = h.createSubscription(netflix)
+	spotify := h.createSubscription(spotifyFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.doJSON("PUT", subscriptionPath(spotify.ID, "/reminder"), map[string]any{"daysBefore": 3}, http.StatusOK, nil)
+	h.doJSON("PUT", subscriptionPath(spotify.ID, "/cancellation-info"), map[string]any{"instructions": "Family plan; ask Sam first.", "remindBeforeRenewal": true}, http.StatusOK, nil)
+	h.doJSON("POST", "/api/budgets", map[string]any{"amount": 100}, http.StatusCreated, nil)
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": "https://example.com/hook", "events": []string{"subscription.created"}}, http.StatusCreated, nil)
+	h.clock.Set(time.Date(2025, 5, 13, 12, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.importTransactions(
+		bankTransaction("t1", "ACME CLOUD BACKUP", -499, "2025-03-05"),
+		bankTransaction("t2", "ACME CLOUD BACKUP", -499, "2025-04-05"),
+	)
+
+	resp, data := h.do("GET", "/api/backup", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Disposition") != `attachment; filename="subscriptions-backup-2025-05-13.json"` {
+		t.Fatalf("backup: %d %s %s", resp.StatusCode, resp.Header.Get("Content-Disposition"), data)
+	}
+	var backup store.Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if backup.Version != store.BackupVersion || len(backup.Subscriptions) != 2 || len(backup.Budgets) != 1 ||
+		len(backup.Transactions) != 2 || len(backup.Alerts) != 2 || len(backup.Webhooks) != 1 || !slices.Equal(backup.Tags, []string{"work"}) {
+		t.Fatalf("backup = %s", data)
+	}
+	if s := backup.Subscriptions[0]; s.Name != "Netflix" || len(s.History) != 1 || len(s.PriceHistory) != 1 || s.ReminderDaysBefore != nil {
+		t.Errorf("netflix = %+v", s)
+	}
+	if s := backup.Subscriptions[1]; s.ReminderDaysBefore == nil || *s.ReminderDaysBefore != 3 || s.Cancellation == nil || !s.Cancellation.RemindBeforeRenewal {
+		t.Errorf("spotify = %+v", s)
+	}
+	if backup.Webhooks[0].Secret == "" {
+		t.Error("webhook secret left out of the backup")
+	}
+
+	// Into an empty account everything is created, with references to
+	// subscriptions pointing at the new ones.
+	other := h.signup("other@example.com")
+	var result restoreResponse
+	other.doJSON("POST", "/api/restore", backup, http.StatusOK, &result)
+	if result.Strategy != store.RestoreSkip || result.Subscriptions != (store.RestoreCounts{Created: 2}) ||
+		result.Transactions.Created != 2 || result.Alerts.Created != 2 || result.Webhooks.Created != 1 || result.Budgets.Created != 1 {
+		t.Fatalf("restore = %+v", result)
+	}
+	var restored store.Backup
+	other.doJSON("GET", "/api/backup", nil, http.StatusOK, &restored)
+	for i, s := range restored.Subscriptions {
+		want := backup.Subscriptions[i]
+		if s.Name != want.Name || s.Cost != want.Cost || !slices.Equal(s.Tags, want.Tags) || s.UpdatedAt != want.UpdatedAt ||
+			len(s.History) != len(want.History) || len(s.PriceHistory) != len(want.PriceHistory) || (s.ReminderDaysBefore == nil) != (want.ReminderDaysBefore == nil) ||
+			(s.Cancellation == nil) != (want.Cancellation == nil) || (s.Cancellation != nil && *s.Cancellation != *want.Cancellation) {
+			t.Errorf("restored %s = %+v, want %+v", want.Name, s, want)
+		}
+	}
+	if al := restored.Alerts[0]; al.SubscriptionID == nil || *al.SubscriptionID != restored.Subscriptions[0].ID {
+		t.Errorf("restored alert = %+v, want it on subscription %d", al, restored.Subscriptions[0].ID)
+	}
+	if restored.Webhooks[0].Secret != backup.Webhooks[0].Secret {
+		t.Error("restored webhook has a new secret")
+	}
+
+	// Restoring again leaves everything as it is.
+	other.doJSON("POST", "/api/restore", backup, http.StatusOK, &result)
+	if result.Subscriptions != (store.RestoreCounts{Skipped: 2}) || result.Transactions.Skipped != 2 || result.Webhooks.Skipped != 1 {
+		t.Errorf("second restore = %+v", result)
+	}
+	var page models.Page[models.Subscription]
+	other.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &page)
+	if page.Total != 2 {
+		t.Errorf("subscriptions after restoring twice = %d", page.Total)
+	}
+
+	// Overwrite puts the backup's copy back; merge keeps a newer change
+	// and combines the tags.
+	mine := restored.Subscriptions[0]
+	other.patchSubscription(mine.ID, map[string]any{"cost": 20, "tags": []string{"family"}}, http.StatusOK, nil)
+	other.doJSON("POST", "/api/restore?strategy=overwrite", backup, http.StatusOK, &result)
+	var got models.Subscription
+	other.doJSON("GET", subscriptionPath(mine.ID, ""), nil, http.StatusOK, &got)
+	if result.Subscriptions.Updated != 2 || got.Cost != 1799 || !slices.Equal(got.Tags, []string{"work"}) {
+		t.Errorf("overwritten = %+v, %+v", result.Subscriptions, got)
+	}
+	h.clock.Advance(time.Hour)
+	other.patchSubscription(mine.ID, map[string]any{"cost": 20, "tags": []string{"family"}}, http.StatusOK, nil)
+	other.doJSON("POST", "/api/restore?strategy=merge", backup, http.StatusOK, &result)
+	other.doJSON("GET", subscriptionPath(mine.ID, ""), nil, http.StatusOK, &got)
+	if got.Cost != 2000 || !slices.Equal(got.Tags, []string{"family", "work"}) {
+		t.Errorf("merged = %+v", got)
+	}
+	var prices []models.PriceChange
+	other.doJSON("GET", subscriptionPath(mine.ID, "/prices"), nil, http.StatusOK, &prices)
+	if len(prices) != 2 {
+		t.Errorf("merged prices = %+v", prices)
+	}
+
+	// The NDJSON form round-trips too.
+	resp, data = h.do("GET", "/api/backup?format=ndjson", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("ndjson backup: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _ := io.ReadAll(gz)
+	if n := strings.Count(string(lines), "\n"); !strings.HasPrefix(string(lines), `{"type":"header"`) || n != 10 {
+		t.Errorf("ndjson has %d lines: %s", n, lines)
+	}
+	third := h.signup("third@example.com")
+	req, _ := http.NewRequest("POST", h.server.URL+"/api/restore", bytes.NewReader(data))
+	resp, body := third.send(req)
+	json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusOK || result.Subscriptions.Created != 2 || result.Alerts.Created != 2 {
+		t.Errorf("ndjson restore: %d %s", resp.StatusCode, body)
+	}
+
+	// Nothing is restored from a backup that fails validation or goes over
+	// a quota.
+	fourth := h.signup("fourth@example.com")
+	bad := backup
+	bad.Subscriptions = slices.Clone(backup.Subscriptions)
+	bad.Subscriptions[1].Cost = 0
+	var problem struct {
+		Errors []fieldError `json:"errors"`
+	}
+	fourth.doJSON("POST", "/api/restore", bad, http.StatusBadRequest, &problem)
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "subscriptions[1].cost" {
+		t.Errorf("validation errors = %+v", problem.Errors)
+	}
+	bad.Version = 2
+	fourth.doJSON("POST", "/api/restore", bad, http.StatusBadRequest, nil)
+	fourth.doJSON("POST", "/api/restore?strategy=replace", backup, http.StatusBadRequest, nil)
+	fourth.doJSON("POST", "/api/restore", "not a backup", http.StatusBadRequest, nil)
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 1
+	fourth.doJSON("POST", "/api/restore", backup, http.StatusForbidden, nil)
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 0
+	fourth.doJSON("GET", "/api/backup", nil, http.StatusOK, &restored)
+	if len(restored.Subscriptions) != 0 || len(restored.Tags) != 0 || len(restored.Webhooks) != 0 {
+		t.Errorf("partial restore left behind: %+v", restored)
+	}
+}
+
+// memoryBlobs is a BlobStore in memory. failPuts makes every Put fail.
+type memoryBlobs struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failPuts bool
+}
+
+func (m *memoryBlobs) Put(_ context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failPuts {
+		return errors.New("bucket is read-only")
+	}
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memoryBlobs) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no object %s", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryBlobs) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memoryBlobs) List(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func TestScheduledBackups(t *testing.T) {
+	h := newHarness(t)
+	admin := h.asAdmin()
+	admin.doJSON("GET", "/api/admin/backups", nil, http.StatusServiceUnavailable, nil)
+
+	blobs := &memoryBlobs{objects: map[string][]byte{"backups/notes.txt": []byte("not a backup")}}
+	h.app.blobs = blobs
+	h.app.config.BackupPrefix, h.app.config.BackupInterval, h.app.config.BackupRetention = "backups/", 24*time.Hour, 2
+	h.createSubscription(netflixFixture())
+	h.signup("other@example.com").createSubscription(spotifyFixture())
+
+	var run BackupRun
+	admin.doJSON("POST", "/api/admin/backups", nil, http.StatusCreated, &run)
+	if run.Key != "backups/backup-20250501T120000Z.tar.gz" || run.Accounts != 2 || len(run.Pruned) != 0 {
+		t.Fatalf("backup run = %+v", run)
+	}
+
+	// The archive holds a backup per account that restores as it is.
+	gz, err := gzip.NewReader(bytes.NewReader(blobs.objects[run.Key]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name], _ = io.ReadAll(archive)
+	}
+	if len(files) != 2 || files["2-other@example.com.ndjson"] == nil {
+		t.Fatalf("archive files = %v", slices.Collect(maps.Keys(files)))
+	}
+	fresh := h.signup("fresh@example.com")
+	req, _ := http.NewRequest("POST", h.server.URL+"/api/restore", bytes.NewReader(files["2-other@example.com.ndjson"]))
+	resp, body := fresh.send(req)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"subscriptions":{"created":1`) {
+		t.Errorf("restoring from the archive: %d %s", resp.StatusCode, body)
+	}
+
+	// The job waits for the newest backup to be an interval old, and only
+	// BackupRetention backups are kept.
+	if run, err := h.app.backupIfDue(context.Background()); err != nil || run != nil {
+		t.Errorf("backup before it's due: %+v, %v", run, err)
+	}
+	for range 2 {
+		h.clock.Advance(25 * time.Hour)
+		if run, err := h.app.backupIfDue(context.Background()); err != nil || run == nil {
+			t.Fatalf("due backup: %+v, %v", run, err)
+		}
+	}
+	var list BackupList
+	admin.doJSON("GET", "/api/admin/backups", nil, http.StatusOK, &list)
+	if len(list.Backups) != 2 || list.Backups[0].CreatedAt != "2025-05-03T14:00:00Z" || list.Backups[1].Key != "backups/backup-20250502T130000Z.tar.gz" ||
+		list.Retention != 2 || list.Interval != "24h0m0s" {
+		t.Errorf("backups = %+v", list)
+	}
+	if _, ok := blobs.objects["backups/notes.txt"]; !ok {
+		t.Error("pruning deleted an object that isn't a backup")
+	}
+
+	blobs.failPuts = true
+	admin.doJSON("POST", "/api/admin/backups", nil, http.StatusBadGateway, nil)
+	h.doJSON("POST", "/api/admin/backups", nil, http.StatusForbidden, nil)
+}
+
+func TestCalendarFeed(t *testing.T) {
+	h := newHarness(t)
+	netflix := netflixFixture()
+	netflix.Description = strings.Repeat("Family plan, shared with the household; ", 4)
+	h.createSubscription(netflix)
+	h.createSubscription(awsFixture())
+
+	var link struct {
+		Token string `json:"token"`
+		Path  string `json:"path"`
+	}
+	h.doJSON("GET", "/api/me/calendar", nil, http.StatusOK, &link)
+
+	feed := h.anonymous()
+	resp, data := feed.do("GET", link.Path, nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+		t.Fatalf("feed: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	ics := string(data)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART;VALUE=DATE:20250512\r\nDTEND;VALUE=DATE:20250513\r\nRRULE:FREQ=MONTHLY\r\n",
+		"DTSTART;VALUE=DATE:20251101\r\nDTEND;VALUE=DATE:20251102\r\nRRULE:FREQ=YEARLY\r\n",
+		"SUMMARY:Netflix renews (15.49 USD)\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed is missing %q:\n%s", want, ics)
+		}
+	}
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+	if !strings.Contains(strings.ReplaceAll(ics, "\r\n ", ""), `Family plan\, shared with the household\; `) {
+		t.Errorf("description not escaped and folded:\n%s", ics)
+	}
+
+	// The feed also works with a bearer token; the tokens aren't
+	// interchangeable otherwise.
+	h.doJSON("GET", "/api/subscriptions/calendar.ics", nil, http.StatusOK, nil)
+	feed.doJSON("GET", "/api/subscriptions/calendar.ics?token="+h.token, nil, http.StatusUnauthorized, nil)
+	feed.doJSON("GET", "/api/subscriptions/calendar.ics", nil, http.StatusUnauthorized, nil)
+	bearer := *feed
+	bearer.token = link.Token
+	bearer.doJSON("GET", "/api/subscriptions", nil, http.StatusUnauthorized, nil)
+}
+
+func TestSubscriptionPagination(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(netflixFixture())
+	h.createSubscription(awsFixture())
+
+	var page models.Page[models.Subscription]
+	resp, _ := h.do("GET", "/api/subscriptions?limit=2&offset=1", nil)
+	if got := resp.Header.Get("X-Total-Count"); got != "3" {
+		t.Errorf("X-Total-Count = %q, want 3", got)
+	}
+	h.doJSON("GET", "/api/subscriptions?limit=2&offset=1", nil, http.StatusOK, &page)
+	if page.Total != 3 || page.Limit != 2 || page.Offset != 1 || len(page.Items) != 2 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	if page.Items[0].Name != "Netflix" || page.Items[1].Name != "AWS" {
+		t.Errorf("got %s, %s; want Netflix, AWS", page.Items[0].Name, page.Items[1].Name)
+	}
+
+	h.doJSON("GET", "/api/subscriptions?limit=0", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/subscriptions?offset=-1", nil, http.StatusBadRequest, nil)
+}
+
+func TestSubscriptionFilters(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"category=Music", []string{"Spotify"}},
+		{"billingCycle=monthly&sort=cost:desc", []string{"Netflix", "Spotify"}},
+		{"minCost=11&maxCost=100", []string{"Netflix"}},
+		{"nextBillingAfter=2025-05-03&nextBillingBefore=2025-12-01", []string{"Netflix", "AWS"}},
+		{"sort=name", []string{"AWS", "Netflix", "Spotify"}},
+	}
+	for _, c := range cases {
+		var page models.Page[models.Subscription]
+		h.doJSON("GET", "/api/subscriptions?"+c.query, nil, http.StatusOK, &page)
+		var got []string
+		for _, s := range page.Items {
+			got = append(got, s.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") || page.Total != len(c.want) {
+			t.Errorf("%s: got %v (total %d), want %v", c.query, got, page.Total, c.want)
+		}
+	}
+
+	for _, bad := range []string{"minCost=cheap", "nextBillingBefore=soon", "sort=price", "sort=cost:sideways"} {
+		h.doJSON("GET", "/api/subscriptions?"+bad, nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestSubscriptionTimestamps(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	if netflix.CreatedAt != "2025-05-01T12:00:00Z" || netflix.UpdatedAt != netflix.CreatedAt {
+		t.Errorf("created at %q, updated at %q; want the current time", netflix.CreatedAt, netflix.UpdatedAt)
+	}
+	h.clock.Advance(time.Hour)
+	spotify := h.createSubscription(spotifyFixture())
+	h.clock.Advance(time.Hour)
+	aws := h.createSubscription(awsFixture())
+
+	h.clock.Advance(time.Hour)
+	var updated models.Subscription
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, &updated)
+	if updated.CreatedAt != netflix.CreatedAt || updated.UpdatedAt != "2025-05-01T15:00:00Z" {
+		t.Errorf("after an update: created at %q, updated at %q", updated.CreatedAt, updated.UpdatedAt)
+	}
+	h.clock.Advance(time.Hour)
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/pause"), nil, http.StatusOK, &updated)
+	if updated.UpdatedAt != "2025-05-01T16:00:00Z" {
+		t.Errorf("after pausing: updated at %q", updated.UpdatedAt)
+	}
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(spotify.ID, ""), nil, http.StatusOK, &got)
+	if got.CreatedAt != spotify.CreatedAt || got.UpdatedAt != updated.UpdatedAt {
+		t.Errorf("stored: created at %q, updated at %q; want %q, %q", got.CreatedAt, got.UpdatedAt, spotify.CreatedAt, updated.UpdatedAt)
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"sort=createdAt:desc", []string{"AWS", "Spotify", "Netflix"}},
+		{"sort=updatedAt:desc", []string{"Spotify", "Netflix", "AWS"}},
+		{"createdAfter=" + url.QueryEscape(netflix.CreatedAt), []string{"Spotify", "AWS"}},
+		{"createdBefore=" + url.QueryEscape(aws.CreatedAt) + "&sort=createdAt", []string{"Netflix", "Spotify"}},
+		{"updatedAfter=2025-05-01T14:30:00Z&sort=updatedAt", []string{"Netflix", "Spotify"}},
+		{"updatedBefore=2025-05-01T14:30:00Z", []string{"AWS"}},
+	}
+	for _, c := range cases {
+		var page models.Page[models.Subscription]
+		h.doJSON("GET", "/api/subscriptions?"+c.query, nil, http.StatusOK, &page)
+		var names []string
+		for _, s := range page.Items {
+			names = append(names, s.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: got %v, want %v", c.query, names, c.want)
+		}
+	}
+	h.doJSON("GET", "/api/subscriptions?createdAfter=yesterday", nil, http.StatusBadRequest, nil)
+}
+
+func TestTags(t *testing.T) {
+	h := newHarness(t)
+	netflix := netflixFixture()
+	netflix.Tags = []string{"Shared", "trial ", "shared"}
+	netflix = h.createSubscription(netflix)
+	if strings.Join(netflix.Tags, ",") != "shared,trial" {
+		t.Errorf("created with tags %v, want them normalized", netflix.Tags)
+	}
+	spotify := spotifyFixture()
+	spotify.Tags = []string{"shared"}
+	h.createSubscription(spotify)
+	h.createSubscription(awsFixture())
+
+	names := func(query string) string {
+		var page models.Page[models.Subscription]
+		h.doJSON("GET", "/api/subscriptions?"+query, nil, http.StatusOK, &page)
+		var got []string
+		for _, s := range page.Items {
+			got = append(got, s.Name)
+		}
+		return strings.Join(got, ",")
+	}
+	if got := names("tag=Shared"); got != "Spotify,Netflix" {
+		t.Errorf("tagged shared: %s", got)
+	}
+	if got := names("tag=trial&category=Music"); got != "" {
+		t.Errorf("tagged trial in Music: %s", got)
+	}
+
+	var tags []models.Tag
+	h.doJSON("GET", "/api/tags", nil, http.StatusOK, &tags)
+	if len(tags) != 2 || tags[0].Name != "shared" || tags[0].Subscriptions != 2 || tags[1].Name != "trial" {
+		t.Fatalf("tags = %+v", tags)
+	}
+	var work models.Tag
+	h.doJSON("POST", "/api/tags", map[string]any{"name": "Work"}, http.StatusCreated, &work)
+	h.doJSON("POST", "/api/tags", map[string]any{"name": "work"}, http.StatusConflict, nil)
+	h.doJSON("POST", "/api/tags", map[string]any{"name": " "}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", fmt.Sprintf("/api/tags/%d", work.ID), map[string]any{"name": "shared"}, http.StatusConflict, nil)
+
+	// Renaming shows on every subscription; PATCH keeps tags unless given.
+	var renamed models.Tag
+	h.doJSON("PUT", fmt.Sprintf("/api/tags/%d", tags[0].ID), map[string]any{"name": "family"}, http.StatusOK, &renamed)
+	if renamed.Name != "family" || renamed.Subscriptions != 2 {
+		t.Errorf("renamed = %+v", renamed)
+	}
+	var got models.Subscription
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, &got)
+	if strings.Join(got.Tags, ",") != "family,trial" {
+		t.Errorf("patched tags = %v", got.Tags)
+	}
+	h.patchSubscription(netflix.ID, map[string]any{"tags": []string{"work"}}, http.StatusOK, &got)
+	if strings.Join(got.Tags, ",") != "work" {
+		t.Errorf("retagged = %v", got.Tags)
+	}
+
+	h.doJSON("DELETE", fmt.Sprintf("/api/tags/%d", work.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusOK, &got)
+	if len(got.Tags) != 0 {
+		t.Errorf("tags after delete = %v", got.Tags)
+	}
+	h.doJSON("DELETE", fmt.Sprintf("/api/tags/%d", work.ID), nil, http.StatusNotFound, nil)
+	h.signup("other@example.com").doJSON("PUT", fmt.Sprintf("/api/tags/%d", tags[1].ID), map[string]any{"name": "mine"}, http.StatusNotFound, nil)
+
+	bad := netflixFixture()
+	bad.Tags = []string{"fine", ""}
+	h.doJSON("POST", "/api/subscriptions", bad, http.StatusBadRequest, nil)
+}
+
+func TestAuditLog(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99, "tags": []string{"shared"}}, http.StatusOK, nil)
+	// Saving without changes isn't an edit.
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.clock.Advance(24 * time.Hour)
+	h.doJSON("DELETE", subscriptionPath(spotify.ID, ""), nil, http.StatusNoContent, nil)
+
+	var page models.Page[models.AuditEntry]
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/audit"), nil, http.StatusOK, &page)
+	if page.Total != 2 || page.Items[0].Action != models.AuditUpdate || page.Items[1].Action != models.AuditCreate {
+		t.Fatalf("netflix audit = %+v", page.Items)
+	}
+	update := page.Items[0]
+	if update.Actor == nil || *update.Actor != testEmail || len(update.Changes) != 2 ||
+		update.Changes["cost"].From != 15.49 || update.Changes["cost"].To != 17.99 {
+		t.Errorf("update entry = %+v", update)
+	}
+	if tags := update.Changes["tags"]; fmt.Sprint(tags.From, tags.To) != "[] [shared]" {
+		t.Errorf("tag change = %+v", tags)
+	}
+	if created := page.Items[1].Changes; created["name"].From != nil || created["name"].To != "Netflix" || created["lastVerifiedAt"] != (models.AuditChange{}) {
+		t.Errorf("create entry = %+v", created)
+	}
+
+	// A deleted subscription's log stays readable.
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/audit?action=delete"), nil, http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0].Changes["name"].From != "Spotify" || page.Items[0].Changes["name"].To != nil {
+		t.Errorf("spotify deletion = %+v", page.Items)
+	}
+	h.doJSON("GET", subscriptionPath(999, "/audit"), nil, http.StatusNotFound, nil)
+
+	for query, want := range map[string]int{
+		"":               4,
+		"?action=create": 2,
+		"?subscriptionId=" + strconv.Itoa(netflix.ID): 2,
+		"?from=2025-05-02": 1,
+		"?to=2025-05-01":   3,
+		"?limit=1":         4,
+	} {
+		h.doJSON("GET", "/api/audit"+query, nil, http.StatusOK, &page)
+		if page.Total != want {
+			t.Errorf("audit%s: total %d, want %d", query, page.Total, want)
+		}
+	}
+	for _, bad := range []string{"action=rename", "from=yesterday", "subscriptionId=x"} {
+		h.doJSON("GET", "/api/audit?"+bad, nil, http.StatusBadRequest, nil)
+	}
+	h.signup("other@example.com").doJSON("GET", subscriptionPath(netflix.ID, "/audit"), nil, http.StatusNotFound, nil)
+
+	// Changes made by the server's own jobs have no actor.
+	h.clock.Set(time.Date(2025, 5, 20, 12, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/audit?limit=1"), nil, http.StatusOK, &page)
+	if e := page.Items[0]; e.ActorID != nil || e.Actor != nil || e.Changes["nextBilling"].To != "2025-06-12" {
+		t.Errorf("roll-forward entry = %+v", e)
+	}
+}
+
+func TestSubscriptionErrors(t *testing.T) {
+	h := newHarness(t)
+
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"name": "Missing fields"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/subscriptions/999", netflixFixture(), http.StatusNotFound, nil)
+	h.doJSON("DELETE", "/api/subscriptions/999", nil, http.StatusNotFound, nil)
+	h.doJSON("POST", "/api/subscriptions/999/verify", nil, http.StatusNotFound, nil)
+}
+
+func TestStats(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+	weekly := spotifyFixture()
+	weekly.Name, weekly.Cost, weekly.BillingCycle = "Radio", 300, "weekly"
+	h.createSubscription(weekly)
+
+	var stats struct {
+		TotalMonthly float64 `json:"totalMonthly"`
+		TotalYearly  float64 `json:"totalYearly"`
+		ByCategory   []struct {
+			Category string  `json:"category"`
+			Count    int     `json:"count"`
+			Monthly  float64 `json:"monthly"`
+			Yearly   float64 `json:"yearly"`
+		} `json:"byCategory"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.ByCategory) != 3 {
+		t.Fatalf("got %d categories, want 3", len(stats.ByCategory))
+	}
+	// 15.49*12 + (10.99*12 + 3*52) + 120 a year.
+	if stats.TotalYearly != 593.76 || stats.TotalMonthly != 49.48 {
+		t.Errorf("totals = %v monthly, %v yearly", stats.TotalMonthly, stats.TotalYearly)
+	}
+	music := stats.ByCategory[0]
+	if music.Category != "Music" || music.Count != 2 || music.Yearly != 287.88 || music.Monthly != 23.99 {
+		t.Errorf("largest category = %+v", music)
+	}
+	cloud := stats.ByCategory[2]
+	if cloud.Category != "Cloud" || cloud.Monthly != 10 || cloud.Yearly != 120 {
+		t.Errorf("smallest category = %+v", cloud)
+	}
+}
+
+func TestStatsByTag(t *testing.T) {
+	h := newHarness(t)
+	netflix := netflixFixture()
+	netflix.Tags = []string{"shared", "work"}
+	h.createSubscription(netflix)
+	spotify := spotifyFixture()
+	spotify.Tags = []string{"shared"}
+	h.createSubscription(spotify)
+	h.createSubscription(awsFixture())
+
+	var stats struct {
+		TotalYearly float64 `json:"totalYearly"`
+		ByTag       []struct {
+			Tag     string  `json:"tag"`
+			Count   int     `json:"count"`
+			Monthly float64 `json:"monthly"`
+			Yearly  float64 `json:"yearly"`
+		} `json:"byTag"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.ByTag) != 2 {
+		t.Fatalf("got %d tags, want 2 (untagged subscriptions aren't listed)", len(stats.ByTag))
+	}
+	// (15.49 + 10.99) * 12 and 15.49 * 12.
+	shared, work := stats.ByTag[0], stats.ByTag[1]
+	if shared.Tag != "shared" || shared.Count != 2 || shared.Yearly != 317.76 || shared.Monthly != 26.48 {
+		t.Errorf("shared = %+v", shared)
+	}
+	if work.Tag != "work" || work.Count != 1 || work.Yearly != 185.88 {
+		t.Errorf("work = %+v", work)
+	}
+	if stats.TotalYearly != 437.76 {
+		t.Errorf("total yearly = %v; tags shouldn't count twice", stats.TotalYearly)
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+
+	get := func(h *harness, path string) (http.Header, string) {
+		t.Helper()
+		resp, body := h.do("GET", path, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", path, resp.StatusCode, body)
+		}
+		return resp.Header, string(body)
+	}
+
+	header, body := get(h, "/api/stats")
+	if !strings.Contains(body, `"totalMonthly":15.49`) || header.Get("Cache-Control") != "private, max-age=60" || header.Get("Age") != "" {
+		t.Fatalf("first stats: Cache-Control %q, Age %q: %s", header.Get("Cache-Control"), header.Get("Age"), body)
+	}
+	h.clock.Advance(20 * time.Second)
+	header, cached := get(h, "/api/stats")
+	if cached != body || header.Get("Age") != "20" || header.Get("Cache-Control") != "private, max-age=40" {
+		t.Errorf("cached stats: Age %q, Cache-Control %q: %s", header.Get("Age"), header.Get("Cache-Control"), cached)
+	}
+	// The user's display currency shares the entry.
+	if header, _ := get(h, "/api/stats?currency=usd"); header.Get("Age") != "20" {
+		t.Errorf("stats in USD: Age %q, want 20", header.Get("Age"))
+	}
+
+	// Changing a subscription drops the cached stats.
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	if header, body = get(h, "/api/stats"); !strings.Contains(body, `"totalMonthly":17.99`) || header.Get("Age") != "" {
+		t.Errorf("after an update: Age %q: %s", header.Get("Age"), body)
+	}
+	h.clock.Advance(time.Second)
+	h.createSubscription(spotifyFixture())
+	if _, body = get(h, "/api/stats"); !strings.Contains(body, `"totalMonthly":28.98`) {
+		t.Errorf("after a create: %s", body)
+	}
+
+	// So do budgets, which the stats report on.
+	h.clock.Advance(time.Second)
+	h.doJSON("POST", "/api/budgets", map[string]any{"amount": 20}, http.StatusCreated, nil)
+	if _, body = get(h, "/api/stats"); strings.Contains(body, `"budgets":[]`) {
+		t.Errorf("stats after creating a budget list none: %s", body)
+	}
+
+	// Users don't share entries.
+	if header, body := get(h.signup("other@example.com"), "/api/stats"); header.Get("Age") != "" || !strings.Contains(body, `"totalMonthly":0`) {
+		t.Errorf("another user's stats: Age %q: %s", header.Get("Age"), body)
+	}
+
+	// Entries expire after the TTL.
+	h.clock.Advance(time.Minute)
+	if header, _ = get(h, "/api/stats"); header.Get("Age") != "" {
+		t.Errorf("expired stats served with Age %q", header.Get("Age"))
+	}
+
+	// With the cache off, every response is computed afresh.
+	h.app.config.StatsCacheTTL = 0
+	h.clock.Advance(time.Second)
+	if header, _ = get(h, "/api/stats"); header.Get("Age") != "" || header.Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("uncached stats: Age %q, Cache-Control %q", header.Get("Age"), header.Get("Cache-Control"))
+	}
+}
+
+func TestPriceHistory(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.clock.Advance(30 * 24 * time.Hour)
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 16.99, "description": "Ads tier"}, http.StatusOK, nil)
+	h.patchSubscription(netflix.ID, map[string]any{"description": "Standard plan"}, http.StatusOK, nil)
+	h.patchSubscription(spotify.ID, map[string]any{"cost": 11.99, "currency": "EUR"}, http.StatusOK, nil)
+
+	var prices []models.PriceChange
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/prices"), nil, http.StatusOK, &prices)
+	if len(prices) != 2 || prices[0].OldCost != 1799 || prices[0].NewCost != 1699 ||
+		prices[1].OldCost != 1549 || prices[1].NewCurrency != "USD" || prices[1].ChangedAt != "2025-05-01T12:00:00Z" {
+		t.Fatalf("netflix prices = %+v", prices)
+	}
+	h.doJSON("GET", subscriptionPath(999, "/prices"), nil, http.StatusNotFound, nil)
+
+	// Only the increase alerts.
+	var alerts []models.Alert
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 1 || alerts[0].Kind != models.AlertPriceIncreased || alerts[0].Message != "Netflix went up from 15.49 to 17.99 USD" {
+		t.Errorf("alerts = %+v", alerts)
+	}
+
+	// Spotify changed currency, so it isn't compared.
+	h.app.rates = rates.Static{Table: rates.Table{Base: "USD", Rates: map[string]float64{"EUR": 0.9}}}
+	var stats struct {
+		PriceIncreases []priceIncrease `json:"priceIncreases"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.PriceIncreases) != 1 {
+		t.Fatalf("price increases = %+v", stats.PriceIncreases)
+	}
+	if got := stats.PriceIncreases[0]; got.SubscriptionID != netflix.ID || got.PreviousCost != 1549 || got.Cost != 1699 || got.Percent != 9.68 {
+		t.Errorf("netflix increase = %+v", got)
+	}
+
+	h.clock.Set(time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.PriceIncreases) != 0 {
+		t.Errorf("increases from over a year ago: %+v", stats.PriceIncreases)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	h := newHarness(t)
+	hook := withWebhook(h)
+	var overall, entertainment models.Budget
+	h.doJSON("POST", "/api/budgets", map[string]any{"amount": 30}, http.StatusCreated, &overall)
+	h.doJSON("POST", "/api/budgets", map[string]any{"category": "Entertainment", "amount": 10, "currency": "usd"}, http.StatusCreated, &entertainment)
+	if overall.Category != nil || overall.Currency != "USD" || entertainment.Currency != "USD" {
+		t.Fatalf("budgets = %+v, %+v", overall, entertainment)
+	}
+	h.doJSON("POST", "/api/budgets", map[string]any{"amount": 50}, http.StatusConflict, nil)
+	h.doJSON("POST", "/api/budgets", map[string]any{"category": " ", "amount": 0}, http.StatusBadRequest, nil)
+
+	// Netflix alone is over the Entertainment budget; the yearly plan,
+	// $10 a month, tips the overall one.
+	netflix := h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 14.49}, http.StatusOK, nil)
+
+	var stats struct {
+		Budgets []budgetStatus `json:"budgets"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.Budgets) != 2 {
+		t.Fatalf("budget stats = %+v", stats.Budgets)
+	}
+	if got := stats.Budgets[0]; got.ID != overall.ID || got.Spent != 3548 || got.Remaining != -548 || !got.Over {
+		t.Errorf("overall = %+v", got)
+	}
+	if got := stats.Budgets[1]; *got.Category != "Entertainment" || got.Spent != 1449 || !got.Over {
+		t.Errorf("Entertainment = %+v", got)
+	}
+
+	// Each budget alerts once a month, however often it's over.
+	var alerts []models.Alert
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 2 || alerts[0].Kind != models.AlertBudgetExceeded ||
+		alerts[1].Message != "Spending (Entertainment) is 15.49 USD a month, over the budget of 10.00" {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	var log models.Page[models.WebhookDelivery]
+	h.doJSON("GET", fmt.Sprintf("/api/webhooks/%d/deliveries", hook), nil, http.StatusOK, &log)
+	var exceeded int
+	for _, d := range log.Items {
+		if d.Event == models.EventBudgetExceeded {
+			exceeded++
+		}
+	}
+	if exceeded != 2 {
+		t.Errorf("%d budget.exceeded deliveries, want 2", exceeded)
+	}
+
+	h.doJSON("PUT", fmt.Sprintf("/api/budgets/%d", overall.ID), map[string]any{"amount": 40}, http.StatusOK, &overall)
+	// Both are still over in June, so both alert again.
+	h.clock.Set(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	h.doJSON("PUT", fmt.Sprintf("/api/budgets/%d", overall.ID), map[string]any{"amount": 35}, http.StatusOK, nil)
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 4 {
+		t.Errorf("%d alerts after a new month, want 4", len(alerts))
+	}
+
+	h.doJSON("DELETE", fmt.Sprintf("/api/budgets/%d", entertainment.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/budgets/%d", entertainment.ID), nil, http.StatusNotFound, nil)
+	h.doJSON("PUT", "/api/budgets/999", map[string]any{"amount": 5}, http.StatusNotFound, nil)
+	var budgets []models.Budget
+	h.doJSON("GET", "/api/budgets", nil, http.StatusOK, &budgets)
+	if len(budgets) != 1 || budgets[0].Amount != 3500 {
+		t.Errorf("budgets = %+v", budgets)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+	disney := h.createSubscription(models.Subscription{Name: "Disney Plus", Category: "Entertainment", Cost: 899, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20"), Description: "Family plan <4 screens>"})
+	hulu := h.createSubscription(models.Subscription{Name: "Hulu", Category: "Entertainment", Cost: 799, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-22"), Description: "Bundled with Spotify"})
+
+	search := func(q string) []searchResult {
+		t.Helper()
+		var results []searchResult
+		h.doJSON("GET", "/api/subscriptions/search?q="+url.QueryEscape(q), nil, http.StatusOK, &results)
+		return results
+	}
+	ids := func(results []searchResult) []int {
+		var ids []int
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	// A name match outranks a description match.
+	if got := ids(search("Spot")); !slices.Equal(got, []int{spotify.ID, hulu.ID}) {
+		t.Errorf("spot = %v", got)
+	}
+	// Every term has to match, in any field.
+	if got := ids(search("entertainment NETFLIX")); !slices.Equal(got, []int{netflix.ID}) {
+		t.Errorf("entertainment netflix = %v", got)
+	}
+	results := search("plan")
+	if got := ids(results); !slices.Equal(got, []int{disney.ID, netflix.ID}) {
+		t.Errorf("plan = %v", got)
+	}
+	if len(results) > 0 {
+		want := map[string]string{"description": "Family <mark>plan</mark> &lt;4 screens&gt;"}
+		if !maps.Equal(results[0].Highlights, want) {
+			t.Errorf("highlights = %v", results[0].Highlights)
+		}
+	}
+	if got := search("music"); len(got) != 1 || got[0].Highlights["category"] != "<mark>Music</mark>" {
+		t.Errorf("music = %+v", got)
+	}
+	if got := search("nothing"); len(got) != 0 {
+		t.Errorf("nothing = %v", ids(got))
+	}
+
+	var limited []searchResult
+	h.doJSON("GET", "/api/subscriptions/search?q=entertainment&limit=2", nil, http.StatusOK, &limited)
+	if len(limited) != 2 {
+		t.Errorf("limit 2 returned %d", len(limited))
+	}
+	h.doJSON("GET", "/api/subscriptions/search?q=+-+", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/subscriptions/search?q=plan&limit=500", nil, http.StatusBadRequest, nil)
+
+	// Other users' subscriptions don't match.
+	other := h.signup("other@example.com")
+	var theirs []searchResult
+	other.doJSON("GET", "/api/subscriptions/search?q=plan", nil, http.StatusOK, &theirs)
+	if len(theirs) != 0 {
+		t.Errorf("other user found %v", ids(theirs))
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	// Off by default.
+	premium := netflixFixture()
+	premium.Name, premium.Cost = "Netflix (Premium)", 1599
+	first := h.createSubscription(premium)
+	h.doJSON("DELETE", subscriptionPath(first.ID, ""), nil, http.StatusNoContent, nil)
+
+	h.app.config.DuplicateCheck = true
+	var conflict struct {
+		Code       string               `json:"code"`
+		Duplicates []duplicateCandidate `json:"duplicates"`
+	}
+	h.doJSON("POST", "/api/subscriptions", premium, http.StatusConflict, &conflict)
+	if conflict.Code != codeDuplicate || len(conflict.Duplicates) != 1 || conflict.Duplicates[0].ID != netflix.ID || conflict.Duplicates[0].Similarity != 0.5 {
+		t.Errorf("conflict = %+v", conflict)
+	}
+	h.doJSON("POST", "/api/subscriptions?force=maybe", premium, http.StatusBadRequest, nil)
+	var forced models.Subscription
+	h.doJSON("POST", "/api/subscriptions?force=true", premium, http.StatusCreated, &forced)
+
+	// A similar name at a different price, or a different name at the same
+	// price, isn't a duplicate.
+	family := spotifyFixture()
+	family.Name, family.Cost = "Spotify Family", 1699
+	h.createSubscription(family)
+	cheaper := spotifyFixture()
+	cheaper.Name = "Tidal"
+	h.createSubscription(cheaper)
+	// Nor is a cancelled subscription.
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/cancel"), nil, http.StatusOK, nil)
+	h.createSubscription(spotifyFixture())
+
+	var pairs []duplicatePair
+	h.doJSON("GET", "/api/subscriptions/duplicates", nil, http.StatusOK, &pairs)
+	if len(pairs) != 1 || pairs[0].Similarity != 0.5 {
+		t.Fatalf("pairs = %+v", pairs)
+	}
+	got := []int{pairs[0].Subscriptions[0].ID, pairs[0].Subscriptions[1].ID}
+	slices.Sort(got)
+	if !slices.Equal(got, []int{netflix.ID, forced.ID}) {
+		t.Errorf("pair = %v, want Netflix and Netflix (Premium)", got)
+	}
+}
+
+func TestTrials(t *testing.T) {
+	h := newHarness(t)
+	trial := func(s models.Subscription, ends string) models.Subscription {
+		s.IsTrial, s.TrialEndsAt = true, &ends
+		return h.createSubscription(s)
+	}
+	netflix := trial(netflixFixture(), "2025-05-05")
+	spotify := trial(spotifyFixture(), "2025-05-20")
+	h.createSubscription(awsFixture())
+	h.doJSON("POST", "/api/subscriptions", map[string]any{
+		"name": "Hulu", "category": "Entertainment", "cost": 7.99, "billingCycle": "monthly", "nextBilling": "2025-05-09", "isTrial": true,
+	}, http.StatusBadRequest, nil)
+
+	var trials []models.Subscription
+	h.doJSON("GET", "/api/trials", nil, http.StatusOK, &trials)
+	if len(trials) != 1 || trials[0].ID != netflix.ID || *trials[0].TrialEndsAt != "2025-05-05" {
+		t.Fatalf("trials ending this week = %+v", trials)
+	}
+	h.doJSON("GET", "/api/trials?days=30", nil, http.StatusOK, &trials)
+	if len(trials) != 2 || trials[1].ID != spotify.ID {
+		t.Errorf("trials ending this month = %+v", trials)
+	}
+	h.doJSON("GET", "/api/trials?days=365", nil, http.StatusBadRequest, nil)
+
+	// Nothing ends before its date, and each trial ends once.
+	if n, err := h.app.endTrials(context.Background()); err != nil || n != 0 {
+		t.Fatalf("endTrials before the end date = %d, %v", n, err)
+	}
+	h.clock.Set(time.Date(2025, 5, 5, 9, 0, 0, 0, time.UTC))
+	for i, want := range []int{1, 0} {
+		if n, err := h.app.endTrials(context.Background()); err != nil || n != want {
+			t.Fatalf("endTrials run %d = %d, %v; want %d", i, n, err, want)
+		}
+	}
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusOK, &got)
+	if got.IsTrial || got.TrialEndsAt == nil || *got.TrialEndsAt != "2025-05-05" {
+		t.Errorf("ended trial = %+v", got)
+	}
+	var alerts []models.Alert
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 1 || alerts[0].Kind != models.AlertTrialEnded || *alerts[0].SubscriptionID != netflix.ID ||
+		alerts[0].Message != "Your Netflix trial ended on 2025-05-05 and now costs 15.49 USD monthly; cancel it if you don't want to keep it" {
+		t.Errorf("alerts = %+v", alerts)
+	}
+	h.doJSON("GET", "/api/trials?days=30", nil, http.StatusOK, &trials)
+	if len(trials) != 1 || trials[0].ID != spotify.ID {
+		t.Errorf("trials left = %+v", trials)
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	aws := h.createSubscription(awsFixture())
+	if netflix.Status != models.StatusActive {
+		t.Fatalf("new subscription is %q", netflix.Status)
+	}
+
+	var s models.Subscription
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/pause"), nil, http.StatusOK, &s)
+	if s.Status != models.StatusPaused {
+		t.Errorf("paused = %+v", s)
+	}
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/pause"), nil, http.StatusConflict, nil)
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/resume"), nil, http.StatusConflict, nil)
+	h.doJSON("POST", subscriptionPath(aws.ID, "/cancel"), map[string]any{"date": "2025-05-31", "reason": " Moved to another provider "}, http.StatusOK, &s)
+	if s.Status != models.StatusCancelled || *s.CancelledAt != "2025-05-31" || *s.CancellationReason != "Moved to another provider" {
+		t.Errorf("cancelled = %+v", s)
+	}
+	h.doJSON("POST", subscriptionPath(aws.ID, "/cancel"), nil, http.StatusConflict, nil)
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/cancel"), map[string]any{"date": "soon"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", subscriptionPath(999, "/pause"), nil, http.StatusNotFound, nil)
+
+	// Edits keep the status.
+	h.patchSubscription(spotify.ID, map[string]any{"cost": 11.99}, http.StatusOK, &s)
+	if s.Status != models.StatusPaused {
+		t.Errorf("patched = %+v", s)
+	}
+	put := awsFixture()
+	put.Status, put.Version = models.StatusActive, h.version(aws.ID)
+	h.doJSON("PUT", subscriptionPath(aws.ID, ""), put, http.StatusOK, &s)
+	if s.Status != models.StatusCancelled || s.CancelledAt == nil {
+		t.Errorf("replaced = %+v", s)
+	}
+
+	var stats struct {
+		TotalMonthly float64 `json:"totalMonthly"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.TotalMonthly != 15.49 {
+		t.Errorf("monthly total = %v, want only Netflix", stats.TotalMonthly)
+	}
+	var page models.Page[models.Subscription]
+	h.doJSON("GET", "/api/subscriptions?status=paused", nil, http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0].ID != spotify.ID {
+		t.Errorf("paused list = %+v", page.Items)
+	}
+	h.doJSON("GET", "/api/subscriptions?status=gone", nil, http.StatusBadRequest, nil)
+	// AWS is cancelled before its November renewal.
+	var f forecast
+	h.doJSON("GET", "/api/forecast", nil, http.StatusOK, &f)
+	if f.Total != 18588 {
+		t.Errorf("forecast total = %v, want twelve months of Netflix", f.Total)
+	}
+
+	// Nothing rolls forward while paused; resuming catches the date up
+	// without adding to the history.
+	h.clock.Set(time.Date(2025, 7, 10, 12, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/resume"), nil, http.StatusOK, &s)
+	if s.Status != models.StatusActive || s.NextBilling.String() != "2025-08-03" {
+		t.Errorf("resumed = %+v", s)
+	}
+	var history []models.BillingEvent
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/history"), nil, http.StatusOK, &history)
+	if len(history) != 0 {
+		t.Errorf("history = %+v", history)
+	}
+	h.doJSON("POST", subscriptionPath(aws.ID, "/resume"), nil, http.StatusOK, &s)
+	if s.CancelledAt != nil || s.CancellationReason != nil {
+		t.Errorf("resumed after cancelling = %+v", s)
+	}
+}
+
+func TestSubscriptionArchive(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+	if netflix.ArchivedAt != nil {
+		t.Fatalf("new subscription archived at %s", *netflix.ArchivedAt)
+	}
+
+	var s models.Subscription
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/archive"), nil, http.StatusOK, &s)
+	if s.ArchivedAt == nil || *s.ArchivedAt != "2025-05-01T12:00:00Z" || s.Status != models.StatusActive {
+		t.Errorf("archived = %+v", s)
+	}
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/archive"), nil, http.StatusConflict, nil)
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/unarchive"), nil, http.StatusConflict, nil)
+	h.doJSON("POST", subscriptionPath(999, "/archive"), nil, http.StatusNotFound, nil)
+	h.signup("other@example.com").doJSON("POST", subscriptionPath(spotify.ID, "/archive"), nil, http.StatusNotFound, nil)
+
+	// Edits keep it archived.
+	put := netflixFixture()
+	put.Version = h.version(netflix.ID)
+	h.doJSON("PUT", subscriptionPath(netflix.ID, ""), put, http.StatusOK, &s)
+	if s.ArchivedAt == nil {
+		t.Errorf("replaced = %+v", s)
+	}
+
+	var page models.Page[models.Subscription]
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &page)
+	if page.Total != 2 || slices.ContainsFunc(page.Items, func(s models.Subscription) bool { return s.ID == netflix.ID }) {
+		t.Errorf("list = %+v, want Netflix left out", page.Items)
+	}
+	h.doJSON("GET", "/api/subscriptions/archived", nil, http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0].ID != netflix.ID {
+		t.Errorf("archived list = %+v", page.Items)
+	}
+	h.doJSON("GET", "/api/subscriptions/archived?category=Music", nil, http.StatusOK, &page)
+	if page.Total != 0 {
+		t.Errorf("archived music = %+v", page.Items)
+	}
+
+	var stats struct {
+		TotalMonthly float64 `json:"totalMonthly"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.TotalMonthly != 20.99 {
+		t.Errorf("monthly total = %v, want Spotify and AWS", stats.TotalMonthly)
+	}
+	h.doJSON("GET", "/api/stats?includeArchived=true", nil, http.StatusOK, &stats)
+	if stats.TotalMonthly != 36.48 {
+		t.Errorf("monthly total with archived = %v", stats.TotalMonthly)
+	}
+	h.doJSON("GET", "/api/stats?includeArchived=maybe", nil, http.StatusBadRequest, nil)
+
+	var bulk bulkResponse
+	h.doJSON("POST", "/api/subscriptions/bulk/archive", map[string]any{"ids": []int{spotify.ID, netflix.ID, 999}}, http.StatusOK, &bulk)
+	if len(bulk.Results) != 3 || bulk.Results[0].Status != bulkArchived || bulk.Results[1].Status != bulkArchived || bulk.Results[2].Status != bulkNotFound {
+		t.Errorf("bulk archive = %+v", bulk.Results)
+	}
+	h.doJSON("POST", "/api/subscriptions/bulk/archive", map[string]any{"ids": []int{}}, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/subscriptions/archived", nil, http.StatusOK, &page)
+	if page.Total != 2 {
+		t.Errorf("archived after bulk = %+v", page.Items)
+	}
+
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/unarchive"), nil, http.StatusOK, &s)
+	if s.ArchivedAt != nil {
+		t.Errorf("unarchived = %+v", s)
+	}
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &page)
+	if page.Total != 2 {
+		t.Errorf("list after unarchiving = %+v", page.Items)
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	aws := h.createSubscription(awsFixture())
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/pause"), nil, http.StatusOK, nil)
+
+	var all models.ShareLink
+	h.doJSON("POST", "/api/subscriptions/share", map[string]any{"label": " Our subscriptions ", "includeStats": true}, http.StatusCreated, &all)
+	if !strings.HasPrefix(all.Token, "sh_") || all.Path != "/share/"+all.Token || all.Label != "Our subscriptions" || len(all.SubscriptionIDs) != 0 {
+		t.Errorf("created = %+v", all)
+	}
+	var view sharedView
+	h.anonymous().doJSON("GET", all.Path+"?format=json", nil, http.StatusOK, &view)
+	if len(view.Subscriptions) != 2 || view.Subscriptions[0].Name != netflix.Name || view.Subscriptions[1].Name != aws.Name {
+		t.Errorf("shared = %+v, want the active subscriptions", view.Subscriptions)
+	}
+	if view.Stats == nil || view.Stats.Currency != "USD" || view.Stats.TotalMonthly != 2549 {
+		t.Errorf("shared stats = %+v", view.Stats)
+	}
+	resp, body := h.anonymous().do("GET", all.Path, nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") ||
+		resp.Header.Get("Cache-Control") != "no-store" || !strings.Contains(string(body), "Our subscriptions") || !strings.Contains(string(body), "Netflix") {
+		t.Errorf("page = %d %v %s", resp.StatusCode, resp.Header, body)
+	}
+	h.anonymous().doJSON("GET", all.Path+"?format=xml", nil, http.StatusBadRequest, nil)
+	h.anonymous().doJSON("GET", "/share/sh_nope", nil, http.StatusNotFound, nil)
+
+	var chosen models.ShareLink
+	h.doJSON("POST", "/api/subscriptions/share", map[string]any{"subscriptionIds": []int{spotify.ID, spotify.ID}, "expiresAt": "2025-05-02T00:00:00Z"}, http.StatusCreated, &chosen)
+	if !slices.Equal(chosen.SubscriptionIDs, []int{spotify.ID}) || chosen.ExpiresAt == nil {
+		t.Errorf("created = %+v", chosen)
+	}
+	var picked sharedView
+	h.anonymous().doJSON("GET", chosen.Path+"?format=json", nil, http.StatusOK, &picked)
+	if len(picked.Subscriptions) != 1 || picked.Subscriptions[0].Status != models.StatusPaused || picked.Stats != nil {
+		t.Errorf("shared = %+v", picked)
+	}
+
+	h.doJSON("POST", "/api/subscriptions/share", map[string]any{"subscriptionIds": []int{999}}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/subscriptions/share", map[string]any{"expiresAt": "2025-04-30T00:00:00Z"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/subscriptions/share", map[string]any{"label": strings.Repeat("x", maxShareLabel+1)}, http.StatusBadRequest, nil)
+	h.signup("other@example.com").doJSON("POST", "/api/subscriptions/share", map[string]any{"subscriptionIds": []int{netflix.ID}}, http.StatusBadRequest, nil)
+
+	var links []models.ShareLink
+	h.doJSON("GET", "/api/subscriptions/share", nil, http.StatusOK, &links)
+	if len(links) != 2 || links[0].ID != chosen.ID || links[0].Token != "" || links[1].Path != "" {
+		t.Errorf("links = %+v", links)
+	}
+
+	h.clock.Set(time.Date(2025, 5, 3, 12, 0, 0, 0, time.UTC))
+	h.anonymous().doJSON("GET", chosen.Path, nil, http.StatusNotFound, nil)
+
+	h.signup("another@example.com").doJSON("DELETE", fmt.Sprintf("/api/subscriptions/share/%d", all.ID), nil, http.StatusNotFound, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/subscriptions/share/%d", all.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/subscriptions/share/%d", all.ID), nil, http.StatusNotFound, nil)
+	h.anonymous().doJSON("GET", all.Path, nil, http.StatusNotFound, nil)
+}
+
+func TestForecast(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+	weekly := spotifyFixture()
+	weekly.Name, weekly.Cost, weekly.BillingCycle = "Radio", 300, "weekly"
+	h.createSubscription(weekly)
+
+	var f forecast
+	h.doJSON("GET", "/api/forecast", nil, http.StatusOK, &f)
+	if len(f.Months) != 12 || f.Months[0].Month != "2025-05" || f.Months[11].Month != "2026-04" {
+		t.Fatalf("months = %+v", f.Months)
+	}
+	// May has five Saturdays from the 3rd.
+	may := f.Months[0]
+	if may.Total != 4148 || len(may.ByCategory) != 2 || may.ByCategory[0].Category != "Music" || may.ByCategory[0].Charges != 6 {
+		t.Errorf("May = %+v", may)
+	}
+	// The yearly plan lands in November rather than being spread out.
+	if nov := f.Months[6]; nov.Total != 16148 || nov.ByCategory[0].Category != "Cloud" || nov.ByCategory[0].Amount != 12000 {
+		t.Errorf("November = %+v", nov)
+	}
+	if f.Currency != "USD" || f.Total != 59376 {
+		t.Errorf("total = %v %s", f.Total, f.Currency)
+	}
+
+	h.doJSON("GET", "/api/forecast?months=6&category=Cloud", nil, http.StatusOK, &f)
+	if len(f.Months) != 6 || f.Total != 0 {
+		t.Errorf("Cloud over six months = %v", f.Total)
+	}
+	for _, bad := range []string{"months=0", "months=61", "months=year", "minCost=cheap"} {
+		h.doJSON("GET", "/api/forecast?"+bad, nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestStatsConvertCurrencies(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	euro := spotifyFixture()
+	euro.Currency = "EUR"
+	h.createSubscription(euro)
+
+	h.doJSON("POST", "/api/subscriptions", map[string]any{
+		"name": "Lowercase", "category": "Music", "cost": 1, "currency": "eur", "billingCycle": "monthly", "nextBilling": "2025-06-01",
+	}, http.StatusBadRequest, nil)
+
+	var stats struct {
+		Currency     string  `json:"currency"`
+		TotalMonthly float64 `json:"totalMonthly"`
+		TotalYearly  float64 `json:"totalYearly"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusServiceUnavailable, nil)
+
+	// One euro buys 1.25 dollars, then 1.5 once the cached table expires.
+	rate, fetches := "1.25", 0
+	ecb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if rate == "" {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+<Cube><Cube time="2025-05-01"><Cube currency="USD" rate="%s"/><Cube currency="GBP" rate="0.85"/></Cube></Cube>
+</gesmes:Envelope>`, rate)
+	}))
+	t.Cleanup(ecb.Close)
+	h.app.rates = &rates.Cached{Source: rates.ECB{URL: ecb.URL}, TTL: time.Hour, Clock: h.clock}
+
+	// 15.49*12 dollars plus 10.99*12 euros.
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.Currency != "USD" || stats.TotalYearly != 350.73 || stats.TotalMonthly != 29.23 {
+		t.Errorf("USD stats = %+v", stats)
+	}
+	h.doJSON("GET", "/api/stats?currency=gbp", nil, http.StatusOK, &stats)
+	if stats.Currency != "GBP" || stats.TotalYearly != 238.50 {
+		t.Errorf("GBP stats = %+v", stats)
+	}
+	h.doJSON("GET", "/api/stats?currency=JPY", nil, http.StatusBadRequest, nil)
+
+	var me models.User
+	h.doJSON("PATCH", "/api/me", map[string]any{"currency": "euro"}, http.StatusBadRequest, nil)
+	h.doJSON("PATCH", "/api/me", map[string]any{"currency": "EUR"}, http.StatusOK, &me)
+	if me.Currency != "EUR" {
+		t.Errorf("me = %+v", me)
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.Currency != "EUR" || stats.TotalYearly != 280.58 {
+		t.Errorf("EUR stats = %+v", stats)
+	}
+	if fetches != 1 {
+		t.Errorf("fetched rates %d times within the cache time, want 1", fetches)
+	}
+
+	rate = "1.5"
+	h.clock.Advance(2 * time.Hour)
+	h.doJSON("GET", "/api/stats?currency=USD", nil, http.StatusOK, &stats)
+	if stats.TotalYearly != 383.70 {
+		t.Errorf("USD stats after refresh = %+v", stats)
+	}
+
+	// A failed refresh keeps the last table.
+	rate = ""
+	h.clock.Advance(2 * time.Hour)
+	h.doJSON("GET", "/api/stats?currency=USD", nil, http.StatusOK, &stats)
+	if stats.TotalYearly != 383.70 || fetches != 3 {
+		t.Errorf("USD stats with the provider down = %+v after %d fetches", stats, fetches)
+	}
+}
+
+func TestSpendingKeepsBillingRates(t *testing.T) {
+	h := newHarness(t)
+	h.doJSON("GET", "/api/spending?months=0", nil, http.StatusBadRequest, nil)
+	netflix := h.createSubscription(netflixFixture())
+	euro := spotifyFixture()
+	euro.Currency = "EUR"
+	euro = h.createSubscription(euro)
+
+	// A dollar buys 0.8 euros on May 1 and 0.5 by June 20.
+	h.app.rates = rates.Static{Table: rates.Table{Base: "USD", Rates: map[string]float64{"EUR": 0.8}}}
+	if n, err := h.app.snapshotRates(context.Background()); err != nil || n != 1 {
+		t.Fatalf("snapshotRates = %d, %v; want 1 stored", n, err)
+	}
+	h.clock.Set(time.Date(2025, 6, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.app.rates = rates.Static{Table: rates.Table{Base: "USD", Rates: map[string]float64{"EUR": 0.5}}}
+	if _, err := h.app.snapshotRates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var history []models.BillingEvent
+	h.doJSON("GET", subscriptionPath(euro.ID, "/history"), nil, http.StatusOK, &history)
+	if len(history) != 2 {
+		t.Fatalf("euro history = %+v, want 2 events", history)
+	}
+	for _, e := range history {
+		if e.Currency != "EUR" || e.RecordCurrency != "USD" || e.Rate == nil || *e.Rate != 1.25 {
+			t.Errorf("euro event = %+v, want EUR at 1.25 USD", e)
+		}
+	}
+
+	type report struct {
+		Currency string       `json:"currency"`
+		Total    models.Money `json:"total"`
+		Months   []struct {
+			Month   string       `json:"month"`
+			Charges int          `json:"charges"`
+			Total   models.Money `json:"total"`
+		} `json:"months"`
+	}
+	// 10.99 euros at May's 1.25 dollars plus 15.49 dollars, each month,
+	// though a euro now buys two dollars.
+	var got report
+	h.doJSON("GET", "/api/spending?months=3", nil, http.StatusOK, &got)
+	if got.Currency != "USD" || got.Total != 5846 || len(got.Months) != 3 {
+		t.Fatalf("USD spending = %+v", got)
+	}
+	if m := got.Months[0]; m.Month != "2025-04" || m.Charges != 0 || m.Total != 0 {
+		t.Errorf("April = %+v, want nothing billed", m)
+	}
+	if m := got.Months[2]; m.Month != "2025-06" || m.Charges != 2 || m.Total != 2923 {
+		t.Errorf("June = %+v", m)
+	}
+
+	// Dollars become euros at the snapshot from before each charge.
+	got = report{}
+	h.doJSON("GET", "/api/spending?months=3&currency=EUR", nil, http.StatusOK, &got)
+	if got.Currency != "EUR" || got.Total != 4676 {
+		t.Errorf("EUR spending = %+v, want 2 x (10.99 + 15.49 x 0.8)", got)
+	}
+	h.doJSON("GET", "/api/spending?currency=JPY", nil, http.StatusBadRequest, nil)
+
+	var netflixHistory []models.BillingEvent
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/history"), nil, http.StatusOK, &netflixHistory)
+	for _, e := range netflixHistory {
+		if e.Currency != "USD" || e.Rate == nil || *e.Rate != 1 {
+			t.Errorf("netflix event = %+v, want USD at 1", e)
+		}
+	}
+}
+
+func TestSpendingTimeseries(t *testing.T) {
+	h := newHarness(t)
+	h.doJSON("GET", "/api/stats/timeseries?months=61", nil, http.StatusBadRequest, nil)
+	h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	aws := awsFixture()
+	aws.NextBilling = models.MustParseDate("2025-06-01")
+	h.createSubscription(aws)
+	h.clock.Set(time.Date(2025, 6, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Currency   string      `json:"currency"`
+		Spending   chartSeries `json:"spending"`
+		ByCategory chartSeries `json:"byCategory"`
+		ByCycle    chartSeries `json:"byCycle"`
+	}
+	h.doJSON("GET", "/api/stats/timeseries?months=3", nil, http.StatusOK, &got)
+	labels := []string{"2025-04", "2025-05", "2025-06"}
+	for _, chart := range []chartSeries{got.Spending, got.ByCategory, got.ByCycle} {
+		if !slices.Equal(chart.Labels, labels) {
+			t.Errorf("labels = %v, want %v", chart.Labels, labels)
+		}
+	}
+	if d := got.Spending.Datasets; got.Currency != "USD" || len(d) != 1 || !slices.Equal(d[0].Data, []models.Money{0, 2648, 14648}) {
+		t.Errorf("spending = %+v", got.Spending)
+	}
+	want := []chartDataset{
+		{Label: "Cloud", Data: []models.Money{0, 0, 12000}},
+		{Label: "Entertainment", Data: []models.Money{0, 1549, 1549}},
+		{Label: "Music", Data: []models.Money{0, 1099, 1099}},
+	}
+	sameDataset := func(x, y chartDataset) bool { return x.Label == y.Label && slices.Equal(x.Data, y.Data) }
+	if !slices.EqualFunc(got.ByCategory.Datasets, want, sameDataset) {
+		t.Errorf("by category = %+v", got.ByCategory.Datasets)
+	}
+	want = []chartDataset{
+		{Label: "yearly", Data: []models.Money{0, 0, 12000}},
+		{Label: "monthly", Data: []models.Money{0, 2648, 2648}},
+	}
+	if !slices.EqualFunc(got.ByCycle.Datasets, want, sameDataset) {
+		t.Errorf("by cycle = %+v", got.ByCycle.Datasets)
+	}
+
+	// With nothing billed the charts have no datasets to draw, but the
+	// total is still there, at zero.
+	h.signup("other@example.com").doJSON("GET", "/api/stats/timeseries", nil, http.StatusOK, &got)
+	if len(got.Spending.Labels) != 12 || len(got.ByCategory.Datasets) != 0 || len(got.ByCycle.Datasets) != 0 || got.Spending.Datasets[0].Data[11] != 0 {
+		t.Errorf("empty timeseries = %+v", got)
+	}
+}
+
+func TestMonthlyReport(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	// Both bill in May and June; then Spotify is paused and Netflix goes
+	// up to 17.99 for July.
+	h.clock.Set(time.Date(2025, 6, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/pause"), nil, http.StatusOK, nil)
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.clock.Set(time.Date(2025, 7, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var report monthlyReport
+	h.doJSON("GET", "/api/reports/monthly?from=2025-05&to=2025-07", nil, http.StatusOK, &report)
+	if report.Currency != "USD" || report.From != "2025-05" || report.To != "2025-07" || report.Total != 7095 || len(report.Months) != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+	may, june, july := report.Months[0], report.Months[1], report.Months[2]
+	if may.Month != "2025-05" || may.Charges != 2 || may.Total != 2648 || may.Change != 2648 || may.ChangePercent != nil || len(may.ByCategory) != 2 {
+		t.Errorf("May = %+v", may)
+	}
+	if june.Total != 2648 || june.Change != 0 || june.ChangePercent == nil || *june.ChangePercent != 0 {
+		t.Errorf("June = %+v", june)
+	}
+	if july.Charges != 1 || july.Total != 1799 || july.Change != -849 || *july.ChangePercent != -32.06 {
+		t.Errorf("July = %+v", july)
+	}
+	// Music wasn't billed in July, and is listed to show the drop.
+	if len(july.ByCategory) != 2 {
+		t.Fatalf("July by category = %+v", july.ByCategory)
+	}
+	if c := july.ByCategory[0]; c.Category != "Entertainment" || c.Total != 1799 || c.Change != 250 || *c.ChangePercent != 16.14 {
+		t.Errorf("July entertainment = %+v", c)
+	}
+	if c := july.ByCategory[1]; c.Category != "Music" || c.Charges != 0 || c.Total != 0 || c.Change != -1099 || *c.ChangePercent != -100 {
+		t.Errorf("July music = %+v", c)
+	}
+
+	// The first month is compared with the one before it, and by default
+	// the report ends with the current month.
+	report = monthlyReport{}
+	h.doJSON("GET", "/api/reports/monthly?from=2025-06", nil, http.StatusOK, &report)
+	if len(report.Months) != 2 || report.Months[0].Change != 0 || report.To != "2025-07" {
+		t.Errorf("report from June = %+v", report)
+	}
+	report = monthlyReport{}
+	h.doJSON("GET", "/api/reports/monthly", nil, http.StatusOK, &report)
+	if report.From != "2024-08" || len(report.Months) != 12 || report.Total != 7095 {
+		t.Errorf("default report = %+v", report)
+	}
+
+	h.doJSON("GET", "/api/reports/monthly?from=2025-08&to=2025-07", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/reports/monthly?to=July", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/reports/monthly?from=2020-01&to=2025-07", nil, http.StatusBadRequest, nil)
+}
+
+func TestMonthlyReportPDF(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	// Parentheses have to be escaped and the rest encoded.
+	spotify := spotifyFixture()
+	spotify.Name = "Spotify (Familie) – Ünd"
+	h.createSubscription(spotify)
+	h.clock.Set(time.Date(2025, 6, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := h.do("GET", "/api/reports/monthly.pdf?from=2025-05&to=2025-06", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename="spending-2025-05-to-2025-06.pdf"` {
+		t.Fatalf("GET monthly.pdf: %d %v", resp.StatusCode, resp.Header)
+	}
+	if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.HasSuffix(body, []byte("%%EOF\n")) {
+		t.Errorf("body isn't a PDF: %.40q", body)
+	}
+	// The cross-reference table points at each object.
+	xref := bytes.LastIndex(body, []byte("startxref\n"))
+	offset, err := strconv.Atoi(string(bytes.Fields(body[xref:])[1]))
+	if err != nil || !bytes.HasPrefix(body[offset:], []byte("xref\n")) {
+		t.Fatalf("startxref points at %.20q", body[offset:])
+	}
+	entries := bytes.Split(body[offset:], []byte("\n"))[3:]
+	for i, e := range entries {
+		if !bytes.HasSuffix(e, []byte(" n ")) {
+			break
+		}
+		at, _ := strconv.Atoi(string(e[:10]))
+		if want := fmt.Sprintf("%d 0 obj", i+1); !bytes.HasPrefix(body[at:], []byte(want)) {
+			t.Errorf("object %d is at %.20q", i+1, body[at:])
+		}
+	}
+
+	h.doJSON("GET", "/api/reports/monthly.pdf?from=2025-07&to=2025-06", nil, http.StatusBadRequest, nil)
+}
+
+func TestAnnualReport(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	aws := h.createSubscription(awsFixture())
+
+	// Netflix and Spotify bill in May and June, then Spotify is cancelled
+	// with six monthly renewals left in the year and AWS before its
+	// yearly one in November.
+	h.clock.Set(time.Date(2025, 6, 20, 9, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/cancel"), map[string]any{"date": "2025-06-20"}, http.StatusOK, nil)
+	h.doJSON("POST", subscriptionPath(aws.ID, "/cancel"), map[string]any{"date": "2025-06-30"}, http.StatusOK, nil)
+
+	var report annualReport
+	h.doJSON("GET", "/api/reports/annual/2025", nil, http.StatusOK, &report)
+	if report.Year != 2025 || report.Currency != "USD" || report.TotalSpent != 5296 || report.Charges != 4 {
+		t.Errorf("report = %+v", report)
+	}
+	if c := report.BiggestCategory; c == nil || c.Category != "Entertainment" || c.Total != 3098 {
+		t.Errorf("biggest category = %+v", c)
+	}
+	if s := report.MostExpensive; s == nil || s.ID != netflix.ID || s.Charges != 2 || s.Total != 3098 {
+		t.Errorf("most expensive = %+v", s)
+	}
+	if len(report.NewSubscriptions) != 3 || report.NewSubscriptions[0].ID != netflix.ID || report.NewSubscriptions[0].Total != 3098 {
+		t.Errorf("new subscriptions = %+v", report.NewSubscriptions)
+	}
+	if len(report.Cancellations) != 2 || report.TotalSaved != 18594 {
+		t.Fatalf("cancellations = %+v, saved %v", report.Cancellations, report.TotalSaved)
+	}
+	for _, c := range report.Cancellations {
+		if c.ID == spotify.ID && (c.CancelledAt != "2025-06-20" || c.Saved != 6594) || c.ID == aws.ID && c.Saved != 12000 {
+			t.Errorf("cancellation = %+v", c)
+		}
+	}
+
+	report = annualReport{}
+	h.doJSON("GET", "/api/reports/annual/2024", nil, http.StatusOK, &report)
+	if report.TotalSpent != 0 || report.BiggestCategory != nil || report.MostExpensive != nil || len(report.NewSubscriptions) != 0 || len(report.Cancellations) != 0 {
+		t.Errorf("2024 = %+v", report)
+	}
+	h.doJSON("GET", "/api/reports/annual/last", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/reports/annual/0", nil, http.StatusBadRequest, nil)
+}
+
+func TestInsights(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	aws := h.createSubscription(awsFixture())
+	disney := netflixFixture()
+	disney.Name, disney.Cost = "Disney+", 799
+	disney = h.createSubscription(disney)
+
+	// Seven months on, Spotify has been confirmed and Disney+ edited;
+	// nobody has touched Netflix or AWS.
+	h.clock.Advance(7 * 30 * 24 * time.Hour)
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/verify"), nil, http.StatusNoContent, nil)
+	h.patchSubscription(disney.ID, map[string]any{"cost": 8.99}, http.StatusOK, nil)
+
+	var got struct {
+		Currency         string       `json:"currency"`
+		PotentialSavings models.Money `json:"potentialSavings"`
+		Unused           []struct {
+			ID           int          `json:"id"`
+			LastActivity string       `json:"lastActivity"`
+			YearlyCost   models.Money `json:"yearlyCost"`
+		} `json:"unused"`
+		Overlapping []struct {
+			Category      string                `json:"category"`
+			Subscriptions []models.Subscription `json:"subscriptions"`
+			YearlyCost    models.Money          `json:"yearlyCost"`
+		} `json:"overlapping"`
+		SwitchToYearly []struct {
+			SubscriptionID int          `json:"subscriptionId"`
+			YearlyPlanCost models.Money `json:"yearlyPlanCost"`
+			YearlySavings  models.Money `json:"yearlySavings"`
+		} `json:"switchToYearly"`
+		PriceIncreases []struct {
+			SubscriptionID int          `json:"subscriptionId"`
+			PreviousCost   models.Money `json:"previousCost"`
+		} `json:"priceIncreases"`
+	}
+	h.doJSON("GET", "/api/insights", nil, http.StatusOK, &got)
+
+	if len(got.Unused) != 2 || got.Unused[0].ID != netflix.ID || got.Unused[0].YearlyCost != 18588 || got.Unused[1].ID != aws.ID {
+		t.Errorf("unused = %+v, want Netflix then AWS", got.Unused)
+	} else if got.Unused[0].LastActivity != "2025-05-01T12:00:00Z" {
+		t.Errorf("netflix last activity = %s, want when it was added", got.Unused[0].LastActivity)
+	}
+	if len(got.Overlapping) != 1 || got.Overlapping[0].Category != "Entertainment" || len(got.Overlapping[0].Subscriptions) != 2 || got.Overlapping[0].YearlyCost != 29376 {
+		t.Errorf("overlapping = %+v, want Netflix and Disney+", got.Overlapping)
+	}
+	// Ten months instead of twelve; AWS is yearly already and Netflix
+	// looks unused.
+	if len(got.SwitchToYearly) != 2 || got.SwitchToYearly[0].SubscriptionID != spotify.ID || got.SwitchToYearly[0].YearlySavings != 2198 ||
+		got.SwitchToYearly[1].SubscriptionID != disney.ID || got.SwitchToYearly[1].YearlyPlanCost != 8990 {
+		t.Errorf("switch to yearly = %+v", got.SwitchToYearly)
+	}
+	if len(got.PriceIncreases) != 1 || got.PriceIncreases[0].SubscriptionID != disney.ID || got.PriceIncreases[0].PreviousCost != 799 {
+		t.Errorf("price increases = %+v", got.PriceIncreases)
+	}
+	if got.Currency != "USD" || got.PotentialSavings != 18588+12000+2198+1798 {
+		t.Errorf("potential savings = %s %s", got.PotentialSavings, got.Currency)
+	}
+
+	h.doJSON("GET", "/api/insights?currency=EUR", nil, http.StatusServiceUnavailable, nil)
+}
+
+func TestCostPerUse(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	h.createSubscription(awsFixture())
+
+	var today, earlier models.UsageEvent
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/usage"), nil, http.StatusCreated, &today)
+	if today.Date.String() != "2025-05-01" || today.SubscriptionID != netflix.ID {
+		t.Errorf("logged use = %+v, want it today", today)
+	}
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/usage"), map[string]any{"date": "2025-04-25", "note": " Watched two episodes "}, http.StatusCreated, &earlier)
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/usage"), map[string]any{"date": "2025-05-02"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/usage"), map[string]any{"note": strings.Repeat("x", maxUsageNote+1)}, http.StatusBadRequest, nil)
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/usage"), map[string]any{"date": "2025-03-01"}, http.StatusCreated, nil)
+	h.signup("other@example.com").doJSON("POST", subscriptionPath(netflix.ID, "/usage"), nil, http.StatusNotFound, nil)
+
+	var uses []models.UsageEvent
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/usage"), nil, http.StatusOK, &uses)
+	if len(uses) != 2 || uses[0].ID != today.ID || uses[1].Note != "Watched two episodes" {
+		t.Errorf("uses = %+v, want the latest first", uses)
+	}
+
+	var got struct {
+		PotentialSavings models.Money `json:"potentialSavings"`
+		UsageDays        int          `json:"usageDays"`
+		CostPerUse       []struct {
+			SubscriptionID int          `json:"subscriptionId"`
+			Uses           int          `json:"uses"`
+			Cost           models.Money `json:"cost"`
+			CostPerUse     models.Money `json:"costPerUse"`
+		} `json:"costPerUse"`
+		CancellationCandidates []struct {
+			ID         int          `json:"id"`
+			LastUsed   string       `json:"lastUsed"`
+			YearlyCost models.Money `json:"yearlyCost"`
+		} `json:"cancellationCandidates"`
+	}
+	// Over 30 days Netflix costs 30/365 of 185.88, used twice; Spotify
+	// hasn't been used since March. AWS has no uses logged, so it's left
+	// out of both.
+	h.doJSON("GET", "/api/insights", nil, http.StatusOK, &got)
+	if got.UsageDays != 30 || len(got.CostPerUse) != 1 || got.CostPerUse[0].SubscriptionID != netflix.ID || got.CostPerUse[0].Uses != 2 ||
+		got.CostPerUse[0].Cost != 1528 || got.CostPerUse[0].CostPerUse != 764 {
+		t.Errorf("cost per use = %+v", got.CostPerUse)
+	}
+	if len(got.CancellationCandidates) != 1 || got.CancellationCandidates[0].ID != spotify.ID || got.CancellationCandidates[0].LastUsed != "2025-03-01" ||
+		got.CancellationCandidates[0].YearlyCost != 13188 {
+		t.Errorf("cancellation candidates = %+v", got.CancellationCandidates)
+	}
+	// Cancelling Spotify, and Netflix going yearly.
+	if got.PotentialSavings != 13188+3098 {
+		t.Errorf("potential savings = %s", got.PotentialSavings)
+	}
+
+	h.doJSON("GET", "/api/insights?usageDays=90", nil, http.StatusOK, &got)
+	if len(got.CostPerUse) != 2 || len(got.CancellationCandidates) != 0 {
+		t.Errorf("over 90 days: cost per use %+v, candidates %+v", got.CostPerUse, got.CancellationCandidates)
+	}
+	h.doJSON("GET", "/api/insights?usageDays=0", nil, http.StatusBadRequest, nil)
+
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, fmt.Sprintf("/usage/%d", today.ID)), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, fmt.Sprintf("/usage/%d", today.ID)), nil, http.StatusNotFound, nil)
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/usage"), nil, http.StatusOK, &uses)
+	if len(uses) != 1 || uses[0].ID != earlier.ID {
+		t.Errorf("uses after delete = %+v", uses)
+	}
+}
+
+func TestStatsUpcomingFollowsClock(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+
+	var stats struct {
+		Upcoming []models.Subscription `json:"upcoming"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.Upcoming) != 0 {
+		t.Errorf("got %d upcoming on May 1, want 0", len(stats.Upcoming))
+	}
+
+	h.clock.Set(time.Date(2025, 5, 8, 0, 0, 0, 0, time.UTC))
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if len(stats.Upcoming) != 1 {
+		t.Errorf("got %d upcoming on May 8, want 1", len(stats.Upcoming))
+	}
+}
+
+func TestStatsComparison(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	// A month on, Netflix costs more, Spotify is cancelled and AWS is new.
+	h.clock.Set(time.Date(2025, 6, 5, 12, 0, 0, 0, time.UTC))
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/cancel"), map[string]any{"date": "2025-06-05"}, http.StatusOK, nil)
+	h.createSubscription(awsFixture())
+
+	var stats struct {
+		TotalMonthly models.Money     `json:"totalMonthly"`
+		Comparison   *statsComparison `json:"comparison"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.Comparison != nil {
+		t.Errorf("comparison without compareTo: %+v", stats.Comparison)
+	}
+
+	h.doJSON("GET", "/api/stats?compareTo=previous_month", nil, http.StatusOK, &stats)
+	c := stats.Comparison
+	if c == nil || c.CompareTo != "previous_month" || c.Date.String() != "2025-05-05" {
+		t.Fatalf("comparison = %+v", c)
+	}
+	if m := c.TotalMonthly; stats.TotalMonthly != 2799 || m.Previous != 2648 || m.Change != 151 || m.Percent == nil || *m.Percent != 5.7 {
+		t.Errorf("total monthly = %+v, now %v", m, stats.TotalMonthly)
+	}
+	if y := c.TotalYearly; y.Previous != 31776 || y.Change != 1812 {
+		t.Errorf("total yearly = %+v", y)
+	}
+	// Categories counted now come first, in the stats' order, then the
+	// ones that are gone.
+	if len(c.ByCategory) != 3 {
+		t.Fatalf("by category = %+v", c.ByCategory)
+	}
+	if e := c.ByCategory[0]; e.Category != "Entertainment" || e.Monthly.Previous != 1549 || e.Monthly.Change != 250 || *e.Monthly.Percent != 16.14 {
+		t.Errorf("entertainment = %+v", e)
+	}
+	if cloud := c.ByCategory[1]; cloud.Category != "Cloud" || cloud.Monthly.Previous != 0 || cloud.Monthly.Change != 1000 || cloud.Monthly.Percent != nil {
+		t.Errorf("cloud = %+v", cloud)
+	}
+	if music := c.ByCategory[2]; music.Category != "Music" || music.Monthly.Change != -1099 || *music.Monthly.Percent != -100 {
+		t.Errorf("music = %+v", music)
+	}
+
+	// With asOf, the comparison counts back from that day instead.
+	h.doJSON("GET", "/api/stats?asOf=2025-06-01&compareTo=previous_month", nil, http.StatusOK, &stats)
+	if c := stats.Comparison; c == nil || c.Date.String() != "2025-05-01" || c.TotalMonthly.Previous != 2648 {
+		t.Errorf("comparison with a month before June 1 = %+v", c)
+	}
+	h.doJSON("GET", "/api/stats?asOf=2025-05-15&compareTo=previous_month", nil, http.StatusOK, &stats)
+	if c := stats.Comparison; c == nil || c.Date.String() != "2025-04-15" || c.TotalMonthly.Previous != 0 {
+		t.Errorf("comparison with a month before May 15 = %+v", c)
+	}
+
+	// Nothing had been added a year ago.
+	h.doJSON("GET", "/api/stats?compareTo=previous_year", nil, http.StatusOK, &stats)
+	if c := stats.Comparison; c == nil || c.Date.String() != "2024-06-05" || c.TotalMonthly.Previous != 0 || c.TotalMonthly.Percent != nil {
+		t.Errorf("comparison with a year ago = %+v", c)
+	}
+	h.doJSON("GET", "/api/stats?compareTo=last_week", nil, http.StatusBadRequest, nil)
+}
+
+func TestStatsAsOf(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+
+	var stats struct {
+		AsOf           string                `json:"asOf"`
+		Upcoming       []models.Subscription `json:"upcoming"`
+		PriceIncreases []priceIncrease       `json:"priceIncreases"`
+	}
+	h.doJSON("GET", "/api/stats", nil, http.StatusOK, &stats)
+	if stats.AsOf != "" || len(stats.Upcoming) != 1 || stats.Upcoming[0].Name != "Spotify" || len(stats.PriceIncreases) != 1 {
+		t.Fatalf("stats today = %+v", stats)
+	}
+
+	// Spotify's May 3 renewal is behind by May 8; Netflix's is due.
+	h.doJSON("GET", "/api/stats?asOf=2025-05-08", nil, http.StatusOK, &stats)
+	if stats.AsOf != "2025-05-08" {
+		t.Errorf("asOf = %q", stats.AsOf)
+	}
+	if len(stats.Upcoming) != 1 || stats.Upcoming[0].Name != "Netflix" || stats.Upcoming[0].NextBilling.String() != "2025-05-12" {
+		t.Errorf("upcoming as of May 8 = %+v", stats.Upcoming)
+	}
+	// Spotify is rolled forward to its next renewal.
+	h.doJSON("GET", "/api/stats?asOf=2025-06-01", nil, http.StatusOK, &stats)
+	if len(stats.Upcoming) != 1 || stats.Upcoming[0].Name != "Spotify" || stats.Upcoming[0].NextBilling.String() != "2025-06-03" {
+		t.Errorf("upcoming as of June 1 = %+v", stats.Upcoming)
+	}
+	// Dates before a subscription's next billing aren't renewals.
+	h.doJSON("GET", "/api/stats?asOf=2025-04-20", nil, http.StatusOK, &stats)
+	if len(stats.Upcoming) != 0 {
+		t.Errorf("upcoming as of April 20 = %+v", stats.Upcoming)
+	}
+
+	// The price went up on May 1, so the day before it hadn't.
+	if len(stats.PriceIncreases) != 0 {
+		t.Errorf("price increases as of April 20 = %+v", stats.PriceIncreases)
+	}
+	h.doJSON("GET", "/api/stats?asOf=2025-05-01", nil, http.StatusOK, &stats)
+	if len(stats.PriceIncreases) != 1 || stats.PriceIncreases[0].Cost != 1799 {
+		t.Errorf("price increases as of May 1 = %+v", stats.PriceIncreases)
+	}
+
+	h.doJSON("GET", "/api/stats?asOf=May+1", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/stats?asOf=2025-13-01", nil, http.StatusBadRequest, nil)
+}
+
+func TestRollForward(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	aws := h.createSubscription(awsFixture())
+	monthEnd := spotifyFixture()
+	monthEnd.NextBilling = models.MustParseDate("2025-01-31")
+	monthEnd = h.createSubscription(monthEnd)
+
+	// By July 20 Netflix has passed three dates and the month-end plan
+	// six; AWS isn't due until November.
+	h.clock.Set(time.Date(2025, 7, 20, 9, 0, 0, 0, time.UTC))
+	moved, err := h.app.rollForward(context.Background())
+	if err != nil || moved != 2 {
+		t.Fatalf("rollForward = %d, %v; want 2 moved", moved, err)
+	}
+
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusOK, &got)
+	if got.NextBilling.String() != "2025-08-12" {
+		t.Errorf("netflix next billing = %s, want 2025-08-12", got.NextBilling)
+	}
+	h.doJSON("GET", subscriptionPath(monthEnd.ID, ""), nil, http.StatusOK, &got)
+	if got.NextBilling.String() != "2025-07-31" {
+		t.Errorf("month-end next billing = %s, want 2025-07-31", got.NextBilling)
+	}
+	h.doJSON("GET", subscriptionPath(aws.ID, ""), nil, http.StatusOK, &got)
+	if got.NextBilling.String() != "2025-11-01" {
+		t.Errorf("aws moved to %s before its date", got.NextBilling)
+	}
+
+	var history []models.BillingEvent
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/history"), nil, http.StatusOK, &history)
+	var dates []string
+	for _, e := range history {
+		dates = append(dates, e.Date)
+		if e.Amount != netflix.Cost || e.SubscriptionID != netflix.ID {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	if strings.Join(dates, ",") != "2025-07-12,2025-06-12,2025-05-12" {
+		t.Errorf("netflix history = %v", dates)
+	}
+	h.doJSON("GET", subscriptionPath(monthEnd.ID, "/history"), nil, http.StatusOK, &history)
+	if len(history) != 6 || history[4].Date != "2025-02-28" {
+		t.Errorf("month-end history = %+v", history)
+	}
+
+	// A second run finds nothing to do.
+	if moved, err := h.app.rollForward(context.Background()); err != nil || moved != 0 {
+		t.Errorf("second rollForward = %d, %v", moved, err)
+	}
+	h.doJSON("GET", subscriptionPath(aws.ID, "/history"), nil, http.StatusOK, &history)
+	if len(history) != 0 {
+		t.Errorf("aws history = %+v", history)
+	}
+	h.signup("other@example.com").doJSON("GET", subscriptionPath(netflix.ID, "/history"), nil, http.StatusNotFound, nil)
+}
+
+// recordingMailer keeps what it's asked to send, failing instead while
+// fail is set.
+type recordingMailer struct {
+	sent []notify.Email
+	fail error
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg notify.Email) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestReminders(t *testing.T) {
+	h := newHarness(t)
+	mailer := &recordingMailer{}
+	h.app.mailer = mailer
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	var setting struct {
+		DaysBefore *int `json:"daysBefore"`
+	}
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/reminder"), nil, http.StatusOK, &setting)
+	if setting.DaysBefore != nil {
+		t.Errorf("new subscription has a reminder %d days before", *setting.DaysBefore)
+	}
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{"daysBefore": 31}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{"daysBefore": 3}, http.StatusOK, nil)
+	h.doJSON("PUT", subscriptionPath(spotify.ID, "/reminder"), map[string]any{"daysBefore": 1}, http.StatusOK, nil)
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/reminder"), nil, http.StatusOK, &setting)
+	if setting.DaysBefore == nil || *setting.DaysBefore != 3 {
+		t.Errorf("netflix reminder = %v, want 3 days before", setting.DaysBefore)
+	}
+	h.signup("other@example.com").doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{"daysBefore": 3}, http.StatusNotFound, nil)
+
+	// On May 1 Netflix (May 12) is too far off and Spotify (May 3) two
+	// days away, one more than its reminder.
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Fatalf("sendReminders on May 1 = %d, %v; want 0", n, err)
+	}
+
+	// A failed send is retried on the next run.
+	h.clock.Set(time.Date(2025, 5, 9, 8, 0, 0, 0, time.UTC))
+	mailer.fail = errors.New("connection refused")
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Fatalf("failing sendReminders = %d, %v; want 0", n, err)
+	}
+	mailer.fail = nil
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 1 {
+		t.Fatalf("sendReminders on May 9 = %d, %v; want 1", n, err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(mailer.sent))
+	}
+	msg := mailer.sent[0]
+	if strings.Join(msg.To, ",") != testEmail || msg.Subject != "Netflix renews in 3 days" {
+		t.Errorf("sent %q to %v", msg.Subject, msg.To)
+	}
+	if !strings.Contains(msg.TextBody, "Monday, May 12") || !strings.Contains(msg.HTMLBody, "<strong>15.49 USD</strong>") {
+		t.Errorf("unexpected bodies:\n%s\n%s", msg.TextBody, msg.HTMLBody)
+	}
+
+	// Each billing date is mailed about once, however many runs see it.
+	h.clock.Set(time.Date(2025, 5, 11, 8, 0, 0, 0, time.UTC))
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Errorf("repeat sendReminders = %d, %v; want 0", n, err)
+	}
+
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, "/reminder"), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/reminder"), nil, http.StatusOK, &setting)
+	if setting.DaysBefore != nil {
+		t.Errorf("deleted reminder still set to %d days", *setting.DaysBefore)
+	}
+}
+
+func TestCancellationInfo(t *testing.T) {
+	h := newHarness(t)
+	mailer := &recordingMailer{}
+	h.app.mailer = mailer
+	netflix := h.createSubscription(netflixFixture())
+	gym := netflixFixture()
+	gym.Name = "Local Gym"
+	gym = h.createSubscription(gym)
+
+	// Without anything set, the catalog's cancellation page is offered
+	// for a service it knows.
+	var info cancellationInfo
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/cancellation-info"), nil, http.StatusOK, &info)
+	if info.URL != "https://www.netflix.com/cancelplan" || !info.FromCatalog || info.RemindBeforeRenewal || info.RenewsOn == nil || *info.RenewsOn != "2025-05-12" {
+		t.Errorf("netflix = %+v", info)
+	}
+	h.doJSON("GET", subscriptionPath(gym.ID, "/cancellation-info"), nil, http.StatusOK, &info)
+	if info.URL != "" || info.FromCatalog {
+		t.Errorf("gym = %+v", info)
+	}
+
+	h.doJSON("PUT", subscriptionPath(gym.ID, "/cancellation-info"), map[string]any{"url": "gym.example.com"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", subscriptionPath(gym.ID, "/cancellation-info"), map[string]any{"instructions": strings.Repeat("x", maxCancellationInstructions+1)}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", subscriptionPath(gym.ID, "/cancellation-info"), map[string]any{
+		"url": "https://gym.example.com/account", "instructions": "Write to the front desk a month ahead.", "remindBeforeRenewal": true,
+	}, http.StatusOK, &info)
+	if info.URL != "https://gym.example.com/account" || info.FromCatalog || !info.RemindBeforeRenewal {
+		t.Errorf("gym after PUT = %+v", info)
+	}
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/cancellation-info"), map[string]any{"remindBeforeRenewal": true}, http.StatusOK, &info)
+	if !info.FromCatalog || !info.RemindBeforeRenewal {
+		t.Errorf("netflix after PUT = %+v", info)
+	}
+	h.signup("other@example.com").doJSON("GET", subscriptionPath(gym.ID, "/cancellation-info"), nil, http.StatusNotFound, nil)
+
+	// A subscription made from the catalog keeps its cancellation page
+	// when it's renamed.
+	var s models.Subscription
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"catalogId": "spotify", "name": "Kids' music", "nextBilling": "2025-06-03"}, http.StatusCreated, &s)
+	h.doJSON("GET", subscriptionPath(s.ID, "/cancellation-info"), nil, http.StatusOK, &info)
+	if info.URL == "" {
+		t.Errorf("renamed catalog subscription = %+v", info)
+	}
+
+	// Both subscriptions renew on May 12; the reminders go out from three
+	// days before, once.
+	h.clock.Set(time.Date(2025, 5, 8, 8, 0, 0, 0, time.UTC))
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Fatalf("sendReminders on May 8 = %d, %v; want 0", n, err)
+	}
+	h.clock.Set(time.Date(2025, 5, 9, 8, 0, 0, 0, time.UTC))
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 2 {
+		t.Fatalf("sendReminders on May 9 = %d, %v; want 2", n, err)
+	}
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Errorf("repeat sendReminders = %d, %v; want 0", n, err)
+	}
+	if len(mailer.sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(mailer.sent))
+	}
+	msg := mailer.sent[1]
+	if msg.Subject != "Cancel Local Gym before it renews in 3 days" || !strings.Contains(msg.TextBody, "https://gym.example.com/account") ||
+		!strings.Contains(msg.HTMLBody, "Write to the front desk a month ahead.") {
+		t.Errorf("sent %q:\n%s\n%s", msg.Subject, msg.TextBody, msg.HTMLBody)
+	}
+	if !strings.Contains(mailer.sent[0].TextBody, "https://www.netflix.com/cancelplan") {
+		t.Errorf("netflix reminder has no catalog link:\n%s", mailer.sent[0].TextBody)
+	}
+
+	// A cancelled subscription isn't renewing, so there's nothing to
+	// remind about.
+	h.doJSON("POST", subscriptionPath(gym.ID, "/cancel"), map[string]any{}, http.StatusOK, nil)
+	h.doJSON("GET", subscriptionPath(gym.ID, "/cancellation-info"), nil, http.StatusOK, &info)
+	if info.RenewsOn != nil {
+		t.Errorf("cancelled gym renews on %s", *info.RenewsOn)
+	}
+
+	h.doJSON("DELETE", subscriptionPath(gym.ID, "/cancellation-info"), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(gym.ID, "/cancellation-info"), nil, http.StatusOK, &info)
+	if info.URL != "" || info.Instructions != "" || info.RemindBeforeRenewal {
+		t.Errorf("gym after DELETE = %+v", info)
+	}
+}
+
+func TestMonthlyDigest(t *testing.T) {
+	h := newHarness(t)
+	mailer := &recordingMailer{}
+	h.app.mailer = mailer
+	netflix := h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+	h.signup("other@example.com").createSubscription(netflixFixture())
+
+	var me models.User
+	h.doJSON("PATCH", "/api/me", map[string]any{"monthlyDigest": true}, http.StatusOK, &me)
+	if !me.MonthlyDigest {
+		t.Fatalf("me = %+v, want the digest on", me)
+	}
+
+	// Nothing happened in April.
+	if n, err := h.app.sendDigests(context.Background()); err != nil || n != 0 {
+		t.Fatalf("sendDigests on May 1 = %d, %v; want 0", n, err)
+	}
+
+	h.clock.Set(time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := h.app.sendDigests(context.Background()); err != nil || n != 1 {
+		t.Fatalf("sendDigests on June 1 = %d, %v; want 1", n, err)
+	}
+	msg := mailer.sent[0]
+	if strings.Join(msg.To, ",") != testEmail || msg.Subject != "Your subscriptions in May 2025" {
+		t.Errorf("sent %q to %v", msg.Subject, msg.To)
+	}
+	for _, want := range []string{"26.48 USD over 2 charges.", "Added:\n  Spotify, 10.99 USD monthly\n  Netflix", "Tuesday, June 3: Spotify, 10.99 USD"} {
+		if !strings.Contains(msg.TextBody, want) {
+			t.Errorf("May digest is missing %q:\n%s", want, msg.TextBody)
+		}
+	}
+	if n, err := h.app.sendDigests(context.Background()); err != nil || n != 0 {
+		t.Errorf("repeat sendDigests = %d, %v; want 0", n, err)
+	}
+
+	// In June Netflix is cancelled before it bills, and Hulu added.
+	h.clock.Set(time.Date(2025, 6, 10, 8, 0, 0, 0, time.UTC))
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/cancel"), map[string]any{"date": "2025-06-10"}, http.StatusOK, nil)
+	h.createSubscription(models.Subscription{Name: "Hulu", Category: "Entertainment", Cost: 799, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-06-15")})
+	h.clock.Set(time.Date(2025, 7, 1, 8, 0, 0, 0, time.UTC))
+	if _, err := h.app.rollForward(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed send is retried on the next run.
+	mailer.fail = errors.New("connection refused")
+	if n, err := h.app.sendDigests(context.Background()); err != nil || n != 0 {
+		t.Fatalf("failing sendDigests = %d, %v; want 0", n, err)
+	}
+	mailer.fail = nil
+	if n, err := h.app.sendDigests(context.Background()); err != nil || n != 1 {
+		t.Fatalf("sendDigests on July 1 = %d, %v; want 1", n, err)
+	}
+	msg = mailer.sent[1]
+	for _, want := range []string{"18.98 USD over 2 charges, 7.50 USD less than in May.", "Added:\n  Hulu", "Cancelled:\n  Netflix, on June 10"} {
+		if !strings.Contains(msg.TextBody, want) {
+			t.Errorf("June digest is missing %q:\n%s", want, msg.TextBody)
+		}
+	}
+	if !strings.Contains(msg.HTMLBody, "<strong>18.98 USD</strong>") {
+		t.Errorf("June digest HTML:\n%s", msg.HTMLBody)
+	}
+}
+
+// pushReceiver stands in for an ntfy server, a Gotify server and a browser
+// push service, recording each request by path. /push/gone answers 410,
+// like a push service for a browser that unsubscribed.
+type pushReceiver struct {
+	mu       sync.Mutex
+	requests map[string][]*http.Request
+	bodies   map[string][]string
+}
+
+func (p *pushReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.mu.Lock()
+	p.requests[r.URL.Path] = append(p.requests[r.URL.Path], r)
+	p.bodies[r.URL.Path] = append(p.bodies[r.URL.Path], string(body))
+	p.mu.Unlock()
+	if r.URL.Path == "/push/gone" {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// browserKeys makes the keys a browser would register for Web Push.
+func browserKeys(t *testing.T) map[string]string {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return map[string]string{
+		"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		"auth":   base64.RawURLEncoding.EncodeToString(secret),
+	}
+}
+
+func TestPushNotifications(t *testing.T) {
+	h := newHarness(t)
+	receiver := &pushReceiver{requests: map[string][]*http.Request{}, bodies: map[string][]string{}}
+	srv := httptest.NewTLSServer(receiver)
+	defer srv.Close()
+
+	var settings notificationSettings
+	h.doJSON("GET", "/api/me/notifications", nil, http.StatusOK, &settings)
+	if settings.VAPIDPublicKey != "" || len(settings.Channels) != 5 || settings.Channels[0].Channel != "webpush" || settings.Channels[1].Enabled {
+		t.Fatalf("new user's settings = %+v", settings)
+	}
+	keys := browserKeys(t)
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": srv.URL + "/push/ok", "keys": keys}, http.StatusServiceUnavailable, nil)
+	h.doJSON("PUT", "/api/me/notifications/webpush", map[string]any{"enabled": true}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/sms", map[string]any{"enabled": true}, http.StatusNotFound, nil)
+	h.doJSON("PUT", "/api/me/notifications/ntfy", map[string]any{"enabled": true}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/ntfy", map[string]any{"enabled": true, "url": "ntfy.sh/alerts"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/gotify", map[string]any{"enabled": true, "url": srv.URL}, http.StatusBadRequest, nil)
+
+	var channel models.NotificationChannel
+	h.doJSON("PUT", "/api/me/notifications/ntfy", map[string]any{"enabled": true, "url": srv.URL + "/alerts", "token": "tk_ntfy"}, http.StatusOK, &channel)
+	if !channel.Enabled || channel.URL != srv.URL+"/alerts" || !channel.HasToken {
+		t.Errorf("ntfy = %+v", channel)
+	}
+	h.doJSON("PUT", "/api/me/notifications/gotify", map[string]any{"enabled": true, "url": srv.URL + "/gotify/", "token": "app-token"}, http.StatusOK, nil)
+	// Turning a channel off keeps where it publishes.
+	h.doJSON("PUT", "/api/me/notifications/gotify", map[string]any{"enabled": false}, http.StatusOK, &channel)
+	if channel.Enabled || channel.URL != srv.URL+"/gotify/" || !channel.HasToken {
+		t.Errorf("gotify turned off = %+v", channel)
+	}
+	h.doJSON("PUT", "/api/me/notifications/gotify", map[string]any{"enabled": true}, http.StatusOK, nil)
+
+	// With VAPID keys, browsers can register.
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.app.config.VAPIDPublicKey = public
+	h.app.channels = map[string]notify.Channel{
+		notify.ChannelWebPush: notify.WebPush{PublicKey: public, PrivateKey: private, Subject: "mailto:ops@example.com", Client: srv.Client()},
+		notify.ChannelNtfy:    notify.Ntfy{Client: srv.Client()},
+		notify.ChannelGotify:  notify.Gotify{Client: srv.Client()},
+	}
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": "http://push.example.com/1", "keys": keys}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": srv.URL + "/push/ok", "keys": map[string]string{"p256dh": "short", "auth": keys["auth"]}}, http.StatusBadRequest, nil)
+	var sub models.PushSubscription
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": srv.URL + "/push/ok", "keys": keys}, http.StatusCreated, &sub)
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": srv.URL + "/push/gone", "keys": browserKeys(t)}, http.StatusCreated, nil)
+	// Registering again updates the same subscription.
+	var again models.PushSubscription
+	h.doJSON("POST", "/api/me/push-subscriptions", map[string]any{"endpoint": srv.URL + "/push/ok", "keys": browserKeys(t)}, http.StatusCreated, &again)
+	if again.ID != sub.ID {
+		t.Errorf("re-registering gave subscription %d, want %d", again.ID, sub.ID)
+	}
+	h.doJSON("GET", "/api/me/notifications", nil, http.StatusOK, &settings)
+	if settings.VAPIDPublicKey != public || !settings.Channels[0].Enabled {
+		t.Errorf("settings after registering = %+v", settings)
+	}
+	other := h.signup("other@example.com")
+	other.doJSON("DELETE", fmt.Sprintf("/api/me/push-subscriptions/%d", sub.ID), nil, http.StatusNotFound, nil)
+
+	// A price increase is pushed everywhere that's turned on.
+	netflix := h.createSubscription(netflixFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+
+	receiver.mu.Lock()
+	ntfy, gotify := receiver.requests["/alerts"], receiver.requests["/gotify/message"]
+	if len(ntfy) != 1 || ntfy[0].Header.Get("Title") != "Price increase" || ntfy[0].Header.Get("Authorization") != "Bearer tk_ntfy" ||
+		receiver.bodies["/alerts"][0] != "Netflix went up from 15.49 to 17.99 USD" {
+		t.Errorf("ntfy got %d requests: %+v", len(ntfy), receiver.bodies["/alerts"])
+	}
+	if len(gotify) != 1 || gotify[0].Header.Get("X-Gotify-Key") != "app-token" ||
+		receiver.bodies["/gotify/message"][0] != `{"message":"Netflix went up from 15.49 to 17.99 USD","title":"Price increase"}` {
+		t.Errorf("gotify got %d requests: %+v", len(gotify), receiver.bodies["/gotify/message"])
+	}
+	pushed := receiver.requests["/push/ok"]
+	if len(pushed) != 1 || pushed[0].Header.Get("Content-Encoding") != "aes128gcm" || !strings.HasPrefix(pushed[0].Header.Get("Authorization"), "vapid t=") {
+		t.Errorf("web push got %d requests", len(pushed))
+	}
+	if len(receiver.requests["/push/gone"]) != 1 {
+		t.Errorf("gone subscription got %d requests, want 1", len(receiver.requests["/push/gone"]))
+	}
+	receiver.mu.Unlock()
+
+	// The browser that unsubscribed is forgotten.
+	var subs []models.PushSubscription
+	h.doJSON("GET", "/api/me/push-subscriptions", nil, http.StatusOK, &subs)
+	if len(subs) != 1 || subs[0].ID != sub.ID {
+		t.Errorf("push subscriptions = %+v", subs)
+	}
+	h.doJSON("DELETE", fmt.Sprintf("/api/me/push-subscriptions/%d", sub.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", "/api/me/push-subscriptions", nil, http.StatusOK, &subs)
+	if len(subs) != 0 {
+		t.Errorf("push subscriptions after delete = %+v", subs)
+	}
+}
+
+func TestChatNotifications(t *testing.T) {
+	h := newHarness(t)
+	receiver := &pushReceiver{requests: map[string][]*http.Request{}, bodies: map[string][]string{}}
+	srv := httptest.NewTLSServer(receiver)
+	defer srv.Close()
+	h.app.channels[notify.ChannelSlack] = notify.Slack{Client: srv.Client()}
+	h.app.channels[notify.ChannelDiscord] = notify.Discord{Client: srv.Client()}
+
+	h.doJSON("PUT", "/api/me/notifications/slack", map[string]any{"enabled": true, "url": "http://hooks.slack.com/services/T0/B0/x"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/slack", map[string]any{"enabled": true, "url": srv.URL + "/slack", "token": "x"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/notifications/test", nil, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/slack", map[string]any{"enabled": true, "url": srv.URL + "/slack"}, http.StatusOK, nil)
+	h.doJSON("PUT", "/api/me/notifications/discord", map[string]any{"url": srv.URL + "/discord"}, http.StatusOK, nil)
+
+	// A test goes to every channel that's on, or to the one asked for.
+	var results []pushResult
+	h.doJSON("POST", "/api/notifications/test", nil, http.StatusOK, &results)
+	if len(results) != 1 || results[0].Channel != "slack" || !results[0].Delivered {
+		t.Errorf("test results = %+v", results)
+	}
+	var slack struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type string `json:"type"`
+			Text struct {
+				Text string `json:"text"`
+			} `json:"text"`
+			Fields []struct {
+				Text string `json:"text"`
+			} `json:"fields"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(receiver.bodies["/slack"][0]), &slack); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.Blocks) != 3 || slack.Blocks[0].Type != "header" || slack.Blocks[0].Text.Text != "Test notification" ||
+		slack.Blocks[2].Fields[0].Text != "*Sent*\nMay 1, 12:00 UTC" {
+		t.Errorf("slack test message = %s", receiver.bodies["/slack"][0])
+	}
+	h.doJSON("POST", "/api/notifications/test", map[string]any{"channel": "discord"}, http.StatusOK, &results)
+	if len(results) != 1 || results[0].Channel != "discord" || !results[0].Delivered {
+		t.Errorf("discord test results = %+v", results)
+	}
+	h.doJSON("POST", "/api/notifications/test", map[string]any{"channel": "gotify"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/notifications/test", map[string]any{"channel": "sms"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/notifications/discord", map[string]any{"enabled": true}, http.StatusOK, nil)
+
+	// Budget alerts are posted to both.
+	h.doJSON("POST", "/api/budgets", map[string]any{"category": "Entertainment", "amount": 10}, http.StatusCreated, nil)
+	netflix := h.createSubscription(netflixFixture())
+	if n := len(receiver.bodies["/slack"]); n != 2 || !strings.Contains(receiver.bodies["/slack"][1], `"text":"Budget exceeded"`) {
+		t.Errorf("slack got %d messages: %v", n, receiver.bodies["/slack"])
+	}
+	var discord struct {
+		Embeds []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Fields      []struct {
+				Name   string `json:"name"`
+				Value  string `json:"value"`
+				Inline bool   `json:"inline"`
+			} `json:"fields"`
+		} `json:"embeds"`
+	}
+	if n := len(receiver.bodies["/discord"]); n != 2 {
+		t.Fatalf("discord got %d messages, want 2", n)
+	}
+	if err := json.Unmarshal([]byte(receiver.bodies["/discord"][1]), &discord); err != nil {
+		t.Fatal(err)
+	}
+	if len(discord.Embeds) != 1 || discord.Embeds[0].Title != "Budget exceeded" ||
+		discord.Embeds[0].Description != "Spending (Entertainment) is 15.49 USD a month, over the budget of 10.00" {
+		t.Errorf("discord budget alert = %s", receiver.bodies["/discord"][1])
+	}
+
+	// Renewal reminders go there too, without mail set up, once per
+	// channel.
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/reminder"), map[string]any{"daysBefore": 3}, http.StatusOK, nil)
+	h.clock.Set(time.Date(2025, 5, 11, 9, 0, 0, 0, time.UTC))
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 2 {
+		t.Fatalf("sendReminders = %d, %v; want 2", n, err)
+	}
+	if n, err := h.app.sendReminders(context.Background()); err != nil || n != 0 {
+		t.Errorf("repeat sendReminders = %d, %v; want 0", n, err)
+	}
+	if err := json.Unmarshal([]byte(receiver.bodies["/discord"][2]), &discord); err != nil {
+		t.Fatal(err)
+	}
+	embed := discord.Embeds[0]
+	if embed.Title != "Netflix renews tomorrow" || embed.Description != "15.49 USD will be billed on Monday, May 12." ||
+		len(embed.Fields) != 2 || embed.Fields[0].Value != "15.49 USD monthly" || !embed.Fields[1].Inline {
+		t.Errorf("discord reminder = %s", receiver.bodies["/discord"][2])
+	}
+	if !strings.Contains(receiver.bodies["/slack"][2], `"text":"Netflix renews tomorrow"`) {
+		t.Errorf("slack reminder = %s", receiver.bodies["/slack"][2])
+	}
+}
+
+func TestStripeSync(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	// A Stripe account with a monthly subscription billed in euros, as of
+	// API version 2025-03-31 with the period on the item, and a yearly one
+	// in yen that hasn't been invoiced.
+	june20 := time.Date(2025, 6, 20, 8, 0, 0, 0, time.UTC).Unix()
+	subs := map[string]string{
+		"sub_monthly": fmt.Sprintf(`{"id": "sub_monthly", "status": "active", "currency": "eur",
+			"items": {"data": [{"quantity": 1, "current_period_end": %d, "price": {"unit_amount": 1599, "recurring": {"interval": "month", "interval_count": 1}}}]},
+			"latest_invoice": {"id": "in_1", "total": 1935, "currency": "eur", "status": "paid", "billing_reason": "subscription_cycle"}}`, june20),
+		"sub_yearly": fmt.Sprintf(`{"id": "sub_yearly", "status": "trialing", "currency": "jpy", "current_period_end": %d,
+			"items": {"data": [{"quantity": 2, "price": {"unit_amount": 1200, "recurring": {"interval": "year", "interval_count": 1}}}]},
+			"latest_invoice": null}`, june20),
+	}
+	var authorizations []string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer sk_test_good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"type": "invalid_request_error", "message": "Invalid API Key provided"}}`)
+			return
+		}
+		if r.URL.Path == "/v1/subscriptions" {
+			fmt.Fprint(w, `{"object": "list", "data": []}`)
+			return
+		}
+		body, ok := subs[strings.TrimPrefix(r.URL.Path, "/v1/subscriptions/")]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": "resource_missing", "message": "No such subscription"}}`)
+		case body == "":
+			http.Error(w, `{"error": {"message": "Something went wrong"}}`, http.StatusInternalServerError)
+		case r.URL.Query().Get("expand[]") != "latest_invoice":
+			t.Errorf("fetched %s without expanding the invoice", r.URL)
+		default:
+			fmt.Fprint(w, body)
+		}
+	}))
+	t.Cleanup(fake.Close)
+	h.app.config.StripeURL = fake.URL
+
+	var account stripeAccount
+	h.doJSON("GET", "/api/me/stripe", nil, http.StatusOK, &account)
+	if account.Connected {
+		t.Errorf("account before connecting = %+v", account)
+	}
+	h.doJSON("PUT", subscriptionPath(netflix.ID, "/stripe"), map[string]any{"stripeSubscriptionId": "sub_monthly"}, http.StatusConflict, nil)
+	h.doJSON("POST", "/api/stripe/sync", nil, http.StatusConflict, nil)
+	h.doJSON("PUT", "/api/me/stripe", map[string]any{"apiKey": "pk_test_public"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/stripe", map[string]any{"apiKey": "sk_test_revoked"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", "/api/me/stripe", map[string]any{"apiKey": "sk_test_good"}, http.StatusOK, &account)
+	if !account.Connected || account.Links != 0 {
+		t.Errorf("connected account = %+v", account)
+	}
+
+	// Linking syncs at once: the cost is the renewal invoice's, tax and
+	// all, and the next billing date the end of the period.
+	path := subscriptionPath(netflix.ID, "/stripe")
+	h.doJSON("PUT", path, map[string]any{"stripeSubscriptionId": "cus_123"}, http.StatusBadRequest, nil)
+	h.doJSON("PUT", path, map[string]any{"stripeSubscriptionId": "sub_missing"}, http.StatusBadRequest, nil)
+	var link models.StripeLink
+	h.doJSON("PUT", path, map[string]any{"stripeSubscriptionId": "sub_monthly"}, http.StatusOK, &link)
+	if link.StripeSubscriptionID != "sub_monthly" || link.Status == nil || *link.Status != "active" || link.SyncedAt == nil || link.Error != nil {
+		t.Errorf("link = %+v", link)
+	}
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusOK, &got)
+	if got.Cost != 1935 || got.Currency != "EUR" || got.BillingCycle != "monthly" || got.NextBilling.String() != "2025-06-20" {
+		t.Errorf("Netflix after linking = %+v", got)
+	}
+	var prices []models.PriceChange
+	h.doJSON("GET", subscriptionPath(netflix.ID, "/prices"), nil, http.StatusOK, &prices)
+	if len(prices) == 0 {
+		t.Error("the synced price change wasn't recorded")
+	}
+	h.doJSON("PUT", subscriptionPath(spotify.ID, "/stripe"), map[string]any{"stripeSubscriptionId": "sub_monthly"}, http.StatusConflict, nil)
+
+	// Yen have no minor unit; without an invoice the prices are used.
+	h.doJSON("PUT", subscriptionPath(spotify.ID, "/stripe"), map[string]any{"stripeSubscriptionId": "sub_yearly"}, http.StatusOK, nil)
+	h.doJSON("GET", subscriptionPath(spotify.ID, ""), nil, http.StatusOK, &got)
+	if got.Cost != 240000 || got.Currency != "JPY" || got.BillingCycle != "yearly" || got.NextBilling.String() != "2025-06-20" {
+		t.Errorf("Spotify after linking = %+v", got)
+	}
+
+	// A cancelled Stripe subscription changes nothing, and a failed fetch
+	// is kept on its link.
+	subs["sub_monthly"] = `{"id": "sub_monthly", "status": "canceled", "currency": "eur", "items": {"data": []}}`
+	subs["sub_yearly"] = ""
+	var links []models.StripeLink
+	h.doJSON("POST", "/api/stripe/sync", nil, http.StatusOK, &links)
+	if len(links) != 2 || *links[0].Status != "canceled" || links[0].Error != nil || links[1].Error == nil || *links[1].Status != "trialing" {
+		t.Fatalf("links after syncing = %+v", links)
+	}
+	h.doJSON("GET", subscriptionPath(netflix.ID, ""), nil, http.StatusOK, &got)
+	if got.Cost != 1935 || got.Status != models.StatusActive {
+		t.Errorf("Netflix after Stripe cancelled it = %+v", got)
+	}
+
+	// The background job syncs every connected account.
+	subs["sub_yearly"] = strings.Replace(subs["sub_monthly"], "sub_monthly", "sub_yearly", 1)
+	if n, err := h.app.syncStripeAccounts(context.Background()); err != nil || n != 1 {
+		t.Errorf("syncStripeAccounts = %d, %v", n, err)
+	}
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/stripe"), nil, http.StatusOK, &link)
+	if link.Error != nil || *link.Status != "canceled" {
+		t.Errorf("Spotify's link after the job = %+v", link)
+	}
+
+	h.doJSON("DELETE", path, nil, http.StatusNoContent, nil)
+	h.doJSON("GET", path, nil, http.StatusNotFound, nil)
+	h.doJSON("DELETE", path, nil, http.StatusNotFound, nil)
+	h.doJSON("DELETE", "/api/me/stripe", nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/stripe"), nil, http.StatusNotFound, nil)
+	h.doJSON("DELETE", "/api/me/stripe", nil, http.StatusNotFound, nil)
+	if authorizations[0] != "Bearer sk_test_revoked" || authorizations[len(authorizations)-1] != "Bearer sk_test_good" {
+		t.Errorf("authorizations = %v", authorizations)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	attachments := subscriptionPath(netflix.ID, "/attachments")
+	receipt := "%PDF-1.7\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n"
+	if resp, _ := h.upload(attachments, "receipt.pdf", receipt); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("upload without storage: got %d, want 503", resp.StatusCode)
+	}
+
+	root := t.TempDir()
+	dir, err := blobs.NewDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.app.blobs = dir
+	resp, data := h.upload(attachments, `C:\Users\me\May receipt.pdf`, receipt)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload: got %d: %s", resp.StatusCode, data)
+	}
+	var att models.Attachment
+	if err := json.Unmarshal(data, &att); err != nil {
+		t.Fatal(err)
+	}
+	if att.Filename != "May receipt.pdf" || att.ContentType != "application/pdf" || att.Size != int64(len(receipt)) || att.SubscriptionID != netflix.ID {
+		t.Errorf("attachment = %+v", att)
+	}
+
+	// The type is sniffed, not taken from the name.
+	for name, content := range map[string]string{"notes.pdf": "just some text", "empty.pdf": ""} {
+		if resp, data := h.upload(attachments, name, content); resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), codeValidation) {
+			t.Errorf("upload %s: got %d: %s", name, resp.StatusCode, data)
+		}
+	}
+	h.app.config.MaxAttachmentBytes = 1024
+	if resp, _ := h.upload(attachments, "big.pdf", receipt+strings.Repeat("x", 2048)); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: got %d, want 413", resp.StatusCode)
+	}
+	h.app.config.MaxAttachmentBytes = 0
+	h.app.config.QuotaLimits[QuotaAttachmentBytes] = att.Size + 10
+	if resp, data := h.upload(attachments, "again.pdf", receipt); resp.StatusCode != http.StatusForbidden || !strings.Contains(string(data), codeQuotaExceeded) {
+		t.Errorf("upload over quota: got %d: %s", resp.StatusCode, data)
+	}
+	h.app.config.QuotaLimits[QuotaAttachmentBytes] = 0
+	keys, _ := dir.List(context.Background(), "")
+	if len(keys) != 1 {
+		t.Errorf("stored files = %v, want only the receipt", keys)
+	}
+
+	var list []models.Attachment
+	h.doJSON("GET", attachments, nil, http.StatusOK, &list)
+	if len(list) != 1 || list[0] != att {
+		t.Errorf("attachments = %+v, want %+v", list, att)
+	}
+	download := attachments + "/" + strconv.Itoa(att.ID)
+	resp, data = h.do("GET", download, nil)
+	if resp.StatusCode != http.StatusOK || string(data) != receipt || resp.Header.Get("Content-Type") != "application/pdf" ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename="May receipt.pdf"` {
+		t.Errorf("download: %d %v %q", resp.StatusCode, resp.Header, data)
+	}
+	spotify := h.createSubscription(spotifyFixture())
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/attachments/"+strconv.Itoa(att.ID)), nil, http.StatusNotFound, nil)
+	h.signup("other@example.com").doJSON("GET", download, nil, http.StatusNotFound, nil)
+
+	second, data := h.upload(subscriptionPath(spotify.ID, "/attachments"), "invoice.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if second.StatusCode != http.StatusCreated {
+		t.Fatalf("upload PNG: got %d: %s", second.StatusCode, data)
+	}
+	json.Unmarshal(data, &att)
+	h.doJSON("DELETE", subscriptionPath(spotify.ID, "/attachments/"+strconv.Itoa(att.ID)), nil, http.StatusNoContent, nil)
+	h.doJSON("GET", subscriptionPath(spotify.ID, "/attachments"), nil, http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Errorf("attachments after delete = %+v", list)
+	}
+
+	// Deleting the subscription purges its files.
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, ""), nil, http.StatusNoContent, nil)
+	if keys, _ := dir.List(context.Background(), ""); len(keys) != 0 {
+		t.Errorf("files left after deleting the subscription: %v", keys)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	h := newHarness(t)
+	var services []catalog.Service
+	h.doJSON("GET", "/api/catalog?q=net", nil, http.StatusOK, &services)
+	if len(services) == 0 || services[0].ID != "netflix" || services[0].CancelURL == "" || len(services[0].Plans) == 0 {
+		t.Fatalf("q=net: %+v", services)
+	}
+	h.doJSON("GET", "/api/catalog?q=hbo", nil, http.StatusOK, &services)
+	if len(services) != 1 || services[0].Name != "Max" {
+		t.Errorf("q=hbo should find Max by its alias: %+v", services)
+	}
+	h.doJSON("GET", "/api/catalog?limit=3", nil, http.StatusOK, &services)
+	if len(services) != 3 {
+		t.Errorf("limit=3: got %d services", len(services))
+	}
+	h.doJSON("GET", "/api/catalog?limit=0", nil, http.StatusBadRequest, nil)
+	var netflix catalog.Service
+	h.doJSON("GET", "/api/catalog/netflix", nil, http.StatusOK, &netflix)
+	h.doJSON("GET", "/api/catalog/nope", nil, http.StatusNotFound, nil)
+
+	// A catalog service fills in what the new subscription leaves out.
+	var s models.Subscription
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"catalogId": "netflix", "plan": "premium", "nextBilling": "2025-05-12"}, http.StatusCreated, &s)
+	premium, _ := netflix.Plan("Premium")
+	if s.Name != "Netflix" || s.Category != "Entertainment" || s.Cost != premium.Cost || s.Currency != premium.Currency || s.BillingCycle != "monthly" {
+		t.Errorf("from catalog: %+v", s)
+	}
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"catalogId": "spotify", "name": "Spotify for the kids", "cost": 5, "nextBilling": "2025-05-12"}, http.StatusCreated, &s)
+	if s.Name != "Spotify for the kids" || s.Cost != 500 || s.Currency != models.DefaultCurrency || s.Category != "Music" {
+		t.Errorf("given fields should win: %+v", s)
+	}
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"catalogId": "nope", "nextBilling": "2025-05-12"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/subscriptions", map[string]any{"catalogId": "netflix", "plan": "Platinum", "nextBilling": "2025-05-12"}, http.StatusBadRequest, nil)
+
+	// CATALOG_FILE adds services and corrects built-in ones on reload.
+	file := filepath.Join(t.TempDir(), "catalog.json")
+	os.WriteFile(file, []byte(`[
+		{"id": "netflix", "name": "Netflix", "category": "Streaming", "website": "https://www.netflix.com",
+		 "plans": [{"name": "Standard", "cost": 19.99, "currency": "usd", "billingCycle": "Monthly"}]},
+		{"id": "local-gym", "name": "Local Gym", "category": "Fitness", "website": "https://gym.example.com"}
+	]`), 0o644)
+	h.app.config.CatalogFile = file
+	admin := h.asAdmin()
+	var reloaded map[string]int
+	admin.doJSON("POST", "/api/admin/catalog/reload", nil, http.StatusOK, &reloaded)
+	h.doJSON("GET", "/api/catalog/netflix", nil, http.StatusOK, &netflix)
+	if netflix.Category != "Streaming" || len(netflix.Plans) != 1 || netflix.Plans[0].Currency != "USD" || netflix.Logo != "https://www.netflix.com/favicon.ico" {
+		t.Errorf("netflix after reload = %+v", netflix)
+	}
+	h.doJSON("GET", "/api/catalog/local-gym", nil, http.StatusOK, nil)
+	os.WriteFile(file, []byte(`[{"id": "Bad ID"}]`), 0o644)
+	admin.doJSON("POST", "/api/admin/catalog/reload", nil, http.StatusInternalServerError, nil)
+	var after map[string]int
+	os.WriteFile(file, []byte(`[]`), 0o644)
+	admin.doJSON("POST", "/api/admin/catalog/reload", nil, http.StatusOK, &after)
+	if after["services"] != reloaded["services"]-1 {
+		t.Errorf("services = %d after removing the file's addition, want %d", after["services"], reloaded["services"]-1)
+	}
+}
+
+// webhookReceiver is an endpoint that records what it's sent, answering
+// with the next of statuses (200 once they run out).
+type webhookReceiver struct {
+	server   *httptest.Server
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	rec := &webhookReceiver{statuses: statuses}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, string(body))
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func TestWebhooks(t *testing.T) {
+	h := newHarness(t)
+	rec := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": "ftp://example.com"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": rec.server.URL, "events": []string{"subscription.exploded"}}, http.StatusBadRequest, nil)
+	var hook models.Webhook
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": rec.server.URL}, http.StatusCreated, &hook)
+	if !strings.HasPrefix(hook.Secret, "whsec_") || len(hook.Events) != 5 {
+		t.Fatalf("created webhook %+v", hook)
+	}
+	var hooks []models.Webhook
+	h.doJSON("GET", "/api/webhooks", nil, http.StatusOK, &hooks)
+	if len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Errorf("webhook list = %+v, want one without its secret", hooks)
+	}
+
+	netflix := h.createSubscription(netflixFixture())
+	h.patchSubscription(netflix.ID, map[string]any{"cost": 17.99}, http.StatusOK, nil)
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, ""), nil, http.StatusNoContent, nil)
+
+	// The created and updated events fail at first and are retried a
+	// minute later; nothing is due before then.
+	for i, tick := range []time.Duration{0, 30 * time.Second, 30 * time.Second} {
+		h.clock.Advance(tick)
+		if _, err := h.app.deliverWebhooks(context.Background()); err != nil {
+			t.Fatalf("deliverWebhooks run %d: %v", i, err)
+		}
+	}
+	var events []string
+	for i, r := range rec.requests {
+		events = append(events, r.Header.Get("X-Webhook-Event"))
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		io.WriteString(mac, r.Header.Get("X-Webhook-Timestamp")+"."+rec.bodies[i])
+		if r.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("request %d has a bad signature", i)
+		}
+	}
+	want := "subscription.created,subscription.updated,subscription.deleted,subscription.created,subscription.updated"
+	if strings.Join(events, ",") != want {
+		t.Errorf("sent %v, want %s", events, want)
+	}
+	var payload struct {
+		Event string              `json:"event"`
+		Data  models.Subscription `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(rec.bodies[1]), &payload); err != nil || payload.Data.ID != netflix.ID || payload.Data.Cost != 1799 {
+		t.Errorf("updated payload = %s", rec.bodies[1])
+	}
+
+	var log models.Page[models.WebhookDelivery]
+	h.doJSON("GET", fmt.Sprintf("/api/webhooks/%d/deliveries", hook.ID), nil, http.StatusOK, &log)
+	if log.Total != 3 {
+		t.Fatalf("delivery log has %d entries, want 3", log.Total)
+	}
+	created := log.Items[2]
+	if created.Status != models.DeliveryDelivered || created.Attempts != 2 || created.Error != nil || created.DeliveredAt == nil {
+		t.Errorf("created delivery = %+v", created)
+	}
+	h.signup("other@example.com").doJSON("GET", fmt.Sprintf("/api/webhooks/%d/deliveries", hook.ID), nil, http.StatusNotFound, nil)
+
+	// Renewals three days out are announced once.
+	spotify := h.createSubscription(spotifyFixture())
+	if n, err := h.app.queueRenewalsUpcoming(context.Background()); err != nil || n != 1 {
+		t.Fatalf("queueRenewalsUpcoming = %d, %v; want 1", n, err)
+	}
+	if n, err := h.app.queueRenewalsUpcoming(context.Background()); err != nil || n != 0 {
+		t.Errorf("second queueRenewalsUpcoming = %d, %v; want 0", n, err)
+	}
+	rec.requests, rec.bodies = nil, nil
+	if n, err := h.app.deliverWebhooks(context.Background()); err != nil || n != 2 {
+		t.Fatalf("deliverWebhooks = %d, %v; want 2", n, err)
+	}
+	if got := rec.requests[1].Header.Get("X-Webhook-Event"); got != models.EventSubscriptionRenewalUpcoming {
+		t.Errorf("second event = %s, want renewal_upcoming", got)
+	}
+	if err := json.Unmarshal([]byte(rec.bodies[1]), &payload); err != nil || payload.Data.ID != spotify.ID {
+		t.Errorf("renewal payload = %s", rec.bodies[1])
+	}
+
+	h.doJSON("DELETE", fmt.Sprintf("/api/webhooks/%d", hook.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", fmt.Sprintf("/api/webhooks/%d", hook.ID), nil, http.StatusNotFound, nil)
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	h := newHarness(t)
+	statuses := make([]int, maxDeliveryAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	rec := newWebhookReceiver(t, statuses...)
+	var hook models.Webhook
+	h.doJSON("POST", "/api/webhooks", map[string]any{"url": rec.server.URL, "events": []string{"subscription.created"}}, http.StatusCreated, &hook)
+	h.createSubscription(netflixFixture())
+
+	for range 2 * maxDeliveryAttempts {
+		if _, err := h.app.deliverWebhooks(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		h.clock.Advance(time.Hour)
+	}
+	if len(rec.requests) != maxDeliveryAttempts {
+		t.Errorf("sent %d times, want %d", len(rec.requests), maxDeliveryAttempts)
+	}
+	var log models.Page[models.WebhookDelivery]
+	h.doJSON("GET", fmt.Sprintf("/api/webhooks/%d/deliveries", hook.ID), nil, http.StatusOK, &log)
+	d := log.Items[0]
+	if d.Status != models.DeliveryFailed || d.ResponseStatus == nil || *d.ResponseStatus != http.StatusServiceUnavailable || d.NextAttemptAt != nil {
+		t.Errorf("delivery = %+v", d)
+	}
+}
+
+func TestStalenessFollowsClock(t *testing.T) {
+	h := newHarness(t)
+	created := h.createSubscription(netflixFixture())
+
+	h.clock.Advance(7 * 30 * 24 * time.Hour)
+	var got models.Subscription
+	h.doJSON("GET", subscriptionPath(created.ID, ""), nil, http.StatusOK, &got)
+	if !got.Stale {
+		t.Error("subscription not stale after seven months")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	h := newHarness(t)
+
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 1
+
+	h.createSubscription(netflixFixture())
+	h.doJSON("POST", "/api/subscriptions", spotifyFixture(), http.StatusForbidden, nil)
+
+	var limits map[string]QuotaStatus
+	h.doJSON("GET", "/api/me/limits", nil, http.StatusOK, &limits)
+	if s := limits[QuotaSubscriptions]; s.Used != 1 || s.Limit == nil || *s.Limit != 1 {
+		t.Errorf("unexpected subscription quota: %+v", s)
+	}
+
+	// Limits apply per user.
+	h.signup("other@example.com").createSubscription(spotifyFixture())
+
+	// Creates racing for the last ones can't take more than there are.
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 3
+	statuses := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Go(func() {
+			s := spotifyFixture()
+			s.Name = fmt.Sprintf("Spotify %d", i)
+			resp, _ := h.do("POST", "/api/subscriptions?force=true", s)
+			statuses[i] = resp.StatusCode
+		})
+	}
+	wg.Wait()
+	created := 0
+	for _, status := range statuses {
+		if status == http.StatusCreated {
+			created++
+		}
+	}
+	h.doJSON("GET", "/api/me/limits", nil, http.StatusOK, &limits)
+	if created != 2 || limits[QuotaSubscriptions].Used != 3 {
+		t.Errorf("racing creates = %v, used %d, want 2 created and 3 used", statuses, limits[QuotaSubscriptions].Used)
+	}
+
+	// The store turns down a create past the limit by itself too.
+	var me models.User
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	ctx := store.WithUser(context.Background(), defaultTenant, me.ID)
+	if _, err := h.app.subscriptions.Create(ctx, me.ID, awsFixture(), h.clock.Now(), 3); !errors.Is(err, store.ErrLimitReached) {
+		t.Errorf("creating past the limit in the store: %v", err)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	h := newHarness(t)
+	h.app.config.QuotaLimits[QuotaSubscriptions] = 1
+	h.createSubscription(netflixFixture())
+
+	type problemBody struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Code     string `json:"code"`
+		Resource string `json:"resource"`
+		Limit    int64  `json:"limit"`
+	}
+	for _, c := range []struct {
+		method, path string
+		body         any
+		status       int
+		code         string
+	}{
+		{"GET", subscriptionPath(999999, ""), nil, http.StatusNotFound, codeNotFound},
+		{"GET", "/api/subscriptions/abc", nil, http.StatusBadRequest, codeBadRequest},
+		{"GET", "/api/no-such-route", nil, http.StatusNotFound, codeNotFound},
+		{"POST", "/api/subscriptions", "not an object", http.StatusBadRequest, codeInvalidJSON},
+		{"POST", "/api/subscriptions", spotifyFixture(), http.StatusForbidden, codeQuotaExceeded},
+	} {
+		resp, data := h.do(c.method, c.path, c.body)
+		if resp.StatusCode != c.status || resp.Header.Get("Content-Type") != problemContentType {
+			t.Errorf("%s %s: %d %s", c.method, c.path, resp.StatusCode, resp.Header.Get("Content-Type"))
+			continue
+		}
+		var p problemBody
+		if err := json.Unmarshal(data, &p); err != nil {
+			t.Fatalf("%s %s: %v\n%s", c.method, c.path, err, data)
+		}
+		if p.Code != c.code || p.Type != problemTypeBase+c.code || p.Status != c.status || p.Title != http.StatusText(c.status) {
+			t.Errorf("%s %s: unexpected problem %+v", c.method, c.path, p)
+		}
+		if c.code == codeQuotaExceeded && (p.Resource != QuotaSubscriptions || p.Limit != 1) {
+			t.Errorf("quota problem lacks its extension members: %s", data)
+		}
+	}
+
+	// Plain-text errors from elsewhere are rewritten.
+	handler := problemMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "teapot trouble", http.StatusTeapot)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/teapot", nil))
+	var p problemBody
+	json.Unmarshal(rec.Body.Bytes(), &p)
+	if rec.Code != http.StatusTeapot || rec.Header().Get("Content-Type") != problemContentType || p.Detail != "teapot trouble" {
+		t.Errorf("rewritten error: %d %s %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+}
+
+func TestTelemetryPreview(t *testing.T) {
+	h := newHarness(t)
+
+	var preview struct {
+		Enabled bool            `json:"enabled"`
+		Payload TelemetryReport `json:"payload"`
+	}
+	h.doJSON("GET", "/api/telemetry/preview", nil, http.StatusOK, &preview)
+	if preview.Payload.InstanceID == "" {
+		t.Error("preview has no instance ID")
+	}
+}
+
+func TestTransactionMatching(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+
+	summary := h.importTransactions(
+		bankTransaction("t1", "NETFLIX.COM 866-579-7172 CA", -1549, "2025-04-12"),
+		bankTransaction("t2", "GYM MEMBERSHIP", -40, "2025-04-02"),
+	)
+	if summary["imported"] != 2 || summary[models.MatchStatusMatched] != 1 {
+		t.Fatalf("unexpected import summary: %v", summary)
+	}
+
+	// Re-importing is idempotent.
+	summary = h.importTransactions(bankTransaction("t1", "NETFLIX.COM 866-579-7172 CA", -1549, "2025-04-12"))
+	if summary["skipped"] != 1 {
+		t.Errorf("re-import summary = %v, want one skipped", summary)
+	}
+
+	var matched []models.Transaction
+	h.doJSON("GET", "/api/transactions?status=matched", nil, http.StatusOK, &matched)
+	if len(matched) != 1 || matched[0].SubscriptionID == nil || *matched[0].SubscriptionID != netflix.ID {
+		t.Errorf("unexpected matched transactions: %+v", matched)
+	}
+
+	h.doJSON("POST", "/api/transactions/match", nil, http.StatusOK, nil)
+	h.doJSON("POST", "/api/transactions", []models.Transaction{{Source: "mystery"}}, http.StatusBadRequest, nil)
+
+	// Cancelled and archived subscriptions aren't candidates.
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/cancel"), nil, http.StatusOK, nil)
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/archive"), nil, http.StatusOK, nil)
+	summary = h.importTransactions(
+		bankTransaction("t3", "SPOTIFY P1234", -1099, "2025-05-03"),
+		bankTransaction("t4", "NETFLIX.COM 866-579-7172 CA", -1549, "2025-05-12"),
+	)
+	if summary[models.MatchStatusUnmatched] != 2 {
+		t.Errorf("import summary after cancelling and archiving = %v, want both unmatched", summary)
+	}
+}
+
+func TestMatchReviewQueue(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(models.Subscription{Name: "Disney Plus", Category: "Entertainment", Cost: 799, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20")})
+	h.createSubscription(models.Subscription{Name: "Disney Bundle", Category: "Entertainment", Cost: 1399, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20")})
+
+	h.importTransactions(bankTransaction("t1", "DISNEY", -1099, "2025-04-28"))
+
+	var queue []models.MatchCandidate
+	h.doJSON("GET", "/api/matches/review", nil, http.StatusOK, &queue)
+	if len(queue) < 2 {
+		t.Fatalf("got %d review candidates, want at least 2", len(queue))
+	}
+
+	h.doJSON("POST", "/api/matches/"+strconv.Itoa(queue[1].ID)+"/reject", nil, http.StatusNoContent, nil)
+	h.doJSON("POST", "/api/matches/"+strconv.Itoa(queue[0].ID)+"/accept", nil, http.StatusNoContent, nil)
+	h.doJSON("POST", "/api/matches/"+strconv.Itoa(queue[0].ID)+"/accept", nil, http.StatusNotFound, nil)
+
+	h.doJSON("GET", "/api/matches/review", nil, http.StatusOK, &queue)
+	if len(queue) != 0 {
+		t.Errorf("review queue not empty after decisions: %+v", queue)
+	}
+}
+
+func TestReconciliation(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	h.createSubscription(spotifyFixture())
+
+	h.importTransactions(
+		bankTransaction("t1", "NETFLIX.COM", -1599, "2025-05-12"),
+		bankTransaction("t2", "NETFLIX.COM", -1599, "2025-05-13"),
+	)
+
+	var report models.ReconciliationReport
+	h.doJSON("GET", "/api/reconciliation?month=2025-05", nil, http.StatusOK, &report)
+	if report.Summary[models.FlagMissedCharge] != 1 || report.Summary[models.FlagDoubleCharge] != 1 || report.Summary[models.FlagAmountMismatch] != 1 {
+		t.Errorf("unexpected reconciliation summary: %v", report.Summary)
+	}
+
+	h.doJSON("GET", "/api/reconciliation?month=May", nil, http.StatusBadRequest, nil)
+}
+
+func TestReconciliationBillablePeriod(t *testing.T) {
+	h := newHarness(t)
+	netflix := h.createSubscription(netflixFixture())
+	spotify := h.createSubscription(spotifyFixture())
+	aws := h.createSubscription(models.Subscription{Name: "AWS", Category: "Cloud", Cost: 1200, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-20")})
+	expected := func(month string) map[string][]string {
+		t.Helper()
+		var report models.ReconciliationReport
+		h.doJSON("GET", "/api/reconciliation?month="+month, nil, http.StatusOK, &report)
+		got := map[string][]string{}
+		for _, item := range report.Items {
+			got[item.Name] = item.ExpectedCharges
+		}
+		return got
+	}
+
+	// They were added on May 1, so April expects nothing.
+	if got := expected("2025-04"); len(got) != 0 {
+		t.Errorf("April expects %v", got)
+	}
+
+	// Spotify is cancelled from May 25: its May 3 charge was due, its
+	// June one isn't. Paused and archived subscriptions expect nothing.
+	h.doJSON("POST", subscriptionPath(spotify.ID, "/cancel"), map[string]any{"date": "2025-05-25"}, http.StatusOK, nil)
+	h.doJSON("POST", subscriptionPath(aws.ID, "/pause"), nil, http.StatusOK, nil)
+	if got := expected("2025-05"); len(got) != 2 || len(got["Spotify"]) != 1 || len(got["Netflix"]) != 1 {
+		t.Errorf("May expects %v", got)
+	}
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/archive"), nil, http.StatusOK, nil)
+	if got := expected("2025-06"); len(got) != 0 {
+		t.Errorf("June expects %v", got)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	h := newHarness(t)
+
+	h.importTransactions(
+		bankTransaction("t1", "ACME CLOUD BACKUP", -499, "2025-03-05"),
+		bankTransaction("t2", "ACME CLOUD BACKUP", -499, "2025-04-05"),
+	)
+
+	var alerts []models.Alert
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 1 || alerts[0].Kind != models.AlertUnknownRecurringCharge {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+
+	h.doJSON("POST", "/api/alerts/"+strconv.Itoa(alerts[0].ID)+"/dismiss", nil, http.StatusNoContent, nil)
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	if len(alerts) != 0 {
+		t.Errorf("dismissed alert still listed: %+v", alerts)
+	}
+	h.doJSON("POST", "/api/alerts/999/dismiss", nil, http.StatusNotFound, nil)
+}
+
+func TestBankStatements(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+
+	ofx := "OFXHEADER:100\nDATA:OFXSGML\n\n<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><BANKACCTFROM><ACCTID>1234</BANKACCTFROM>\n" +
+		"<BANKTRANLIST>\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250305120000[-5:EST]<TRNAMT>-4.99<FITID>a1<NAME>ACME CLOUD BACKUP\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250405<TRNAMT>-4.99<FITID>a2<NAME>ACME CLOUD BACKUP\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250412<TRNAMT>-15.49<FITID>a3<NAME>NETFLIX.COM\n" +
+		"<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20250415<TRNAMT>2500.00<FITID>a4<NAME>PAYROLL\n" +
+		"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>soon<TRNAMT>-1<FITID>a5<NAME>BROKEN\n" +
+		"</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>\n"
+	var report statementReport
+	upload := func(path, name, content string) {
+		t.Helper()
+		resp, data := h.upload(path, name, content)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload %s: got %d: %s", name, resp.StatusCode, data)
+		}
+		report = statementReport{}
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload("/api/transactions/statement", "statement.ofx", ofx)
+	if report.Format != "ofx" || report.Transactions["imported"] != 4 || report.Transactions[models.MatchStatusMatched] != 1 {
+		t.Errorf("OFX report = %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Line != 5 {
+		t.Errorf("OFX errors = %+v, want the fifth transaction", report.Errors)
+	}
+	upload("/api/transactions/statement", "statement.ofx", ofx)
+	if report.Transactions["imported"] != 0 || report.Transactions["skipped"] != 4 {
+		t.Errorf("OFX again = %+v, want all skipped", report)
+	}
+
+	// A CSV statement with debit and credit columns and day-first dates,
+	// whose rows have no IDs.
+	statement := "Date,Payee,Debit,Credit\n" +
+		"15/03/2025,DROPBOX*PLUS,11.99,\n" +
+		"15/04/2025,DROPBOX*PLUS,\"11.99\",\n" +
+		"16/04/2025,Refund,,3.00\n" +
+		"someday,DROPBOX*PLUS,11.99,\n"
+	upload("/api/transactions/statement?dayFirst=true", "statement.csv", statement)
+	if report.Format != "csv" || report.Transactions["imported"] != 3 || len(report.Errors) != 1 || report.Errors[0].Line != 5 {
+		t.Errorf("CSV report = %+v", report)
+	}
+	upload("/api/transactions/statement?dayFirst=true", "statement.csv", statement)
+	if report.Transactions["skipped"] != 3 {
+		t.Errorf("CSV again = %+v, want all skipped", report)
+	}
+	var transactions []models.Transaction
+	h.doJSON("GET", "/api/transactions", nil, http.StatusOK, &transactions)
+	if len(transactions) != 7 || transactions[0].Date != "2025-04-16" || transactions[0].Amount != 300 || transactions[1].Amount != -1199 {
+		t.Errorf("transactions = %+v", transactions)
+	}
+	if resp, _ := h.upload("/api/transactions/statement", "statement.csv", "Date,Payee\n2025-01-01,X\n"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("CSV without amounts: got %d, want 400", resp.StatusCode)
+	}
+
+	// Both runs of charges recur monthly and neither is tracked.
+	var recurring []recurringCharge
+	h.doJSON("GET", "/api/transactions/recurring", nil, http.StatusOK, &recurring)
+	if len(recurring) != 2 {
+		t.Fatalf("recurring = %+v, want two", recurring)
+	}
+	acme := recurring[1]
+	draft := acme.Suggestion.Subscription
+	if acme.Merchant != "acme cloud backup" || acme.BillingCycle != "monthly" || acme.Charges != 2 || acme.Suggestion.Action != "create" || draft == nil {
+		t.Fatalf("ACME = %+v", acme)
+	}
+	if draft.Name != "Acme Cloud Backup" || draft.Cost != 499 || draft.NextBilling != "2025-05-05" || draft.Currency != models.DefaultCurrency {
+		t.Errorf("ACME draft = %+v", draft)
+	}
+
+	// Once the subscription exists, the suggestion is to match it.
+	sub := h.createSubscription(models.Subscription{Name: draft.Name, Category: draft.Category, Cost: draft.Cost, BillingCycle: draft.BillingCycle, NextBilling: models.MustParseDate(draft.NextBilling)})
+	h.doJSON("GET", "/api/transactions/recurring", nil, http.StatusOK, &recurring)
+	if s := recurring[1].Suggestion; s.Action != "match" || s.SubscriptionID == nil || *s.SubscriptionID != sub.ID {
+		t.Errorf("suggestion after creating the subscription = %+v", s)
+	}
+
+	h.doJSON("POST", "/api/transactions/link", map[string]any{"transactionIds": []int{}}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/transactions/link", map[string]any{"transactionIds": acme.TransactionIDs, "subscriptionId": 9999}, http.StatusNotFound, nil)
+	h.doJSON("POST", "/api/transactions/link", map[string]any{"transactionIds": []int{9999}, "subscriptionId": sub.ID}, http.StatusNotFound, nil)
+	var linked []models.Transaction
+	h.doJSON("POST", "/api/transactions/link", map[string]any{"transactionIds": acme.TransactionIDs, "subscriptionId": sub.ID}, http.StatusOK, &linked)
+	if len(linked) != 2 || linked[0].MatchStatus != models.MatchStatusMatched || *linked[1].SubscriptionID != sub.ID {
+		t.Errorf("linked = %+v", linked)
+	}
+	h.doJSON("GET", "/api/transactions/recurring", nil, http.StatusOK, &recurring)
+	if len(recurring) != 1 || recurring[0].Merchant != "dropbox plus" {
+		t.Errorf("recurring after linking = %+v", recurring)
+	}
+	var alerts []models.Alert
+	h.doJSON("GET", "/api/alerts", nil, http.StatusOK, &alerts)
+	for _, a := range alerts {
+		if a.TransactionID != nil && slices.Contains(acme.TransactionIDs, *a.TransactionID) {
+			t.Errorf("alert for a linked charge still listed: %+v", a)
+		}
+	}
+}
+
+func TestHouseholds(t *testing.T) {
+	h := newHarness(t)
+	mailer := &recordingMailer{}
+	h.app.mailer = mailer
+	netflix := h.createSubscription(netflixFixture())
+
+	var home models.Household
+	h.doJSON("POST", "/api/households", map[string]any{"name": " "}, http.StatusBadRequest, nil)
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Home"}, http.StatusCreated, &home)
+	if home.Name != "Home" || len(home.Members) != 1 || home.Members[0].Email != testEmail || home.Members[0].Role != models.RoleOwner {
+		t.Fatalf("created household = %+v", home)
+	}
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Second"}, http.StatusConflict, nil)
+	path := fmt.Sprintf("/api/households/%d", home.ID)
+
+	h.doJSON("POST", path+"/invites", map[string]any{"email": "not an email"}, http.StatusBadRequest, nil)
+	h.doJSON("POST", path+"/invites", map[string]any{"email": testEmail}, http.StatusConflict, nil)
+	var invite models.HouseholdInvite
+	h.doJSON("POST", path+"/invites", map[string]any{"email": "Partner@Example.com"}, http.StatusCreated, &invite)
+	if invite.Email != "partner@example.com" || invite.Household != "Home" || invite.InvitedBy == nil || *invite.InvitedBy != testEmail {
+		t.Errorf("invite = %+v", invite)
+	}
+	h.doJSON("POST", path+"/invites", map[string]any{"email": "partner@example.com"}, http.StatusConflict, nil)
+	if len(mailer.sent) != 1 || mailer.sent[0].To[0] != "partner@example.com" || mailer.sent[0].Subject != "You're invited to join Home" {
+		t.Errorf("sent %+v", mailer.sent)
+	}
+
+	// The invite waits for the account signed up with its email.
+	partner := h.signup("partner@example.com")
+	outsider := h.signup("outsider@example.com")
+	var invites []models.HouseholdInvite
+	partner.doJSON("GET", "/api/me/invites", nil, http.StatusOK, &invites)
+	if len(invites) != 1 || invites[0].ID != invite.ID {
+		t.Fatalf("partner's invites = %+v", invites)
+	}
+	outsider.doJSON("GET", "/api/me/invites", nil, http.StatusOK, &invites)
+	if len(invites) != 0 {
+		t.Errorf("outsider's invites = %+v", invites)
+	}
+	invitePath := fmt.Sprintf("/api/me/invites/%d", invite.ID)
+	outsider.doJSON("POST", invitePath+"/accept", nil, http.StatusNotFound, nil)
+	partner.doJSON("GET", path, nil, http.StatusNotFound, nil)
+	partner.doJSON("POST", invitePath+"/accept", nil, http.StatusOK, &home)
+	if len(home.Members) != 2 || home.Members[1].Email != "partner@example.com" || home.Members[1].Role != models.RoleMember {
+		t.Fatalf("household after accepting = %+v", home)
+	}
+	partner.doJSON("POST", invitePath+"/accept", nil, http.StatusNotFound, nil)
+	owner, member := home.Members[0].UserID, home.Members[1].UserID
+
+	// Non-members can't see the household at all; members can't manage it.
+	for _, p := range []string{path, path + "/invites", path + "/subscriptions", path + "/stats"} {
+		outsider.doJSON("GET", p, nil, http.StatusNotFound, nil)
+	}
+	outsider.doJSON("PUT", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusNotFound, nil)
+	partner.doJSON("POST", path+"/invites", map[string]any{"email": "outsider@example.com"}, http.StatusForbidden, nil)
+	partner.doJSON("DELETE", path, nil, http.StatusForbidden, nil)
+	partner.doJSON("DELETE", fmt.Sprintf("%s/members/%d", path, owner), nil, http.StatusForbidden, nil)
+	h.doJSON("DELETE", fmt.Sprintf("%s/members/%d", path, owner), nil, http.StatusConflict, nil)
+
+	var shared models.SharedSubscription
+	h.doJSON("PUT", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusOK, &shared)
+	if !shared.EqualSplit || shared.OwnerID != owner || len(shared.Splits) != 2 || shared.Splits[0].Percent != 50 {
+		t.Errorf("shared netflix = %+v", shared)
+	}
+	partner.doJSON("PUT", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusNotFound, nil)
+	spotify := partner.createSubscription(spotifyFixture())
+	spotifyPath := path + "/subscriptions/" + strconv.Itoa(spotify.ID)
+	for _, splits := range [][]models.CostSplit{
+		{{UserID: owner, Percent: 25}, {UserID: member, Percent: 65}},
+		{{UserID: owner, Percent: 25}, {UserID: owner, Percent: 75}},
+		{{UserID: owner, Percent: 0}, {UserID: member, Percent: 100}},
+		{{UserID: owner, Percent: 25.001}, {UserID: member, Percent: 74.999}},
+		{{UserID: member + 1, Percent: 100}},
+	} {
+		partner.doJSON("PUT", spotifyPath, map[string]any{"splits": splits}, http.StatusBadRequest, nil)
+	}
+	partner.doJSON("PUT", spotifyPath, map[string]any{"splits": []models.CostSplit{{UserID: owner, Percent: 25}, {UserID: member, Percent: 75}}}, http.StatusOK, &shared)
+	if shared.EqualSplit || len(shared.Splits) != 2 || shared.Splits[1].Percent != 75 {
+		t.Errorf("shared spotify = %+v", shared)
+	}
+
+	var subs []models.SharedSubscription
+	partner.doJSON("GET", path+"/subscriptions", nil, http.StatusOK, &subs)
+	if len(subs) != 2 || subs[0].Name != "Netflix" || subs[1].Name != "Spotify" {
+		t.Fatalf("shared subscriptions = %+v", subs)
+	}
+
+	// Netflix is 185.88 a year split in half, Spotify 131.88 split 25/75.
+	var stats householdStats
+	h.doJSON("GET", path+"/stats", nil, http.StatusOK, &stats)
+	if stats.Currency != "USD" || stats.TotalYearly != 31776 || stats.TotalMonthly != 2648 || stats.MyShareYearly != 12591 || stats.MyShareMonthly != 1049 {
+		t.Errorf("owner's stats = %+v", stats)
+	}
+	partner.doJSON("GET", path+"/stats", nil, http.StatusOK, &stats)
+	if stats.MyShareYearly != 19185 || len(stats.ByMember) != 2 || stats.ByMember[0].Yearly != 12591 {
+		t.Errorf("partner's stats = %+v", stats)
+	}
+
+	// Paused subscriptions aren't counted.
+	h.doJSON("POST", subscriptionPath(netflix.ID, "/pause"), nil, http.StatusOK, nil)
+	h.doJSON("GET", path+"/stats", nil, http.StatusOK, &stats)
+	if stats.TotalYearly != 13188 || stats.MyShareYearly != 3297 {
+		t.Errorf("stats with netflix paused = %+v", stats)
+	}
+
+	partner.doJSON("DELETE", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusForbidden, nil)
+
+	// Leaving unshares the member's subscriptions.
+	partner.doJSON("DELETE", fmt.Sprintf("%s/members/%d", path, member), nil, http.StatusNoContent, nil)
+	partner.doJSON("GET", path, nil, http.StatusNotFound, nil)
+	h.doJSON("GET", path+"/subscriptions", nil, http.StatusOK, &subs)
+	if len(subs) != 1 || subs[0].ID != netflix.ID || !subs[0].EqualSplit || len(subs[0].Splits) != 1 || subs[0].Splits[0].Percent != 100 {
+		t.Errorf("shared subscriptions after leaving = %+v", subs)
+	}
+
+	h.doJSON("DELETE", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusNoContent, nil)
+	h.doJSON("DELETE", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusNotFound, nil)
+
+	h.doJSON("POST", path+"/invites", map[string]any{"email": "outsider@example.com"}, http.StatusCreated, &invite)
+	h.doJSON("DELETE", fmt.Sprintf("%s/invites/%d", path, invite.ID), nil, http.StatusNoContent, nil)
+	outsider.doJSON("GET", "/api/me/invites", nil, http.StatusOK, &invites)
+	if len(invites) != 0 {
+		t.Errorf("withdrawn invite still listed: %+v", invites)
+	}
+
+	// A declined invite is gone for both sides.
+	h.doJSON("POST", path+"/invites", map[string]any{"email": "outsider@example.com"}, http.StatusCreated, &invite)
+	declinePath := fmt.Sprintf("/api/me/invites/%d/decline", invite.ID)
+	partner.doJSON("POST", declinePath, nil, http.StatusNotFound, nil)
+	outsider.doJSON("POST", declinePath, nil, http.StatusNoContent, nil)
+	outsider.doJSON("POST", declinePath, nil, http.StatusNotFound, nil)
+	var pending []models.HouseholdInvite
+	h.doJSON("GET", path+"/invites", nil, http.StatusOK, &pending)
+	if len(pending) != 0 {
+		t.Errorf("declined invite still pending: %+v", pending)
+	}
+
+	h.doJSON("DELETE", path, nil, http.StatusNoContent, nil)
+	h.doJSON("GET", path, nil, http.StatusNotFound, nil)
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Again"}, http.StatusCreated, nil)
+}
+
+func TestTimeTravel(t *testing.T) {
+	h := newHarness(t)
+	h.asAdmin().doJSON("GET", "/api/admin/clock", nil, http.StatusNotFound, nil)
+
+	// Only a dev-mode app, running on a travelling clock, mounts it.
+	dev := *h
+	app, err := New(testConfig(), testDB, Services{Clock: &clock.Travel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.app = app
+	dev.server = httptest.NewServer(dev.app.Router())
+	t.Cleanup(dev.server.Close)
+	admin := dev.asAdmin()
+	dev.doJSON("GET", "/api/admin/clock", nil, http.StatusForbidden, nil)
+
+	var got struct {
+		Now time.Time `json:"now"`
+	}
+	admin.doJSON("PUT", "/api/admin/clock", map[string]any{"now": "2030-01-02"}, http.StatusOK, &got)
+	if got.Now.Format(time.DateOnly) != "2030-01-02" {
+		t.Errorf("clock after travelling to a date = %v", got.Now)
+	}
+	admin.doJSON("PUT", "/api/admin/clock", map[string]any{"now": "2031-06-01T08:00:00Z"}, http.StatusOK, &got)
+	admin.doJSON("GET", "/api/admin/clock", nil, http.StatusOK, &got)
+	if got.Now.Before(time.Date(2031, 6, 1, 8, 0, 0, 0, time.UTC)) || got.Now.After(time.Date(2031, 6, 1, 8, 1, 0, 0, time.UTC)) {
+		t.Errorf("clock after travelling to an instant = %v", got.Now)
+	}
+	admin.doJSON("PUT", "/api/admin/clock", map[string]any{"now": "next week"}, http.StatusBadRequest, nil)
+	admin.doJSON("PUT", "/api/admin/clock", "{", http.StatusBadRequest, nil)
+
+	admin.doJSON("DELETE", "/api/admin/clock", nil, http.StatusOK, &got)
+	if d := time.Since(got.Now); d < -time.Minute || d > time.Minute {
+		t.Errorf("clock after a reset = %v, want about now", got.Now)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	h := newHarness(t)
+	type readiness struct {
+		Status string                `json:"status"`
+		Checks map[string]ProbeCheck `json:"checks"`
+	}
+	var live map[string]string
+	h.anonymous().doJSON("GET", "/livez", nil, http.StatusOK, &live)
+	if live["status"] != "ok" {
+		t.Errorf("livez = %v", live)
+	}
+	var ready readiness
+	h.anonymous().doJSON("GET", "/readyz", nil, http.StatusOK, &ready)
+	if ready.Status != "ok" || ready.Checks["database"].Status != "ok" || ready.Checks["migrations"].Version != store.SchemaVersion {
+		t.Errorf("readyz = %+v", ready)
+	}
+
+	// A job that stops makes the instance unready, as happens on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	h.app.config.TrialInterval = time.Hour
+	h.app.StartTrials(ctx)
+	h.anonymous().doJSON("GET", "/readyz", nil, http.StatusOK, &ready)
+	if jobs := ready.Checks["scheduler"].Jobs; len(jobs) != 1 || jobs[0].Name != "trials" || jobs[0].Status != "ok" {
+		t.Errorf("jobs = %+v", jobs)
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, body := h.anonymous().do("GET", "/readyz", nil)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			json.Unmarshal(body, &ready)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz still %d after the job stopped", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ready.Status != "fail" || ready.Checks["scheduler"].Status != "fail" || ready.Checks["database"].Status != "ok" {
+		t.Errorf("readyz after the job stopped = %+v", ready)
+	}
+
+	// Probes keep answering during maintenance.
+	h.asAdmin().doJSON("PUT", "/api/admin/maintenance", MaintenanceState{Enabled: true}, http.StatusOK, nil)
+	h.anonymous().doJSON("GET", "/livez", nil, http.StatusOK, nil)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	h := newHarness(t)
+	admin := h.asAdmin()
+
+	h.doJSON("PUT", "/api/admin/maintenance", MaintenanceState{Enabled: true}, http.StatusForbidden, nil)
+	admin.doJSON("PUT", "/api/admin/maintenance", MaintenanceState{Enabled: true}, http.StatusOK, nil)
+
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusServiceUnavailable, nil)
+	h.doJSON("GET", "/api/health", nil, http.StatusOK, nil)
+
+	var state MaintenanceState
+	admin.doJSON("GET", "/api/admin/maintenance", nil, http.StatusOK, &state)
+	if !state.Enabled || state.Since == "" {
+		t.Errorf("unexpected maintenance state: %+v", state)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	h := newHarness(t)
+	admin := h.asAdmin()
+
+	admin.doJSON("PUT", "/api/admin/read-only", ReadOnlyState{Enabled: true, Reason: "failover"}, http.StatusOK, nil)
+	h.doJSON("POST", "/api/subscriptions", netflixFixture(), http.StatusServiceUnavailable, nil)
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, nil)
+
+	admin.doJSON("PUT", "/api/admin/read-only", ReadOnlyState{}, http.StatusOK, nil)
+	h.createSubscription(netflixFixture())
+}
+
+func TestDBPool(t *testing.T) {
+	h := newHarness(t)
+	h.doJSON("GET", "/api/admin/db-pool", nil, http.StatusForbidden, nil)
+
+	var pool PoolStats
+	h.asAdmin().doJSON("GET", "/api/admin/db-pool", nil, http.StatusOK, &pool)
+	if pool.MaxOpen != testDB.Stats().MaxOpenConnections || pool.InUse > pool.Open || pool.Saturated {
+		t.Errorf("pool = %+v", pool)
+	}
+
+	// A transaction held open takes the connection.
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if got := poolStats(testDB.Stats()); got.InUse < 1 || got.Saturated != (got.MaxOpen == got.InUse) {
+		t.Errorf("pool with a transaction = %+v", got)
+	}
+
+	// SQLite keeps its single connection whatever the pool asks for.
+	db, err := store.Open(store.SQLite, filepath.Join(t.TempDir(), "pool.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store.Pool{MaxOpenConns: 10, MaxIdleConns: 5}.Configure(db)
+	if got := db.Stats().MaxOpenConnections; got != 1 {
+		t.Errorf("SQLite pool opens %d connections, want 1", got)
+	}
+}
+
+func TestDBConnect(t *testing.T) {
+	// A database that isn't listening is retried until the context ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 1200*time.Millisecond)
+	defer cancel()
+	_, err := store.Connect(ctx, store.Postgres, "postgres://subs@127.0.0.1:1/subs?sslmode=disable")
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 attempts") {
+		t.Errorf("connecting to a closed port: err = %v, want it to give up after 2 attempts", err)
+	}
+
+	// Anything else fails at once.
+	start := time.Now()
+	_, err = store.Connect(context.Background(), store.SQLite, filepath.Join(t.TempDir(), "missing", "subs.db"))
+	if err == nil || strings.Contains(err.Error(), "giving up") || time.Since(start) > time.Second {
+		t.Errorf("opening a file in a missing directory: err = %v after %v, want an error at once", err, time.Since(start))
+	}
+
+	db, err := store.Connect(context.Background(), store.SQLite, filepath.Join(t.TempDir(), "subs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestAdminUsers(t *testing.T) {
+	h := newHarness(t)
+	h.createSubscription(netflixFixture())
+	other := h.signup("other@example.com")
+	admin := h.asAdmin()
+
+	var users models.Page[AdminUser]
+	h.doJSON("GET", "/api/admin/users", nil, http.StatusForbidden, nil)
+	admin.doJSON("GET", "/api/admin/users", nil, http.StatusOK, &users)
+	if users.Total != 2 || len(users.Items) != 2 {
+		t.Fatalf("users = %+v", users)
+	}
+	if u := users.Items[0]; u.Email != testEmail || u.Subscriptions != 1 || u.ActiveSubscriptions != 1 || u.Sessions != 1 || u.Admin {
+		t.Errorf("first user = %+v", u)
+	}
+
+	// Admin users get in with their own token once they're granted the
+	// role.
+	h.doJSON("PUT", "/api/admin/users/1/admin", map[string]any{"admin": true}, http.StatusForbidden, nil)
+	admin.doJSON("PUT", "/api/admin/users/1/admin", map[string]any{}, http.StatusBadRequest, nil)
+	admin.doJSON("PUT", "/api/admin/users/999/admin", map[string]any{"admin": true}, http.StatusNotFound, nil)
+	admin.doJSON("PUT", "/api/admin/users/1/admin", map[string]any{"admin": true}, http.StatusOK, nil)
+	h.doJSON("GET", "/api/admin/users?email=OTHER", nil, http.StatusOK, &users)
+	if users.Total != 1 || users.Items[0].Email != "other@example.com" {
+		t.Errorf("users matching other = %+v", users)
+	}
+	other.doJSON("GET", "/api/admin/users", nil, http.StatusForbidden, nil)
+	var u AdminUser
+	h.doJSON("GET", "/api/admin/users/1", nil, http.StatusOK, &u)
+	if u.Email != testEmail || !u.Admin {
+		t.Errorf("user 1 = %+v", u)
+	}
+	h.doJSON("GET", "/api/admin/users/999", nil, http.StatusNotFound, nil)
+	h.doJSON("GET", "/api/admin/users?limit=0", nil, http.StatusBadRequest, nil)
+
+	var d Dashboard
+	h.doJSON("GET", "/api/admin/dashboard", nil, http.StatusOK, &d)
+	if d.Users != 2 || d.Subscriptions != 1 || d.ActiveSubscriptions != 1 || d.Jobs == nil {
+		t.Errorf("dashboard = %+v", d)
+	}
+	if db := d.Database; db.Driver != string(testDriver) || db.SchemaVersion != store.SchemaVersion || db.SizeBytes <= 0 {
+		t.Errorf("dashboard database = %+v", db)
+	}
+
+	// Impersonating acts as the user, without a session to refresh.
+	var imp impersonation
+	h.doJSON("POST", fmt.Sprintf("/api/admin/users/%d/impersonate", users.Items[0].ID), nil, http.StatusOK, &imp)
+	if imp.Token == "" || imp.User.Email != "other@example.com" {
+		t.Fatalf("impersonation = %+v", imp)
+	}
+	as := *h
+	as.token = imp.Token
+	var me models.User
+	as.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	if me.Email != "other@example.com" {
+		t.Errorf("impersonated /api/me = %+v", me)
+	}
+	var sessions []models.Session
+	as.doJSON("GET", "/api/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 1 || sessions[0].Current {
+		t.Errorf("impersonated sessions = %+v", sessions)
+	}
+	admin.doJSON("POST", "/api/admin/users/999/impersonate", nil, http.StatusNotFound, nil)
+
+	// Impersonating and granting the role are in the admin audit log,
+	// with who did them.
+	var audit models.Page[AdminAuditEntry]
+	h.doJSON("GET", "/api/admin/audit", nil, http.StatusOK, &audit)
+	if audit.Total != 2 || audit.Items[0].Action != adminImpersonate || audit.Items[0].Email != "other@example.com" || audit.Items[0].Actor == nil || *audit.Items[0].Actor != testEmail ||
+		audit.Items[1].Action != adminGrant || audit.Items[1].UserID != 1 || audit.Items[1].ActorID != nil {
+		t.Errorf("admin audit = %+v", audit.Items)
+	}
+	h.doJSON("GET", fmt.Sprintf("/api/admin/audit?userId=%d", imp.User.ID), nil, http.StatusOK, &audit)
+	if audit.Total != 1 || audit.Items[0].Action != adminImpersonate {
+		t.Errorf("admin audit of the impersonated user = %+v", audit.Items)
+	}
+	other.doJSON("GET", "/api/admin/audit", nil, http.StatusForbidden, nil)
+
+	// Revoking the role shuts the account out again.
+	admin.doJSON("PUT", "/api/admin/users/1/admin", map[string]any{"admin": false}, http.StatusOK, &u)
+	if u.Admin {
+		t.Errorf("after revoking, user 1 = %+v", u)
+	}
+	h.doJSON("GET", "/api/admin/users", nil, http.StatusForbidden, nil)
+}
+
+func TestTenants(t *testing.T) {
+	h := newHarness(t)
+	admin := h.asAdmin()
+
+	var acme Tenant
+	h.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": "acme", "name": "Acme"}, http.StatusForbidden, nil)
+	admin.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": " Acme ", "name": "Acme"}, http.StatusCreated, &acme)
+	if acme.Slug != "acme" || acme.Name != "Acme" || acme.ID == defaultTenant {
+		t.Errorf("created = %+v", acme)
+	}
+	admin.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": "acme", "name": "Other"}, http.StatusConflict, nil)
+	admin.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": "default", "name": "Other"}, http.StatusConflict, nil)
+	admin.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": "no spaces", "name": ""}, http.StatusBadRequest, nil)
+
+	// Accounts sign up and log in within their tenant.
+	inAcme := h.anonymous()
+	inAcme.tenant = "acme"
+	alice := inAcme.signup("alice@example.com")
+	alice.createSubscription(netflixFixture())
+	inAcme.doJSON("POST", "/api/auth/login", credentials{Email: "alice@example.com", Password: testPassword}, http.StatusOK, nil)
+	inAcme.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusUnauthorized, nil)
+	h.anonymous().doJSON("POST", "/api/auth/login", credentials{Email: "alice@example.com", Password: testPassword}, http.StatusUnauthorized, nil)
+	inAcme.doJSON("POST", "/api/auth/signup", credentials{Email: "alice@example.com", Password: testPassword}, http.StatusConflict, nil)
+	unknown := h.anonymous()
+	unknown.tenant = "nope"
+	unknown.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusNotFound, nil)
+
+	// Tokens only work in the tenant they were issued in.
+	var list models.Page[models.Subscription]
+	h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 0 {
+		t.Errorf("default tenant sees %d subscriptions, want 0", list.Total)
+	}
+	alice.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 1 {
+		t.Errorf("alice sees %d subscriptions, want 1", list.Total)
+	}
+	outside := *alice
+	outside.tenant = ""
+	outside.doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+	elsewhere := *h
+	elsewhere.tenant = "acme"
+	elsewhere.doJSON("GET", "/api/me", nil, http.StatusUnauthorized, nil)
+
+	// With TENANT_DOMAIN set, the subdomain names the tenant.
+	h.app.config.TenantDomain = "example.com"
+	req, err := http.NewRequest("GET", h.server.URL+"/api/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "acme.example.com"
+	if resp, body := outside.send(req); resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "alice@example.com") {
+		t.Errorf("on acme.example.com: %d %s", resp.StatusCode, body)
+	}
+	req.Host = "example.com"
+	if resp, _ := outside.send(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("on example.com: %d, want 401", resp.StatusCode)
+	}
+
+	// An email can have an account in each tenant, each with its own data
+	// and password, and only the default tenant's can have the admin role.
+	h.asAdmin().doJSON("PUT", "/api/admin/users/1/admin", map[string]any{"admin": true}, http.StatusOK, nil)
+	var signedUp struct {
+		Token string `json:"token"`
+	}
+	inAcme.doJSON("POST", "/api/auth/signup", credentials{Email: testEmail, Password: "another password"}, http.StatusCreated, &signedUp)
+	acmeTest := *inAcme
+	acmeTest.token = signedUp.Token
+	acmeTest.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &list)
+	if list.Total != 0 {
+		t.Errorf("%s in acme sees %d subscriptions, want 0", testEmail, list.Total)
+	}
+	inAcme.doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusUnauthorized, nil)
+	h.anonymous().doJSON("POST", "/api/auth/login", credentials{Email: testEmail, Password: testPassword}, http.StatusOK, nil)
+	acmeTest.doJSON("GET", "/api/admin/dashboard", nil, http.StatusForbidden, nil)
+	h.doJSON("GET", "/api/admin/dashboard", nil, http.StatusOK, nil)
+	var acmeMe models.User
+	acmeTest.doJSON("GET", "/api/me", nil, http.StatusOK, &acmeMe)
+	h.asAdmin().doJSON("PUT", fmt.Sprintf("/api/admin/users/%d/admin", acmeMe.ID), map[string]any{"admin": true}, http.StatusConflict, nil)
+	h.asAdmin().doJSON("PUT", "/api/admin/users/1/admin", map[string]any{"admin": false}, http.StatusOK, nil)
+
+	// Household invites don't cross tenants, even to an email with an
+	// account in both.
+	aliceHome := h.signup("alice@example.com")
+	var home models.Household
+	h.doJSON("POST", "/api/households", map[string]any{"name": "Home"}, http.StatusCreated, &home)
+	var invite models.HouseholdInvite
+	h.doJSON("POST", fmt.Sprintf("/api/households/%d/invites", home.ID), map[string]any{"email": "alice@example.com"}, http.StatusCreated, &invite)
+	var invites []models.HouseholdInvite
+	alice.doJSON("GET", "/api/me/invites", nil, http.StatusOK, &invites)
+	if len(invites) != 0 {
+		t.Errorf("alice's invites = %+v, want none", invites)
+	}
+	alice.doJSON("POST", fmt.Sprintf("/api/me/invites/%d/accept", invite.ID), nil, http.StatusNotFound, nil)
+	aliceHome.doJSON("GET", "/api/me/invites", nil, http.StatusOK, &invites)
+	if len(invites) != 1 || invites[0].ID != invite.ID {
+		t.Errorf("alice's invites in the default tenant = %+v", invites)
+	}
+
+	var tenants []Tenant
+	admin.doJSON("GET", "/api/admin/tenants", nil, http.StatusOK, &tenants)
+	if len(tenants) != 2 || tenants[0].Slug != defaultTenantSlug || tenants[0].Users != 2 || tenants[1].Slug != "acme" || tenants[1].Users != 2 {
+		t.Errorf("tenants = %+v", tenants)
+	}
+	var users models.Page[AdminUser]
+	admin.doJSON("GET", "/api/admin/users", nil, http.StatusOK, &users)
+	if len(users.Items) != 4 || users.Items[0].Tenant != defaultTenantSlug || users.Items[1].Tenant != "acme" || users.Items[2].Tenant != "acme" || users.Items[3].Tenant != defaultTenantSlug {
+		t.Errorf("users = %+v", users.Items)
+	}
+
+	// Only a tenant without accounts can be deleted, and never the default.
+	admin.doJSON("DELETE", fmt.Sprintf("/api/admin/tenants/%d", defaultTenant), nil, http.StatusConflict, nil)
+	admin.doJSON("DELETE", fmt.Sprintf("/api/admin/tenants/%d", acme.ID), nil, http.StatusConflict, nil)
+	var empty Tenant
+	admin.doJSON("POST", "/api/admin/tenants", map[string]any{"slug": "empty", "name": "Empty"}, http.StatusCreated, &empty)
+	admin.doJSON("DELETE", fmt.Sprintf("/api/admin/tenants/%d", empty.ID), nil, http.StatusNoContent, nil)
+	admin.doJSON("DELETE", fmt.Sprintf("/api/admin/tenants/%d", empty.ID), nil, http.StatusNotFound, nil)
+}
+
+func TestRowLevelSecurity(t *testing.T) {
+	if testDriver != store.Postgres {
+		t.Skip("row-level security needs Postgres")
+	}
+	h := newHarness(t)
+
+	// The test database is used as a superuser, which skips the policies,
+	// so run an App as a role that doesn't, with a system role for what
+	// needs every row. The superuser owns the tables, so it grants the
+	// system role its privileges here.
+	ctx := context.Background()
+	for _, stmt := range []string{
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'rls_app') THEN CREATE ROLE rls_app LOGIN PASSWORD 'rls_app'; END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'rls_system') THEN CREATE ROLE rls_system NOLOGIN BYPASSRLS; END IF;
+		END $$`,
+		"GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO rls_app, rls_system",
+		"GRANT USAGE, SELECT, UPDATE ON ALL SEQUENCES IN SCHEMA public TO rls_app, rls_system",
+		"GRANT rls_system TO rls_app",
+	} {
+		if _, err := testDB.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	dsn, err := url.Parse(testDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn.User = url.UserPassword("rls_app", "rls_app")
+	db, err := store.Open(store.Postgres, dsn.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// One connection, so a scope that outlived its transaction would show
+	// in the next query.
+	db.SetMaxOpenConns(1)
+	if err := store.UseSystemRole(ctx, db, ""); err == nil {
+		t.Error("no system role for a role the policies apply to: no error")
+	}
+	if err := store.UseSystemRole(ctx, db, "rls_app"); err == nil {
+		t.Error("a system role without BYPASSRLS: no error")
+	}
+	if err := store.UseSystemRole(ctx, db, "rls_system"); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := New(testConfig(), db, Services{Clock: h.clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rls := *h
+	rls.app, rls.server = app, httptest.NewServer(app.Router())
+	defer rls.server.Close()
+	alice := rls.signup("alice@example.com")
+	bob := rls.signup("bob@example.com")
+	netflix := alice.createSubscription(netflixFixture())
+	bob.createSubscription(spotifyFixture())
+	var me models.User
+	alice.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+
+	count := func(q interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}, ctx context.Context, table string) int {
+		t.Helper()
+		var n int
+		if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	for _, c := range []struct {
+		scope                string
+		ctx                  context.Context
+		users, subscriptions int
+	}{
+		{"unscoped", ctx, 0, 0},
+		{"the tenant", store.WithTenant(ctx, defaultTenant), 2, 0},
+		{"the user", store.WithUser(ctx, defaultTenant, me.ID), 2, 1},
+		{"the user in another tenant", store.WithUser(ctx, defaultTenant+1, me.ID), 0, 0},
+		{"no such user", store.WithUser(ctx, defaultTenant, 999), 2, 0},
+		{"the system role", store.AsSystem(ctx), 2, 2},
+	} {
+		if n := count(db, c.ctx, "users"); n != c.users {
+			t.Errorf("%s: %d users, want %d", c.scope, n, c.users)
+		}
+		if n := count(db, c.ctx, "subscriptions"); n != c.subscriptions {
+			t.Errorf("%s: %d subscriptions, want %d", c.scope, n, c.subscriptions)
+		}
+	}
+
+	// In a transaction the scope is local to it.
+	tx, err := db.BeginTx(store.WithUser(ctx, defaultTenant, me.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(tx, ctx, "subscriptions"); n != 1 {
+		t.Errorf("scoped transaction: %d subscriptions, want 1", n)
+	}
+	tx.Rollback()
+	if n := count(db, ctx, "subscriptions"); n != 0 {
+		t.Errorf("unscoped after the transaction: %d subscriptions, want 0", n)
+	}
+
+	// Logging in, refreshing, households and the admin API all work under
+	// the policies.
+	var login authResponse
+	rls.anonymous().doJSON("POST", "/api/auth/login", credentials{Email: "alice@example.com", Password: testPassword}, http.StatusOK, &login)
+	rls.anonymous().doJSON("POST", "/api/auth/refresh", refreshRequest{RefreshToken: login.RefreshToken}, http.StatusOK, nil)
+	var home models.Household
+	alice.doJSON("POST", "/api/households", map[string]any{"name": "Home"}, http.StatusCreated, &home)
+	path := fmt.Sprintf("/api/households/%d", home.ID)
+	var invite models.HouseholdInvite
+	alice.doJSON("POST", path+"/invites", map[string]any{"email": "bob@example.com"}, http.StatusCreated, &invite)
+	bob.doJSON("POST", fmt.Sprintf("/api/me/invites/%d/accept", invite.ID), nil, http.StatusOK, nil)
+	alice.doJSON("PUT", path+"/subscriptions/"+strconv.Itoa(netflix.ID), nil, http.StatusOK, nil)
+	var shared []models.SharedSubscription
+	bob.doJSON("GET", path+"/subscriptions", nil, http.StatusOK, &shared)
+	if len(shared) != 1 || shared[0].ID != netflix.ID {
+		t.Errorf("bob's shared subscriptions = %+v", shared)
+	}
+	var users models.Page[AdminUser]
+	rls.asAdmin().doJSON("GET", "/api/admin/users", nil, http.StatusOK, &users)
+	if len(users.Items) != 2 {
+		t.Errorf("admin users = %+v", users.Items)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	h := newHarness(t)
+	other := h.signup("other@example.com")
+	var me models.User
+	other.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	s := h.createSubscription(netflixFixture())
+	h.doJSON("GET", fmt.Sprintf("/api/subscriptions/%d", s.ID), nil, http.StatusOK, nil)
+	h.doJSON("GET", "/api/subscriptions/999999", nil, http.StatusNotFound, nil)
+	endpoints := func(report usageReport) []string {
+		var got []string
+		for _, e := range report.Endpoints {
+			got = append(got, fmt.Sprintf("%s %s %s %d/%d", e.Email, e.Method, e.Route, e.Requests, e.Errors))
+		}
+		return got
+	}
+
+	// Nothing is written until the counts are flushed.
+	var report usageReport
+	h.doJSON("GET", "/api/usage", nil, http.StatusOK, &report)
+	if report.Requests != 0 || len(report.Endpoints) != 0 {
+		t.Errorf("usage before flushing = %+v", report)
+	}
+	if err := h.app.FlushUsage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h.doJSON("GET", "/api/usage", nil, http.StatusOK, &report)
+	want := []string{
+		" GET /api/subscriptions/{id} 2/1",
+		" POST /api/subscriptions 1/0",
+		" GET /api/usage 1/0",
+	}
+	if got := endpoints(report); !slices.Equal(got, want) {
+		t.Errorf("usage endpoints = %q, want %q", got, want)
+	}
+	if report.Since != "2025-04-24T12:00:00Z" || report.Requests != 4 || report.Errors != 1 {
+		t.Errorf("usage = %+v", report)
+	}
+
+	// Admins see everyone's usage, broken down by user.
+	h.doJSON("GET", "/api/admin/usage", nil, http.StatusForbidden, nil)
+	h.asAdmin().doJSON("GET", fmt.Sprintf("/api/admin/usage?userId=%d", me.ID), nil, http.StatusOK, &report)
+	if got, want := endpoints(report), []string{"other@example.com GET /api/me 1/0"}; !slices.Equal(got, want) {
+		t.Errorf("usage of %d = %q, want %q", me.ID, got, want)
+	}
+	if err := h.app.FlushUsage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.asAdmin().doJSON("GET", "/api/admin/usage", nil, http.StatusOK, &report)
+	if report.Requests != 6 || len(report.Endpoints) != 4 || report.Endpoints[0].Email != testEmail {
+		t.Errorf("admin usage = %+v", report)
+	}
+
+	// The reports look back ?days, a week by default.
+	h.clock.Advance(8 * 24 * time.Hour)
+	h.doJSON("GET", "/api/usage", nil, http.StatusOK, &report)
+	if report.Requests != 0 {
+		t.Errorf("usage a week later = %+v", report)
+	}
+	h.doJSON("GET", "/api/usage?days=30", nil, http.StatusOK, &report)
+	if report.Requests != 5 {
+		t.Errorf("usage over 30 days = %+v", report)
+	}
+	h.doJSON("GET", "/api/usage?days=0", nil, http.StatusBadRequest, nil)
+	h.doJSON("GET", "/api/usage?days=91", nil, http.StatusBadRequest, nil)
+	h.asAdmin().doJSON("GET", "/api/admin/usage?userId=me", nil, http.StatusBadRequest, nil)
+}
+
+func TestGRPC(t *testing.T) {
+	h := newHarness(t)
+	lis := bufconn.Listen(1 << 20)
+	srv := h.app.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := subscriptionsv1.NewSubscriptionServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+h.token)
+
+	if _, err := client.GetStats(context.Background(), &subscriptionsv1.GetStatsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("without a token: %v", err)
+	}
+
+	// Both APIs see the same subscriptions.
+	netflix := h.createSubscription(netflixFixture())
+	created, err := client.CreateSubscription(ctx, &subscriptionsv1.CreateSubscriptionRequest{Subscription: &subscriptionsv1.Subscription{
+		Name: "Spotify", Category: "Music", Cost: 10.99, BillingCycle: "monthly", NextBilling: "2025-05-03", Tags: []string{"shared"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Status != models.StatusActive || created.Currency != "USD" {
+		t.Errorf("created = %v", created)
+	}
+	var rest models.Subscription
+	h.doJSON("GET", subscriptionPath(int(created.Id), ""), nil, http.StatusOK, &rest)
+	if rest.Name != "Spotify" || !slices.Equal(rest.Tags, []string{"shared"}) {
+		t.Errorf("over REST = %+v", rest)
+	}
+	list, err := client.ListSubscriptions(ctx, &subscriptionsv1.ListSubscriptionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || list.Subscriptions[0].Id != created.Id || list.Subscriptions[1].Id != int64(netflix.ID) {
+		t.Errorf("list = %v", list)
+	}
+
+	_, err = client.CreateSubscription(ctx, &subscriptionsv1.CreateSubscriptionRequest{Subscription: &subscriptionsv1.Subscription{Name: "Bad", Cost: -1}})
+	var violations []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				violations = append(violations, v.Field)
+			}
+		}
+	}
+	if status.Code(err) != codes.InvalidArgument || !slices.Contains(violations, "cost") {
+		t.Errorf("invalid create: %v, violations %v", err, violations)
+	}
+
+	created.Cost = 11.99
+	updated, err := client.UpdateSubscription(ctx, &subscriptionsv1.UpdateSubscriptionRequest{Subscription: created})
+	if err != nil || updated.Cost != 11.99 || updated.Version != created.Version+1 {
+		t.Fatalf("update = %v, %v", updated, err)
+	}
+	// The update has to be at the subscription's current version.
+	if _, err := client.UpdateSubscription(ctx, &subscriptionsv1.UpdateSubscriptionRequest{Subscription: created}); status.Code(err) != codes.Aborted {
+		t.Errorf("update at an old version: %v", err)
+	}
+	created.Version = 0
+	if _, err := client.UpdateSubscription(ctx, &subscriptionsv1.UpdateSubscriptionRequest{Subscription: created}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("update without a version: %v", err)
+	}
+	stats, err := client.GetStats(ctx, &subscriptionsv1.GetStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Currency != "USD" || stats.TotalMonthly != 27.48 || len(stats.ByCategory) != 2 || len(stats.ByTag) != 1 {
+		t.Errorf("stats = %v", stats)
+	}
+
+	if _, err := client.DeleteSubscription(ctx, &subscriptionsv1.DeleteSubscriptionRequest{Id: created.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetSubscription(ctx, &subscriptionsv1.GetSubscriptionRequest{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("get deleted: %v", err)
+	}
+	var entries models.Page[models.AuditEntry]
+	h.doJSON("GET", "/api/audit?subscriptionId="+strconv.Itoa(int(created.Id)), nil, http.StatusOK, &entries)
+	if entries.Total != 3 {
+		t.Errorf("audit has %d entries, want create, update and delete", entries.Total)
+	}
+
+	h.asAdmin().doJSON("PUT", "/api/admin/read-only", map[string]any{"enabled": true}, http.StatusOK, nil)
+	if _, err := client.DeleteSubscription(ctx, &subscriptionsv1.DeleteSubscriptionRequest{Id: int64(netflix.ID)}); status.Code(err) != codes.Unavailable {
+		t.Errorf("delete while read-only: %v", err)
+	}
+	if _, err := client.GetSubscription(ctx, &subscriptionsv1.GetSubscriptionRequest{Id: int64(netflix.ID)}); err != nil {
+		t.Errorf("get while read-only: %v", err)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	h := newHarness(t)
+	type gqlResponse struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string         `json:"message"`
+			Extensions map[string]any `json:"extensions"`
+		} `json:"errors"`
+	}
+	query := func(h *harness, q string, variables map[string]any) gqlResponse {
+		t.Helper()
+		var resp gqlResponse
+		h.doJSON("POST", "/api/graphql", map[string]any{"query": q, "variables": variables}, http.StatusOK, &resp)
+		return resp
+	}
+	errorCode := func(resp gqlResponse) any {
+		if len(resp.Errors) == 0 {
+			return nil
+		}
+		return resp.Errors[0].Extensions["code"]
+	}
+
+	if resp, _ := h.anonymous().do("POST", "/api/graphql", map[string]any{"query": "{ tags { name } }"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d", resp.StatusCode)
+	}
+
+	netflix := netflixFixture()
+	netflix.Tags = []string{"shared"}
+	netflix = h.createSubscription(netflix)
+	var created struct {
+		CreateSubscription struct {
+			ID       string `json:"id"`
+			Category struct{ Name string }
+			Tags     []struct {
+				ID   string
+				Name string
+			}
+		} `json:"createSubscription"`
+	}
+	resp := query(h, `mutation($in: SubscriptionInput!) { createSubscription(input: $in) { id category { name } tags { id name } } }`,
+		map[string]any{"in": map[string]any{"name": "Spotify", "category": "Music", "cost": 10.99, "billingCycle": "monthly", "nextBilling": "2025-05-03", "tags": []string{"shared"}}})
+	if resp.Errors != nil {
+		t.Fatalf("create: %+v", resp.Errors)
+	}
+	json.Unmarshal(resp.Data, &created)
+	if c := created.CreateSubscription; c.Category.Name != "Music" || len(c.Tags) != 1 || c.Tags[0].Name != "shared" || c.Tags[0].ID == "0" {
+		t.Errorf("created = %+v", c)
+	}
+	spotifyID := created.CreateSubscription.ID
+	var rest models.Subscription
+	h.doJSON("GET", "/api/subscriptions/"+spotifyID, nil, http.StatusOK, &rest)
+	if rest.Name != "Spotify" {
+		t.Errorf("over REST = %+v", rest)
+	}
+
+	// Only the fields asked for come back, nested ones included.
+	resp = query(h, `{ subscriptions(sort: "name") { total items { name tags { name subscriptions { name } } } } }`, nil)
+	want := `{"subscriptions":{"total":2,"items":[` +
+		`{"name":"Netflix","tags":[{"name":"shared","subscriptions":[{"name":"Spotify"},{"name":"Netflix"}]}]},` +
+		`{"name":"Spotify","tags":[{"name":"shared","subscriptions":[{"name":"Spotify"},{"name":"Netflix"}]}]}]}}`
+	if resp.Errors != nil || string(resp.Data) != want {
+		t.Errorf("subscriptions = %s %+v", resp.Data, resp.Errors)
+	}
+	resp = query(h, `{ categories { name subscriptions { id } } }`, nil)
+	want = fmt.Sprintf(`{"categories":[{"name":"Entertainment","subscriptions":[{"id":"%d"}]},{"name":"Music","subscriptions":[{"id":"%s"}]}]}`, netflix.ID, spotifyID)
+	if resp.Errors != nil || string(resp.Data) != want {
+		t.Errorf("categories = %s %+v", resp.Data, resp.Errors)
+	}
+
+	resp = query(h, `{ stats { currency totalMonthly byCategory { category { name } monthly } } forecast(months: 2) { months { month } } }`, nil)
+	var dashboard struct {
+		Stats struct {
+			Currency     string
+			TotalMonthly float64
+			ByCategory   []struct {
+				Category struct{ Name string }
+				Monthly  float64
+			}
+		}
+		Forecast struct{ Months []struct{ Month string } }
+	}
+	json.Unmarshal(resp.Data, &dashboard)
+	if resp.Errors != nil || dashboard.Stats.Currency != "USD" || dashboard.Stats.TotalMonthly != 26.48 ||
+		len(dashboard.Stats.ByCategory) != 2 || dashboard.Stats.ByCategory[0].Category.Name != "Entertainment" || len(dashboard.Forecast.Months) != 2 {
+		t.Errorf("dashboard = %+v %+v", dashboard, resp.Errors)
+	}
+
+	const update = `mutation($id: ID!, $version: Int!, $in: SubscriptionInput!) { updateSubscription(id: $id, version: $version, input: $in) { cost version } }`
+	resp = query(h, update,
+		map[string]any{"id": netflix.ID, "version": netflix.Version, "in": map[string]any{"name": "Netflix", "category": "Entertainment", "cost": -1, "billingCycle": "monthly", "nextBilling": "2025-05-12"}})
+	if errorCode(resp) != "validation_failed" {
+		t.Errorf("invalid update: %+v", resp.Errors)
+	}
+	in := map[string]any{"name": "Netflix", "category": "Entertainment", "cost": 17.99, "billingCycle": "monthly", "nextBilling": "2025-05-12"}
+	resp = query(h, update, map[string]any{"id": netflix.ID, "version": netflix.Version, "in": in})
+	if resp.Errors != nil || string(resp.Data) != fmt.Sprintf(`{"updateSubscription":{"cost":17.99,"version":%d}}`, netflix.Version+1) {
+		t.Errorf("update = %s %+v", resp.Data, resp.Errors)
+	}
+	resp = query(h, update, map[string]any{"id": netflix.ID, "version": netflix.Version, "in": in})
+	if errorCode(resp) != "version_conflict" || resp.Errors[0].Extensions["current"] == nil {
+		t.Errorf("update at an old version: %s %+v", resp.Data, resp.Errors)
+	}
+	noVersion := `mutation($id: ID!, $in: SubscriptionInput!) { updateSubscription(id: $id, input: $in) { cost } }`
+	if resp, body := h.do("POST", "/api/graphql", map[string]any{"query": noVersion, "variables": map[string]any{"id": netflix.ID, "in": in}}); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("update without a version = %d %s", resp.StatusCode, body)
+	}
+	resp = query(h, `mutation($id: ID!) { deleteSubscription(id: $id) }`, map[string]any{"id": spotifyID})
+	if resp.Errors != nil || string(resp.Data) != fmt.Sprintf(`{"deleteSubscription":"%s"}`, spotifyID) {
+		t.Errorf("delete = %s %+v", resp.Data, resp.Errors)
+	}
+	resp = query(h, `query($id: ID!) { subscription(id: $id) { name } }`, map[string]any{"id": spotifyID})
+	if errorCode(resp) != "not_found" || string(resp.Data) != `{"subscription":null}` {
+		t.Errorf("deleted = %s %+v", resp.Data, resp.Errors)
+	}
+
+	// Another user's subscriptions aren't visible.
+	if resp := query(h.signup("other@example.com"), `{ subscriptions { total } }`, nil); string(resp.Data) != `{"subscriptions":{"total":0}}` {
+		t.Errorf("other user = %s", resp.Data)
+	}
+
+	h.asAdmin().doJSON("PUT", "/api/admin/read-only", map[string]any{"enabled": true}, http.StatusOK, nil)
+	if resp := query(h, `mutation($id: ID!) { deleteSubscription(id: $id) }`, map[string]any{"id": netflix.ID}); errorCode(resp) != "read_only" {
+		t.Errorf("delete while read-only: %+v", resp.Errors)
+	}
+	if resp := query(h, `{ subscriptions { total } }`, nil); string(resp.Data) != `{"subscriptions":{"total":1}}` {
+		t.Errorf("query while read-only = %s %+v", resp.Data, resp.Errors)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	h := newHarness(t)
+	type event struct{ ID, Event, Data string }
+	// open starts a stream and returns its events as they arrive; the
+	// channel closes when the stream ends.
+	open := func(query string) <-chan event {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		req, _ := http.NewRequestWithContext(ctx, "GET", h.server.URL+"/api/events"+query, nil)
+		if !strings.Contains(query, "token=") {
+			req.Header.Set("Authorization", "Bearer "+h.token)
+		}
+		resp, err := h.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		events := make(chan event, 10)
+		go func() {
+			defer close(events)
+			defer resp.Body.Close()
+			var e event
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				field, value, _ := strings.Cut(scanner.Text(), ": ")
+				switch field {
+				case "id":
+					e.ID = value
+				case "event":
+					e.Event = value
+				case "data":
+					e.Data = value
+				case "":
+					if e.Event != "" {
+						events <- e
+					}
+					e = event{}
+				}
+			}
+		}()
+		return events
+	}
+	next := func(events <-chan event) event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return event{}
+		}
+	}
+
+	if resp, _ := h.do("GET", "/api/events?events=subscription.renamed", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown event: status %d", resp.StatusCode)
+	}
+	if resp, _ := h.anonymous().do("GET", "/api/events?token=nope", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad token: status %d", resp.StatusCode)
+	}
+
+	all := open("")
+	deletes := open("?token=" + h.token + "&events=subscription.deleted")
+	other := open("?token=" + h.signup("other@example.com").token)
+
+	netflix := h.createSubscription(netflixFixture())
+	e := next(all)
+	var payload struct {
+		Event string
+		Data  models.Subscription
+	}
+	json.Unmarshal([]byte(e.Data), &payload)
+	if e.Event != models.EventSubscriptionCreated || payload.Event != e.Event || payload.Data.ID != netflix.ID {
+		t.Errorf("created = %+v", e)
+	}
+	h.doJSON("DELETE", subscriptionPath(netflix.ID, ""), nil, http.StatusNoContent, nil)
+	if e := next(all); e.Event != models.EventSubscriptionDeleted {
+		t.Errorf("second event = %+v", e)
+	}
+	if e := next(deletes); e.Event != models.EventSubscriptionDeleted || e.Data != fmt.Sprintf(`{"event":"subscription.deleted","createdAt":"2025-05-01T12:00:00Z","data":{"id":%d}}`, netflix.ID) {
+		t.Errorf("filtered stream = %+v", e)
+	}
+	select {
+	case e := <-other:
+		t.Errorf("another user's stream got %+v", e)
+	default:
+	}
+
+	// Shutting down ends every stream.
+	h.app.CloseEventStreams()
+	for _, events := range []<-chan event{all, deletes, other} {
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("event after close")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream still open after CloseEventStreams")
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	h := newHarness(t)
+	post := func(h *harness, key string, body any) (*http.Response, []byte) {
+		t.Helper()
+		buf, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", h.server.URL+"/api/subscriptions", bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		return h.send(req)
+	}
+	count := func() int {
+		t.Helper()
+		var page models.Page[models.Subscription]
+		h.doJSON("GET", "/api/subscriptions", nil, http.StatusOK, &page)
+		return page.Total
+	}
+
+	first, firstBody := post(h, "retry-1", netflixFixture())
+	again, againBody := post(h, "retry-1", netflixFixture())
+	if first.StatusCode != http.StatusCreated || again.StatusCode != http.StatusCreated || string(againBody) != string(firstBody) {
+		t.Errorf("retry = %d %s, want %d %s", again.StatusCode, againBody, first.StatusCode, firstBody)
+	}
+	if first.Header.Get("Idempotent-Replayed") != "" || again.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Idempotent-Replayed = %q then %q", first.Header.Get("Idempotent-Replayed"), again.Header.Get("Idempotent-Replayed"))
+	}
+	for _, name := range []string{"Location", "ETag"} {
+		if v := first.Header.Get(name); v == "" || again.Header.Get(name) != v {
+			t.Errorf("%s = %q, replayed as %q", name, v, again.Header.Get(name))
+		}
+	}
+	if n := count(); n != 1 {
+		t.Errorf("%d subscriptions after a retry, want 1", n)
+	}
+
+	// Errors are replayed too, except server errors.
+	invalid, invalidBody := post(h, "retry-2", map[string]any{})
+	if again, againBody := post(h, "retry-2", map[string]any{}); again.StatusCode != invalid.StatusCode || string(againBody) != string(invalidBody) {
+		t.Errorf("invalid retry = %d %s", again.StatusCode, againBody)
+	}
+
+	resp, body := post(h, "retry-1", spotifyFixture())
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(body), "idempotency_key_reused") {
+		t.Errorf("reused key = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := post(h, strings.Repeat("k", 256), netflixFixture()); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("long key: status %d", resp.StatusCode)
+	}
+	// The body is read into memory to hash it, so it's capped.
+	huge := netflixFixture()
+	huge.Description = strings.Repeat("x", maxJSONBytes)
+	if resp, body := post(h, "retry-huge", huge); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge body = %d %s, want 413", resp.StatusCode, body)
+	}
+
+	// Keys belong to one user and last a day.
+	if resp, _ := post(h.signup("other@example.com"), "retry-1", spotifyFixture()); resp.StatusCode != http.StatusCreated {
+		t.Errorf("other user's key: status %d", resp.StatusCode)
+	}
+	h.clock.Advance(25 * time.Hour)
+	if resp, body := post(h, "retry-1", netflixFixture()); resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("expired key = %d %s", resp.StatusCode, body)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("%d subscriptions after the key expired, want 2", n)
+	}
+}
+
+func TestETags(t *testing.T) {
+	h := newHarness(t)
+	sub := h.createSubscription(netflixFixture())
+	path := subscriptionPath(sub.ID, "")
+	conditional := func(method, path, header, etag string, body any) (*http.Response, []byte) {
+		t.Helper()
+		var r io.Reader
+		if body != nil {
+			buf, _ := json.Marshal(body)
+			r = bytes.NewReader(buf)
+		}
+		req, _ := http.NewRequest(method, h.server.URL+path, r)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, etag)
+		return h.send(req)
+	}
+
+	resp, _ := h.do("GET", path, nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET sent no ETag")
+	}
+	if resp, body := conditional("GET", path, "If-None-Match", etag, nil); resp.StatusCode != http.StatusNotModified || len(body) != 0 {
+		t.Errorf("GET with a matching If-None-Match = %d %s, want 304", resp.StatusCode, body)
+	}
+	if resp, _ := conditional("GET", path, "If-None-Match", `"other", W/`+etag, nil); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET with a weak match: status %d, want 304", resp.StatusCode)
+	}
+	list, _ := h.do("GET", "/api/subscriptions", nil)
+	if resp, _ := conditional("GET", "/api/subscriptions", "If-None-Match", list.Header.Get("ETag"), nil); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("list with a matching If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+
+	// An update with the current tag succeeds and returns the new one.
+	resp, body := conditional("PATCH", path, "If-Match", etag, map[string]any{"cost": 17.99})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag = %d %s", resp.StatusCode, body)
+	}
+	updated := resp.Header.Get("ETag")
+	if got, _ := h.do("GET", path, nil); updated == etag || got.Header.Get("ETag") != updated {
+		t.Errorf("ETag after PATCH = %q, GET sends %q, before %q", updated, got.Header.Get("ETag"), etag)
+	}
+	if resp, _ := conditional("GET", "/api/subscriptions", "If-None-Match", list.Header.Get("ETag"), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("list after a change: status %d, want 200", resp.StatusCode)
+	}
+
+	// The old tag is now a lost update.
+	stale := netflixFixture()
+	stale.Cost = 2000
+	for method, update := range map[string]any{"PUT": stale, "PATCH": map[string]any{"cost": 20}} {
+		resp, body := conditional(method, path, "If-Match", etag, update)
+		if resp.StatusCode != http.StatusPreconditionFailed || !strings.Contains(string(body), "precondition_failed") {
+			t.Errorf("%s with an old ETag = %d %s, want 412", method, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := conditional("PUT", path, "If-Match", "W/"+updated, stale); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a weak ETag: status %d, want 412", resp.StatusCode)
+	}
+	var got models.Subscription
+	h.doJSON("GET", path, nil, http.StatusOK, &got)
+	if got.Cost != 1799 {
+		t.Errorf("cost = %v after rejected updates, want 17.99", got.Cost)
+	}
+	if resp, body := conditional("PUT", path, "If-Match", "*", stale); resp.StatusCode != http.StatusOK {
+		t.Errorf("PUT with If-Match: * = %d %s", resp.StatusCode, body)
+	}
+
+	for method, update := range map[string]any{"PUT": stale, "PATCH": map[string]any{"cost": 1}} {
+		if resp, body := h.do(method, path, update); resp.StatusCode != http.StatusPreconditionRequired || !strings.Contains(string(body), "precondition_required") {
+			t.Errorf("%s without If-Match = %d %s, want 428", method, resp.StatusCode, body)
+		}
+	}
+
+	// Going stale with time isn't a change to the subscription.
+	h.doJSON("POST", subscriptionPath(sub.ID, "/verify"), nil, http.StatusNoContent, nil)
+	fresh, _ := h.do("GET", path, nil)
+	etag = fresh.Header.Get("ETag")
+	h.clock.Advance(7 * 30 * 24 * time.Hour)
+	resp, body = h.do("GET", path, nil)
+	if resp.Header.Get("ETag") != etag || !strings.Contains(string(body), `"stale":true`) {
+		t.Errorf("ETag once stale = %q, before %q: %s", resp.Header.Get("ETag"), etag, body)
+	}
+	if resp, body := conditional("PATCH", path, "If-Match", etag, map[string]any{"cost": 18.99}); resp.StatusCode != http.StatusOK {
+		t.Errorf("PATCH with the ETag from before going stale = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestVersionConflicts(t *testing.T) {
+	h := newHarness(t)
+	s := h.createSubscription(netflixFixture())
+	path := fmt.Sprintf("/api/subscriptions/%d", s.ID)
+	if s.Version != 1 {
+		t.Fatalf("version = %d after create, want 1", s.Version)
+	}
+
+	// Two tabs open the subscription; the first one to save wins.
+	first, second := s, s
+	first.Cost = 1799
+	var saved models.Subscription
+	h.doJSON("PUT", path, first, http.StatusOK, &saved)
+	if saved.Version != 2 {
+		t.Errorf("version = %d after PUT, want 2", saved.Version)
+	}
+	second.Description = "family plan"
+	for method, update := range map[string]any{"PUT": second, "PATCH": map[string]any{"description": "family plan", "version": 1}} {
+		var conflict struct {
+			Code    string              `json:"code"`
+			Current models.Subscription `json:"current"`
+		}
+		h.doJSON(method, path, update, http.StatusConflict, &conflict)
+		if conflict.Code != "version_conflict" || conflict.Current.Version != 2 || conflict.Current.Cost != 1799 {
+			t.Errorf("%s with an old version: %+v, want a version_conflict with the saved subscription", method, conflict)
+		}
+	}
+	var got models.Subscription
+	h.doJSON("GET", path, nil, http.StatusOK, &got)
+	if got.Description != s.Description || got.Version != 2 {
+		t.Errorf("after conflicting updates: description %q, version %d", got.Description, got.Version)
+	}
+
+	// Starting over from the current record goes through.
+	h.doJSON("PATCH", path, map[string]any{"description": "family plan", "version": 2}, http.StatusOK, &saved)
+	if saved.Description != "family plan" || saved.Version != 3 {
+		t.Errorf("PATCH at the current version: description %q, version %d", saved.Description, saved.Version)
+	}
+	// An update has to say which version it's for.
+	if resp, body := h.do("PATCH", path, map[string]any{"cost": 19.99}); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without a version = %d %s, want 428", resp.StatusCode, body)
+	}
+	h.doJSON("PATCH", path, map[string]any{"cost": 19.99, "version": saved.Version}, http.StatusOK, nil)
+}
+
+// Pausing and archiving bump the version too, so the version they answer
+// with is the one the next update has to send.
+func TestVersionAfterStatusChanges(t *testing.T) {
+	h := newHarness(t)
+	s := h.createSubscription(netflixFixture())
+	path := subscriptionPath(s.ID, "")
+
+	for _, action := range []string{"/pause", "/resume", "/archive", "/unarchive"} {
+		var changed models.Subscription
+		resp, body := h.do("POST", subscriptionPath(s.ID, action), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s = %d %s", action, resp.StatusCode, body)
+		}
+		if err := json.Unmarshal(body, &changed); err != nil {
+			t.Fatal(err)
+		}
+		if changed.Version != s.Version+1 {
+			t.Errorf("%s answered version %d, want %d", action, changed.Version, s.Version+1)
+		}
+		if got, _ := h.do("GET", path, nil); got.Header.Get("ETag") != resp.Header.Get("ETag") {
+			t.Errorf("%s sent ETag %q, GET sends %q", action, resp.Header.Get("ETag"), got.Header.Get("ETag"))
+		}
+		h.doJSON("PATCH", path, map[string]any{"description": action, "version": changed.Version}, http.StatusOK, &s)
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	h := newHarness(t)
+	h.app.config.Build.Version = "1.2.3"
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	h.anonymous().doJSON("GET", "/api/openapi.json", nil, http.StatusOK, &spec)
+	if spec.OpenAPI != "3.0.3" || spec.Info.Version != "1.2.3" {
+		t.Errorf("spec is OpenAPI %q, version %q", spec.OpenAPI, spec.Info.Version)
+	}
+	// The harness checks every response against it, which needs it valid.
+	if _, err := specRouter(); err != nil {
+		t.Errorf("openapi.json is invalid: %v", err)
+	}
+
+	// A dev-mode app has every route, including the clock.
+	app, err := New(testConfig(), testDB, Services{Clock: &clock.Travel{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	err = app.Router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // a subrouter's prefix
+		}
+		for _, m := range methods {
+			if !documented[m+" "+path] {
+				t.Errorf("%s %s is missing from openapi.json", m, path)
+			}
+			delete(documented, m+" "+path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for op := range documented {
+		t.Errorf("openapi.json documents %s, which isn't routed", op)
+	}
+
+	resp, body := h.anonymous().do("GET", "/api/docs", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "/api/openapi.json") {
+		t.Errorf("docs = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	newHarness(t)
+
+	for range store.SchemaVersion {
+		if _, err := store.MigrateDown(testDB); err != nil {
+			t.Fatalf("Error reverting migration: %v", err)
+		}
+	}
+	if m, err := store.MigrateDown(testDB); err != nil || m != nil {
+		t.Fatalf("MigrateDown on an empty schema = %v, %v; want nil, nil", m, err)
+	}
+
+	ran, err := store.MigrateUp(testDB)
+	if err != nil {
+		t.Fatalf("Error reapplying migrations: %v", err)
+	}
+	if len(ran) != store.SchemaVersion {
+		t.Errorf("reapplied %d migrations, want %d", len(ran), store.SchemaVersion)
+	}
+	statuses, err := store.Status(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d_%s still pending", s.Version, s.Name)
+		}
+	}
+
+	// The schema works again afterwards.
+	newHarness(t).createSubscription(netflixFixture())
+}
+
+func TestStatements(t *testing.T) {
+	h := newHarness(t)
+	var me models.User
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	ctx := store.AsSystem(context.Background())
+	stmts := store.NewStatements(testDB)
+
+	// A prepared statement is reused with new arguments and sees writes
+	// made since it was prepared.
+	const query = "SELECT currency FROM users WHERE id = $1"
+	for _, want := range []string{"USD", "EUR"} {
+		var got string
+		if err := stmts.QueryRowContext(ctx, query, me.ID).Scan(&got); err != nil || got != want {
+			t.Errorf("currency = %q, %v; want %q", got, err, want)
+		}
+		if _, err := stmts.ExecContext(ctx, "UPDATE users SET currency = $1 WHERE id = $2", "EUR", me.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Past the cache's cap, queries still run, just unprepared.
+	for i := range 300 {
+		var got int
+		if err := stmts.QueryRowContext(ctx, fmt.Sprintf("SELECT %d + $1", i), 1).Scan(&got); err != nil || got != i+1 {
+			t.Fatalf("query %d = %d, %v", i, got, err)
+		}
+	}
+
+	// A query that can't be prepared reports its error as it would unprepared.
+	if _, err := stmts.QueryContext(ctx, "SELECT * FROM no_such_table"); err == nil {
+		t.Error("querying a missing table succeeded")
+	}
+	if err := stmts.QueryRowContext(ctx, "SELECT * FROM no_such_table").Scan(new(int)); err == nil {
+		t.Error("querying a missing table for a row succeeded")
+	}
+
+	// Lists and stats are indexed.
+	indexes := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = $1"
+	if testDriver == store.Postgres {
+		indexes = "SELECT COUNT(*) FROM pg_indexes WHERE indexname = $1"
+	}
+	for _, name := range []string{"subscriptions_user_next_billing", "subscriptions_next_billing", "subscriptions_user_category"} {
+		var n int
+		if err := testDB.QueryRowContext(ctx, indexes, name).Scan(&n); err != nil || n != 1 {
+			t.Errorf("index %s: found %d, %v", name, n, err)
+		}
+	}
+}
+
+func TestInTx(t *testing.T) {
+	h := newHarness(t)
+	var me models.User
+	h.doJSON("GET", "/api/me", nil, http.StatusOK, &me)
+	ctx := context.Background()
+	insert := func(tx *sql.Tx, name string) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO tags (user_id, name) VALUES ($1, $2)", me.ID, name)
+		return err
+	}
+	tags := func() int {
+		t.Helper()
+		var n int
+		if err := testDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM tags WHERE user_id = $1", me.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	failed := errors.New("failed")
+	err := store.InTx(ctx, testDB, func(tx *sql.Tx) error {
+		if err := insert(tx, "kept"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("err = %v, want fn's error", err)
+	}
+	if n := tags(); n != 0 {
+		t.Fatalf("%d tags after a failed transaction, want 0", n)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("InTx swallowed the panic")
+			}
+		}()
+		store.InTx(ctx, testDB, func(tx *sql.Tx) error {
+			insert(tx, "kept")
+			panic("boom")
+		})
+	}()
+	if n := tags(); n != 0 {
+		t.Fatalf("%d tags after a panicking transaction, want 0", n)
+	}
+
+	if err := store.InTx(ctx, testDB, func(tx *sql.Tx) error {
+		if err := insert(tx, "one"); err != nil {
+			return err
+		}
+		return insert(tx, "two")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := tags(); n != 2 {
+		t.Fatalf("%d tags after a committed transaction, want 2", n)
+	}
+}
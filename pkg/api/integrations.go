@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"subscription-tracker/pkg/cache"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/rates"
+)
+
+// External services are reached through these interfaces so they can be
+// swapped for fakes in dev mode and tests. Mail lives in package notify.
+
+// RateProvider converts between currencies.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (float64, error)
+}
+
+// BankSync pulls recent transactions from a bank aggregator.
+type BankSync interface {
+	FetchTransactions(ctx context.Context, since time.Time) ([]models.Transaction, error)
+}
+
+// BlobStore holds uploaded files and backups.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// StatsCache keeps computed /api/stats responses, per user and currency.
+// Package cache has one in memory and one in Redis.
+type StatsCache interface {
+	Get(ctx context.Context, userID int, key string) (cache.Entry, bool, error)
+	Set(ctx context.Context, userID int, key string, e cache.Entry) error
+	// Invalidate drops the user's entries after a change made at the
+	// given time.
+	Invalidate(ctx context.Context, userID int, at time.Time) error
+}
+
+// ErrNotConfigured is returned by every integration that hasn't been set up.
+var ErrNotConfigured = errors.New("integration not configured")
+
+// unconfigured stands in for every integration until a real one is set up.
+type unconfigured struct{}
+
+func (unconfigured) Send(context.Context, notify.Email) error { return ErrNotConfigured }
+func (unconfigured) Rate(context.Context, string, string) (float64, error) {
+	return 0, ErrNotConfigured
+}
+func (unconfigured) FetchTransactions(context.Context, time.Time) ([]models.Transaction, error) {
+	return nil, ErrNotConfigured
+}
+func (unconfigured) Put(context.Context, string, io.Reader) error { return ErrNotConfigured }
+func (unconfigured) Get(context.Context, string) (io.ReadCloser, error) {
+	return nil, ErrNotConfigured
+}
+func (unconfigured) Delete(context.Context, string) error { return ErrNotConfigured }
+func (unconfigured) List(context.Context, string) ([]string, error) {
+	return nil, ErrNotConfigured
+}
+
+// rateProvider builds the exchange rate provider the config names, or
+// returns nil if it can't.
+func (a *App) rateProvider() RateProvider {
+	cfg := a.config
+	client := &http.Client{Timeout: 10 * time.Second}
+	var source rates.Source
+	switch cfg.ExchangeRatesProvider {
+	case "static":
+		table, err := rates.ParseTable(models.DefaultCurrency, cfg.ExchangeRatesStatic)
+		if err != nil {
+			a.logger.Error("exchange rates are off", "err", err)
+			return nil
+		}
+		return rates.Static{Table: table}
+	case "ecb":
+		source = rates.ECB{URL: cfg.ExchangeRatesURL, Client: client}
+	case "openexchangerates":
+		source = rates.OpenExchangeRates{URL: cfg.ExchangeRatesURL, AppID: cfg.ExchangeRatesAppID, Client: client}
+	default:
+		a.logger.Error("exchange rates are off", "err", fmt.Errorf("unknown provider %q", cfg.ExchangeRatesProvider))
+		return nil
+	}
+	return &rates.Cached{Source: source, TTL: cfg.ExchangeRatesTTL, Clock: a.clock}
+}
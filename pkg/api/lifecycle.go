@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// subscriptionStatuses are the values ?status= accepts.
+var subscriptionStatuses = []string{models.StatusActive, models.StatusPaused, models.StatusCancelled}
+
+// maxCancellationReason caps a cancellation reason, in characters.
+const maxCancellationReason = 500
+
+// cancelRequest is the optional body of POST
+// /api/subscriptions/{id}/cancel. The date defaults to today.
+type cancelRequest struct {
+	Date   string `json:"date"`
+	Reason string `json:"reason"`
+}
+
+// asNew clears the status of a subscription about to be created: new
+// subscriptions are active and unarchived, and only pause, resume, cancel
+// and archive change that.
+func asNew(s models.Subscription) models.Subscription {
+	s.Status, s.CancelledAt, s.CancellationReason = models.StatusActive, nil, nil
+	s.ArchivedAt = nil
+	return s
+}
+
+// pauseSubscription stops an active subscription billing until it's
+// resumed.
+func (a *App) pauseSubscription(w http.ResponseWriter, r *http.Request) {
+	a.transition(w, r, models.StatusPaused, []string{models.StatusActive}, nil, nil)
+}
+
+// resumeSubscription makes a paused or cancelled subscription active
+// again, clearing any cancellation. A next billing date that passed in
+// the meantime moves to the next one from today, without recording the
+// missed dates as billed.
+func (a *App) resumeSubscription(w http.ResponseWriter, r *http.Request) {
+	a.transition(w, r, models.StatusActive, []string{models.StatusPaused, models.StatusCancelled}, nil, nil)
+}
+
+// cancelSubscription cancels an active or paused subscription, keeping it
+// with the date it ended and an optional reason.
+func (a *App) cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	var req cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	var errs fieldErrors
+	if req.Date == "" {
+		req.Date = a.clock.Now().Format(dateLayout)
+	} else if _, err := time.Parse(dateLayout, req.Date); err != nil {
+		errs.add("date", "must be a valid date in YYYY-MM-DD format")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len([]rune(req.Reason)) > maxCancellationReason {
+		errs.add("reason", fmt.Sprintf("must be at most %d characters", maxCancellationReason))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	var reason *string
+	if req.Reason != "" {
+		reason = &req.Reason
+	}
+	a.transition(w, r, models.StatusCancelled, []string{models.StatusActive, models.StatusPaused}, &req.Date, reason)
+}
+
+// transition moves the subscription in the path to status to if its
+// current status is one of from, and answers 409 otherwise.
+func (a *App) transition(w http.ResponseWriter, r *http.Request, to string, from []string, cancelledAt, reason *string) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	before, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !slices.Contains(from, before.Status) {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("Subscription is %s", before.Status))
+		return
+	}
+
+	now := a.clock.Now()
+	err = a.subscriptions.SetStatus(r.Context(), uid, id, before.Status, to, cancelledAt, reason, now)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusConflict, codeConflict, "Subscription changed while updating its status; try again")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	if to == models.StatusActive {
+		// Billing dates don't roll forward while a subscription isn't
+		// active, so a resumed one can be behind.
+		from := before.NextBilling
+		if next, ok := nextBillingFrom(from, before.BillingCycle, models.DateOf(now)); ok && next != from {
+			err := a.subscriptions.Advance(r.Context(), uid, id, from, next, nil, now)
+			if err != nil && err != store.ErrNotFound {
+				writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+				return
+			}
+		}
+	}
+
+	// Each write bumped the version, so the response is read back for a
+	// client to send the version it now has.
+	s, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.setStale(&s)
+	a.recordAudit(r.Context(), uid, id, &before, &s)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionUpdated, s)
+	if to == models.StatusActive {
+		a.checkBudgets(r.Context(), uid)
+	}
+	a.setSubscriptionETag(w, r, uid, id)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// nextBillingFrom is the first billing date on or after today for a
+// subscription next billed on date, counting from date as rollForward
+// does. It reports false for a date or cycle it doesn't understand.
+func nextBillingFrom(date models.Date, cycle string, today models.Date) (models.Date, bool) {
+	if date.IsZero() {
+		return models.Date{}, false
+	}
+	start := date.Time()
+	next, ok := start, true
+	for n := 1; next.Before(today.Time()); n++ {
+		if next, ok = addCycle(start, cycle, n); !ok {
+			return models.Date{}, false
+		}
+	}
+	return models.DateOf(next), true
+}
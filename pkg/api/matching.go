@@ -0,0 +1,670 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+const (
+	// Scores at or above autoMatchScore link the transaction immediately,
+	// provided the runner-up trails by at least autoMatchMargin. Anything
+	// between reviewScore and that goes to the review queue.
+	autoMatchScore  = 0.75
+	autoMatchMargin = 0.1
+	reviewScore     = 0.45
+
+	maxReviewCandidates = 3
+)
+
+var transactionSources = map[string]bool{"bank": true, "stripe": true, "paypal": true}
+
+// scoredMatch is one subscription's score against a single transaction.
+type scoredMatch struct {
+	subscriptionID int
+	score          float64
+}
+
+// matchableSubscription holds the fields the matcher and reconciliation
+// need. Created is the day it was added and Cancelled, if it's cancelled,
+// the day it was.
+type matchableSubscription struct {
+	ID           int
+	Name         string
+	Cost         models.Money
+	BillingCycle string
+	NextBilling  time.Time
+	Status       string
+	Created      time.Time
+	Cancelled    *time.Time
+}
+
+// billableBetween narrows [from, to] to the days s could have billed on:
+// from the day it was added up to the day before it was cancelled. ok is
+// false if it couldn't have billed at all.
+func (s matchableSubscription) billableBetween(from, to time.Time) (time.Time, time.Time, bool) {
+	created := time.Date(s.Created.Year(), s.Created.Month(), s.Created.Day(), 0, 0, 0, 0, time.UTC)
+	if from.Before(created) {
+		from = created
+	}
+	if s.Cancelled != nil && !to.Before(*s.Cancelled) {
+		to = s.Cancelled.AddDate(0, 0, -1)
+	}
+	return from, to, !to.Before(from)
+}
+
+// scoreTransaction rates how likely it is that t is a charge for s, from 0
+// to 1, weighing name similarity, amount and billing cadence.
+func scoreTransaction(t models.Transaction, posted time.Time, s matchableSubscription) float64 {
+	name := nameSimilarity(t.Description, s.Name)
+	amount := amountSimilarity(t.Amount.Abs(), s.Cost)
+	cadence := cadenceSimilarity(posted, s)
+	return 0.5*name + 0.3*amount + 0.2*cadence
+}
+
+// nameSimilarity compares a raw statement descriptor with a subscription
+// name. Descriptors are noisy ("NETFLIX.COM 866-579-7172 CA"), so a name
+// contained in the descriptor counts as a full match.
+func nameSimilarity(descriptor, name string) float64 {
+	d := normalizeMerchant(descriptor)
+	n := normalizeMerchant(name)
+	if d == "" || n == "" {
+		return 0
+	}
+	if strings.Contains(d, n) || strings.Contains(n, d) {
+		return 1
+	}
+
+	best := 0.0
+	for _, token := range strings.Fields(d) {
+		if s := levenshteinRatio(token, strings.ReplaceAll(n, " ", "")); s > best {
+			best = s
+		}
+	}
+	if s := levenshteinRatio(d, n); s > best {
+		best = s
+	}
+	return best
+}
+
+var merchantNoise = map[string]bool{
+	"www": true, "com": true, "net": true, "inc": true, "llc": true, "ltd": true,
+	"pos": true, "payment": true, "purchase": true, "recurring": true, "debit": true,
+	"card": true, "paypal": true, "stripe": true, "subscription": true, "bill": true,
+}
+
+func normalizeMerchant(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+
+	var tokens []string
+	for _, token := range strings.Fields(b.String()) {
+		if len(token) > 1 && !merchantNoise[token] {
+			tokens = append(tokens, token)
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+func levenshteinRatio(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// amountSimilarity is 1 for an exact match and falls to 0 at a 20% difference.
+func amountSimilarity(amount, cost models.Money) float64 {
+	if cost <= 0 {
+		return 0
+	}
+	diff := float64((amount - cost).Abs()) / float64(cost)
+	if diff <= 0.005 {
+		return 1
+	}
+	return math.Max(0, 1-diff/0.2)
+}
+
+// cadenceSimilarity checks how close the charge lands to a projected billing
+// date. Tolerance scales with the cycle: a couple of days for weekly plans,
+// up to a week for longer ones.
+func cadenceSimilarity(posted time.Time, s matchableSubscription) float64 {
+	expected, ok := nearestBillingDate(s.NextBilling, s.BillingCycle, posted)
+	if !ok {
+		return 0.5
+	}
+	tolerance := 7
+	if cycleDays(s.BillingCycle) <= 7 {
+		tolerance = 2
+	}
+	off := absDays(posted.Sub(expected))
+	if off > tolerance {
+		return 0
+	}
+	return 1 - float64(off)/float64(tolerance+1)
+}
+
+// rankMatches scores t against every subscription, best first.
+func rankMatches(t models.Transaction, subs []matchableSubscription) ([]scoredMatch, error) {
+	posted, err := time.Parse(dateLayout, t.Date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction date %q: %w", t.Date, err)
+	}
+
+	var ranked []scoredMatch
+	for _, s := range subs {
+		ranked = append(ranked, scoredMatch{subscriptionID: s.ID, score: scoreTransaction(t, posted, s)})
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	return ranked, nil
+}
+
+// loadMatchableSubscriptions loads the user's subscriptions that can be
+// charged for: paused and archived ones are left out.
+func (a *App) loadMatchableSubscriptions(ctx context.Context, userID int) ([]matchableSubscription, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, name, cost_cents, billing_cycle, next_billing, status, created_at, cancelled_at
+		FROM subscriptions
+		WHERE user_id = $1 AND status <> $2 AND archived_at IS NULL
+	`, userID, models.StatusPaused)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []matchableSubscription
+	for rows.Next() {
+		var s matchableSubscription
+		var cancelled sql.NullString
+		if err := rows.Scan(&s.ID, &s.Name, &s.Cost, &s.BillingCycle, &s.NextBilling, &s.Status, &s.Created, &cancelled); err != nil {
+			return nil, err
+		}
+		if cancelled.Valid {
+			day, err := time.Parse(dateLayout, cancelled.String[:min(len(cancelled.String), len(dateLayout))])
+			if err != nil {
+				return nil, err
+			}
+			s.Cancelled = &day
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// matchTransaction runs the matcher for a single stored transaction and
+// records the outcome: a direct link, review candidates, or nothing. Only
+// active subscriptions are candidates.
+func (a *App) matchTransaction(ctx context.Context, userID int, t *models.Transaction, subs []matchableSubscription) (string, error) {
+	rejected, err := a.rejectedSubscriptions(ctx, t.ID)
+	if err != nil {
+		return "", err
+	}
+	var eligible []matchableSubscription
+	for _, s := range subs {
+		if !rejected[s.ID] && s.Status == models.StatusActive {
+			eligible = append(eligible, s)
+		}
+	}
+
+	ranked, err := rankMatches(*t, eligible)
+	if err != nil {
+		return "", err
+	}
+
+	status := models.MatchStatusUnmatched
+	var linked *int
+	switch {
+	case len(ranked) > 0 && ranked[0].score >= autoMatchScore &&
+		(len(ranked) == 1 || ranked[0].score-ranked[1].score >= autoMatchMargin):
+		status = models.MatchStatusMatched
+		linked = &ranked[0].subscriptionID
+	case len(ranked) > 0 && ranked[0].score >= reviewScore:
+		status = models.MatchStatusReview
+		for i, m := range ranked {
+			if i == maxReviewCandidates || m.score < reviewScore {
+				break
+			}
+			if _, err := a.db.ExecContext(ctx, `
+				INSERT INTO match_candidates (transaction_id, subscription_id, score)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (transaction_id, subscription_id) DO UPDATE SET score = EXCLUDED.score
+			`, t.ID, m.subscriptionID, m.score); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if _, err := a.db.ExecContext(ctx, `UPDATE transactions SET subscription_id = $1, match_status = $2 WHERE id = $3`,
+		linked, status, t.ID); err != nil {
+		return "", err
+	}
+	t.SubscriptionID = linked
+	t.MatchStatus = status
+
+	if linked != nil {
+		if err := a.markVerifiedByCharge(ctx, userID, *t); err != nil {
+			return "", err
+		}
+	}
+	return status, nil
+}
+
+// markVerifiedByCharge treats a matched charge as proof the subscription is
+// still live as of the charge date.
+func (a *App) markVerifiedByCharge(ctx context.Context, userID int, t models.Transaction) error {
+	posted, err := time.Parse(dateLayout, t.Date)
+	if err != nil {
+		return err
+	}
+	return a.markVerified(ctx, userID, *t.SubscriptionID, posted)
+}
+
+// rejectedSubscriptions returns the subscriptions a user already ruled out
+// for this transaction, so rematching doesn't suggest them again.
+func (a *App) rejectedSubscriptions(ctx context.Context, transactionID int) (map[int]bool, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT subscription_id FROM match_candidates WHERE transaction_id = $1 AND status = 'rejected'`, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rejected := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		rejected[id] = true
+	}
+	return rejected, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransaction(scanner rowScanner, t *models.Transaction) error {
+	var posted time.Time
+	var subscriptionID sql.NullInt64
+	if err := scanner.Scan(&t.ID, &t.Source, &t.ExternalID, &t.Description, &t.Amount, &posted, &subscriptionID, &t.MatchStatus); err != nil {
+		return err
+	}
+	t.Date = posted.Format(dateLayout)
+	t.SubscriptionID = nullableInt(subscriptionID)
+	return nil
+}
+
+// storeTransactions saves a validated batch for the user and runs the
+// matcher and alert checks on each new transaction. Re-importing the same
+// source/externalId pair is a no-op.
+func (a *App) storeTransactions(ctx context.Context, userID int, batch []models.Transaction) (map[string]int, error) {
+	subs, err := a.loadMatchableSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := map[string]int{"imported": 0, "skipped": 0, models.MatchStatusMatched: 0, models.MatchStatusReview: 0, models.MatchStatusUnmatched: 0}
+	for _, t := range batch {
+		err := a.db.QueryRowContext(ctx, `
+			INSERT INTO transactions (user_id, source, external_id, description, amount_cents, posted_on)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, source, external_id) DO NOTHING
+			RETURNING id
+		`, userID, t.Source, t.ExternalID, t.Description, t.Amount, t.Date).Scan(&t.ID)
+		if err == sql.ErrNoRows {
+			summary["skipped"]++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		summary["imported"]++
+
+		status, err := a.matchTransaction(ctx, userID, &t, subs)
+		if err != nil {
+			return nil, err
+		}
+		summary[status]++
+
+		if err := a.checkUnexpectedCharge(ctx, userID, t, subs); err != nil {
+			return nil, err
+		}
+	}
+	return summary, nil
+}
+
+// importTransactions stores a batch of transactions posted by the client.
+func (a *App) importTransactions(w http.ResponseWriter, r *http.Request) {
+	var batch []models.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	for i, t := range batch {
+		if !transactionSources[t.Source] || t.ExternalID == "" || t.Description == "" || t.Amount == 0 {
+			writeError(w, http.StatusBadRequest, codeValidation, fmt.Sprintf("Transaction %d: missing required fields", i))
+			return
+		}
+		if _, err := time.Parse(dateLayout, t.Date); err != nil {
+			writeError(w, http.StatusBadRequest, codeValidation, fmt.Sprintf("Transaction %d: date must be YYYY-MM-DD", i))
+			return
+		}
+	}
+
+	summary, err := a.storeTransactions(r.Context(), userID(r), batch)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Import error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getTransactions lists imported transactions, optionally by match status.
+func (a *App) getTransactions(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+		FROM transactions
+		WHERE user_id = $1
+	`
+	args := []any{userID(r)}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND match_status = $2"
+		args = append(args, status)
+	}
+	query += " ORDER BY posted_on DESC, id DESC"
+
+	rows, err := a.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	transactions := []models.Transaction{}
+	for rows.Next() {
+		var t models.Transaction
+		if err := scanTransaction(rows, &t); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Row scan error: %v", err))
+			return
+		}
+		transactions = append(transactions, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(transactions); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getMatchReviewQueue returns pending candidates for ambiguous transactions,
+// best score first.
+func (a *App) getMatchReviewQueue(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT c.id, c.score, c.status, c.subscription_id, s.name,
+			t.id, t.source, t.external_id, t.description, t.amount_cents, t.posted_on, t.subscription_id, t.match_status
+		FROM match_candidates c
+		JOIN transactions t ON t.id = c.transaction_id
+		JOIN subscriptions s ON s.id = c.subscription_id
+		WHERE t.user_id = $1 AND c.status = 'pending'
+		ORDER BY t.posted_on DESC, c.score DESC
+	`, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	queue := []models.MatchCandidate{}
+	for rows.Next() {
+		var c models.MatchCandidate
+		var posted time.Time
+		var linked sql.NullInt64
+		t := &c.Transaction
+		if err := rows.Scan(&c.ID, &c.Score, &c.Status, &c.SubscriptionID, &c.SubscriptionName,
+			&t.ID, &t.Source, &t.ExternalID, &t.Description, &t.Amount, &posted, &linked, &t.MatchStatus); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Row scan error: %v", err))
+			return
+		}
+		t.Date = posted.Format(dateLayout)
+		t.SubscriptionID = nullableInt(linked)
+		queue = append(queue, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(queue); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// acceptMatch links the candidate's transaction to its subscription,
+// closes out the other candidates for that transaction and marks the
+// subscription verified as of the charge, all in one transaction so a
+// failure leaves the candidate pending to try again.
+func (a *App) acceptMatch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	uid := userID(r)
+
+	var t models.Transaction
+	err := store.InTx(r.Context(), a.db, func(tx *sql.Tx) error {
+		var transactionID, subscriptionID int
+		err := tx.QueryRowContext(r.Context(), `
+			UPDATE match_candidates SET status = 'accepted'
+			WHERE id = $1 AND status = 'pending'
+				AND transaction_id IN (SELECT id FROM transactions WHERE user_id = $2)
+			RETURNING transaction_id, subscription_id
+		`, id, uid).Scan(&transactionID, &subscriptionID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(r.Context(), `UPDATE match_candidates SET status = 'rejected' WHERE transaction_id = $1 AND id <> $2 AND status = 'pending'`,
+			transactionID, id); err != nil {
+			return err
+		}
+		err = scanTransaction(tx.QueryRowContext(r.Context(), `
+			UPDATE transactions SET subscription_id = $1, match_status = $2 WHERE id = $3
+			RETURNING id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+		`, subscriptionID, models.MatchStatusMatched, transactionID), &t)
+		if err != nil {
+			return err
+		}
+		posted, err := time.Parse(dateLayout, t.Date)
+		if err != nil {
+			return err
+		}
+		if err := store.VerifyTx(r.Context(), tx, uid, subscriptionID, posted); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(r.Context(), `UPDATE alerts SET dismissed = TRUE WHERE kind = $1 AND subscription_id = $2`,
+			models.AlertStaleSubscription, subscriptionID)
+		return err
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		writeError(w, http.StatusNotFound, codeNotFound, "Match candidate not found")
+		return
+	case err == store.ErrNotFound:
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.invalidateStats(r.Context(), uid)
+
+	subs, err := a.loadMatchableSubscriptions(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err := a.checkUnexpectedCharge(r.Context(), uid, t, subs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Alert error: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectMatch discards a candidate. When no candidates remain the
+// transaction falls back to unmatched.
+func (a *App) rejectMatch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var transactionID int
+	err := a.db.QueryRowContext(r.Context(), `
+		UPDATE match_candidates SET status = 'rejected'
+		WHERE id = $1 AND status = 'pending'
+			AND transaction_id IN (SELECT id FROM transactions WHERE user_id = $2)
+		RETURNING transaction_id
+	`, id, userID(r)).Scan(&transactionID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Match candidate not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	if _, err := a.db.ExecContext(r.Context(), `
+		UPDATE transactions SET match_status = $1
+		WHERE id = $2 AND NOT EXISTS (
+			SELECT 1 FROM match_candidates WHERE transaction_id = $2 AND status = 'pending'
+		)
+	`, models.MatchStatusUnmatched, transactionID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rematchTransactions reruns the matcher over transactions that are still
+// unmatched, e.g. after adding the subscription they belong to.
+func (a *App) rematchTransactions(w http.ResponseWriter, r *http.Request) {
+	uid := userID(r)
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+		FROM transactions
+		WHERE user_id = $1 AND match_status = $2
+	`, uid, models.MatchStatusUnmatched)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	var pending []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		if err := scanTransaction(rows, &t); err != nil {
+			rows.Close()
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Row scan error: %v", err))
+			return
+		}
+		pending = append(pending, t)
+	}
+	rows.Close()
+
+	subs, err := a.loadMatchableSubscriptions(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	summary := map[string]int{models.MatchStatusMatched: 0, models.MatchStatusReview: 0, models.MatchStatusUnmatched: 0}
+	for _, t := range pending {
+		status, err := a.matchTransaction(r.Context(), uid, &t, subs)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Matching error: %v", err))
+			return
+		}
+		summary[status]++
+
+		if err := a.checkUnexpectedCharge(r.Context(), uid, t, subs); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Alert error: %v", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// syncTransactions pulls transactions from the configured bank sync
+// provider and imports them. ?since=YYYY-MM-DD defaults to 30 days ago.
+func (a *App) syncTransactions(w http.ResponseWriter, r *http.Request) {
+	since := a.clock.Now().AddDate(0, 0, -30)
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(dateLayout, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "since must be YYYY-MM-DD")
+			return
+		}
+		since = parsed
+	}
+
+	batch, err := a.bankSync.FetchTransactions(r.Context(), since)
+	if errors.Is(err, ErrNotConfigured) {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Bank sync is not configured")
+		return
+	}
+	a.integrations.report("plaid", err)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Bank sync error: %v", err))
+		return
+	}
+
+	summary, err := a.storeTransactions(r.Context(), userID(r), batch)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Import error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// openAPISpec describes every route Router serves. It's maintained by hand;
+// TestOpenAPISpec fails when a route is missing from it or it lists one
+// that doesn't exist.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// getOpenAPISpec serves the OpenAPI document, stamped with the running
+// version so generated clients can tell which build they were made from.
+func (a *App) getOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("OpenAPI spec error: %v", err))
+		return
+	}
+	if info, ok := spec["info"].(map[string]any); ok {
+		info["version"] = a.config.Build.Version
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(spec); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// docsPage loads Swagger UI from a CDN and points it at the spec, so the
+// binary doesn't have to carry the UI's assets.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Subscription Tracker API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.ui = SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// getDocs serves Swagger UI for exploring the API.
+func getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, docsPage)
+}
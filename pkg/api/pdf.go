@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// A4, in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// pdfDocument builds a PDF with just the parts readers require: pages of
+// text in the standard Helvetica fonts, which need no embedding, lines and
+// filled shapes. Coordinates are in points from the top left of the page.
+type pdfDocument struct {
+	title   string
+	created time.Time
+	pages   []*bytes.Buffer
+}
+
+// rgb is a color, each component from 0 to 1.
+type rgb struct{ r, g, b float64 }
+
+func newPDFDocument(title string, created time.Time) *pdfDocument {
+	return &pdfDocument{title: title, created: created}
+}
+
+// addPage starts a page, which later drawing goes on.
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// text writes s with its baseline starting at x, y.
+func (d *pdfDocument) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, pdfPageHeight-y, pdfString(s))
+}
+
+// textRight writes s ending at x.
+func (d *pdfDocument) textRight(x, y, size float64, bold bool, s string) {
+	d.text(x-textWidth(s, size), y, size, bold, s)
+}
+
+// line draws a hairline from x1, y1 to x2, y2.
+func (d *pdfDocument) line(x1, y1, x2, y2 float64, c rgb) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", c.r, c.g, c.b, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// rect fills the rectangle with its top left corner at x, y.
+func (d *pdfDocument) rect(x, y, w, h float64, c rgb) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", c.r, c.g, c.b, x, pdfPageHeight-y-h, w, h)
+}
+
+// sector fills the slice of the circle centered on cx, cy from angle from
+// to angle to, in radians clockwise from twelve o'clock. The arc is drawn
+// as short straight lines, at most a degree apart.
+func (d *pdfDocument) sector(cx, cy, radius, from, to float64, c rgb) {
+	p := d.page()
+	fmt.Fprintf(p, "%.3f %.3f %.3f rg %.2f %.2f m", c.r, c.g, c.b, cx, pdfPageHeight-cy)
+	steps := max(int(math.Ceil((to-from)/(math.Pi/180))), 1)
+	for i := 0; i <= steps; i++ {
+		angle := from + (to-from)*float64(i)/float64(steps)
+		fmt.Fprintf(p, " %.2f %.2f l", cx+radius*math.Sin(angle), pdfPageHeight-cy+radius*math.Cos(angle))
+	}
+	p.WriteString(" h f\n")
+}
+
+// WriteTo writes out the document.
+func (d *pdfDocument) WriteTo(w io.Writer) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	out := &countingWriter{w: buffered}
+	var offsets []int64
+	object := func(body string) {
+		offsets = append(offsets, out.n)
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1 to 4 are the catalog, the page tree, the fonts and the
+	// document information; each page and its contents follow.
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /F1 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>" +
+		" /F2 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >> >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (subscription-tracker) /CreationDate (D:%s) >>",
+		pdfString(d.title), d.created.UTC().Format("20060102150405Z")))
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font 3 0 R >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		var stream bytes.Buffer
+		z := zlib.NewWriter(&stream)
+		z.Write(content.Bytes())
+		z.Close()
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	}
+
+	xref := out.n
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	if out.err != nil {
+		return out.n, out.err
+	}
+	return out.n, buffered.Flush()
+}
+
+// countingWriter counts what's written to w, for the cross-reference table,
+// and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) (int, error) {
+	return c.Write([]byte(s))
+}
+
+// winAnsi maps the characters Windows-1252 has outside Latin-1 to their
+// codes; Latin-1 characters are their own codes.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// pdfString encodes s as the body of a PDF string in WinAnsiEncoding.
+// Characters it can't encode become question marks.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths are the widths of the printable ASCII characters in
+// Helvetica, in thousandths of the font size. Helvetica-Bold's digits and
+// the punctuation in amounts are the same width, so they serve for
+// right-aligning bold figures too.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// textWidth is how wide s is set in Helvetica at size. Characters outside
+// ASCII count as wide as a digit.
+func textWidth(s string, size float64) float64 {
+	var w int
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			w += helveticaWidths[r-' ']
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) * size / 1000
+}
+
+// fitText shortens s with an ellipsis until it's at most width wide at size.
+func fitText(s string, size, width float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
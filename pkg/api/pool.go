@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PoolStats is the database connection pool as GET /api/admin/db-pool
+// reports it. The pool is saturated when every connection it may open is
+// in use, so further queries queue for one.
+type PoolStats struct {
+	MaxOpen   int  `json:"maxOpen"`
+	Open      int  `json:"open"`
+	InUse     int  `json:"inUse"`
+	Idle      int  `json:"idle"`
+	Saturated bool `json:"saturated"`
+	// WaitCount and WaitDuration total every wait for a connection since
+	// the pool was opened.
+	WaitCount    int64  `json:"waitCount"`
+	WaitDuration string `json:"waitDuration"`
+}
+
+func poolStats(s sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxOpen:      s.MaxOpenConnections,
+		Open:         s.OpenConnections,
+		InUse:        s.InUse,
+		Idle:         s.Idle,
+		Saturated:    s.MaxOpenConnections > 0 && s.InUse >= s.MaxOpenConnections,
+		WaitCount:    s.WaitCount,
+		WaitDuration: s.WaitDuration.String(),
+	}
+}
+
+// StartPoolMonitor checks the database connection pool every
+// PoolMonitorInterval until ctx is done. It warns when queries had to wait
+// for a connection since the last check, which means the pool's limit is
+// too low for the load, and otherwise logs the pool's state at debug
+// level. A pool that's merely saturated at the moment of a check isn't
+// worth a warning.
+func (a *App) StartPoolMonitor(ctx context.Context) {
+	if a.config.PoolMonitorInterval <= 0 {
+		return
+	}
+
+	a.jobs.started("pool_monitor", a.config.PoolMonitorInterval)
+	go func() {
+		defer a.jobs.stopped("pool_monitor")
+		ticker := time.NewTicker(a.config.PoolMonitorInterval)
+		defer ticker.Stop()
+		last := a.db.Stats()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s := a.db.Stats()
+			stats := poolStats(s)
+			waits, waited := s.WaitCount-last.WaitCount, s.WaitDuration-last.WaitDuration
+			attrs := []any{"max_open", stats.MaxOpen, "open", stats.Open, "in_use", stats.InUse, "idle", stats.Idle, "waits", waits, "waited", waited}
+			if waits > 0 {
+				a.logger.Warn("queries waited for a database connection", attrs...)
+			} else {
+				a.logger.Debug("database connection pool", attrs...)
+			}
+			last = s
+			a.jobs.ran("pool_monitor")
+		}
+	}()
+}
+
+// getPool reports the database connection pool's current state.
+func (a *App) getPool(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(poolStats(a.db.Stats())); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// priceIncrease is a stats insight: a subscription that costs more now
+// than it did a year ago. PreviousCost is the price then and ChangedAt the
+// first change since.
+type priceIncrease struct {
+	SubscriptionID int          `json:"subscriptionId"`
+	Name           string       `json:"name"`
+	Currency       string       `json:"currency"`
+	PreviousCost   models.Money `json:"previousCost"`
+	Cost           models.Money `json:"cost"`
+	Percent        float64      `json:"percent"`
+	ChangedAt      string       `json:"changedAt"`
+}
+
+// recordPriceChange adds to the price history when an update changed the
+// cost or currency, and raises an alert when the price went up. The update
+// has already been made, so failures are logged rather than returned. An
+// App without a database keeps no history.
+func (a *App) recordPriceChange(ctx context.Context, userID int, before, after models.Subscription) {
+	if a.db == nil || (before.Cost == after.Cost && before.Currency == after.Currency) {
+		return
+	}
+	var id int
+	err := a.db.QueryRowContext(ctx, `
+		INSERT INTO price_history (subscription_id, user_id, old_cost_cents, old_currency, new_cost_cents, new_currency, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, after.ID, userID, before.Cost, before.Currency, after.Cost, after.Currency, a.dbNow()).Scan(&id)
+	if err != nil {
+		a.logger.WarnContext(ctx, "recording price change", "subscription", after.ID, "err", err)
+		return
+	}
+	if before.Currency != after.Currency || after.Cost <= before.Cost {
+		return
+	}
+	err = a.raiseAlert(ctx, userID, models.Alert{
+		Kind:           models.AlertPriceIncreased,
+		Message:        fmt.Sprintf("%s went up from %s to %s %s", after.Name, before.Cost, after.Cost, after.Currency),
+		SubscriptionID: &after.ID,
+	}, fmt.Sprintf("%s:%d", models.AlertPriceIncreased, id))
+	if err != nil {
+		a.logger.WarnContext(ctx, "raising price alert", "subscription", after.ID, "err", err)
+	}
+}
+
+// getSubscriptionPrices lists a subscription's price changes, newest
+// first.
+func (a *App) getSubscriptionPrices(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	_, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, old_cost_cents, old_currency, new_cost_cents, new_currency, changed_at
+		FROM price_history
+		WHERE subscription_id = $1 AND user_id = $2
+		ORDER BY changed_at DESC, id DESC
+	`, id, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	changes := []models.PriceChange{}
+	for rows.Next() {
+		var c models.PriceChange
+		var changedAt time.Time
+		if err := rows.Scan(&c.ID, &c.OldCost, &c.OldCurrency, &c.NewCost, &c.NewCurrency, &changedAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		c.ChangedAt = changedAt.Format(time.RFC3339)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(changes); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// priceIncreases finds the subscriptions in subs that cost more at through
+// than they did a year before, biggest increase first. A subscription
+// whose currency changed in that time isn't compared.
+func (a *App) priceIncreases(ctx context.Context, userID int, subs []models.Subscription, through time.Time) ([]priceIncrease, error) {
+	increases := []priceIncrease{}
+	if a.db == nil {
+		return increases, nil
+	}
+	rows, err := a.stmts.QueryContext(ctx, `
+		SELECT subscription_id, old_cost_cents, old_currency, changed_at
+		FROM price_history
+		WHERE user_id = $1 AND changed_at >= $2
+		ORDER BY changed_at, id
+	`, userID, through.AddDate(-1, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// The price before the first change in the year is the one a year ago,
+	// and the price before the first change after through the one then.
+	type price struct {
+		cost      models.Money
+		currency  string
+		changedAt time.Time
+	}
+	yearAgo, then := map[int]price{}, map[int]price{}
+	for rows.Next() {
+		var id int
+		var p price
+		if err := rows.Scan(&id, &p.cost, &p.currency, &p.changedAt); err != nil {
+			return nil, err
+		}
+		if p.changedAt.After(through) {
+			if _, seen := then[id]; !seen {
+				then[id] = p
+			}
+			continue
+		}
+		if _, seen := yearAgo[id]; !seen {
+			yearAgo[id] = p
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range subs {
+		p, ok := yearAgo[s.ID]
+		cost, currency := s.Cost, s.Currency
+		if t, changed := then[s.ID]; changed {
+			cost, currency = t.cost, t.currency
+		}
+		if !ok || p.currency != currency || cost <= p.cost {
+			continue
+		}
+		increases = append(increases, priceIncrease{
+			SubscriptionID: s.ID,
+			Name:           s.Name,
+			Currency:       currency,
+			PreviousCost:   p.cost,
+			Cost:           cost,
+			Percent:        roundCents(float64(cost-p.cost) / float64(p.cost) * 100),
+			ChangedAt:      p.changedAt.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(increases, func(i, j int) bool {
+		if increases[i].Percent != increases[j].Percent {
+			return increases[i].Percent > increases[j].Percent
+		}
+		return increases[i].SubscriptionID < increases[j].SubscriptionID
+	})
+	return increases, nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+const (
+	probeOK   = "ok"
+	probeFail = "fail"
+
+	// probeTimeout bounds each readiness check, so a hung database fails
+	// the probe instead of outlasting it.
+	probeTimeout = 2 * time.Second
+)
+
+// jobMonitor tracks the background jobs started by the Start methods, so
+// /readyz can tell when one has stopped or stalled.
+type jobMonitor struct {
+	sync.Mutex
+	jobs map[string]*jobState
+}
+
+type jobState struct {
+	interval time.Duration
+	lastRun  time.Time
+	stopped  bool
+}
+
+func newJobMonitor() *jobMonitor {
+	return &jobMonitor{jobs: map[string]*jobState{}}
+}
+
+// started records that the job named name runs every interval.
+func (m *jobMonitor) started(name string, interval time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.jobs[name] = &jobState{interval: interval, lastRun: time.Now()}
+}
+
+// ran records that the job has just finished a run.
+func (m *jobMonitor) ran(name string) {
+	m.Lock()
+	defer m.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		j.lastRun = time.Now()
+	}
+}
+
+// stopped records that the job's loop has exited.
+func (m *jobMonitor) stopped(name string) {
+	m.Lock()
+	defer m.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		j.stopped = true
+	}
+}
+
+// JobStatus is one background job as /readyz reports it. A job is failing
+// once it has stopped or gone two intervals without finishing a run.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Interval string `json:"interval"`
+	LastRun  string `json:"lastRun"`
+}
+
+func (m *jobMonitor) statuses() []JobStatus {
+	m.Lock()
+	defer m.Unlock()
+	statuses := []JobStatus{}
+	for name, j := range m.jobs {
+		s := JobStatus{Name: name, Status: probeOK, Interval: j.interval.String(), LastRun: j.lastRun.Format(time.RFC3339)}
+		if j.stopped || time.Since(j.lastRun) > 2*j.interval {
+			s.Status = probeFail
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ProbeCheck is the outcome of one readiness check.
+type ProbeCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Version and Want are the applied and expected schema versions.
+	Version int `json:"version,omitempty"`
+	Want    int `json:"want,omitempty"`
+	// Jobs lists the background jobs for the scheduler check.
+	Jobs []JobStatus `json:"jobs,omitempty"`
+}
+
+// getLive answers the liveness probe: if the process can serve this, it
+// is alive. It checks nothing else, so a database outage doesn't get every
+// replica restarted.
+func getLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// getReady answers the readiness probe: 200 when the database is
+// reachable, its schema is at the version this build expects and every
+// background job started is still running, and 503 otherwise, with each
+// check's outcome in the body.
+func (a *App) getReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+
+	checks := map[string]ProbeCheck{}
+	if err := a.db.PingContext(ctx); err != nil {
+		checks["database"] = ProbeCheck{Status: probeFail, Error: err.Error()}
+	} else {
+		checks["database"] = ProbeCheck{Status: probeOK}
+	}
+
+	migrations := ProbeCheck{Status: probeOK, Want: store.SchemaVersion}
+	version, err := store.AppliedVersion(ctx, a.db)
+	switch {
+	case err != nil:
+		migrations.Status, migrations.Error = probeFail, err.Error()
+	case version != store.SchemaVersion:
+		migrations.Status, migrations.Error = probeFail, fmt.Sprintf("schema is at version %d", version)
+	}
+	migrations.Version = version
+	checks["migrations"] = migrations
+
+	scheduler := ProbeCheck{Status: probeOK, Jobs: a.jobs.statuses()}
+	for _, j := range scheduler.Jobs {
+		if j.Status != probeOK {
+			scheduler.Status, scheduler.Error = probeFail, fmt.Sprintf("job %s has stopped or stalled", j.Name)
+		}
+	}
+	checks["scheduler"] = scheduler
+
+	overall, status := probeOK, http.StatusOK
+	for _, c := range checks {
+		if c.Status != probeOK {
+			overall, status = probeFail, http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]any{"status": overall, "checks": checks}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Error codes. Every error response is an RFC 7807 problem whose code
+// member, and the last segment of its type, is one of these, so clients
+// can branch on it instead of parsing the human-readable detail.
+const (
+	codeBadRequest           = "bad_request"
+	codeInvalidJSON          = "invalid_json"
+	codeValidation           = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeTwoFactorRequired    = "two_factor_required"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codeVersionConflict      = "version_conflict"
+	codeDuplicate            = "possible_duplicate"
+	codeIdempotencyReuse     = "idempotency_key_reused"
+	codePreconditionFailed   = "precondition_failed"
+	codePreconditionRequired = "precondition_required"
+	codeTooLarge             = "payload_too_large"
+	codeQuotaExceeded        = "quota_exceeded"
+	codeMaintenance          = "maintenance"
+	codeReadOnly             = "read_only"
+	codeNotConfigured        = "not_configured"
+	codeUpstream             = "upstream_error"
+	codeDatabase             = "database_error"
+	codeInternal             = "internal_error"
+)
+
+// problemContentType is the media type of RFC 7807 problem details.
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes a code to form the problem's type URI.
+const problemTypeBase = "urn:subscription-tracker:problem:"
+
+// statusCodes is the code used for a status when a handler doesn't name
+// a more specific one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusConflict:              codeConflict,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusBadGateway:            codeUpstream,
+	http.StatusServiceUnavailable:    codeNotConfigured,
+}
+
+// problem is an RFC 7807 problem details object. Extra holds extension
+// members, which are written alongside the standard ones.
+type problem struct {
+	Status int
+	Code   string
+	Detail string
+	Extra  map[string]any
+}
+
+func (p problem) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(p.Extra)+5)
+	for k, v := range p.Extra {
+		out[k] = v
+	}
+	out["type"] = problemTypeBase + p.Code
+	out["title"] = http.StatusText(p.Status)
+	out["status"] = p.Status
+	out["code"] = p.Code
+	if p.Detail != "" {
+		out["detail"] = p.Detail
+	}
+	return json.Marshal(out)
+}
+
+// writeProblem sends p as the response.
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Code == "" {
+		p.Code = codeFor(p.Status)
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", problemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError sends a problem with the given status, code and detail.
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeProblem(w, problem{Status: status, Code: code, Detail: detail})
+}
+
+func codeFor(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return codeInternal
+	}
+	return codeBadRequest
+}
+
+// problemHandler answers every request with status, for the router's
+// not-found and method-not-allowed cases.
+func problemHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, problem{Status: status})
+	})
+}
+
+// problemMiddleware rewrites plain-text error responses, such as those from
+// http.Error in code outside this package, as problems, so API clients only
+// ever have to parse one error format.
+func problemMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		if pw.status != 0 {
+			writeProblem(w, problem{Status: pw.status, Detail: strings.TrimSpace(pw.body.String())})
+		}
+	})
+}
+
+// problemWriter passes a response through unless it is a plain-text error,
+// whose status and body it holds back for problemMiddleware.
+type problemWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (p *problemWriter) WriteHeader(code int) {
+	if p.wroteHeader {
+		return
+	}
+	p.wroteHeader = true
+	if code >= 400 && strings.HasPrefix(p.Header().Get("Content-Type"), "text/plain") {
+		p.status = code
+		return
+	}
+	p.ResponseWriter.WriteHeader(code)
+}
+
+func (p *problemWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	if p.status != 0 {
+		return p.body.Write(b)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (p *problemWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
@@ -0,0 +1,514 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+)
+
+// pushTimeout bounds each push, which is sent while the alert is raised.
+const pushTimeout = 10 * time.Second
+
+// pushChannels are the channels a user can turn on, in the order they're
+// listed.
+var pushChannels = []string{notify.ChannelWebPush, notify.ChannelNtfy, notify.ChannelGotify, notify.ChannelSlack, notify.ChannelDiscord}
+
+// alertTitles head the push for each alert kind.
+var alertTitles = map[string]string{
+	models.AlertUnknownRecurringCharge: "New recurring charge",
+	models.AlertChargeAmountMismatch:   "Unexpected charge amount",
+	models.AlertStaleSubscription:      "Subscription needs checking",
+	models.AlertPriceIncreased:         "Price increase",
+	models.AlertBudgetExceeded:         "Budget exceeded",
+	models.AlertTrialEnded:             "Trial ended",
+}
+
+// notificationSettings is the body of GET /api/me/notifications.
+// VAPIDPublicKey is what browsers subscribe to Web Push with, and is empty
+// when the server has no VAPID keys.
+type notificationSettings struct {
+	VAPIDPublicKey string                       `json:"vapidPublicKey,omitempty"`
+	Channels       []models.NotificationChannel `json:"channels"`
+}
+
+// channelRequest is the body of PUT /api/me/notifications/{channel}.
+// Fields left out keep their current value; an empty token removes it.
+type channelRequest struct {
+	Enabled *bool   `json:"enabled"`
+	URL     *string `json:"url"`
+	Token   *string `json:"token"`
+}
+
+// pushSubscriptionRequest is the body of POST /api/me/push-subscriptions,
+// as a browser's PushSubscription.toJSON() gives it.
+type pushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// channelSetting is a stored notification_settings row; token is kept
+// for sending.
+type channelSetting struct {
+	models.NotificationChannel
+	token string
+}
+
+// channelSettings reads the user's setting for every channel, with the
+// ones never set turned off.
+func (a *App) channelSettings(ctx context.Context, userID int) ([]channelSetting, error) {
+	stored := map[string]channelSetting{}
+	rows, err := a.db.QueryContext(ctx, "SELECT channel, enabled, url, token FROM notification_settings WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s channelSetting
+		var target, token sql.NullString
+		if err := rows.Scan(&s.Channel, &s.Enabled, &target, &token); err != nil {
+			return nil, err
+		}
+		s.URL, s.token, s.HasToken = target.String, token.String, token.String != ""
+		stored[s.Channel] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	settings := make([]channelSetting, len(pushChannels))
+	for i, c := range pushChannels {
+		settings[i] = stored[c]
+		settings[i].Channel = c
+	}
+	return settings, nil
+}
+
+// getNotificationSettings lists the caller's push channels.
+func (a *App) getNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Notification settings need a database")
+		return
+	}
+	settings, err := a.channelSettings(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	resp := notificationSettings{Channels: make([]models.NotificationChannel, len(settings))}
+	if _, ok := a.channels[notify.ChannelWebPush]; ok {
+		resp.VAPIDPublicKey = a.config.VAPIDPublicKey
+	}
+	for i, s := range settings {
+		resp.Channels[i] = s.NotificationChannel
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// putNotificationChannel changes the caller's setting for one channel.
+// ntfy needs a topic URL, Gotify a server URL and application token, and
+// Slack and Discord an incoming webhook URL before they can be turned on;
+// Web Push needs the server's VAPID keys, and is sent to the browsers
+// registered under /api/me/push-subscriptions.
+func (a *App) putNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Notification settings need a database")
+		return
+	}
+	channel := mux.Vars(r)["channel"]
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	uid := userID(r)
+	settings, err := a.channelSettings(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	var s *channelSetting
+	for i := range settings {
+		if settings[i].Channel == channel {
+			s = &settings[i]
+		}
+	}
+	if s == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown notification channel")
+		return
+	}
+
+	var errs fieldErrors
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+	if channel == notify.ChannelWebPush {
+		if req.URL != nil {
+			errs.add("url", "is not used by webpush")
+		}
+		if req.Token != nil {
+			errs.add("token", "is not used by webpush")
+		}
+		if _, ok := a.channels[notify.ChannelWebPush]; s.Enabled && !ok {
+			errs.add("enabled", "Web Push isn't configured on this server")
+		}
+	} else {
+		if req.URL != nil {
+			s.URL = *req.URL
+		}
+		if req.Token != nil {
+			s.token = *req.Token
+		}
+		// Slack and Discord webhook URLs are the secret, so they must
+		// go over TLS.
+		chat := channel == notify.ChannelSlack || channel == notify.ChannelDiscord
+		if u, err := url.Parse(s.URL); s.URL == "" && s.Enabled {
+			errs.add("url", "is required to turn the channel on")
+		} else if chat && s.URL != "" && (err != nil || u.Scheme != "https" || u.Host == "") {
+			errs.add("url", "must be an absolute https URL")
+		} else if s.URL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			errs.add("url", "must be an absolute http or https URL")
+		}
+		if chat && req.Token != nil {
+			errs.add("token", "is not used by "+channel)
+		}
+		if channel == notify.ChannelGotify && s.token == "" && s.Enabled {
+			errs.add("token", "is required to turn the channel on")
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	_, err = a.db.ExecContext(r.Context(), `
+		INSERT INTO notification_settings (user_id, channel, enabled, url, token, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+		ON CONFLICT (user_id, channel) DO UPDATE SET
+			enabled = excluded.enabled, url = excluded.url, token = excluded.token, updated_at = excluded.updated_at
+	`, uid, channel, s.Enabled, s.URL, s.token, a.dbNow())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	s.HasToken = s.token != ""
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.NotificationChannel); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// createPushSubscription registers a browser for Web Push and turns the
+// channel on. Registering an endpoint that's already known replaces its
+// keys and moves it to the caller.
+func (a *App) createPushSubscription(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.channels[notify.ChannelWebPush]; !ok || a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Web Push isn't configured")
+		return
+	}
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	var errs fieldErrors
+	if u, err := url.Parse(req.Endpoint); req.Endpoint == "" {
+		errs.add("endpoint", "is required")
+	} else if err != nil || u.Scheme != "https" || u.Host == "" {
+		errs.add("endpoint", "must be an absolute https URL")
+	}
+	// The keys are URL-safe base64: an uncompressed P-256 point and a
+	// 16-byte secret. Browsers leave off the padding, but accept it.
+	if key, err := pushKey(req.Keys.P256dh); err != nil || len(key) != 65 {
+		errs.add("keys.p256dh", "must be a base64 P-256 public key")
+	}
+	if secret, err := pushKey(req.Keys.Auth); err != nil || len(secret) != 16 {
+		errs.add("keys.auth", "must be a base64 16-byte secret")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	uid := userID(r)
+	sub := models.PushSubscription{Endpoint: req.Endpoint}
+	var createdAt time.Time
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE SET user_id = excluded.user_id, p256dh = excluded.p256dh, auth = excluded.auth
+		RETURNING id, created_at
+	`, uid, req.Endpoint, req.Keys.P256dh, req.Keys.Auth, a.dbNow()).Scan(&sub.ID, &createdAt)
+	if err == nil {
+		_, err = a.db.ExecContext(r.Context(), `
+			INSERT INTO notification_settings (user_id, channel, enabled, updated_at) VALUES ($1, $2, TRUE, $3)
+			ON CONFLICT (user_id, channel) DO UPDATE SET enabled = TRUE, updated_at = excluded.updated_at
+		`, uid, notify.ChannelWebPush, a.dbNow())
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	sub.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func pushKey(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+func (a *App) getPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Notification settings need a database")
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), "SELECT id, endpoint, created_at FROM push_subscriptions WHERE user_id = $1 ORDER BY id", userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	subs := []models.PushSubscription{}
+	for rows.Next() {
+		var sub models.PushSubscription
+		var createdAt time.Time
+		if err := rows.Scan(&sub.ID, &sub.Endpoint, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		sub.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) deletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Notification settings need a database")
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM push_subscriptions WHERE id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Push subscription not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushTarget is one place a push goes: a channel's destination, or for
+// Web Push one registered browser.
+type pushTarget struct {
+	channel string
+	subID   int
+	dest    notify.Destination
+}
+
+// pushTargets expands the settings into where to send, skipping channels
+// this server can't send on.
+func (a *App) pushTargets(ctx context.Context, userID int, settings []channelSetting) ([]pushTarget, error) {
+	var targets []pushTarget
+	for _, s := range settings {
+		if a.channels[s.Channel] == nil {
+			continue
+		}
+		if s.Channel != notify.ChannelWebPush {
+			targets = append(targets, pushTarget{channel: s.Channel, dest: notify.Destination{URL: s.URL, Token: s.token}})
+			continue
+		}
+		err := eachRow(ctx, a.db, "SELECT id, endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = $1 ORDER BY id", userID, func(rows *sql.Rows) error {
+			t := pushTarget{channel: s.Channel}
+			if err := rows.Scan(&t.subID, &t.dest.URL, &t.dest.P256dh, &t.dest.Auth); err != nil {
+				return err
+			}
+			targets = append(targets, t)
+			return nil
+		})
+		if err != nil {
+			return targets, err
+		}
+	}
+	return targets, nil
+}
+
+// publish sends msg to one target. A browser whose push subscription has
+// gone is forgotten.
+func (a *App) publish(ctx context.Context, t pushTarget, msg notify.Push) error {
+	pctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	err := a.channels[t.channel].Publish(pctx, t.dest, msg)
+	cancel()
+	if errors.Is(err, notify.ErrGone) {
+		// The push service answered; the browser has unsubscribed.
+		a.integrations.report("web_push", nil)
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE id = $1", t.subID); err != nil {
+			a.logger.WarnContext(ctx, "removing push subscription", "subscription", t.subID, "err", err)
+		}
+		return err
+	}
+	if t.channel == notify.ChannelWebPush {
+		a.integrations.report("web_push", err)
+	}
+	return err
+}
+
+// pushAlert sends a new alert to each channel the user has turned on.
+// Like the notifier, failures are only logged: the alert is already in the
+// feed.
+func (a *App) pushAlert(ctx context.Context, userID int, alert models.Alert) {
+	settings, err := a.channelSettings(ctx, userID)
+	if err != nil {
+		a.logger.WarnContext(ctx, "reading notification settings", "user", userID, "err", err)
+		return
+	}
+	settings = slices.DeleteFunc(settings, func(s channelSetting) bool { return !s.Enabled })
+	targets, err := a.pushTargets(ctx, userID, settings)
+	if err != nil {
+		a.logger.WarnContext(ctx, "reading push subscriptions", "user", userID, "err", err)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	title, ok := alertTitles[alert.Kind]
+	if !ok {
+		title = "Subscription alert"
+	}
+	msg := notify.Push{Title: title, Body: alert.Message, Tag: "alert-" + strconv.Itoa(alert.ID)}
+	for _, t := range targets {
+		if err := a.publish(ctx, t, msg); err != nil && !errors.Is(err, notify.ErrGone) {
+			a.logger.WarnContext(ctx, "pushing alert", "alert", alert.ID, "channel", t.channel, "err", err)
+		}
+	}
+}
+
+// testNotificationRequest is the body of POST /api/notifications/test.
+// An empty channel means every channel that's turned on.
+type testNotificationRequest struct {
+	Channel string `json:"channel"`
+}
+
+// pushResult is how sending to one target went. Endpoint is the browser's,
+// for Web Push.
+type pushResult struct {
+	Channel   string `json:"channel"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// testNotification sends a sample message so the caller can check a
+// channel is set up right, even before turning it on. The response lists
+// each delivery; a failure there still answers 200.
+func (a *App) testNotification(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Notification settings need a database")
+		return
+	}
+	var req testNotificationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+			return
+		}
+	}
+
+	uid := userID(r)
+	settings, err := a.channelSettings(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if req.Channel != "" && !slices.Contains(pushChannels, req.Channel) {
+		writeValidationErrors(w, fieldErrors{{"channel", fmt.Sprintf("must be one of %s", strings.Join(pushChannels, ", "))}})
+		return
+	}
+	settings = slices.DeleteFunc(settings, func(s channelSetting) bool {
+		if req.Channel != "" {
+			return s.Channel != req.Channel
+		}
+		return !s.Enabled
+	})
+	targets, err := a.pushTargets(r.Context(), uid, settings)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	targets = slices.DeleteFunc(targets, func(t pushTarget) bool { return t.dest.URL == "" })
+	if len(targets) == 0 {
+		msg := "no channel is turned on"
+		if req.Channel != "" {
+			msg = "has nowhere to send to yet"
+		}
+		writeValidationErrors(w, fieldErrors{{"channel", msg}})
+		return
+	}
+
+	msg := notify.Push{
+		Title:  "Test notification",
+		Body:   "Subscription Tracker alerts and reminders will arrive here.",
+		Tag:    "test",
+		Fields: []notify.PushField{{Name: "Sent", Value: a.clock.Now().UTC().Format("January 2, 15:04 UTC")}},
+	}
+	results := make([]pushResult, len(targets))
+	for i, t := range targets {
+		results[i] = pushResult{Channel: t.channel, Delivered: true}
+		if t.channel == notify.ChannelWebPush {
+			results[i].Endpoint = t.dest.URL
+		}
+		if err := a.publish(r.Context(), t, msg); err != nil {
+			results[i].Delivered, results[i].Error = false, err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
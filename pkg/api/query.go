@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+// parseSort turns a sort parameter like "cost:desc,name" into sort keys.
+// Only fields in allowed are accepted.
+func parseSort(param string, allowed map[string]bool) ([]store.SortKey, error) {
+	if param == "" {
+		return nil, nil
+	}
+
+	var keys []store.SortKey
+	for _, field := range strings.Split(param, ",") {
+		name, dir, _ := strings.Cut(strings.TrimSpace(field), ":")
+		if !allowed[name] {
+			return nil, fmt.Errorf("cannot sort by %q", name)
+		}
+		switch strings.ToLower(dir) {
+		case "", "asc":
+			keys = append(keys, store.SortKey{Field: name})
+		case "desc":
+			keys = append(keys, store.SortKey{Field: name, Desc: true})
+		default:
+			return nil, fmt.Errorf("sort direction for %s must be asc or desc", name)
+		}
+	}
+	return keys, nil
+}
+
+// asOf is the date the request's date-driven figures are computed for:
+// midnight UTC on ?asOf=YYYY-MM-DD, or else the clock's time. given
+// reports whether the request asked for a date. It writes a 400 and
+// returns false if asOf isn't a date.
+func (a *App) asOf(w http.ResponseWriter, r *http.Request) (at time.Time, given, ok bool) {
+	v := r.URL.Query().Get("asOf")
+	if v == "" {
+		return a.clock.Now(), false, true
+	}
+	day, err := time.Parse(dateLayout, v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "asOf must be a YYYY-MM-DD date")
+		return time.Time{}, false, false
+	}
+	return day, true, true
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	QuotaSubscriptions    = "subscriptions"
+	QuotaAttachmentBytes  = "attachmentBytes"
+	QuotaWebhookEndpoints = "webhookEndpoints"
+)
+
+func (a *App) countSubscriptions(ctx context.Context, userID int) (int64, error) {
+	return a.subscriptions.Count(ctx, userID)
+}
+
+// QuotaStatus is one line of the limits report. Limit is nil when the
+// resource is unlimited.
+type QuotaStatus struct {
+	Limit *int64 `json:"limit"`
+	Used  int64  `json:"used"`
+}
+
+// quotaStatus reports the user's usage of a resource. Limits apply to each
+// user separately.
+func (a *App) quotaStatus(ctx context.Context, userID int, resource string) (QuotaStatus, error) {
+	var status QuotaStatus
+	if limit := a.config.QuotaLimits[resource]; limit > 0 {
+		status.Limit = &limit
+	}
+	if count, ok := a.quotaUsage[resource]; ok {
+		used, err := count(ctx, userID)
+		if err != nil {
+			return status, err
+		}
+		status.Used = used
+	}
+	return status, nil
+}
+
+// checkQuota writes a 403 and returns false when adding n more units of the
+// resource would exceed the user's limit.
+func (a *App) checkQuota(ctx context.Context, w http.ResponseWriter, userID int, resource string, n int64) bool {
+	status, err := a.quotaStatus(ctx, userID, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return false
+	}
+	if status.Limit == nil || status.Used+n <= *status.Limit {
+		return true
+	}
+	writeQuotaExceeded(w, resource, status)
+	return false
+}
+
+// subscriptionLimit is the subscription quota for the store to enforce as
+// it creates subscriptions, or 0 if there's none. checkQuota only gives
+// the early answer: two creates can both pass it with one left.
+func (a *App) subscriptionLimit() int64 {
+	return max(a.config.QuotaLimits[QuotaSubscriptions], 0)
+}
+
+// writeLimitReached sends the 403 for a create the store turned down with
+// store.ErrLimitReached.
+func (a *App) writeLimitReached(ctx context.Context, w http.ResponseWriter, userID int, resource string) {
+	status, err := a.quotaStatus(ctx, userID, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	writeQuotaExceeded(w, resource, status)
+}
+
+// writeQuotaExceeded sends the 403 for going over the resource's limit.
+func writeQuotaExceeded(w http.ResponseWriter, resource string, status QuotaStatus) {
+	writeProblem(w, problem{
+		Status: http.StatusForbidden,
+		Code:   codeQuotaExceeded,
+		Detail: fmt.Sprintf("Quota exceeded for %s: limit is %d", resource, *status.Limit),
+		Extra: map[string]any{
+			"resource": resource,
+			"limit":    *status.Limit,
+			"used":     status.Used,
+		},
+	})
+}
+
+// getLimits reports every quota with the user's current usage.
+func (a *App) getLimits(w http.ResponseWriter, r *http.Request) {
+	limits := map[string]QuotaStatus{}
+	for resource := range a.config.QuotaLimits {
+		status, err := a.quotaStatus(r.Context(), userID(r), resource)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		limits[resource] = status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(limits); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// amountTolerance absorbs rounding on statements before a charge counts as a
+// mismatch.
+const amountTolerance models.Money = 1
+
+// reconcile fills in the amounts and flags for an item whose expected and
+// actual charges are already set.
+func reconcile(item *models.ReconciliationItem, cost models.Money) {
+	item.ExpectedAmount = cost * models.Money(len(item.ExpectedCharges))
+	for _, c := range item.ActualCharges {
+		item.ActualAmount += c.Amount
+	}
+
+	expected, actual := len(item.ExpectedCharges), len(item.ActualCharges)
+	if actual < expected {
+		item.Flags = append(item.Flags, models.FlagMissedCharge)
+	}
+	if actual > expected {
+		item.Flags = append(item.Flags, models.FlagDoubleCharge)
+	}
+	for _, c := range item.ActualCharges {
+		if (c.Amount - cost).Abs() > amountTolerance {
+			item.Flags = append(item.Flags, models.FlagAmountMismatch)
+			break
+		}
+	}
+}
+
+// getReconciliation compares what each subscription should have charged in
+// a month against the transactions matched to it. Nothing is expected
+// before a subscription was added or once it was cancelled.
+func (a *App) getReconciliation(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	from, err := time.Parse("2006-01", month)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "month must be in YYYY-MM format")
+		return
+	}
+	to := from.AddDate(0, 1, -1)
+
+	uid := userID(r)
+	subs, err := a.loadMatchableSubscriptions(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	charges := map[int][]models.ActualCharge{}
+	txRows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, subscription_id, posted_on, amount_cents
+		FROM transactions
+		WHERE user_id = $1 AND match_status = $2 AND posted_on BETWEEN $3 AND $4
+		ORDER BY posted_on
+	`, uid, models.MatchStatusMatched, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer txRows.Close()
+
+	for txRows.Next() {
+		var c models.ActualCharge
+		var subscriptionID int
+		var posted time.Time
+		if err := txRows.Scan(&c.TransactionID, &subscriptionID, &posted, &c.Amount); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Row scan error: %v", err))
+			return
+		}
+		c.Date = posted.Format(dateLayout)
+		c.Amount = c.Amount.Abs()
+		charges[subscriptionID] = append(charges[subscriptionID], c)
+	}
+
+	report := models.ReconciliationReport{
+		Month:   month,
+		Summary: map[string]int{models.FlagMissedCharge: 0, models.FlagDoubleCharge: 0, models.FlagAmountMismatch: 0},
+		Items:   []models.ReconciliationItem{},
+	}
+	for _, s := range subs {
+		item := models.ReconciliationItem{
+			SubscriptionID:  s.ID,
+			Name:            s.Name,
+			ExpectedCharges: []string{},
+			ActualCharges:   charges[s.ID],
+			Flags:           []string{},
+		}
+		if item.ActualCharges == nil {
+			item.ActualCharges = []models.ActualCharge{}
+		}
+		if first, last, ok := s.billableBetween(from, to); ok {
+			for _, d := range billingDatesBetween(s.NextBilling, s.BillingCycle, first, last) {
+				item.ExpectedCharges = append(item.ExpectedCharges, d.Format(dateLayout))
+			}
+		}
+		if len(item.ExpectedCharges) == 0 && len(item.ActualCharges) == 0 {
+			continue
+		}
+
+		reconcile(&item, s.Cost)
+		for _, f := range item.Flags {
+			report.Summary[f]++
+		}
+		report.ExpectedTotal += item.ExpectedAmount
+		report.ActualTotal += item.ActualAmount
+		report.Items = append(report.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
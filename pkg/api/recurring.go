@@ -0,0 +1,312 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// recurringCycles are the billing cycles recurring charges are checked
+// against, and recurringTolerance how many days a typical gap between
+// charges may be off one.
+var recurringCycles = []string{"weekly", "monthly", "quarterly", "yearly"}
+
+const recurringTolerance = 4
+
+// recurringCharge is a run of charges from one merchant, for about the
+// same amount, at a regular billing cycle, none of them linked to a
+// subscription yet. Amount and Description are from the latest charge.
+type recurringCharge struct {
+	Merchant       string       `json:"merchant"`
+	Description    string       `json:"description"`
+	Amount         models.Money `json:"amount"`
+	BillingCycle   string       `json:"billingCycle"`
+	Charges        int          `json:"charges"`
+	FirstCharged   string       `json:"firstCharged"`
+	LastCharged    string       `json:"lastCharged"`
+	TransactionIDs []int        `json:"transactionIds"`
+	Suggestion     suggestion   `json:"suggestion"`
+}
+
+// suggestion is what to do about a recurringCharge: "match" it to the
+// tracked subscription that best fits its latest charge, or "create" a
+// new one, with Subscription a draft to POST to /api/subscriptions.
+type suggestion struct {
+	Action         string             `json:"action"`
+	SubscriptionID *int               `json:"subscriptionId,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	Score          float64            `json:"score,omitempty"`
+	Subscription   *draftSubscription `json:"subscription,omitempty"`
+}
+
+type draftSubscription struct {
+	Name         string       `json:"name"`
+	Category     string       `json:"category"`
+	Cost         models.Money `json:"cost"`
+	Currency     string       `json:"currency"`
+	BillingCycle string       `json:"billingCycle"`
+	NextBilling  string       `json:"nextBilling"`
+}
+
+// getRecurringCharges analyzes the transactions that aren't linked to a
+// subscription, unmatched or waiting for review, for recurring charges,
+// and suggests a subscription for each. Charges are grouped by merchant
+// as the matcher normalizes it; within a merchant, only charges close in
+// amount to the latest count, so a one-off purchase doesn't break the
+// cycle.
+func (a *App) getRecurringCharges(w http.ResponseWriter, r *http.Request) {
+	uid := userID(r)
+	charges, err := a.recurringCharges(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(charges); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) recurringCharges(ctx context.Context, userID int) ([]recurringCharge, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+		FROM transactions
+		WHERE user_id = $1 AND match_status <> $2 AND amount_cents < 0
+		ORDER BY posted_on, id
+	`, userID, models.MatchStatusMatched)
+	if err != nil {
+		return nil, err
+	}
+	byMerchant := map[string][]models.Transaction{}
+	var merchants []string
+	for rows.Next() {
+		var t models.Transaction
+		if err := scanTransaction(rows, &t); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		merchant := normalizeMerchant(t.Description)
+		if merchant == "" {
+			continue
+		}
+		if _, ok := byMerchant[merchant]; !ok {
+			merchants = append(merchants, merchant)
+		}
+		byMerchant[merchant] = append(byMerchant[merchant], t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	subs, err := a.loadMatchableSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := a.userCurrency(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, s := range subs {
+		names[s.ID] = s.Name
+	}
+
+	charges := []recurringCharge{}
+	for _, merchant := range merchants {
+		group := byMerchant[merchant]
+		latest := group[len(group)-1]
+		var run []models.Transaction
+		var dates []time.Time
+		for _, t := range group {
+			if amountSimilarity(t.Amount.Abs(), latest.Amount.Abs()) >= 0.75 {
+				posted, _ := time.Parse(dateLayout, t.Date)
+				run = append(run, t)
+				dates = append(dates, posted)
+			}
+		}
+		cycle := recurringCycle(dates)
+		if cycle == "" {
+			continue
+		}
+
+		c := recurringCharge{
+			Merchant:     merchant,
+			Description:  latest.Description,
+			Amount:       latest.Amount.Abs(),
+			BillingCycle: cycle,
+			Charges:      len(run),
+			FirstCharged: run[0].Date,
+			LastCharged:  latest.Date,
+		}
+		for _, t := range run {
+			c.TransactionIDs = append(c.TransactionIDs, t.ID)
+		}
+		ranked, err := rankMatches(latest, subs)
+		if err != nil {
+			return nil, err
+		}
+		if len(ranked) > 0 && ranked[0].score >= reviewScore {
+			id := ranked[0].subscriptionID
+			c.Suggestion = suggestion{Action: "match", SubscriptionID: &id, Name: names[id], Score: math.Round(ranked[0].score*100) / 100}
+		} else {
+			next, _ := addCycle(dates[len(dates)-1], cycle, 1)
+			for today := models.DateOf(a.clock.Now()).Time(); next.Before(today); {
+				next, _ = addCycle(next, cycle, 1)
+			}
+			c.Suggestion = suggestion{Action: "create", Subscription: &draftSubscription{
+				Name:         merchantName(merchant),
+				Category:     "Other",
+				Cost:         latest.Amount.Abs(),
+				Currency:     currency,
+				BillingCycle: cycle,
+				NextBilling:  next.Format(dateLayout),
+			}}
+		}
+		charges = append(charges, c)
+	}
+	slices.SortStableFunc(charges, func(x, y recurringCharge) int { return cmp.Compare(y.LastCharged, x.LastCharged) })
+	return charges, nil
+}
+
+// recurringCycle is the billing cycle the median gap between dates,
+// sorted, lands within recurringTolerance days of, or "" if there are
+// fewer than two dates or no cycle fits.
+func recurringCycle(dates []time.Time) string {
+	if len(dates) < 2 {
+		return ""
+	}
+	gaps := make([]int, len(dates)-1)
+	for i := 1; i < len(dates); i++ {
+		gaps[i-1] = absDays(dates[i].Sub(dates[i-1]))
+	}
+	slices.Sort(gaps)
+	gap := gaps[len(gaps)/2]
+	for _, cycle := range recurringCycles {
+		if math.Abs(float64(gap-cycleDays(cycle))) <= recurringTolerance {
+			return cycle
+		}
+	}
+	return ""
+}
+
+// merchantName makes a normalized merchant into a subscription name,
+// such as "Spotify" for "spotify".
+func merchantName(merchant string) string {
+	words := strings.Fields(merchant)
+	for i, w := range words {
+		first, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(first)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+type linkTransactionsRequest struct {
+	TransactionIDs []int `json:"transactionIds"`
+	SubscriptionID int   `json:"subscriptionId"`
+}
+
+// linkTransactions links transactions to one of the user's subscriptions,
+// as accepting a match would, typically the run of charges a recurring
+// charge suggestion was made for. Pending match candidates are accepted or
+// rejected to agree, and the unknown recurring charge alerts raised for
+// the transactions are dismissed. It returns the linked transactions.
+func (a *App) linkTransactions(w http.ResponseWriter, r *http.Request) {
+	var req linkTransactionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	var errs fieldErrors
+	if len(req.TransactionIDs) == 0 {
+		errs.add("transactionIds", "is required")
+	}
+	if req.SubscriptionID <= 0 {
+		errs.add("subscriptionId", "is required")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	ctx, uid := r.Context(), userID(r)
+	if _, err := a.subscriptions.Get(ctx, uid, req.SubscriptionID); err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for _, id := range req.TransactionIDs {
+		var n int
+		if err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM transactions WHERE id = $1 AND user_id = $2", id, uid).Scan(&n); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if n == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Transaction %d not found", id))
+			return
+		}
+	}
+
+	subs, err := a.loadMatchableSubscriptions(ctx, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	linked := []models.Transaction{}
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(req.TransactionIDs))) {
+		t, err := a.linkTransaction(ctx, uid, id, req.SubscriptionID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if err := a.checkUnexpectedCharge(ctx, uid, t, subs); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Alert error: %v", err))
+			return
+		}
+		linked = append(linked, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(linked); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// linkTransaction links one transaction for linkTransactions.
+func (a *App) linkTransaction(ctx context.Context, userID, transactionID, subscriptionID int) (models.Transaction, error) {
+	var t models.Transaction
+	if _, err := a.db.ExecContext(ctx, `
+		UPDATE match_candidates SET status = CASE WHEN subscription_id = $1 THEN 'accepted' ELSE 'rejected' END
+		WHERE transaction_id = $2 AND status = 'pending'
+	`, subscriptionID, transactionID); err != nil {
+		return t, err
+	}
+	err := scanTransaction(a.db.QueryRowContext(ctx, `
+		UPDATE transactions SET subscription_id = $1, match_status = $2 WHERE id = $3
+		RETURNING id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+	`, subscriptionID, models.MatchStatusMatched, transactionID), &t)
+	if err != nil {
+		return t, err
+	}
+	if _, err := a.db.ExecContext(ctx, `
+		UPDATE alerts SET dismissed = TRUE
+		WHERE user_id = $1 AND kind = $2 AND transaction_id = $3
+	`, userID, models.AlertUnknownRecurringCharge, transactionID); err != nil {
+		return t, err
+	}
+	return t, a.markVerifiedByCharge(ctx, userID, t)
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"slices"
+	texttemplate "text/template"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/notify"
+	"subscription-tracker/pkg/store"
+)
+
+// maxReminderDays is the furthest ahead a reminder can be set.
+const maxReminderDays = 30
+
+// notificationRenewalReminder is the notifications kind for reminder mail.
+// Reminders sent to a chat channel are recorded as this, an underscore and
+// the channel, so each channel is only sent one.
+const notificationRenewalReminder = "renewal_reminder"
+
+// reminderChannels are the notification channels renewal reminders are
+// sent to besides email.
+var reminderChannels = []string{notify.ChannelSlack, notify.ChannelDiscord}
+
+//go:embed templates
+var templateFiles embed.FS
+
+var (
+	reminderHTML = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/reminder.html"))
+	reminderText = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/reminder.txt"))
+)
+
+// reminderSetting is the body of GET and PUT /api/subscriptions/{id}/reminder.
+// A null daysBefore means no reminder.
+type reminderSetting struct {
+	DaysBefore *int `json:"daysBefore"`
+}
+
+func (a *App) getReminder(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	var setting reminderSetting
+	var days int
+	err := a.db.QueryRowContext(r.Context(), "SELECT days_before FROM reminders WHERE subscription_id = $1 AND user_id = $2", id, userID(r)).Scan(&days)
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if err == nil {
+		setting.DaysBefore = &days
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(setting); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// setReminder turns on a reminder email daysBefore days ahead of each
+// renewal, or changes how far ahead it is sent.
+func (a *App) setReminder(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	var setting reminderSetting
+	if err := json.NewDecoder(r.Body).Decode(&setting); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	var errs fieldErrors
+	if setting.DaysBefore == nil {
+		errs.add("daysBefore", "is required; DELETE the reminder to turn it off")
+	} else if *setting.DaysBefore < 0 || *setting.DaysBefore > maxReminderDays {
+		errs.add("daysBefore", fmt.Sprintf("must be between 0 and %d", maxReminderDays))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	_, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO reminders (subscription_id, user_id, days_before) VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id) DO UPDATE SET days_before = excluded.days_before
+	`, id, userID(r), *setting.DaysBefore)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(setting); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) deleteReminder(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(), "DELETE FROM reminders WHERE subscription_id = $1 AND user_id = $2", id, userID(r)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartReminders sends renewal reminders now and then every
+// ReminderInterval, until ctx is cancelled.
+func (a *App) StartReminders(ctx context.Context) {
+	if a.config.ReminderInterval <= 0 || a.db == nil {
+		return
+	}
+
+	a.jobs.started("reminders", a.config.ReminderInterval)
+	go func() {
+		defer a.jobs.stopped("reminders")
+		ticker := time.NewTicker(a.config.ReminderInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.sendReminders(ctx); err != nil {
+				a.logger.Warn("sending renewal reminders", "err", err)
+			} else if n > 0 {
+				a.logger.Info("sent renewal reminders", "count", n)
+			}
+			a.jobs.ran("reminders")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sendReminders sends every reminder that is due: the subscription renews
+// between today and its days-before setting from now. It's mailed if mail
+// is configured, and posted to the user's Slack and Discord if they're
+// turned on, followed by the reminders to cancel. Each billing date is
+// claimed in the notifications table before sending, so overlapping runs
+// and restarts never send twice; a failed send gives the claim back for
+// the next run to retry. It returns how many were sent.
+func (a *App) sendReminders(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT r.subscription_id, r.user_id, r.days_before, u.email
+		FROM reminders r JOIN users u ON u.id = r.user_id
+		WHERE u.delete_after IS NULL
+		ORDER BY r.subscription_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	type reminder struct {
+		subscriptionID, userID, daysBefore int
+		email                              string
+	}
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.subscriptionID, &r.userID, &r.daysBefore, &r.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	chats := map[int][]pushTarget{}
+	now := a.clock.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	sent := 0
+	for _, r := range reminders {
+		s, err := a.subscriptions.Get(ctx, r.userID, r.subscriptionID)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+		if s.Status != models.StatusActive {
+			continue // not renewing
+		}
+		if s.NextBilling.IsZero() {
+			continue
+		}
+		date := s.NextBilling.Time()
+		daysLeft := int(date.Sub(today).Hours() / 24)
+		if daysLeft < 0 || daysLeft > r.daysBefore {
+			continue
+		}
+
+		data := reminderData{
+			Name:         s.Name,
+			Category:     s.Category,
+			Description:  s.Description,
+			Cost:         s.Cost.String(),
+			Currency:     s.Currency,
+			BillingCycle: s.BillingCycle,
+			Date:         date.Format("Monday, January 2"),
+			When:         whenText(daysLeft),
+			DaysBefore:   r.daysBefore,
+		}
+		n, err := a.deliverReminder(ctx, r.userID, s.ID, r.email, notificationRenewalReminder, date, func() (notify.Email, error) {
+			return renderReminder(data)
+		}, reminderPush(data), chats)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	n, err := a.sendCancelReminders(ctx, chats)
+	return sent + n, err
+}
+
+// deliverReminder mails a reminder of kind about the billing date to
+// email, if mail is configured, and posts push to the user's Slack and
+// Discord if they're turned on, each at most once. chats caches the
+// user's channels across calls. It returns how many were sent.
+func (a *App) deliverReminder(ctx context.Context, userID, subscriptionID int, email, kind string, date time.Time, render func() (notify.Email, error), push notify.Push, chats map[int][]pushTarget) (int, error) {
+	sent := 0
+	if _, noMail := a.mailer.(unconfigured); !noMail {
+		ok, err := a.sendReminderOnce(ctx, userID, subscriptionID, kind, date, func() error {
+			msg, err := render()
+			if err == nil {
+				msg.To = []string{email}
+				err = a.mailer.Send(ctx, msg)
+			}
+			a.integrations.report("smtp", err)
+			return err
+		})
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	targets, ok := chats[userID]
+	if !ok {
+		settings, err := a.channelSettings(ctx, userID)
+		if err != nil {
+			return sent, err
+		}
+		settings = slices.DeleteFunc(settings, func(s channelSetting) bool { return !s.Enabled || !slices.Contains(reminderChannels, s.Channel) })
+		if targets, err = a.pushTargets(ctx, userID, settings); err != nil {
+			return sent, err
+		}
+		chats[userID] = targets
+	}
+	for _, t := range targets {
+		ok, err := a.sendReminderOnce(ctx, userID, subscriptionID, kind+"_"+t.channel, date, func() error {
+			return a.publish(ctx, t, push)
+		})
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// sendReminderOnce claims the reminder of kind for a billing date and
+// sends it, giving the claim back if send fails. It reports whether it was
+// sent; an error is only returned for the claim.
+func (a *App) sendReminderOnce(ctx context.Context, userID, subscriptionID int, kind string, date time.Time, send func() error) (bool, error) {
+	var claim int
+	err := a.db.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, subscription_id, kind, billing_date)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (subscription_id, kind, billing_date) DO NOTHING
+		RETURNING id
+	`, userID, subscriptionID, kind, date.Format(dateLayout)).Scan(&claim)
+	if err == sql.ErrNoRows {
+		return false, nil // already sent
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := send(); err != nil {
+		a.logger.WarnContext(ctx, "sending renewal reminder", "subscription", subscriptionID, "kind", kind, "err", err)
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM notifications WHERE id = $1", claim); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// reminderData fills the reminder templates.
+type reminderData struct {
+	Name, Category, Description  string
+	Cost, Currency, BillingCycle string
+	Date, When                   string
+	DaysBefore                   int
+}
+
+func whenText(daysLeft int) string {
+	switch daysLeft {
+	case 0:
+		return "today"
+	case 1:
+		return "tomorrow"
+	}
+	return fmt.Sprintf("in %d days", daysLeft)
+}
+
+// reminderPush is a reminder as a chat message.
+func reminderPush(data reminderData) notify.Push {
+	msg := notify.Push{
+		Title: fmt.Sprintf("%s renews %s", data.Name, data.When),
+		Body:  fmt.Sprintf("%s %s will be billed on %s.", data.Cost, data.Currency, data.Date),
+		Tag:   "reminder",
+	}
+	msg.Fields = append(msg.Fields, notify.PushField{Name: "Amount", Value: fmt.Sprintf("%s %s %s", data.Cost, data.Currency, data.BillingCycle)})
+	if data.Category != "" {
+		msg.Fields = append(msg.Fields, notify.PushField{Name: "Category", Value: data.Category})
+	}
+	return msg
+}
+
+func renderReminder(data reminderData) (notify.Email, error) {
+	var text, html bytes.Buffer
+	if err := reminderText.Execute(&text, data); err != nil {
+		return notify.Email{}, err
+	}
+	if err := reminderHTML.Execute(&html, data); err != nil {
+		return notify.Email{}, err
+	}
+	return notify.Email{
+		Subject:  fmt.Sprintf("%s renews %s", data.Name, data.When),
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
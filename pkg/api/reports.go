@@ -0,0 +1,512 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// reportCategory is what one category was billed in a month, and how that
+// changed from the month before.
+type reportCategory struct {
+	Category string       `json:"category"`
+	Charges  int          `json:"charges"`
+	Total    models.Money `json:"total"`
+	Change   models.Money `json:"change"`
+	// ChangePercent is Change as a percentage of the month before, or nil
+	// if nothing was billed then.
+	ChangePercent *float64 `json:"changePercent"`
+}
+
+type reportMonth struct {
+	Month         string           `json:"month"`
+	Charges       int              `json:"charges"`
+	Total         models.Money     `json:"total"`
+	Change        models.Money     `json:"change"`
+	ChangePercent *float64         `json:"changePercent"`
+	ByCategory    []reportCategory `json:"byCategory"`
+}
+
+type monthlyReport struct {
+	Currency string        `json:"currency"`
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Total    models.Money  `json:"total"`
+	Months   []reportMonth `json:"months"`
+}
+
+// getMonthlyReport reports what was actually billed each month from ?from
+// to ?to, both YYYY-MM and by default the twelve months up to and
+// including the current one, in total and by category. Each month and
+// category is compared with the month before, the first one included.
+// Amounts are converted as getSpending converts them.
+func (a *App) getMonthlyReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := a.monthlyReport(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// monthlyReport builds the report getMonthlyReport describes for the
+// request. It answers the request itself and returns false if it can't.
+func (a *App) monthlyReport(w http.ResponseWriter, r *http.Request) (monthlyReport, bool) {
+	var report monthlyReport
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Spending reports need a database")
+		return report, false
+	}
+	now := a.clock.Now()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01", v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "to must be in YYYY-MM format")
+			return report, false
+		}
+		to = t
+	}
+	from := to.AddDate(0, 1-defaultSpendingMonths, 0)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01", v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "from must be in YYYY-MM format")
+			return report, false
+		}
+		from = t
+	}
+	months := monthsBetween(from, to) + 1
+	if months < 1 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "from must not be after to")
+		return report, false
+	}
+	if months > maxSpendingMonths {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("A report covers at most %d months", maxSpendingMonths))
+		return report, false
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return report, false
+	}
+
+	// The month before from is read too, to compare the first one with.
+	start := from.AddDate(0, -1, 0)
+	charges, unconverted, err := a.billedCharges(r.Context(), userID(r), currency, start, to.AddDate(0, 1, 0))
+	if unconverted != "" {
+		a.writeConversionError(w, unconverted, currency, err)
+		return report, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return report, false
+	}
+
+	billed := make([]map[string]*reportCategory, months+1)
+	for i := range billed {
+		billed[i] = map[string]*reportCategory{}
+	}
+	for _, ch := range charges {
+		i := monthsBetween(start, ch.billed.Time())
+		c := billed[i][ch.category]
+		if c == nil {
+			c = &reportCategory{Category: ch.category}
+			billed[i][ch.category] = c
+		}
+		c.Charges++
+		c.Total += ch.amount
+	}
+
+	report = monthlyReport{Currency: currency, From: from.Format("2006-01"), To: to.Format("2006-01"), Months: make([]reportMonth, months)}
+	var previous models.Money
+	for _, c := range billed[0] {
+		previous += c.Total
+	}
+	for i := range report.Months {
+		before, current := billed[i], billed[i+1]
+		m := reportMonth{Month: from.AddDate(0, i, 0).Format("2006-01"), ByCategory: []reportCategory{}}
+		// A category billed the month before but not this one is listed
+		// too, with nothing billed, so its drop shows.
+		for category, c := range before {
+			if c.Charges > 0 && current[category] == nil {
+				current[category] = &reportCategory{Category: c.Category}
+			}
+		}
+		for category, c := range current {
+			var was models.Money
+			if b := before[category]; b != nil {
+				was = b.Total
+			}
+			c.Change, c.ChangePercent = c.Total-was, changePercent(was, c.Total)
+			m.Charges += c.Charges
+			m.Total += c.Total
+			m.ByCategory = append(m.ByCategory, *c)
+		}
+		sort.Slice(m.ByCategory, func(x, y int) bool {
+			if m.ByCategory[x].Total != m.ByCategory[y].Total {
+				return m.ByCategory[x].Total > m.ByCategory[y].Total
+			}
+			return m.ByCategory[x].Category < m.ByCategory[y].Category
+		})
+		m.Change, m.ChangePercent = m.Total-previous, changePercent(previous, m.Total)
+		previous = m.Total
+		report.Total += m.Total
+		report.Months[i] = m
+	}
+	return report, true
+}
+
+// reportUpcomingDays is how far ahead the PDF report lists renewals.
+const reportUpcomingDays = 30
+
+// reportChartColors color the category chart's slices; categories past
+// the last are drawn together as Other.
+var reportChartColors = []rgb{
+	{0.22, 0.42, 0.69}, {0.90, 0.49, 0.13}, {0.30, 0.65, 0.33}, {0.80, 0.24, 0.24},
+	{0.55, 0.40, 0.72}, {0.55, 0.34, 0.29}, {0.89, 0.47, 0.76}, {0.60, 0.60, 0.60},
+}
+
+// getMonthlyReportPDF renders the monthly report as a PDF to download:
+// the months in a table, a chart of what each category cost over them and
+// the renewals due in the next reportUpcomingDays days, for filing with
+// an expense report. It takes the same parameters as getMonthlyReport.
+func (a *App) getMonthlyReportPDF(w http.ResponseWriter, r *http.Request) {
+	report, ok := a.monthlyReport(w, r)
+	if !ok {
+		return
+	}
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), store.SubscriptionQuery{Status: models.StatusActive})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	now := a.clock.Now()
+	doc := renderMonthlyReport(report, a.upcoming(subs, now, reportUpcomingDays), now)
+
+	// The document is rendered whole first, so a failure can still be
+	// answered with an error.
+	var body bytes.Buffer
+	if _, err := doc.WriteTo(&body); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("PDF rendering error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="spending-%s-to-%s.pdf"`, report.From, report.To))
+	w.Write(body.Bytes())
+}
+
+// renderMonthlyReport lays out the PDF getMonthlyReportPDF serves.
+func renderMonthlyReport(report monthlyReport, upcoming []models.Subscription, now time.Time) *pdfDocument {
+	const (
+		margin = 50.0
+		right  = pdfPageWidth - margin
+		bottom = pdfPageHeight - margin
+		row    = 16.0
+	)
+	rule := rgb{0.8, 0.8, 0.8}
+	monthName := func(month string) string {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return month
+		}
+		return t.Format("January 2006")
+	}
+
+	doc := newPDFDocument("Spending report", now)
+	doc.addPage()
+	y := 70.0
+	// fits starts a new page unless h more points fit on this one.
+	fits := func(h float64) {
+		if y+h > bottom {
+			doc.addPage()
+			y = 70
+		}
+	}
+	heading := func(s string) {
+		fits(row * 4)
+		y += 24
+		doc.text(margin, y, 13, true, s)
+		y += row + 4
+	}
+
+	doc.text(margin, y, 20, true, "Spending report")
+	y += 22
+	doc.text(margin, y, 11, false, fmt.Sprintf("%s – %s, in %s", monthName(report.From), monthName(report.To), report.Currency))
+	y += 16
+	doc.text(margin, y, 9, false, "Generated on "+now.Format("January 2, 2006"))
+	y += 26
+	doc.text(margin, y, 12, true, fmt.Sprintf("Billed in total: %s %s", report.Total, report.Currency))
+
+	heading("By month")
+	monthColumns := func(bold bool, month, charges, total, change, percent string) {
+		doc.text(margin, y, 10, bold, month)
+		doc.textRight(290, y, 10, bold, charges)
+		doc.textRight(390, y, 10, bold, total)
+		doc.textRight(470, y, 10, bold, change)
+		doc.textRight(right, y, 10, bold, percent)
+	}
+	monthColumns(true, "Month", "Charges", "Total", "Change", "Change %")
+	doc.line(margin, y+5, right, y+5, rule)
+	y += row + 2
+	for _, m := range report.Months {
+		fits(row)
+		percent := "–"
+		if m.ChangePercent != nil {
+			percent = fmt.Sprintf("%+.2f%%", *m.ChangePercent)
+		}
+		change := m.Change.String()
+		if m.Change > 0 {
+			change = "+" + change
+		}
+		monthColumns(false, monthName(m.Month), strconv.Itoa(m.Charges), m.Total.String(), change, percent)
+		y += row
+	}
+
+	heading("By category")
+	totals := map[string]models.Money{}
+	for _, m := range report.Months {
+		for _, c := range m.ByCategory {
+			totals[c.Category] += c.Total
+		}
+	}
+	var pie []reportCategory
+	for category, total := range totals {
+		if total > 0 {
+			pie = append(pie, reportCategory{Category: category, Total: total})
+		}
+	}
+	sort.Slice(pie, func(i, j int) bool {
+		if pie[i].Total != pie[j].Total {
+			return pie[i].Total > pie[j].Total
+		}
+		return pie[i].Category < pie[j].Category
+	})
+	if len(pie) > len(reportChartColors) {
+		other := reportCategory{Category: "Other"}
+		for _, c := range pie[len(reportChartColors)-1:] {
+			other.Total += c.Total
+		}
+		pie = append(pie[:len(reportChartColors)-1], other)
+	}
+	if len(pie) == 0 || report.Total <= 0 {
+		doc.text(margin, y, 10, false, "Nothing was billed in this period.")
+		y += row
+	} else {
+		const radius = 75.0
+		fits(2*radius + row)
+		cx, cy := margin+radius, y+radius-8
+		angle := 0.0
+		for i, c := range pie {
+			sweep := 2 * math.Pi * c.Total.Float() / report.Total.Float()
+			doc.sector(cx, cy, radius, angle, angle+sweep, reportChartColors[i])
+			angle += sweep
+		}
+		ly := y
+		for i, c := range pie {
+			name := c.Category
+			if name == "" {
+				name = "Uncategorized"
+			}
+			doc.rect(250, ly-8, 9, 9, reportChartColors[i])
+			doc.text(266, ly, 10, false, fitText(name, 10, 160))
+			doc.textRight(480, ly, 10, false, c.Total.String())
+			doc.textRight(right, ly, 10, false, fmt.Sprintf("%.1f%%", c.Total.Float()/report.Total.Float()*100))
+			ly += row
+		}
+		y = max(y+2*radius, ly)
+	}
+
+	heading(fmt.Sprintf("Renewals in the next %d days", reportUpcomingDays))
+	if len(upcoming) == 0 {
+		doc.text(margin, y, 10, false, fmt.Sprintf("No renewals in the next %d days.", reportUpcomingDays))
+		return doc
+	}
+	renewalColumns := func(bold bool, date, name, cycle, amount string) {
+		doc.text(margin, y, 10, bold, date)
+		doc.text(140, y, 10, bold, fitText(name, 10, 230))
+		doc.text(385, y, 10, bold, cycle)
+		doc.textRight(right, y, 10, bold, amount)
+	}
+	renewalColumns(true, "Date", "Subscription", "Cycle", "Amount")
+	doc.line(margin, y+5, right, y+5, rule)
+	y += row + 2
+	for _, s := range upcoming {
+		fits(row)
+		renewalColumns(false, s.NextBilling.Time().Format("Jan 2, 2006"), s.Name, s.BillingCycle, fmt.Sprintf("%s %s", s.Cost, s.Currency))
+		y += row
+	}
+	fits(row * 2)
+	y += 8
+	doc.text(margin, y, 8, false, "Renewals are in each subscription's own currency.")
+	return doc
+}
+
+// annualCategory is what one category was billed in a year.
+type annualCategory struct {
+	Category string       `json:"category"`
+	Charges  int          `json:"charges"`
+	Total    models.Money `json:"total"`
+}
+
+// annualSubscription is a subscription in the year in review.
+type annualSubscription struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Charges and Total are what it was billed in the year.
+	Charges int          `json:"charges"`
+	Total   models.Money `json:"total"`
+}
+
+// annualCancellation is a subscription cancelled in the year, and what
+// the renewals it would have had for the rest of the year would have cost.
+type annualCancellation struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	CancelledAt string       `json:"cancelledAt"`
+	Saved       models.Money `json:"saved"`
+}
+
+type annualReport struct {
+	Year       int          `json:"year"`
+	Currency   string       `json:"currency"`
+	TotalSpent models.Money `json:"totalSpent"`
+	Charges    int          `json:"charges"`
+	// BiggestCategory and MostExpensive are nil for a year with nothing
+	// billed.
+	BiggestCategory  *annualCategory      `json:"biggestCategory"`
+	MostExpensive    *annualSubscription  `json:"mostExpensive"`
+	NewSubscriptions []annualSubscription `json:"newSubscriptions"`
+	Cancellations    []annualCancellation `json:"cancellations"`
+	TotalSaved       models.Money         `json:"totalSaved"`
+}
+
+// getAnnualReport sums up a calendar year: what was billed, converted as
+// getSpending converts it, the category and the subscription that cost
+// the most, the subscriptions added, and those cancelled with what
+// cancelling them saved. Savings count the renewals each would have had
+// from its cancellation to the end of the year, at today's exchange
+// rates. Deleted subscriptions take their history with them and aren't
+// counted.
+func (a *App) getAnnualReport(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Spending reports need a database")
+		return
+	}
+	year, err := strconv.Atoi(mux.Vars(r)["year"])
+	if err != nil || year < 1 || year > 9999 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid year")
+		return
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	uid := userID(r)
+	charges, unconverted, err := a.billedCharges(r.Context(), uid, currency, start, end)
+	if unconverted != "" {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	subs, _, err := a.subscriptions.List(r.Context(), uid, store.SubscriptionQuery{Sort: []store.SortKey{{Field: store.SortByCreatedAt}}})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	report := annualReport{Year: year, Currency: currency, NewSubscriptions: []annualSubscription{}, Cancellations: []annualCancellation{}}
+	byCategory := map[string]*annualCategory{}
+	bySubscription := map[int]*annualSubscription{}
+	for _, c := range charges {
+		report.Charges++
+		report.TotalSpent += c.amount
+		if byCategory[c.category] == nil {
+			byCategory[c.category] = &annualCategory{Category: c.category}
+		}
+		byCategory[c.category].Charges++
+		byCategory[c.category].Total += c.amount
+		if bySubscription[c.subscriptionID] == nil {
+			bySubscription[c.subscriptionID] = &annualSubscription{ID: c.subscriptionID, Name: c.name, Category: c.category}
+		}
+		bySubscription[c.subscriptionID].Charges++
+		bySubscription[c.subscriptionID].Total += c.amount
+	}
+	// Ties go to the first category by name and the oldest subscription.
+	for _, c := range byCategory {
+		if b := report.BiggestCategory; b == nil || c.Total > b.Total || c.Total == b.Total && c.Category < b.Category {
+			report.BiggestCategory = c
+		}
+	}
+	for _, s := range bySubscription {
+		if m := report.MostExpensive; m == nil || s.Total > m.Total || s.Total == m.Total && s.ID < m.ID {
+			report.MostExpensive = s
+		}
+	}
+
+	convert := a.converter(r.Context(), currency)
+	last := end.AddDate(0, 0, -1)
+	for _, s := range subs {
+		if created, err := time.Parse(time.RFC3339, s.CreatedAt); err == nil && created.Year() == year {
+			added := annualSubscription{ID: s.ID, Name: s.Name, Category: s.Category}
+			if b := bySubscription[s.ID]; b != nil {
+				added.Charges, added.Total = b.Charges, b.Total
+			}
+			report.NewSubscriptions = append(report.NewSubscriptions, added)
+		}
+		if s.Status != models.StatusCancelled || s.CancelledAt == nil {
+			continue
+		}
+		cancelled, err := time.Parse(dateLayout, *s.CancelledAt)
+		if err != nil || cancelled.Year() != year {
+			continue
+		}
+		// Renewals count from the cancellation, or from the next billing
+		// date still to come after it.
+		from := cancelled
+		if next := s.NextBilling.Time(); next.After(from) {
+			from = next
+		}
+		renewals := len(billingDatesBetween(s.NextBilling.Time(), s.BillingCycle, from, last))
+		saved, err := convert(s.Currency, s.Cost*models.Money(renewals))
+		if err != nil {
+			a.writeConversionError(w, s.Currency, currency, err)
+			return
+		}
+		report.Cancellations = append(report.Cancellations, annualCancellation{ID: s.ID, Name: s.Name, CancelledAt: *s.CancelledAt, Saved: saved})
+		report.TotalSaved += saved
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// changePercent is how much now differs from was, in percent, or nil if
+// was is nothing.
+func changePercent(was, now models.Money) *float64 {
+	if was == 0 {
+		return nil
+	}
+	p := roundCents(float64(now-was) / float64(was) * 100)
+	return &p
+}
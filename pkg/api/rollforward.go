@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/rates"
+	"subscription-tracker/pkg/store"
+)
+
+// StartRollForward rolls passed billing dates forward now and then every
+// RollForwardInterval, until ctx is cancelled.
+func (a *App) StartRollForward(ctx context.Context) {
+	if a.config.RollForwardInterval <= 0 {
+		return
+	}
+
+	a.jobs.started("roll_forward", a.config.RollForwardInterval)
+	go func() {
+		defer a.jobs.stopped("roll_forward")
+		ticker := time.NewTicker(a.config.RollForwardInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.rollForward(ctx); err != nil {
+				a.logger.Warn("rolling billing dates forward", "err", err)
+			} else if n > 0 {
+				a.logger.Info("rolled billing dates forward", "subscriptions", n)
+			}
+			a.jobs.ran("roll_forward")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// rollForward advances every subscription whose next billing date has
+// passed to its first billing date from today on, recording each date it
+// skips in the billing history. It returns how many subscriptions moved.
+func (a *App) rollForward(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	today := models.DateOf(a.clock.Now())
+	due, err := a.subscriptions.Due(ctx, today)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	recordCurrencies := map[int]string{}
+	for _, d := range due {
+		from := d.NextBilling
+		if from.IsZero() {
+			continue
+		}
+		next := from.Time()
+		// Dates count from the stored one rather than from each other.
+		// A subscription billed on the 31st is anchored to it when it's
+		// stored, so it returns to the 31st after a short month whichever
+		// run it was moved by.
+		var billed []models.BillingEvent
+		date := next
+		for n := 1; date.Before(today.Time()); n++ {
+			billed = append(billed, models.BillingEvent{Date: date.Format(dateLayout), Amount: d.Cost})
+			var ok bool
+			if date, ok = addCycle(next, d.BillingCycle, n); !ok {
+				break
+			}
+		}
+		if date.Before(today.Time()) {
+			continue // a cycle addCycle doesn't understand
+		}
+		record, ok := recordCurrencies[d.UserID]
+		if !ok {
+			if record, err = a.userCurrency(ctx, d.UserID); err != nil {
+				return moved, err
+			}
+			recordCurrencies[d.UserID] = record
+		}
+		a.rateBilled(ctx, billed, d.Subscription, record)
+
+		err = a.subscriptions.Advance(ctx, d.UserID, d.ID, from, models.DateOf(date), billed, a.clock.Now())
+		if err == store.ErrNotFound {
+			continue // edited or deleted since Due; the next run sees the new date
+		}
+		if err != nil {
+			return moved, err
+		}
+		after := d.Subscription
+		after.NextBilling = models.DateOf(date)
+		a.recordAudit(ctx, d.UserID, d.ID, &d.Subscription, &after)
+		moved++
+	}
+	return moved, nil
+}
+
+// rateBilled sets the currency of each of s's billed events and its rate
+// into record on the day it was billed. An event whose rate can't be found
+// is left without one, to be converted at the rate of the day it's
+// reported.
+func (a *App) rateBilled(ctx context.Context, billed []models.BillingEvent, s models.Subscription, record string) {
+	for i := range billed {
+		e := &billed[i]
+		e.Currency = s.Currency
+		date, err := models.ParseDate(e.Date)
+		if err != nil {
+			continue
+		}
+		rate, err := a.rateOn(ctx, s.Currency, record, date)
+		if err != nil {
+			if !errors.Is(err, ErrNotConfigured) && !errors.Is(err, rates.ErrUnknownCurrency) {
+				a.logger.Warn("looking up the rate of a billing event", "subscription", s.ID, "date", e.Date, "err", err)
+			}
+			continue
+		}
+		e.RecordCurrency, e.Rate = record, &rate
+	}
+}
+
+// getSubscriptionHistory lists the billing dates a subscription has passed,
+// newest first.
+func (a *App) getSubscriptionHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := a.subscriptions.History(r.Context(), userID(r), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// How many results GET /api/subscriptions/search returns by default and at
+// most.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchResult is a subscription matching a search, with where it matched.
+// Highlights holds each matching field, HTML-escaped, with the matched
+// terms wrapped in <mark>.
+type searchResult struct {
+	models.Subscription
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// searchSubscriptions finds the user's subscriptions whose name, category
+// or description match every word of ?q=, best match first.
+func (a *App) searchSubscriptions(w http.ResponseWriter, r *http.Request) {
+	terms := store.SearchTerms(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "q must contain at least one letter or digit")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	found, err := a.subscriptions.Search(r.Context(), userID(r), terms, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	results := make([]searchResult, len(found))
+	for i, f := range found {
+		a.setStale(&f.Subscription)
+		results[i] = searchResult{Subscription: f.Subscription, Rank: f.Rank, Highlights: map[string]string{}}
+		for field, text := range map[string]string{"name": f.Name, "category": f.Category, "description": f.Description} {
+			if marked, ok := highlight(text, terms); ok {
+				results[i].Highlights[field] = marked
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// highlight HTML-escapes text and wraps every case-insensitive occurrence
+// of terms in <mark>, merging overlapping ones. It reports false if no term
+// occurs.
+func highlight(text string, terms []string) (string, bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, c := range runes {
+		lower[i] = unicode.ToLower(c)
+	}
+	marked := make([]bool, len(runes))
+	matched := false
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) == term {
+				for j := i; j < i+len(t); j++ {
+					marked[j] = true
+				}
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(string(runes[i:j])) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(string(runes[i:j])))
+		}
+		i = j
+	}
+	return b.String(), true
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// maxUserAgentLength caps the User-Agent kept with a session.
+const maxUserAgentLength = 256
+
+// sessionNow is the time sessions are stamped and expired with. Like
+// access tokens they use wall-clock time rather than the App's clock.
+func sessionNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// newRefreshToken returns a random refresh token and the hash it's stored
+// as. Only the hash is kept, so a leaked database can't be used to sign in.
+func newRefreshToken() (token, hash string) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	token = "rt_" + base64.RawURLEncoding.EncodeToString(key)
+	return token, hashToken(token)
+}
+
+// hashToken is how refresh tokens and recovery codes are stored. They're
+// random enough that a fast hash is as safe as bcrypt, and one can be
+// looked up by its hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession starts a session for the user on the device making the
+// request and returns its ID and first refresh token. The user's expired
+// sessions are cleared out on the way.
+func (a *App) createSession(r *http.Request, userID int) (int, string, error) {
+	ctx := r.Context()
+	now := sessionNow()
+	if _, err := a.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1 AND expires_at <= $2", userID, now); err != nil {
+		return 0, "", err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	token, hash := newRefreshToken()
+	var id int
+	err = a.db.QueryRowContext(ctx, `
+		INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6)
+		RETURNING id
+	`, userID, hash, userAgent, ip, now, now.Add(a.config.RefreshTokenTTL)).Scan(&id)
+	return id, token, err
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshSession exchanges a refresh token for a new access token and a
+// new refresh token, which replaces it: each one works once. Using one
+// that's already been replaced means it was copied, so the session is
+// revoked, logging out both whoever has it and the device it was taken
+// from. A session only refreshes in its user's tenant.
+func (a *App) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.RefreshToken == "" {
+		writeValidationErrors(w, fieldErrors{{"refreshToken", "is required"}})
+		return
+	}
+
+	tenant, ok := a.resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	// The refresh token is all there is to find the user by.
+	ctx := store.AsSystem(r.Context())
+	hash := hashToken(req.RefreshToken)
+	now := sessionNow()
+	var session int
+	var expiresAt, createdAt time.Time
+	var u models.User
+	err := a.db.QueryRowContext(ctx, `
+		SELECT s.id, s.expires_at, u.id, u.email, u.created_at, u.currency, u.totp_enabled, u.monthly_digest, u.tenant_id
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1
+	`, hash).Scan(&session, &expiresAt, &u.ID, &u.Email, &createdAt, &u.Currency, &u.TwoFactorEnabled, &u.MonthlyDigest, &u.TenantID)
+	if err == nil && u.TenantID != tenant {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Session is for another tenant")
+		return
+	}
+	if err == sql.ErrNoRows {
+		a.revokeReusedToken(w, r, hash)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !expiresAt.After(now) {
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", session); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Session expired; log in again")
+		return
+	}
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+
+	token, next := newRefreshToken()
+	res, err := a.db.ExecContext(ctx, `
+		UPDATE sessions SET token_hash = $1, previous_hash = $2, last_used_at = $3, expires_at = $4
+		WHERE id = $5 AND token_hash = $2
+	`, next, hash, now, now.Add(a.config.RefreshTokenTTL), session)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Refreshed by a concurrent request since it was looked up.
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	a.writeTokens(w, http.StatusOK, u, session, token)
+}
+
+// revokeReusedToken answers a refresh token that isn't current, revoking
+// its session if it's the one the session had before.
+func (a *App) revokeReusedToken(w http.ResponseWriter, r *http.Request, hash string) {
+	res, err := a.db.ExecContext(store.AsSystem(r.Context()), "DELETE FROM sessions WHERE previous_hash = $1", hash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		a.logger.WarnContext(r.Context(), "refresh token reused; session revoked")
+	}
+	writeError(w, http.StatusUnauthorized, codeUnauthorized, "Invalid or expired refresh token")
+}
+
+// logout ends the session a refresh token belongs to, and with it the
+// session's access tokens. It needs no access
+// token, so a client whose token has expired can still log out, and it
+// succeeds even if the session is already gone.
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.RefreshToken == "" {
+		writeValidationErrors(w, fieldErrors{{"refreshToken", "is required"}})
+		return
+	}
+	if _, err := a.db.ExecContext(store.AsSystem(r.Context()), "DELETE FROM sessions WHERE token_hash = $1", hashToken(req.RefreshToken)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userSessions lists the user's unexpired sessions, most recently used
+// first, marking current as the current one.
+func (a *App) userSessions(ctx context.Context, userID, current int) ([]models.Session, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, user_agent, ip, created_at, last_used_at, expires_at FROM sessions
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY last_used_at DESC, id DESC
+	`, userID, sessionNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		var createdAt, lastUsedAt, expiresAt time.Time
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = createdAt.Format(time.RFC3339)
+		s.LastUsedAt = lastUsedAt.Format(time.RFC3339)
+		s.ExpiresAt = expiresAt.Format(time.RFC3339)
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// getSessions lists the user's unexpired sessions, most recently used
+// first.
+func (a *App) getSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := a.userSessions(r.Context(), userID(r), sessionID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteSession revokes one of the user's sessions, such as a lost
+// device's. Its refresh token and the access tokens issued for it stop
+// working at once.
+func (a *App) deleteSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Session not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// maxShareLabel caps a share link's label, in characters.
+const maxShareLabel = 100
+
+var shareHTML = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/share.html"))
+
+// shareRequest is the body of POST /api/subscriptions/share. Leaving out
+// SubscriptionIDs shares every active subscription, including ones added
+// later.
+type shareRequest struct {
+	Label           string  `json:"label"`
+	SubscriptionIDs []int   `json:"subscriptionIds"`
+	IncludeStats    bool    `json:"includeStats"`
+	ExpiresAt       *string `json:"expiresAt"`
+}
+
+// sharedView is what a share link shows, read when it's opened. It leaves
+// out IDs, descriptions, tags and anything else about the account.
+type sharedView struct {
+	Label         string               `json:"label"`
+	Subscriptions []sharedSubscription `json:"subscriptions"`
+	Stats         *sharedStats         `json:"stats,omitempty"`
+	ExpiresAt     *string              `json:"expiresAt"`
+}
+
+type sharedSubscription struct {
+	Name         string       `json:"name"`
+	Category     string       `json:"category"`
+	Cost         models.Money `json:"cost"`
+	Currency     string       `json:"currency"`
+	BillingCycle string       `json:"billingCycle"`
+	NextBilling  models.Date  `json:"nextBilling"`
+	Status       string       `json:"status"`
+}
+
+// sharedStats totals the active subscriptions shown, in the owner's
+// currency.
+type sharedStats struct {
+	Currency     string         `json:"currency"`
+	TotalMonthly models.Money   `json:"totalMonthly"`
+	TotalYearly  models.Money   `json:"totalYearly"`
+	ByCategory   []categoryStat `json:"byCategory"`
+}
+
+// newShareToken returns a random share token. Like refresh tokens, only
+// its hash is stored.
+func newShareToken() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return "sh_" + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// createShareLink makes a link anyone can open to see the chosen
+// subscriptions. The response is the only time its token is shown.
+func (a *App) createShareLink(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	uid := userID(r)
+	now := a.clock.Now()
+	var errs fieldErrors
+	req.Label = strings.TrimSpace(req.Label)
+	if len([]rune(req.Label)) > maxShareLabel {
+		errs.add("label", fmt.Sprintf("must be at most %d characters", maxShareLabel))
+	}
+	var ids []int
+	if len(req.SubscriptionIDs) > maxBulkItems {
+		errs.add("subscriptionIds", fmt.Sprintf("must list at most %d subscriptions", maxBulkItems))
+	} else if len(req.SubscriptionIDs) > 0 {
+		subs, _, err := a.subscriptions.List(r.Context(), uid, store.SubscriptionQuery{})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		for _, id := range req.SubscriptionIDs {
+			if !slices.ContainsFunc(subs, func(s models.Subscription) bool { return s.ID == id }) {
+				errs.add("subscriptionIds", fmt.Sprintf("%d isn't one of your subscriptions", id))
+			} else if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+		if err != nil {
+			errs.add("expiresAt", "must be an RFC 3339 timestamp")
+		} else if !t.After(now) {
+			errs.add("expiresAt", "must be in the future")
+		} else {
+			expiresAt = &t
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	token := newShareToken()
+	link := models.ShareLink{
+		Label:           req.Label,
+		SubscriptionIDs: ids,
+		IncludeStats:    req.IncludeStats,
+		Token:           token,
+		Path:            "/share/" + token,
+		CreatedAt:       now.Format(time.RFC3339),
+	}
+	if link.SubscriptionIDs == nil {
+		link.SubscriptionIDs = []int{}
+	}
+	if expiresAt != nil {
+		v := expiresAt.Format(time.RFC3339)
+		link.ExpiresAt = &v
+	}
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO share_links (user_id, token_hash, label, subscription_ids, include_stats, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, uid, hashToken(token), link.Label, joinIDs(ids), link.IncludeStats, now, expiresAt).Scan(&link.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(link); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getShareLinks lists the caller's share links, newest first, including
+// expired ones until they're revoked.
+func (a *App) getShareLinks(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, label, subscription_ids, include_stats, created_at, expires_at
+		FROM share_links WHERE user_id = $1 ORDER BY id DESC
+	`, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		var link models.ShareLink
+		var ids string
+		var createdAt time.Time
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&link.ID, &link.Label, &ids, &link.IncludeStats, &createdAt, &expiresAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		link.SubscriptionIDs = splitIDs(ids)
+		link.CreatedAt = createdAt.Format(time.RFC3339)
+		if expiresAt.Valid {
+			v := expiresAt.Time.Format(time.RFC3339)
+			link.ExpiresAt = &v
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteShareLink revokes a share link; opening it is a 404 from then on.
+func (a *App) deleteShareLink(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM share_links WHERE id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Share link not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getSharedView serves GET /share/{token} without signing in: a page
+// listing the link's subscriptions, or with ?format=json the sharedView
+// it's made from. Unknown, revoked and expired links are all a 404.
+func (a *App) getSharedView(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "html" && format != "json" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "format must be html or json")
+		return
+	}
+
+	// The link is looked up by its token as the system role; the view is
+	// then read as the user who shared it.
+	var uid, tenant int
+	var ids, currency string
+	var includeStats bool
+	var expiresAt sql.NullTime
+	view := sharedView{Subscriptions: []sharedSubscription{}}
+	err := a.db.QueryRowContext(store.AsSystem(r.Context()), `
+		SELECT l.user_id, u.tenant_id, l.label, l.subscription_ids, l.include_stats, l.expires_at, u.currency
+		FROM share_links l JOIN users u ON u.id = l.user_id
+		WHERE l.token_hash = $1 AND u.delete_after IS NULL
+	`, hashToken(mux.Vars(r)["token"])).Scan(&uid, &tenant, &view.Label, &ids, &includeStats, &expiresAt, &currency)
+	if err == sql.ErrNoRows || (err == nil && expiresAt.Valid && !expiresAt.Time.After(a.clock.Now())) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Share link not found or expired")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if expiresAt.Valid {
+		v := expiresAt.Time.Format(time.RFC3339)
+		view.ExpiresAt = &v
+	}
+	ctx := store.WithUser(r.Context(), tenant, uid)
+
+	query := store.SubscriptionQuery{}
+	chosen := splitIDs(ids)
+	if len(chosen) == 0 {
+		query = store.SubscriptionQuery{Status: models.StatusActive, Archived: new(bool)}
+	}
+	subs, _, err := a.subscriptions.List(ctx, uid, query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	var active []models.Subscription
+	for _, s := range subs {
+		if len(chosen) > 0 && !slices.Contains(chosen, s.ID) {
+			continue // deleted subscriptions just drop out
+		}
+		view.Subscriptions = append(view.Subscriptions, sharedSubscription{
+			Name:         s.Name,
+			Category:     s.Category,
+			Cost:         s.Cost,
+			Currency:     s.Currency,
+			BillingCycle: s.BillingCycle,
+			NextBilling:  s.NextBilling,
+			Status:       s.Status,
+		})
+		if s.Status == models.StatusActive {
+			active = append(active, s)
+		}
+	}
+	if includeStats {
+		totals, unconverted, err := summarizeSpending(active, a.converter(ctx, currency))
+		if err != nil {
+			a.writeConversionError(w, unconverted, currency, err)
+			return
+		}
+		view.Stats = &sharedStats{Currency: currency, TotalMonthly: totals.TotalMonthly, TotalYearly: totals.TotalYearly, ByCategory: totals.ByCategory}
+	}
+
+	// The token is in the URL, so keep it out of caches and Referer headers.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(view); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		}
+		return
+	}
+	var page bytes.Buffer
+	if err := shareHTML.Execute(&page, view); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Template error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// joinIDs and splitIDs convert between subscription IDs and the
+// comma-separated form share_links keeps them in.
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitIDs(v string) []int {
+	ids := []int{}
+	for _, part := range strings.Split(v, ",") {
+		if id, err := strconv.Atoi(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
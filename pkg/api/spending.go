@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// Spending report lengths, in months, for ?months=.
+const (
+	defaultSpendingMonths = 12
+	maxSpendingMonths     = 60
+)
+
+// spendingMonth is what was billed in one calendar month, as YYYY-MM.
+type spendingMonth struct {
+	Month   string       `json:"month"`
+	Charges int          `json:"charges"`
+	Total   models.Money `json:"total"`
+}
+
+type spendingReport struct {
+	Currency string          `json:"currency"`
+	Total    models.Money    `json:"total"`
+	Months   []spendingMonth `json:"months"`
+}
+
+// getSpending totals the billing history month by month, over ?months
+// (default 12, at most 60) up to and including the current one. Unlike
+// the stats, amounts aren't converted at today's rate: an event reported
+// in the currency of record it was billed under uses the rate stored with
+// it, and any other conversion uses the exchange rate snapshot of the day
+// it was billed, so past months don't change as rates do.
+func (a *App) getSpending(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Spending reports need a database")
+		return
+	}
+	months, ok := spendingMonths(w, r)
+	if !ok {
+		return
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	report, unconverted, err := a.billedSpending(r.Context(), userID(r), currency, a.spendingStart(months), months)
+	if unconverted != "" {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// spendingMonths is ?months, how many months a spending report covers. It
+// writes a 400 and returns false if that's not between 1 and
+// maxSpendingMonths.
+func spendingMonths(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("months")
+	if v == "" {
+		return defaultSpendingMonths, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxSpendingMonths {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("months must be between 1 and %d", maxSpendingMonths))
+		return 0, false
+	}
+	return n, true
+}
+
+// spendingStart is the first month of a spending report covering months
+// months up to and including the current one.
+func (a *App) spendingStart(months int) time.Time {
+	now := a.clock.Now()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-months, 0)
+}
+
+// billedSpending totals userID's billing history in currency over the
+// months calendar months from start, converting as getSpending describes.
+// If a conversion fails it returns the currency it couldn't convert.
+func (a *App) billedSpending(ctx context.Context, userID int, currency string, start time.Time, months int) (spendingReport, string, error) {
+	report := spendingReport{Currency: currency, Months: make([]spendingMonth, months)}
+	for i := range report.Months {
+		report.Months[i] = spendingMonth{Month: start.AddDate(0, i, 0).Format("2006-01")}
+	}
+	charges, unconverted, err := a.billedCharges(ctx, userID, currency, start, start.AddDate(0, months, 0))
+	if err != nil {
+		return report, unconverted, err
+	}
+	for _, c := range charges {
+		m := &report.Months[monthsBetween(start, c.billed.Time())]
+		m.Charges++
+		m.Total += c.amount
+		report.Total += c.amount
+	}
+	return report, "", nil
+}
+
+// billedCharge is one billing event, converted to the report's currency.
+type billedCharge struct {
+	billed         models.Date
+	subscriptionID int
+	name, category string
+	billingCycle   string
+	amount         models.Money
+}
+
+// billedCharges lists userID's billing history from start up to end in
+// currency, oldest first, with the subscription billed.
+// If a conversion fails it returns the currency it couldn't convert.
+func (a *App) billedCharges(ctx context.Context, userID int, currency string, start, end time.Time) ([]billedCharge, string, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT b.billed_on, b.amount_cents, b.currency, b.record_currency, b.rate, s.id, s.name, s.category, s.billing_cycle
+		FROM billing_history b
+		JOIN subscriptions s ON s.id = b.subscription_id
+		WHERE b.user_id = $1 AND b.billed_on >= $2 AND b.billed_on < $3
+		ORDER BY b.billed_on, b.id
+	`, userID, models.DateOf(start), models.DateOf(end))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The events are read before converting any, which can query the
+	// stored rates.
+	type event struct {
+		billedCharge
+		from   string
+		record sql.NullString
+		rate   sql.NullFloat64
+	}
+	var events []event
+	for rows.Next() {
+		var e event
+		if err := rows.Scan(&e.billed, &e.amount, &e.from, &e.record, &e.rate, &e.subscriptionID, &e.name, &e.category, &e.billingCycle); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	type dayRate struct {
+		currency string
+		day      models.Date
+	}
+	cache := map[dayRate]float64{}
+	charges := make([]billedCharge, 0, len(events))
+	for _, e := range events {
+		if e.from != currency {
+			rate, ok := e.rate.Float64, e.rate.Valid && e.record.String == currency
+			if !ok {
+				key := dayRate{e.from, e.billed}
+				if rate, ok = cache[key]; !ok {
+					if rate, err = a.rateOn(ctx, e.from, currency, e.billed); err != nil {
+						return nil, e.from, err
+					}
+					cache[key] = rate
+				}
+			}
+			e.amount = models.MoneyFromFloat(e.amount.Float() * rate)
+		}
+		charges = append(charges, e.billedCharge)
+	}
+	return charges, "", nil
+}
+
+// monthsBetween is how many calendar months t is after the month start
+// begins.
+func monthsBetween(start, t time.Time) int {
+	return (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+}
+
+// chartSeries is a chart in the shape Chart.js takes as its data: a value
+// for each label in every dataset.
+type chartSeries struct {
+	Labels   []string       `json:"labels"`
+	Datasets []chartDataset `json:"datasets"`
+}
+
+type chartDataset struct {
+	Label string         `json:"label"`
+	Data  []models.Money `json:"data"`
+}
+
+// getSpendingTimeseries buckets the billing history the way getSpending
+// does, over the same ?months, into charts of what was billed each month:
+// in total, by category, and by the billing cycle of the subscription
+// billed. Datasets are biggest first, and every chart has the same labels.
+func (a *App) getSpendingTimeseries(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, codeNotConfigured, "Spending reports need a database")
+		return
+	}
+	months, ok := spendingMonths(w, r)
+	if !ok {
+		return
+	}
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	start := a.spendingStart(months)
+	charges, unconverted, err := a.billedCharges(r.Context(), userID(r), currency, start, start.AddDate(0, months, 0))
+	if unconverted != "" {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	labels := make([]string, months)
+	for i := range labels {
+		labels[i] = start.AddDate(0, i, 0).Format("2006-01")
+	}
+	total := make([]models.Money, months)
+	byCategory, byCycle := map[string][]models.Money{}, map[string][]models.Money{}
+	add := func(series map[string][]models.Money, label string, month int, amount models.Money) {
+		if series[label] == nil {
+			series[label] = make([]models.Money, months)
+		}
+		series[label][month] += amount
+	}
+	for _, c := range charges {
+		i := monthsBetween(start, c.billed.Time())
+		total[i] += c.amount
+		add(byCategory, c.category, i, c.amount)
+		add(byCycle, c.billingCycle, i, c.amount)
+	}
+
+	result := struct {
+		Currency   string      `json:"currency"`
+		Spending   chartSeries `json:"spending"`
+		ByCategory chartSeries `json:"byCategory"`
+		ByCycle    chartSeries `json:"byCycle"`
+	}{
+		Currency:   currency,
+		Spending:   chartSeries{Labels: labels, Datasets: []chartDataset{{Label: "Total", Data: total}}},
+		ByCategory: chartSeries{Labels: labels, Datasets: chartDatasets(byCategory)},
+		ByCycle:    chartSeries{Labels: labels, Datasets: chartDatasets(byCycle)},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// chartDatasets turns series of monthly amounts into datasets, the one
+// with the biggest total first.
+func chartDatasets(series map[string][]models.Money) []chartDataset {
+	datasets := []chartDataset{}
+	totals := map[string]models.Money{}
+	for label, data := range series {
+		for _, amount := range data {
+			totals[label] += amount
+		}
+		datasets = append(datasets, chartDataset{Label: label, Data: data})
+	}
+	sort.Slice(datasets, func(i, j int) bool {
+		if ti, tj := totals[datasets[i].Label], totals[datasets[j].Label]; ti != tj {
+			return ti > tj
+		}
+		return datasets[i].Label < datasets[j].Label
+	})
+	return datasets
+}
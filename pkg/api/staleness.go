@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// setStale fills in s.Stale from when it was last verified.
+func (a *App) setStale(s *models.Subscription) {
+	s.Stale = true
+	if s.LastVerifiedAt == nil {
+		return
+	}
+	if verified, err := time.Parse(time.RFC3339, *s.LastVerifiedAt); err == nil {
+		s.Stale = verified.Before(a.clock.Now().AddDate(0, -a.config.StaleAfterMonths, 0))
+	}
+}
+
+// markVerified records that a subscription was confirmed at the given time
+// and clears any outstanding stale alert for it. Older confirmations never
+// overwrite newer ones.
+func (a *App) markVerified(ctx context.Context, userID, subscriptionID int, at time.Time) error {
+	if err := a.subscriptions.Verify(ctx, userID, subscriptionID, at); err != nil {
+		return err
+	}
+	a.invalidateStats(ctx, userID)
+	_, err := a.db.ExecContext(ctx, `UPDATE alerts SET dismissed = TRUE WHERE kind = $1 AND subscription_id = $2`,
+		models.AlertStaleSubscription, subscriptionID)
+	return err
+}
+
+// verifySubscription lets the user confirm a subscription is still accurate.
+func (a *App) verifySubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.markVerified(r.Context(), userID(r), id, a.clock.Now())
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// raiseStaleAlerts adds a feed entry for every subscription that has gone
+// unverified past the threshold. The dedupe key includes the last
+// verification so a subscription that goes stale again alerts again.
+func (a *App) raiseStaleAlerts(ctx context.Context, userID int) error {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, name, last_verified_at
+		FROM subscriptions
+		WHERE user_id = $1 AND (last_verified_at IS NULL OR last_verified_at < $2)
+	`, userID, a.clock.Now().AddDate(0, -a.config.StaleAfterMonths, 0))
+	if err != nil {
+		return err
+	}
+
+	type stale struct {
+		id           int
+		name         string
+		lastVerified sql.NullTime
+	}
+	var found []stale
+	for rows.Next() {
+		var s stale
+		if err := rows.Scan(&s.id, &s.name, &s.lastVerified); err != nil {
+			rows.Close()
+			return err
+		}
+		found = append(found, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range found {
+		message := fmt.Sprintf("%s has never been verified; check it's still active", s.name)
+		since := "never"
+		if s.lastVerified.Valid {
+			since = s.lastVerified.Time.Format(dateLayout)
+			message = fmt.Sprintf("%s hasn't been verified since %s; check it's still active", s.name, since)
+		}
+		if err := a.raiseAlert(ctx, userID, models.Alert{
+			Kind:           models.AlertStaleSubscription,
+			Message:        message,
+			SubscriptionID: &s.id,
+		}, fmt.Sprintf("%s:%d:%s", models.AlertStaleSubscription, s.id, since)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// statementColumns are the fields a CSV bank statement is read from. A
+// statement has either one signed amount column or separate debit and
+// credit columns.
+var statementColumns = []csvColumn{
+	{"date", true, []string{"date", "posted", "posteddate", "postingdate", "transactiondate", "bookingdate", "valuedate"}},
+	{"description", true, []string{"description", "payee", "merchant", "name", "details", "memo", "narrative"}},
+	{"amount", false, []string{"amount", "value", "transactionamount"}},
+	{"debit", false, []string{"debit", "withdrawal", "withdrawals", "moneyout", "paidout"}},
+	{"credit", false, []string{"credit", "deposit", "deposits", "moneyin", "paidin"}},
+	{"id", false, []string{"id", "transactionid", "fitid", "reference"}},
+}
+
+// statementDateLayouts are the date formats tried for CSV statements.
+// Slashed dates are month first unless ?dayFirst=true.
+var statementDateLayouts = []string{dateLayout, "2006/01/02", "01/02/2006", "1/2/2006", "02.01.2006", "2.1.2006"}
+
+// ofxTransaction starts each transaction in an OFX statement and ofxEnd
+// may end one; ofxField finds the fields in it. They work on OFX 1.x SGML,
+// where tags aren't closed, as well as on OFX 2.x XML.
+var (
+	ofxTransaction = regexp.MustCompile(`(?i)<STMTTRN>`)
+	ofxEnd         = regexp.MustCompile(`(?i)</STMTTRN>|</BANKTRANLIST>`)
+	ofxField       = regexp.MustCompile(`(?i)<([A-Z0-9.]+)>([^<\r\n]*)`)
+	ofxAccount     = regexp.MustCompile(`(?i)<ACCTID>([^<\r\n]*)`)
+)
+
+// statementReport is the response of POST /api/transactions/statement.
+// Transactions counts as POST /api/transactions does; Errors lists the
+// lines, or for OFX the transactions by position, that couldn't be read.
+type statementReport struct {
+	Format       string         `json:"format"`
+	Transactions map[string]int `json:"transactions"`
+	Errors       []csvImportRow `json:"errors"`
+}
+
+// importStatement reads a bank statement uploaded as the "file" part of a
+// multipart form, OFX or CSV (?format= overrides the guess), and imports
+// its transactions like POST /api/transactions, so they're matched to
+// subscriptions and unknown recurring charges are flagged. Transactions
+// without an ID in the file get one from their contents, so uploading an
+// overlapping statement again only adds what's new.
+func (a *App) importStatement(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format != "" && format != "ofx" && format != "csv" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "format must be ofx or csv")
+		return
+	}
+	file, ok := uploadedFile(w, r, maxImportBytes)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(file)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("File is larger than %d bytes", maxImportBytes))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading upload: %v", err))
+		return
+	}
+	if format == "" {
+		format = "csv"
+		if head := strings.ToUpper(string(data[:min(len(data), 1024)])); strings.Contains(head, "OFXHEADER") || strings.Contains(head, "<OFX>") {
+			format = "ofx"
+		}
+	}
+
+	report := statementReport{Format: format, Errors: []csvImportRow{}}
+	var batch []models.Transaction
+	if format == "ofx" {
+		batch, report.Errors = parseOFX(data)
+	} else {
+		batch, report.Errors, err = parseStatementCSV(data, r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+	}
+
+	report.Transactions, err = a.storeTransactions(r.Context(), userID(r), batch)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Import error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// parseOFX reads the transactions of an OFX bank or credit card
+// statement. IDs are the account and FITID.
+func parseOFX(data []byte) ([]models.Transaction, []csvImportRow) {
+	account := ""
+	if m := ofxAccount.FindSubmatch(data); m != nil {
+		account = strings.TrimSpace(string(m[1])) + ":"
+	}
+	var batch []models.Transaction
+	var errs []csvImportRow
+	ids := statementIDs{}
+	for i, block := range ofxTransaction.Split(string(data), -1)[1:] {
+		if end := ofxEnd.FindStringIndex(block); end != nil {
+			block = block[:end[0]]
+		}
+		fields := map[string]string{}
+		for _, f := range ofxField.FindAllStringSubmatch(block, -1) {
+			fields[strings.ToUpper(f[1])] = strings.TrimSpace(f[2])
+		}
+		t := models.Transaction{Source: "bank", Description: cmp.Or(fields["NAME"], fields["PAYEE"], fields["MEMO"])}
+		// DTPOSTED may carry a time and zone after the date.
+		if posted := fields["DTPOSTED"]; len(posted) >= 8 {
+			if d, err := time.Parse("20060102", posted[:8]); err == nil {
+				t.Date = d.Format(dateLayout)
+			}
+		}
+		amount, err := models.ParseMoney(fields["TRNAMT"])
+		t.Amount = amount
+		if msg := checkStatementTransaction(t, err); msg != "" {
+			errs = append(errs, csvImportRow{Line: i + 1, Error: msg})
+			continue
+		}
+		if id := fields["FITID"]; id != "" {
+			t.ExternalID = "ofx:" + account + id
+		} else {
+			t.ExternalID = ids.next(t)
+		}
+		batch = append(batch, t)
+	}
+	return batch, errs
+}
+
+// parseStatementCSV reads a CSV bank statement with a header row. It only
+// fails as a whole if the header is unreadable or lacks a needed column.
+func parseStatementCSV(data []byte, r *http.Request) ([]models.Transaction, []csvImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading CSV header: %v", err)
+	}
+	mapping, err := csvMapping(header, r, statementColumns)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, amount := mapping["amount"]
+	_, debit := mapping["debit"]
+	_, credit := mapping["credit"]
+	if !amount && !debit && !credit {
+		return nil, nil, errors.New("no column for amount, or for debit and credit; name one with ?column.amount=<header>")
+	}
+	dayFirst := r.URL.Query().Get("dayFirst") == "true"
+
+	var batch []models.Transaction
+	errs := []csvImportRow{}
+	ids := statementIDs{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			errs = append(errs, csvImportRow{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		get := func(field string) string {
+			if i, ok := mapping[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		t := models.Transaction{Source: "bank", Description: get("description"), Date: parseStatementDate(get("date"), dayFirst)}
+		var amountErr error
+		if amount {
+			t.Amount, amountErr = parseStatementAmount(get("amount"))
+		} else {
+			var out, in models.Money
+			if v := get("debit"); v != "" {
+				out, amountErr = parseStatementAmount(v)
+			}
+			if v := get("credit"); v != "" && amountErr == nil {
+				in, amountErr = parseStatementAmount(v)
+			}
+			t.Amount = in.Abs() - out.Abs()
+		}
+		if msg := checkStatementTransaction(t, amountErr); msg != "" {
+			errs = append(errs, csvImportRow{Line: line, Error: msg})
+			continue
+		}
+		if id := get("id"); id != "" {
+			t.ExternalID = "csv:" + id
+		} else {
+			t.ExternalID = ids.next(t)
+		}
+		batch = append(batch, t)
+	}
+	return batch, errs, nil
+}
+
+// checkStatementTransaction says what's wrong with a parsed transaction,
+// or "" if nothing is.
+func checkStatementTransaction(t models.Transaction, amountErr error) string {
+	switch {
+	case t.Date == "":
+		return "date is missing or not a date"
+	case t.Description == "":
+		return "description is missing"
+	case amountErr != nil:
+		return "amount is not a number"
+	case t.Amount == 0:
+		return "amount is missing or zero"
+	}
+	return ""
+}
+
+func parseStatementDate(v string, dayFirst bool) string {
+	for _, layout := range statementDateLayouts {
+		if dayFirst && strings.Contains(layout, "/") && !strings.HasPrefix(layout, "2006") {
+			layout = strings.NewReplacer("01/02", "02/01", "1/2", "2/1").Replace(layout)
+		}
+		if d, err := time.Parse(layout, v); err == nil {
+			return d.Format(dateLayout)
+		}
+	}
+	return ""
+}
+
+// parseStatementAmount reads an amount such as "-15.49", "$1,200.00" or
+// "(15.49)", the accounting style for a negative number.
+func parseStatementAmount(v string) (models.Money, error) {
+	negative := strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")")
+	v = strings.Trim(v, "()")
+	v = strings.NewReplacer(",", "", " ", "", "$", "", "€", "", "£", "").Replace(v)
+	m, err := models.ParseMoney(v)
+	if negative {
+		m = -m.Abs()
+	}
+	return m, err
+}
+
+// statementIDs makes up IDs for transactions whose statement has none,
+// from the date, description and amount. Identical rows in one statement,
+// such as two equal charges on a day, are told apart by how many came
+// before.
+type statementIDs map[string]int
+
+func (ids statementIDs) next(t models.Transaction) string {
+	key := t.Date + "|" + strings.ToLower(t.Description) + "|" + t.Amount.String()
+	n := ids[key]
+	ids[key] = n + 1
+	sum := sha256.Sum256([]byte(key + "|" + strconv.Itoa(n)))
+	return "stmt:" + hex.EncodeToString(sum[:12])
+}
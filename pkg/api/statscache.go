@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"subscription-tracker/pkg/cache"
+)
+
+// cachedStats writes the user's stats under key, their currency and
+// whether archived subscriptions are included, from the cache, with their
+// Age, if there's an entry younger than Config.StatsCacheTTL. It reports
+// whether it did; a cache that can't be reached is a miss.
+func (a *App) cachedStats(w http.ResponseWriter, r *http.Request, key string) bool {
+	ttl := a.config.StatsCacheTTL
+	if ttl <= 0 {
+		return false
+	}
+	e, ok, err := a.stats.Get(r.Context(), userID(r), key)
+	a.reportStatsCache(err)
+	if err != nil {
+		a.logger.WarnContext(r.Context(), "reading cached stats", "err", err)
+	}
+	if !ok {
+		return false
+	}
+	age := a.clock.Now().Sub(e.StoredAt)
+	if age < 0 || age >= ttl {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int((ttl-age)/time.Second)))
+	w.Header().Set("Age", strconv.Itoa(int(age/time.Second)))
+	w.Write(e.Body)
+	return true
+}
+
+// cacheStats stores the user's stats under key, computed at computedAt,
+// and sets the Cache-Control header for sending them.
+func (a *App) cacheStats(w http.ResponseWriter, r *http.Request, key string, body []byte, computedAt time.Time) {
+	ttl := a.config.StatsCacheTTL
+	if ttl <= 0 {
+		w.Header().Set("Cache-Control", "private, no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(ttl/time.Second)))
+	err := a.stats.Set(r.Context(), userID(r), key, cache.Entry{Body: body, StoredAt: computedAt})
+	a.reportStatsCache(err)
+	if err != nil {
+		a.logger.WarnContext(r.Context(), "caching stats", "err", err)
+	}
+}
+
+// invalidateStats drops the user's cached stats after a change to what
+// they're computed from. Every subscription change goes through
+// recordAudit, which calls it; budgets, tags and verification call it
+// themselves.
+func (a *App) invalidateStats(ctx context.Context, userID int) {
+	err := a.stats.Invalidate(ctx, userID, a.clock.Now())
+	a.reportStatsCache(err)
+	if err != nil {
+		a.logger.WarnContext(ctx, "invalidating cached stats", "user", userID, "err", err)
+	}
+}
+
+// reportStatsCache records the outcome of a call to the stats cache when
+// it's in Redis.
+func (a *App) reportStatsCache(err error) {
+	if _, ok := a.stats.(*cache.Redis); ok {
+		a.integrations.report("redis", err)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// statsComparisons are the ?compareTo values the stats take, each with
+// the time it compares the stats against, counting back from the ?asOf
+// day or now.
+var statsComparisons = map[string]func(now time.Time) time.Time{
+	"previous_month": func(now time.Time) time.Time { return now.AddDate(0, -1, 0) },
+	"previous_year":  func(now time.Time) time.Time { return now.AddDate(-1, 0, 0) },
+}
+
+// statsChange is how a figure changed since the time compared with.
+type statsChange struct {
+	Previous models.Money `json:"previous"`
+	Change   models.Money `json:"change"`
+	// Percent is Change as a percentage of Previous, or nil if Previous
+	// is zero.
+	Percent *float64 `json:"percent"`
+}
+
+func newStatsChange(previous, current models.Money) statsChange {
+	return statsChange{Previous: previous, Change: current - previous, Percent: changePercent(previous, current)}
+}
+
+type categoryChange struct {
+	Category string      `json:"category"`
+	Monthly  statsChange `json:"monthly"`
+	Yearly   statsChange `json:"yearly"`
+}
+
+// statsComparison compares the stats' totals with what they were on Date.
+type statsComparison struct {
+	CompareTo    string      `json:"compareTo"`
+	Date         models.Date `json:"date"`
+	TotalMonthly statsChange `json:"totalMonthly"`
+	TotalYearly  statsChange `json:"totalYearly"`
+	// ByCategory has each category counted then or now, in the order the
+	// stats list them, followed by those no longer counted.
+	ByCategory []categoryChange `json:"byCategory"`
+}
+
+// compareStats works out what the stats totals were at and compares
+// current with them. Then is reconstructed from today's subscriptions: it
+// counts those created by then that weren't yet cancelled, or archived
+// unless includeArchived, at their price then. Pauses aren't dated, so a
+// paused subscription is left out then too. Amounts are converted with
+// convert, at today's rates, so a change in exchange rates doesn't show as
+// a change in spending. If a conversion fails it returns the currency it
+// couldn't convert.
+func (a *App) compareStats(ctx context.Context, userID int, at time.Time, includeArchived bool, current spending, convert func(from string, amount models.Money) (models.Money, error)) (statsComparison, string, error) {
+	at = at.UTC().Truncate(time.Second)
+	subs, _, err := a.subscriptions.List(ctx, userID, store.SubscriptionQuery{})
+	if err != nil {
+		return statsComparison{}, "", err
+	}
+	prices, err := a.pricesAt(ctx, userID, at)
+	if err != nil {
+		return statsComparison{}, "", err
+	}
+
+	var then []models.Subscription
+	for _, s := range subs {
+		if created, err := time.Parse(time.RFC3339, s.CreatedAt); err != nil || created.After(at) {
+			continue
+		}
+		switch s.Status {
+		case models.StatusActive:
+		case models.StatusCancelled:
+			if s.CancelledAt == nil || *s.CancelledAt <= models.DateOf(at).String() {
+				continue
+			}
+		default:
+			continue
+		}
+		if s.ArchivedAt != nil && !includeArchived {
+			if archived, err := time.Parse(time.RFC3339, *s.ArchivedAt); err == nil && !archived.After(at) {
+				continue
+			}
+		}
+		if p, ok := prices[s.ID]; ok {
+			s.Cost, s.Currency = p.cost, p.currency
+		}
+		then = append(then, s)
+	}
+	previous, unconverted, err := summarizeSpending(then, convert)
+	if err != nil {
+		return statsComparison{}, unconverted, err
+	}
+
+	c := statsComparison{
+		Date:         models.DateOf(at),
+		TotalMonthly: newStatsChange(previous.TotalMonthly, current.TotalMonthly),
+		TotalYearly:  newStatsChange(previous.TotalYearly, current.TotalYearly),
+		ByCategory:   []categoryChange{},
+	}
+	was := map[string]categoryStat{}
+	for _, p := range previous.ByCategory {
+		was[p.Category] = p
+	}
+	for _, n := range current.ByCategory {
+		p := was[n.Category]
+		delete(was, n.Category)
+		c.ByCategory = append(c.ByCategory, categoryChange{Category: n.Category, Monthly: newStatsChange(p.Monthly, n.Monthly), Yearly: newStatsChange(p.Yearly, n.Yearly)})
+	}
+	for _, p := range previous.ByCategory {
+		if _, gone := was[p.Category]; gone {
+			c.ByCategory = append(c.ByCategory, categoryChange{Category: p.Category, Monthly: newStatsChange(p.Monthly, 0), Yearly: newStatsChange(p.Yearly, 0)})
+		}
+	}
+	return c, "", nil
+}
+
+// recordedPrice is a subscription's price as the price history recorded it.
+type recordedPrice struct {
+	cost     models.Money
+	currency string
+}
+
+// pricesAt finds what the subscriptions of userID whose price changed
+// after at cost then: the old price of the first change since. Those
+// missing haven't changed price.
+func (a *App) pricesAt(ctx context.Context, userID int, at time.Time) (map[int]recordedPrice, error) {
+	prices := map[int]recordedPrice{}
+	if a.db == nil {
+		return prices, nil
+	}
+	rows, err := a.stmts.QueryContext(ctx, `
+		SELECT subscription_id, old_cost_cents, old_currency
+		FROM price_history
+		WHERE user_id = $1 AND changed_at > $2
+		ORDER BY changed_at, id
+	`, userID, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var p recordedPrice
+		if err := rows.Scan(&id, &p.cost, &p.currency); err != nil {
+			return nil, err
+		}
+		if _, seen := prices[id]; !seen {
+			prices[id] = p
+		}
+	}
+	return prices, rows.Err()
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	integrationOK            = "ok"
+	integrationDegraded      = "degraded"
+	integrationUnknown       = "unknown"
+	integrationNotConfigured = "not_configured"
+)
+
+// IntegrationStatus is the health of one external dependency as seen from
+// its most recent calls.
+type IntegrationStatus struct {
+	Name        string  `json:"name"`
+	Status      string  `json:"status"`
+	Configured  bool    `json:"configured"`
+	LastSuccess *string `json:"lastSuccess"`
+	LastError   *string `json:"lastError"`
+	LastErrorAt *string `json:"lastErrorAt"`
+}
+
+type integrationState struct {
+	configured  bool
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// integrationRegistry tracks every external dependency. Callers report
+// after each attempt so /api/status reflects reality without probing third
+// parties on every request.
+type integrationRegistry struct {
+	mu     sync.Mutex
+	states map[string]*integrationState
+}
+
+func newIntegrationRegistry() *integrationRegistry {
+	return &integrationRegistry{states: map[string]*integrationState{}}
+}
+
+func (reg *integrationRegistry) register(name string, configured bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if state, ok := reg.states[name]; ok {
+		state.configured = configured
+		return
+	}
+	reg.states[name] = &integrationState{configured: configured}
+}
+
+// report records the outcome of a call to an external system.
+func (reg *integrationRegistry) report(name string, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	state, ok := reg.states[name]
+	if !ok {
+		state = &integrationState{configured: true}
+		reg.states[name] = state
+	}
+	if err != nil {
+		state.lastError = err.Error()
+		state.lastErrorAt = time.Now()
+		return
+	}
+	state.lastSuccess = time.Now()
+}
+
+func formatTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	s := t.Format(time.RFC3339)
+	return &s
+}
+
+func (reg *integrationRegistry) statuses() []IntegrationStatus {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	statuses := []IntegrationStatus{}
+	for name, state := range reg.states {
+		s := IntegrationStatus{
+			Name:        name,
+			Configured:  state.configured,
+			LastSuccess: formatTime(state.lastSuccess),
+			LastErrorAt: formatTime(state.lastErrorAt),
+		}
+		if state.lastError != "" {
+			s.LastError = &state.lastError
+		}
+		switch {
+		case !state.configured:
+			s.Status = integrationNotConfigured
+		case state.lastErrorAt.After(state.lastSuccess):
+			s.Status = integrationDegraded
+		case !state.lastSuccess.IsZero():
+			s.Status = integrationOK
+		default:
+			s.Status = integrationUnknown
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// getStatus reports the health of the database and every configured
+// integration. It always answers 200 so a status page can render partial
+// outages; the overall status is "degraded" if anything is.
+func (a *App) getStatus(w http.ResponseWriter, r *http.Request) {
+	a.integrations.report("database", a.db.PingContext(r.Context()))
+
+	statuses := a.integrations.statuses()
+	overall := integrationOK
+	for _, s := range statuses {
+		if s.Status == integrationDegraded {
+			overall = integrationDegraded
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"status":       overall,
+		"checkedAt":    time.Now().Format(time.RFC3339),
+		"integrations": statuses,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,492 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+	"subscription-tracker/pkg/stripe"
+)
+
+// stripeTimeout bounds each call to the Stripe API.
+const stripeTimeout = 10 * time.Second
+
+// stripeLive are the Stripe subscription statuses that still renew. A
+// link to one in any other status, such as canceled, is kept but changes
+// nothing.
+var stripeLive = map[string]bool{"active": true, "trialing": true, "past_due": true}
+
+// stripeAccount is the response of GET /api/me/stripe.
+type stripeAccount struct {
+	Connected   bool    `json:"connected"`
+	ConnectedAt *string `json:"connectedAt"`
+	Links       int     `json:"links"`
+}
+
+type stripeKeyRequest struct {
+	APIKey string `json:"apiKey"`
+}
+
+type stripeLinkRequest struct {
+	StripeSubscriptionID string `json:"stripeSubscriptionId"`
+}
+
+// stripeFor returns a client for the user's connected Stripe account, or
+// false if they haven't connected one.
+func (a *App) stripeFor(ctx context.Context, userID int) (stripe.Client, bool, error) {
+	var key string
+	err := a.db.QueryRowContext(ctx, "SELECT api_key FROM stripe_accounts WHERE user_id = $1", userID).Scan(&key)
+	if err == sql.ErrNoRows {
+		return stripe.Client{}, false, nil
+	}
+	if err != nil {
+		return stripe.Client{}, false, err
+	}
+	return a.stripeClient(key), true, nil
+}
+
+func (a *App) stripeClient(key string) stripe.Client {
+	return stripe.Client{URL: a.config.StripeURL, Key: key, Client: &http.Client{Timeout: stripeTimeout}}
+}
+
+// reportStripe records how a call to Stripe went. A rejected key or a
+// subscription missing from the user's account is the user's to fix, so
+// it doesn't count against the integration.
+func (a *App) reportStripe(err error) {
+	if errors.Is(err, stripe.ErrUnauthorized) || errors.Is(err, stripe.ErrNotFound) {
+		err = nil
+	}
+	a.integrations.report("stripe", err)
+}
+
+func (a *App) getStripeAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := a.stripeAccount(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(account); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) stripeAccount(ctx context.Context, userID int) (stripeAccount, error) {
+	var account stripeAccount
+	var connectedAt time.Time
+	err := a.db.QueryRowContext(ctx, "SELECT created_at FROM stripe_accounts WHERE user_id = $1", userID).Scan(&connectedAt)
+	if err == sql.ErrNoRows {
+		return account, nil
+	}
+	if err != nil {
+		return account, err
+	}
+	at := connectedAt.UTC().Format(time.RFC3339)
+	account.Connected, account.ConnectedAt = true, &at
+	err = a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM stripe_links WHERE user_id = $1", userID).Scan(&account.Links)
+	return account, err
+}
+
+// connectStripe stores the user's Stripe API key, after checking with
+// Stripe that it may read subscriptions. A restricted key with read
+// access to subscriptions and invoices is enough. Connecting again
+// replaces the key and keeps the links.
+func (a *App) connectStripe(w http.ResponseWriter, r *http.Request) {
+	var req stripeKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	key := strings.TrimSpace(req.APIKey)
+	if !strings.HasPrefix(key, "sk_") && !strings.HasPrefix(key, "rk_") {
+		writeValidationErrors(w, fieldErrors{{"apiKey", "must be a Stripe secret (sk_) or restricted (rk_) key"}})
+		return
+	}
+
+	err := a.stripeClient(key).Check(r.Context())
+	a.reportStripe(err)
+	if errors.Is(err, stripe.ErrUnauthorized) {
+		writeValidationErrors(w, fieldErrors{{"apiKey", "was rejected by Stripe, or may not read subscriptions"}})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Stripe error: %v", err))
+		return
+	}
+
+	uid := userID(r)
+	if _, err := a.db.ExecContext(r.Context(), `
+		INSERT INTO stripe_accounts (user_id, api_key, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET api_key = excluded.api_key
+	`, uid, key, a.dbNow()); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.getStripeAccount(w, r)
+}
+
+// disconnectStripe forgets the user's key and every link made with it.
+// The subscriptions keep what was last synced.
+func (a *App) disconnectStripe(w http.ResponseWriter, r *http.Request) {
+	uid := userID(r)
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM stripe_accounts WHERE user_id = $1", uid)
+	if err == nil {
+		_, err = a.db.ExecContext(r.Context(), "DELETE FROM stripe_links WHERE user_id = $1", uid)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "No Stripe account is connected")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) getStripeLink(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	links, err := a.stripeLinks(r.Context(), userID(r), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if len(links) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "The subscription isn't linked to Stripe")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links[0]); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// linkStripe maps a subscription to a Stripe subscription in the user's
+// connected account and syncs it straight away. Each Stripe subscription
+// can be linked to one subscription at a time.
+func (a *App) linkStripe(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	var req stripeLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	stripeID := strings.TrimSpace(req.StripeSubscriptionID)
+	if !strings.HasPrefix(stripeID, "sub_") {
+		writeValidationErrors(w, fieldErrors{{"stripeSubscriptionId", "must be a Stripe subscription ID (sub_...)"}})
+		return
+	}
+
+	ctx, uid := r.Context(), userID(r)
+	client, connected, err := a.stripeFor(ctx, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !connected {
+		writeError(w, http.StatusConflict, codeConflict, "Connect a Stripe account first, with PUT /api/me/stripe")
+		return
+	}
+	var other int
+	err = a.db.QueryRowContext(ctx, "SELECT subscription_id FROM stripe_links WHERE user_id = $1 AND stripe_subscription_id = $2", uid, stripeID).Scan(&other)
+	if err == nil && other != id {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("%s is already linked to subscription %d", stripeID, other))
+		return
+	}
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	// Only IDs that exist in the account are linked, so a typo fails here
+	// rather than on every sync.
+	ss, err := client.Subscription(ctx, stripeID)
+	a.reportStripe(err)
+	switch {
+	case errors.Is(err, stripe.ErrNotFound):
+		writeValidationErrors(w, fieldErrors{{"stripeSubscriptionId", "isn't a subscription in the connected Stripe account"}})
+		return
+	case errors.Is(err, stripe.ErrUnauthorized):
+		writeError(w, http.StatusConflict, codeConflict, "Stripe no longer accepts the connected API key; connect it again")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, codeUpstream, fmt.Sprintf("Stripe error: %v", err))
+		return
+	}
+
+	if _, err := a.db.ExecContext(ctx, `
+		INSERT INTO stripe_links (subscription_id, user_id, stripe_subscription_id) VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id) DO UPDATE SET stripe_subscription_id = excluded.stripe_subscription_id, status = NULL, synced_at = NULL, error = NULL
+	`, id, uid, stripeID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	link, err := a.recordStripeSync(ctx, uid, models.StripeLink{SubscriptionID: id, StripeSubscriptionID: stripeID}, ss, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(link); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) unlinkStripe(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM stripe_links WHERE subscription_id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "The subscription isn't linked to Stripe")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncStripeNow syncs every subscription the user linked, returning the
+// links with how each went. A Stripe subscription that fails to sync
+// keeps its error on the link rather than failing the request.
+func (a *App) syncStripeNow(w http.ResponseWriter, r *http.Request) {
+	ctx, uid := r.Context(), userID(r)
+	client, connected, err := a.stripeFor(ctx, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !connected {
+		writeError(w, http.StatusConflict, codeConflict, "Connect a Stripe account first, with PUT /api/me/stripe")
+		return
+	}
+	links, err := a.syncStripe(ctx, uid, client)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// StartStripeSync syncs every linked subscription of every connected
+// Stripe account now and then every StripeSyncInterval, until ctx is
+// cancelled.
+func (a *App) StartStripeSync(ctx context.Context) {
+	if a.config.StripeSyncInterval <= 0 || a.db == nil {
+		return
+	}
+
+	a.jobs.started("stripe_sync", a.config.StripeSyncInterval)
+	go func() {
+		defer a.jobs.stopped("stripe_sync")
+		ticker := time.NewTicker(a.config.StripeSyncInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.syncStripeAccounts(ctx); err != nil {
+				a.logger.Warn("syncing Stripe subscriptions", "err", err)
+			} else if n > 0 {
+				a.logger.Info("synced Stripe subscriptions", "accounts", n)
+			}
+			a.jobs.ran("stripe_sync")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// syncStripeAccounts syncs the links of every connected account that has
+// any, returning how many accounts it synced.
+func (a *App) syncStripeAccounts(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	type account struct {
+		userID int
+		key    string
+	}
+	var accounts []account
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT user_id, api_key FROM stripe_accounts
+		WHERE user_id IN (SELECT user_id FROM stripe_links)
+		ORDER BY user_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var acc account
+		if err := rows.Scan(&acc.userID, &acc.key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		accounts = append(accounts, acc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, acc := range accounts {
+		if _, err := a.syncStripe(ctx, acc.userID, a.stripeClient(acc.key)); err != nil {
+			return i, err
+		}
+	}
+	return len(accounts), nil
+}
+
+// syncStripe syncs each of the user's links in turn. It only fails on a
+// database error.
+func (a *App) syncStripe(ctx context.Context, userID int, client stripe.Client) ([]models.StripeLink, error) {
+	links, err := a.stripeLinks(ctx, userID, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i, link := range links {
+		ss, err := client.Subscription(ctx, link.StripeSubscriptionID)
+		a.reportStripe(err)
+		if links[i], err = a.recordStripeSync(ctx, userID, link, ss, err); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// stripeLinks lists the user's links, or only the one for subscriptionID
+// if it isn't zero.
+func (a *App) stripeLinks(ctx context.Context, userID, subscriptionID int) ([]models.StripeLink, error) {
+	query := `
+		SELECT subscription_id, stripe_subscription_id, status, synced_at, error
+		FROM stripe_links WHERE user_id = $1
+	`
+	args := []any{userID}
+	if subscriptionID != 0 {
+		query += " AND subscription_id = $2"
+		args = append(args, subscriptionID)
+	}
+	rows, err := a.db.QueryContext(ctx, query+" ORDER BY subscription_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.StripeLink{}
+	for rows.Next() {
+		var link models.StripeLink
+		var status, syncErr sql.NullString
+		var synced sql.NullTime
+		if err := rows.Scan(&link.SubscriptionID, &link.StripeSubscriptionID, &status, &synced, &syncErr); err != nil {
+			return nil, err
+		}
+		if status.Valid {
+			link.Status = &status.String
+		}
+		if synced.Valid {
+			at := synced.Time.UTC().Format(time.RFC3339)
+			link.SyncedAt = &at
+		}
+		if syncErr.Valid {
+			link.Error = &syncErr.String
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// recordStripeSync applies ss, fetched for link with error fetchErr, to
+// the subscription and stores how the sync went.
+func (a *App) recordStripeSync(ctx context.Context, userID int, link models.StripeLink, ss stripe.Subscription, fetchErr error) (models.StripeLink, error) {
+	link.Error = nil
+	if fetchErr != nil {
+		msg := fetchErr.Error()
+		link.Error = &msg
+	} else {
+		link.Status = &ss.Status
+		if err := a.applyStripe(ctx, userID, link.SubscriptionID, ss); err != nil {
+			return link, err
+		}
+	}
+	synced := a.dbNow()
+	at := synced.Format(time.RFC3339)
+	link.SyncedAt = &at
+	_, err := a.db.ExecContext(ctx, `
+		UPDATE stripe_links SET status = $1, synced_at = $2, error = $3
+		WHERE subscription_id = $4 AND user_id = $5
+	`, link.Status, synced, link.Error, link.SubscriptionID, userID)
+	return link, err
+}
+
+// applyStripe updates a subscription from the Stripe subscription it's
+// billed through, if that still renews: its cost from the latest renewal
+// invoice, or from the prices when there's none yet, and its currency,
+// billing cycle and next billing date. The change is recorded as an edit
+// would be.
+func (a *App) applyStripe(ctx context.Context, userID, id int, ss stripe.Subscription) error {
+	if !stripeLive[ss.Status] {
+		return nil
+	}
+	before, err := a.subscriptions.Get(ctx, userID, id)
+	if err == store.ErrNotFound {
+		return nil // deleted since; the link went with it
+	}
+	if err != nil {
+		return err
+	}
+
+	after := before
+	amount, currency := ss.Amount(), ss.Currency
+	if inv := ss.LatestInvoice; inv != nil && inv.Total > 0 && (inv.BillingReason == "subscription_cycle" || inv.BillingReason == "subscription_create") {
+		amount, currency = inv.Total, inv.Currency
+	}
+	if amount > 0 && isCurrencyCode(currency) {
+		after.Cost, after.Currency = models.Money(stripe.Cents(amount, currency)), currency
+	}
+	if !ss.CurrentPeriodEnd.IsZero() {
+		after.NextBilling = models.DateOf(ss.CurrentPeriodEnd)
+	}
+	if len(ss.Items) > 0 {
+		it := ss.Items[0]
+		cycle := models.Recurrence{Interval: it.IntervalCount, Unit: it.Interval}
+		// A cycle of days has no equivalent; an anchored one that agrees is
+		// kept as it is.
+		if current, err := models.ParseRecurrence(before.BillingCycle); cycle.Validate() == nil && (err != nil || current.Interval != cycle.Interval || current.Unit != cycle.Unit) {
+			after.BillingCycle = cycle.StartingOn(after.NextBilling).String()
+		}
+	}
+	if after.Cost == before.Cost && after.Currency == before.Currency && after.BillingCycle == before.BillingCycle && after.NextBilling == before.NextBilling {
+		return nil
+	}
+
+	after, err = a.subscriptions.Update(ctx, userID, after, a.clock.Now())
+	if err == store.ErrNotFound {
+		return nil // changed or deleted since; the next sync catches up
+	}
+	if err != nil {
+		return err
+	}
+	after.Stale = false
+	a.recordAudit(ctx, userID, id, &before, &after)
+	a.recordPriceChange(ctx, userID, before, after)
+	a.emitEvent(ctx, userID, models.EventSubscriptionUpdated, after)
+	a.checkBudgets(ctx, userID)
+	return nil
+}
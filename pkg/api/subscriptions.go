@@ -0,0 +1,749 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+func healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok","message":"Server is running"}`))
+}
+
+func (a *App) dbCheck(w http.ResponseWriter, r *http.Request) {
+	err := a.db.Ping()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error","message":"Database connection failed"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok","message":"Database connection successful"}`))
+}
+
+// subscriptionSortFields are the keys ?sort= accepts.
+var subscriptionSortFields = map[string]bool{
+	store.SortByName:         true,
+	store.SortByCategory:     true,
+	store.SortByCost:         true,
+	store.SortByBillingCycle: true,
+	store.SortByNextBilling:  true,
+	store.SortByCreatedAt:    true,
+	store.SortByUpdatedAt:    true,
+}
+
+// subscriptionFilter reads the list filters shared by every endpoint that
+// returns a set of subscriptions: category, billingCycle, tag, minCost,
+// maxCost, nextBillingBefore and nextBillingAfter (exclusive, YYYY-MM-DD),
+// createdAfter, createdBefore, updatedAfter and updatedBefore (exclusive,
+// RFC 3339), trial (true or false), status, plus sort=key[:asc|desc],...
+func subscriptionFilter(r *http.Request) (store.SubscriptionQuery, error) {
+	q := r.URL.Query()
+	query := store.SubscriptionQuery{
+		Category:     q.Get("category"),
+		BillingCycle: q.Get("billingCycle"),
+		Tag:          q.Get("tag"),
+	}
+
+	if v := q.Get("status"); v != "" {
+		if !slices.Contains(subscriptionStatuses, v) {
+			return query, errors.New("status must be one of " + strings.Join(subscriptionStatuses, ", "))
+		}
+		query.Status = v
+	}
+	if v := q.Get("trial"); v != "" {
+		trial, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, errors.New("trial must be true or false")
+		}
+		query.Trial = &trial
+	}
+	for _, f := range []struct {
+		param string
+		dest  **models.Money
+	}{{"minCost", &query.MinCost}, {"maxCost", &query.MaxCost}} {
+		if v := q.Get(f.param); v != "" {
+			cost, err := models.ParseMoney(v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a number", f.param)
+			}
+			*f.dest = &cost
+		}
+	}
+	for _, f := range []struct {
+		param string
+		dest  *models.Date
+	}{{"nextBillingAfter", &query.NextBillingAfter}, {"nextBillingBefore", &query.NextBillingBefore}} {
+		if v := q.Get(f.param); v != "" {
+			date, err := models.ParseDate(v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be YYYY-MM-DD", f.param)
+			}
+			*f.dest = date
+		}
+	}
+	for _, f := range []struct {
+		param string
+		dest  *time.Time
+	}{
+		{"createdAfter", &query.CreatedAfter},
+		{"createdBefore", &query.CreatedBefore},
+		{"updatedAfter", &query.UpdatedAfter},
+		{"updatedBefore", &query.UpdatedBefore},
+	} {
+		if v := q.Get(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 timestamp", f.param)
+			}
+			*f.dest = t
+		}
+	}
+
+	var err error
+	query.Sort, err = parseSort(q.Get("sort"), subscriptionSortFields)
+	return query, err
+}
+
+// subscriptionID reads the {id} route variable, writing a 400 and returning
+// false when it isn't a number.
+func subscriptionID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// ownedSubscription checks the subscription in the path is the caller's
+// and returns its ID, for routes about something that belongs to it.
+func (a *App) ownedSubscription(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return 0, false
+	}
+	_, err := a.subscriptions.Get(r.Context(), userID(r), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return 0, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return 0, false
+	}
+	return id, true
+}
+
+// getSubscriptions returns one page of the subscriptions that aren't
+// archived matching the filters in subscriptionFilter, soonest billing
+// first by default. ?limit (default 50, max 500) and ?offset select the
+// page; the total is also sent in X-Total-Count. Like a single
+// subscription, the page has an ETag, so a poller can send If-None-Match
+// and get a 304 when nothing changed.
+func (a *App) getSubscriptions(w http.ResponseWriter, r *http.Request) {
+	a.listSubscriptions(w, r, false)
+}
+
+// listSubscriptions is getSubscriptions for either the archived
+// subscriptions or the rest.
+func (a *App) listSubscriptions(w http.ResponseWriter, r *http.Request, archived bool) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	query, err := subscriptionFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	query.Limit, query.Offset = limit, offset
+	query.Archived = &archived
+
+	items, total, err := a.subscriptions.List(r.Context(), userID(r), query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	for i := range items {
+		a.setStale(&items[i])
+	}
+	page := models.Page[models.Subscription]{Items: items, Total: total, Limit: limit, Offset: offset}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	writeTagged(w, r, page)
+}
+
+func (a *App) getSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	s, err := a.subscriptions.Get(r.Context(), userID(r), id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		}
+		return
+	}
+	a.writeSubscription(w, r, s)
+}
+
+// CreateSubscription creates a new subscription
+func (a *App) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var in subscriptionInput
+
+	bodyBytes, ok := readJSONBody(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if in.CatalogID != "" {
+		if errs := a.fillFromCatalog(&in); len(errs) > 0 {
+			writeValidationErrors(w, errs)
+			return
+		}
+	}
+	in.Subscription = asNew(in.Subscription)
+
+	s, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	uid := userID(r)
+	if !a.checkQuota(r.Context(), w, uid, QuotaSubscriptions, 1) {
+		return
+	}
+	if !a.checkDuplicates(w, r, uid, s) {
+		return
+	}
+
+	s, err := a.subscriptions.Create(r.Context(), uid, s, a.clock.Now(), a.subscriptionLimit())
+	if errors.Is(err, store.ErrLimitReached) {
+		a.writeLimitReached(r.Context(), w, uid, QuotaSubscriptions)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	s.Stale = false
+	if in.CatalogID != "" {
+		a.saveCatalogCancelURL(r.Context(), uid, s.ID, in.CatalogID)
+	}
+	a.recordAudit(r.Context(), uid, s.ID, nil, &s)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionCreated, s)
+	a.checkBudgets(r.Context(), uid)
+	w.Header().Set("Location", "/api/subscriptions/"+strconv.Itoa(s.ID))
+	a.setSubscriptionETag(w, r, uid, s.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// UpdateSubscription updates an existing subscription
+func (a *App) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	var in subscriptionInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	s, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	s.ID = id
+	uid := userID(r)
+	before, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	conditional, ok := a.checkIfMatch(w, r, before, s.Version != 0)
+	if !ok {
+		return
+	}
+	if s.Version != 0 && s.Version != before.Version {
+		a.setStale(&before)
+		writeVersionConflict(w, before)
+		return
+	}
+	if conditional {
+		s.Version = before.Version
+	}
+	// The status only changes through pause, resume and cancel.
+	s.Status, s.CancelledAt, s.CancellationReason = before.Status, before.CancelledAt, before.CancellationReason
+	s.ArchivedAt = before.ArchivedAt
+	s, err = a.subscriptions.Update(r.Context(), uid, s, a.clock.Now())
+	if err == store.ErrNotFound && conditional {
+		writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, "The subscription has changed since it was fetched")
+		return
+	}
+	if err == store.ErrNotFound {
+		a.writeUpdateConflict(w, r, uid, id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	s.Stale = false
+	a.recordAudit(r.Context(), uid, id, &before, &s)
+	a.recordPriceChange(r.Context(), uid, before, s)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionUpdated, s)
+	a.checkBudgets(r.Context(), uid)
+	a.setSubscriptionETag(w, r, uid, id)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// subscriptionPatch is the body of PATCH /api/subscriptions/{id}. Fields
+// that are absent or null keep their stored value.
+type subscriptionPatch struct {
+	Name         *string            `json:"name"`
+	Category     *string            `json:"category"`
+	Cost         *models.Money      `json:"cost"`
+	Currency     *string            `json:"currency"`
+	BillingCycle *string            `json:"billingCycle"`
+	Recurrence   *models.Recurrence `json:"recurrence"`
+	NextBilling  *string            `json:"nextBilling"`
+	Description  *string            `json:"description"`
+	Tags         []string           `json:"tags"`
+	IsTrial      *bool              `json:"isTrial"`
+	TrialEndsAt  *string            `json:"trialEndsAt"`
+	// Version is the version the patch is meant for, which is required
+	// unless the request sends If-Match; see models.Subscription.
+	Version *int `json:"version"`
+}
+
+// apply copies the fields set in p onto in.
+func (p subscriptionPatch) apply(in *subscriptionInput) {
+	s := &in.Subscription
+	for _, f := range []struct {
+		value *string
+		dest  *string
+	}{
+		{p.Name, &s.Name},
+		{p.Category, &s.Category},
+		{p.Currency, &s.Currency},
+		{p.BillingCycle, &s.BillingCycle},
+		{p.NextBilling, &in.NextBilling},
+		{p.Description, &s.Description},
+	} {
+		if f.value != nil {
+			*f.dest = *f.value
+		}
+	}
+	if p.Cost != nil {
+		s.Cost = *p.Cost
+	}
+	if p.Recurrence != nil {
+		// A recurrence replaces the stored cycle unless billingCycle is
+		// patched too, when the two have to agree.
+		in.Recurrence = p.Recurrence
+		if p.BillingCycle == nil {
+			s.BillingCycle = ""
+		}
+	}
+	if p.Tags != nil {
+		s.Tags = p.Tags
+	}
+	if p.IsTrial != nil {
+		s.IsTrial = *p.IsTrial
+	}
+	if p.TrialEndsAt != nil {
+		s.TrialEndsAt = p.TrialEndsAt
+	}
+}
+
+// patchSubscription updates only the fields present in the body, so a
+// client can change the cost without resending everything else. Unknown
+// fields are rejected rather than ignored, so a typo can't look like a
+// successful update.
+func (a *App) patchSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	var patch subscriptionPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	uid := userID(r)
+	s, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	conditional, ok := a.checkIfMatch(w, r, s, patch.Version != nil)
+	if !ok {
+		return
+	}
+	if patch.Version != nil && *patch.Version != s.Version {
+		a.setStale(&s)
+		writeVersionConflict(w, s)
+		return
+	}
+
+	before := s
+	in := subscriptionInput{Subscription: s, NextBilling: s.NextBilling.String()}
+	patch.apply(&in)
+	s, errs := validateSubscription(in)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	s, err = a.subscriptions.Update(r.Context(), uid, s, a.clock.Now())
+	if err == store.ErrNotFound && conditional {
+		writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, "The subscription has changed since it was fetched")
+		return
+	}
+	if err == store.ErrNotFound {
+		a.writeUpdateConflict(w, r, uid, id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	s.Stale = false
+	a.recordAudit(r.Context(), uid, id, &before, &s)
+	a.recordPriceChange(r.Context(), uid, before, s)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionUpdated, s)
+	a.checkBudgets(r.Context(), uid)
+	a.setSubscriptionETag(w, r, uid, id)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteSubscription removes a subscription
+func (a *App) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+
+	uid := userID(r)
+	before, err := a.subscriptions.Get(r.Context(), uid, id)
+	if err == nil {
+		err = a.subscriptions.Delete(r.Context(), uid, id)
+	}
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.recordAudit(r.Context(), uid, id, &before, nil)
+	a.emitEvent(r.Context(), uid, models.EventSubscriptionDeleted, deletedSubscription{id})
+	a.purgeAttachments(r.Context(), uid, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Subscriptions bill on different cycles, so every figure is normalized:
+// a $120 yearly plan adds $10 to the monthly totals, not $120.
+type categoryStat struct {
+	Category string       `json:"category"`
+	Count    int          `json:"count"`
+	Monthly  models.Money `json:"monthly"`
+	Yearly   models.Money `json:"yearly"`
+}
+
+// A subscription counts towards each of its tags, so tag figures can add
+// up to more than the totals.
+type tagStat struct {
+	Tag     string       `json:"tag"`
+	Count   int          `json:"count"`
+	Monthly models.Money `json:"monthly"`
+	Yearly  models.Money `json:"yearly"`
+}
+
+// spending is what a set of subscriptions costs, in total and by category
+// and tag, biggest first.
+type spending struct {
+	TotalMonthly models.Money   `json:"totalMonthly"`
+	TotalYearly  models.Money   `json:"totalYearly"`
+	ByCategory   []categoryStat `json:"byCategory"`
+	ByTag        []tagStat      `json:"byTag"`
+}
+
+// summarizeSpending totals subs in the currency convert converts to. If a
+// conversion fails it returns the currency it couldn't convert. Callers
+// pass only active subscriptions, since paused and cancelled ones cost
+// nothing.
+func summarizeSpending(subs []models.Subscription, convert func(from string, amount models.Money) (models.Money, error)) (spending, string, error) {
+	result := spending{ByCategory: []categoryStat{}, ByTag: []tagStat{}}
+	byCategory := map[string]*categoryStat{}
+	byTag := map[string]*tagStat{}
+	var totalYearly models.Money
+	for _, s := range subs {
+		yearly, err := convert(s.Currency, yearlyCost(s))
+		if err != nil {
+			return spending{}, s.Currency, err
+		}
+		c := byCategory[s.Category]
+		if c == nil {
+			c = &categoryStat{Category: s.Category}
+			byCategory[s.Category] = c
+		}
+		c.Count++
+		c.Yearly += yearly
+		totalYearly += yearly
+		for _, tag := range s.Tags {
+			t := byTag[tag]
+			if t == nil {
+				t = &tagStat{Tag: tag}
+				byTag[tag] = t
+			}
+			t.Count++
+			t.Yearly += yearly
+		}
+	}
+	for _, c := range byCategory {
+		c.Monthly = perMonth(c.Yearly)
+		result.ByCategory = append(result.ByCategory, *c)
+	}
+	result.TotalMonthly, result.TotalYearly = perMonth(totalYearly), totalYearly
+	sort.Slice(result.ByCategory, func(i, j int) bool {
+		if result.ByCategory[i].Yearly != result.ByCategory[j].Yearly {
+			return result.ByCategory[i].Yearly > result.ByCategory[j].Yearly
+		}
+		return result.ByCategory[i].Category < result.ByCategory[j].Category
+	})
+	for _, t := range byTag {
+		t.Monthly = perMonth(t.Yearly)
+		result.ByTag = append(result.ByTag, *t)
+	}
+	sort.Slice(result.ByTag, func(i, j int) bool {
+		if result.ByTag[i].Yearly != result.ByTag[j].Yearly {
+			return result.ByTag[i].Yearly > result.ByTag[j].Yearly
+		}
+		return result.ByTag[i].Tag < result.ByTag[j].Tag
+	})
+	return result, "", nil
+}
+
+// getStats returns statistics about the subscriptions. Amounts are
+// converted to ?currency, by default the user's display currency. With
+// ?asOf the upcoming renewals and price increases are those as of that
+// date rather than today, and with ?compareTo=previous_month or
+// previous_year the totals are compared with what they were a month or a
+// year ago. They're cached for Config.StatsCacheTTL, until the user
+// changes something they depend on.
+func (a *App) getStats(w http.ResponseWriter, r *http.Request) {
+	currency, ok := a.statsCurrency(w, r)
+	if !ok {
+		return
+	}
+	now, asOfGiven, ok := a.asOf(w, r)
+	if !ok {
+		return
+	}
+	compareTo := r.URL.Query().Get("compareTo")
+	if _, ok := statsComparisons[compareTo]; compareTo != "" && !ok {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "compareTo must be previous_month or previous_year")
+		return
+	}
+	// Archived subscriptions are left out unless ?includeArchived=true.
+	query := store.SubscriptionQuery{Status: models.StatusActive, Archived: new(bool)}
+	cacheKey := currency
+	includeArchived := false
+	if v := r.URL.Query().Get("includeArchived"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "includeArchived must be true or false")
+			return
+		}
+		if include {
+			query.Archived = nil
+			cacheKey += "+archived"
+			includeArchived = true
+		}
+	}
+	// Price increases count changes made up to the end of the day asked
+	// for, or up to now.
+	through := a.dbNow()
+	var asOfDate string
+	if asOfGiven {
+		through = now.AddDate(0, 0, 1).Add(-time.Second)
+		asOfDate = now.Format(dateLayout)
+		cacheKey += "@" + asOfDate
+	}
+	if compareTo != "" {
+		cacheKey += "~" + compareTo
+	}
+	if a.cachedStats(w, r, cacheKey) {
+		return
+	}
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	stats := struct {
+		Currency string `json:"currency"`
+		// AsOf is the date asked for with ?asOf, which the upcoming
+		// renewals and price increases are computed for and the comparison
+		// counts back from; the totals and budgets are always the current
+		// ones.
+		AsOf string `json:"asOf,omitempty"`
+		spending
+		Upcoming []models.Subscription `json:"upcoming"`
+		// PriceIncreases are the subscriptions costing more than a year
+		// ago, in their own currency.
+		PriceIncreases []priceIncrease `json:"priceIncreases"`
+		Budgets        []budgetStatus  `json:"budgets"`
+		// Comparison is there with ?compareTo.
+		Comparison *statsComparison `json:"comparison,omitempty"`
+	}{
+		Currency: currency,
+		AsOf:     asOfDate,
+	}
+
+	var unconverted string
+	convert := a.converter(r.Context(), currency)
+	stats.spending, unconverted, err = summarizeSpending(subs, convert)
+	if err != nil {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+	if compareTo != "" {
+		at := statsComparisons[compareTo](through)
+		c, unconverted, err := a.compareStats(r.Context(), userID(r), at, includeArchived, stats.spending, convert)
+		if unconverted != "" {
+			a.writeConversionError(w, unconverted, currency, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		c.CompareTo = compareTo
+		stats.Comparison = &c
+	}
+
+	stats.PriceIncreases, err = a.priceIncreases(r.Context(), userID(r), subs, through)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	budgets, err := a.budgets(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	stats.Budgets, unconverted, err = a.budgetStatuses(r.Context(), budgets, subs, currency)
+	if err != nil {
+		a.writeConversionError(w, unconverted, currency, err)
+		return
+	}
+
+	stats.Upcoming = a.upcoming(subs, now, statsUpcomingDays)
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+		return
+	}
+	body = append(body, '\n')
+	a.cacheStats(w, r, cacheKey, body, a.clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// statsUpcomingDays is how far ahead the stats list renewals.
+const statsUpcomingDays = 7
+
+// upcoming lists the subscriptions renewing in the days from now, soonest
+// first. A next billing date before now is rolled forward along
+// the subscription's cycle, and the subscription given the date it renews
+// on, so stats as of a later date see the renewals due then.
+func (a *App) upcoming(subs []models.Subscription, now time.Time, days int) []models.Subscription {
+	from := models.DateOf(now).Time()
+	to := from.AddDate(0, 0, days)
+	upcoming := []models.Subscription{}
+	for _, s := range subs {
+		if s.NextBilling.IsZero() {
+			continue
+		}
+		next, start := s.NextBilling.Time(), from
+		if next.After(start) {
+			start = next
+		}
+		dates := billingDatesBetween(next, s.BillingCycle, start, to)
+		if len(dates) == 0 {
+			continue
+		}
+		s.NextBilling = models.DateOf(dates[0])
+		a.setStale(&s)
+		upcoming = append(upcoming, s)
+	}
+	slices.SortStableFunc(upcoming, func(x, y models.Subscription) int {
+		return x.NextBilling.Time().Compare(y.NextBilling.Time())
+	})
+	return upcoming
+}
@@ -0,0 +1,443 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"subscription-tracker/pkg/clock"
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// These tests run the subscription endpoints against the in-memory
+// repository, so they need no database and run without the integration tag.
+
+func newMemoryApp(t *testing.T) (*App, http.Handler) {
+	t.Helper()
+	app, err := New(Config{JWTSecret: "test", TokenTTL: time.Hour, StaleAfterMonths: 6}, nil, Services{
+		Subscriptions: store.NewMemorySubscriptions(),
+		Clock:         clock.NewFake(time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, app.Router()
+}
+
+func serveAs(t *testing.T, app *App, router http.Handler, user int, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := app.issueToken(user, defaultTenant, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMemorySubscriptionCRUD(t *testing.T) {
+	app, router := newMemoryApp(t)
+	netflix := models.Subscription{Name: "Netflix", Category: "Entertainment", Cost: 1549, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-12")}
+
+	w := serveAs(t, app, router, 1, "POST", "/api/subscriptions", netflix)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body)
+	}
+	var created models.Subscription
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.ID == 0 || created.LastVerifiedAt == nil {
+		t.Fatalf("unexpected created subscription: %+v", created)
+	}
+	path := "/api/subscriptions/" + strconv.Itoa(created.ID)
+
+	netflix.Cost, netflix.Version = 1799, created.Version
+	if w := serveAs(t, app, router, 1, "PUT", path, netflix); w.Code != http.StatusOK {
+		t.Fatalf("update: got %d: %s", w.Code, w.Body)
+	}
+	w = serveAs(t, app, router, 1, "GET", path, nil)
+	var got models.Subscription
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Cost != 1799 || got.Stale {
+		t.Errorf("get after update: %d %+v", w.Code, got)
+	}
+
+	w = serveAs(t, app, router, 1, "PATCH", path, map[string]any{"description": "4K plan", "version": got.Version})
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Cost != 1799 || got.Description != "4K plan" {
+		t.Errorf("patch: %d %+v", w.Code, got)
+	}
+
+	if w := serveAs(t, app, router, 2, "GET", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user's get: got %d, want 404", w.Code)
+	}
+	if w := serveAs(t, app, router, 2, "DELETE", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user's delete: got %d, want 404", w.Code)
+	}
+
+	if w := serveAs(t, app, router, 1, "DELETE", path, nil); w.Code != http.StatusNoContent {
+		t.Errorf("delete: got %d", w.Code)
+	}
+	if w := serveAs(t, app, router, 1, "GET", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("get after delete: got %d, want 404", w.Code)
+	}
+}
+
+func TestAppLogger(t *testing.T) {
+	// Two Apps side by side log to their own loggers.
+	var logs [2]bytes.Buffer
+	for i := range logs {
+		app, err := New(Config{JWTSecret: "test", TokenTTL: time.Hour}, nil, Services{
+			Subscriptions: store.NewMemorySubscriptions(),
+			Logger:        slog.New(slog.NewTextHandler(&logs[i], nil)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		serveAs(t, app, app.Router(), i+1, "GET", "/api/subscriptions/"+strconv.Itoa(i+1), nil)
+	}
+	for i := range logs {
+		want := "path=/api/subscriptions/" + strconv.Itoa(i+1) + " status=404"
+		if got := logs[i].String(); strings.Count(got, "msg=request") != 1 || !strings.Contains(got, want) {
+			t.Errorf("app %d logged %q, want one request line with %s", i, got, want)
+		}
+	}
+}
+
+func TestNewRandomJWTSecret(t *testing.T) {
+	// Without a secret each App makes up its own, so one's tokens don't
+	// work on another.
+	var apps [2]*App
+	for i := range apps {
+		app, err := New(Config{TokenTTL: time.Hour}, nil, Services{
+			Subscriptions: store.NewMemorySubscriptions(),
+			Logger:        slog.New(slog.DiscardHandler),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(app.config.JWTSecret) != 64 {
+			t.Fatalf("generated secret %q, want 32 random bytes in hex", app.config.JWTSecret)
+		}
+		apps[i] = app
+	}
+	if apps[0].config.JWTSecret == apps[1].config.JWTSecret {
+		t.Error("two Apps generated the same secret")
+	}
+	if w := serveAs(t, apps[0], apps[0].Router(), 1, "GET", "/api/subscriptions", nil); w.Code != http.StatusOK {
+		t.Errorf("token on its own App: got %d", w.Code)
+	}
+	token, err := apps[0].issueToken(1, defaultTenant, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/subscriptions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	apps[1].Router().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token on another App: got %d, want 401", w.Code)
+	}
+}
+
+func TestMemorySubscriptionList(t *testing.T) {
+	app, router := newMemoryApp(t)
+	trialEnds := "2025-05-11"
+	for _, s := range []models.Subscription{
+		{Name: "Netflix", Category: "Entertainment", Cost: 1549, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-12"), IsTrial: true, TrialEndsAt: &trialEnds},
+		{Name: "Spotify", Category: "Music", Cost: 1099, BillingCycle: "monthly", NextBilling: models.MustParseDate("2025-05-03"), Tags: []string{"Shared"}},
+		{Name: "AWS", Category: "Cloud", Cost: 12000, BillingCycle: "yearly", NextBilling: models.MustParseDate("2025-11-01"), Tags: []string{"work", "shared"}},
+	} {
+		if w := serveAs(t, app, router, 1, "POST", "/api/subscriptions", s); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %d", s.Name, w.Code)
+		}
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"Spotify", "Netflix", "AWS"}, 3},
+		{"?sort=cost:desc", []string{"AWS", "Netflix", "Spotify"}, 3},
+		{"?billingCycle=monthly&limit=1&offset=1", []string{"Netflix"}, 2},
+		{"?maxCost=12", []string{"Spotify"}, 1},
+		{"?nextBillingAfter=2025-05-03&nextBillingBefore=2025-11-01", []string{"Netflix"}, 1},
+		{"?tag=shared", []string{"Spotify", "AWS"}, 2},
+		{"?tag=work&category=Music", nil, 0},
+		{"?trial=true", []string{"Netflix"}, 1},
+		{"?trial=false&sort=name", []string{"AWS", "Spotify"}, 2},
+	} {
+		w := serveAs(t, app, router, 1, "GET", "/api/subscriptions"+c.query, nil)
+		var page models.Page[models.Subscription]
+		json.Unmarshal(w.Body.Bytes(), &page)
+		var names []string
+		for _, s := range page.Items {
+			names = append(names, s.Name)
+		}
+		if w.Code != http.StatusOK || page.Total != c.total || len(names) != len(c.want) {
+			t.Errorf("%q: got %d %v (total %d), want %v (total %d)", c.query, w.Code, names, page.Total, c.want, c.total)
+			continue
+		}
+		for i := range names {
+			if names[i] != c.want[i] {
+				t.Errorf("%q: got %v, want %v", c.query, names, c.want)
+				break
+			}
+		}
+	}
+
+	for _, bad := range []string{"sort=price", "trial=maybe"} {
+		if w := serveAs(t, app, router, 1, "GET", "/api/subscriptions?"+bad, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", bad, w.Code)
+		}
+	}
+}
+
+func TestSubscriptionValidation(t *testing.T) {
+	app, router := newMemoryApp(t)
+
+	bad := subscriptionInput{
+		Subscription: models.Subscription{Name: " ", Category: "Video", Cost: -300, Currency: "$", BillingCycle: "fortnightly", IsTrial: true},
+		NextBilling:  "2025-02-30",
+	}
+	w := serveAs(t, app, router, 1, "POST", "/api/subscriptions", bad)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("create: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Code   string      `json:"code"`
+		Errors fieldErrors `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	var fields []string
+	for _, e := range body.Errors {
+		fields = append(fields, e.Field)
+	}
+	if body.Code != codeValidation || strings.Join(fields, ",") != "name,cost,currency,billingCycle,nextBilling,trialEndsAt" {
+		t.Errorf("unexpected validation errors: %s", w.Body)
+	}
+
+	// Patches are validated against the merged result.
+	ok := models.Subscription{Name: "Netflix", Category: "Video", Cost: 1549, BillingCycle: "Annual", NextBilling: models.MustParseDate("2025-05-12")}
+	w = serveAs(t, app, router, 1, "POST", "/api/subscriptions", ok)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body)
+	}
+	var created models.Subscription
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Currency != models.DefaultCurrency {
+		t.Errorf("created in %q, want the default currency", created.Currency)
+	}
+	path := "/api/subscriptions/" + strconv.Itoa(created.ID)
+	w = serveAs(t, app, router, 1, "PATCH", path, map[string]any{"billingCycle": "daily", "version": created.Version})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "must be one of weekly, monthly, quarterly, yearly") {
+		t.Errorf("patch: got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRecurringBillingCycles(t *testing.T) {
+	app, router := newMemoryApp(t)
+	create := func(body map[string]any) (*httptest.ResponseRecorder, models.Subscription) {
+		t.Helper()
+		full := map[string]any{"name": "Gym", "category": "Health", "cost": 25}
+		for k, v := range body {
+			full[k] = v
+		}
+		w := serveAs(t, app, router, 1, "POST", "/api/subscriptions", full)
+		var got models.Subscription
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w, got
+	}
+
+	for _, c := range []struct {
+		body map[string]any
+		want string
+	}{
+		{map[string]any{"recurrence": map[string]any{"interval": 2, "unit": "week"}, "nextBilling": "2025-05-06"}, "every 2 weeks"},
+		{map[string]any{"billingCycle": " Every 3 Months on the Last Day", "nextBilling": "2025-05-31"}, "quarterly on the last day"},
+		{map[string]any{"billingCycle": "every 5 months on LAST day", "nextBilling": "2025-05-31"}, "every 5 months on the last day"},
+		{map[string]any{"billingCycle": "annually", "nextBilling": "2025-05-28"}, "yearly"},
+		{map[string]any{"billingCycle": "every 1 month", "nextBilling": "2025-05-15"}, "monthly"},
+		// A day not every month has becomes the anchor.
+		{map[string]any{"billingCycle": "every 1 month", "nextBilling": "2025-05-30"}, "monthly on day 30"},
+		{map[string]any{"billingCycle": "annually", "nextBilling": "2028-02-29"}, "yearly on day 29"},
+		{map[string]any{"billingCycle": "every 2 weeks", "nextBilling": "2025-05-31"}, "every 2 weeks"},
+		{map[string]any{"billingCycle": "monthly on day 30", "recurrence": map[string]any{"interval": 1, "unit": "month", "dayOfMonth": 30}, "nextBilling": "2025-06-30"}, "monthly on day 30"},
+	} {
+		if w, got := create(c.body); w.Code != http.StatusCreated || got.BillingCycle != c.want {
+			t.Errorf("%v: got %d, cycle %q; want %q: %s", c.body, w.Code, got.BillingCycle, c.want, w.Body)
+		}
+	}
+
+	for _, c := range []struct {
+		body  map[string]any
+		field string
+	}{
+		{map[string]any{"recurrence": map[string]any{"interval": 1, "unit": "week", "dayOfMonth": 3}, "nextBilling": "2025-05-03"}, "recurrence"},
+		{map[string]any{"recurrence": map[string]any{"interval": 0, "unit": "month"}, "nextBilling": "2025-05-03"}, "recurrence"},
+		{map[string]any{"billingCycle": "every 100 weeks", "nextBilling": "2025-05-03"}, "billingCycle"},
+		{map[string]any{"billingCycle": "monthly", "recurrence": map[string]any{"interval": 2, "unit": "week"}, "nextBilling": "2025-05-03"}, "billingCycle"},
+		{map[string]any{"billingCycle": "monthly on day 0", "nextBilling": "2025-05-03"}, "billingCycle"},
+		{map[string]any{"recurrence": map[string]any{"interval": 1, "unit": "month", "dayOfMonth": -1}, "nextBilling": "2025-05-03"}, "recurrence"},
+		// The anchor day of a 30-day month is the 30th.
+		{map[string]any{"billingCycle": "monthly on day 31", "nextBilling": "2025-06-29"}, "nextBilling"},
+		{map[string]any{"billingCycle": "every month on the last day", "nextBilling": "2025-05-30"}, "nextBilling"},
+	} {
+		w, _ := create(c.body)
+		var body struct {
+			Errors fieldErrors `json:"errors"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusBadRequest || len(body.Errors) != 1 || body.Errors[0].Field != c.field {
+			t.Errorf("%v: got %d %s, want an error in %s", c.body, w.Code, w.Body, c.field)
+		}
+	}
+
+	// Patching in a recurrence replaces the stored cycle.
+	_, gym := create(map[string]any{"billingCycle": "monthly", "nextBilling": "2025-01-31"})
+	path := "/api/subscriptions/" + strconv.Itoa(gym.ID)
+	w := serveAs(t, app, router, 1, "PATCH", path, map[string]any{"recurrence": map[string]any{"interval": 1, "unit": "month", "lastDayOfMonth": true}, "version": gym.Version})
+	var got models.Subscription
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.BillingCycle != "monthly on the last day" {
+		t.Fatalf("patch: got %d: %s", w.Code, w.Body)
+	}
+
+	// Anchored to the last day, the plan goes back to the 31st after
+	// February, even across separate runs.
+	fake := app.clock.(*clock.Fake)
+	for _, c := range []struct{ now, want string }{{"2025-02-10", "2025-02-28"}, {"2025-03-10", "2025-03-31"}, {"2025-04-10", "2025-04-30"}} {
+		fake.Set(models.MustParseDate(c.now).Time())
+		if _, err := app.rollForward(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal(serveAs(t, app, router, 1, "GET", path, nil).Body.Bytes(), &got)
+		if got.NextBilling.String() != c.want {
+			t.Errorf("on %s next billing = %s, want %s", c.now, got.NextBilling, c.want)
+		}
+	}
+}
+
+// A plain monthly cycle started on the 31st keeps to it across separate
+// roll-forward runs, though each stores the date of a shorter month.
+func TestRollForwardKeepsStartDay(t *testing.T) {
+	app, router := newMemoryApp(t)
+	w := serveAs(t, app, router, 1, "POST", "/api/subscriptions", map[string]any{"name": "Gym", "category": "Health", "cost": 25, "billingCycle": "monthly", "nextBilling": "2025-01-31"})
+	var got models.Subscription
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusCreated || got.BillingCycle != "monthly on day 31" {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body)
+	}
+	path := "/api/subscriptions/" + strconv.Itoa(got.ID)
+
+	fake := app.clock.(*clock.Fake)
+	for _, c := range []struct{ now, want string }{{"2025-02-10", "2025-02-28"}, {"2025-03-10", "2025-03-31"}, {"2025-04-10", "2025-04-30"}, {"2025-05-10", "2025-05-31"}} {
+		fake.Set(models.MustParseDate(c.now).Time())
+		if _, err := app.rollForward(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal(serveAs(t, app, router, 1, "GET", path, nil).Body.Bytes(), &got)
+		if got.NextBilling.String() != c.want {
+			t.Errorf("on %s next billing = %s, want %s", c.now, got.NextBilling, c.want)
+		}
+	}
+}
+
+func TestRecurrenceArithmetic(t *testing.T) {
+	date := func(s string) time.Time { return models.MustParseDate(s).Time() }
+	for _, c := range []struct {
+		from, cycle string
+		n           int
+		want        string
+	}{
+		{"2025-05-06", "every 2 weeks", 3, "2025-06-17"},
+		{"2025-01-31", "monthly", 1, "2025-02-28"},
+		{"2025-02-28", "monthly", 1, "2025-03-28"},
+		{"2025-02-28", "monthly on the last day", 1, "2025-03-31"},
+		{"2025-02-28", "monthly on day 30", 1, "2025-03-30"},
+		{"2025-03-30", "monthly on day 30", -1, "2025-02-28"},
+		{"2024-02-29", "yearly", 1, "2025-02-28"},
+		{"2025-02-28", "every 2 years on the last day", 1, "2027-02-28"},
+		{"2027-02-28", "yearly on the last day", 1, "2028-02-29"},
+		{"2025-01-15", "every 5 months", 2, "2025-11-15"},
+	} {
+		got, ok := addCycle(date(c.from), c.cycle, c.n)
+		if !ok || got.Format(dateLayout) != c.want {
+			t.Errorf("%d × %q from %s = %s, want %s", c.n, c.cycle, c.from, got.Format(dateLayout), c.want)
+		}
+	}
+
+	for cycle, want := range map[string]string{
+		"every 2 weeks":                  "FREQ=WEEKLY;INTERVAL=2",
+		"quarterly":                      "FREQ=MONTHLY;INTERVAL=3",
+		"monthly on day 15":              "FREQ=MONTHLY;BYMONTHDAY=15",
+		"monthly on day 30":              "FREQ=MONTHLY;BYMONTHDAY=28,29,30;BYSETPOS=-1",
+		"every 2 years on the last day":  "FREQ=MONTHLY;INTERVAL=24;BYMONTHDAY=-1",
+		"every 3 years":                  "FREQ=YEARLY;INTERVAL=3",
+		"every 6 months on the last day": "FREQ=MONTHLY;INTERVAL=6;BYMONTHDAY=-1",
+	} {
+		if got, ok := cycleRRule(cycle); !ok || got != want {
+			t.Errorf("rule for %q = %s, want %s", cycle, got, want)
+		}
+	}
+
+	for cycle, want := range map[string]models.Money{"every 2 weeks": 260000, "every 5 months": 24000, "every 2 years": 5000, "weekly": 520000} {
+		if got := yearlyCost(models.Subscription{Cost: 10000, BillingCycle: cycle}); got != want {
+			t.Errorf("yearly cost of 100.00 %s = %s, want %s", cycle, got, want)
+		}
+	}
+}
+
+// TestTOTP checks codes against the SHA-1 test vectors in RFC 6238,
+// truncated to six digits.
+func TestTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := totpCode(secret, totpStep(time.Unix(unix, 0)))
+		if err != nil || got != want {
+			t.Errorf("code at %d = %q, %v; want %q", unix, got, err, want)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step := totpStep(now)
+	previous, _ := totpCode(secret, step-1)
+	if got, ok := totpMatch(secret, previous, now, 0); !ok || got != step-1 {
+		t.Errorf("previous step's code matched %d, %v", got, ok)
+	}
+	if _, ok := totpMatch(secret, previous, now, step-1); ok {
+		t.Error("a used code matched again")
+	}
+	stale, _ := totpCode(secret, step-2)
+	if _, ok := totpMatch(secret, stale, now, 0); ok {
+		t.Error("a code two steps old matched")
+	}
+
+	if got := normalizeRecoveryCode(" K3F9X-2mq7d"); got != "k3f9x2mq7d" {
+		t.Errorf("normalized recovery code = %q", got)
+	}
+	if isTOTPCode("k3f9x-2mq7d") || !isTOTPCode("005924") {
+		t.Error("isTOTPCode mistook a code")
+	}
+}
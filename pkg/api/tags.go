@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/store"
+)
+
+// maxTagLength caps a tag name, in characters, after trimming.
+const maxTagLength = 32
+
+// tagRequest is the body of POST /api/tags and PUT /api/tags/{id}.
+type tagRequest struct {
+	Name string `json:"name"`
+}
+
+// tagNameError says what's wrong with a tag name, or returns "" if
+// nothing is. Commas are kept out so tags can be listed in one CSV cell.
+func tagNameError(name string) string {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "is required"
+	case len([]rune(name)) > maxTagLength:
+		return fmt.Sprintf("must be at most %d characters", maxTagLength)
+	case strings.Contains(name, ","):
+		return "must not contain commas"
+	}
+	return ""
+}
+
+// readTagRequest decodes and validates a tag body, writing the error and
+// returning false if it's no good.
+func readTagRequest(w http.ResponseWriter, r *http.Request) (tagRequest, bool) {
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return req, false
+	}
+	if msg := tagNameError(req.Name); msg != "" {
+		var errs fieldErrors
+		errs.add("name", msg)
+		writeValidationErrors(w, errs)
+		return req, false
+	}
+	return req, true
+}
+
+// getTags lists the user's tags by name, each with how many subscriptions
+// carry it.
+func (a *App) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := a.subscriptions.Tags(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// createTag adds a tag no subscription carries yet. Naming a new tag on a
+// subscription creates it too, so this is only needed to set tags up
+// ahead of time.
+func (a *App) createTag(w http.ResponseWriter, r *http.Request) {
+	req, ok := readTagRequest(w, r)
+	if !ok {
+		return
+	}
+	tag, err := a.subscriptions.CreateTag(r.Context(), userID(r), req.Name)
+	if err == store.ErrDuplicate {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("You already have a tag named %q", tag.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tag); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// renameTag renames a tag on every subscription carrying it.
+func (a *App) renameTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	req, ok := readTagRequest(w, r)
+	if !ok {
+		return
+	}
+	tag, err := a.subscriptions.RenameTag(r.Context(), userID(r), id, req.Name)
+	switch {
+	case err == store.ErrNotFound:
+		writeError(w, http.StatusNotFound, codeNotFound, "Tag not found")
+		return
+	case err == store.ErrDuplicate:
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("You already have a tag named %q", tag.Name))
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	a.invalidateStats(r.Context(), userID(r))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tag); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteTag removes a tag from every subscription carrying it; the
+// subscriptions themselves are kept.
+func (a *App) deleteTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	err = a.subscriptions.DeleteTag(r.Context(), userID(r), id)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, "Tag not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	a.invalidateStats(r.Context(), userID(r))
+	w.WriteHeader(http.StatusNoContent)
+}
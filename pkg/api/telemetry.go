@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+// Telemetry is off unless TELEMETRY_ENABLED=true and an endpoint is set.
+// Reports contain only a random instance ID, coarse size buckets and
+// per-route request counters: no names, costs or other user data.
+
+// featureCounters counts requests per route template since the last report.
+type featureCounters struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// TelemetryReport is the exact payload POSTed to the telemetry endpoint.
+type TelemetryReport struct {
+	InstanceID string            `json:"instanceId"`
+	Version    string            `json:"version"`
+	GoVersion  string            `json:"goVersion"`
+	Platform   string            `json:"platform"`
+	Size       map[string]string `json:"size"`
+	Features   map[string]int    `json:"features"`
+}
+
+// telemetryMiddleware counts feature usage by route template, so IDs in
+// paths never leave the instance.
+func (a *App) telemetryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tpl := routeTemplate(r); tpl != "" {
+			a.features.Lock()
+			a.features.counts[r.Method+" "+tpl]++
+			a.features.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instanceID returns the random identifier for this installation, creating
+// it on first use.
+func (a *App) instanceID() (string, error) {
+	var id string
+	err := a.db.QueryRow("SELECT value FROM instance_settings WHERE key = 'instance_id'").Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(buf)
+	if _, err := a.db.Exec(`
+		INSERT INTO instance_settings (key, value) VALUES ('instance_id', $1)
+		ON CONFLICT (key) DO NOTHING
+	`, id); err != nil {
+		return "", err
+	}
+	// Another instance may have won the race; read back whatever is stored.
+	err = a.db.QueryRow("SELECT value FROM instance_settings WHERE key = 'instance_id'").Scan(&id)
+	return id, err
+}
+
+// sizeBucket turns an exact row count into a coarse range.
+func sizeBucket(n int) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n <= 10:
+		return "1-10"
+	case n <= 50:
+		return "11-50"
+	case n <= 200:
+		return "51-200"
+	case n <= 1000:
+		return "201-1000"
+	}
+	return "1000+"
+}
+
+func (a *App) buildTelemetryReport() (TelemetryReport, error) {
+	id, err := a.instanceID()
+	if err != nil {
+		return TelemetryReport{}, err
+	}
+
+	report := TelemetryReport{
+		InstanceID: id,
+		Version:    a.config.Build.Version,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Size:       map[string]string{},
+		Features:   map[string]int{},
+	}
+	// The counts are of every account's rows.
+	ctx := store.AsSystem(context.Background())
+	for _, table := range []string{"subscriptions", "transactions"} {
+		var n int
+		if err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			return report, err
+		}
+		report.Size[table] = sizeBucket(n)
+	}
+
+	a.features.Lock()
+	for k, v := range a.features.counts {
+		report.Features[k] = v
+	}
+	a.features.Unlock()
+	return report, nil
+}
+
+// sendTelemetry posts one report and, on success, subtracts what was sent
+// from the counters.
+func (a *App) sendTelemetry() error {
+	report, err := a.buildTelemetryReport()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(a.config.TelemetryEndpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+
+	a.features.Lock()
+	for k, v := range report.Features {
+		a.features.counts[k] -= v
+		if a.features.counts[k] <= 0 {
+			delete(a.features.counts, k)
+		}
+	}
+	a.features.Unlock()
+	return nil
+}
+
+// StartTelemetry begins periodic reporting if the operator opted in. It
+// stops when ctx is cancelled.
+func (a *App) StartTelemetry(ctx context.Context) {
+	if !a.config.telemetryActive() {
+		return
+	}
+	a.logger.Info("anonymous telemetry enabled", "endpoint", a.config.TelemetryEndpoint, "interval", a.config.TelemetryInterval)
+
+	a.jobs.started("telemetry", a.config.TelemetryInterval)
+	go func() {
+		defer a.jobs.stopped("telemetry")
+		ticker := time.NewTicker(a.config.TelemetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := a.sendTelemetry()
+			a.integrations.report("telemetry", err)
+			if err != nil {
+				a.logger.Warn("sending telemetry", "err", err)
+			}
+			a.jobs.ran("telemetry")
+		}
+	}()
+}
+
+// getTelemetryPreview shows exactly what the next report would contain,
+// whether or not telemetry is enabled.
+func (a *App) getTelemetryPreview(w http.ResponseWriter, r *http.Request) {
+	report, err := a.buildTelemetryReport()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	preview := struct {
+		Enabled  bool            `json:"enabled"`
+		Endpoint string          `json:"endpoint"`
+		Payload  TelemetryReport `json:"payload"`
+	}{
+		Enabled:  a.config.telemetryActive(),
+		Endpoint: a.config.TelemetryEndpoint,
+		Payload:  report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultTenant is the tenant of requests that don't name one, and of
+// every account made before tenants existed.
+const (
+	defaultTenant     = 1
+	defaultTenantSlug = "default"
+)
+
+// tenantHeader names the tenant of a request that doesn't come in on its
+// subdomain.
+const tenantHeader = "X-Tenant"
+
+// maxTenantName caps a tenant's name, in characters; slugs are at most a
+// DNS label long.
+const (
+	maxTenantName = 100
+	maxTenantSlug = 63
+)
+
+var tenantSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// errUnknownTenant is returned for a request naming a tenant that doesn't
+// exist.
+var errUnknownTenant = errors.New("unknown tenant")
+
+// Tenant is an organization with its own accounts, as the admin API lists
+// it.
+type Tenant struct {
+	ID        int    `json:"id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	Users     int    `json:"users"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// requestTenantSlug is the tenant the request names: the X-Tenant header,
+// or else the subdomain of Config.TenantDomain it was sent to. It's ""
+// for a request naming none.
+func (a *App) requestTenantSlug(r *http.Request) string {
+	if slug := r.Header.Get(tenantHeader); slug != "" {
+		return strings.ToLower(strings.TrimSpace(slug))
+	}
+	if a.config.TenantDomain == "" {
+		return ""
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(a.config.TenantDomain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// requestTenant looks up the tenant the request names, which is the
+// default one, without a query, if it names none.
+func (a *App) requestTenant(r *http.Request) (int, error) {
+	slug := a.requestTenantSlug(r)
+	if slug == "" || slug == defaultTenantSlug {
+		return defaultTenant, nil
+	}
+	var id int
+	err := a.db.QueryRowContext(r.Context(), "SELECT id FROM tenants WHERE slug = $1", slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownTenant
+	}
+	return id, err
+}
+
+// resolveTenant is requestTenant answering the request itself if the
+// tenant can't be found.
+func (a *App) resolveTenant(w http.ResponseWriter, r *http.Request) (int, bool) {
+	tenant, err := a.requestTenant(r)
+	if err == errUnknownTenant {
+		writeError(w, http.StatusNotFound, codeNotFound, "Tenant not found")
+		return 0, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return 0, false
+	}
+	return tenant, true
+}
+
+// getTenants lists the tenants, oldest first, with how many accounts each
+// has.
+func (a *App) getTenants(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT t.id, t.slug, t.name, t.created_at, (SELECT COUNT(*) FROM users u WHERE u.tenant_id = t.id)
+		FROM tenants t ORDER BY t.id
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		var createdAt time.Time
+		if err := rows.Scan(&t.ID, &t.Slug, &t.Name, &createdAt, &t.Users); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		t.CreatedAt = createdAt.Format(time.RFC3339)
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tenants); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// createTenant provisions a tenant from {"slug": ..., "name": ...}. Its
+// accounts sign up and log in on the slug's subdomain or with the slug in
+// X-Tenant.
+func (a *App) createTenant(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	var errs fieldErrors
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if !tenantSlug.MatchString(req.Slug) || len(req.Slug) > maxTenantSlug {
+		errs.add("slug", fmt.Sprintf("must be up to %d lowercase letters, digits and dashes", maxTenantSlug))
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs.add("name", "is required")
+	} else if len([]rune(req.Name)) > maxTenantName {
+		errs.add("name", fmt.Sprintf("must be at most %d characters", maxTenantName))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	t := Tenant{Slug: req.Slug, Name: req.Name}
+	var createdAt time.Time
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO tenants (slug, name, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (slug) DO NOTHING
+		RETURNING id, created_at
+	`, t.Slug, t.Name, a.dbNow()).Scan(&t.ID, &createdAt)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusConflict, codeConflict, "A tenant with this slug already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	t.CreatedAt = createdAt.Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteTenant removes a tenant with no accounts left. The default tenant
+// can't be removed.
+func (a *App) deleteTenant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	if id == defaultTenant {
+		writeError(w, http.StatusConflict, codeConflict, "The default tenant can't be deleted")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), `
+		DELETE FROM tenants WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM households WHERE tenant_id = $1)
+	`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := a.db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1)", id).Scan(&exists); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if exists {
+			writeError(w, http.StatusConflict, codeConflict, "The tenant still has accounts; delete them first")
+		} else {
+			writeError(w, http.StatusNotFound, codeNotFound, "Tenant not found")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"subscription-tracker/pkg/clock"
+)
+
+// timeTravel serves /api/admin/clock. The router mounts it only when the
+// App's clock is a clock.Travel, i.e. in dev mode.
+type timeTravel struct {
+	clock *clock.Travel
+}
+
+func (tt timeTravel) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"now": tt.clock.Now().Format(time.RFC3339),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (tt timeTravel) get(w http.ResponseWriter, r *http.Request) {
+	tt.write(w)
+}
+
+// set moves the clock to the given instant; time keeps passing from there.
+func (tt timeTravel) set(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Now string `json:"now"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	t, err := time.Parse(time.RFC3339, req.Now)
+	if err != nil {
+		t, err = time.Parse(dateLayout, req.Now)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "now must be an RFC 3339 timestamp or YYYY-MM-DD date")
+		return
+	}
+
+	tt.clock.TravelTo(t)
+	tt.write(w)
+}
+
+// reset returns the clock to real time.
+func (tt timeTravel) reset(w http.ResponseWriter, r *http.Request) {
+	tt.clock.Reset()
+	tt.write(w)
+}
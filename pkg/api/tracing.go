@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceMiddleware starts a server span for each request through the global
+// OpenTelemetry tracer provider, continuing a trace the caller propagated.
+// Spans are named after the route template, like "GET /api/subscriptions/{id}",
+// so traces group by endpoint rather than by ID.
+func (a *App) traceMiddleware(next http.Handler) http.Handler {
+	route := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tpl := routeTemplate(r); tpl != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(tpl))
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(route, "http.server", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			if tpl := routeTemplate(r); tpl != "" {
+				return r.Method + " " + tpl
+			}
+			return r.Method
+		},
+	))
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// How far ahead, in days, GET /api/trials looks by default and at most.
+const (
+	defaultTrialDays = 7
+	maxTrialDays     = 90
+)
+
+// getTrials lists the user's active trials ending within ?days (default
+// 7), soonest first. Trials whose end date has passed but that the trial job hasn't
+// reached yet are included.
+func (a *App) getTrials(w http.ResponseWriter, r *http.Request) {
+	days := defaultTrialDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxTrialDays {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("days must be between 0 and %d", maxTrialDays))
+			return
+		}
+		days = n
+	}
+	trial := true
+	subs, _, err := a.subscriptions.List(r.Context(), userID(r), store.SubscriptionQuery{Trial: &trial, Status: models.StatusActive})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	through := a.clock.Now().AddDate(0, 0, days).Format(dateLayout)
+	trials := []models.Subscription{}
+	for _, s := range subs {
+		if s.TrialEndsAt != nil && *s.TrialEndsAt <= through {
+			a.setStale(&s)
+			trials = append(trials, s)
+		}
+	}
+	sort.SliceStable(trials, func(i, j int) bool { return *trials[i].TrialEndsAt < *trials[j].TrialEndsAt })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trials); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// StartTrials ends trials whose end date has come now and then every
+// TrialInterval, until ctx is cancelled.
+func (a *App) StartTrials(ctx context.Context) {
+	if a.config.TrialInterval <= 0 {
+		return
+	}
+
+	a.jobs.started("trials", a.config.TrialInterval)
+	go func() {
+		defer a.jobs.stopped("trials")
+		ticker := time.NewTicker(a.config.TrialInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := a.endTrials(ctx); err != nil {
+				a.logger.Warn("ending trials", "err", err)
+			} else if n > 0 {
+				a.logger.Info("ended trials", "subscriptions", n)
+			}
+			a.jobs.ran("trials")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// endTrials turns every trial ending today or earlier into a regular
+// subscription, and raises an alert so the user reviews it and cancels it
+// if they meant to. It returns how many trials ended.
+func (a *App) endTrials(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	ended, err := a.subscriptions.TrialsEnded(ctx, a.clock.Now().Format(dateLayout))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range ended {
+		endsAt := *d.TrialEndsAt
+		err := a.subscriptions.EndTrial(ctx, d.UserID, d.ID, endsAt, a.clock.Now())
+		if err == store.ErrNotFound {
+			continue // edited or deleted since TrialsEnded
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+
+		after := d.Subscription
+		after.IsTrial = false
+		a.recordAudit(ctx, d.UserID, d.ID, &d.Subscription, &after)
+		err = a.raiseAlert(ctx, d.UserID, models.Alert{
+			Kind:           models.AlertTrialEnded,
+			Message:        fmt.Sprintf("Your %s trial ended on %s and now costs %s %s %s; cancel it if you don't want to keep it", d.Name, endsAt, d.Cost, d.Currency, d.BillingCycle),
+			SubscriptionID: &after.ID,
+		}, fmt.Sprintf("%s:%d:%s", models.AlertTrialEnded, d.ID, endsAt))
+		if err != nil {
+			a.logger.WarnContext(ctx, "raising trial alert", "subscription", d.ID, "err", err)
+		}
+		// TrialsEnded leaves tags unset; the event carries them.
+		if s, err := a.subscriptions.Get(ctx, d.UserID, d.ID); err == nil {
+			a.setStale(&s)
+			a.emitEvent(ctx, d.UserID, models.EventSubscriptionUpdated, s)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+// TOTP parameters, RFC 6238 as authenticator apps implement it: HMAC-SHA1
+// over 30-second steps, truncated to six digits.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many steps either side of now a code is still
+	// accepted, to allow for clock drift and typing.
+	totpSkew = 1
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "Subscription Tracker"
+)
+
+// recoveryCodeCount is how many recovery codes turning on two-factor
+// authentication gives.
+const recoveryCodeCount = 10
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, base32 as authenticator
+// apps take it.
+func newTOTPSecret() string {
+	key := make([]byte, 20)
+	rand.Read(key)
+	return totpEncoding.EncodeToString(key)
+}
+
+// totpStep is the time step t falls in.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode is the code for a time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decoding TOTP secret: %w", err)
+	}
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%uint32(math.Pow10(totpDigits))), nil
+}
+
+// totpMatch returns the step within totpSkew of now that code is for, if
+// it's one after the step last used, so a code works only once.
+func totpMatch(secret, code string, now time.Time, lastUsed int64) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsed {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI authenticator apps scan, as a QR code, to
+// add the account.
+func totpURI(secret, email string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes, like
+// "k3f9x-2mq7d", and their hashes.
+func newRecoveryCodes() (codes, hashes []string) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	for range recoveryCodeCount {
+		buf := make([]byte, 10)
+		rand.Read(buf)
+		for i, b := range buf {
+			buf[i] = alphabet[int(b)%len(alphabet)]
+		}
+		code := string(buf[:5]) + "-" + string(buf[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes
+}
+
+// normalizeRecoveryCode lowercases a recovery code and drops the dash and
+// any spaces, which people add or leave out when typing it.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// isTOTPCode reports whether code looks like a TOTP code rather than a
+// recovery code.
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// twoFactorState is a user's TOTP settings. secret is set from the start
+// of setup, enabled once setup has been verified.
+type twoFactorState struct {
+	email    string
+	secret   *string
+	enabled  bool
+	lastStep int64
+}
+
+func (a *App) twoFactorState(ctx context.Context, userID int) (twoFactorState, error) {
+	var s twoFactorState
+	err := a.db.QueryRowContext(ctx, "SELECT email, totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1", userID).
+		Scan(&s.email, &s.secret, &s.enabled, &s.lastStep)
+	return s, err
+}
+
+// checkSecondFactor checks a TOTP or recovery code for a user with
+// two-factor authentication on, using it up: a TOTP code can't be used
+// again, nor can any code before it, and a recovery code is deleted.
+func (a *App) checkSecondFactor(ctx context.Context, userID int, state twoFactorState, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if !isTOTPCode(code) {
+		res, err := a.db.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2", userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	}
+	if state.secret == nil {
+		return false, nil
+	}
+	step, ok := totpMatch(*state.secret, code, time.Now(), state.lastStep)
+	if !ok {
+		return false, nil
+	}
+	// Only the first of two logins racing with the same code gets in.
+	res, err := a.db.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+type twoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// decodeTwoFactorCode reads a {"code": ...} body, writing the error if
+// there's no code.
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req twoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return "", false
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		writeValidationErrors(w, fieldErrors{{"code", "is required"}})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// setupTwoFactor starts turning on two-factor authentication: it makes a
+// new secret and returns it with the otpauth:// URI for authenticator
+// apps. Nothing changes at login until a code from it is verified;
+// starting again replaces the secret.
+func (a *App) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	state, err := a.twoFactorState(r.Context(), userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if state.enabled {
+		writeError(w, http.StatusConflict, codeConflict, "Two-factor authentication is already on; disable it first")
+		return
+	}
+	secret := newTOTPSecret()
+	if _, err := a.db.ExecContext(r.Context(), "UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2", secret, userID(r)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	resp := map[string]string{"secret": secret, "uri": totpURI(secret, state.email)}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// verifyTwoFactor finishes setup with a code from the authenticator app,
+// turning two-factor authentication on, and returns the recovery codes.
+// They're shown only this once.
+func (a *App) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	code, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	state, err := a.twoFactorState(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	switch {
+	case state.enabled:
+		writeError(w, http.StatusConflict, codeConflict, "Two-factor authentication is already on")
+		return
+	case state.secret == nil:
+		writeError(w, http.StatusConflict, codeConflict, "Start two-factor setup first")
+		return
+	}
+	step, ok := totpMatch(*state.secret, strings.TrimSpace(code), time.Now(), state.lastStep)
+	if !ok {
+		writeValidationErrors(w, fieldErrors{{"code", "is incorrect or has expired"}})
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err = store.InTx(r.Context(), a.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), "UPDATE users SET totp_enabled = $1, totp_last_step = $2 WHERE id = $3", true, step, uid); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM recovery_codes WHERE user_id = $1", uid); err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(r.Context(), "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", uid, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": codes}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// disableTwoFactor turns two-factor authentication off, given a current
+// TOTP code or a recovery code, and deletes the secret and recovery codes.
+func (a *App) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	code, ok := decodeTwoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	uid := userID(r)
+	state, err := a.twoFactorState(r.Context(), uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !state.enabled {
+		writeError(w, http.StatusConflict, codeConflict, "Two-factor authentication is not on")
+		return
+	}
+	ok, err = a.checkSecondFactor(r.Context(), uid, state, code)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if !ok {
+		writeValidationErrors(w, fieldErrors{{"code", "is incorrect or has expired"}})
+		return
+	}
+
+	err = store.InTx(r.Context(), a.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), "UPDATE users SET totp_secret = NULL, totp_enabled = $1, totp_last_step = 0 WHERE id = $2", false, uid); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(r.Context(), "DELETE FROM recovery_codes WHERE user_id = $1", uid)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
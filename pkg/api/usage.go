@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/store"
+)
+
+// How far back, in days, the usage reports look by default and at most.
+// Usage older than the most a report can show is deleted.
+const (
+	defaultUsageDays = 7
+	maxUsageDays     = 90
+)
+
+// usageKey is one row of api_usage: a user's requests to one endpoint in
+// one hour.
+type usageKey struct {
+	userID int
+	hour   time.Time
+	method string
+	route  string
+}
+
+type usageCount struct {
+	requests, errors, durationMs int64
+}
+
+// usageBuffer collects request counts between flushes, so a request costs
+// a map update rather than a database write.
+type usageBuffer struct {
+	sync.Mutex
+	counts map[usageKey]usageCount
+}
+
+func (b *usageBuffer) add(key usageKey, c usageCount) {
+	b.Lock()
+	defer b.Unlock()
+	if b.counts == nil {
+		b.counts = map[usageKey]usageCount{}
+	}
+	sum := b.counts[key]
+	sum.requests += c.requests
+	sum.errors += c.errors
+	sum.durationMs += c.durationMs
+	b.counts[key] = sum
+}
+
+// take empties the buffer and returns what was in it.
+func (b *usageBuffer) take() map[usageKey]usageCount {
+	b.Lock()
+	defer b.Unlock()
+	counts := b.counts
+	b.counts = nil
+	return counts
+}
+
+// recordUsage counts a request by the user to the endpoint. It does
+// nothing when usage tracking is off.
+func (a *App) recordUsage(userID int, method, route string, failed bool, took time.Duration) {
+	if a.config.UsageFlushInterval <= 0 || userID == 0 {
+		return
+	}
+	c := usageCount{requests: 1, durationMs: took.Milliseconds()}
+	if failed {
+		c.errors = 1
+	}
+	a.usage.add(usageKey{userID: userID, hour: a.clock.Now().UTC().Truncate(time.Hour), method: method, route: route}, c)
+}
+
+// usageMiddleware counts the signed-in user's requests by route template,
+// so /api/subscriptions/1 and /api/subscriptions/2 are one endpoint.
+// Responses with a 4xx or 5xx status count as errors.
+func (a *App) usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		a.recordUsage(userID(r), r.Method, routeTemplate(r), rec.status >= http.StatusBadRequest, time.Since(start))
+	})
+}
+
+// FlushUsage writes the usage counted since the last flush to the
+// database and deletes usage too old to be reported. Counts that can't be
+// written go back in the buffer for the next flush. main calls it once
+// the server has stopped taking requests, so the last ones aren't lost.
+func (a *App) FlushUsage(ctx context.Context) error {
+	ctx = store.AsSystem(ctx)
+	counts := a.usage.take()
+	if len(counts) > 0 {
+		if err := a.writeUsage(ctx, counts); err != nil {
+			for key, c := range counts {
+				a.usage.add(key, c)
+			}
+			return err
+		}
+	}
+	cutoff := a.clock.Now().UTC().Truncate(time.Hour).Add(-maxUsageDays * 24 * time.Hour)
+	_, err := a.db.ExecContext(ctx, "DELETE FROM api_usage WHERE hour < $1", cutoff)
+	return err
+}
+
+func (a *App) writeUsage(ctx context.Context, counts map[usageKey]usageCount) error {
+	return store.InTx(ctx, a.db, func(tx *sql.Tx) error {
+		// Counts for accounts deleted since the requests were made are
+		// dropped.
+		exists := map[int]bool{}
+		for key := range counts {
+			if _, ok := exists[key.userID]; ok {
+				continue
+			}
+			var found bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", key.userID).Scan(&found); err != nil {
+				return err
+			}
+			exists[key.userID] = found
+		}
+		for key, c := range counts {
+			if !exists[key.userID] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO api_usage (user_id, hour, method, route, requests, errors, duration_ms)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (user_id, hour, method, route) DO UPDATE SET
+					requests = api_usage.requests + excluded.requests,
+					errors = api_usage.errors + excluded.errors,
+					duration_ms = api_usage.duration_ms + excluded.duration_ms
+			`, key.userID, key.hour, key.method, key.route, c.requests, c.errors, c.durationMs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StartUsage flushes the usage counts every UsageFlushInterval until ctx
+// is done.
+func (a *App) StartUsage(ctx context.Context) {
+	if a.config.UsageFlushInterval <= 0 {
+		return
+	}
+
+	a.jobs.started("usage", a.config.UsageFlushInterval)
+	go func() {
+		defer a.jobs.stopped("usage")
+		ticker := time.NewTicker(a.config.UsageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := a.FlushUsage(ctx); err != nil {
+				a.logger.Error("flushing API usage", "err", err)
+				continue
+			}
+			a.jobs.ran("usage")
+		}
+	}()
+}
+
+// usageEntry is the requests made to one endpoint in a usage report.
+// UserID and Email are only set in the admin report, which breaks usage
+// down by user as well.
+type usageEntry struct {
+	UserID     int    `json:"userId,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	Requests   int64  `json:"requests"`
+	Errors     int64  `json:"errors"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// usageReport is the response of GET /api/usage and GET /api/admin/usage.
+// Since is the start of the first hour it covers.
+type usageReport struct {
+	Since      string       `json:"since"`
+	Requests   int64        `json:"requests"`
+	Errors     int64        `json:"errors"`
+	DurationMs int64        `json:"durationMs"`
+	Endpoints  []usageEntry `json:"endpoints"`
+}
+
+// getUsage reports the user's requests over the last ?days (default 7),
+// busiest endpoint first. It only includes flushed counts, so it can lag
+// by up to the flush interval.
+func (a *App) getUsage(w http.ResponseWriter, r *http.Request) {
+	a.writeUsageReport(w, r, userID(r), false)
+}
+
+// getAdminUsage is GET /api/usage for every user, or for the one in
+// ?userId, to see which accounts the load comes from.
+func (a *App) getAdminUsage(w http.ResponseWriter, r *http.Request) {
+	user := 0
+	if v := r.URL.Query().Get("userId"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "userId must be a positive integer")
+			return
+		}
+		user = n
+	}
+	a.writeUsageReport(w, r, user, true)
+}
+
+// writeUsageReport answers a usage request for one user or, when user is
+// zero, for everyone. byUser keeps who made the requests in the entries.
+func (a *App) writeUsageReport(w http.ResponseWriter, r *http.Request, user int, byUser bool) {
+	days := defaultUsageDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageDays {
+			writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("days must be between 1 and %d", maxUsageDays))
+			return
+		}
+		days = n
+	}
+	since := a.clock.Now().UTC().Truncate(time.Hour).Add(-time.Duration(days) * 24 * time.Hour)
+
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT u.user_id, users.email, u.method, u.route, SUM(u.requests), SUM(u.errors), SUM(u.duration_ms)
+		FROM api_usage u JOIN users ON users.id = u.user_id
+		WHERE u.hour >= $1 AND ($2 = 0 OR u.user_id = $2)
+		GROUP BY u.user_id, users.email, u.method, u.route
+		ORDER BY SUM(u.requests) DESC, u.user_id, u.route, u.method
+	`, since, user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	report := usageReport{Since: since.Format(time.RFC3339), Endpoints: []usageEntry{}}
+	for rows.Next() {
+		var e usageEntry
+		if err := rows.Scan(&e.UserID, &e.Email, &e.Method, &e.Route, &e.Requests, &e.Errors, &e.DurationMs); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if !byUser {
+			e.UserID, e.Email = 0, ""
+		}
+		report.Requests += e.Requests
+		report.Errors += e.Errors
+		report.DurationMs += e.DurationMs
+		report.Endpoints = append(report.Endpoints, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+)
+
+// maxUsageNote caps the note on a logged use, in characters.
+const maxUsageNote = 200
+
+type usageEventRequest struct {
+	Date models.Date `json:"date"`
+	Note string      `json:"note"`
+}
+
+// logUse records a use of the subscription, today unless the body gives
+// a date. The body can be left out.
+func (a *App) logUse(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	var req usageEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	now := a.clock.Now()
+	today := models.NewDate(now.Date())
+	var errs fieldErrors
+	if req.Date.IsZero() {
+		req.Date = today
+	} else if req.Date.After(today) {
+		errs.add("date", "can't be in the future")
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len([]rune(req.Note)) > maxUsageNote {
+		errs.add("note", fmt.Sprintf("must be at most %d characters", maxUsageNote))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	event := models.UsageEvent{SubscriptionID: id, Date: req.Date, Note: req.Note, CreatedAt: now.UTC().Format(time.RFC3339)}
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO usage_events (user_id, subscription_id, used_on, note, created_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID(r), id, req.Date, req.Note, now).Scan(&event.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// getUses lists the subscription's logged uses, most recent first, a
+// page at a time.
+func (a *App) getUses(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, used_on, note, created_at FROM usage_events
+		WHERE subscription_id = $1 AND user_id = $2
+		ORDER BY used_on DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, id, userID(r), limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	events := []models.UsageEvent{}
+	for rows.Next() {
+		event := models.UsageEvent{SubscriptionID: id}
+		var createdAt time.Time
+		if err := rows.Scan(&event.ID, &event.Date, &event.Note, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		event.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteUse removes a use logged by mistake.
+func (a *App) deleteUse(w http.ResponseWriter, r *http.Request) {
+	id, ok := subscriptionID(w, r)
+	if !ok {
+		return
+	}
+	usageID, err := strconv.Atoi(mux.Vars(r)["usageId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid usage ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM usage_events WHERE id = $1 AND subscription_id = $2 AND user_id = $3", usageID, id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Usage not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// useSummary is what the insights need to know about a subscription's
+// logged uses: how many fell in the window looked at, and the date of the
+// latest one ever.
+type useSummary struct {
+	Uses     int
+	LastUsed models.Date
+}
+
+// usesSince summarizes the user's logged uses by subscription, counting
+// those after since. Subscriptions with none logged are left out. An App
+// without a database has none.
+func (a *App) usesSince(ctx context.Context, userID int, since models.Date) (map[int]useSummary, error) {
+	usage := map[int]useSummary{}
+	if a.db == nil {
+		return usage, nil
+	}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT subscription_id, SUM(CASE WHEN used_on > $2 THEN 1 ELSE 0 END), MAX(used_on)
+		FROM usage_events WHERE user_id = $1
+		GROUP BY subscription_id
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var u useSummary
+		var uses sql.NullInt64
+		if err := rows.Scan(&id, &uses, &u.LastUsed); err != nil {
+			return nil, err
+		}
+		u.Uses = int(uses.Int64)
+		usage[id] = u
+	}
+	return usage, rows.Err()
+}
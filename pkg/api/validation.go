@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// billingCycles are the named values billingCycle accepts, as listed in
+// errors. models.ParseRecurrence also takes "annual" and "annually" as
+// spellings of yearly, and custom cycles such as "every 2 weeks".
+var billingCycles = []string{"weekly", "monthly", "quarterly", "yearly"}
+
+// maxJSONBytes caps a JSON request body that's read into memory whole.
+const maxJSONBytes = 1 << 20
+
+// readJSONBody reads the whole request body, up to maxJSONBytes, writing a
+// 413 if it's larger or a 400 if it can't be read.
+func readJSONBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBytes))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Body is larger than %d bytes", maxJSONBytes))
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Error reading body: %v", err))
+		return nil, false
+	}
+	return body, true
+}
+
+// fieldError is one problem with one field of a request body.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects every problem with a request body, so a client can
+// fix them all in one go instead of one per round trip.
+type fieldErrors []fieldError
+
+func (e *fieldErrors) add(field, message string) {
+	*e = append(*e, fieldError{field, message})
+}
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// writeValidationErrors sends a 400 problem listing errs in its errors
+// member.
+func writeValidationErrors(w http.ResponseWriter, errs fieldErrors) {
+	writeProblem(w, problem{
+		Status: http.StatusBadRequest,
+		Code:   codeValidation,
+		Detail: errs.Error(),
+		Extra:  map[string]any{"errors": errs},
+	})
+}
+
+// subscriptionInput is a subscription as a client sends it. The next
+// billing date is kept as the text given until validateSubscription
+// parses it, so a malformed date is reported with the other field errors
+// instead of rejecting the whole body. The billing cycle can be given as
+// the structured Recurrence instead of as text.
+type subscriptionInput struct {
+	models.Subscription
+	NextBilling string             `json:"nextBilling"`
+	Recurrence  *models.Recurrence `json:"recurrence"`
+	// CatalogID and Plan name a catalog service and plan to pre-fill a new
+	// subscription from; see fillFromCatalog.
+	CatalogID string `json:"catalogId"`
+	Plan      string `json:"plan"`
+}
+
+// validateSubscription checks in before it's stored and returns it as a
+// subscription: everything but the description, currency and tags must be
+// set, the cost must be positive, the currency (when given) a currency
+// code, the cycle a valid recurrence, the next billing date a real
+// calendar date on the cycle's day of the month if it has one, and each
+// tag a valid tag name. A trial needs the date it ends. The cycle is
+// stored as the recurrence's canonical text, so "Annual" becomes "yearly",
+// and is anchored to the next billing date as models.Recurrence.StartingOn
+// describes, so "monthly" from January 31 becomes "monthly on day 31".
+func validateSubscription(in subscriptionInput) (models.Subscription, fieldErrors) {
+	s := in.Subscription
+	var errs fieldErrors
+	if strings.TrimSpace(s.Name) == "" {
+		errs.add("name", "is required")
+	}
+	if strings.TrimSpace(s.Category) == "" {
+		errs.add("category", "is required")
+	}
+	if s.Cost <= 0 {
+		errs.add("cost", "must be greater than 0")
+	}
+	if s.Currency != "" && !isCurrencyCode(s.Currency) {
+		errs.add("currency", "must be a three-letter ISO 4217 code such as USD")
+	}
+	cycle, cycleOK := validateCycle(s.BillingCycle, in.Recurrence, &errs)
+	if in.NextBilling == "" {
+		errs.add("nextBilling", "is required")
+	} else if date, err := models.ParseDate(in.NextBilling); err != nil {
+		errs.add("nextBilling", "must be a valid date in YYYY-MM-DD format")
+	} else if cycleOK && addRecurrence(date.Time(), cycle, 0) != date.Time() {
+		// Moving no cycles from date lands on the anchor day of its month.
+		errs.add("nextBilling", "must be on the billing cycle's day of the month")
+	} else {
+		s.NextBilling = date
+		cycle = cycle.StartingOn(date)
+	}
+	if cycleOK {
+		s.BillingCycle = cycle.String()
+	}
+	if s.TrialEndsAt != nil {
+		if _, err := time.Parse(dateLayout, *s.TrialEndsAt); err != nil {
+			errs.add("trialEndsAt", "must be a valid date in YYYY-MM-DD format")
+		}
+	} else if s.IsTrial {
+		errs.add("trialEndsAt", "is required for a trial")
+	}
+	for _, tag := range s.Tags {
+		if msg := tagNameError(tag); msg != "" {
+			errs.add("tags", "each "+msg)
+			break
+		}
+	}
+	return s, errs
+}
+
+// validateCycle returns the billing cycle given as text, as a structured
+// recurrence or as both, adding to errs if it's missing, invalid or the
+// two disagree.
+func validateCycle(text string, structured *models.Recurrence, errs *fieldErrors) (models.Recurrence, bool) {
+	var fromText models.Recurrence
+	if text != "" {
+		var err error
+		fromText, err = models.ParseRecurrence(text)
+		switch {
+		case err == models.ErrUnknownCycle:
+			errs.add("billingCycle", "must be one of "+strings.Join(billingCycles, ", ")+`, or custom such as "every 2 weeks" or "every month on the last day"`)
+			return models.Recurrence{}, false
+		case err != nil:
+			errs.add("billingCycle", "is invalid: "+err.Error())
+			return models.Recurrence{}, false
+		}
+	}
+	switch {
+	case structured != nil:
+		if err := structured.Validate(); err != nil {
+			errs.add("recurrence", err.Error())
+			return models.Recurrence{}, false
+		}
+		if text != "" && fromText != *structured {
+			errs.add("billingCycle", "doesn't match recurrence; give one or the other")
+			return models.Recurrence{}, false
+		}
+		return *structured, true
+	case text == "":
+		errs.add("billingCycle", "is required")
+		return models.Recurrence{}, false
+	}
+	return fromText, true
+}
+
+// isCurrencyCode reports whether code looks like an ISO 4217 code: three
+// upper-case letters. Whether there's a rate for it is up to the provider.
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
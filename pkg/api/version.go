@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"subscription-tracker/pkg/store"
+)
+
+// getVersion reports what's deployed and whether the schema is up to date.
+func (a *App) getVersion(w http.ResponseWriter, r *http.Request) {
+	applied, err := store.CurrentSchemaVersion(a.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"version":               a.config.Build.Version,
+		"gitSha":                a.config.Build.GitSHA,
+		"buildTime":             a.config.Build.BuildTime,
+		"goVersion":             runtime.Version(),
+		"schemaVersion":         applied,
+		"expectedSchemaVersion": store.SchemaVersion,
+		"schemaUpToDate":        applied >= store.SchemaVersion,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
@@ -0,0 +1,544 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/store"
+)
+
+// webhookEvents are the events a webhook can subscribe to, and the ones it
+// gets when it doesn't say.
+var webhookEvents = []string{
+	models.EventSubscriptionCreated,
+	models.EventSubscriptionUpdated,
+	models.EventSubscriptionDeleted,
+	models.EventSubscriptionRenewalUpcoming,
+	models.EventBudgetExceeded,
+}
+
+const (
+	// maxDeliveryAttempts is how many times an event is sent before its
+	// delivery is marked failed. Retries wait deliveryBackoff, doubling
+	// after each failure: 1, 2, 4, 8 and 16 minutes.
+	maxDeliveryAttempts = 6
+	deliveryBackoff     = time.Minute
+
+	// deliveryLease holds a delivery back from other runs while it is
+	// being sent. It must outlast webhookTimeout.
+	deliveryLease  = time.Minute
+	webhookTimeout = 10 * time.Second
+
+	// deliveryBatch caps how many deliveries one run sends.
+	deliveryBatch = 100
+
+	// renewalUpcomingDays is how far ahead of a billing date
+	// subscription.renewal_upcoming is sent.
+	renewalUpcomingDays = 3
+
+	// notificationWebhookRenewal is the notifications kind that records a
+	// renewal_upcoming event queued for a billing date.
+	notificationWebhookRenewal = "webhook_renewal_upcoming"
+)
+
+// webhookRequest is the body of POST /api/webhooks.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// webhookPayload is the JSON body sent to a webhook. Data is the
+// subscription, or just its ID for subscription.deleted.
+type webhookPayload struct {
+	Event     string `json:"event"`
+	CreatedAt string `json:"createdAt"`
+	Data      any    `json:"data"`
+}
+
+// deletedSubscription is the data of subscription.deleted.
+type deletedSubscription struct {
+	ID int `json:"id"`
+}
+
+func (a *App) countWebhooks(ctx context.Context, userID int) (int64, error) {
+	var n int64
+	err := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM webhooks WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+// createWebhook registers a URL for the caller's subscription events. The
+// response is the only time the signing secret is shown.
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+
+	var errs fieldErrors
+	if u, err := url.Parse(req.URL); req.URL == "" {
+		errs.add("url", "is required")
+	} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("url", "must be an absolute http or https URL")
+	}
+	events := webhookEvents
+	if len(req.Events) > 0 {
+		events = nil
+		for _, e := range req.Events {
+			if !slices.Contains(webhookEvents, e) {
+				errs.add("events", fmt.Sprintf("%q is not one of %s", e, strings.Join(webhookEvents, ", ")))
+			} else if !slices.Contains(events, e) {
+				events = append(events, e)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	uid := userID(r)
+	if !a.checkQuota(r.Context(), w, uid, QuotaWebhookEndpoints, 1) {
+		return
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	hook := models.Webhook{URL: req.URL, Events: events, Secret: "whsec_" + hex.EncodeToString(key)}
+	var createdAt time.Time
+	err := a.db.QueryRowContext(r.Context(), `
+		INSERT INTO webhooks (user_id, url, secret, events) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, uid, hook.URL, hook.Secret, strings.Join(events, ",")).Scan(&hook.ID, &createdAt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	hook.CreatedAt = createdAt.Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(hook); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+func (a *App) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.QueryContext(r.Context(), "SELECT id, url, events, created_at FROM webhooks WHERE user_id = $1 ORDER BY id", userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		var hook models.Webhook
+		var events string
+		var createdAt time.Time
+		if err := rows.Scan(&hook.ID, &hook.URL, &events, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		hook.Events = strings.Split(events, ",")
+		hook.CreatedAt = createdAt.Format(time.RFC3339)
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hooks); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// deleteWebhook removes a webhook along with its delivery log and anything
+// still queued for it.
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(), "DELETE FROM webhooks WHERE id = $1 AND user_id = $2", id, userID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Webhook not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries returns one page of a webhook's delivery log, newest
+// first, with the same ?limit and ?offset as the subscription list.
+func (a *App) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Invalid ID")
+		return
+	}
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	var total int
+	err = a.db.QueryRowContext(r.Context(), `
+		SELECT COUNT(d.id) FROM webhooks w LEFT JOIN webhook_deliveries d ON d.webhook_id = w.id
+		WHERE w.id = $1 AND w.user_id = $2
+		GROUP BY w.id
+	`, id, userID(r)).Scan(&total)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	rows, err := a.db.QueryContext(r.Context(), `
+		SELECT id, event, status, attempts, response_status, last_error, created_at, next_attempt_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1
+		ORDER BY id DESC LIMIT $2 OFFSET $3
+	`, id, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	items := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var responseStatus sql.NullInt64
+		var lastError sql.NullString
+		var createdAt, nextAttempt time.Time
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.Event, &d.Status, &d.Attempts, &responseStatus, &lastError, &createdAt, &nextAttempt, &deliveredAt); err != nil {
+			writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+			return
+		}
+		if responseStatus.Valid {
+			status := int(responseStatus.Int64)
+			d.ResponseStatus = &status
+		}
+		if lastError.Valid {
+			d.Error = &lastError.String
+		}
+		d.CreatedAt = createdAt.Format(time.RFC3339)
+		if d.Status == models.DeliveryPending {
+			next := nextAttempt.Format(time.RFC3339)
+			d.NextAttemptAt = &next
+		}
+		if deliveredAt.Valid {
+			delivered := deliveredAt.Time.Format(time.RFC3339)
+			d.DeliveredAt = &delivered
+		}
+		items = append(items, d)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, codeDatabase, fmt.Sprintf("Database error: %v", err))
+		return
+	}
+
+	page := models.Page[models.WebhookDelivery]{Items: items, Total: total, Limit: limit, Offset: offset}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("JSON encoding error: %v", err))
+	}
+}
+
+// dbNow is the clock's time as stored in the timestamps the App writes
+// itself, such as delivery times: UTC and whole seconds, so SQLite's text
+// timestamps compare in time order.
+func (a *App) dbNow() time.Time {
+	return a.clock.Now().UTC().Truncate(time.Second)
+}
+
+// emitEvent sends event to the user's open event streams, queues it for
+// every one of their webhooks subscribed to it and wakes the delivery job.
+// The change it reports has already been made, so failures are logged
+// rather than returned. An App without a database has no webhooks, so it
+// only reaches the streams.
+func (a *App) emitEvent(ctx context.Context, userID int, event string, data any) {
+	a.publishEvent(ctx, userID, event, data)
+	if a.db == nil {
+		return
+	}
+	rows, err := a.db.QueryContext(ctx, "SELECT id, events FROM webhooks WHERE user_id = $1", userID)
+	if err != nil {
+		a.logger.WarnContext(ctx, "queueing webhook event", "event", event, "err", err)
+		return
+	}
+	var hooks []int
+	for rows.Next() {
+		var id int
+		var events string
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			a.logger.WarnContext(ctx, "queueing webhook event", "event", event, "err", err)
+			return
+		}
+		if slices.Contains(strings.Split(events, ","), event) {
+			hooks = append(hooks, id)
+		}
+	}
+	rows.Close()
+	if len(hooks) == 0 {
+		return
+	}
+
+	now := a.dbNow()
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: now.Format(time.RFC3339), Data: data})
+	if err != nil {
+		a.logger.WarnContext(ctx, "queueing webhook event", "event", event, "err", err)
+		return
+	}
+	for _, id := range hooks {
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (webhook_id, event, payload, created_at, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $4)
+		`, id, event, string(payload), now)
+		if err != nil {
+			a.logger.WarnContext(ctx, "queueing webhook event", "event", event, "webhook", id, "err", err)
+		}
+	}
+	select {
+	case a.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartWebhooks sends queued webhook deliveries as they are queued and
+// retries failed ones every WebhookInterval, until ctx is cancelled. Each
+// run also queues subscription.renewal_upcoming events that have come due.
+func (a *App) StartWebhooks(ctx context.Context) {
+	if a.config.WebhookInterval <= 0 {
+		return
+	}
+
+	a.jobs.started("webhooks", a.config.WebhookInterval)
+	go func() {
+		defer a.jobs.stopped("webhooks")
+		ticker := time.NewTicker(a.config.WebhookInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := a.queueRenewalsUpcoming(ctx); err != nil {
+				a.logger.Warn("queueing renewal webhooks", "err", err)
+			}
+			if n, err := a.deliverWebhooks(ctx); err != nil {
+				a.logger.Warn("delivering webhooks", "err", err)
+			} else if n > 0 {
+				a.logger.Info("delivered webhooks", "count", n)
+			}
+			a.jobs.ran("webhooks")
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-a.webhookWake:
+			}
+		}
+	}()
+}
+
+// queueRenewalsUpcoming queues subscription.renewal_upcoming for each
+// subscription billing within renewalUpcomingDays whose owner has a webhook
+// for it. Like reminders, each billing date is claimed in notifications, so
+// it is only sent once. It returns how many events were queued.
+func (a *App) queueRenewalsUpcoming(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	rows, err := a.db.QueryContext(ctx, "SELECT user_id, events FROM webhooks")
+	if err != nil {
+		return 0, err
+	}
+	users := map[int]bool{}
+	for rows.Next() {
+		var uid int
+		var events string
+		if err := rows.Scan(&uid, &events); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if slices.Contains(strings.Split(events, ","), models.EventSubscriptionRenewalUpcoming) {
+			users[uid] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(users) == 0 {
+		return 0, err
+	}
+
+	now := a.clock.Now()
+	today := now.Format(dateLayout)
+	due, err := a.subscriptions.Due(ctx, models.DateOf(now.AddDate(0, 0, renewalUpcomingDays+1)))
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, d := range due {
+		date := d.NextBilling.String()
+		if !users[d.UserID] || date < today {
+			continue
+		}
+		var claim int
+		err := a.db.QueryRowContext(ctx, `
+			INSERT INTO notifications (user_id, subscription_id, kind, billing_date)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (subscription_id, kind, billing_date) DO NOTHING
+			RETURNING id
+		`, d.UserID, d.ID, notificationWebhookRenewal, date).Scan(&claim)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return queued, err
+		}
+		a.emitEvent(ctx, d.UserID, models.EventSubscriptionRenewalUpcoming, d.Subscription)
+		queued++
+	}
+	return queued, nil
+}
+
+// deliverWebhooks sends every pending delivery whose next attempt is due.
+// A delivery succeeds on any 2xx response; otherwise it is retried with
+// exponential backoff until maxDeliveryAttempts, then marked failed. It
+// returns how many were delivered.
+func (a *App) deliverWebhooks(ctx context.Context) (int, error) {
+	ctx = store.AsSystem(ctx)
+	now := a.dbNow()
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = $1 AND d.next_attempt_at <= $2
+		ORDER BY d.id LIMIT $3
+	`, models.DeliveryPending, now, deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+	type delivery struct {
+		id, attempts                int
+		event, payload, url, secret string
+	}
+	var pending []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, d := range pending {
+		// Take the lease; if another run got there first, leave it.
+		res, err := a.db.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET next_attempt_at = $1
+			WHERE id = $2 AND status = $3 AND next_attempt_at <= $4
+		`, now.Add(deliveryLease), d.id, models.DeliveryPending, now)
+		if err != nil {
+			return delivered, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		status, err := a.postWebhook(ctx, d.url, d.secret, d.id, d.event, d.payload)
+		attempts := d.attempts + 1
+		var responseStatus *int
+		if status != 0 {
+			responseStatus = &status
+		}
+		if err == nil {
+			_, err = a.db.ExecContext(ctx, `
+				UPDATE webhook_deliveries
+				SET status = $1, attempts = $2, response_status = $3, last_error = NULL, delivered_at = $4
+				WHERE id = $5
+			`, models.DeliveryDelivered, attempts, responseStatus, a.dbNow(), d.id)
+			if err != nil {
+				return delivered, err
+			}
+			delivered++
+			continue
+		}
+
+		next, state := now.Add(deliveryBackoff<<(attempts-1)), models.DeliveryPending
+		if attempts >= maxDeliveryAttempts {
+			state = models.DeliveryFailed
+		}
+		_, err = a.db.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = $1, attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5
+			WHERE id = $6
+		`, state, attempts, responseStatus, err.Error(), next, d.id)
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// postWebhook sends one payload. The X-Webhook-Signature header is
+// "sha256=" and the hex HMAC-SHA256, keyed with the webhook's secret, of
+// the X-Webhook-Timestamp value, a dot and the body; receivers should
+// recompute it and reject old timestamps. It returns the response status,
+// or 0 if there was no response.
+func (a *App) postWebhook(ctx context.Context, target, secret string, id int, event, payload string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", target, strings.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(a.clock.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp+"."+payload)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "subscription-tracker-webhooks/"+a.config.Build.Version)
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(id))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := a.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,101 @@
+package blobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// tempPrefix marks files still being written by Put. List skips them.
+const tempPrefix = ".put-"
+
+// Dir keeps objects as files under a local directory, for a single
+// instance without S3. Keys are slash-separated paths inside it; one that
+// would leave the directory is an error.
+type Dir struct {
+	root *os.Root
+}
+
+// NewDir keeps objects under dir, creating it if needed.
+func NewDir(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{root: root}, nil
+}
+
+// Put writes the object to a temporary file first and renames it into
+// place, so a failed upload never leaves a partial object behind.
+func (d *Dir) Put(_ context.Context, key string, r io.Reader) error {
+	if !fs.ValidPath(key) || key == "." {
+		return &fs.PathError{Op: "put", Path: key, Err: fs.ErrInvalid}
+	}
+	dir := path.Dir(key)
+	if err := d.root.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	var suffix [8]byte
+	rand.Read(suffix[:])
+	temp := path.Join(dir, tempPrefix+hex.EncodeToString(suffix[:]))
+	f, err := d.root.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = d.root.Rename(temp, key)
+	}
+	if err != nil {
+		d.root.Remove(temp)
+	}
+	return err
+}
+
+func (d *Dir) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	return d.root.Open(key)
+}
+
+// Delete removes the object. Like S3, it doesn't fail if there's none.
+func (d *Dir) Delete(_ context.Context, key string) error {
+	if err := d.root.Remove(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns every key starting with prefix, in lexical order.
+func (d *Dir) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := fs.WalkDir(d.root.FS(), ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// Skip directories that can't hold a matching key.
+			if p != "." && !strings.HasPrefix(p+"/", prefix) && !strings.HasPrefix(prefix, p+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(p, prefix) && !strings.HasPrefix(entry.Name(), tempPrefix) {
+			keys = append(keys, p)
+		}
+		return nil
+	})
+	slices.Sort(keys)
+	return keys, err
+}
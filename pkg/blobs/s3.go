@@ -0,0 +1,89 @@
+// Package blobs stores files in Amazon S3 or a service that speaks its API,
+// such as MinIO, Cloudflare R2 or Backblaze B2, or in a local directory.
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// DefaultEndpoint is Amazon S3's endpoint.
+const DefaultEndpoint = "https://s3.amazonaws.com"
+
+// partSize is how much of an upload of unknown length is buffered at a
+// time. Objects can be up to ten thousand parts long.
+const partSize = 16 << 20
+
+// S3 keeps objects in one bucket.
+type S3 struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3 connects to bucket at endpoint, an http or https URL such as
+// http://localhost:9000 for a local MinIO; empty means Amazon S3. Without
+// an access key the credentials come from the standard AWS environment
+// variables, ~/.aws/credentials or the instance's IAM role. The connection
+// is made on first use.
+func NewS3(endpoint, region, bucket, accessKeyID, secretAccessKey string) (*S3, error) {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint %q must be an http or https URL", endpoint)
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	if accessKeyID != "" {
+		creds = credentials.NewStaticV4(accessKeyID, secretAccessKey, "")
+	}
+	client, err := minio.New(u.Host, &minio.Options{Creds: creds, Secure: u.Scheme == "https", Region: region})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{Client: client, Bucket: bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, r, -1, minio.PutObjectOptions{PartSize: partSize})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject doesn't talk to the server until it's read; Stat does, so
+	// a missing object is reported here.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// List returns every key starting with prefix, in lexical order.
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,83 @@
+// Package cache keeps computed responses for a while, keyed by user so
+// that a change to a user's data can drop everything cached for them.
+// Memory keeps them in the process; Redis shares them between replicas.
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response body and when it was computed.
+type Entry struct {
+	Body     []byte    `json:"body"`
+	StoredAt time.Time `json:"storedAt"`
+}
+
+// Memory caches entries in the process. It keeps at most one entry per
+// user and key, so it needs no expiry of its own; callers decide whether
+// an entry is still fresh enough from its StoredAt.
+type Memory struct {
+	mu    sync.Mutex
+	users map[int]*userEntries
+}
+
+type userEntries struct {
+	// invalidated is when the user's entries were last dropped. Entries
+	// computed before then, by a request that raced the change, are
+	// ignored even if they're stored afterwards.
+	invalidated time.Time
+	entries     map[string]Entry
+}
+
+func NewMemory() *Memory {
+	return &Memory{users: map[int]*userEntries{}}
+}
+
+func (m *Memory) Get(_ context.Context, userID int, key string) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[userID]
+	if u == nil {
+		return Entry{}, false, nil
+	}
+	e, ok := u.entries[key]
+	if !ok || e.StoredAt.Before(u.invalidated) {
+		return Entry{}, false, nil
+	}
+	return e, true, nil
+}
+
+func (m *Memory) Set(_ context.Context, userID int, key string, e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.user(userID)
+	if e.StoredAt.Before(u.invalidated) {
+		return nil
+	}
+	u.entries[key] = e
+	return nil
+}
+
+// Invalidate drops the user's entries, and any computed before at that
+// are stored later.
+func (m *Memory) Invalidate(_ context.Context, userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.user(userID)
+	if at.After(u.invalidated) {
+		u.invalidated = at
+	}
+	clear(u.entries)
+	return nil
+}
+
+func (m *Memory) user(userID int) *userEntries {
+	u := m.users[userID]
+	if u == nil {
+		u = &userEntries{entries: map[string]Entry{}}
+		m.users[userID] = u
+	}
+	return u
+}
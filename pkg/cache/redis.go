@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidatedField holds, in a user's hash, when its entries were last
+// dropped. It can't clash with a key, since keys are currency codes.
+const invalidatedField = "!invalidated"
+
+// Redis caches entries in Redis, as one hash per user named Prefix:userID
+// with a field per key. The hash expires TTL after its last write.
+type Redis struct {
+	Client *redis.Client
+	Prefix string
+	TTL    time.Duration
+}
+
+// NewRedis connects to the Redis server at url, a redis:// or rediss://
+// URL. The connection is made on first use.
+func NewRedis(url, prefix string, ttl time.Duration) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{Client: redis.NewClient(opts), Prefix: prefix, TTL: ttl}, nil
+}
+
+func (c *Redis) hash(userID int) string {
+	return fmt.Sprintf("%s:%d", c.Prefix, userID)
+}
+
+func (c *Redis) Get(ctx context.Context, userID int, key string) (Entry, bool, error) {
+	vals, err := c.Client.HMGet(ctx, c.hash(userID), key, invalidatedField).Result()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	data, ok := vals[0].(string)
+	if !ok {
+		return Entry{}, false, nil
+	}
+	var e Entry
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return Entry{}, false, fmt.Errorf("decoding cached %s: %w", key, err)
+	}
+	if marker, ok := vals[1].(string); ok {
+		invalidated, err := time.Parse(time.RFC3339Nano, marker)
+		if err != nil || e.StoredAt.Before(invalidated) {
+			return Entry{}, false, nil
+		}
+	}
+	return e, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, userID int, key string, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	hash := c.hash(userID)
+	_, err = c.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, hash, key, data)
+		p.Expire(ctx, hash, c.TTL)
+		return nil
+	})
+	return err
+}
+
+// Invalidate drops the user's entries. It leaves when it did so in their
+// place, so that an entry computed before then and stored afterwards is
+// ignored.
+func (c *Redis) Invalidate(ctx context.Context, userID int, at time.Time) error {
+	hash := c.hash(userID)
+	_, err := c.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, hash)
+		p.HSet(ctx, hash, invalidatedField, at.UTC().Format(time.RFC3339Nano))
+		p.Expire(ctx, hash, c.TTL)
+		return nil
+	})
+	return err
+}
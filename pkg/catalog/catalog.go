@@ -0,0 +1,254 @@
+// Package catalog is a list of well-known subscription services, with
+// their typical plans and where to cancel them, for suggesting and
+// pre-filling subscriptions. One ships in the binary; a JSON file can add
+// to it or correct it without a new release.
+package catalog
+
+import (
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+)
+
+//go:embed services.json
+var embedded []byte
+
+var serviceID = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Service is a subscription service. Logo is an image URL; without one in
+// the data, it's the website's favicon. CancelURL, when known, is where to
+// cancel. Plans are list prices, typically in US dollars, and can be out
+// of date.
+type Service struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Category  string   `json:"category"`
+	Website   string   `json:"website"`
+	Logo      string   `json:"logo"`
+	CancelURL string   `json:"cancelUrl,omitempty"`
+	Plans     []Plan   `json:"plans"`
+}
+
+// Plan is one of a service's offers.
+type Plan struct {
+	Name         string       `json:"name"`
+	Cost         models.Money `json:"cost"`
+	Currency     string       `json:"currency"`
+	BillingCycle string       `json:"billingCycle"`
+}
+
+// Plan finds a plan by name, ignoring case.
+func (s Service) Plan(name string) (Plan, bool) {
+	for _, p := range s.Plans {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Plan{}, false
+}
+
+// Catalog is a set of services, sorted by name. It isn't changed once
+// made, so it can be shared.
+type Catalog struct {
+	services []Service
+	byID     map[string]int
+}
+
+// Embedded is the catalog built into the binary.
+func Embedded() *Catalog {
+	c, err := Parse(embedded)
+	if err != nil {
+		panic(fmt.Sprintf("catalog: embedded data: %v", err))
+	}
+	return c
+}
+
+// Load is the embedded catalog with the services in the JSON file at
+// path, if it's not empty, added to it. A service in the file replaces
+// the embedded one with its ID.
+func Load(path string) (*Catalog, error) {
+	c := Embedded()
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	services := slices.Clone(c.services)
+	for _, s := range extra.services {
+		if i, ok := c.byID[s.ID]; ok {
+			services[i] = s
+		} else {
+			services = append(services, s)
+		}
+	}
+	return build(services), nil
+}
+
+// Parse reads a catalog from a JSON array of services, checking each one
+// and putting plan billing cycles in their canonical form.
+func Parse(data []byte) (*Catalog, error) {
+	var services []Service
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var errs []error
+	for i := range services {
+		s := &services[i]
+		if err := check(s); err != nil {
+			errs = append(errs, fmt.Errorf("service %d (%s): %w", i+1, cmp.Or(s.ID, s.Name), err))
+		}
+		if seen[s.ID] {
+			errs = append(errs, fmt.Errorf("service %d: ID %s is used twice", i+1, s.ID))
+		}
+		seen[s.ID] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return build(services), nil
+}
+
+func check(s *Service) error {
+	if !serviceID.MatchString(s.ID) {
+		return errors.New("id must be lowercase letters, digits and dashes")
+	}
+	if strings.TrimSpace(s.Name) == "" || strings.TrimSpace(s.Category) == "" {
+		return errors.New("name and category are required")
+	}
+	u, err := url.Parse(s.Website)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("website must be an https URL")
+	}
+	if s.Logo == "" {
+		s.Logo = (&url.URL{Scheme: "https", Host: u.Host, Path: "/favicon.ico"}).String()
+	}
+	if s.Plans == nil {
+		s.Plans = []Plan{}
+	}
+	for i := range s.Plans {
+		p := &s.Plans[i]
+		cycle, err := models.ParseRecurrence(p.BillingCycle)
+		switch {
+		case p.Name == "":
+			return fmt.Errorf("plan %d has no name", i+1)
+		case p.Cost <= 0:
+			return fmt.Errorf("plan %s must cost more than 0", p.Name)
+		case len(p.Currency) != 3:
+			return fmt.Errorf("plan %s needs a three-letter currency code", p.Name)
+		case err != nil:
+			return fmt.Errorf("plan %s: %w", p.Name, err)
+		}
+		p.Currency = strings.ToUpper(p.Currency)
+		p.BillingCycle = cycle.String()
+	}
+	return nil
+}
+
+func build(services []Service) *Catalog {
+	slices.SortStableFunc(services, func(a, b Service) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	c := &Catalog{services: services, byID: make(map[string]int, len(services))}
+	for i, s := range services {
+		c.byID[s.ID] = i
+	}
+	return c
+}
+
+// Len is how many services the catalog has.
+func (c *Catalog) Len() int { return len(c.services) }
+
+// Get finds a service by ID.
+func (c *Catalog) Get(id string) (Service, bool) {
+	i, ok := c.byID[id]
+	if !ok {
+		return Service{}, false
+	}
+	return c.services[i], true
+}
+
+// Find returns the service called name, or with name as an alias,
+// ignoring case and punctuation.
+func (c *Catalog) Find(name string) (Service, bool) {
+	name = fold(name)
+	if name == "" {
+		return Service{}, false
+	}
+	for _, s := range c.services {
+		if fold(s.Name) == name || slices.ContainsFunc(s.Aliases, func(alias string) bool { return fold(alias) == name }) {
+			return s, true
+		}
+	}
+	return Service{}, false
+}
+
+// Search returns up to limit services whose name or an alias matches q,
+// ignoring case and punctuation: those starting with it first, then those
+// with a word starting with it, then those containing it, each by name.
+// An empty q matches every service.
+func (c *Catalog) Search(q string, limit int) []Service {
+	q = fold(q)
+	type hit struct {
+		rank int
+		i    int
+	}
+	var hits []hit
+	for i, s := range c.services {
+		best := -1
+		for _, name := range append([]string{s.Name}, s.Aliases...) {
+			if r := rank(fold(name), q); r >= 0 && (best < 0 || r < best) {
+				best = r
+			}
+		}
+		if best >= 0 {
+			hits = append(hits, hit{best, i})
+		}
+	}
+	slices.SortStableFunc(hits, func(a, b hit) int { return a.rank - b.rank })
+	results := []Service{}
+	for _, h := range hits[:min(len(hits), limit)] {
+		results = append(results, c.services[h.i])
+	}
+	return results
+}
+
+// rank is how well name matches q, lower being better, or -1 if it
+// doesn't.
+func rank(name, q string) int {
+	switch {
+	case strings.HasPrefix(name, q):
+		return 0
+	case strings.Contains(" "+name, " "+q):
+		return 1
+	case strings.Contains(name, q):
+		return 2
+	}
+	return -1
+}
+
+// fold lowercases s and turns anything but letters, digits, "+" and
+// non-ASCII characters into single spaces, so "HBO Max", "hbo-max" and
+// "HBO  MAX" are the same.
+func fold(s string) string {
+	s = strings.ToLower(s)
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return r != '+' && !('a' <= r && r <= 'z') && !('0' <= r && r <= '9') && r < 0x80
+	}), " ")
+}
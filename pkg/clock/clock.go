@@ -0,0 +1,75 @@
+// Package clock is the source of "now" for every date-dependent
+// calculation: upcoming windows, staleness, billing advancement, reminders
+// and trials. Go through a Clock instead of time.Now() or CURRENT_DATE so
+// tests can pin the date.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock reports the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+// Fake is a manually controlled clock for tests.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Travel runs at normal speed but shifted by an offset. Dev mode uses it for
+// the admin time-travel override.
+type Travel struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *Travel) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// TravelTo moves the clock to t; time keeps passing from there.
+func (c *Travel) TravelTo(t time.Time) {
+	c.mu.Lock()
+	c.offset = time.Until(t)
+	c.mu.Unlock()
+}
+
+// Reset returns the clock to real time.
+func (c *Travel) Reset() {
+	c.mu.Lock()
+	c.offset = 0
+	c.mu.Unlock()
+}
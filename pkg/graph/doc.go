@@ -0,0 +1,6 @@
+// Package graph is the generated GraphQL executor for the subscription
+// tracker. The schema is schema.graphqls; the resolvers are in pkg/api.
+// Run go generate after changing either the schema or gqlgen.yml.
+package graph
+
+//go:generate go tool gqlgen generate
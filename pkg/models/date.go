@@ -0,0 +1,142 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// DateLayout is how dates are written: YYYY-MM-DD.
+const DateLayout = "2006-01-02"
+
+// Date is a calendar day, with no time of day or zone, such as a billing
+// date. It's YYYY-MM-DD in JSON and in the database, and the zero Date
+// means none was given.
+type Date struct {
+	// t is midnight UTC at the start of the day, so Dates compare with ==.
+	t time.Time
+}
+
+// NewDate returns the given day. Out-of-range values normalize as they do
+// for time.Date, so January 32 is February 1.
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// DateOf returns the day t falls on in its own location.
+func DateOf(t time.Time) Date {
+	return NewDate(t.Date())
+}
+
+// ParseDate parses a YYYY-MM-DD date.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
+// MustParseDate is ParseDate for dates known to be valid, such as
+// constants; it panics on a malformed one.
+func MustParseDate(s string) Date {
+	d, err := ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Date) IsZero() bool { return d.t.IsZero() }
+
+// Time is midnight UTC at the start of the day.
+func (d Date) Time() time.Time { return d.t }
+
+// String is the date as YYYY-MM-DD, or "" for the zero Date.
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.t.Format(DateLayout)
+}
+
+// AddDate adds years, months and days as time.Time.AddDate does.
+func (d Date) AddDate(years, months, days int) Date {
+	return Date{d.t.AddDate(years, months, days)}
+}
+
+func (d Date) Before(e Date) bool { return d.t.Before(e.t) }
+func (d Date) After(e Date) bool  { return d.t.After(e.t) }
+
+// Compare returns -1, 0 or +1 as d is before, the same day as or after e.
+func (d Date) Compare(e Date) int { return d.t.Compare(e.t) }
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts a YYYY-MM-DD string, or "" or null for none. A
+// malformed date is reported as a type error, so the decoder names the
+// field it was in.
+func (d *Date) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = Date{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: fmt.Sprintf("string %q, not a YYYY-MM-DD date", s), Type: reflect.TypeOf(d).Elem()}
+	}
+	*d = parsed
+	return nil
+}
+
+// Scan reads a date column. Postgres drivers return DATE columns as a
+// time and SQLite as text in one of a few layouts; either way only the
+// day is kept.
+func (d *Date) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Date{}
+		return nil
+	case time.Time:
+		*d = NewDate(v.Date())
+		return nil
+	case []byte:
+		return d.scanText(string(v))
+	case string:
+		return d.scanText(v)
+	}
+	return fmt.Errorf("can't scan %T into a date", src)
+}
+
+func (d *Date) scanText(s string) error {
+	if len(s) > len(DateLayout) {
+		s = s[:len(DateLayout)]
+	}
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return fmt.Errorf("scanning date: %w", err)
+	}
+	*d = parsed
+	return nil
+}
+
+// Value writes the date as YYYY-MM-DD text, which both Postgres and
+// SQLite store as the same day, or NULL if it's zero.
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
@@ -0,0 +1,427 @@
+// Package models holds the types the subscription tracker stores and
+// returns over its API. They are plain data with JSON tags; behavior lives
+// in the api and store packages.
+package models
+
+// DefaultCurrency is the currency of amounts stored without one, including
+// everything from before currencies were tracked.
+const DefaultCurrency = "USD"
+
+// User is an account. Every subscription, transaction and alert belongs to
+// exactly one user.
+type User struct {
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"createdAt"`
+	// Currency is the ISO 4217 code stats are shown in.
+	Currency string `json:"currency"`
+	// TwoFactorEnabled is set once the user has turned on TOTP, after which
+	// logging in also takes a code.
+	TwoFactorEnabled bool `json:"twoFactorEnabled"`
+	// MonthlyDigest opts the user in to a summary email at the start of
+	// each month.
+	MonthlyDigest bool `json:"monthlyDigest"`
+	// DeleteAfter is when the account is erased, if the user has asked for
+	// that. Logging in again cancels it.
+	DeleteAfter *string `json:"deleteAfter,omitempty"`
+	// TenantID is the tenant the account belongs to. Access tokens carry
+	// it rather than the API showing it.
+	TenantID int `json:"-"`
+}
+
+// Session is a signed-in device: one login and the refresh tokens it's
+// been rotated through. Current marks the session of the request that
+// listed it.
+type Session struct {
+	ID         int    `json:"id"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	Current    bool   `json:"current"`
+}
+
+// Subscription is a recurring charge the user is tracking.
+type Subscription struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Category     string `json:"category"`
+	Cost         Money  `json:"cost"`
+	Currency     string `json:"currency"`
+	BillingCycle string `json:"billingCycle"`
+	NextBilling  Date   `json:"nextBilling"`
+	Description  string `json:"description"`
+	// Tags are the names of the user's tags on the subscription, sorted.
+	Tags []string `json:"tags"`
+	// IsTrial marks a free trial, which ends on TrialEndsAt (YYYY-MM-DD).
+	// The date is kept once the trial has become a regular subscription.
+	IsTrial     bool    `json:"isTrial"`
+	TrialEndsAt *string `json:"trialEndsAt"`
+	// Status is one of the Status constants. A cancelled subscription
+	// has the date it ended (YYYY-MM-DD) and, if given, why.
+	Status             string  `json:"status"`
+	CancelledAt        *string `json:"cancelledAt"`
+	CancellationReason *string `json:"cancellationReason"`
+	// ArchivedAt (RFC 3339) is when the subscription was archived, which
+	// hides it from the subscription list and stats but keeps it in
+	// reports. It's nil unless it's archived.
+	ArchivedAt *string `json:"archivedAt"`
+
+	LastVerifiedAt *string `json:"lastVerifiedAt"`
+	Stale          bool    `json:"stale"`
+	// CreatedAt and UpdatedAt (RFC 3339) are when the subscription was
+	// added and last changed. Confirming it alone doesn't count as a
+	// change.
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+
+	// Version goes up by one with every write to the subscription. An
+	// update must send it, or the ETag, which covers it too, and only goes
+	// through if the subscription is still at that version.
+	Version int `json:"version"`
+}
+
+// CancellationInfo is how to cancel a subscription: the page to do it on
+// and any steps to follow. RemindBeforeRenewal asks for a reminder to
+// cancel shortly before each renewal.
+type CancellationInfo struct {
+	URL                 string `json:"url"`
+	Instructions        string `json:"instructions"`
+	RemindBeforeRenewal bool   `json:"remindBeforeRenewal"`
+}
+
+// Subscription statuses. Only active subscriptions bill; paused and
+// cancelled ones are left out of the stats and forecast.
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+)
+
+// Tag is a user's label for grouping subscriptions, such as "work" or
+// "trial". Subscriptions counts the subscriptions carrying it.
+type Tag struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+// BillingEvent is a billing date that has passed, recorded when the
+// subscription's next billing date was rolled forward past it. Amount is
+// in Currency. Rate is what one unit of Currency bought in RecordCurrency,
+// the user's display currency, on the day; both are empty when no rate
+// was known.
+type BillingEvent struct {
+	ID             int      `json:"id"`
+	SubscriptionID int      `json:"subscriptionId"`
+	Date           string   `json:"date"`
+	Amount         Money    `json:"amount"`
+	Currency       string   `json:"currency"`
+	RecordCurrency string   `json:"recordCurrency,omitempty"`
+	Rate           *float64 `json:"rate,omitempty"`
+	RecordedAt     string   `json:"recordedAt"`
+}
+
+// Budget caps monthly spending, normalized as in the stats, on one
+// category or, with a null Category, on everything.
+type Budget struct {
+	ID       int     `json:"id"`
+	Category *string `json:"category"`
+	Amount   Money   `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Household roles. The owner invites and removes members and can delete
+// the household; members can share their own subscriptions with it.
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// Household is a group of accounts sharing subscriptions. A user belongs
+// to at most one. Members are listed owner first, then in the order they
+// joined.
+type Household struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	CreatedAt string            `json:"createdAt"`
+	Members   []HouseholdMember `json:"members"`
+}
+
+// HouseholdMember is a user in a household.
+type HouseholdMember struct {
+	UserID   int    `json:"userId"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joinedAt"`
+}
+
+// HouseholdInvite asks whoever has the account for Email to join a
+// household. InvitedBy is the inviter's email, null if they've since
+// deleted their account.
+type HouseholdInvite struct {
+	ID          int     `json:"id"`
+	HouseholdID int     `json:"householdId"`
+	Household   string  `json:"household"`
+	Email       string  `json:"email"`
+	InvitedBy   *string `json:"invitedBy"`
+	CreatedAt   string  `json:"createdAt"`
+}
+
+// CostSplit is the percentage of a shared subscription's cost one member
+// pays.
+type CostSplit struct {
+	UserID  int     `json:"userId"`
+	Percent float64 `json:"percent"`
+}
+
+// SharedSubscription is a subscription shared with a household by OwnerID,
+// the member it belongs to. Splits are what each member pays: the ones set
+// for it or, when EqualSplit is set, an equal share for every member.
+type SharedSubscription struct {
+	Subscription
+	OwnerID    int         `json:"ownerId"`
+	Splits     []CostSplit `json:"splits"`
+	EqualSplit bool        `json:"equalSplit"`
+}
+
+// PriceChange is one change to a subscription's price.
+type PriceChange struct {
+	ID          int    `json:"id"`
+	OldCost     Money  `json:"oldCost"`
+	OldCurrency string `json:"oldCurrency"`
+	NewCost     Money  `json:"newCost"`
+	NewCurrency string `json:"newCurrency"`
+	ChangedAt   string `json:"changedAt"`
+}
+
+// Audit actions.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry records one change to a subscription. ActorID and Actor, the
+// email, say who made it; both are null for changes the server made
+// itself, such as rolling a billing date forward. Changes maps each field
+// that changed to its old and new value.
+type AuditEntry struct {
+	ID             int                    `json:"id"`
+	SubscriptionID int                    `json:"subscriptionId"`
+	Action         string                 `json:"action"`
+	ActorID        *int                   `json:"actorId"`
+	Actor          *string                `json:"actor"`
+	Changes        map[string]AuditChange `json:"changes"`
+	CreatedAt      string                 `json:"createdAt"`
+}
+
+// AuditChange is a field's value before and after a change. From is null
+// when the subscription was created and To when it was deleted.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Webhook events.
+const (
+	EventSubscriptionCreated         = "subscription.created"
+	EventSubscriptionUpdated         = "subscription.updated"
+	EventSubscriptionDeleted         = "subscription.deleted"
+	EventSubscriptionRenewalUpcoming = "subscription.renewal_upcoming"
+	EventBudgetExceeded              = "budget.exceeded"
+)
+
+// Webhook is a URL that is sent the user's subscription events. Secret,
+// the key payloads are signed with, is only returned when the webhook is
+// created.
+type Webhook struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// ShareLink is a public, read-only link to some of the user's
+// subscriptions and, if IncludeStats, their totals. An empty
+// SubscriptionIDs shares every active subscription. Token, which is in
+// Path, is only returned when the link is created. A link without
+// ExpiresAt works until it's revoked.
+type ShareLink struct {
+	ID              int     `json:"id"`
+	Label           string  `json:"label"`
+	SubscriptionIDs []int   `json:"subscriptionIds"`
+	IncludeStats    bool    `json:"includeStats"`
+	Token           string  `json:"token,omitempty"`
+	Path            string  `json:"path,omitempty"`
+	CreatedAt       string  `json:"createdAt"`
+	ExpiresAt       *string `json:"expiresAt"`
+}
+
+// NotificationChannel is a user's setting for one push channel: webpush,
+// ntfy or gotify. URL is the ntfy topic or Gotify server. The token is
+// never returned; HasToken says whether one is set.
+type NotificationChannel struct {
+	Channel  string `json:"channel"`
+	Enabled  bool   `json:"enabled"`
+	URL      string `json:"url,omitempty"`
+	HasToken bool   `json:"hasToken"`
+}
+
+// PushSubscription is a browser registered for Web Push.
+type PushSubscription struct {
+	ID        int    `json:"id"`
+	Endpoint  string `json:"endpoint"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Attachment is a receipt or invoice attached to a subscription. Size is
+// in bytes.
+type Attachment struct {
+	ID             int    `json:"id"`
+	SubscriptionID int    `json:"subscriptionId"`
+	Filename       string `json:"filename"`
+	ContentType    string `json:"contentType"`
+	Size           int64  `json:"size"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+// UsageEvent is one logged use of a subscription, on Date, with an
+// optional note such as "watched two episodes".
+type UsageEvent struct {
+	ID             int    `json:"id"`
+	SubscriptionID int    `json:"subscriptionId"`
+	Date           Date   `json:"date"`
+	Note           string `json:"note"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+// StripeLink ties a subscription to the Stripe subscription it's billed
+// through. Status is the Stripe subscription's status when it was last
+// fetched, and SyncedAt (RFC 3339) when the last sync ran; Error says why
+// that sync failed, if it did.
+type StripeLink struct {
+	SubscriptionID       int     `json:"subscriptionId"`
+	StripeSubscriptionID string  `json:"stripeSubscriptionId"`
+	Status               *string `json:"status"`
+	SyncedAt             *string `json:"syncedAt"`
+	Error                *string `json:"error"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook.
+// ResponseStatus and Error describe the latest attempt.
+type WebhookDelivery struct {
+	ID             int     `json:"id"`
+	Event          string  `json:"event"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	ResponseStatus *int    `json:"responseStatus"`
+	Error          *string `json:"error"`
+	CreatedAt      string  `json:"createdAt"`
+	NextAttemptAt  *string `json:"nextAttemptAt"`
+	DeliveredAt    *string `json:"deliveredAt"`
+}
+
+// Transaction is a charge imported from a bank, Stripe or PayPal feed.
+type Transaction struct {
+	ID             int    `json:"id"`
+	Source         string `json:"source"`
+	ExternalID     string `json:"externalId"`
+	Description    string `json:"description"`
+	Amount         Money  `json:"amount"`
+	Date           string `json:"date"`
+	SubscriptionID *int   `json:"subscriptionId"`
+	MatchStatus    string `json:"matchStatus"`
+}
+
+// Transaction match statuses.
+const (
+	MatchStatusUnmatched = "unmatched"
+	MatchStatusMatched   = "matched"
+	MatchStatusReview    = "review"
+)
+
+// MatchCandidate is a possible transaction-to-subscription link waiting for
+// a human decision because the matcher wasn't confident enough.
+type MatchCandidate struct {
+	ID               int         `json:"id"`
+	Score            float64     `json:"score"`
+	Status           string      `json:"status"`
+	Transaction      Transaction `json:"transaction"`
+	SubscriptionID   int         `json:"subscriptionId"`
+	SubscriptionName string      `json:"subscriptionName"`
+}
+
+// Alert kinds.
+const (
+	AlertUnknownRecurringCharge = "unknown_recurring_charge"
+	AlertChargeAmountMismatch   = "charge_amount_mismatch"
+	AlertStaleSubscription      = "stale_subscription"
+	AlertPriceIncreased         = "price_increased"
+	AlertBudgetExceeded         = "budget_exceeded"
+	AlertTrialEnded             = "trial_ended"
+)
+
+// Alert is an entry in the alerts feed.
+type Alert struct {
+	ID             int    `json:"id"`
+	Kind           string `json:"kind"`
+	Message        string `json:"message"`
+	SubscriptionID *int   `json:"subscriptionId"`
+	TransactionID  *int   `json:"transactionId"`
+	CreatedAt      string `json:"createdAt"`
+	Dismissed      bool   `json:"dismissed"`
+}
+
+// Reconciliation flags.
+const (
+	FlagMissedCharge   = "missed_charge"
+	FlagDoubleCharge   = "double_charge"
+	FlagAmountMismatch = "amount_mismatch"
+)
+
+// ActualCharge is a matched transaction as it appears in a reconciliation.
+type ActualCharge struct {
+	TransactionID int    `json:"transactionId"`
+	Date          string `json:"date"`
+	Amount        Money  `json:"amount"`
+}
+
+// ReconciliationItem compares one subscription's expected charges for the
+// month with the transactions matched to it.
+type ReconciliationItem struct {
+	SubscriptionID  int            `json:"subscriptionId"`
+	Name            string         `json:"name"`
+	ExpectedCharges []string       `json:"expectedCharges"`
+	ActualCharges   []ActualCharge `json:"actualCharges"`
+	ExpectedAmount  Money          `json:"expectedAmount"`
+	ActualAmount    Money          `json:"actualAmount"`
+	Flags           []string       `json:"flags"`
+}
+
+// ReconciliationReport is the response of GET /api/reconciliation.
+type ReconciliationReport struct {
+	Month         string               `json:"month"`
+	ExpectedTotal Money                `json:"expectedTotal"`
+	ActualTotal   Money                `json:"actualTotal"`
+	Summary       map[string]int       `json:"summary"`
+	Items         []ReconciliationItem `json:"items"`
+}
+
+// Page is one slice of a paginated list. Total counts every matching item,
+// not just those in Items.
+type Page[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
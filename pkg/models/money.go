@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Money is an amount in hundredths of its currency's unit, such as cents,
+// so that sums and comparisons are exact. It's stored as a whole number of
+// cents, and in JSON it's a number with up to two decimal places, as
+// amounts always were.
+type Money int64
+
+// MoneyFromFloat rounds f to the nearest hundredth, halves away from zero.
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// ParseMoney parses a decimal amount such as "15.49" or "-3". Digits past
+// the second decimal place are rounded, as MoneyFromFloat does.
+func ParseMoney(s string) (Money, error) {
+	// big.Rat would also take fractions such as "1/3".
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	r.Mul(r, big.NewRat(100, 1))
+	// Round half away from zero: add or subtract a half, then truncate.
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	r.Add(r, half)
+	cents := new(big.Int).Quo(r.Num(), r.Denom())
+	if !cents.IsInt64() {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+	return Money(cents.Int64()), nil
+}
+
+// Float is m in whole units, for computations such as currency conversion
+// that aren't exact anyway.
+func (m Money) Float() float64 { return float64(m) / 100 }
+
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// String is m with two decimal places, such as "15.49" or "120.00".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+	}
+	// Negating math.MinInt64 overflows, so work with the unsigned value.
+	abs := uint64(m)
+	if m < 0 {
+		abs = -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+// MarshalJSON writes m as the shortest number that is exactly it: 15.49,
+// 15.5 or 120, as encoding/json writes the float64 amounts used to be.
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.String()
+	switch {
+	case s[len(s)-2:] == "00":
+		s = s[:len(s)-3]
+	case s[len(s)-1] == '0':
+		s = s[:len(s)-1]
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalJSON reads a JSON number exactly, without going through a
+// float64. Anything else is a type error, so the decoder names the field.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) == 0 || data[0] == '"' {
+		return &json.UnmarshalTypeError{Value: "string", Type: reflect.TypeOf(m).Elem()}
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(n.String())
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "number " + n.String(), Type: reflect.TypeOf(m).Elem()}
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a column of cents. Sums of one come back from Postgres as a
+// numeric, which the driver gives as text.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		*m = Money(v)
+		return nil
+	case float64:
+		*m = Money(math.Round(v))
+		return nil
+	case []byte:
+		return m.scanText(string(v))
+	case string:
+		return m.scanText(v)
+	}
+	return fmt.Errorf("can't scan %T into an amount", src)
+}
+
+func (m *Money) scanText(s string) error {
+	cents, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("scanning amount: %w", err)
+	}
+	*m = Money(cents)
+	return nil
+}
+
+// Value writes m as a whole number of cents.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
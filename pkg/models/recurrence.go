@@ -0,0 +1,168 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Recurrence units.
+const (
+	UnitWeek  = "week"
+	UnitMonth = "month"
+	UnitYear  = "year"
+)
+
+// MaxInterval is the most units a recurrence can have between charges.
+const MaxInterval = 99
+
+// ErrUnknownCycle is returned by ParseRecurrence for text that isn't a
+// billing cycle at all.
+var ErrUnknownCycle = errors.New("not a billing cycle")
+
+var errDayOfMonth = errors.New("dayOfMonth must be from 1 to 31")
+
+// Recurrence is a billing cycle: a charge every Interval Units. A monthly
+// or yearly one can be anchored to a day of the month, so that it charges
+// on that day however short the months in between. Without an anchor it
+// charges on the day of the month of the charge it counts from, which
+// StartingOn keeps to days every month has.
+//
+// As a subscription's billingCycle, a Recurrence is written as text:
+// "weekly", "monthly", "quarterly" or "yearly" where one of those fits,
+// otherwise "every 2 weeks" and the like, with " on day 15" or " on the
+// last day" for an anchor.
+type Recurrence struct {
+	Interval int    `json:"interval"`
+	Unit     string `json:"unit"`
+	// DayOfMonth is the day a charge falls on, 1 to 31; a month with fewer
+	// days is charged on its last. LastDayOfMonth charges on the last day
+	// of every month. At most one of them is set.
+	DayOfMonth     int  `json:"dayOfMonth,omitempty"`
+	LastDayOfMonth bool `json:"lastDayOfMonth,omitempty"`
+}
+
+// namedCycles are the cycles with a name of their own, as String writes
+// them.
+var namedCycles = map[string]Recurrence{
+	"weekly":    {Interval: 1, Unit: UnitWeek},
+	"monthly":   {Interval: 1, Unit: UnitMonth},
+	"quarterly": {Interval: 3, Unit: UnitMonth},
+	"yearly":    {Interval: 1, Unit: UnitYear},
+}
+
+// ParseRecurrence parses a billing cycle as String writes it, ignoring case
+// and extra spaces, and also takes "annual" and "annually" for yearly and
+// a plural or singular unit whatever the interval. It returns
+// ErrUnknownCycle for text it can't read and a Validate error for a cycle
+// out of range.
+func ParseRecurrence(s string) (Recurrence, error) {
+	words := strings.Fields(strings.ToLower(s))
+	var r Recurrence
+	switch {
+	case len(words) == 0:
+		return Recurrence{}, ErrUnknownCycle
+	case words[0] == "annual" || words[0] == "annually":
+		r, words = namedCycles["yearly"], words[1:]
+	case words[0] == "every":
+		words = words[1:]
+		r.Interval = 1
+		if len(words) > 0 {
+			if n, err := strconv.Atoi(words[0]); err == nil {
+				r.Interval, words = n, words[1:]
+			}
+		}
+		if len(words) == 0 {
+			return Recurrence{}, ErrUnknownCycle
+		}
+		r.Unit, words = strings.TrimSuffix(words[0], "s"), words[1:]
+		if r.Unit != UnitWeek && r.Unit != UnitMonth && r.Unit != UnitYear {
+			return Recurrence{}, ErrUnknownCycle
+		}
+	default:
+		named, ok := namedCycles[words[0]]
+		if !ok {
+			return Recurrence{}, ErrUnknownCycle
+		}
+		r, words = named, words[1:]
+	}
+
+	switch anchor := strings.Join(words, " "); {
+	case anchor == "":
+	case anchor == "on the last day" || anchor == "on last day":
+		r.LastDayOfMonth = true
+	case strings.HasPrefix(anchor, "on day "):
+		day, err := strconv.Atoi(strings.TrimPrefix(anchor, "on day "))
+		if err != nil {
+			return Recurrence{}, ErrUnknownCycle
+		}
+		if day < 1 {
+			return Recurrence{}, errDayOfMonth
+		}
+		r.DayOfMonth = day
+	default:
+		return Recurrence{}, ErrUnknownCycle
+	}
+	if err := r.Validate(); err != nil {
+		return Recurrence{}, err
+	}
+	return r, nil
+}
+
+// Validate checks that r is a cycle a subscription can have.
+func (r Recurrence) Validate() error {
+	switch {
+	case r.Unit != UnitWeek && r.Unit != UnitMonth && r.Unit != UnitYear:
+		return errors.New("unit must be week, month or year")
+	case r.Interval < 1 || r.Interval > MaxInterval:
+		return fmt.Errorf("interval must be from 1 to %d", MaxInterval)
+	case r.DayOfMonth < 0 || r.DayOfMonth > 31:
+		// Zero is no anchor, as when dayOfMonth is left out.
+		return errDayOfMonth
+	case r.DayOfMonth > 0 && r.LastDayOfMonth:
+		return errors.New("can't have both dayOfMonth and lastDayOfMonth")
+	case r.Unit == UnitWeek && (r.DayOfMonth > 0 || r.LastDayOfMonth):
+		return errors.New("can't anchor a weekly cycle to a day of the month")
+	}
+	return nil
+}
+
+// Anchored reports whether r charges on a fixed day of the month.
+func (r Recurrence) Anchored() bool { return r.DayOfMonth > 0 || r.LastDayOfMonth }
+
+// StartingOn returns r for a subscription charged on date. A monthly or
+// yearly cycle without an anchor is anchored to date's day if that's past
+// the 28th: counting on from the last charge would otherwise keep it on
+// the 28th for good after one February.
+func (r Recurrence) StartingOn(date Date) Recurrence {
+	if r.Unit != UnitWeek && !r.Anchored() && !date.IsZero() && date.Time().Day() > 28 {
+		r.DayOfMonth = date.Time().Day()
+	}
+	return r
+}
+
+// String writes r as ParseRecurrence reads it, such as "monthly", "every
+// 2 weeks" or "every 3 months on the last day".
+func (r Recurrence) String() string {
+	base := ""
+	for name, named := range namedCycles {
+		if named.Interval == r.Interval && named.Unit == r.Unit {
+			base = name
+		}
+	}
+	switch {
+	case base != "":
+	case r.Interval == 1:
+		base = "every " + r.Unit
+	default:
+		base = fmt.Sprintf("every %d %ss", r.Interval, r.Unit)
+	}
+	switch {
+	case r.LastDayOfMonth:
+		return base + " on the last day"
+	case r.DayOfMonth > 0:
+		return fmt.Sprintf("%s on day %d", base, r.DayOfMonth)
+	}
+	return base
+}
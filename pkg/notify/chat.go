@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// discordColor is the accent stripe of Discord embeds.
+const discordColor = 0x4f46e5
+
+// Slack posts to a Slack incoming webhook as Block Kit blocks: the title
+// as a header, the body and then the fields two to a row.
+type Slack struct {
+	Client *http.Client
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+func (s Slack) Publish(ctx context.Context, dest Destination, msg Push) error {
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: msg.Title}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: slackEscape(msg.Body)}},
+	}
+	if len(msg.Fields) > 0 {
+		fields := make([]slackText, len(msg.Fields))
+		for i, f := range msg.Fields {
+			fields[i] = slackText{Type: "mrkdwn", Text: "*" + slackEscape(f.Name) + "*\n" + slackEscape(f.Value)}
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Fields: fields})
+	}
+	// text is what notifications and clients without blocks show.
+	return postJSON(ctx, s.Client, dest.URL, "slack", map[string]any{
+		"text":   msg.Title + ": " + msg.Body,
+		"blocks": blocks,
+	})
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// Discord posts to a Discord webhook as an embed, with the fields inline.
+type Discord struct {
+	Client *http.Client
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+func (d Discord) Publish(ctx context.Context, dest Destination, msg Push) error {
+	embed := discordEmbed{Title: msg.Title, Description: msg.Body, Color: discordColor}
+	for _, f := range msg.Fields {
+		embed.Fields = append(embed.Fields, discordField{Name: f.Name, Value: f.Value, Inline: true})
+	}
+	return postJSON(ctx, d.Client, dest.URL, "discord", map[string]any{
+		"embeds":           []discordEmbed{embed},
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+}
+
+func postJSON(ctx context.Context, c *http.Client, url, service string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client(c).Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp, service)
+}
@@ -0,0 +1,46 @@
+// Package notify delivers alerts and email to the user outside the API.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"subscription-tracker/pkg/models"
+)
+
+// Notifier delivers alerts to the user.
+type Notifier interface {
+	Notify(a models.Alert) error
+}
+
+// LogNotifier writes alerts to the server log. It's the default until a
+// real delivery channel is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(a models.Alert) error {
+	slog.Info("alert", "kind", a.Kind, "message", a.Message)
+	return nil
+}
+
+// Email is an outgoing message.
+type Email struct {
+	To       []string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, msg Email) error
+}
+
+// LogMailer prints messages to the server log instead of sending them. Dev
+// mode uses it in place of SMTP.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Email) error {
+	slog.InfoContext(ctx, "dev mail", "to", strings.Join(msg.To, ","), "subject", msg.Subject, "body", msg.TextBody)
+	return nil
+}
@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+// Channel names, as stored in a user's notification settings.
+const (
+	ChannelWebPush = "webpush"
+	ChannelNtfy    = "ntfy"
+	ChannelGotify  = "gotify"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+)
+
+// ErrGone means the destination no longer exists, such as a browser push
+// subscription that was revoked, and shouldn't be sent to again.
+var ErrGone = errors.New("push destination is gone")
+
+// Push is a short notification for a phone or browser.
+type Push struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Tag groups pushes about the same thing, so a newer one can replace
+	// an older one still on screen.
+	Tag string `json:"tag,omitempty"`
+	// Fields are details shown as a table where the channel can, as in
+	// Slack and Discord, and left out elsewhere.
+	Fields []PushField `json:"fields,omitempty"`
+}
+
+// PushField is one labelled detail of a Push.
+type PushField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Destination is where one user's pushes go on a channel. URL is the
+// ntfy topic URL, the Gotify server, the Slack or Discord incoming webhook
+// or the Web Push endpoint; Token is the
+// ntfy access token or Gotify application token. P256dh and Auth are a
+// Web Push subscription's keys.
+type Destination struct {
+	URL    string
+	Token  string
+	P256dh string
+	Auth   string
+}
+
+// Channel delivers pushes to one kind of destination.
+type Channel interface {
+	Publish(ctx context.Context, dest Destination, msg Push) error
+}
+
+// WebPush sends to browser push subscriptions, signed with the server's
+// VAPID keys. Subject is a mailto: or https: contact for the push
+// services.
+type WebPush struct {
+	PublicKey  string
+	PrivateKey string
+	Subject    string
+	Client     *http.Client
+}
+
+func (p WebPush) Publish(ctx context.Context, dest Destination, msg Push) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	opts := &webpush.Options{
+		Subscriber:      p.Subject,
+		VAPIDPublicKey:  p.PublicKey,
+		VAPIDPrivateKey: p.PrivateKey,
+		TTL:             24 * 60 * 60,
+		Topic:           topic(msg.Tag),
+	}
+	if p.Client != nil {
+		opts.HTTPClient = p.Client
+	}
+	resp, err := webpush.SendNotificationWithContext(ctx, payload, &webpush.Subscription{
+		Endpoint: dest.URL,
+		Keys:     webpush.Keys{P256dh: dest.P256dh, Auth: dest.Auth},
+	}, opts)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return fmt.Errorf("push service returned %s: %w", resp.Status, ErrGone)
+	}
+	return checkResponse(resp, "push service")
+}
+
+// topic makes tag a valid Topic header, which allows at most 32 URL-safe
+// base64 characters.
+func topic(tag string) string {
+	t := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, tag)
+	if len(t) > 32 {
+		t = t[:32]
+	}
+	return t
+}
+
+// Ntfy publishes to an ntfy topic, given by its full URL such as
+// https://ntfy.sh/my-topic. Token, if set, is sent as a bearer token for
+// protected topics.
+type Ntfy struct {
+	Client *http.Client
+}
+
+func (n Ntfy) Publish(ctx context.Context, dest Destination, msg Push) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", dest.URL, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	if dest.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+dest.Token)
+	}
+	resp, err := client(n.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp, "ntfy")
+}
+
+// Gotify posts messages to a Gotify server with an application token.
+type Gotify struct {
+	Client *http.Client
+}
+
+func (g Gotify) Publish(ctx context.Context, dest Destination, msg Push) error {
+	body, err := json.Marshal(map[string]string{"title": msg.Title, "message": msg.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(dest.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", dest.Token)
+	resp, err := client(g.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp, "gotify")
+}
+
+func client(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
+
+// checkResponse drains and closes resp, returning an error unless its
+// status is 2xx.
+func checkResponse(resp *http.Response, service string) error {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", service, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package rates
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ECBURL is the European Central Bank's daily reference rates, which are
+// against the euro.
+const ECBURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+// OpenExchangeRatesURL is openexchangerates.org's latest rates endpoint.
+const OpenExchangeRatesURL = "https://openexchangerates.org/api/latest.json"
+
+// ECB fetches the European Central Bank's reference rates. URL defaults to
+// ECBURL.
+type ECB struct {
+	URL    string
+	Client *http.Client
+}
+
+func (e ECB) Fetch(ctx context.Context) (Table, error) {
+	var doc struct {
+		Cube struct {
+			Cube struct {
+				Rates []struct {
+					Currency string  `xml:"currency,attr"`
+					Rate     float64 `xml:"rate,attr"`
+				} `xml:"Cube"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	}
+	resp, err := get(ctx, e.Client, cmp.Or(e.URL, ECBURL))
+	if err != nil {
+		return Table{}, err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return Table{}, fmt.Errorf("decoding ECB rates: %w", err)
+	}
+
+	t := Table{Base: "EUR", Rates: map[string]float64{}}
+	for _, r := range doc.Cube.Cube.Rates {
+		t.Rates[r.Currency] = r.Rate
+	}
+	if len(t.Rates) == 0 {
+		return Table{}, fmt.Errorf("ECB response has no rates")
+	}
+	return t, nil
+}
+
+// OpenExchangeRates fetches rates from openexchangerates.org with the
+// given app ID. URL defaults to OpenExchangeRatesURL.
+type OpenExchangeRates struct {
+	URL    string
+	AppID  string
+	Client *http.Client
+}
+
+func (o OpenExchangeRates) Fetch(ctx context.Context) (Table, error) {
+	u, err := url.Parse(cmp.Or(o.URL, OpenExchangeRatesURL))
+	if err != nil {
+		return Table{}, err
+	}
+	q := u.Query()
+	q.Set("app_id", o.AppID)
+	u.RawQuery = q.Encode()
+
+	resp, err := get(ctx, o.Client, u.String())
+	if err != nil {
+		return Table{}, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Table{}, fmt.Errorf("decoding openexchangerates response: %w", err)
+	}
+	if body.Base == "" || len(body.Rates) == 0 {
+		return Table{}, fmt.Errorf("openexchangerates response has no rates")
+	}
+	return Table{Base: body.Base, Rates: body.Rates}, nil
+}
+
+func get(ctx context.Context, client *http.Client, target string) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("exchange rate provider returned %s", resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,132 @@
+// Package rates converts between currencies. A Table holds rates against
+// one base currency; Static serves a fixed table, and Cached serves one
+// fetched from a Source such as the ECB or openexchangerates.org,
+// refreshing it when it gets old.
+package rates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/clock"
+)
+
+// ErrUnknownCurrency is returned for a currency a table has no rate for.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// Table is a set of exchange rates: one unit of Base buys Rates[c] of
+// currency c.
+type Table struct {
+	Base  string
+	Rates map[string]float64
+}
+
+// Rate is how many units of to one unit of from buys.
+func (t Table) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	f, err := t.perBase(from)
+	if err != nil {
+		return 0, err
+	}
+	c, err := t.perBase(to)
+	if err != nil {
+		return 0, err
+	}
+	return c / f, nil
+}
+
+func (t Table) perBase(currency string) (float64, error) {
+	if currency == t.Base {
+		return 1, nil
+	}
+	if r, ok := t.Rates[currency]; ok && r > 0 {
+		return r, nil
+	}
+	return 0, fmt.Errorf("%w %s", ErrUnknownCurrency, currency)
+}
+
+// ParseTable reads a table written as "EUR=0.92,GBP=0.79", rates per one
+// unit of base.
+func ParseTable(base, s string) (Table, error) {
+	t := Table{Base: base, Rates: map[string]float64{}}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return t, fmt.Errorf("%q is not CODE=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate <= 0 {
+			return t, fmt.Errorf("rate for %s must be a positive number", strings.TrimSpace(code))
+		}
+		t.Rates[strings.ToUpper(strings.TrimSpace(code))] = rate
+	}
+	return t, nil
+}
+
+// Static serves a fixed table.
+type Static struct {
+	Table Table
+}
+
+func (s Static) Rate(_ context.Context, from, to string) (float64, error) {
+	return s.Table.Rate(from, to)
+}
+
+// Source fetches a current table.
+type Source interface {
+	Fetch(ctx context.Context) (Table, error)
+}
+
+// Cached serves rates from a table fetched from Source, fetching a new one
+// once it is older than TTL. If a refresh fails it keeps using the old
+// table, so a provider outage doesn't take conversions down with it.
+type Cached struct {
+	Source Source
+	TTL    time.Duration
+	Clock  clock.Clock
+
+	mu        sync.Mutex
+	table     Table
+	fetchedAt time.Time
+}
+
+func (c *Cached) Rate(ctx context.Context, from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	table, err := c.current(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return table.Rate(from, to)
+}
+
+func (c *Cached) current(ctx context.Context) (Table, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.Clock.Now()
+	if c.table.Rates != nil && now.Sub(c.fetchedAt) < c.TTL {
+		return c.table, nil
+	}
+	table, err := c.Source.Fetch(ctx)
+	if err != nil {
+		if c.table.Rates == nil {
+			return Table{}, err
+		}
+		slog.WarnContext(ctx, "refreshing exchange rates; using the previous table", "fetched_at", c.fetchedAt, "err", err)
+		return c.table, nil
+	}
+	c.table, c.fetchedAt = table, now
+	return table, nil
+}
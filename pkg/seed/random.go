@@ -0,0 +1,212 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"subscription-tracker/pkg/models"
+)
+
+// service is a kind of subscription Random can make up: a name, its
+// category and what it costs a month, in cents.
+type service struct {
+	name     string
+	category string
+	min, max models.Money
+}
+
+// services are well-known services Random uses first.
+var services = []service{
+	{"Netflix", "Entertainment", 699, 2299},
+	{"Disney+", "Entertainment", 799, 1399},
+	{"Hulu", "Entertainment", 799, 1799},
+	{"Max", "Entertainment", 999, 1999},
+	{"Paramount+", "Entertainment", 599, 1199},
+	{"Apple TV+", "Entertainment", 999, 999},
+	{"YouTube Premium", "Entertainment", 1399, 2299},
+	{"Crunchyroll", "Entertainment", 799, 1499},
+	{"Xbox Game Pass", "Gaming", 999, 1999},
+	{"PlayStation Plus", "Gaming", 999, 1799},
+	{"Nintendo Switch Online", "Gaming", 399, 399},
+	{"Spotify", "Music", 1199, 1999},
+	{"Apple Music", "Music", 1099, 1699},
+	{"Tidal", "Music", 1099, 1099},
+	{"Audible", "Books", 799, 1495},
+	{"Kindle Unlimited", "Books", 1199, 1199},
+	{"GitHub", "Software", 400, 2100},
+	{"JetBrains", "Software", 1690, 2890},
+	{"1Password", "Software", 299, 499},
+	{"Notion", "Software", 800, 1500},
+	{"Figma", "Software", 1500, 4500},
+	{"Adobe Creative Cloud", "Software", 2299, 5999},
+	{"Microsoft 365", "Software", 699, 1299},
+	{"Grammarly", "Software", 1200, 3000},
+	{"Slack", "Software", 725, 1250},
+	{"Zoom", "Software", 1333, 2199},
+	{"iCloud+", "Cloud", 99, 999},
+	{"Google One", "Cloud", 199, 999},
+	{"Dropbox", "Cloud", 999, 1999},
+	{"Backblaze", "Cloud", 900, 900},
+	{"DigitalOcean", "Cloud", 400, 4800},
+	{"The New York Times", "News", 400, 2500},
+	{"The Guardian", "News", 700, 1200},
+	{"The Economist", "News", 1900, 2900},
+	{"Medium", "News", 500, 500},
+	{"Peloton", "Fitness", 1299, 4400},
+	{"Strava", "Fitness", 1199, 1199},
+	{"Headspace", "Fitness", 1299, 1299},
+	{"ClassPass", "Fitness", 1900, 7900},
+	{"HelloFresh", "Food", 6000, 12000},
+	{"DashPass", "Food", 999, 999},
+	{"Duolingo", "Education", 699, 1299},
+	{"Masterclass", "Education", 1000, 2000},
+	{"NordVPN", "Utilities", 399, 1299},
+	{"Ring Protect", "Utilities", 499, 1999},
+}
+
+// Made-up brand names are a prefix and a suffix, such as "Nimbus Stream",
+// the suffix deciding the category.
+var (
+	namePrefixes = []string{
+		"Acme", "Apex", "Aurora", "Blue", "Bright", "Cedar", "Comet", "Coral", "Echo", "Ember",
+		"Falcon", "Granite", "Harbor", "Juniper", "Lumen", "Maple", "Nimbus", "Nova", "Orbit", "Pixel",
+		"Quartz", "Sable", "Summit", "Tandem", "Vertex",
+	}
+	nameSuffixes = []struct {
+		name     string
+		category string
+		min, max models.Money
+	}{
+		{"Stream", "Entertainment", 499, 1999},
+		{"Play", "Gaming", 499, 1499},
+		{"Tunes", "Music", 499, 1499},
+		{"Reads", "Books", 499, 1499},
+		{"Labs", "Software", 500, 3000},
+		{"Notes", "Software", 300, 1200},
+		{"Mail", "Software", 200, 900},
+		{"Cloud", "Cloud", 100, 2000},
+		{"Drive", "Cloud", 199, 1499},
+		{"Daily", "News", 300, 1900},
+		{"Fit", "Fitness", 799, 3999},
+		{"Eats", "Food", 999, 9999},
+		{"Academy", "Education", 999, 2999},
+		{"Guard", "Utilities", 299, 1499},
+	}
+	plans = []string{"", "", "", " Plus", " Pro", " Family", " Premium"}
+)
+
+var tagPool = []string{"family", "work", "personal", "shared", "tax-deductible", "review"}
+
+// cycle is a billing cycle Random picks, with how many months one lasts,
+// how often in a hundred it's picked and how far ahead the next billing
+// date can fall.
+type cycle struct {
+	name      string
+	months    float64
+	weight    int
+	daysAhead int
+}
+
+var cycles = []cycle{
+	{"weekly", 12.0 / 52, 3, 7},
+	{"monthly", 1, 70, 31},
+	{"quarterly", 3, 7, 91},
+	{"yearly", 12, 20, 365},
+}
+
+// Random makes up n subscriptions that look like a real account's: the
+// well-known services first, then made-up brands, with plausible costs for
+// their billing cycle, next billing dates staggered over the cycle and a
+// mix of tags, trials and paused and cancelled subscriptions. The same r
+// seed gives the same subscriptions.
+func Random(r *rand.Rand, n int, today models.Date) []models.Subscription {
+	order := r.Perm(len(services))
+	taken := map[string]bool{}
+	subs := make([]models.Subscription, 0, n)
+	for i := range n {
+		var svc service
+		if i < len(services) {
+			svc = services[order[i]]
+		} else {
+			svc = madeUpService(r, taken)
+		}
+		taken[svc.name] = true
+		subs = append(subs, randomSubscription(r, svc, today))
+	}
+	return subs
+}
+
+// madeUpService invents a brand not yet in taken. Once the combinations
+// run out, names get a number.
+func madeUpService(r *rand.Rand, taken map[string]bool) service {
+	for attempt := 0; ; attempt++ {
+		suffix := nameSuffixes[r.IntN(len(nameSuffixes))]
+		name := namePrefixes[r.IntN(len(namePrefixes))] + " " + suffix.name + plans[r.IntN(len(plans))]
+		if attempt > 20 {
+			name = fmt.Sprintf("%s %d", name, r.IntN(10000))
+		}
+		if !taken[name] {
+			return service{name, suffix.category, suffix.min, suffix.max}
+		}
+	}
+}
+
+func randomSubscription(r *rand.Rand, svc service, today models.Date) models.Subscription {
+	c := pickCycle(r)
+	monthly := svc.min
+	if svc.max > svc.min {
+		monthly += models.Money(r.Int64N(int64(svc.max - svc.min + 1)))
+	}
+	s := models.Subscription{
+		Name:        svc.name,
+		Category:    svc.category,
+		Cost:        price(models.Money(float64(monthly) * c.months)),
+		NextBilling: today.AddDate(0, 0, 1+r.IntN(c.daysAhead)),
+	}
+	s.BillingCycle = cycleFrom(c.name, s.NextBilling)
+	for _, tag := range tagPool {
+		if r.IntN(8) == 0 {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	switch p := r.IntN(100); {
+	case p < 5:
+		s.IsTrial, s.TrialEndsAt = true, dateString(today.AddDate(0, 0, 1+r.IntN(30)))
+	case p < 12:
+		s.Status = models.StatusPaused
+	case p < 20:
+		s.Status = models.StatusCancelled
+		reason := cancellationReasons[r.IntN(len(cancellationReasons))]
+		s.CancelledAt, s.CancellationReason = dateString(today.AddDate(0, 0, -1-r.IntN(180))), &reason
+	}
+	return s
+}
+
+func pickCycle(r *rand.Rand) cycle {
+	pick := r.IntN(100)
+	for _, c := range cycles {
+		if pick < c.weight {
+			return c
+		}
+		pick -= c.weight
+	}
+	return cycles[len(cycles)-1]
+}
+
+var cancellationReasons = []string{"Too expensive", "Not using it", "Switched to another service", "Only needed it for a month"}
+
+// price rounds a cost to how prices are usually set: whole amounts, or
+// ending in .49 or .99.
+func price(m models.Money) models.Money {
+	if m < 100 {
+		return 99
+	}
+	switch whole := m / 100 * 100; {
+	case m-whole < 25:
+		return whole
+	case m-whole < 75:
+		return whole + 49
+	default:
+		return whole + 99
+	}
+}
@@ -0,0 +1,99 @@
+// Package seed makes up subscriptions to fill an account with: a fixed demo
+// set that shows off every feature, or any number of random but plausible
+// ones for load testing and demos. It only builds the values; storing them
+// is up to the caller.
+package seed
+
+import "subscription-tracker/pkg/models"
+
+// Budget is a monthly budget, overall when Category is empty.
+type Budget struct {
+	Category string
+	Amount   models.Money
+}
+
+// Budgets are the demo account's budgets.
+var Budgets = []Budget{
+	{"", 25000},
+	{"Entertainment", 4000},
+	{"Software", 6000},
+}
+
+// demo is one subscription of the demo set. Dates are days from today, so
+// the data looks current whenever it's loaded.
+type demo struct {
+	name, category, description string
+	cost                        models.Money
+	cycle                       string
+	nextInDays                  int
+	tags                        []string
+	status                      string
+	// trialDays, when set, makes it a trial ending that many days from
+	// today.
+	trialDays int
+	// cancelledDaysAgo and reason describe a cancelled subscription.
+	cancelledDaysAgo int
+	reason           string
+}
+
+var demoSet = []demo{
+	{name: "Netflix", category: "Entertainment", description: "Standard plan", cost: 1549, cycle: "monthly", nextInDays: 3, tags: []string{"family"}},
+	{name: "Disney+", category: "Entertainment", cost: 1399, cycle: "monthly", nextInDays: 17, tags: []string{"family"}},
+	{name: "YouTube Premium", category: "Entertainment", cost: 1399, cycle: "monthly", nextInDays: 24},
+	{name: "Spotify", category: "Music", description: "Duo", cost: 1499, cycle: "monthly", nextInDays: 9, tags: []string{"family"}},
+	{name: "GitHub", category: "Software", description: "Pro", cost: 400, cycle: "monthly", nextInDays: 14, tags: []string{"work"}},
+	{name: "JetBrains All Products", category: "Software", cost: 28900, cycle: "yearly", nextInDays: 131, tags: []string{"work"}},
+	{name: "1Password", category: "Software", description: "Families", cost: 5988, cycle: "yearly", nextInDays: 203, tags: []string{"family"}},
+	{name: "iCloud+", category: "Cloud", description: "200 GB", cost: 299, cycle: "monthly", nextInDays: 6},
+	{name: "Backblaze", category: "Cloud", description: "Computer backup", cost: 9900, cycle: "yearly", nextInDays: 58},
+	{name: "The Guardian", category: "News", cost: 1200, cycle: "monthly", nextInDays: 21},
+	{name: "The Economist", category: "News", cost: 8900, cycle: "quarterly", nextInDays: 40},
+	{name: "Gym membership", category: "Fitness", cost: 4500, cycle: "monthly", nextInDays: 1},
+	{name: "Strava", category: "Fitness", cost: 7999, cycle: "yearly", nextInDays: 12, trialDays: 12},
+	{name: "Headspace", category: "Fitness", cost: 1299, cycle: "monthly", nextInDays: 30, status: models.StatusPaused},
+	{name: "Hulu", category: "Entertainment", cost: 799, cycle: "monthly", nextInDays: 11, status: models.StatusCancelled, cancelledDaysAgo: 19, reason: "Not watching it"},
+	{name: "Grammarly", category: "Software", cost: 1200, cycle: "monthly", nextInDays: 5, tags: []string{"work"}},
+}
+
+// Demo returns the demo set: sixteen subscriptions across six categories
+// with renewals spread over the coming year, a trial, a paused and a
+// cancelled subscription, and tags. Costs are in the default currency, so
+// the stats work without exchange rates.
+func Demo(today models.Date) []models.Subscription {
+	subs := make([]models.Subscription, 0, len(demoSet))
+	for _, d := range demoSet {
+		s := models.Subscription{
+			Name:         d.name,
+			Category:     d.category,
+			Cost:         d.cost,
+			BillingCycle: cycleFrom(d.cycle, today.AddDate(0, 0, d.nextInDays)),
+			NextBilling:  today.AddDate(0, 0, d.nextInDays),
+			Description:  d.description,
+			Tags:         d.tags,
+			Status:       d.status,
+		}
+		if d.trialDays > 0 {
+			s.IsTrial, s.TrialEndsAt = true, dateString(today.AddDate(0, 0, d.trialDays))
+		}
+		if d.status == models.StatusCancelled {
+			s.CancelledAt, s.CancellationReason = dateString(today.AddDate(0, 0, -d.cancelledDaysAgo)), &d.reason
+		}
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// cycleFrom is cycle as the API would store it for a subscription next
+// billed on next, anchored to next's day if that's past the 28th.
+func cycleFrom(cycle string, next models.Date) string {
+	r, err := models.ParseRecurrence(cycle)
+	if err != nil {
+		return cycle
+	}
+	return r.StartingOn(next).String()
+}
+
+func dateString(d models.Date) *string {
+	s := d.String()
+	return &s
+}
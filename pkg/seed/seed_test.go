@@ -0,0 +1,69 @@
+package seed
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+var today = models.DateOf(time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC))
+
+func TestRandom(t *testing.T) {
+	const n = 500
+	subs := Random(rand.New(rand.NewPCG(1, 0)), n, today)
+	if len(subs) != n {
+		t.Fatalf("got %d subscriptions, want %d", len(subs), n)
+	}
+	if again := Random(rand.New(rand.NewPCG(1, 0)), n, today); !reflect.DeepEqual(subs, again) {
+		t.Error("the same seed made different subscriptions")
+	}
+	if other := Random(rand.New(rand.NewPCG(2, 0)), n, today); reflect.DeepEqual(subs, other) {
+		t.Error("different seeds made the same subscriptions")
+	}
+
+	names := map[string]bool{}
+	statuses := map[string]int{}
+	for _, s := range subs {
+		if names[s.Name] {
+			t.Errorf("name %q made up twice", s.Name)
+		}
+		names[s.Name] = true
+		statuses[s.Status]++
+		if s.Category == "" || s.Cost < 99 {
+			t.Errorf("%s: category %q, cost %s", s.Name, s.Category, s.Cost)
+		}
+		if !s.NextBilling.After(today) || s.NextBilling.After(today.AddDate(1, 0, 0)) {
+			t.Errorf("%s: next billing %s isn't within a year", s.Name, s.NextBilling)
+		}
+		if _, err := models.ParseRecurrence(s.BillingCycle); err != nil {
+			t.Errorf("%s: billing cycle %q: %v", s.Name, s.BillingCycle, err)
+		}
+		if (s.Status == models.StatusCancelled) != (s.CancelledAt != nil) {
+			t.Errorf("%s: status %q but cancelled at %v", s.Name, s.Status, s.CancelledAt)
+		}
+	}
+	if statuses[models.StatusPaused] == 0 || statuses[models.StatusCancelled] == 0 {
+		t.Errorf("statuses = %v, want some paused and cancelled", statuses)
+	}
+
+	if subs := Random(rand.New(rand.NewPCG(1, 0)), 0, today); len(subs) != 0 {
+		t.Errorf("Random with n 0 made %d subscriptions", len(subs))
+	}
+}
+
+func TestPrice(t *testing.T) {
+	for m, want := range map[models.Money]models.Money{
+		10:   99,
+		1000: 1000,
+		1024: 1000,
+		1050: 1049,
+		1080: 1099,
+	} {
+		if got := price(m); got != want {
+			t.Errorf("price(%d) = %d, want %d", m, got, want)
+		}
+	}
+}
@@ -0,0 +1,768 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// BackupVersion is the version of the Backup format ReadBackup writes and
+// Restore reads.
+const BackupVersion = 1
+
+// Backup is everything an account holds that can move with it to another
+// instance: its settings, tags, subscriptions with their reminders,
+// cancellation info and billing and price history, budgets, transactions,
+// alerts and webhooks. IDs are the source instance's; Restore uses them
+// only to reconnect transactions and alerts to their subscriptions.
+// Sessions, two-factor settings, households, the audit log, logged uses
+// and API usage stay behind.
+type Backup struct {
+	Version       int                  `json:"version"`
+	ExportedAt    string               `json:"exportedAt"`
+	Currency      string               `json:"currency"`
+	Tags          []string             `json:"tags"`
+	Subscriptions []BackupSubscription `json:"subscriptions"`
+	Budgets       []models.Budget      `json:"budgets"`
+	Transactions  []models.Transaction `json:"transactions"`
+	Alerts        []BackupAlert        `json:"alerts"`
+	// Webhooks carry their signing secrets, so a restored webhook still
+	// verifies at its receiver.
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+// BackupSubscription is a subscription with what hangs off it.
+// ReminderDaysBefore is nil when it has no reminder, and Cancellation when
+// nothing is set on how to cancel it.
+type BackupSubscription struct {
+	models.Subscription
+	ReminderDaysBefore *int                     `json:"reminderDaysBefore"`
+	Cancellation       *models.CancellationInfo `json:"cancellation,omitempty"`
+	History            []models.BillingEvent    `json:"history"`
+	PriceHistory       []models.PriceChange     `json:"priceHistory"`
+}
+
+// BackupAlert is an alert with the key that keeps it from being raised
+// twice.
+type BackupAlert struct {
+	models.Alert
+	DedupeKey string `json:"dedupeKey"`
+}
+
+// ReadBackup reads the user's data in one transaction, so the backup is
+// consistent even while the account is in use.
+func ReadBackup(ctx context.Context, db *sql.DB, userID int, at time.Time) (Backup, error) {
+	b := Backup{
+		Version:       BackupVersion,
+		ExportedAt:    formatTime(at.UTC()),
+		Tags:          []string{},
+		Subscriptions: []BackupSubscription{},
+		Budgets:       []models.Budget{},
+		Transactions:  []models.Transaction{},
+		Alerts:        []BackupAlert{},
+		Webhooks:      []models.Webhook{},
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, "SELECT currency FROM users WHERE id = $1", userID).Scan(&b.Currency); err != nil {
+		return b, err
+	}
+	if err := eachRow(ctx, tx, "SELECT name FROM tags WHERE user_id = $1 ORDER BY name", []any{userID}, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		b.Tags = append(b.Tags, name)
+		return nil
+	}); err != nil {
+		return b, err
+	}
+	if b.Subscriptions, err = backupSubscriptions(ctx, tx, userID); err != nil {
+		return b, err
+	}
+
+	if err := eachRow(ctx, tx, "SELECT id, category, amount_cents, currency FROM budgets WHERE user_id = $1 ORDER BY id", []any{userID}, func(rows *sql.Rows) error {
+		var budget models.Budget
+		var category string
+		if err := rows.Scan(&budget.ID, &category, &budget.Amount, &budget.Currency); err != nil {
+			return err
+		}
+		if category != "" {
+			budget.Category = &category
+		}
+		b.Budgets = append(b.Budgets, budget)
+		return nil
+	}); err != nil {
+		return b, err
+	}
+	if err := eachRow(ctx, tx, `
+		SELECT id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status
+		FROM transactions WHERE user_id = $1 ORDER BY id
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var t models.Transaction
+		var posted time.Time
+		var sub sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.Source, &t.ExternalID, &t.Description, &t.Amount, &posted, &sub, &t.MatchStatus); err != nil {
+			return err
+		}
+		t.Date, t.SubscriptionID = posted.Format(time.DateOnly), nullInt(sub)
+		b.Transactions = append(b.Transactions, t)
+		return nil
+	}); err != nil {
+		return b, err
+	}
+	if err := eachRow(ctx, tx, `
+		SELECT id, kind, message, subscription_id, transaction_id, dedupe_key, dismissed, created_at
+		FROM alerts WHERE user_id = $1 ORDER BY id
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var al BackupAlert
+		var sub, txn sql.NullInt64
+		var created time.Time
+		if err := rows.Scan(&al.ID, &al.Kind, &al.Message, &sub, &txn, &al.DedupeKey, &al.Dismissed, &created); err != nil {
+			return err
+		}
+		al.SubscriptionID, al.TransactionID, al.CreatedAt = nullInt(sub), nullInt(txn), formatTime(created)
+		b.Alerts = append(b.Alerts, al)
+		return nil
+	}); err != nil {
+		return b, err
+	}
+	if err := eachRow(ctx, tx, "SELECT id, url, secret, events, created_at FROM webhooks WHERE user_id = $1 ORDER BY id", []any{userID}, func(rows *sql.Rows) error {
+		var hook models.Webhook
+		var events string
+		var created time.Time
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &events, &created); err != nil {
+			return err
+		}
+		hook.Events, hook.CreatedAt = strings.Split(events, ","), formatTime(created)
+		b.Webhooks = append(b.Webhooks, hook)
+		return nil
+	}); err != nil {
+		return b, err
+	}
+	return b, tx.Commit()
+}
+
+// backupSubscriptions reads the user's subscriptions, oldest first, with
+// their tags, reminders, cancellation info and histories.
+func backupSubscriptions(ctx context.Context, tx *sql.Tx, userID int) ([]BackupSubscription, error) {
+	subs := []BackupSubscription{}
+	byID := map[int]*BackupSubscription{}
+	if err := eachRow(ctx, tx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id = $1 ORDER BY id", []any{userID}, func(rows *sql.Rows) error {
+		var s BackupSubscription
+		if err := scanSubscription(rows, &s.Subscription); err != nil {
+			return err
+		}
+		s.Tags, s.History, s.PriceHistory = []string{}, []models.BillingEvent{}, []models.PriceChange{}
+		subs = append(subs, s)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i := range subs {
+		byID[subs[i].ID] = &subs[i]
+	}
+
+	err := eachRow(ctx, tx, `
+		SELECT st.subscription_id, t.name
+		FROM subscription_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE t.user_id = $1 ORDER BY t.name
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if s := byID[id]; s != nil {
+			s.Tags = append(s.Tags, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(ctx, tx, "SELECT subscription_id, days_before FROM reminders WHERE user_id = $1", []any{userID}, func(rows *sql.Rows) error {
+		var id, days int
+		if err := rows.Scan(&id, &days); err != nil {
+			return err
+		}
+		if s := byID[id]; s != nil {
+			s.ReminderDaysBefore = &days
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(ctx, tx, "SELECT subscription_id, url, instructions, remind_before_renewal FROM cancellation_info WHERE user_id = $1", []any{userID}, func(rows *sql.Rows) error {
+		var id int
+		var info models.CancellationInfo
+		if err := rows.Scan(&id, &info.URL, &info.Instructions, &info.RemindBeforeRenewal); err != nil {
+			return err
+		}
+		if s := byID[id]; s != nil {
+			s.Cancellation = &info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(ctx, tx, `
+		SELECT `+billingEventColumns+`
+		FROM billing_history
+		WHERE subscription_id IN (SELECT id FROM subscriptions WHERE user_id = $1)
+		ORDER BY billed_on DESC
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var e models.BillingEvent
+		if err := scanBillingEvent(rows, &e); err != nil {
+			return err
+		}
+		if s := byID[e.SubscriptionID]; s != nil {
+			s.History = append(s.History, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(ctx, tx, `
+		SELECT id, subscription_id, old_cost_cents, old_currency, new_cost_cents, new_currency, changed_at
+		FROM price_history WHERE user_id = $1 ORDER BY changed_at
+	`, []any{userID}, func(rows *sql.Rows) error {
+		var c models.PriceChange
+		var id int
+		var changed time.Time
+		if err := rows.Scan(&c.ID, &id, &c.OldCost, &c.OldCurrency, &c.NewCost, &c.NewCurrency, &changed); err != nil {
+			return err
+		}
+		c.ChangedAt = formatTime(changed)
+		if s := byID[id]; s != nil {
+			s.PriceHistory = append(s.PriceHistory, c)
+		}
+		return nil
+	})
+	return subs, err
+}
+
+// Restore conflict strategies: what Restore does with an item the account
+// already has. Subscriptions are the same when their names match ignoring
+// case, budgets when their categories do, transactions by source and
+// external ID, alerts by dedupe key and webhooks by URL.
+const (
+	// RestoreSkip keeps the existing item.
+	RestoreSkip = "skip"
+	// RestoreOverwrite replaces it with the backup's, including a
+	// subscription's tags, reminder, cancellation info and histories.
+	RestoreOverwrite = "overwrite"
+	// RestoreMerge keeps whichever copy of a subscription changed more
+	// recently and combines their tags and histories, adds the backup's
+	// reminder and cancellation info if the subscription has none, keeps an alert dismissed if
+	// either copy is, links a transaction the existing copy left
+	// unmatched, and adds a webhook's missing events. Budgets are kept.
+	RestoreMerge = "merge"
+)
+
+// RestoreStrategies lists the conflict strategies.
+var RestoreStrategies = []string{RestoreSkip, RestoreOverwrite, RestoreMerge}
+
+// RestoreCounts says how many items of one kind Restore created, changed
+// and left alone because the account already had them.
+type RestoreCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// RestoreResult reports what Restore did with each kind of item.
+type RestoreResult struct {
+	Tags          RestoreCounts `json:"tags"`
+	Subscriptions RestoreCounts `json:"subscriptions"`
+	Budgets       RestoreCounts `json:"budgets"`
+	Transactions  RestoreCounts `json:"transactions"`
+	Alerts        RestoreCounts `json:"alerts"`
+	Webhooks      RestoreCounts `json:"webhooks"`
+}
+
+// Restore replays b into the user's account with the given conflict
+// strategy, all or nothing. The account's currency is only replaced when
+// overwriting. Rows the backup has no timestamp for get at. check, if not
+// nil, sees the result before it's committed and can veto it by returning
+// an error, which Restore returns.
+func Restore(ctx context.Context, db *sql.DB, userID int, b Backup, strategy string, at time.Time, check func(RestoreResult) error) (RestoreResult, error) {
+	var res RestoreResult
+	if !slices.Contains(RestoreStrategies, strategy) {
+		return res, fmt.Errorf("unknown restore strategy %q", strategy)
+	}
+	err := InTx(ctx, db, func(tx *sql.Tx) error {
+		r := restorer{ctx: ctx, tx: tx, userID: userID, strategy: strategy, at: at.UTC(), subIDs: map[int]int{}, txnIDs: map[int]int{}}
+		if strategy == RestoreOverwrite && b.Currency != "" {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET currency = $1 WHERE id = $2", b.Currency, userID); err != nil {
+				return err
+			}
+		}
+		steps := []func(Backup, *RestoreCounts) error{r.tags, r.subscriptions, r.budgets, r.transactions, r.alerts, r.webhooks}
+		counts := []*RestoreCounts{&res.Tags, &res.Subscriptions, &res.Budgets, &res.Transactions, &res.Alerts, &res.Webhooks}
+		for i, step := range steps {
+			if err := step(b, counts[i]); err != nil {
+				return err
+			}
+		}
+		if check != nil {
+			return check(res)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// restorer carries one Restore's transaction and the IDs the backup's
+// subscriptions and transactions got, to reconnect what refers to them.
+type restorer struct {
+	ctx      context.Context
+	tx       *sql.Tx
+	userID   int
+	strategy string
+	at       time.Time
+	subIDs   map[int]int
+	txnIDs   map[int]int
+}
+
+func (r restorer) tags(b Backup, n *RestoreCounts) error {
+	for _, name := range b.Tags {
+		res, err := r.tx.ExecContext(r.ctx, "INSERT INTO tags (user_id, name) VALUES ($1, $2) ON CONFLICT (user_id, name) DO NOTHING", r.userID, NormalizeTag(name))
+		if err != nil {
+			return err
+		}
+		if added, _ := res.RowsAffected(); added > 0 {
+			n.Created++
+		} else {
+			n.Skipped++
+		}
+	}
+	return nil
+}
+
+func (r restorer) subscriptions(b Backup, n *RestoreCounts) error {
+	// Existing subscriptions by lower-cased name, each matched at most
+	// once, so two backed-up subscriptions with one name pair up with two
+	// existing ones.
+	existing := map[string][]models.Subscription{}
+	if err := eachRow(r.ctx, r.tx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id = $1 ORDER BY id", []any{r.userID}, func(rows *sql.Rows) error {
+		var s models.Subscription
+		if err := scanSubscription(rows, &s); err != nil {
+			return err
+		}
+		key := strings.ToLower(strings.TrimSpace(s.Name))
+		existing[key] = append(existing[key], s)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, s := range b.Subscriptions {
+		s.Subscription = withDefaults(s.Subscription)
+		key := strings.ToLower(strings.TrimSpace(s.Name))
+		if len(existing[key]) == 0 {
+			id, err := r.insertSubscription(s)
+			if err != nil {
+				return fmt.Errorf("subscription %q: %w", s.Name, err)
+			}
+			r.subIDs[s.ID] = id
+			n.Created++
+			continue
+		}
+		old := existing[key][0]
+		existing[key] = existing[key][1:]
+		r.subIDs[s.ID] = old.ID
+
+		var err error
+		switch r.strategy {
+		case RestoreSkip:
+			n.Skipped++
+			continue
+		case RestoreOverwrite:
+			err = r.overwriteSubscription(old.ID, s)
+		case RestoreMerge:
+			err = r.mergeSubscription(old, s)
+		}
+		if err != nil {
+			return fmt.Errorf("subscription %q: %w", s.Name, err)
+		}
+		n.Updated++
+	}
+	return nil
+}
+
+// restoredTimes are a backed-up subscription's timestamps, falling back to
+// now for any that are missing.
+func restoredTimes(s models.Subscription, now time.Time) (created, updated time.Time, verified, archived *time.Time) {
+	created, updated = parseTimeOr(s.CreatedAt, now), parseTimeOr(s.UpdatedAt, now)
+	if s.LastVerifiedAt != nil {
+		t := parseTimeOr(*s.LastVerifiedAt, now)
+		verified = &t
+	}
+	if s.ArchivedAt != nil {
+		t := parseTimeOr(*s.ArchivedAt, now)
+		archived = &t
+	}
+	return created, updated, verified, archived
+}
+
+func parseTimeOr(v string, def time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	return def
+}
+
+func (r restorer) insertSubscription(s BackupSubscription) (int, error) {
+	created, updated, verified, archived := restoredTimes(s.Subscription, r.at)
+	var id int
+	err := r.tx.QueryRowContext(r.ctx, `
+		INSERT INTO subscriptions (user_id, name, category, cost_cents, currency, billing_cycle, next_billing, description, last_verified_at,
+			is_trial, trial_ends_at, status, cancelled_at, cancellation_reason, archived_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id
+	`, r.userID, s.Name, s.Category, s.Cost, s.Currency, s.BillingCycle, s.NextBilling, s.Description, verified,
+		s.IsTrial, s.TrialEndsAt, s.Status, s.CancelledAt, s.CancellationReason, archived, created, updated).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := setTags(r.ctx, r.tx, r.userID, id, s.Tags); err != nil {
+		return 0, err
+	}
+	return id, r.addExtras(id, s, nil)
+}
+
+// updateSubscription sets the subscription's fields, tags and timestamps
+// to s's.
+func (r restorer) updateSubscription(id int, s BackupSubscription) error {
+	_, updated, verified, archived := restoredTimes(s.Subscription, r.at)
+	if _, err := r.tx.ExecContext(r.ctx, `
+		UPDATE subscriptions
+		SET name = $1, category = $2, cost_cents = $3, currency = $4, billing_cycle = $5, next_billing = $6, description = $7,
+			last_verified_at = $8, is_trial = $9, trial_ends_at = $10, status = $11, cancelled_at = $12, cancellation_reason = $13,
+			archived_at = $14, updated_at = $15, version = version + 1
+		WHERE id = $16 AND user_id = $17
+	`, s.Name, s.Category, s.Cost, s.Currency, s.BillingCycle, s.NextBilling, s.Description,
+		verified, s.IsTrial, s.TrialEndsAt, s.Status, s.CancelledAt, s.CancellationReason, archived, updated, id, r.userID); err != nil {
+		return err
+	}
+	return setTags(r.ctx, r.tx, r.userID, id, s.Tags)
+}
+
+func (r restorer) overwriteSubscription(id int, s BackupSubscription) error {
+	if err := r.updateSubscription(id, s); err != nil {
+		return err
+	}
+	for _, table := range []string{"reminders", "cancellation_info", "billing_history", "price_history"} {
+		if _, err := r.tx.ExecContext(r.ctx, "DELETE FROM "+table+" WHERE subscription_id = $1", id); err != nil {
+			return err
+		}
+	}
+	return r.addExtras(id, s, nil)
+}
+
+func (r restorer) mergeSubscription(old models.Subscription, s BackupSubscription) error {
+	subs := []models.Subscription{old}
+	if err := loadTags(r.ctx, r.tx, r.userID, subs); err != nil {
+		return err
+	}
+	tags := append(slices.Clone(subs[0].Tags), s.Tags...)
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+
+	if parseTimeOr(s.UpdatedAt, time.Time{}).After(parseTimeOr(old.UpdatedAt, time.Time{})) {
+		s.Tags = tags
+		if err := r.updateSubscription(old.ID, s); err != nil {
+			return err
+		}
+	} else if err := setTags(r.ctx, r.tx, r.userID, old.ID, tags); err != nil {
+		return err
+	}
+
+	var hasReminder, hasCancellation bool
+	if err := r.tx.QueryRowContext(r.ctx, `
+		SELECT EXISTS (SELECT 1 FROM reminders WHERE subscription_id = $1), EXISTS (SELECT 1 FROM cancellation_info WHERE subscription_id = $1)
+	`, old.ID).Scan(&hasReminder, &hasCancellation); err != nil {
+		return err
+	}
+	if hasReminder {
+		s.ReminderDaysBefore = nil
+	}
+	if hasCancellation {
+		s.Cancellation = nil
+	}
+	// Price changes already recorded, by when and to what, aren't added
+	// again.
+	seen := map[string]bool{}
+	if err := eachRow(r.ctx, r.tx, "SELECT changed_at, new_cost_cents, new_currency FROM price_history WHERE subscription_id = $1", []any{old.ID}, func(rows *sql.Rows) error {
+		var at time.Time
+		var cost models.Money
+		var currency string
+		if err := rows.Scan(&at, &cost, &currency); err != nil {
+			return err
+		}
+		seen[priceChangeKey(formatTime(at), cost, currency)] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	return r.addExtras(old.ID, s, seen)
+}
+
+func priceChangeKey(at string, cost models.Money, currency string) string {
+	return fmt.Sprintf("%s|%d|%s", at, cost, currency)
+}
+
+// addExtras stores s's reminder, cancellation info, billing history and
+// the price changes not in skipPrices. Billing dates already recorded are
+// left as they are.
+func (r restorer) addExtras(id int, s BackupSubscription, skipPrices map[string]bool) error {
+	if s.ReminderDaysBefore != nil {
+		if _, err := r.tx.ExecContext(r.ctx, "INSERT INTO reminders (subscription_id, user_id, days_before) VALUES ($1, $2, $3)", id, r.userID, *s.ReminderDaysBefore); err != nil {
+			return err
+		}
+	}
+	if c := s.Cancellation; c != nil {
+		if _, err := r.tx.ExecContext(r.ctx, `
+			INSERT INTO cancellation_info (subscription_id, user_id, url, instructions, remind_before_renewal) VALUES ($1, $2, $3, $4, $5)
+		`, id, r.userID, c.URL, c.Instructions, c.RemindBeforeRenewal); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.History {
+		billed, err := models.ParseDate(e.Date)
+		if err != nil {
+			return fmt.Errorf("billing history: %w", err)
+		}
+		// Backups from before events kept their currency were billed in
+		// the subscription's.
+		currency := cmp.Or(e.Currency, s.Currency)
+		if e.Rate == nil {
+			e.RecordCurrency = ""
+		}
+		if _, err := r.tx.ExecContext(r.ctx, `
+			INSERT INTO billing_history (subscription_id, user_id, billed_on, amount_cents, currency, record_currency, rate, recorded_at)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+			ON CONFLICT (subscription_id, billed_on) DO NOTHING
+		`, id, r.userID, billed, e.Amount, currency, e.RecordCurrency, e.Rate, parseTimeOr(e.RecordedAt, r.at)); err != nil {
+			return err
+		}
+	}
+	for _, c := range s.PriceHistory {
+		changed := parseTimeOr(c.ChangedAt, r.at)
+		if skipPrices[priceChangeKey(formatTime(changed), c.NewCost, c.NewCurrency)] {
+			continue
+		}
+		if _, err := r.tx.ExecContext(r.ctx, `
+			INSERT INTO price_history (subscription_id, user_id, old_cost_cents, old_currency, new_cost_cents, new_currency, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, id, r.userID, c.OldCost, c.OldCurrency, c.NewCost, c.NewCurrency, changed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r restorer) budgets(b Backup, n *RestoreCounts) error {
+	for _, budget := range b.Budgets {
+		category := ""
+		if budget.Category != nil {
+			category = *budget.Category
+		}
+		var id int
+		err := r.tx.QueryRowContext(r.ctx, "SELECT id FROM budgets WHERE user_id = $1 AND category = $2", r.userID, category).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := r.tx.ExecContext(r.ctx, `
+				INSERT INTO budgets (user_id, category, amount_cents, currency, created_at) VALUES ($1, $2, $3, $4, $5)
+			`, r.userID, category, budget.Amount, budget.Currency, r.at); err != nil {
+				return err
+			}
+			n.Created++
+		case err != nil:
+			return err
+		case r.strategy == RestoreOverwrite:
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE budgets SET amount_cents = $1, currency = $2 WHERE id = $3", budget.Amount, budget.Currency, id); err != nil {
+				return err
+			}
+			n.Updated++
+		default:
+			n.Skipped++
+		}
+	}
+	return nil
+}
+
+// mapped returns the restored ID of a backed-up row, or nil if it has none
+// or its row wasn't in the backup.
+func mapped(ids map[int]int, id *int) *int {
+	if id == nil {
+		return nil
+	}
+	if to, ok := ids[*id]; ok {
+		return &to
+	}
+	return nil
+}
+
+func (r restorer) transactions(b Backup, n *RestoreCounts) error {
+	for _, t := range b.Transactions {
+		posted, err := models.ParseDate(t.Date)
+		if err != nil {
+			return fmt.Errorf("transaction %s/%s: %w", t.Source, t.ExternalID, err)
+		}
+		sub := mapped(r.subIDs, t.SubscriptionID)
+		status := t.MatchStatus
+		if sub == nil && status == models.MatchStatusMatched {
+			status = models.MatchStatusUnmatched
+		}
+
+		var id int
+		var oldSub sql.NullInt64
+		err = r.tx.QueryRowContext(r.ctx, "SELECT id, subscription_id FROM transactions WHERE user_id = $1 AND source = $2 AND external_id = $3",
+			r.userID, t.Source, t.ExternalID).Scan(&id, &oldSub)
+		switch {
+		case err == sql.ErrNoRows:
+			err = r.tx.QueryRowContext(r.ctx, `
+				INSERT INTO transactions (user_id, source, external_id, description, amount_cents, posted_on, subscription_id, match_status)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				RETURNING id
+			`, r.userID, t.Source, t.ExternalID, t.Description, t.Amount, posted, sub, status).Scan(&id)
+			if err != nil {
+				return err
+			}
+			n.Created++
+		case err != nil:
+			return err
+		case r.strategy == RestoreOverwrite:
+			if _, err := r.tx.ExecContext(r.ctx, `
+				UPDATE transactions SET description = $1, amount_cents = $2, posted_on = $3, subscription_id = $4, match_status = $5
+				WHERE id = $6
+			`, t.Description, t.Amount, posted, sub, status, id); err != nil {
+				return err
+			}
+			n.Updated++
+		case r.strategy == RestoreMerge && !oldSub.Valid && sub != nil:
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE transactions SET subscription_id = $1, match_status = $2 WHERE id = $3", *sub, status, id); err != nil {
+				return err
+			}
+			n.Updated++
+		default:
+			n.Skipped++
+		}
+		r.txnIDs[t.ID] = id
+	}
+	return nil
+}
+
+func (r restorer) alerts(b Backup, n *RestoreCounts) error {
+	for _, al := range b.Alerts {
+		var id int
+		var dismissed bool
+		err := r.tx.QueryRowContext(r.ctx, "SELECT id, dismissed FROM alerts WHERE user_id = $1 AND dedupe_key = $2", r.userID, al.DedupeKey).Scan(&id, &dismissed)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := r.tx.ExecContext(r.ctx, `
+				INSERT INTO alerts (user_id, kind, message, subscription_id, transaction_id, dedupe_key, dismissed, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`, r.userID, al.Kind, al.Message, mapped(r.subIDs, al.SubscriptionID), mapped(r.txnIDs, al.TransactionID), al.DedupeKey, al.Dismissed,
+				parseTimeOr(al.CreatedAt, r.at)); err != nil {
+				return err
+			}
+			n.Created++
+		case err != nil:
+			return err
+		case r.strategy == RestoreOverwrite:
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE alerts SET message = $1, dismissed = $2 WHERE id = $3", al.Message, al.Dismissed, id); err != nil {
+				return err
+			}
+			n.Updated++
+		case r.strategy == RestoreMerge && al.Dismissed && !dismissed:
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE alerts SET dismissed = $1 WHERE id = $2", true, id); err != nil {
+				return err
+			}
+			n.Updated++
+		default:
+			n.Skipped++
+		}
+	}
+	return nil
+}
+
+func (r restorer) webhooks(b Backup, n *RestoreCounts) error {
+	for _, hook := range b.Webhooks {
+		var id int
+		var events string
+		err := r.tx.QueryRowContext(r.ctx, "SELECT id, events FROM webhooks WHERE user_id = $1 AND url = $2 ORDER BY id", r.userID, hook.URL).Scan(&id, &events)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := r.tx.ExecContext(r.ctx, `
+				INSERT INTO webhooks (user_id, url, secret, events, created_at) VALUES ($1, $2, $3, $4, $5)
+			`, r.userID, hook.URL, hook.Secret, strings.Join(hook.Events, ","), parseTimeOr(hook.CreatedAt, r.at)); err != nil {
+				return err
+			}
+			n.Created++
+		case err != nil:
+			return err
+		case r.strategy == RestoreOverwrite:
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE webhooks SET secret = $1, events = $2 WHERE id = $3", hook.Secret, strings.Join(hook.Events, ","), id); err != nil {
+				return err
+			}
+			n.Updated++
+		case r.strategy == RestoreMerge:
+			merged := strings.Split(events, ",")
+			for _, e := range hook.Events {
+				if !slices.Contains(merged, e) {
+					merged = append(merged, e)
+				}
+			}
+			if len(merged) == len(strings.Split(events, ",")) {
+				n.Skipped++
+				continue
+			}
+			if _, err := r.tx.ExecContext(r.ctx, "UPDATE webhooks SET events = $1 WHERE id = $2", strings.Join(merged, ","), id); err != nil {
+				return err
+			}
+			n.Updated++
+		default:
+			n.Skipped++
+		}
+	}
+	return nil
+}
+
+// eachRow runs a query in tx and calls fn for each row.
+func eachRow(ctx context.Context, tx *sql.Tx, query string, args []any, fn func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func nullInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
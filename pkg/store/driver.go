@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"modernc.org/sqlite"
+)
+
+// Driver names a supported database engine.
+type Driver string
+
+const (
+	Postgres Driver = "postgres"
+	// SQLite keeps everything in one local file, for demos and local use
+	// without a Postgres server.
+	SQLite Driver = "sqlite"
+)
+
+// ParseDriver validates a driver name from configuration.
+func ParseDriver(name string) (Driver, error) {
+	switch d := Driver(strings.ToLower(name)); d {
+	case Postgres, SQLite:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown database driver %q (want postgres or sqlite)", name)
+}
+
+// sqlitePragmas are applied to every SQLite connection: the schema relies on
+// foreign key cascades, which SQLite leaves off by default, and a busy
+// timeout lets concurrent requests wait for the write lock instead of failing.
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+// sqlDriver is the database/sql driver each engine is opened with. Postgres
+// goes through pgx, which reads dates and timestamps as times and numerics
+// as exact decimals; Open uses its connector directly, wrapped to scope
+// transactions to a user.
+var sqlDriver = map[Driver]string{
+	Postgres: "pgx",
+	SQLite:   "sqlite",
+}
+
+var dbSystem = map[Driver]attribute.KeyValue{
+	Postgres: semconv.DBSystemNamePostgreSQL,
+	SQLite:   semconv.DBSystemNameSQLite,
+}
+
+// opened records the engine behind each database Open returned. The
+// tracing wrapper hides the driver's type, so DriverOf can't tell from it.
+var opened sync.Map
+
+// Open connects to the database and checks the connection. For SQLite the
+// DSN is a file path, or file: URI, and is created if missing.
+//
+// Queries are traced through the global OpenTelemetry tracer provider, as
+// children of the span in their context. Queries made outside a trace,
+// such as background jobs, don't start traces of their own.
+func Open(driver Driver, dsn string) (*sql.DB, error) {
+	return open(context.Background(), driver, dsn)
+}
+
+func open(ctx context.Context, driver Driver, dsn string) (*sql.DB, error) {
+	if driver == SQLite {
+		if !strings.HasPrefix(dsn, "file:") {
+			dsn = "file:" + dsn
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + sqlitePragmas
+		} else {
+			dsn += "?" + sqlitePragmas
+		}
+	}
+
+	options := []otelsql.Option{
+		otelsql.WithAttributes(dbSystem[driver]),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter:           inTrace,
+		}),
+	}
+	var db *sql.DB
+	if driver == Postgres {
+		// Postgres transactions are scoped to the user, tenant or system
+		// role in their context; see WithUser.
+		config, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+		connector := &scopedConnector{Connector: stdlib.GetConnector(*config)}
+		db = otelsql.OpenDB(connector, options...)
+		connectors.Store(db, connector)
+	} else {
+		var err error
+		if db, err = otelsql.Open(sqlDriver[driver], dsn, options...); err != nil {
+			return nil, err
+		}
+	}
+	opened.Store(db, driver)
+	if driver == SQLite {
+		// SQLite allows one writer at a time; a single connection turns
+		// lock contention into queueing in database/sql.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Connect backoff: the first retry comes after connectRetryMin, and each
+// wait doubles up to connectRetryMax.
+const (
+	connectRetryMin = 500 * time.Millisecond
+	connectRetryMax = 10 * time.Second
+)
+
+// Connect is Open, retried with backoff for as long as the database can't
+// be reached or is still starting up, as when it's started alongside the
+// server, until ctx is done. Anything else, such as a wrong password or a
+// missing database, fails at once.
+func Connect(ctx context.Context, driver Driver, dsn string) (*sql.DB, error) {
+	wait := connectRetryMin
+	for attempt := 1; ; attempt++ {
+		db, err := open(ctx, driver, dsn)
+		if err == nil || !transient(err) {
+			return db, err
+		}
+		slog.Warn("database not reachable yet; retrying", "attempt", attempt, "retry_in", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+		wait = min(2*wait, connectRetryMax)
+	}
+}
+
+// transient reports whether a connection error may clear up by itself:
+// the server isn't listening or resolvable yet, dropped the connection,
+// is starting up or has no connections to spare.
+func transient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P03", "53300": // cannot_connect_now, too_many_connections
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, driver.ErrBadConn)
+}
+
+// Pool sizes a database's connection pool. Zero fields keep database/sql's
+// defaults.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Configure applies p to db. SQLite keeps the single connection Open gives
+// it whatever MaxOpenConns says.
+func (p Pool) Configure(db *sql.DB) {
+	if p.MaxOpenConns > 0 && DriverOf(db) != SQLite {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+}
+
+// Size reports how much space the database takes, in bytes: the whole
+// Postgres database, or the pages of the SQLite file.
+func Size(ctx context.Context, db *sql.DB) (int64, error) {
+	query := "SELECT pg_database_size(current_database())"
+	if DriverOf(db) == SQLite {
+		query = "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	}
+	var size int64
+	err := db.QueryRowContext(ctx, query).Scan(&size)
+	return size, err
+}
+
+func inTrace(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// DriverOf reports which engine db is connected to.
+func DriverOf(db *sql.DB) Driver {
+	if d, ok := opened.Load(db); ok {
+		return d.(Driver)
+	}
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		return SQLite
+	}
+	if _, ok := db.Driver().(*stdlib.Driver); !ok {
+		panic(fmt.Sprintf("store: unsupported database driver %T", db.Driver()))
+	}
+	return Postgres
+}
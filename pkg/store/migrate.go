@@ -0,0 +1,301 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<driver>/ as NNNN_name.up.sql with a
+// matching NNNN_name.down.sql. Every driver has the same list of versions,
+// written in its own dialect. Versions must be unique; they're applied in
+// order and each one runs in its own transaction.
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// Migration is one embedded schema change.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// migrations is every embedded migration for each driver, oldest first.
+var migrations = mustLoadMigrations()
+
+// SchemaVersion is the newest embedded migration, the revision Init brings
+// the database to.
+var SchemaVersion = migrations[Postgres][len(migrations[Postgres])-1].Version
+
+func mustLoadMigrations() map[Driver][]Migration {
+	sets := map[Driver][]Migration{}
+	for _, driver := range []Driver{Postgres, SQLite} {
+		list, err := loadMigrations(migrationFiles, "migrations/"+string(driver))
+		if err != nil {
+			panic("store: " + err.Error())
+		}
+		sets[driver] = list
+	}
+	if err := sameVersions(sets[Postgres], sets[SQLite]); err != nil {
+		panic("store: sqlite migrations: " + err.Error())
+	}
+	return sets
+}
+
+// sameVersions checks that two drivers' migration lists line up, so a
+// schema version means the same thing on either engine.
+func sameVersions(want, got []Migration) error {
+	for i := range max(len(want), len(got)) {
+		switch {
+		case i >= len(got):
+			return fmt.Errorf("missing %d_%s", want[i].Version, want[i].Name)
+		case i >= len(want):
+			return fmt.Errorf("unexpected %d_%s", got[i].Version, got[i].Name)
+		case want[i].Version != got[i].Version || want[i].Name != got[i].Name:
+			return fmt.Errorf("have %d_%s where postgres has %d_%s", got[i].Version, got[i].Name, want[i].Version, want[i].Name)
+		}
+	}
+	return nil
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := strings.TrimPrefix(file, dir+"/")
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.up.sql or NNNN_name.down.sql", base)
+		}
+		num, name, _ := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: bad version %q", base, num)
+		}
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	var list []Migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no migrations embedded in %s", dir)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// MigrationStatus reports whether a migration has been applied. AppliedAt
+// is nil for pending migrations.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrationsTable is the bookkeeping table's DDL for each driver.
+var migrationsTable = map[Driver]string{
+	Postgres: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`,
+	SQLite: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`,
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(migrationsTable[DriverOf(db)])
+	return err
+}
+
+// appliedMigrations returns when each recorded version was applied.
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Status lists every embedded migration and whether it has been applied.
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, m := range migrations[DriverOf(db)] {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// AppliedVersion is the newest migration recorded as applied, or zero for
+// a database that has none. Unlike Status it never creates the migrations
+// table, so it is safe to call often.
+func AppliedVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// MigrateUp applies every pending migration in order and returns the ones
+// it ran.
+func MigrateUp(db *sql.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for _, m := range migrations[DriverOf(db)] {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := runMigration(db, m.up, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+			return ran, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// MigrateDown reverts the newest applied migration and returns it, or nil
+// if nothing is applied.
+func MigrateDown(db *sql.DB) (*Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	list := migrations[DriverOf(db)]
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := runMigration(db, m.down, "DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+			return nil, fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// runMigration executes a migration script and updates schema_migrations in
+// one transaction, so a failed script leaves no trace.
+//
+// On SQLite, schema changes that rebuild a table would cascade deletes
+// through its foreign keys, and the pragma that prevents it has no effect
+// inside a transaction. So the script runs on one connection with foreign
+// keys off, and the result is checked before committing.
+func runMigration(db *sql.DB, script, record string, version int) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sqlite := DriverOf(db) == SQLite
+	if sqlite {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(record, version); err != nil {
+		return err
+	}
+	if sqlite {
+		if err := checkForeignKeys(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if the migration left rows pointing at rows that
+// no longer exist.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table string
+		var rowid sql.NullInt64
+		var parent string
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references missing %s row", table, rowid.Int64, parent)
+	}
+	return rows.Err()
+}
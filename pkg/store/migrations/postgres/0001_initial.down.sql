@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS instance_settings;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS match_candidates;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS subscriptions;
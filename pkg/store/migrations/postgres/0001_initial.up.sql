@@ -0,0 +1,51 @@
+-- The schema as it was before migrations existed. Every statement is
+-- idempotent so databases created by the old startup code adopt it as-is.
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	category TEXT NOT NULL,
+	cost DECIMAL(10,2) NOT NULL,
+	billing_cycle TEXT NOT NULL,
+	next_billing DATE NOT NULL,
+	description TEXT
+);
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id SERIAL PRIMARY KEY,
+	source TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	description TEXT NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	posted_on DATE NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL,
+	match_status TEXT NOT NULL DEFAULT 'unmatched',
+	UNIQUE (source, external_id)
+);
+
+CREATE TABLE IF NOT EXISTS match_candidates (
+	id SERIAL PRIMARY KEY,
+	transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	score REAL NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	UNIQUE (transaction_id, subscription_id)
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id SERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE CASCADE,
+	transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+	dedupe_key TEXT NOT NULL UNIQUE,
+	dismissed BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS instance_settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,14 @@
+-- Fails if two users imported the same transaction or raised the same
+-- alert; resolve those rows before going back to a single-user schema.
+
+DROP INDEX IF EXISTS alerts_user_dedupe_key;
+ALTER TABLE alerts ADD CONSTRAINT alerts_dedupe_key_key UNIQUE (dedupe_key);
+
+DROP INDEX IF EXISTS transactions_user_source_external_id;
+ALTER TABLE transactions ADD CONSTRAINT transactions_source_external_id_key UNIQUE (source, external_id);
+
+ALTER TABLE alerts DROP COLUMN IF EXISTS user_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS user_id;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS user_id;
+
+DROP TABLE IF EXISTS users;
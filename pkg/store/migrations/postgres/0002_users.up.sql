@@ -0,0 +1,19 @@
+-- User accounts. Data becomes per-user, so the transaction and alert dedupe
+-- keys are only unique within one user.
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_source_external_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_user_source_external_id ON transactions (user_id, source, external_id);
+
+ALTER TABLE alerts DROP CONSTRAINT IF EXISTS alerts_dedupe_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS alerts_user_dedupe_key ON alerts (user_id, dedupe_key);
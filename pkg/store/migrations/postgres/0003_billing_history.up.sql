@@ -0,0 +1,12 @@
+-- Billing history: one row per billing date that has passed, written when
+-- the roll-forward job advances a subscription's next billing date.
+
+CREATE TABLE IF NOT EXISTS billing_history (
+	id SERIAL PRIMARY KEY,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	billed_on DATE NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (subscription_id, billed_on)
+);
@@ -0,0 +1,19 @@
+-- Renewal reminders. reminders holds each subscription's setting; a row in
+-- notifications records a reminder sent, so each billing date is only
+-- mailed about once.
+
+CREATE TABLE IF NOT EXISTS reminders (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	days_before INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	billing_date DATE NOT NULL,
+	sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (subscription_id, kind, billing_date)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS currency;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,5 @@
+-- Amounts are stored in their own currency, and each user picks the
+-- currency totals are shown in. Everything before this was in dollars.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
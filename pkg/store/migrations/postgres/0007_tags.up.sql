@@ -0,0 +1,17 @@
+-- Tags are the user's own labels, shared across their subscriptions.
+-- Names are stored trimmed and lower-cased.
+
+CREATE TABLE IF NOT EXISTS tags (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS subscription_tags (
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (subscription_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscription_tags_tag ON subscription_tags (tag_id);
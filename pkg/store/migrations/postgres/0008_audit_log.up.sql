@@ -0,0 +1,15 @@
+-- Every change to a subscription, kept after the subscription is deleted.
+-- actor_id is who made the change, NULL for the server's own jobs; changes
+-- is a JSON object of field to {"from", "to"}.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	subscription_id INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	changes TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_subscription ON audit_log (user_id, subscription_id);
@@ -0,0 +1,15 @@
+-- One row per change to a subscription's price, with the price before and
+-- after it.
+
+CREATE TABLE IF NOT EXISTS price_history (
+	id SERIAL PRIMARY KEY,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	old_cost DECIMAL(10,2) NOT NULL,
+	old_currency TEXT NOT NULL,
+	new_cost DECIMAL(10,2) NOT NULL,
+	new_currency TEXT NOT NULL,
+	changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS price_history_subscription ON price_history (subscription_id, changed_at);
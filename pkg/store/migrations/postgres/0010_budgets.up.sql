@@ -0,0 +1,12 @@
+-- Monthly spending budgets. A budget with an empty category covers
+-- everything; each user has at most one budget per category.
+
+CREATE TABLE IF NOT EXISTS budgets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	category TEXT NOT NULL DEFAULT '',
+	amount DECIMAL(10,2) NOT NULL,
+	currency TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, category)
+);
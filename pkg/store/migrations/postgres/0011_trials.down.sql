@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS trial_ends_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS is_trial;
@@ -0,0 +1,5 @@
+-- Free trials. A trial ends on trial_ends_at, when the trial job turns it
+-- into a regular subscription; the date is kept afterwards.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS is_trial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS trial_ends_at DATE;
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS cancellation_reason;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS cancelled_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS status;
@@ -0,0 +1,6 @@
+-- Subscriptions can be paused or cancelled rather than deleted. A
+-- cancellation keeps its date and the reason given.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancelled_at DATE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancellation_reason TEXT;
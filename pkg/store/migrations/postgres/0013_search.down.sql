@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS subscriptions_search_idx;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS search_vector;
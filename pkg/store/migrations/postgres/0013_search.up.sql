@@ -0,0 +1,10 @@
+-- Full-text search over subscriptions. Names weigh most, then categories,
+-- then descriptions. The simple configuration doesn't stem, so product
+-- names match as typed.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(name, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(category, '')), 'B') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS subscriptions_search_idx ON subscriptions USING GIN (search_vector);
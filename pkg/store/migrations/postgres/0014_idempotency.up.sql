@@ -0,0 +1,14 @@
+-- Responses to requests sent with an Idempotency-Key, kept for a day so a
+-- retry gets the first answer instead of repeating the change. status is
+-- NULL while the first request is still running.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	idempotency_key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (user_id, idempotency_key)
+);
@@ -0,0 +1,4 @@
+-- Every write to a subscription bumps its version, so an update can be
+-- made conditional on no one else having changed it since it was read.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
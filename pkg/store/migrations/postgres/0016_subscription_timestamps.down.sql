@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS subscriptions_user_updated;
+DROP INDEX IF EXISTS subscriptions_user_created;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS created_at;
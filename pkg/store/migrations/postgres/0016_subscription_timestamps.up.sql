@@ -0,0 +1,9 @@
+-- Subscriptions record when they were added and last changed, so they can
+-- be listed by either. Existing rows get their last verification, which is
+-- when the API last wrote them.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+UPDATE subscriptions SET created_at = last_verified_at, updated_at = last_verified_at WHERE last_verified_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS subscriptions_user_created ON subscriptions (user_id, created_at);
+CREATE INDEX IF NOT EXISTS subscriptions_user_updated ON subscriptions (user_id, updated_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS subscriptions_user_category;
+DROP INDEX IF EXISTS subscriptions_next_billing;
+DROP INDEX IF EXISTS subscriptions_user_next_billing;
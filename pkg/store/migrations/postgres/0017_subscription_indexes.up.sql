@@ -0,0 +1,9 @@
+-- Subscriptions were only indexed for search and the created and updated
+-- sorts, so most list, stats and roll-forward queries scanned the table.
+-- Lists are per user and sorted by next billing date by default, the
+-- roll-forward job looks for passed billing dates across all users, and
+-- stats and filters group by category.
+
+CREATE INDEX IF NOT EXISTS subscriptions_user_next_billing ON subscriptions (user_id, next_billing);
+CREATE INDEX IF NOT EXISTS subscriptions_next_billing ON subscriptions (next_billing);
+CREATE INDEX IF NOT EXISTS subscriptions_user_category ON subscriptions (user_id, category);
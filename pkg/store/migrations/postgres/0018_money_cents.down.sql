@@ -0,0 +1,12 @@
+ALTER TABLE budgets ALTER COLUMN amount_cents TYPE DECIMAL(10,2) USING amount_cents / 100.0;
+ALTER TABLE budgets RENAME COLUMN amount_cents TO amount;
+ALTER TABLE price_history ALTER COLUMN new_cost_cents TYPE DECIMAL(10,2) USING new_cost_cents / 100.0;
+ALTER TABLE price_history RENAME COLUMN new_cost_cents TO new_cost;
+ALTER TABLE price_history ALTER COLUMN old_cost_cents TYPE DECIMAL(10,2) USING old_cost_cents / 100.0;
+ALTER TABLE price_history RENAME COLUMN old_cost_cents TO old_cost;
+ALTER TABLE billing_history ALTER COLUMN amount_cents TYPE DECIMAL(10,2) USING amount_cents / 100.0;
+ALTER TABLE billing_history RENAME COLUMN amount_cents TO amount;
+ALTER TABLE transactions ALTER COLUMN amount_cents TYPE DECIMAL(10,2) USING amount_cents / 100.0;
+ALTER TABLE transactions RENAME COLUMN amount_cents TO amount;
+ALTER TABLE subscriptions ALTER COLUMN cost_cents TYPE DECIMAL(10,2) USING cost_cents / 100.0;
+ALTER TABLE subscriptions RENAME COLUMN cost_cents TO cost;
@@ -0,0 +1,16 @@
+-- Amounts were DECIMAL(10,2) columns that the server read into floats,
+-- so totals picked up rounding errors. They're now whole numbers of cents,
+-- in columns named for it.
+
+ALTER TABLE subscriptions RENAME COLUMN cost TO cost_cents;
+ALTER TABLE subscriptions ALTER COLUMN cost_cents TYPE BIGINT USING ROUND(cost_cents * 100);
+ALTER TABLE transactions RENAME COLUMN amount TO amount_cents;
+ALTER TABLE transactions ALTER COLUMN amount_cents TYPE BIGINT USING ROUND(amount_cents * 100);
+ALTER TABLE billing_history RENAME COLUMN amount TO amount_cents;
+ALTER TABLE billing_history ALTER COLUMN amount_cents TYPE BIGINT USING ROUND(amount_cents * 100);
+ALTER TABLE price_history RENAME COLUMN old_cost TO old_cost_cents;
+ALTER TABLE price_history ALTER COLUMN old_cost_cents TYPE BIGINT USING ROUND(old_cost_cents * 100);
+ALTER TABLE price_history RENAME COLUMN new_cost TO new_cost_cents;
+ALTER TABLE price_history ALTER COLUMN new_cost_cents TYPE BIGINT USING ROUND(new_cost_cents * 100);
+ALTER TABLE budgets RENAME COLUMN amount TO amount_cents;
+ALTER TABLE budgets ALTER COLUMN amount_cents TYPE BIGINT USING ROUND(amount_cents * 100);
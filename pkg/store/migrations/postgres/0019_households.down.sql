@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS household_splits;
+DROP TABLE IF EXISTS household_subscriptions;
+DROP TABLE IF EXISTS household_invites;
+DROP TABLE IF EXISTS household_members;
+DROP TABLE IF EXISTS households;
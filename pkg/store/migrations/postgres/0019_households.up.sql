@@ -0,0 +1,45 @@
+-- Households share subscriptions between accounts. Each user is a member
+-- of at most one; its owner invites others by email. A member can share
+-- their own subscriptions with the household, and the cost of each is
+-- split by the percentages in household_splits, or equally between the
+-- members if it has none.
+
+CREATE TABLE IF NOT EXISTS households (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS household_members (
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+	role TEXT NOT NULL,
+	joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (household_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS household_invites (
+	id SERIAL PRIMARY KEY,
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (household_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS household_invites_email ON household_invites (email);
+
+CREATE TABLE IF NOT EXISTS household_subscriptions (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	shared_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS household_subscriptions_household ON household_subscriptions (household_id);
+
+CREATE TABLE IF NOT EXISTS household_splits (
+	subscription_id INTEGER NOT NULL REFERENCES household_subscriptions(subscription_id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	percent DECIMAL(5,2) NOT NULL,
+	PRIMARY KEY (subscription_id, user_id)
+);
@@ -0,0 +1,15 @@
+-- Two-factor authentication with TOTP. totp_secret is set when setup
+-- starts and totp_enabled once a code from it has been verified;
+-- totp_last_step is the time step of the last code accepted, so none can
+-- be used twice. Recovery codes are kept as SHA-256 hashes and deleted as
+-- they're used.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,17 @@
+-- API usage counts each user's requests to each endpoint, by the hour.
+-- The server adds to them in batches rather than writing a row per
+-- request. Route is the route template, like /api/subscriptions/{id}, or
+-- the full gRPC method name.
+
+CREATE TABLE IF NOT EXISTS api_usage (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	hour TIMESTAMPTZ NOT NULL,
+	method TEXT NOT NULL,
+	route TEXT NOT NULL,
+	requests BIGINT NOT NULL DEFAULT 0,
+	errors BIGINT NOT NULL DEFAULT 0,
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, hour, method, route)
+);
+
+CREATE INDEX IF NOT EXISTS api_usage_hour ON api_usage (hour);
@@ -0,0 +1,5 @@
+-- Account deletion. delete_after is set when a user asks for their account
+-- to be erased; once it has passed, the user and everything that
+-- references them are deleted. Logging in before then clears it.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+ALTER TABLE billing_history DROP COLUMN IF EXISTS rate;
+ALTER TABLE billing_history DROP COLUMN IF EXISTS record_currency;
+ALTER TABLE billing_history DROP COLUMN IF EXISTS currency;
+DROP TABLE IF EXISTS exchange_rates;
@@ -0,0 +1,20 @@
+-- Exchange rate snapshots. exchange_rates holds each day's rate for every
+-- currency in use, as units per US dollar. Each billing event keeps the
+-- currency it was billed in, the user's display currency when it was
+-- recorded and the rate between the two that day, so past spending
+-- doesn't change as rates do. Earlier events take their subscription's
+-- current currency and have no rate.
+
+CREATE TABLE IF NOT EXISTS exchange_rates (
+	currency TEXT NOT NULL,
+	rated_on DATE NOT NULL,
+	per_usd DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (currency, rated_on)
+);
+
+ALTER TABLE billing_history ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE billing_history ADD COLUMN IF NOT EXISTS record_currency TEXT;
+ALTER TABLE billing_history ADD COLUMN IF NOT EXISTS rate DOUBLE PRECISION;
+
+UPDATE billing_history SET currency = s.currency
+FROM subscriptions s WHERE s.id = billing_history.subscription_id;
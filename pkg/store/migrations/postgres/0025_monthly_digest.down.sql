@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS digests;
+ALTER TABLE users DROP COLUMN IF EXISTS monthly_digest;
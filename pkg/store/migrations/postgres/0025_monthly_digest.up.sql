@@ -0,0 +1,12 @@
+-- Monthly digest email. users.monthly_digest opts a user in; a row in
+-- digests claims one user's digest for a month, as YYYY-MM, so it's only
+-- sent once.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS monthly_digest BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS digests (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	month TEXT NOT NULL,
+	sent_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (user_id, month)
+);
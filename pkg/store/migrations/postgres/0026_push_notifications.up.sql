@@ -0,0 +1,25 @@
+-- Push notification channels. notification_settings holds a user's
+-- preference for each channel (webpush, ntfy or gotify) and, for ntfy and
+-- Gotify, where to publish; push_subscriptions holds the browsers that
+-- registered for Web Push.
+
+CREATE TABLE IF NOT EXISTS notification_settings (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	channel TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	url TEXT,
+	token TEXT,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, channel)
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	endpoint TEXT NOT NULL UNIQUE,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS push_subscriptions_user ON push_subscriptions (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS stripe_links;
+DROP TABLE IF EXISTS stripe_accounts;
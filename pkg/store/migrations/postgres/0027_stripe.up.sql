@@ -0,0 +1,19 @@
+-- Stripe sync. stripe_accounts holds the API key each user connected;
+-- stripe_links maps the user's subscriptions to Stripe subscription IDs,
+-- with how the last sync of each went.
+
+CREATE TABLE IF NOT EXISTS stripe_accounts (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	api_key TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS stripe_links (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	stripe_subscription_id TEXT NOT NULL,
+	status TEXT,
+	synced_at TIMESTAMPTZ,
+	error TEXT,
+	UNIQUE (user_id, stripe_subscription_id)
+);
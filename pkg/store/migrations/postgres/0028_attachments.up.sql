@@ -0,0 +1,16 @@
+-- Receipts and invoices attached to subscriptions. The files are in the
+-- blob store at blob_key, under attachments/<user>/<subscription>/, so
+-- everything attached to a subscription can be found by prefix.
+
+CREATE TABLE IF NOT EXISTS attachments (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	blob_key TEXT NOT NULL UNIQUE,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_subscription ON attachments (subscription_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS cancellation_info;
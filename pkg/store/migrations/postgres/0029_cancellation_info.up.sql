@@ -0,0 +1,10 @@
+-- How to cancel each subscription, and whether to be reminded to before
+-- it renews. Subscriptions without a row have nothing set.
+
+CREATE TABLE IF NOT EXISTS cancellation_info (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	url TEXT NOT NULL DEFAULT '',
+	instructions TEXT NOT NULL DEFAULT '',
+	remind_before_renewal BOOLEAN NOT NULL DEFAULT FALSE
+);
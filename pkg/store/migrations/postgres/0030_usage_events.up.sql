@@ -0,0 +1,13 @@
+-- Uses of a subscription the user logged, such as "watched Netflix
+-- today", one row per use, for cost per use in the insights.
+
+CREATE TABLE IF NOT EXISTS usage_events (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	used_on DATE NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS usage_events_subscription ON usage_events (subscription_id, used_on);
@@ -0,0 +1,4 @@
+-- Archived subscriptions are hidden from the subscription list and stats
+-- but kept, with their billing history, for reports.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
@@ -0,0 +1,17 @@
+-- Public read-only links to a user's subscriptions, for showing them to
+-- someone without an account. Only the SHA-256 of each link's token is
+-- kept. subscription_ids is a comma-separated list, or empty to share
+-- every active subscription.
+
+CREATE TABLE IF NOT EXISTS share_links (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	label TEXT NOT NULL DEFAULT '',
+	subscription_ids TEXT NOT NULL DEFAULT '',
+	include_stats BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS share_links_user ON share_links (user_id);
@@ -0,0 +1,19 @@
+-- Tenants partition the accounts of an instance hosted for several
+-- organizations. Everything else belongs to a user, so belongs to the
+-- user's tenant; households, shared between users, record theirs.
+-- Existing data goes to the default tenant, which always has ID 1.
+
+CREATE TABLE IF NOT EXISTS tenants (
+	id SERIAL PRIMARY KEY,
+	slug TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('tenants', 'id'), (SELECT MAX(id) FROM tenants));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants(id);
+ALTER TABLE households ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants(id);
+
+CREATE INDEX IF NOT EXISTS users_tenant ON users (tenant_id);
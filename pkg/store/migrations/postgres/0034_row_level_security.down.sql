@@ -0,0 +1,74 @@
+DROP POLICY IF EXISTS subscriptions_user ON subscriptions;
+ALTER TABLE subscriptions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE subscriptions DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tags_user ON tags;
+ALTER TABLE tags NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE tags DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS transactions_user ON transactions;
+ALTER TABLE transactions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE transactions DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS alerts_user ON alerts;
+ALTER TABLE alerts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE alerts DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS billing_history_user ON billing_history;
+ALTER TABLE billing_history NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE billing_history DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS reminders_user ON reminders;
+ALTER TABLE reminders NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE reminders DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS notifications_user ON notifications;
+ALTER TABLE notifications NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE notifications DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS webhooks_user ON webhooks;
+ALTER TABLE webhooks NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE webhooks DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS audit_log_user ON audit_log;
+ALTER TABLE audit_log NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_log DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS price_history_user ON price_history;
+ALTER TABLE price_history NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE price_history DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS budgets_user ON budgets;
+ALTER TABLE budgets NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE budgets DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS idempotency_keys_user ON idempotency_keys;
+ALTER TABLE idempotency_keys NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE idempotency_keys DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS sessions_user ON sessions;
+ALTER TABLE sessions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sessions DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS recovery_codes_user ON recovery_codes;
+ALTER TABLE recovery_codes NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE recovery_codes DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS api_usage_user ON api_usage;
+ALTER TABLE api_usage NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE api_usage DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS digests_user ON digests;
+ALTER TABLE digests NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE digests DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS notification_settings_user ON notification_settings;
+ALTER TABLE notification_settings NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE notification_settings DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS push_subscriptions_user ON push_subscriptions;
+ALTER TABLE push_subscriptions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE push_subscriptions DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS stripe_accounts_user ON stripe_accounts;
+ALTER TABLE stripe_accounts NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE stripe_accounts DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS stripe_links_user ON stripe_links;
+ALTER TABLE stripe_links NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE stripe_links DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS attachments_user ON attachments;
+ALTER TABLE attachments NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE attachments DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS cancellation_info_user ON cancellation_info;
+ALTER TABLE cancellation_info NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE cancellation_info DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS usage_events_user ON usage_events;
+ALTER TABLE usage_events NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE usage_events DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS share_links_user ON share_links;
+ALTER TABLE share_links NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE share_links DISABLE ROW LEVEL SECURITY;
+DROP FUNCTION IF EXISTS app_shared_subscriptions();
+DROP FUNCTION IF EXISTS app_user_id();
@@ -0,0 +1,143 @@
+-- Row-level security: when the app sets app.user_id for a query, these
+-- policies only let it see and write that user's rows, plus subscriptions
+-- shared into a household the user belongs to and their tags. Without
+-- app.user_id set, as for background jobs and the admin API, every row is
+-- visible. FORCE makes the policies apply to the tables' owner too;
+-- superusers and roles with BYPASSRLS still skip them.
+
+CREATE OR REPLACE FUNCTION app_user_id() RETURNS INTEGER LANGUAGE sql STABLE AS $$
+	SELECT NULLIF(current_setting('app.user_id', true), '')::INTEGER
+$$;
+
+-- app_shared_subscriptions is every subscription shared into the current
+-- user's household.
+CREATE OR REPLACE FUNCTION app_shared_subscriptions() RETURNS SETOF INTEGER LANGUAGE sql STABLE AS $$
+	SELECT hs.subscription_id FROM household_subscriptions hs
+	JOIN household_members m ON m.household_id = hs.household_id
+	WHERE m.user_id = app_user_id()
+$$;
+
+ALTER TABLE subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE subscriptions FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS subscriptions_user ON subscriptions;
+CREATE POLICY subscriptions_user ON subscriptions
+	USING (app_user_id() IS NULL OR user_id = app_user_id() OR id IN (SELECT app_shared_subscriptions()))
+	WITH CHECK (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE tags ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tags FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tags_user ON tags;
+CREATE POLICY tags_user ON tags
+	USING (app_user_id() IS NULL OR user_id = app_user_id()
+		OR id IN (SELECT st.tag_id FROM subscription_tags st WHERE st.subscription_id IN (SELECT app_shared_subscriptions())))
+	WITH CHECK (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE transactions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE transactions FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS transactions_user ON transactions;
+CREATE POLICY transactions_user ON transactions USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE alerts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE alerts FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS alerts_user ON alerts;
+CREATE POLICY alerts_user ON alerts USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE billing_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE billing_history FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS billing_history_user ON billing_history;
+CREATE POLICY billing_history_user ON billing_history USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE reminders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE reminders FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS reminders_user ON reminders;
+CREATE POLICY reminders_user ON reminders USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE notifications ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notifications FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS notifications_user ON notifications;
+CREATE POLICY notifications_user ON notifications USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE webhooks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhooks FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS webhooks_user ON webhooks;
+CREATE POLICY webhooks_user ON webhooks USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS audit_log_user ON audit_log;
+CREATE POLICY audit_log_user ON audit_log USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE price_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE price_history FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS price_history_user ON price_history;
+CREATE POLICY price_history_user ON price_history USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE budgets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budgets FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS budgets_user ON budgets;
+CREATE POLICY budgets_user ON budgets USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE idempotency_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE idempotency_keys FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS idempotency_keys_user ON idempotency_keys;
+CREATE POLICY idempotency_keys_user ON idempotency_keys USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sessions FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS sessions_user ON sessions;
+CREATE POLICY sessions_user ON sessions USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE recovery_codes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE recovery_codes FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS recovery_codes_user ON recovery_codes;
+CREATE POLICY recovery_codes_user ON recovery_codes USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE api_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE api_usage FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS api_usage_user ON api_usage;
+CREATE POLICY api_usage_user ON api_usage USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE digests ENABLE ROW LEVEL SECURITY;
+ALTER TABLE digests FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS digests_user ON digests;
+CREATE POLICY digests_user ON digests USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE notification_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notification_settings FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS notification_settings_user ON notification_settings;
+CREATE POLICY notification_settings_user ON notification_settings USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE push_subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE push_subscriptions FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS push_subscriptions_user ON push_subscriptions;
+CREATE POLICY push_subscriptions_user ON push_subscriptions USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE stripe_accounts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stripe_accounts FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS stripe_accounts_user ON stripe_accounts;
+CREATE POLICY stripe_accounts_user ON stripe_accounts USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE stripe_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stripe_links FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS stripe_links_user ON stripe_links;
+CREATE POLICY stripe_links_user ON stripe_links USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE attachments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE attachments FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS attachments_user ON attachments;
+CREATE POLICY attachments_user ON attachments USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE cancellation_info ENABLE ROW LEVEL SECURITY;
+ALTER TABLE cancellation_info FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS cancellation_info_user ON cancellation_info;
+CREATE POLICY cancellation_info_user ON cancellation_info USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE usage_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE usage_events FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS usage_events_user ON usage_events;
+CREATE POLICY usage_events_user ON usage_events USING (app_user_id() IS NULL OR user_id = app_user_id());
+
+ALTER TABLE share_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE share_links FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS share_links_user ON share_links;
+CREATE POLICY share_links_user ON share_links USING (app_user_id() IS NULL OR user_id = app_user_id());
@@ -0,0 +1,5 @@
+-- Fails if an email has accounts in more than one tenant.
+
+CREATE INDEX IF NOT EXISTS users_tenant ON users (tenant_id);
+DROP INDEX IF EXISTS users_tenant_email;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
@@ -0,0 +1,7 @@
+-- An email address can have an account in each tenant, so it's unique
+-- within a tenant rather than on the whole instance. The unique index
+-- leads with tenant_id, so it also serves the lookups users_tenant did.
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email ON users (tenant_id, email);
+DROP INDEX IF EXISTS users_tenant;
@@ -0,0 +1,207 @@
+-- Row-level security, strictly: the policies of 0034 let every row
+-- through when app.user_id wasn't set, so a query that lost its scope saw
+-- everything. Now a query sees only what its scope allows: app.tenant_id
+-- and app.user_id, set for each transaction, give the tenant's accounts
+-- and households and the user's own rows, and with neither set it sees no
+-- rows at all. Background jobs and the admin API run as a separate role
+-- with BYPASSRLS instead. The user only counts if it's in the tenant, so
+-- an ID from one tenant reaches nothing in another. The tables 0034 left
+-- out, belonging to a user through another table, are covered too.
+--
+-- The policies never refer back to a table whose policy refers to
+-- them, which Postgres rejects as infinite recursion: subscriptions go
+-- through household_subscriptions to household_members and households,
+-- which only check the tenant. Postgres can't see through
+-- app_household_id, so it's only used where it can't lead back to itself.
+
+CREATE OR REPLACE FUNCTION app_tenant_id() RETURNS INTEGER LANGUAGE sql STABLE AS $$
+	SELECT NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+$$;
+
+-- app_user_id is app.user_id, if it's an account in app.tenant_id.
+CREATE OR REPLACE FUNCTION app_user_id() RETURNS INTEGER LANGUAGE sql STABLE AS $$
+	SELECT id FROM users WHERE id = NULLIF(current_setting('app.user_id', true), '')::INTEGER AND tenant_id = app_tenant_id()
+$$;
+
+-- app_household_id is the household the current user is in.
+CREATE OR REPLACE FUNCTION app_household_id() RETURNS INTEGER LANGUAGE sql STABLE AS $$
+	SELECT household_id FROM household_members WHERE user_id = app_user_id()
+$$;
+
+-- The tenant's accounts are visible, since households list their members
+-- and invites name their senders, but each can only change itself. These
+-- policies read app.user_id directly: app_user_id reads users.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS users_tenant ON users;
+CREATE POLICY users_tenant ON users USING (tenant_id = (SELECT app_tenant_id()));
+
+DROP POLICY IF EXISTS users_update_self ON users;
+CREATE POLICY users_update_self ON users AS RESTRICTIVE FOR UPDATE
+	USING (id = NULLIF(current_setting('app.user_id', true), '')::INTEGER);
+
+DROP POLICY IF EXISTS users_delete_self ON users;
+CREATE POLICY users_delete_self ON users AS RESTRICTIVE FOR DELETE
+	USING (id = NULLIF(current_setting('app.user_id', true), '')::INTEGER);
+
+ALTER TABLE households ENABLE ROW LEVEL SECURITY;
+ALTER TABLE households FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS households_tenant ON households;
+CREATE POLICY households_tenant ON households USING (tenant_id = (SELECT app_tenant_id()));
+
+DROP POLICY IF EXISTS households_update_member ON households;
+CREATE POLICY households_update_member ON households AS RESTRICTIVE FOR UPDATE
+	USING (id = (SELECT app_household_id()));
+
+DROP POLICY IF EXISTS households_delete_member ON households;
+CREATE POLICY households_delete_member ON households AS RESTRICTIVE FOR DELETE
+	USING (id = (SELECT app_household_id()));
+
+-- Users only join households themselves, and only leave, or are removed
+-- from, their own.
+ALTER TABLE household_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE household_members FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS household_members_tenant ON household_members;
+CREATE POLICY household_members_tenant ON household_members USING (household_id IN (SELECT id FROM households));
+
+DROP POLICY IF EXISTS household_members_join_self ON household_members;
+CREATE POLICY household_members_join_self ON household_members AS RESTRICTIVE FOR INSERT
+	WITH CHECK (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS household_members_update_member ON household_members;
+CREATE POLICY household_members_update_member ON household_members AS RESTRICTIVE FOR UPDATE
+	USING (household_id = (SELECT app_household_id()));
+
+DROP POLICY IF EXISTS household_members_delete_member ON household_members;
+CREATE POLICY household_members_delete_member ON household_members AS RESTRICTIVE FOR DELETE
+	USING (household_id = (SELECT app_household_id()));
+
+-- A household's invites are its members', and an invite is also its
+-- invitee's, to see, accept or decline; only members send them.
+ALTER TABLE household_invites ENABLE ROW LEVEL SECURITY;
+ALTER TABLE household_invites FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS household_invites_user ON household_invites;
+CREATE POLICY household_invites_user ON household_invites
+	USING (household_id = (SELECT app_household_id())
+		OR (email = (SELECT email FROM users WHERE id = (SELECT app_user_id())) AND household_id IN (SELECT id FROM households)));
+
+DROP POLICY IF EXISTS household_invites_send_member ON household_invites;
+CREATE POLICY household_invites_send_member ON household_invites AS RESTRICTIVE FOR INSERT
+	WITH CHECK (household_id = (SELECT app_household_id()));
+
+ALTER TABLE household_subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE household_subscriptions FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS household_subscriptions_member ON household_subscriptions;
+CREATE POLICY household_subscriptions_member ON household_subscriptions USING (household_id = (SELECT app_household_id()));
+
+ALTER TABLE household_splits ENABLE ROW LEVEL SECURITY;
+ALTER TABLE household_splits FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS household_splits_member ON household_splits;
+CREATE POLICY household_splits_member ON household_splits USING (subscription_id IN (SELECT subscription_id FROM household_subscriptions));
+
+DROP POLICY IF EXISTS subscriptions_user ON subscriptions;
+CREATE POLICY subscriptions_user ON subscriptions
+	USING (user_id = (SELECT app_user_id()) OR id IN (SELECT subscription_id FROM household_subscriptions))
+	WITH CHECK (user_id = (SELECT app_user_id()));
+
+-- Tags on a shared subscription are visible with it; only its owner tags
+-- it.
+ALTER TABLE subscription_tags ENABLE ROW LEVEL SECURITY;
+ALTER TABLE subscription_tags FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS subscription_tags_user ON subscription_tags;
+CREATE POLICY subscription_tags_user ON subscription_tags
+	USING (subscription_id IN (SELECT id FROM subscriptions))
+	WITH CHECK (subscription_id IN (SELECT id FROM subscriptions WHERE user_id = (SELECT app_user_id())));
+
+DROP POLICY IF EXISTS tags_user ON tags;
+CREATE POLICY tags_user ON tags
+	USING (user_id = (SELECT app_user_id())
+		OR id IN (SELECT tag_id FROM subscription_tags WHERE subscription_id IN (SELECT subscription_id FROM household_subscriptions)))
+	WITH CHECK (user_id = (SELECT app_user_id()));
+
+ALTER TABLE match_candidates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE match_candidates FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS match_candidates_user ON match_candidates;
+CREATE POLICY match_candidates_user ON match_candidates USING (transaction_id IN (SELECT id FROM transactions));
+
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_deliveries FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS webhook_deliveries_user ON webhook_deliveries;
+CREATE POLICY webhook_deliveries_user ON webhook_deliveries USING (webhook_id IN (SELECT id FROM webhooks));
+
+DROP POLICY IF EXISTS transactions_user ON transactions;
+CREATE POLICY transactions_user ON transactions USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS alerts_user ON alerts;
+CREATE POLICY alerts_user ON alerts USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS billing_history_user ON billing_history;
+CREATE POLICY billing_history_user ON billing_history USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS reminders_user ON reminders;
+CREATE POLICY reminders_user ON reminders USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS notifications_user ON notifications;
+CREATE POLICY notifications_user ON notifications USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS webhooks_user ON webhooks;
+CREATE POLICY webhooks_user ON webhooks USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS audit_log_user ON audit_log;
+CREATE POLICY audit_log_user ON audit_log USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS price_history_user ON price_history;
+CREATE POLICY price_history_user ON price_history USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS budgets_user ON budgets;
+CREATE POLICY budgets_user ON budgets USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS idempotency_keys_user ON idempotency_keys;
+CREATE POLICY idempotency_keys_user ON idempotency_keys USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS sessions_user ON sessions;
+CREATE POLICY sessions_user ON sessions USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS recovery_codes_user ON recovery_codes;
+CREATE POLICY recovery_codes_user ON recovery_codes USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS api_usage_user ON api_usage;
+CREATE POLICY api_usage_user ON api_usage USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS digests_user ON digests;
+CREATE POLICY digests_user ON digests USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS notification_settings_user ON notification_settings;
+CREATE POLICY notification_settings_user ON notification_settings USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS push_subscriptions_user ON push_subscriptions;
+CREATE POLICY push_subscriptions_user ON push_subscriptions USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS stripe_accounts_user ON stripe_accounts;
+CREATE POLICY stripe_accounts_user ON stripe_accounts USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS stripe_links_user ON stripe_links;
+CREATE POLICY stripe_links_user ON stripe_links USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS attachments_user ON attachments;
+CREATE POLICY attachments_user ON attachments USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS cancellation_info_user ON cancellation_info;
+CREATE POLICY cancellation_info_user ON cancellation_info USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS usage_events_user ON usage_events;
+CREATE POLICY usage_events_user ON usage_events USING (user_id = (SELECT app_user_id()));
+
+DROP POLICY IF EXISTS share_links_user ON share_links;
+CREATE POLICY share_links_user ON share_links USING (user_id = (SELECT app_user_id()));
+
+DROP FUNCTION IF EXISTS app_shared_subscriptions();
@@ -0,0 +1,25 @@
+-- The admin role is granted to an account explicitly, through the admin
+-- API, rather than to whoever signed up first with an email listed in
+-- ADMIN_EMAILS, since sign-up doesn't verify emails.
+--
+-- admin_audit_log records what admins do to accounts, such as
+-- impersonating them. actor_id is the admin account, NULL for the admin
+-- token; the target's email is kept so entries still read after the
+-- account is deleted. Only the system role sees it: row-level security
+-- is on with no policy.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	id SERIAL PRIMARY KEY,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	action TEXT NOT NULL,
+	target_user_id INTEGER NOT NULL,
+	target_email TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_target ON admin_audit_log (target_user_id);
+
+ALTER TABLE admin_audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE admin_audit_log FORCE ROW LEVEL SECURITY;
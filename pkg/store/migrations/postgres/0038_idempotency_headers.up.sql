@@ -0,0 +1,5 @@
+-- The response headers a replay repeats besides Content-Type, such as
+-- the Location and ETag of what the first request created, as a JSON
+-- object of header names to values.
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS headers TEXT NOT NULL DEFAULT '{}';
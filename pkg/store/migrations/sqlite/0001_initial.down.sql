@@ -0,0 +1,5 @@
+DROP TABLE instance_settings;
+DROP TABLE alerts;
+DROP TABLE match_candidates;
+DROP TABLE transactions;
+DROP TABLE subscriptions;
@@ -0,0 +1,51 @@
+-- SQLite version of postgres/0001_initial. Unique constraints are named
+-- indexes here because SQLite can't drop a constraint declared inline.
+
+CREATE TABLE subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	category TEXT NOT NULL,
+	cost DECIMAL(10,2) NOT NULL,
+	billing_cycle TEXT NOT NULL,
+	next_billing DATE NOT NULL,
+	description TEXT,
+	last_verified_at TIMESTAMP
+);
+
+CREATE TABLE transactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	description TEXT NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	posted_on DATE NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL,
+	match_status TEXT NOT NULL DEFAULT 'unmatched'
+);
+CREATE UNIQUE INDEX transactions_source_external_id_key ON transactions (source, external_id);
+
+CREATE TABLE match_candidates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	score REAL NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	UNIQUE (transaction_id, subscription_id)
+);
+
+CREATE TABLE alerts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE CASCADE,
+	transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+	dedupe_key TEXT NOT NULL,
+	dismissed BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX alerts_dedupe_key_key ON alerts (dedupe_key);
+
+CREATE TABLE instance_settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,55 @@
+-- SQLite can't drop a column that has a foreign key, so the three tables are
+-- rebuilt without user_id. The migration runner turns foreign key
+-- enforcement off while this runs so the rebuilds don't cascade.
+--
+-- Fails if two users imported the same transaction or raised the same
+-- alert; resolve those rows before going back to a single-user schema.
+
+CREATE TABLE subscriptions_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	category TEXT NOT NULL,
+	cost DECIMAL(10,2) NOT NULL,
+	billing_cycle TEXT NOT NULL,
+	next_billing DATE NOT NULL,
+	description TEXT,
+	last_verified_at TIMESTAMP
+);
+INSERT INTO subscriptions_old
+	SELECT id, name, category, cost, billing_cycle, next_billing, description, last_verified_at FROM subscriptions;
+DROP TABLE subscriptions;
+ALTER TABLE subscriptions_old RENAME TO subscriptions;
+
+CREATE TABLE transactions_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	description TEXT NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	posted_on DATE NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL,
+	match_status TEXT NOT NULL DEFAULT 'unmatched'
+);
+INSERT INTO transactions_old
+	SELECT id, source, external_id, description, amount, posted_on, subscription_id, match_status FROM transactions;
+DROP TABLE transactions;
+ALTER TABLE transactions_old RENAME TO transactions;
+CREATE UNIQUE INDEX transactions_source_external_id_key ON transactions (source, external_id);
+
+CREATE TABLE alerts_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE CASCADE,
+	transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+	dedupe_key TEXT NOT NULL,
+	dismissed BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO alerts_old
+	SELECT id, kind, message, subscription_id, transaction_id, dedupe_key, dismissed, created_at FROM alerts;
+DROP TABLE alerts;
+ALTER TABLE alerts_old RENAME TO alerts;
+CREATE UNIQUE INDEX alerts_dedupe_key_key ON alerts (dedupe_key);
+
+DROP TABLE users;
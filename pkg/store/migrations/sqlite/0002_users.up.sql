@@ -0,0 +1,18 @@
+-- SQLite version of postgres/0002_users.
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE subscriptions ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE transactions ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE alerts ADD COLUMN user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+DROP INDEX transactions_source_external_id_key;
+CREATE UNIQUE INDEX transactions_user_source_external_id ON transactions (user_id, source, external_id);
+
+DROP INDEX alerts_dedupe_key_key;
+CREATE UNIQUE INDEX alerts_user_dedupe_key ON alerts (user_id, dedupe_key);
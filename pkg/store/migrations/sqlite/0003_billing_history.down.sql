@@ -0,0 +1 @@
+DROP TABLE billing_history;
@@ -0,0 +1,11 @@
+-- SQLite version of postgres/0003_billing_history.
+
+CREATE TABLE billing_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	billed_on DATE NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (subscription_id, billed_on)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN currency;
+ALTER TABLE subscriptions DROP COLUMN currency;
@@ -0,0 +1,4 @@
+-- SQLite version of postgres/0006_currencies.
+
+ALTER TABLE subscriptions ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE users ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
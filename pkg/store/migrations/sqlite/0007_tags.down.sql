@@ -0,0 +1,2 @@
+DROP TABLE subscription_tags;
+DROP TABLE tags;
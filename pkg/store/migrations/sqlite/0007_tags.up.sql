@@ -0,0 +1,16 @@
+-- SQLite version of postgres/0007_tags.
+
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	UNIQUE (user_id, name)
+);
+
+CREATE TABLE subscription_tags (
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (subscription_id, tag_id)
+);
+
+CREATE INDEX subscription_tags_tag ON subscription_tags (tag_id);
@@ -0,0 +1,13 @@
+-- SQLite version of postgres/0008_audit_log.
+
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	subscription_id INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	changes TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_user_subscription ON audit_log (user_id, subscription_id);
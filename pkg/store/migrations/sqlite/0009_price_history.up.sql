@@ -0,0 +1,14 @@
+-- SQLite version of postgres/0009_price_history.
+
+CREATE TABLE price_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	old_cost DECIMAL(10,2) NOT NULL,
+	old_currency TEXT NOT NULL,
+	new_cost DECIMAL(10,2) NOT NULL,
+	new_currency TEXT NOT NULL,
+	changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX price_history_subscription ON price_history (subscription_id, changed_at);
@@ -0,0 +1,11 @@
+-- SQLite version of postgres/0010_budgets.
+
+CREATE TABLE budgets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	category TEXT NOT NULL DEFAULT '',
+	amount DECIMAL(10,2) NOT NULL,
+	currency TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, category)
+);
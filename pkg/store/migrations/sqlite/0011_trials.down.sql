@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN trial_ends_at;
+ALTER TABLE subscriptions DROP COLUMN is_trial;
@@ -0,0 +1,4 @@
+-- SQLite version of postgres/0011_trials.
+
+ALTER TABLE subscriptions ADD COLUMN is_trial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN trial_ends_at DATE;
@@ -0,0 +1,5 @@
+-- SQLite version of postgres/0012_subscription_status.
+
+ALTER TABLE subscriptions ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE subscriptions ADD COLUMN cancelled_at DATE;
+ALTER TABLE subscriptions ADD COLUMN cancellation_reason TEXT;
@@ -0,0 +1 @@
+-- Nothing to drop; see 0013_search.up.sql.
@@ -0,0 +1,2 @@
+-- SQLite version of postgres/0013_search. There's no full-text index;
+-- search matches with LIKE instead, so there's nothing to create.
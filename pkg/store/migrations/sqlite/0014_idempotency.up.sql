@@ -0,0 +1,12 @@
+-- SQLite version of postgres/0014_idempotency.
+
+CREATE TABLE idempotency_keys (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	idempotency_key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, idempotency_key)
+);
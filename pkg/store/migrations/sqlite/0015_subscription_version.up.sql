@@ -0,0 +1,3 @@
+-- SQLite version of postgres/0015_subscription_version.
+
+ALTER TABLE subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+DROP INDEX subscriptions_user_updated;
+DROP INDEX subscriptions_user_created;
+ALTER TABLE subscriptions DROP COLUMN updated_at;
+ALTER TABLE subscriptions DROP COLUMN created_at;
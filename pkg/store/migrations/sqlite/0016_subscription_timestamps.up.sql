@@ -0,0 +1,11 @@
+-- SQLite version of postgres/0016_subscription_timestamps. A column added
+-- to an existing table can't default to CURRENT_TIMESTAMP, so rows never
+-- verified are filled in afterwards.
+
+ALTER TABLE subscriptions ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE subscriptions ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE subscriptions SET
+	created_at = COALESCE(last_verified_at, CURRENT_TIMESTAMP),
+	updated_at = COALESCE(last_verified_at, CURRENT_TIMESTAMP);
+CREATE INDEX subscriptions_user_created ON subscriptions (user_id, created_at);
+CREATE INDEX subscriptions_user_updated ON subscriptions (user_id, updated_at);
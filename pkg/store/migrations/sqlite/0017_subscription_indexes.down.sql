@@ -0,0 +1,3 @@
+DROP INDEX subscriptions_user_category;
+DROP INDEX subscriptions_next_billing;
+DROP INDEX subscriptions_user_next_billing;
@@ -0,0 +1,5 @@
+-- SQLite version of postgres/0017_subscription_indexes.
+
+CREATE INDEX subscriptions_user_next_billing ON subscriptions (user_id, next_billing);
+CREATE INDEX subscriptions_next_billing ON subscriptions (next_billing);
+CREATE INDEX subscriptions_user_category ON subscriptions (user_id, category);
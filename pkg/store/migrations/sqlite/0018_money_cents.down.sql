@@ -0,0 +1,11 @@
+UPDATE budgets SET amount_cents = amount_cents / 100.0;
+ALTER TABLE budgets RENAME COLUMN amount_cents TO amount;
+UPDATE price_history SET old_cost_cents = old_cost_cents / 100.0, new_cost_cents = new_cost_cents / 100.0;
+ALTER TABLE price_history RENAME COLUMN new_cost_cents TO new_cost;
+ALTER TABLE price_history RENAME COLUMN old_cost_cents TO old_cost;
+UPDATE billing_history SET amount_cents = amount_cents / 100.0;
+ALTER TABLE billing_history RENAME COLUMN amount_cents TO amount;
+UPDATE transactions SET amount_cents = amount_cents / 100.0;
+ALTER TABLE transactions RENAME COLUMN amount_cents TO amount;
+UPDATE subscriptions SET cost_cents = cost_cents / 100.0;
+ALTER TABLE subscriptions RENAME COLUMN cost_cents TO cost;
@@ -0,0 +1,17 @@
+-- SQLite version of postgres/0018_money_cents. A column's type can't be
+-- changed here, so the renamed columns keep their DECIMAL declaration,
+-- under which whole numbers are stored as integers.
+
+ALTER TABLE subscriptions RENAME COLUMN cost TO cost_cents;
+UPDATE subscriptions SET cost_cents = CAST(ROUND(cost_cents * 100) AS INTEGER);
+ALTER TABLE transactions RENAME COLUMN amount TO amount_cents;
+UPDATE transactions SET amount_cents = CAST(ROUND(amount_cents * 100) AS INTEGER);
+ALTER TABLE billing_history RENAME COLUMN amount TO amount_cents;
+UPDATE billing_history SET amount_cents = CAST(ROUND(amount_cents * 100) AS INTEGER);
+ALTER TABLE price_history RENAME COLUMN old_cost TO old_cost_cents;
+ALTER TABLE price_history RENAME COLUMN new_cost TO new_cost_cents;
+UPDATE price_history SET
+	old_cost_cents = CAST(ROUND(old_cost_cents * 100) AS INTEGER),
+	new_cost_cents = CAST(ROUND(new_cost_cents * 100) AS INTEGER);
+ALTER TABLE budgets RENAME COLUMN amount TO amount_cents;
+UPDATE budgets SET amount_cents = CAST(ROUND(amount_cents * 100) AS INTEGER);
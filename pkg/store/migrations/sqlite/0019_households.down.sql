@@ -0,0 +1,5 @@
+DROP TABLE household_splits;
+DROP TABLE household_subscriptions;
+DROP TABLE household_invites;
+DROP TABLE household_members;
+DROP TABLE households;
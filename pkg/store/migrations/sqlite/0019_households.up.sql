@@ -0,0 +1,41 @@
+-- SQLite version of postgres/0019_households.
+
+CREATE TABLE households (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE household_members (
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+	role TEXT NOT NULL,
+	joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (household_id, user_id)
+);
+
+CREATE TABLE household_invites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (household_id, email)
+);
+
+CREATE INDEX household_invites_email ON household_invites (email);
+
+CREATE TABLE household_subscriptions (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	shared_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX household_subscriptions_household ON household_subscriptions (household_id);
+
+CREATE TABLE household_splits (
+	subscription_id INTEGER NOT NULL REFERENCES household_subscriptions(subscription_id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	percent DECIMAL(5,2) NOT NULL,
+	PRIMARY KEY (subscription_id, user_id)
+);
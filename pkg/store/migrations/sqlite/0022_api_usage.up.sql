@@ -0,0 +1,14 @@
+-- SQLite version of postgres/0022_api_usage.
+
+CREATE TABLE api_usage (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	hour TIMESTAMP NOT NULL,
+	method TEXT NOT NULL,
+	route TEXT NOT NULL,
+	requests INTEGER NOT NULL DEFAULT 0,
+	errors INTEGER NOT NULL DEFAULT 0,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, hour, method, route)
+);
+
+CREATE INDEX api_usage_hour ON api_usage (hour);
@@ -0,0 +1,3 @@
+-- SQLite version of postgres/0023_account_deletion.
+
+ALTER TABLE users ADD COLUMN delete_after TIMESTAMP;
@@ -0,0 +1,4 @@
+ALTER TABLE billing_history DROP COLUMN rate;
+ALTER TABLE billing_history DROP COLUMN record_currency;
+ALTER TABLE billing_history DROP COLUMN currency;
+DROP TABLE exchange_rates;
@@ -0,0 +1,16 @@
+-- SQLite version of postgres/0024_exchange_rates.
+
+CREATE TABLE exchange_rates (
+	currency TEXT NOT NULL,
+	rated_on DATE NOT NULL,
+	per_usd REAL NOT NULL,
+	PRIMARY KEY (currency, rated_on)
+);
+
+ALTER TABLE billing_history ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE billing_history ADD COLUMN record_currency TEXT;
+ALTER TABLE billing_history ADD COLUMN rate REAL;
+
+UPDATE billing_history SET currency = (
+	SELECT currency FROM subscriptions s WHERE s.id = billing_history.subscription_id
+);
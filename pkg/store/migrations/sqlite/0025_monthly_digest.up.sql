@@ -0,0 +1,10 @@
+-- SQLite version of postgres/0025_monthly_digest.
+
+ALTER TABLE users ADD COLUMN monthly_digest BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE digests (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	month TEXT NOT NULL,
+	sent_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, month)
+);
@@ -0,0 +1,22 @@
+-- SQLite version of postgres/0026_push_notifications.
+
+CREATE TABLE notification_settings (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	channel TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	url TEXT,
+	token TEXT,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, channel)
+);
+
+CREATE TABLE push_subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	endpoint TEXT NOT NULL UNIQUE,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX push_subscriptions_user ON push_subscriptions (user_id);
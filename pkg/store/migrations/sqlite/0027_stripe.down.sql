@@ -0,0 +1,2 @@
+DROP TABLE stripe_links;
+DROP TABLE stripe_accounts;
@@ -0,0 +1,17 @@
+-- SQLite version of postgres/0027_stripe.
+
+CREATE TABLE stripe_accounts (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	api_key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE stripe_links (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	stripe_subscription_id TEXT NOT NULL,
+	status TEXT,
+	synced_at TIMESTAMP,
+	error TEXT,
+	UNIQUE (user_id, stripe_subscription_id)
+);
@@ -0,0 +1,14 @@
+-- SQLite version of postgres/0028_attachments.
+
+CREATE TABLE attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	blob_key TEXT NOT NULL UNIQUE,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size_bytes INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX attachments_subscription ON attachments (subscription_id);
@@ -0,0 +1 @@
+DROP TABLE cancellation_info;
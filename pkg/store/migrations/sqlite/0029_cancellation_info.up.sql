@@ -0,0 +1,9 @@
+-- SQLite version of postgres/0029_cancellation_info.
+
+CREATE TABLE cancellation_info (
+	subscription_id INTEGER PRIMARY KEY REFERENCES subscriptions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	url TEXT NOT NULL DEFAULT '',
+	instructions TEXT NOT NULL DEFAULT '',
+	remind_before_renewal BOOLEAN NOT NULL DEFAULT FALSE
+);
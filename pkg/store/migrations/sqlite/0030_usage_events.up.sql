@@ -0,0 +1,12 @@
+-- SQLite version of postgres/0030_usage_events.
+
+CREATE TABLE usage_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	used_on DATE NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX usage_events_subscription ON usage_events (subscription_id, used_on);
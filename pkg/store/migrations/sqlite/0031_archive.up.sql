@@ -0,0 +1,3 @@
+-- SQLite version of postgres/0031_archive.
+
+ALTER TABLE subscriptions ADD COLUMN archived_at TIMESTAMP;
@@ -0,0 +1,14 @@
+-- SQLite version of postgres/0032_share_links.
+
+CREATE TABLE share_links (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	label TEXT NOT NULL DEFAULT '',
+	subscription_ids TEXT NOT NULL DEFAULT '',
+	include_stats BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP
+);
+
+CREATE INDEX share_links_user ON share_links (user_id);
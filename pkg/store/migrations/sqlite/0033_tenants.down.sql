@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_tenant;
+ALTER TABLE households DROP COLUMN tenant_id;
+ALTER TABLE users DROP COLUMN tenant_id;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,17 @@
+-- SQLite version of postgres/0033_tenants. SQLite can't add a column
+-- with both a foreign key and a non-NULL default, so tenant_id isn't
+-- declared as referencing tenants here; the app keeps it consistent.
+
+CREATE TABLE tenants (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default');
+
+ALTER TABLE users ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE households ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX users_tenant ON users (tenant_id);
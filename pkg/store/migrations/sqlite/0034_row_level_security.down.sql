@@ -0,0 +1 @@
+-- SQLite has no row-level security; see the up migration.
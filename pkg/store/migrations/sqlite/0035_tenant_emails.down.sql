@@ -0,0 +1,23 @@
+-- Fails if an email has accounts in more than one tenant.
+
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	totp_secret TEXT,
+	totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_last_step INTEGER NOT NULL DEFAULT 0,
+	delete_after TIMESTAMP,
+	monthly_digest BOOLEAN NOT NULL DEFAULT FALSE,
+	tenant_id INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO users_new (id, email, password_hash, created_at, currency, totp_secret, totp_enabled, totp_last_step, delete_after, monthly_digest, tenant_id)
+SELECT id, email, password_hash, created_at, currency, totp_secret, totp_enabled, totp_last_step, delete_after, monthly_digest, tenant_id FROM users;
+UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'users') WHERE name = 'users_new';
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX users_tenant ON users (tenant_id);
@@ -0,0 +1,25 @@
+-- SQLite version of postgres/0035_tenant_emails. SQLite can't drop the
+-- UNIQUE constraint on email, so the table is rebuilt without it, keeping
+-- its IDs and where AUTOINCREMENT had got to.
+
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	totp_secret TEXT,
+	totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_last_step INTEGER NOT NULL DEFAULT 0,
+	delete_after TIMESTAMP,
+	monthly_digest BOOLEAN NOT NULL DEFAULT FALSE,
+	tenant_id INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO users_new (id, email, password_hash, created_at, currency, totp_secret, totp_enabled, totp_last_step, delete_after, monthly_digest, tenant_id)
+SELECT id, email, password_hash, created_at, currency, totp_secret, totp_enabled, totp_last_step, delete_after, monthly_digest, tenant_id FROM users;
+UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'users') WHERE name = 'users_new';
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE UNIQUE INDEX users_tenant_email ON users (tenant_id, email);
@@ -0,0 +1,2 @@
+-- SQLite version of postgres/0036_strict_row_level_security. SQLite has no
+-- row-level security, so there is nothing to do.
@@ -0,0 +1,14 @@
+-- SQLite version of postgres/0037_admin_role, without row-level security.
+
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE admin_audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	action TEXT NOT NULL,
+	target_user_id INTEGER NOT NULL,
+	target_email TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX admin_audit_log_target ON admin_audit_log (target_user_id);
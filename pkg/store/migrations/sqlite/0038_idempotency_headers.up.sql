@@ -0,0 +1,3 @@
+-- SQLite version of postgres/0038_idempotency_headers.
+
+ALTER TABLE idempotency_keys ADD COLUMN headers TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// maxPrepared caps how many statements a Statements keeps. Queries built
+// from filters, like List's, have many forms; past the cap, new ones run
+// without being prepared.
+const maxPrepared = 256
+
+// Statements runs queries as prepared statements, preparing each the first
+// time it's run and reusing it after that, so the database plans it once
+// instead of on every request. database/sql prepares it again on each
+// pooled connection that needs it. A query that fails to prepare is run
+// as-is, so its error is reported the usual way.
+type Statements struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+	// preparing holds the queries being prepared in the background.
+	preparing map[string]bool
+}
+
+func NewStatements(db *sql.DB) *Statements {
+	return &Statements{db: db, stmts: map[string]*sql.Stmt{}, preparing: map[string]bool{}}
+}
+
+// stmt returns the prepared statement for query, or nil if it can't be
+// prepared or the cache is full.
+func (s *Statements) stmt(ctx context.Context, query string) *sql.Stmt {
+	s.mu.Lock()
+	stmt, ok := s.stmts[query]
+	full := len(s.stmts) >= maxPrepared
+	s.mu.Unlock()
+	if ok || full {
+		return stmt
+	}
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.stmts[query]; ok {
+		// Prepared concurrently by another request.
+		stmt.Close()
+		return existing
+	}
+	s.stmts[query] = stmt
+	return stmt
+}
+
+func (s *Statements) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt := s.stmt(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return s.db.ExecContext(ctx, query, args...)
+}
+
+func (s *Statements) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt := s.stmt(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+func (s *Statements) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt := s.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return s.db.QueryRowContext(ctx, query, args...)
+}
+
+// cached returns the statement already prepared for query. If there isn't
+// one it starts preparing it in the background and returns nil.
+func (s *Statements) cached(query string) *sql.Stmt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stmt, ok := s.stmts[query]; ok || s.preparing[query] || len(s.stmts) >= maxPrepared {
+		return stmt
+	}
+	s.preparing[query] = true
+	go func() {
+		s.stmt(context.Background(), query)
+		s.mu.Lock()
+		delete(s.preparing, query)
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+// in runs the statements inside tx. A query not prepared yet runs as-is
+// and is prepared for next time once a connection is free: preparing
+// takes a connection of its own, and waiting for one while tx holds its
+// own could wait for ever, as with SQLite's single connection.
+func (s *Statements) in(tx *sql.Tx) querier {
+	return txStatements{s, tx}
+}
+
+type txStatements struct {
+	*Statements
+	tx *sql.Tx
+}
+
+func (t txStatements) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt := t.cached(query); stmt != nil {
+		return t.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t txStatements) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt := t.cached(query); stmt != nil {
+		return t.tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	}
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+func (t txStatements) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt := t.cached(query); stmt != nil {
+		return t.tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+	}
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// whereClause builds a WHERE clause from conditions written with ? for
+// their argument. Placeholders are numbered as conditions are added, so
+// values never end up in the SQL text.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+// add appends a condition. Each ? in cond consumes the next arg.
+func (w *whereClause) add(cond string, args ...any) {
+	var b strings.Builder
+	n := 0
+	for _, r := range cond {
+		if r == '?' && n < len(args) {
+			w.args = append(w.args, args[n])
+			n++
+			b.WriteString("$" + strconv.Itoa(len(w.args)))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	w.conds = append(w.conds, b.String())
+}
+
+// String returns the clause with a leading space, or "" when there are no
+// conditions.
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// next returns the placeholder for an argument appended after the
+// conditions, e.g. LIMIT and OFFSET.
+func (w *whereClause) next(arg any) string {
+	w.args = append(w.args, arg)
+	return "$" + strconv.Itoa(len(w.args))
+}
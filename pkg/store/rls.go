@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// scope is who the queries made with a context run as.
+type scope struct {
+	// tenant and user are app.tenant_id and app.user_id; user is "" before
+	// the user is known.
+	tenant, user string
+	// system runs the queries as the system role, which bypasses
+	// row-level security.
+	system bool
+}
+
+type scopeKey struct{}
+
+// WithUser scopes the queries made with ctx to the user in the tenant. On
+// Postgres each transaction runs with app.tenant_id and app.user_id set
+// to them, which the row-level security policies of migration 0036 check,
+// so a query that forgets its user_id condition still can't reach another
+// user's rows, nor a user outside the tenant. Queries made with a context
+// that isn't scoped see no rows at all. SQLite has no row-level security
+// and ignores the scope.
+func WithUser(ctx context.Context, tenantID, userID int) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope{tenant: strconv.Itoa(tenantID), user: strconv.Itoa(userID)})
+}
+
+// WithTenant scopes the queries made with ctx to the tenant before a user
+// is known, as when signing up or logging in: they see the tenant's
+// accounts, and no user's data.
+func WithTenant(ctx context.Context, tenantID int) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope{tenant: strconv.Itoa(tenantID)})
+}
+
+// AsSystem runs the queries made with ctx as the system role given to
+// UseSystemRole, which bypasses row-level security, for background jobs,
+// the admin API and lookups that find the user, like a refresh token's.
+// Without a system role they run as the connection's own role, which
+// UseSystemRole checked bypasses row-level security itself.
+func AsSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope{system: true})
+}
+
+func scopeOf(ctx context.Context) (scope, bool) {
+	s, ok := ctx.Value(scopeKey{}).(scope)
+	return s, ok
+}
+
+// connectors records the scopedConnector behind each Postgres database Open
+// returned, for UseSystemRole.
+var connectors sync.Map
+
+// UseSystemRole sets up the role AsSystem runs queries as. role must exist,
+// bypass row-level security and be granted to the role the server connects
+// as; it's granted what it needs on the schema's tables. With no role, the
+// connection's own role must bypass row-level security, as a superuser
+// does, and only a warning is logged, since then the policies don't apply
+// to the server at all. SQLite has no roles and needs none.
+func UseSystemRole(ctx context.Context, db *sql.DB, role string) error {
+	c, ok := connectors.Load(db)
+	if !ok {
+		return nil
+	}
+	var user string
+	var bypasses bool
+	if err := db.QueryRowContext(ctx, "SELECT rolname, rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user").Scan(&user, &bypasses); err != nil {
+		return err
+	}
+	if role == "" {
+		if !bypasses {
+			return fmt.Errorf("role %s is subject to row-level security, so background jobs and the admin API need a system role that bypasses it", user)
+		}
+		slog.Warn("database role bypasses row-level security; the policies aren't enforced", "role", user)
+		return nil
+	}
+
+	var bypassRLS, member bool
+	err := db.QueryRowContext(ctx, "SELECT rolbypassrls, pg_has_role(current_user, oid, 'MEMBER') FROM pg_roles WHERE rolname = $1", role).Scan(&bypassRLS, &member)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("system role %s doesn't exist", role)
+	}
+	if err != nil {
+		return err
+	}
+	if !bypassRLS {
+		return fmt.Errorf("system role %s doesn't have BYPASSRLS", role)
+	}
+	if !member {
+		return fmt.Errorf("system role %s isn't granted to %s", role, user)
+	}
+	// The role only gets what the server uses, on the tables the
+	// migrations made so far.
+	var schema string
+	if err := db.QueryRowContext(ctx, "SELECT current_schema()").Scan(&schema); err != nil {
+		return err
+	}
+	name, in := pgx.Identifier{role}.Sanitize(), pgx.Identifier{schema}.Sanitize()
+	if _, err := db.ExecContext(ctx,
+		"GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA "+in+" TO "+name+"; "+
+			"GRANT USAGE, SELECT, UPDATE ON ALL SEQUENCES IN SCHEMA "+in+" TO "+name); err != nil {
+		return fmt.Errorf("granting system role %s the tables: %w", role, err)
+	}
+	c.(*scopedConnector).systemRole.Store(&name)
+	return nil
+}
+
+// scopedConnector opens Postgres connections that scope each transaction
+// as its context says.
+type scopedConnector struct {
+	driver.Connector
+	// systemRole is the quoted name of the role AsSystem runs as, or nil
+	// for none.
+	systemRole atomic.Pointer[string]
+}
+
+func (c *scopedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &scopedConn{Conn: conn.(*stdlib.Conn), connector: c}, nil
+}
+
+// scopedConn sets a transaction's scope with SET LOCAL semantics as it
+// begins, so it reverts when the transaction ends and never outlives it
+// on the pooled connection. A scoped query outside a transaction runs in
+// one of its own, begun in the same round trip as the scope is set.
+type scopedConn struct {
+	*stdlib.Conn
+	connector *scopedConnector
+	inTx      bool
+}
+
+// scopeSQL is what sets a transaction's scope for ctx, or "" if it needs
+// nothing set.
+func (c *scopedConn) scopeSQL(ctx context.Context) string {
+	s, ok := scopeOf(ctx)
+	switch {
+	case !ok:
+		return ""
+	case s.system:
+		if role := c.connector.systemRole.Load(); role != nil {
+			return "SET LOCAL ROLE " + *role
+		}
+		return ""
+	}
+	// Both values are formatted integers, so they're safe to inline.
+	return "SELECT set_config('app.tenant_id', '" + s.tenant + "', true), set_config('app.user_id', '" + s.user + "', true)"
+}
+
+// run runs a query, in an implicit transaction if ctx needs a scope set
+// and there's no transaction already. The transaction ends when the query
+// does, or for rows when they're closed.
+func (c *scopedConn) run(ctx context.Context, query func() (any, error)) (any, error) {
+	set := c.scopeSQL(ctx)
+	if c.inTx || set == "" {
+		return query()
+	}
+	if _, err := c.Conn.ExecContext(ctx, "BEGIN; "+set, nil); err != nil {
+		c.end(ctx, err)
+		return nil, err
+	}
+	v, err := query()
+	if rows, ok := v.(*stdlib.Rows); ok && err == nil {
+		c.inTx = true
+		return &scopedRows{Rows: rows, ctx: ctx, conn: c}, nil
+	}
+	if err := c.end(ctx, err); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// end ends an implicit transaction: it commits after err nil and rolls
+// back after an error, which it returns.
+func (c *scopedConn) end(ctx context.Context, err error) error {
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		c.Conn.ExecContext(ctx, "ROLLBACK", nil)
+		return err
+	}
+	_, err = c.Conn.ExecContext(ctx, "COMMIT", nil)
+	return err
+}
+
+func (c *scopedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	v, err := c.run(ctx, func() (any, error) { return c.Conn.ExecContext(ctx, query, args) })
+	res, _ := v.(driver.Result)
+	return res, err
+}
+
+func (c *scopedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	v, err := c.run(ctx, func() (any, error) { return c.Conn.QueryContext(ctx, query, args) })
+	rows, _ := v.(driver.Rows)
+	return rows, err
+}
+
+func (c *scopedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scopedStmt{Stmt: stmt.(*stdlib.Stmt), conn: c}, nil
+}
+
+func (c *scopedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if set := c.scopeSQL(ctx); set != "" {
+		if _, err := c.Conn.ExecContext(ctx, set, nil); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	c.inTx = true
+	return scopedTx{Tx: tx, conn: c}, nil
+}
+
+type scopedStmt struct {
+	*stdlib.Stmt
+	conn *scopedConn
+}
+
+func (s scopedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	v, err := s.conn.run(ctx, func() (any, error) { return s.Stmt.ExecContext(ctx, args) })
+	res, _ := v.(driver.Result)
+	return res, err
+}
+
+func (s scopedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	v, err := s.conn.run(ctx, func() (any, error) { return s.Stmt.QueryContext(ctx, args) })
+	rows, _ := v.(driver.Rows)
+	return rows, err
+}
+
+type scopedTx struct {
+	driver.Tx
+	conn *scopedConn
+}
+
+func (t scopedTx) Commit() error {
+	t.conn.inTx = false
+	return t.Tx.Commit()
+}
+
+func (t scopedTx) Rollback() error {
+	t.conn.inTx = false
+	return t.Tx.Rollback()
+}
+
+// scopedRows are the rows of a query run in an implicit transaction, which
+// ends when they're closed.
+type scopedRows struct {
+	*stdlib.Rows
+	ctx  context.Context
+	conn *scopedConn
+}
+
+func (r *scopedRows) Close() error {
+	if !r.conn.inTx {
+		return nil
+	}
+	r.conn.inTx = false
+	return r.conn.end(r.ctx, r.Rows.Close())
+}
@@ -0,0 +1,50 @@
+// Package store owns the database connection and the schema, which is built
+// from the versioned SQL migrations embedded from migrations/. Postgres and
+// SQLite are supported.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Init applies any pending migrations. The server doesn't call it: it
+// needs the schema to be up to date already (see CheckSchema), and the
+// migrate command applies them.
+func Init(db *sql.DB) error {
+	_, err := MigrateUp(db)
+	return err
+}
+
+// CheckSchema returns an error naming the pending migrations if any
+// embedded migration hasn't been applied. A database migrated by a newer
+// binary passes, so the old one keeps running during a rolling deploy.
+func CheckSchema(db *sql.DB) error {
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	switch {
+	case len(pending) == 1:
+		return fmt.Errorf("migration %s is pending; run migrate up", pending[0])
+	case len(pending) > 1:
+		return fmt.Errorf("%d migrations are pending, %s to %s; run migrate up", len(pending), pending[0], pending[len(pending)-1])
+	}
+	return nil
+}
+
+// CurrentSchemaVersion reads the newest version recorded in
+// schema_migrations, or 0 if none has been applied.
+func CurrentSchemaVersion(db *sql.DB) (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"subscription-tracker/pkg/models"
+)
+
+// ErrNotFound is returned when a record doesn't exist or belongs to another
+// user.
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a record would take a name the user
+// already has.
+var ErrDuplicate = errors.New("already exists")
+
+// ErrLimitReached is returned when creating subscriptions would take the
+// user past the most they may have.
+var ErrLimitReached = errors.New("limit reached")
+
+// Fields subscriptions can be sorted by.
+const (
+	SortByName         = "name"
+	SortByCategory     = "category"
+	SortByCost         = "cost"
+	SortByBillingCycle = "billingCycle"
+	SortByNextBilling  = "nextBilling"
+	SortByCreatedAt    = "createdAt"
+	SortByUpdatedAt    = "updatedAt"
+)
+
+// SortKey orders a list by one field.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SubscriptionQuery selects and orders a user's subscriptions. Zero-valued
+// filters match everything.
+type SubscriptionQuery struct {
+	Category     string
+	BillingCycle string
+	MinCost      *models.Money
+	MaxCost      *models.Money
+	// NextBillingAfter and NextBillingBefore are exclusive bounds.
+	NextBillingAfter  models.Date
+	NextBillingBefore models.Date
+	// Tag matches subscriptions carrying the named tag.
+	Tag string
+	// Trial, when set, matches only trials or only non-trials.
+	Trial *bool
+	// Status matches subscriptions with the given status.
+	Status string
+	// Archived, when set, matches only archived or only unarchived
+	// subscriptions.
+	Archived *bool
+	// CreatedAfter, CreatedBefore, UpdatedAfter and UpdatedBefore are
+	// exclusive bounds on CreatedAt and UpdatedAt.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+
+	// Sort defaults to next billing date ascending. Ties are always broken
+	// by ID so pages are stable.
+	Sort []SortKey
+	// Limit of zero returns every match.
+	Limit  int
+	Offset int
+}
+
+// SubscriptionRepository stores subscriptions. Every method is scoped to
+// one user; other users' records behave as if they don't exist.
+//
+// Repositories don't know about staleness: they record LastVerifiedAt and
+// leave Stale for the caller to work out.
+type SubscriptionRepository interface {
+	// List returns one page of matches and the total number of matches.
+	List(ctx context.Context, userID int, q SubscriptionQuery) ([]models.Subscription, int, error)
+	Get(ctx context.Context, userID, id int) (models.Subscription, error)
+	// Create stores s as created and verified at verifiedAt and returns it
+	// with its ID. Unless limit is 0, it returns ErrLimitReached instead if
+	// the user already has limit subscriptions; the count and the insert
+	// are atomic, so concurrent creates can't both take the last one.
+	Create(ctx context.Context, userID int, s models.Subscription, verifiedAt time.Time, limit int64) (models.Subscription, error)
+	// CreateMany stores every subscription or, if any insert fails or they
+	// would take the user past limit, none.
+	CreateMany(ctx context.Context, userID int, subs []models.Subscription, verifiedAt time.Time, limit int64) ([]models.Subscription, error)
+	// Update replaces the subscription with ID s.ID and marks it updated
+	// and verified at verifiedAt. It returns ErrNotFound unless s.Version
+	// is still the stored version.
+	Update(ctx context.Context, userID int, s models.Subscription, verifiedAt time.Time) (models.Subscription, error)
+	Delete(ctx context.Context, userID, id int) error
+	// Verify records a confirmation at the given time. An older
+	// confirmation never replaces a newer one.
+	Verify(ctx context.Context, userID, id int, at time.Time) error
+	Count(ctx context.Context, userID int) (int64, error)
+
+	// Due lists every user's active subscriptions whose next billing date
+	// is before the given date. Like TrialsEnded it isn't scoped to a
+	// user, for the roll-forward job. Tags are left unset.
+	Due(ctx context.Context, before models.Date) ([]DueSubscription, error)
+	// Advance moves a subscription's next billing date from from to to and
+	// records the passed dates in billed, all or nothing. It returns
+	// ErrNotFound if the date is no longer from, say because the user
+	// changed it in the meantime.
+	//
+	// Advance, EndTrial and SetStatus record at as the subscription's
+	// UpdatedAt.
+	Advance(ctx context.Context, userID, id int, from, to models.Date, billed []models.BillingEvent, at time.Time) error
+	// History lists a subscription's recorded billing events, newest first.
+	History(ctx context.Context, userID, id int) ([]models.BillingEvent, error)
+
+	// TrialsEnded lists every user's active trials that end on or before
+	// the YYYY-MM-DD date, for the trial job. Tags are left unset.
+	TrialsEnded(ctx context.Context, through string) ([]DueSubscription, error)
+	// EndTrial makes a trial a regular subscription. It returns
+	// ErrNotFound if it's no longer a trial ending on endsAt, say because
+	// the user changed it in the meantime.
+	EndTrial(ctx context.Context, userID, id int, endsAt string, at time.Time) error
+
+	// Search finds the user's subscriptions matching every one of terms,
+	// from SearchTerms, in their name, category or description, best
+	// match first. It returns at most limit.
+	Search(ctx context.Context, userID int, terms []string, limit int) ([]SearchResult, error)
+
+	// Update leaves the status and archiving alone; SetStatus and
+	// SetArchived are the only ways to change them.
+
+	// SetStatus moves a subscription from status from to to, setting its
+	// cancellation date and reason, which are nil unless it's cancelled.
+	// It returns ErrNotFound if the status is no longer from.
+	SetStatus(ctx context.Context, userID, id int, from, to string, cancelledAt, reason *string, at time.Time) error
+	// SetArchived archives the subscription at at, or unarchives it if
+	// archived is false. Either way at is its UpdatedAt.
+	SetArchived(ctx context.Context, userID, id int, archived bool, at time.Time) error
+
+	// Subscriptions refer to tags by name, and tags named on a subscription
+	// that the user doesn't have yet are created with it. Tag names are
+	// compared after NormalizeTag.
+
+	// Tags lists the user's tags by name, with how many subscriptions
+	// carry each.
+	Tags(ctx context.Context, userID int) ([]models.Tag, error)
+	// CreateTag returns ErrDuplicate if the user already has the name.
+	CreateTag(ctx context.Context, userID int, name string) (models.Tag, error)
+	// RenameTag renames the tag on every subscription carrying it. It
+	// returns ErrDuplicate if another of the user's tags has the name.
+	RenameTag(ctx context.Context, userID, id int, name string) (models.Tag, error)
+	// DeleteTag removes the tag from every subscription carrying it.
+	DeleteTag(ctx context.Context, userID, id int) error
+}
+
+// DueSubscription is a subscription returned by Due, with its owner.
+type DueSubscription struct {
+	UserID int
+	models.Subscription
+}
+
+// SearchResult is a subscription found by Search. Rank orders results and
+// has no meaning of its own; Postgres and the LIKE fallback compute it
+// differently.
+type SearchResult struct {
+	models.Subscription
+	Rank float64
+}
+
+// SearchTerms splits a search query into the terms Search matches: its
+// words, lower-cased and without repeats. Anything but letters and digits
+// separates words. Each term matches the start of a word in Postgres and
+// anywhere in a field elsewhere.
+func SearchTerms(q string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !slices.Contains(terms, word) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// Field weights for search ranking, the defaults Postgres's ts_rank gives
+// the A, B and C weights the search index uses.
+const (
+	nameWeight        = 1.0
+	categoryWeight    = 0.4
+	descriptionWeight = 0.2
+)
+
+// matchRank ranks s for the LIKE fallback: each term adds the weight of
+// every field containing it. It is zero unless every term matches.
+func matchRank(s models.Subscription, terms []string) float64 {
+	var rank float64
+	for _, term := range terms {
+		var r float64
+		for _, f := range []struct {
+			text   string
+			weight float64
+		}{{s.Name, nameWeight}, {s.Category, categoryWeight}, {s.Description, descriptionWeight}} {
+			if strings.Contains(strings.ToLower(f.text), term) {
+				r += f.weight
+			}
+		}
+		if r == 0 {
+			return 0
+		}
+		rank += r
+	}
+	return rank
+}
+
+// sortSearchResults orders results best first, then by name and ID, and
+// keeps at most limit.
+func sortSearchResults(results []SearchResult, limit int) []SearchResult {
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Rank, a.Rank); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return results[:min(len(results), limit)]
+}
+
+// withDefaults fills in DefaultCurrency for a subscription given without
+// a currency and StatusActive for one without a status, and puts its tags
+// in stored form: normalized, sorted and without repeats.
+func withDefaults(s models.Subscription) models.Subscription {
+	if s.Currency == "" {
+		s.Currency = models.DefaultCurrency
+	}
+	if s.Status == "" {
+		s.Status = models.StatusActive
+	}
+	tags := make([]string, 0, len(s.Tags))
+	for _, t := range s.Tags {
+		tags = append(tags, NormalizeTag(t))
+	}
+	slices.Sort(tags)
+	s.Tags = slices.Compact(tags)
+	return s
+}
+
+// NormalizeTag is the form tag names are stored and matched in: trimmed
+// and lower-cased, so "Work" and "work " are the same tag.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// trialDate reads a stored date, such as a trial's end, which drivers can
+// return as a date or a timestamp.
+func trialDate(v string) *string {
+	v = v[:min(len(v), len(time.DateOnly))]
+	return &v
+}
+
+func formatVerified(t time.Time) *string {
+	v := formatTime(t)
+	return &v
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,466 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// MemorySubscriptions is a SubscriptionRepository held in memory, for tests
+// and for embedding the engine without a database. Data is lost when the
+// process exits.
+type MemorySubscriptions struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]memorySubscription
+	// history holds each subscription's billing events, oldest first.
+	history     map[int][]models.BillingEvent
+	nextEventID int
+	tags        map[int]memoryTag
+	nextTagID   int
+}
+
+// memorySubscription holds a subscription's tags by ID, so renaming a tag
+// renames it everywhere; sub.Tags is filled in by tagged.
+type memorySubscription struct {
+	userID       int
+	sub          models.Subscription
+	lastVerified time.Time
+	tags         []int
+}
+
+type memoryTag struct {
+	userID int
+	name   string
+}
+
+func NewMemorySubscriptions() *MemorySubscriptions {
+	return &MemorySubscriptions{
+		nextID:      1,
+		subs:        map[int]memorySubscription{},
+		history:     map[int][]models.BillingEvent{},
+		nextEventID: 1,
+		tags:        map[int]memoryTag{},
+		nextTagID:   1,
+	}
+}
+
+// tagged returns r's subscription with the names of its tags.
+func (m *MemorySubscriptions) tagged(r memorySubscription) models.Subscription {
+	s := r.sub
+	s.Tags = []string{}
+	for _, id := range r.tags {
+		s.Tags = append(s.Tags, m.tags[id].name)
+	}
+	slices.Sort(s.Tags)
+	return s
+}
+
+// tagIDs returns the IDs of the user's tags with the given names, creating
+// any the user doesn't have yet.
+func (m *MemorySubscriptions) tagIDs(userID int, names []string) []int {
+	ids := make([]int, 0, len(names))
+	for _, name := range names {
+		id, ok := m.tagID(userID, name)
+		if !ok {
+			id = m.nextTagID
+			m.nextTagID++
+			m.tags[id] = memoryTag{userID: userID, name: name}
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (m *MemorySubscriptions) tagID(userID int, name string) (int, bool) {
+	for id, t := range m.tags {
+		if t.userID == userID && t.name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// store saves s, which has its tags in stored form, for userID.
+func (m *MemorySubscriptions) store(userID int, s models.Subscription, verifiedAt time.Time) {
+	r := memorySubscription{userID: userID, sub: s, lastVerified: verifiedAt, tags: m.tagIDs(userID, s.Tags)}
+	r.sub.Tags = nil
+	m.subs[s.ID] = r
+}
+
+// subscriptionCompare orders subscriptions by each sort field.
+var subscriptionCompare = map[string]func(a, b models.Subscription) int{
+	SortByName:         func(a, b models.Subscription) int { return strings.Compare(a.Name, b.Name) },
+	SortByCategory:     func(a, b models.Subscription) int { return strings.Compare(a.Category, b.Category) },
+	SortByCost:         func(a, b models.Subscription) int { return cmp.Compare(a.Cost, b.Cost) },
+	SortByBillingCycle: func(a, b models.Subscription) int { return strings.Compare(a.BillingCycle, b.BillingCycle) },
+	SortByNextBilling:  func(a, b models.Subscription) int { return a.NextBilling.Compare(b.NextBilling) },
+	SortByCreatedAt:    func(a, b models.Subscription) int { return timestamp(a.CreatedAt).Compare(timestamp(b.CreatedAt)) },
+	SortByUpdatedAt:    func(a, b models.Subscription) int { return timestamp(a.UpdatedAt).Compare(timestamp(b.UpdatedAt)) },
+}
+
+// timestamp parses CreatedAt or UpdatedAt, which may carry different
+// offsets and so can't be compared as strings.
+func timestamp(v string) time.Time {
+	t, _ := time.Parse(time.RFC3339, v)
+	return t
+}
+
+func (q SubscriptionQuery) matches(s models.Subscription) bool {
+	date := s.NextBilling
+	created, updated := timestamp(s.CreatedAt), timestamp(s.UpdatedAt)
+	return (q.Category == "" || s.Category == q.Category) &&
+		(q.BillingCycle == "" || s.BillingCycle == q.BillingCycle) &&
+		(q.MinCost == nil || s.Cost >= *q.MinCost) &&
+		(q.MaxCost == nil || s.Cost <= *q.MaxCost) &&
+		(q.NextBillingAfter.IsZero() || date.After(q.NextBillingAfter)) &&
+		(q.NextBillingBefore.IsZero() || date.Before(q.NextBillingBefore)) &&
+		(q.Trial == nil || s.IsTrial == *q.Trial) &&
+		(q.Status == "" || s.Status == q.Status) &&
+		(q.Archived == nil || (s.ArchivedAt != nil) == *q.Archived) &&
+		(q.CreatedAfter.IsZero() || created.After(q.CreatedAfter)) &&
+		(q.CreatedBefore.IsZero() || created.Before(q.CreatedBefore)) &&
+		(q.UpdatedAfter.IsZero() || updated.After(q.UpdatedAfter)) &&
+		(q.UpdatedBefore.IsZero() || updated.Before(q.UpdatedBefore)) &&
+		(q.Tag == "" || slices.Contains(s.Tags, NormalizeTag(q.Tag)))
+}
+
+func (m *MemorySubscriptions) List(_ context.Context, userID int, q SubscriptionQuery) ([]models.Subscription, int, error) {
+	sort := q.Sort
+	if len(sort) == 0 {
+		sort = []SortKey{{Field: SortByNextBilling}}
+	}
+	for _, key := range sort {
+		if _, ok := subscriptionCompare[key.Field]; !ok {
+			return nil, 0, fmt.Errorf("cannot sort by %q", key.Field)
+		}
+	}
+
+	m.mu.Lock()
+	var matched []models.Subscription
+	for _, r := range m.subs {
+		if s := m.tagged(r); r.userID == userID && q.matches(s) {
+			matched = append(matched, s)
+		}
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(matched, func(a, b models.Subscription) int {
+		for _, key := range sort {
+			c := subscriptionCompare[key.Field](a, b)
+			if key.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	total := len(matched)
+	page := matched[min(q.Offset, total):]
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+	}
+	return append([]models.Subscription{}, page...), total, nil
+}
+
+func (m *MemorySubscriptions) Get(_ context.Context, userID, id int) (models.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID {
+		return models.Subscription{}, ErrNotFound
+	}
+	return m.tagged(r), nil
+}
+
+func (m *MemorySubscriptions) Create(_ context.Context, userID int, s models.Subscription, verifiedAt time.Time, limit int64) (models.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit > 0 && m.count(userID)+1 > limit {
+		return models.Subscription{}, ErrLimitReached
+	}
+	s.ID = m.nextID
+	m.nextID++
+	s = withDefaults(s)
+	s.LastVerifiedAt, s.Version = formatVerified(verifiedAt), 1
+	s.CreatedAt, s.UpdatedAt = formatTime(verifiedAt), formatTime(verifiedAt)
+	m.store(userID, s, verifiedAt)
+	return s, nil
+}
+
+func (m *MemorySubscriptions) CreateMany(_ context.Context, userID int, subs []models.Subscription, verifiedAt time.Time, limit int64) ([]models.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit > 0 && m.count(userID)+int64(len(subs)) > limit {
+		return nil, ErrLimitReached
+	}
+	created := make([]models.Subscription, 0, len(subs))
+	for _, s := range subs {
+		s.ID = m.nextID
+		m.nextID++
+		s = withDefaults(s)
+		s.LastVerifiedAt, s.Version = formatVerified(verifiedAt), 1
+		s.CreatedAt, s.UpdatedAt = formatTime(verifiedAt), formatTime(verifiedAt)
+		m.store(userID, s, verifiedAt)
+		created = append(created, s)
+	}
+	return created, nil
+}
+
+func (m *MemorySubscriptions) Update(_ context.Context, userID int, s models.Subscription, verifiedAt time.Time) (models.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[s.ID]
+	if !ok || r.userID != userID || s.Version != r.sub.Version {
+		return s, ErrNotFound
+	}
+	s = withDefaults(s)
+	s.Status, s.CancelledAt, s.CancellationReason = r.sub.Status, r.sub.CancelledAt, r.sub.CancellationReason
+	s.ArchivedAt = r.sub.ArchivedAt
+	s.LastVerifiedAt, s.Version = formatVerified(verifiedAt), r.sub.Version+1
+	s.CreatedAt, s.UpdatedAt = r.sub.CreatedAt, formatTime(verifiedAt)
+	m.store(userID, s, verifiedAt)
+	return s, nil
+}
+
+func (m *MemorySubscriptions) Delete(_ context.Context, userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.subs[id]; !ok || r.userID != userID {
+		return ErrNotFound
+	}
+	delete(m.subs, id)
+	delete(m.history, id)
+	return nil
+}
+
+func (m *MemorySubscriptions) Verify(_ context.Context, userID, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID {
+		return ErrNotFound
+	}
+	if at.After(r.lastVerified) {
+		r.lastVerified = at
+		r.sub.LastVerifiedAt = formatVerified(at)
+	}
+	r.sub.Version++
+	m.subs[id] = r
+	return nil
+}
+
+func (m *MemorySubscriptions) Count(_ context.Context, userID int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.count(userID), nil
+}
+
+// count is Count with m.mu held.
+func (m *MemorySubscriptions) count(userID int) int64 {
+	var n int64
+	for _, r := range m.subs {
+		if r.userID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemorySubscriptions) Due(_ context.Context, before models.Date) ([]DueSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []DueSubscription
+	for _, r := range m.subs {
+		if r.sub.Status == models.StatusActive && r.sub.NextBilling.Before(before) {
+			due = append(due, DueSubscription{UserID: r.userID, Subscription: r.sub})
+		}
+	}
+	slices.SortFunc(due, func(a, b DueSubscription) int { return cmp.Compare(a.ID, b.ID) })
+	return due, nil
+}
+
+func (m *MemorySubscriptions) Advance(_ context.Context, userID, id int, from, to models.Date, billed []models.BillingEvent, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID || r.sub.NextBilling != from {
+		return ErrNotFound
+	}
+	r.sub.NextBilling = to
+	r.sub.UpdatedAt = formatTime(at)
+	r.sub.Version++
+	m.subs[id] = r
+
+	recorded := formatVerified(time.Now())
+	for _, e := range billed {
+		if slices.ContainsFunc(m.history[id], func(h models.BillingEvent) bool { return h.Date == e.Date }) {
+			continue
+		}
+		e.ID, e.SubscriptionID, e.RecordedAt = m.nextEventID, id, *recorded
+		m.nextEventID++
+		m.history[id] = append(m.history[id], e)
+	}
+	return nil
+}
+
+func (m *MemorySubscriptions) History(_ context.Context, userID, id int) ([]models.BillingEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.subs[id]; !ok || r.userID != userID {
+		return nil, ErrNotFound
+	}
+	history := append([]models.BillingEvent{}, m.history[id]...)
+	slices.SortFunc(history, func(a, b models.BillingEvent) int { return strings.Compare(b.Date, a.Date) })
+	return history, nil
+}
+
+func (m *MemorySubscriptions) TrialsEnded(_ context.Context, through string) ([]DueSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ended []DueSubscription
+	for _, r := range m.subs {
+		if r.sub.Status == models.StatusActive && r.sub.IsTrial && r.sub.TrialEndsAt != nil && *r.sub.TrialEndsAt <= through {
+			ended = append(ended, DueSubscription{UserID: r.userID, Subscription: r.sub})
+		}
+	}
+	slices.SortFunc(ended, func(a, b DueSubscription) int { return cmp.Compare(a.ID, b.ID) })
+	return ended, nil
+}
+
+func (m *MemorySubscriptions) EndTrial(_ context.Context, userID, id int, endsAt string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID || !r.sub.IsTrial || r.sub.TrialEndsAt == nil || *r.sub.TrialEndsAt != endsAt {
+		return ErrNotFound
+	}
+	r.sub.IsTrial = false
+	r.sub.UpdatedAt = formatTime(at)
+	r.sub.Version++
+	m.subs[id] = r
+	return nil
+}
+
+func (m *MemorySubscriptions) SetStatus(_ context.Context, userID, id int, from, to string, cancelledAt, reason *string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID || r.sub.Status != from {
+		return ErrNotFound
+	}
+	r.sub.Status, r.sub.CancelledAt, r.sub.CancellationReason = to, cancelledAt, reason
+	r.sub.UpdatedAt = formatTime(at)
+	r.sub.Version++
+	m.subs[id] = r
+	return nil
+}
+
+func (m *MemorySubscriptions) SetArchived(_ context.Context, userID, id int, archived bool, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.subs[id]
+	if !ok || r.userID != userID {
+		return ErrNotFound
+	}
+	r.sub.ArchivedAt = nil
+	if archived {
+		r.sub.ArchivedAt = formatVerified(at)
+	}
+	r.sub.UpdatedAt = formatTime(at)
+	r.sub.Version++
+	m.subs[id] = r
+	return nil
+}
+
+func (m *MemorySubscriptions) Search(_ context.Context, userID int, terms []string, limit int) ([]SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+	for _, r := range m.subs {
+		s := m.tagged(r)
+		if rank := matchRank(s, terms); r.userID == userID && rank > 0 {
+			results = append(results, SearchResult{Subscription: s, Rank: rank})
+		}
+	}
+	return sortSearchResults(results, limit), nil
+}
+
+func (m *MemorySubscriptions) Tags(_ context.Context, userID int) ([]models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[int]int{}
+	for _, r := range m.subs {
+		for _, id := range r.tags {
+			counts[id]++
+		}
+	}
+	tags := []models.Tag{}
+	for id, t := range m.tags {
+		if t.userID == userID {
+			tags = append(tags, models.Tag{ID: id, Name: t.name, Subscriptions: counts[id]})
+		}
+	}
+	slices.SortFunc(tags, func(a, b models.Tag) int { return strings.Compare(a.Name, b.Name) })
+	return tags, nil
+}
+
+func (m *MemorySubscriptions) CreateTag(_ context.Context, userID int, name string) (models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = NormalizeTag(name)
+	if _, ok := m.tagID(userID, name); ok {
+		return models.Tag{Name: name}, ErrDuplicate
+	}
+	return models.Tag{ID: m.tagIDs(userID, []string{name})[0], Name: name}, nil
+}
+
+func (m *MemorySubscriptions) RenameTag(_ context.Context, userID, id int, name string) (models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = NormalizeTag(name)
+	t, ok := m.tags[id]
+	if !ok || t.userID != userID {
+		return models.Tag{}, ErrNotFound
+	}
+	if other, ok := m.tagID(userID, name); ok && other != id {
+		return models.Tag{ID: id, Name: name}, ErrDuplicate
+	}
+	m.tags[id] = memoryTag{userID: userID, name: name}
+
+	tag := models.Tag{ID: id, Name: name}
+	for _, r := range m.subs {
+		if slices.Contains(r.tags, id) {
+			tag.Subscriptions++
+		}
+	}
+	return tag, nil
+}
+
+func (m *MemorySubscriptions) DeleteTag(_ context.Context, userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tags[id]; !ok || t.userID != userID {
+		return ErrNotFound
+	}
+	delete(m.tags, id)
+	for subID, r := range m.subs {
+		if i := slices.Index(r.tags, id); i >= 0 {
+			r.tags = slices.Delete(slices.Clone(r.tags), i, i+1)
+			m.subs[subID] = r
+		}
+	}
+	return nil
+}
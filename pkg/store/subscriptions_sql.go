@@ -0,0 +1,703 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"subscription-tracker/pkg/models"
+)
+
+// SQLSubscriptions is the SubscriptionRepository backed by the
+// subscriptions table. Its queries work on both Postgres and SQLite.
+type SQLSubscriptions struct {
+	db *sql.DB
+	// stmts runs queries as prepared statements, except those like
+	// loadTags's whose text changes with the number of arguments.
+	stmts *Statements
+}
+
+func NewSQLSubscriptions(db *sql.DB) *SQLSubscriptions {
+	return &SQLSubscriptions{db: db, stmts: NewStatements(db)}
+}
+
+// subscriptionColumns is the column list scanSubscription expects. The
+// description column is nullable, and rows written outside the API may
+// leave it unset.
+const subscriptionColumns = `id, name, category, cost_cents, currency, billing_cycle, next_billing, COALESCE(description, ''), last_verified_at, is_trial, trial_ends_at, status, cancelled_at, cancellation_reason, archived_at, version, created_at, updated_at`
+
+// subscriptionSortColumns maps sort fields to columns.
+var subscriptionSortColumns = map[string]string{
+	SortByName:         "name",
+	SortByCategory:     "category",
+	SortByCost:         "cost_cents",
+	SortByBillingCycle: "billing_cycle",
+	SortByNextBilling:  "next_billing",
+	SortByCreatedAt:    "created_at",
+	SortByUpdatedAt:    "updated_at",
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSubscription scans subscriptionColumns, after any columns in
+// leading.
+func scanSubscription(scanner rowScanner, s *models.Subscription, leading ...any) error {
+	var lastVerified, archived sql.NullTime
+	var trialEnds, cancelledAt, reason sql.NullString
+	var created, updated time.Time
+	dest := append(leading, &s.ID, &s.Name, &s.Category, &s.Cost, &s.Currency, &s.BillingCycle, &s.NextBilling, &s.Description, &lastVerified,
+		&s.IsTrial, &trialEnds, &s.Status, &cancelledAt, &reason, &archived, &s.Version, &created, &updated)
+	if err := scanner.Scan(dest...); err != nil {
+		return err
+	}
+	s.CreatedAt, s.UpdatedAt = formatTime(created), formatTime(updated)
+	if lastVerified.Valid {
+		s.LastVerifiedAt = formatVerified(lastVerified.Time)
+	}
+	if trialEnds.Valid {
+		s.TrialEndsAt = trialDate(trialEnds.String)
+	}
+	if cancelledAt.Valid {
+		s.CancelledAt = trialDate(cancelledAt.String)
+	}
+	if reason.Valid {
+		s.CancellationReason = &reason.String
+	}
+	if archived.Valid {
+		s.ArchivedAt = formatVerified(archived.Time)
+	}
+	return nil
+}
+
+func (p *SQLSubscriptions) List(ctx context.Context, userID int, q SubscriptionQuery) ([]models.Subscription, int, error) {
+	where := &whereClause{}
+	where.add("user_id = ?", userID)
+	if q.Category != "" {
+		where.add("category = ?", q.Category)
+	}
+	if q.BillingCycle != "" {
+		where.add("billing_cycle = ?", q.BillingCycle)
+	}
+	if q.MinCost != nil {
+		where.add("cost_cents >= ?", *q.MinCost)
+	}
+	if q.MaxCost != nil {
+		where.add("cost_cents <= ?", *q.MaxCost)
+	}
+	if !q.NextBillingAfter.IsZero() {
+		where.add("next_billing > ?", q.NextBillingAfter)
+	}
+	if !q.NextBillingBefore.IsZero() {
+		where.add("next_billing < ?", q.NextBillingBefore)
+	}
+	if q.Trial != nil {
+		where.add("is_trial = ?", *q.Trial)
+	}
+	if q.Status != "" {
+		where.add("status = ?", q.Status)
+	}
+	if q.Archived != nil {
+		if *q.Archived {
+			where.add("archived_at IS NOT NULL")
+		} else {
+			where.add("archived_at IS NULL")
+		}
+	}
+	for _, b := range []struct {
+		cond string
+		at   time.Time
+	}{
+		{"created_at > ?", q.CreatedAfter},
+		{"created_at < ?", q.CreatedBefore},
+		{"updated_at > ?", q.UpdatedAfter},
+		{"updated_at < ?", q.UpdatedBefore},
+	} {
+		if !b.at.IsZero() {
+			where.add(b.cond, b.at)
+		}
+	}
+	if q.Tag != "" {
+		where.add(`id IN (
+			SELECT st.subscription_id FROM subscription_tags st JOIN tags t ON t.id = st.tag_id
+			WHERE t.user_id = ? AND t.name = ?
+		)`, userID, NormalizeTag(q.Tag))
+	}
+
+	order := "next_billing ASC"
+	if len(q.Sort) > 0 {
+		order = ""
+		for i, key := range q.Sort {
+			column, ok := subscriptionSortColumns[key.Field]
+			if !ok {
+				return nil, 0, fmt.Errorf("cannot sort by %q", key.Field)
+			}
+			if i > 0 {
+				order += ", "
+			}
+			if key.Desc {
+				order += column + " DESC"
+			} else {
+				order += column + " ASC"
+			}
+		}
+	}
+
+	var total int
+	if err := p.stmts.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions"+where.String(), where.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions" + where.String() + " ORDER BY " + order + ", id ASC"
+	if q.Limit > 0 {
+		query += " LIMIT " + where.next(q.Limit)
+	}
+	if q.Offset > 0 {
+		query += " OFFSET " + where.next(q.Offset)
+	}
+	rows, err := p.stmts.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	subs := []models.Subscription{}
+	for rows.Next() {
+		var s models.Subscription
+		if err := scanSubscription(rows, &s); err != nil {
+			return nil, 0, err
+		}
+		subs = append(subs, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	// SQLite has a single connection, which rows holds until closed.
+	rows.Close()
+	return subs, total, loadTags(ctx, p.db, userID, subs)
+}
+
+// loadTags fills in the tags of subs, which all belong to userID.
+func loadTags(ctx context.Context, q querier, userID int, subs []models.Subscription) error {
+	if len(subs) == 0 {
+		return nil
+	}
+	byID := make(map[int]*models.Subscription, len(subs))
+	where := &whereClause{}
+	where.add("t.user_id = ?", userID)
+	ids := make([]string, len(subs))
+	for i := range subs {
+		subs[i].Tags = []string{}
+		byID[subs[i].ID] = &subs[i]
+		ids[i] = where.next(subs[i].ID)
+	}
+	where.add("st.subscription_id IN (" + strings.Join(ids, ", ") + ")")
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT st.subscription_id, t.name
+		FROM subscription_tags st JOIN tags t ON t.id = st.tag_id`+where.String()+`
+		ORDER BY t.name
+	`, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, name)
+	}
+	return rows.Err()
+}
+
+// setTags makes names, in stored form, the subscription's only tags,
+// creating any the user doesn't have yet.
+func setTags(ctx context.Context, q querier, userID, subscriptionID int, names []string) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM subscription_tags WHERE subscription_id = $1", subscriptionID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		// The no-op update makes RETURNING give the existing tag's ID.
+		var tagID int
+		if err := q.QueryRowContext(ctx, `
+			INSERT INTO tags (user_id, name) VALUES ($1, $2)
+			ON CONFLICT (user_id, name) DO UPDATE SET name = excluded.name
+			RETURNING id
+		`, userID, name).Scan(&tagID); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "INSERT INTO subscription_tags (subscription_id, tag_id) VALUES ($1, $2)", subscriptionID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *SQLSubscriptions) Get(ctx context.Context, userID, id int) (models.Subscription, error) {
+	var s models.Subscription
+	err := scanSubscription(p.stmts.QueryRowContext(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE id = $1 AND user_id = $2
+	`, id, userID), &s)
+	if err == sql.ErrNoRows {
+		return s, ErrNotFound
+	}
+	if err != nil {
+		return s, err
+	}
+	subs := []models.Subscription{s}
+	err = loadTags(ctx, p.db, userID, subs)
+	return subs[0], err
+}
+
+// Create and Update return s as given rather than reading it back, so
+// fields come back in the form the caller sent them.
+func (p *SQLSubscriptions) Create(ctx context.Context, userID int, s models.Subscription, verifiedAt time.Time, limit int64) (models.Subscription, error) {
+	err := InTx(ctx, p.db, func(tx *sql.Tx) error {
+		q := p.stmts.in(tx)
+		if err := checkLimit(ctx, q, userID, 1, limit); err != nil {
+			return err
+		}
+		var err error
+		s, err = insertSubscription(ctx, q, userID, s, verifiedAt)
+		return err
+	})
+	return s, err
+}
+
+func (p *SQLSubscriptions) CreateMany(ctx context.Context, userID int, subs []models.Subscription, verifiedAt time.Time, limit int64) ([]models.Subscription, error) {
+	created := make([]models.Subscription, 0, len(subs))
+	err := InTx(ctx, p.db, func(tx *sql.Tx) error {
+		q := p.stmts.in(tx)
+		if err := checkLimit(ctx, q, userID, int64(len(subs)), limit); err != nil {
+			return err
+		}
+		for i, s := range subs {
+			s, err := insertSubscription(ctx, q, userID, s, verifiedAt)
+			if err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			created = append(created, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// checkLimit returns ErrLimitReached if adding n subscriptions would take
+// the user past limit, unless limit is 0. It locks the user's row first,
+// so a concurrent create for the user waits until the transaction ends
+// and then counts what it added.
+func checkLimit(ctx context.Context, q querier, userID int, n, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx, "UPDATE users SET currency = currency WHERE id = $1", userID); err != nil {
+		return err
+	}
+	var count int64
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return err
+	}
+	if count+n > limit {
+		return ErrLimitReached
+	}
+	return nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertSubscription(ctx context.Context, q querier, userID int, s models.Subscription, verifiedAt time.Time) (models.Subscription, error) {
+	s = withDefaults(s)
+	var stored time.Time
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO subscriptions (user_id, name, category, cost_cents, currency, billing_cycle, next_billing, description, last_verified_at,
+			is_trial, trial_ends_at, status, cancelled_at, cancellation_reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $9, $9)
+		RETURNING id, last_verified_at, version
+	`, userID, s.Name, s.Category, s.Cost, s.Currency, s.BillingCycle, s.NextBilling, s.Description, verifiedAt,
+		s.IsTrial, s.TrialEndsAt, s.Status, s.CancelledAt, s.CancellationReason).Scan(&s.ID, &stored, &s.Version)
+	if err != nil {
+		return s, err
+	}
+	s.LastVerifiedAt = formatVerified(stored)
+	s.CreatedAt, s.UpdatedAt = formatTime(stored), formatTime(stored)
+	return s, setTags(ctx, q, userID, s.ID, s.Tags)
+}
+
+func (p *SQLSubscriptions) Update(ctx context.Context, userID int, s models.Subscription, verifiedAt time.Time) (models.Subscription, error) {
+	s = withDefaults(s)
+	var created time.Time
+	err := InTx(ctx, p.db, func(tx *sql.Tx) error {
+		q := p.stmts.in(tx)
+		err := q.QueryRowContext(ctx, `
+			UPDATE subscriptions
+			SET name = $1, category = $2, cost_cents = $3, billing_cycle = $4, next_billing = $5, description = $6,
+				last_verified_at = $8, updated_at = $8, currency = $10, is_trial = $11, trial_ends_at = $12, version = version + 1
+			WHERE id = $7 AND user_id = $9 AND version = $13
+			RETURNING version, created_at
+		`, s.Name, s.Category, s.Cost, s.BillingCycle, s.NextBilling, s.Description, s.ID, verifiedAt, userID, s.Currency, s.IsTrial, s.TrialEndsAt, s.Version).Scan(&s.Version, &created)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return setTags(ctx, q, userID, s.ID, s.Tags)
+	})
+	if err != nil {
+		return s, err
+	}
+	s.LastVerifiedAt = formatVerified(verifiedAt)
+	s.CreatedAt, s.UpdatedAt = formatTime(created), formatTime(verifiedAt)
+	return s, nil
+}
+
+func (p *SQLSubscriptions) Delete(ctx context.Context, userID, id int) error {
+	result, err := p.stmts.ExecContext(ctx, "DELETE FROM subscriptions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (p *SQLSubscriptions) Verify(ctx context.Context, userID, id int, at time.Time) error {
+	return verify(ctx, p.stmts, userID, id, at)
+}
+
+// VerifyTx is Verify within tx, for callers that verify a subscription as
+// part of a larger write.
+func VerifyTx(ctx context.Context, tx *sql.Tx, userID, id int, at time.Time) error {
+	return verify(ctx, tx, userID, id, at)
+}
+
+func verify(ctx context.Context, q querier, userID, id int, at time.Time) error {
+	result, err := q.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET last_verified_at = CASE WHEN last_verified_at IS NULL OR last_verified_at < $3 THEN $3 ELSE last_verified_at END,
+			version = version + 1
+		WHERE id = $1 AND user_id = $2
+	`, id, userID, at)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (p *SQLSubscriptions) Count(ctx context.Context, userID int) (int64, error) {
+	var n int64
+	err := p.stmts.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+func (p *SQLSubscriptions) Due(ctx context.Context, before models.Date) ([]DueSubscription, error) {
+	rows, err := p.stmts.QueryContext(ctx, `
+		SELECT user_id, `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE next_billing < $1 AND status = 'active' AND user_id IS NOT NULL
+		ORDER BY id
+	`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDue(rows)
+}
+
+func scanDue(rows *sql.Rows) ([]DueSubscription, error) {
+	var due []DueSubscription
+	for rows.Next() {
+		var d DueSubscription
+		if err := scanSubscription(rows, &d.Subscription, &d.UserID); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (p *SQLSubscriptions) Advance(ctx context.Context, userID, id int, from, to models.Date, billed []models.BillingEvent, at time.Time) error {
+	return InTx(ctx, p.db, func(tx *sql.Tx) error {
+		q := p.stmts.in(tx)
+		result, err := q.ExecContext(ctx, `
+			UPDATE subscriptions SET next_billing = $1, updated_at = $5, version = version + 1
+			WHERE id = $2 AND user_id = $3 AND next_billing = $4
+		`, to, id, userID, from, at)
+		if err != nil {
+			return err
+		}
+		if err := requireRow(result); err != nil {
+			return err
+		}
+		for _, e := range billed {
+			// A date already recorded, by an earlier run that advanced the
+			// subscription before the user moved it back, is kept as it was.
+			if _, err := q.ExecContext(ctx, `
+				INSERT INTO billing_history (subscription_id, user_id, billed_on, amount_cents, currency, record_currency, rate)
+				VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+				ON CONFLICT (subscription_id, billed_on) DO NOTHING
+			`, id, userID, e.Date, e.Amount, e.Currency, e.RecordCurrency, e.Rate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *SQLSubscriptions) History(ctx context.Context, userID, id int) ([]models.BillingEvent, error) {
+	if _, err := p.Get(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	rows, err := p.stmts.QueryContext(ctx, `
+		SELECT `+billingEventColumns+`
+		FROM billing_history
+		WHERE subscription_id = $1 AND user_id = $2
+		ORDER BY billed_on DESC
+	`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.BillingEvent{}
+	for rows.Next() {
+		var e models.BillingEvent
+		if err := scanBillingEvent(rows, &e); err != nil {
+			return nil, err
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+const billingEventColumns = `id, subscription_id, billed_on, amount_cents, currency, record_currency, rate, recorded_at`
+
+func scanBillingEvent(scanner rowScanner, e *models.BillingEvent) error {
+	var billed, recorded time.Time
+	var recordCurrency sql.NullString
+	var rate sql.NullFloat64
+	if err := scanner.Scan(&e.ID, &e.SubscriptionID, &billed, &e.Amount, &e.Currency, &recordCurrency, &rate, &recorded); err != nil {
+		return err
+	}
+	e.Date, e.RecordedAt = billed.Format(time.DateOnly), formatTime(recorded)
+	if recordCurrency.Valid && rate.Valid {
+		e.RecordCurrency, e.Rate = recordCurrency.String, &rate.Float64
+	}
+	return nil
+}
+
+func (p *SQLSubscriptions) TrialsEnded(ctx context.Context, through string) ([]DueSubscription, error) {
+	rows, err := p.stmts.QueryContext(ctx, `
+		SELECT user_id, `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE is_trial AND trial_ends_at <= $1 AND status = 'active' AND user_id IS NOT NULL
+		ORDER BY id
+	`, through)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDue(rows)
+}
+
+func (p *SQLSubscriptions) EndTrial(ctx context.Context, userID, id int, endsAt string, at time.Time) error {
+	result, err := p.stmts.ExecContext(ctx, `
+		UPDATE subscriptions SET is_trial = FALSE, updated_at = $4, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND is_trial AND trial_ends_at = $3
+	`, id, userID, endsAt, at)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (p *SQLSubscriptions) SetStatus(ctx context.Context, userID, id int, from, to string, cancelledAt, reason *string, at time.Time) error {
+	result, err := p.stmts.ExecContext(ctx, `
+		UPDATE subscriptions SET status = $1, cancelled_at = $2, cancellation_reason = $3, updated_at = $7, version = version + 1
+		WHERE id = $4 AND user_id = $5 AND status = $6
+	`, to, cancelledAt, reason, id, userID, from, at)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (p *SQLSubscriptions) SetArchived(ctx context.Context, userID, id int, archived bool, at time.Time) error {
+	var archivedAt *time.Time
+	if archived {
+		archivedAt = &at
+	}
+	result, err := p.stmts.ExecContext(ctx, `
+		UPDATE subscriptions SET archived_at = $1, updated_at = $2, version = version + 1
+		WHERE id = $3 AND user_id = $4
+	`, archivedAt, at, id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (p *SQLSubscriptions) Search(ctx context.Context, userID int, terms []string, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	if DriverOf(p.db) == Postgres {
+		// Terms are only letters and digits, so they can't break out of
+		// the tsquery syntax.
+		prefixes := make([]string, len(terms))
+		for i, t := range terms {
+			prefixes[i] = t + ":*"
+		}
+		rows, err = p.stmts.QueryContext(ctx, `
+			SELECT ts_rank(search_vector, query), `+subscriptionColumns+`
+			FROM subscriptions, to_tsquery('simple', $2) query
+			WHERE user_id = $1 AND search_vector @@ query
+			ORDER BY 1 DESC, name, id
+			LIMIT $3
+		`, userID, strings.Join(prefixes, " & "), limit)
+	} else {
+		where := &whereClause{}
+		where.add("user_id = ?", userID)
+		for _, t := range terms {
+			like := "%" + t + "%"
+			where.add("(LOWER(name) LIKE ? OR LOWER(category) LIKE ? OR LOWER(COALESCE(description, '')) LIKE ?)", like, like, like)
+		}
+		rows, err = p.db.QueryContext(ctx, "SELECT 0, "+subscriptionColumns+" FROM subscriptions"+where.String(), where.args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		if err := scanSubscription(rows, &r.Subscription, &r.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if DriverOf(p.db) != Postgres {
+		for i := range results {
+			results[i].Rank = matchRank(results[i].Subscription, terms)
+		}
+		results = sortSearchResults(results, limit)
+	}
+	subs := make([]models.Subscription, len(results))
+	for i := range results {
+		subs[i] = results[i].Subscription
+	}
+	if err := loadTags(ctx, p.db, userID, subs); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Subscription = subs[i]
+	}
+	return results, nil
+}
+
+// requireRow turns an update that touched nothing into ErrNotFound.
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *SQLSubscriptions) Tags(ctx context.Context, userID int) ([]models.Tag, error) {
+	rows, err := p.stmts.QueryContext(ctx, `
+		SELECT t.id, t.name, COUNT(st.subscription_id)
+		FROM tags t LEFT JOIN subscription_tags st ON st.tag_id = t.id
+		WHERE t.user_id = $1
+		GROUP BY t.id, t.name
+		ORDER BY t.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Subscriptions); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (p *SQLSubscriptions) CreateTag(ctx context.Context, userID int, name string) (models.Tag, error) {
+	t := models.Tag{Name: NormalizeTag(name)}
+	err := p.stmts.QueryRowContext(ctx, `
+		INSERT INTO tags (user_id, name) VALUES ($1, $2)
+		ON CONFLICT (user_id, name) DO NOTHING
+		RETURNING id
+	`, userID, t.Name).Scan(&t.ID)
+	if err == sql.ErrNoRows {
+		return t, ErrDuplicate
+	}
+	return t, err
+}
+
+func (p *SQLSubscriptions) RenameTag(ctx context.Context, userID, id int, name string) (models.Tag, error) {
+	t := models.Tag{ID: id, Name: NormalizeTag(name)}
+	err := InTx(ctx, p.db, func(tx *sql.Tx) error {
+		q := p.stmts.in(tx)
+		var taken bool
+		if err := q.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM tags WHERE user_id = $1 AND name = $2 AND id <> $3)
+		`, userID, t.Name, id).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicate
+		}
+		result, err := q.ExecContext(ctx, "UPDATE tags SET name = $1 WHERE id = $2 AND user_id = $3", t.Name, id, userID)
+		if err != nil {
+			return err
+		}
+		if err := requireRow(result); err != nil {
+			return err
+		}
+		return q.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscription_tags WHERE tag_id = $1", id).Scan(&t.Subscriptions)
+	})
+	return t, err
+}
+
+func (p *SQLSubscriptions) DeleteTag(ctx context.Context, userID, id int) error {
+	result, err := p.stmts.ExecContext(ctx, "DELETE FROM tags WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// InTx runs fn in a transaction on db, so writes spanning several tables
+// land together or not at all. The transaction commits if fn returns nil
+// and rolls back if it returns an error or panics. fn's error is returned
+// as is, so callers can still compare it to ErrNotFound and the like.
+//
+// fn must make all its queries through tx: on SQLite, which has a single
+// connection, a query on db itself would wait for the transaction to end.
+func InTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Once committed, rolling back is a no-op.
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,221 @@
+// Package stripe reads subscriptions and their invoices from the Stripe
+// API, for syncing tracked subscriptions billed through Stripe.
+package stripe
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// URL is the Stripe API.
+const URL = "https://api.stripe.com"
+
+var (
+	// ErrUnauthorized means Stripe rejected the API key, or the key may
+	// not read subscriptions.
+	ErrUnauthorized = errors.New("stripe rejected the API key")
+	// ErrNotFound means the Stripe account has no such subscription.
+	ErrNotFound = errors.New("no such stripe subscription")
+)
+
+// Client calls the Stripe API with one account's secret or restricted
+// key. URL defaults to the package's URL.
+type Client struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// Subscription is the part of a Stripe subscription a sync needs. Amounts
+// are in the currency's smallest unit, as Stripe gives them.
+type Subscription struct {
+	ID                string
+	Status            string
+	Currency          string
+	CancelAtPeriodEnd bool
+	// CurrentPeriodEnd is when the subscription next renews. Stripe API
+	// versions from 2025-03-31 give it per item; the first item's is used
+	// then.
+	CurrentPeriodEnd time.Time
+	Items            []Item
+	// LatestInvoice is nil for a subscription that hasn't been invoiced.
+	LatestInvoice *Invoice
+}
+
+// Item is one price on a subscription.
+type Item struct {
+	UnitAmount    int64
+	Quantity      int64
+	Interval      string // day, week, month or year
+	IntervalCount int
+}
+
+// Invoice is a subscription's invoice. BillingReason says why it was
+// made, such as "subscription_cycle" for a renewal or
+// "subscription_update" for a proration.
+type Invoice struct {
+	ID            string
+	Total         int64
+	Currency      string
+	Status        string
+	BillingReason string
+}
+
+// Amount is what one period of the subscription costs at its current
+// prices, before tax and discounts.
+func (s Subscription) Amount() int64 {
+	var total int64
+	for _, it := range s.Items {
+		total += it.UnitAmount * max(it.Quantity, 1)
+	}
+	return total
+}
+
+type apiSubscription struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	Currency          string `json:"currency"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"`
+	Items             struct {
+		Data []struct {
+			Quantity         int64 `json:"quantity"`
+			CurrentPeriodEnd int64 `json:"current_period_end"`
+			Price            struct {
+				UnitAmount int64 `json:"unit_amount"`
+				Recurring  *struct {
+					Interval      string `json:"interval"`
+					IntervalCount int    `json:"interval_count"`
+				} `json:"recurring"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+	// LatestInvoice is an ID unless expanded.
+	LatestInvoice json.RawMessage `json:"latest_invoice"`
+}
+
+// Subscription fetches one subscription with its latest invoice.
+func (c Client) Subscription(ctx context.Context, id string) (Subscription, error) {
+	var raw apiSubscription
+	if err := c.get(ctx, "/v1/subscriptions/"+url.PathEscape(id), url.Values{"expand[]": {"latest_invoice"}}, &raw); err != nil {
+		return Subscription{}, err
+	}
+
+	s := Subscription{
+		ID:                raw.ID,
+		Status:            raw.Status,
+		Currency:          strings.ToUpper(raw.Currency),
+		CancelAtPeriodEnd: raw.CancelAtPeriodEnd,
+	}
+	end := raw.CurrentPeriodEnd
+	for _, d := range raw.Items.Data {
+		it := Item{UnitAmount: d.Price.UnitAmount, Quantity: d.Quantity}
+		if r := d.Price.Recurring; r != nil {
+			it.Interval, it.IntervalCount = r.Interval, max(r.IntervalCount, 1)
+		}
+		s.Items = append(s.Items, it)
+		if end == 0 {
+			end = d.CurrentPeriodEnd
+		}
+	}
+	if end != 0 {
+		s.CurrentPeriodEnd = time.Unix(end, 0).UTC()
+	}
+
+	if len(raw.LatestInvoice) > 0 && raw.LatestInvoice[0] == '{' {
+		var inv struct {
+			ID            string `json:"id"`
+			Total         int64  `json:"total"`
+			Currency      string `json:"currency"`
+			Status        string `json:"status"`
+			BillingReason string `json:"billing_reason"`
+		}
+		if err := json.Unmarshal(raw.LatestInvoice, &inv); err != nil {
+			return Subscription{}, fmt.Errorf("decoding stripe invoice: %w", err)
+		}
+		s.LatestInvoice = &Invoice{ID: inv.ID, Total: inv.Total, Currency: strings.ToUpper(inv.Currency), Status: inv.Status, BillingReason: inv.BillingReason}
+	}
+	return s, nil
+}
+
+// Check makes sure the key works and may read subscriptions.
+func (c Client) Check(ctx context.Context) error {
+	return c.get(ctx, "/v1/subscriptions", url.Values{"limit": {"1"}}, nil)
+}
+
+func (c Client) get(ctx context.Context, path string, query url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(cmp.Or(c.URL, URL), "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Key)
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrUnauthorized
+		case http.StatusNotFound:
+			return ErrNotFound
+		}
+		if body.Error.Message != "" {
+			return fmt.Errorf("stripe returned %s: %s", resp.Status, body.Error.Message)
+		}
+		return fmt.Errorf("stripe returned %s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding stripe response: %w", err)
+	}
+	return nil
+}
+
+// zeroDecimal and threeDecimal are the currencies whose smallest unit
+// isn't a hundredth, as Stripe counts them.
+var (
+	zeroDecimal = map[string]bool{
+		"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+		"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+	}
+	threeDecimal = map[string]bool{"BHD": true, "JOD": true, "KWD": true, "OMR": true, "TND": true}
+)
+
+// Cents converts an amount in currency's smallest unit to hundredths,
+// rounding three-decimal currencies half away from zero.
+func Cents(amount int64, currency string) int64 {
+	currency = strings.ToUpper(currency)
+	switch {
+	case zeroDecimal[currency]:
+		return amount * 100
+	case threeDecimal[currency]:
+		if amount < 0 {
+			return (amount - 5) / 10
+		}
+		return (amount + 5) / 10
+	}
+	return amount
+}
@@ -0,0 +1,6 @@
+// Package subscriptionsv1 holds the gRPC SubscriptionService, generated
+// from subscriptions.proto. Edit the .proto and regenerate; api.App
+// implements the server.
+package subscriptionsv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative subscriptions.proto
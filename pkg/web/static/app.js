@@ -0,0 +1,396 @@
+// The dashboard: logs in, then shows the overview (#/) or the
+// subscription list (#/subscriptions), all through the REST API. Tokens
+// are kept in localStorage; an expired access token is refreshed once
+// before giving up and asking to log in again.
+"use strict";
+
+const $ = (selector) => document.querySelector(selector);
+
+const tokens = {
+  get() {
+    try {
+      return JSON.parse(localStorage.getItem("tokens")) || {};
+    } catch {
+      return {};
+    }
+  },
+  set(t) {
+    localStorage.setItem("tokens", JSON.stringify({ token: t.token, refreshToken: t.refreshToken }));
+  },
+  clear() {
+    localStorage.removeItem("tokens");
+  },
+};
+
+// ApiError is a problem response (RFC 9457) from the API.
+class ApiError extends Error {
+  constructor(status, problem) {
+    super(problem.detail || problem.title || `Request failed with status ${status}`);
+    this.status = status;
+    this.code = problem.code;
+    this.errors = problem.errors || [];
+    this.current = problem.current;
+  }
+}
+
+async function request(method, path, body, headers = {}) {
+  const init = { method, headers: { ...headers } };
+  const { token } = tokens.get();
+  if (token) init.headers.Authorization = `Bearer ${token}`;
+  if (body !== undefined) {
+    init.headers["Content-Type"] = "application/json";
+    init.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, init);
+  const text = await resp.text();
+  const data = text ? JSON.parse(text) : null;
+  return { resp, data };
+}
+
+let refreshing = null;
+
+// refresh exchanges the refresh token for new tokens, once however many
+// requests are waiting on it.
+function refresh() {
+  refreshing ??= (async () => {
+    const { refreshToken } = tokens.get();
+    if (!refreshToken) return false;
+    const { resp, data } = await request("POST", "/api/auth/refresh", { refreshToken });
+    if (!resp.ok) return false;
+    tokens.set(data);
+    return true;
+  })().finally(() => {
+    refreshing = null;
+  });
+  return refreshing;
+}
+
+// api makes an authenticated request and returns the parsed body and the
+// response, throwing an ApiError for anything but a 2xx.
+async function api(method, path, body, headers) {
+  let { resp, data } = await request(method, path, body, headers);
+  if (resp.status === 401 && (await refresh())) {
+    ({ resp, data } = await request(method, path, body, headers));
+  }
+  if (resp.status === 401) {
+    tokens.clear();
+    showLogin();
+  }
+  if (!resp.ok) throw new ApiError(resp.status, data || {});
+  return { data, resp };
+}
+
+function flash(message) {
+  const el = $("#flash");
+  el.textContent = message;
+  el.hidden = !message;
+}
+
+function money(amount, currency) {
+  try {
+    return new Intl.NumberFormat(undefined, { style: "currency", currency: currency || "USD" }).format(amount);
+  } catch {
+    return `${amount.toFixed(2)} ${currency}`;
+  }
+}
+
+function el(tag, props = {}, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props);
+  node.append(...children);
+  return node;
+}
+
+const svgNS = "http://www.w3.org/2000/svg";
+
+function svg(tag, attrs = {}, text) {
+  const node = document.createElementNS(svgNS, tag);
+  for (const [k, v] of Object.entries(attrs)) node.setAttribute(k, v);
+  if (text !== undefined) node.textContent = text;
+  return node;
+}
+
+// Logging in and signing up.
+
+let signingUp = false;
+
+function showLogin() {
+  $("#nav").hidden = true;
+  for (const view of document.querySelectorAll("main > section")) view.hidden = view.id !== "login-view";
+}
+
+function setSigningUp(on) {
+  signingUp = on;
+  $("#login-title").textContent = on ? "Sign up" : "Log in";
+  $("#login-form button[type=submit]").textContent = on ? "Create account" : "Log in";
+  $("#login-switch-text").textContent = on ? "Already have an account?" : "No account yet?";
+  $("#login-switch").textContent = on ? "Log in" : "Sign up";
+  $("#code-field").hidden = true;
+}
+
+async function submitLogin(event) {
+  event.preventDefault();
+  const form = event.target;
+  const creds = { email: form.email.value, password: form.password.value };
+  if (form.code.value) creds.code = form.code.value.trim();
+  const { resp, data } = await request("POST", signingUp ? "/api/auth/signup" : "/api/auth/login", creds);
+  if (!resp.ok) {
+    if (data && data.code === "two_factor_required") {
+      $("#code-field").hidden = false;
+      form.code.focus();
+    }
+    flash(new ApiError(resp.status, data || {}).message);
+    return;
+  }
+  flash("");
+  tokens.set(data);
+  form.reset();
+  setSigningUp(false);
+  start();
+}
+
+async function logout() {
+  const { refreshToken } = tokens.get();
+  tokens.clear();
+  if (refreshToken) await request("POST", "/api/auth/logout", { refreshToken });
+  showLogin();
+}
+
+// The overview: totals, charts and upcoming renewals.
+
+async function showOverview() {
+  const [{ data: stats }, { data: forecast }] = await Promise.all([
+    api("GET", "/api/stats?compareTo=previous_month"),
+    api("GET", "/api/forecast?months=12"),
+  ]);
+  const currency = stats.currency;
+  $("#total-monthly").textContent = money(stats.totalMonthly, currency);
+  const { percent } = stats.comparison.totalMonthly;
+  $("#monthly-change").textContent = percent === null ? "" : `${percent > 0 ? "+" : ""}${Math.round(percent)}% vs last month`;
+  $("#total-yearly").textContent = money(stats.totalYearly, currency);
+  $("#total-count").textContent = stats.byCategory.reduce((n, c) => n + c.count, 0);
+
+  barChart($("#category-chart"), stats.byCategory.map((c) => ({ label: c.category || "Uncategorized", value: c.monthly })), currency);
+  columnChart($("#forecast-chart"), forecast.months.map((m) => ({ label: monthLabel(m.month), value: m.total })), currency);
+
+  const upcoming = $("#upcoming");
+  upcoming.replaceChildren();
+  for (const s of stats.upcoming) {
+    upcoming.append(el("li", {}, `${s.name}: ${money(s.cost, s.currency)} on ${s.nextBilling}`));
+  }
+  if (!stats.upcoming.length) upcoming.append(el("li", { className: "muted" }, "Nothing in the next week."));
+}
+
+function monthLabel(month) {
+  const [year, m] = month.split("-").map(Number);
+  return new Date(year, m - 1, 1).toLocaleString(undefined, { month: "short" });
+}
+
+// barChart draws horizontal bars, one per item, longest first.
+function barChart(node, items, currency) {
+  node.replaceChildren();
+  if (!items.length) {
+    node.append(svg("text", { x: 0, y: 20 }, "No active subscriptions."));
+    return;
+  }
+  const width = node.clientWidth || 400;
+  const rowHeight = Math.min(28, 224 / items.length);
+  const labelWidth = 110;
+  const valueWidth = 80;
+  const max = Math.max(...items.map((i) => i.value), 1);
+  items.forEach((item, i) => {
+    const y = i * rowHeight;
+    const barWidth = Math.max(1, ((width - labelWidth - valueWidth) * item.value) / max);
+    node.append(svg("text", { x: 0, y: y + rowHeight * 0.65 }, item.label));
+    node.append(svg("rect", { class: "bar", x: labelWidth, y: y + 4, width: barWidth, height: rowHeight - 8, rx: 3 }));
+    node.append(svg("text", { class: "value", x: labelWidth + barWidth + 6, y: y + rowHeight * 0.65 }, money(item.value, currency)));
+  });
+}
+
+// columnChart draws a column per item, such as a month of the forecast.
+function columnChart(node, items, currency) {
+  node.replaceChildren();
+  const width = node.clientWidth || 400;
+  const height = node.clientHeight || 224;
+  const bottom = 20;
+  const max = Math.max(...items.map((i) => i.value), 1);
+  const slot = width / Math.max(items.length, 1);
+  items.forEach((item, i) => {
+    const h = ((height - bottom - 16) * item.value) / max;
+    const x = i * slot + slot * 0.15;
+    const rect = svg("rect", { class: "bar", x, y: height - bottom - h, width: slot * 0.7, height: Math.max(h, 0), rx: 3 });
+    rect.append(svg("title", {}, money(item.value, currency)));
+    node.append(rect);
+    node.append(svg("text", { x: x + slot * 0.35, y: height - 5, "text-anchor": "middle" }, item.label));
+  });
+}
+
+// The subscription list and editor.
+
+async function showSubscriptions() {
+  const { data: page } = await api("GET", "/api/subscriptions?limit=500&sort=name");
+  const body = $("#subscriptions");
+  body.replaceChildren();
+  const categories = new Set();
+  for (const s of page.items) {
+    if (s.category) categories.add(s.category);
+    const edit = el("button", { type: "button", className: "link", textContent: "Edit", onclick: () => openEditor(s.id) });
+    const del = el("button", { type: "button", className: "link danger", textContent: "Delete", onclick: () => remove(s) });
+    body.append(
+      el(
+        "tr",
+        {},
+        el("td", {}, s.name),
+        el("td", {}, s.category),
+        el("td", { className: "num" }, money(s.cost, s.currency)),
+        el("td", {}, s.billingCycle),
+        el("td", {}, s.nextBilling),
+        el("td", {}, s.status),
+        el("td", { className: "actions" }, edit, " ", del),
+      ),
+    );
+  }
+  $("#subscriptions-empty").hidden = page.items.length > 0;
+  $("#categories").replaceChildren(...[...categories].sort().map((c) => el("option", { value: c })));
+}
+
+// editing holds the subscription being edited and its version, so saving
+// fails rather than overwriting a change made elsewhere in the meantime.
+let editing = null;
+
+const editorFields = ["name", "category", "cost", "currency", "billingCycle", "nextBilling", "description"];
+
+function fillEditor(form, s) {
+  editing = { id: s.id, version: s.version };
+  for (const field of editorFields) {
+    form[field].value = s[field] ?? "";
+  }
+}
+
+async function openEditor(id) {
+  const form = $("#editor-form");
+  form.reset();
+  $("#editor-errors").replaceChildren();
+  editing = null;
+  if (id) {
+    const { data: s } = await api("GET", `/api/subscriptions/${id}`);
+    fillEditor(form, s);
+  }
+  $("#editor-title").textContent = id ? "Edit subscription" : "Add subscription";
+  $("#editor").showModal();
+}
+
+async function save(event) {
+  event.preventDefault();
+  const form = event.target;
+  const sub = {
+    name: form.name.value.trim(),
+    category: form.category.value.trim(),
+    cost: Number(form.cost.value),
+    billingCycle: form.billingCycle.value.trim(),
+    nextBilling: form.nextBilling.value,
+    description: form.description.value,
+  };
+  if (form.currency.value.trim()) sub.currency = form.currency.value.trim().toUpperCase();
+  try {
+    if (editing) {
+      await api("PATCH", `/api/subscriptions/${editing.id}`, { ...sub, version: editing.version });
+    } else {
+      await api("POST", "/api/subscriptions", sub);
+    }
+  } catch (err) {
+    const list = $("#editor-errors");
+    const messages = err.errors.length ? err.errors.map((e) => `${e.field} ${e.message}`) : [err.message];
+    if (err.code === "version_conflict") {
+      // Show what it is now, to redo the edit on top of.
+      fillEditor(form, err.current);
+      messages.splice(0, messages.length, "It was changed elsewhere; this is the latest. Make your change again and save.");
+    }
+    list.replaceChildren(...messages.map((m) => el("li", {}, m)));
+    return;
+  }
+  $("#editor").close();
+  showSubscriptions().catch(report);
+}
+
+// While adding, the catalog suggests well-known services as the name is
+// typed; picking one fills in whatever is still empty from its first plan.
+let suggestions = new Map();
+
+async function suggestServices(event) {
+  if (editing) return;
+  const name = event.target.value.trim();
+  const chosen = suggestions.get(name);
+  if (chosen) {
+    prefill(chosen);
+    return;
+  }
+  if (!name) return;
+  const { data: services } = await api("GET", `/api/catalog?q=${encodeURIComponent(name)}&limit=8`);
+  suggestions = new Map(services.map((s) => [s.name, s]));
+  $("#services").replaceChildren(...services.map((s) => el("option", { value: s.name })));
+}
+
+function prefill(service) {
+  const form = $("#editor-form");
+  const [plan] = service.plans;
+  if (!form.category.value) form.category.value = service.category;
+  if (!plan) return;
+  if (!form.cost.value) {
+    form.cost.value = plan.cost;
+    if (!form.currency.value) form.currency.value = plan.currency;
+  }
+  if (!form.billingCycle.value) form.billingCycle.value = plan.billingCycle;
+}
+
+async function remove(s) {
+  if (!confirm(`Delete ${s.name}?`)) return;
+  await api("DELETE", `/api/subscriptions/${s.id}`).catch(report);
+  showSubscriptions().catch(report);
+}
+
+// Routing.
+
+function report(err) {
+  if (err.status !== 401) flash(err.message);
+}
+
+function route() {
+  if (!tokens.get().token) {
+    showLogin();
+    return;
+  }
+  flash("");
+  const view = location.hash === "#/subscriptions" ? "subscriptions" : "overview";
+  $("#nav").hidden = false;
+  for (const section of document.querySelectorAll("main > section")) section.hidden = section.id !== `${view}-view`;
+  for (const link of document.querySelectorAll("nav a")) link.classList.toggle("active", link.hash === (view === "overview" ? "#/" : "#/subscriptions"));
+  (view === "overview" ? showOverview() : showSubscriptions()).catch(report);
+}
+
+async function start() {
+  if (!tokens.get().token) {
+    showLogin();
+    return;
+  }
+  try {
+    const { data: me } = await api("GET", "/api/me");
+    $("#whoami").textContent = me.email;
+  } catch (err) {
+    report(err);
+    return;
+  }
+  route();
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("#login-form").addEventListener("submit", submitLogin);
+  $("#login-switch").addEventListener("click", () => setSigningUp(!signingUp));
+  $("#logout").addEventListener("click", logout);
+  $("#add").addEventListener("click", () => openEditor(null).catch(report));
+  $("#editor-form").addEventListener("submit", save);
+  $("#editor-form").name.addEventListener("input", (event) => suggestServices(event).catch(report));
+  $("#editor-cancel").addEventListener("click", () => $("#editor").close());
+  window.addEventListener("hashchange", route);
+  start();
+});
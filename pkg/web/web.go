@@ -0,0 +1,32 @@
+// Package web is the dashboard the server serves at /: one page that
+// lists, charts and edits the signed-in user's subscriptions through the
+// REST API. It's plain HTML, CSS and JavaScript embedded in the binary,
+// with no build step, so the server is usable without deploying a
+// frontend of its own.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var files embed.FS
+
+// Handler serves the dashboard's files. The page switches views with the
+// URL fragment, so every other path is a 404 rather than index.html, and
+// mistyped API paths still get one.
+func Handler() http.Handler {
+	static, err := fs.Sub(files, "static")
+	if err != nil {
+		panic(err)
+	}
+	server := http.FileServerFS(static)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Embedded files have no modification time to revalidate with,
+		// and a new binary can change them.
+		w.Header().Set("Cache-Control", "no-cache")
+		server.ServeHTTP(w, r)
+	})
+}
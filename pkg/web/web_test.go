@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	for _, tc := range []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"/", http.StatusOK, "text/html", `<script src="/app.js"`},
+		{"/app.js", http.StatusOK, "text/javascript", "/api/"},
+		{"/style.css", http.StatusOK, "text/css", ""},
+		// Views are URL fragments, so other paths aren't the page.
+		{"/subscriptions", http.StatusNotFound, "", ""},
+		{"/api/subscriptionz", http.StatusNotFound, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("GET %s: status %d, want %d", tc.path, w.Code, tc.status)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("GET %s: Content-Type %q, want %q", tc.path, ct, tc.contentType)
+		}
+		if !strings.Contains(w.Body.String(), tc.contains) {
+			t.Errorf("GET %s: body doesn't contain %q", tc.path, tc.contains)
+		}
+		if cc := w.Header().Get("Cache-Control"); tc.status == http.StatusOK && cc != "no-cache" {
+			t.Errorf("GET %s: Cache-Control %q, want no-cache", tc.path, cc)
+		}
+	}
+}
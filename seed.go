@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/seed"
+	"subscription-tracker/pkg/store"
+)
+
+// seedOptions are the seed command's flags.
+type seedOptions struct {
+	email    string
+	password string
+	count    int
+	random   uint64
+}
+
+func (o *seedOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.email, "email", "demo@example.com", "email of the demo account to create")
+	fs.StringVar(&o.password, "password", "demo-password", "password of the demo account")
+	fs.IntVar(&o.count, "count", 0, "make up this many random subscriptions instead of the demo set")
+	fs.Uint64Var(&o.random, "random-seed", 1, "seed for --count; the same seed makes the same subscriptions")
+}
+
+// runSeed implements the seed command: it creates a demo account with the
+// seed package's demo set, or --count random subscriptions, and budgets,
+// to try the dashboard, develop or load test against. It refuses to touch
+// an account that already exists.
+func runSeed(ctx context.Context, db *sql.DB, opts seedOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: seed [--email EMAIL] [--password PASSWORD] [--count N [--random-seed SEED]]")
+	}
+	if opts.count < 0 {
+		return errors.New("--count must not be negative")
+	}
+	if err := store.CheckSchema(db); err != nil {
+		return err
+	}
+	email := strings.ToLower(strings.TrimSpace(opts.email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		return fmt.Errorf("invalid email %q", opts.email)
+	}
+	if len(opts.password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(opts.password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// The account goes in the default tenant, which has ID 1.
+	const tenant = 1
+	var userID int
+	err = db.QueryRowContext(store.WithTenant(ctx, tenant), `
+		INSERT INTO users (email, password_hash) VALUES ($1, $2)
+		ON CONFLICT (tenant_id, email) DO NOTHING
+		RETURNING id
+	`, email, string(hash)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("an account with email %s already exists; pass --email to seed another", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	subs := seed.Demo(models.DateOf(now))
+	if opts.count > 0 {
+		subs = seed.Random(rand.New(rand.NewPCG(opts.random, 0)), opts.count, models.DateOf(now))
+	}
+	ctx = store.WithUser(ctx, tenant, userID)
+	if err := seedAccount(ctx, db, userID, subs, now); err != nil {
+		// Leave no half-seeded account behind; its rows cascade.
+		db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+		return err
+	}
+	fmt.Printf("created %s (password %q) with %d subscriptions and %d budgets\n", email, opts.password, len(subs), len(seed.Budgets))
+	return nil
+}
+
+func seedAccount(ctx context.Context, db *sql.DB, userID int, subs []models.Subscription, now time.Time) error {
+	if _, err := store.NewSQLSubscriptions(db).CreateMany(ctx, userID, subs, now, 0); err != nil {
+		return err
+	}
+	for _, b := range seed.Budgets {
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO budgets (user_id, category, amount_cents, currency, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, userID, b.Category, b.Amount, models.DefaultCurrency, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"subscription-tracker/pkg/models"
+	"subscription-tracker/pkg/seed"
+	"subscription-tracker/pkg/store"
+)
+
+func TestSeed(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	opts := seedOptions{email: "Demo@Example.com", password: "demo-password", random: 1}
+
+	if err := runSeed(ctx, db, opts, nil); err == nil || !strings.Contains(err.Error(), "run migrate up") {
+		t.Errorf("seeding an unmigrated database: err = %v", err)
+	}
+	if err := store.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSeed(ctx, db, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	var subs, budgets int
+	if err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM subscriptions s JOIN users u ON u.id = s.user_id WHERE u.email = $1),
+			(SELECT COUNT(*) FROM budgets b JOIN users u ON u.id = b.user_id WHERE u.email = $1)
+	`, "demo@example.com").Scan(&subs, &budgets); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(seed.Demo(models.DateOf(time.Now()))); subs != want || budgets != len(seed.Budgets) {
+		t.Errorf("seeded %d subscriptions and %d budgets, want %d and %d", subs, budgets, want, len(seed.Budgets))
+	}
+
+	for _, tc := range []struct {
+		opts seedOptions
+		args []string
+		want string
+	}{
+		{opts, nil, "already exists"},
+		{opts, []string{"extra"}, "usage"},
+		{seedOptions{email: "not an email", password: "demo-password"}, nil, "invalid email"},
+		{seedOptions{email: "short@example.com", password: "short"}, nil, "at least 8 characters"},
+		{seedOptions{email: "negative@example.com", password: "demo-password", count: -1}, nil, "must not be negative"},
+	} {
+		if err := runSeed(ctx, db, tc.opts, tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("seed %+v %q: err = %v, want %q", tc.opts, tc.args, err, tc.want)
+		}
+	}
+}
+
+func TestSeedCount(t *testing.T) {
+	db := openTestDB(t)
+	if err := store.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, email := range []string{"load1@example.com", "load2@example.com"} {
+		if err := runSeed(ctx, db, seedOptions{email: email, password: "demo-password", count: 500, random: 7}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same seed makes the same subscriptions for each account.
+	var subs, names int
+	if err := db.QueryRow("SELECT COUNT(*), COUNT(DISTINCT name) FROM subscriptions").Scan(&subs, &names); err != nil {
+		t.Fatal(err)
+	}
+	if subs != 1000 || names != 500 {
+		t.Errorf("seeded %d subscriptions with %d names, want 1000 with 500", subs, names)
+	}
+}
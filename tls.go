@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certManager gets and renews certificates for TLSDomains from Let's
+// Encrypt, or is nil when there are none. Certificates obtained are kept in
+// TLSCacheDir so a restart doesn't ask for them again.
+func (c serverConfig) certManager() *autocert.Manager {
+	if len(c.TLSDomains) == 0 {
+		return nil
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.TLSDomains...),
+		Cache:      autocert.DirCache(c.TLSCacheDir),
+		Email:      c.ACMEEmail,
+	}
+}
+
+// redirectHandler sends every request to the same URL over HTTPS on port.
+// Only GET and HEAD keep their method through a 301, so other requests get
+// a 308.
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// These tests cover how the binary is configured to serve HTTPS. Serving
+// it needs a certificate or Let's Encrypt, so they stop short of that.
+
+func loadTestConfig(t *testing.T, args ...string) (serverConfig, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return loadServerConfig(fs, args)
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg, err := loadTestConfig(t, "-tls-domains", "example.com, www.example.com,", "-redirect-port", "8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.tlsEnabled() || !slices.Equal(cfg.TLSDomains, []string{"example.com", "www.example.com"}) {
+		t.Errorf("TLS domains = %q, enabled %v", cfg.TLSDomains, cfg.tlsEnabled())
+	}
+	if certs := cfg.certManager(); certs == nil || certs.HostPolicy(t.Context(), "example.com") != nil || certs.HostPolicy(t.Context(), "evil.example") == nil {
+		t.Errorf("certificate manager doesn't keep to the configured domains")
+	}
+
+	cfg, err = loadTestConfig(t, "-tls-cert", "cert.pem", "-tls-key", "key.pem")
+	if err != nil || !cfg.tlsEnabled() || cfg.certManager() != nil {
+		t.Errorf("certificate files: err %v, enabled %v", err, cfg.tlsEnabled())
+	}
+	if cfg, err := loadTestConfig(t); err != nil || cfg.tlsEnabled() {
+		t.Errorf("no TLS settings: err %v, enabled %v", err, cfg.tlsEnabled())
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-tls-cert", "cert.pem"}, "both a certificate and a key"},
+		{[]string{"-tls-key", "key.pem"}, "both a certificate and a key"},
+		{[]string{"-tls-cert", "cert.pem", "-tls-key", "key.pem", "-tls-domains", "example.com"}, "can't both be set"},
+		{[]string{"-redirect-port", "8081"}, "needs TLS"},
+		{[]string{"-tls-domains", "example.com", "-redirect-port", "70000"}, "out of range"},
+		{[]string{"-tls-domains", "example.com", "-redirect-port", "8080"}, "already in use"},
+	} {
+		if _, err := loadTestConfig(t, tc.args...); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: err = %v, want %q", tc.args, err, tc.want)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		method, url string
+		port        int
+		status      int
+		location    string
+	}{
+		{"GET", "http://example.com/api/subscriptions?page=2", 443, http.StatusMovedPermanently, "https://example.com/api/subscriptions?page=2"},
+		{"HEAD", "http://example.com:8081/", 443, http.StatusMovedPermanently, "https://example.com/"},
+		{"GET", "http://example.com:8081/healthz", 8443, http.StatusMovedPermanently, "https://example.com:8443/healthz"},
+		// Other methods keep their method and body through the redirect.
+		{"POST", "http://example.com/api/subscriptions", 443, http.StatusPermanentRedirect, "https://example.com/api/subscriptions"},
+	} {
+		w := httptest.NewRecorder()
+		redirectHandler(tc.port).ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+		if w.Code != tc.status || w.Header().Get("Location") != tc.location {
+			t.Errorf("%s %s to port %d: %d %q, want %d %q", tc.method, tc.url, tc.port, w.Code, w.Header().Get("Location"), tc.status, tc.location)
+		}
+	}
+}
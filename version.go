@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"subscription-tracker/pkg/api"
+	"subscription-tracker/pkg/store"
+)
+
+// Build metadata, injected at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.gitSHA=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// make build and make release set all three. A plain go build or go
+// install leaves them, and buildInfo falls back on the commit and time the
+// Go toolchain stamped into the binary.
+var (
+	version   = "dev"
+	gitSHA    = "unknown"
+	buildTime = "unknown"
+)
+
+// buildInfo is the running binary's build metadata.
+func buildInfo() api.BuildInfo {
+	b := api.BuildInfo{Version: version, GitSHA: gitSHA, BuildTime: buildTime}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && b.GitSHA == "unknown":
+			b.GitSHA = s.Value
+		case s.Key == "vcs.time" && b.BuildTime == "unknown":
+			b.BuildTime = s.Value
+		}
+	}
+	return b
+}
+
+// printVersion implements the version command, which reports what
+// /api/version does about the binary without needing a database.
+func printVersion(w io.Writer) {
+	b := buildInfo()
+	fmt.Fprintf(w, "subscription-tracker %s\n", b.Version)
+	fmt.Fprintf(w, "commit:   %s\n", b.GitSHA)
+	fmt.Fprintf(w, "built:    %s\n", b.BuildTime)
+	fmt.Fprintf(w, "go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "schema:   %d\n", store.SchemaVersion)
+}
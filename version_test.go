@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"subscription-tracker/pkg/api"
+	"subscription-tracker/pkg/store"
+)
+
+func TestBuildInfo(t *testing.T) {
+	// Without ldflags, the toolchain's stamp fills in what it can.
+	b := buildInfo()
+	if b.Version == "" || b.GitSHA == "" || b.BuildTime == "" {
+		t.Errorf("build info without ldflags = %+v", b)
+	}
+
+	// Values from ldflags win over the stamp.
+	defer func(v, sha, at string) { version, gitSHA, buildTime = v, sha, at }(version, gitSHA, buildTime)
+	version, gitSHA, buildTime = "v1.2.0", "abc123", "2025-05-01T12:00:00Z"
+	want := api.BuildInfo{Version: "v1.2.0", GitSHA: "abc123", BuildTime: "2025-05-01T12:00:00Z"}
+	if b := buildInfo(); b != want {
+		t.Errorf("build info = %+v, want %+v", b, want)
+	}
+
+	var out bytes.Buffer
+	printVersion(&out)
+	for _, line := range []string{
+		"subscription-tracker v1.2.0",
+		"commit:   abc123",
+		"built:    2025-05-01T12:00:00Z",
+		fmt.Sprintf("go:       %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("schema:   %d", store.SchemaVersion),
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("version output %q is missing %q", out.String(), line)
+		}
+	}
+}